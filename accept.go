@@ -0,0 +1,151 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// AcceptLoop accepts connections on lstn and calls handle for each of them,
+// until the listener is closed. See AcceptLoopContext for details.
+func AcceptLoop(lstn net.Listener, handle func(net.Conn)) error {
+	return AcceptLoopContext(context.Background(), lstn, handle)
+}
+
+// AcceptLoopContext accepts connections on lstn and calls handle for each of
+// them, until the listener is closed or ctx is canceled.
+//
+// The handle function is called on the goroutine running the loop, it is
+// expected to start a new goroutine to serve the connection.
+//
+// Temporary errors are retried with an exponential backoff capped to one
+// second, which prevents from spinning on errors that persist for a while.
+// When the process runs out of file descriptors the loop pauses for the
+// maximum backoff right away, giving the program a chance to close some.
+//
+// Canceling ctx interrupts a pending Accept call: listeners that support
+// deadlines (like *net.TCPListener) get their deadline moved to the current
+// time and cleared when the loop exits, others are closed.
+//
+// The function returns nil when the listener was closed or ctx was canceled,
+// otherwise the error that stopped the loop.
+func AcceptLoopContext(ctx context.Context, lstn net.Listener, handle func(net.Conn)) error {
+	if err := acceptLoop(ctx, lstn, handle, nil); !isClosedListener(err) {
+		return err
+	}
+	return nil
+}
+
+// acceptLoop implements AcceptLoopContext, it returns the errors of closed
+// listeners as well so callers decide how to report them.
+//
+// The logf function, if not nil, is called to report the temporary errors
+// before retrying.
+func acceptLoop(ctx context.Context, lstn net.Listener, handle func(net.Conn), logf func(err error, backoff time.Duration)) error {
+	const maxBackoff = 1 * time.Second
+
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		exit := make(chan struct{})
+		deadline := false
+
+		go func() {
+			defer close(exit)
+			select {
+			case <-done:
+				deadline = interruptAccept(lstn)
+			case <-stop:
+			}
+		}()
+
+		defer func() {
+			close(stop)
+			<-exit
+			if deadline {
+				lstn.(deadlineListener).SetDeadline(time.Time{})
+			}
+		}()
+	}
+
+	for attempt := 0; ; {
+		conn, err := lstn.Accept()
+
+		if err == nil {
+			attempt = 0
+			handle(conn)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			// Don't report errors when the loop stopped because its context
+			// was canceled.
+			return nil
+		default:
+		}
+
+		if !IsTemporary(err) && !isTooManyOpenFiles(err) {
+			return err
+		}
+
+		// Backoff strategy for handling temporary errors, this prevents from
+		// retrying too fast when errors like running out of file descriptors
+		// occur.
+		attempt++
+		backoff := time.Duration(attempt*attempt) * 10 * time.Millisecond
+		if backoff > maxBackoff || isTooManyOpenFiles(err) {
+			backoff = maxBackoff
+		}
+
+		if logf != nil {
+			logf(err, backoff)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// deadlineListener is implemented by listeners which support deadlines on
+// Accept, like *net.TCPListener and *net.UnixListener.
+type deadlineListener interface {
+	SetDeadline(time.Time) error
+}
+
+// interruptAccept unblocks the goroutines waiting in the Accept method of
+// lstn, it returns true if it did so by setting a deadline, false if the
+// listener had to be closed.
+func interruptAccept(lstn net.Listener) bool {
+	if l, ok := lstn.(deadlineListener); ok && l.SetDeadline(time.Now()) == nil {
+		return true
+	}
+	lstn.Close()
+	return false
+}
+
+// isClosedListener returns true if err was returned by the Accept method of a
+// closed listener.
+func isClosedListener(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// isTooManyOpenFiles returns true if err reports that the process or system
+// ran out of file descriptors.
+func isTooManyOpenFiles(err error) bool {
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	return err == syscall.EMFILE || err == syscall.ENFILE
+}
@@ -0,0 +1,178 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// errorListener is a listener which returns a sequence of errors before
+// returning the connections of its base listener.
+type errorListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *errorListener) Accept() (net.Conn, error) {
+	if len(l.errs) != 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptLoop(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make(chan net.Conn, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- AcceptLoop(&errorListener{
+			Listener: lstn,
+			errs:     []error{Timeout("temporary"), Timeout("temporary")},
+		}, func(conn net.Conn) { conns <- conn })
+	}()
+
+	conn, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case c := <-conns:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("no connection accepted after temporary errors")
+	}
+
+	lstn.Close()
+
+	if err := <-errs; err != nil {
+		t.Error("bad error returned after the listener was closed:", err)
+	}
+}
+
+func TestAcceptLoopError(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	fatal := errors.New("fatal")
+
+	if err := AcceptLoop(&errorListener{
+		Listener: lstn,
+		errs:     []error{fatal},
+	}, func(conn net.Conn) { conn.Close() }); err != fatal {
+		t.Error("bad error returned:", err)
+	}
+}
+
+func TestAcceptLoopContext(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	// Running out of file descriptors pauses the loop, canceling the context
+	// must interrupt the pause.
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if err := AcceptLoopContext(ctx, &errorListener{
+		Listener: lstn,
+		errs:     []error{emfile},
+	}, func(conn net.Conn) { conn.Close() }); err != nil {
+		t.Error("bad error returned:", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Error("the loop did not stop when the context was canceled:", elapsed)
+	}
+}
+
+func TestAcceptLoopContextIdle(t *testing.T) {
+	tests := []struct {
+		name   string
+		lstn   func(net.Listener) net.Listener
+		closed bool
+	}{
+		{
+			name: "deadline",
+			lstn: func(l net.Listener) net.Listener { return l },
+		},
+		{
+			name:   "close",
+			lstn:   func(l net.Listener) net.Listener { return &errorListener{Listener: l} },
+			closed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lstn, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lstn.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error, 1)
+
+			go func() {
+				errs <- AcceptLoopContext(ctx, test.lstn(lstn), func(conn net.Conn) { conn.Close() })
+			}()
+
+			// Give the loop a chance to block in Accept.
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+
+			select {
+			case err := <-errs:
+				if err != nil {
+					t.Error("bad error returned:", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the loop did not stop when the context was canceled")
+			}
+
+			// Listeners supporting deadlines must remain usable after the
+			// loop exited, the others are closed.
+			go func() {
+				if conn, err := net.Dial("tcp", lstn.Addr().String()); err == nil {
+					conn.Close()
+				}
+			}()
+
+			conn, err := lstn.Accept()
+			if test.closed {
+				if err == nil {
+					conn.Close()
+					t.Error("the listener was not closed")
+				}
+			} else {
+				if err != nil {
+					t.Error("the listener is not usable anymore:", err)
+				} else {
+					conn.Close()
+				}
+			}
+		})
+	}
+}
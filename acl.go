@@ -0,0 +1,246 @@
+package netx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ACL is an access control list deciding which client addresses a program
+// accepts connections or requests from, based on their networks, or on their
+// country and autonomous system when it has an IP metadata database.
+//
+// Clients are rejected if they match one of the deny rules. Otherwise, if the
+// ACL has allow rules, clients are accepted if they match one of them, and
+// accepted unconditionally if it has none.
+//
+// A nil ACL allows all addresses.
+type ACL struct {
+	// Allow is the list of networks that clients are accepted from.
+	Allow []*net.IPNet
+
+	// Deny is the list of networks that clients are rejected from.
+	Deny []*net.IPNet
+
+	// AllowCountries is the list of countries that clients are accepted from,
+	// as ISO 3166-1 alpha-2 codes like "US" or "FR".
+	AllowCountries []string
+
+	// DenyCountries is the list of countries that clients are rejected from.
+	DenyCountries []string
+
+	// DenyASNs is the list of autonomous systems that clients are rejected
+	// from, like the ones of hosting providers.
+	DenyASNs []uint32
+
+	// Lookup provides the country and autonomous system of clients, the rules
+	// based on them never match when it is nil or when the lookup fails. The
+	// clients of unknown origin are rejected if AllowCountries is not empty.
+	Lookup IPInfoLookup
+}
+
+// ErrDenied is the error returned when reading from or writing to connections
+// that were rejected by an ACLListener after they were accepted.
+var ErrDenied = errors.New("the client address is denied by the access control list")
+
+// Allowed checks whether the ACL allows clients with the given IP address.
+func (acl *ACL) Allowed(ip net.IP) bool {
+	if acl == nil {
+		return true
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4 // IPv4-mapped IPv6 addresses are checked as IPv4
+	}
+
+	if containsIP(acl.Deny, ip) {
+		return false
+	}
+
+	var info IPInfo
+
+	if acl.Lookup != nil && (len(acl.AllowCountries) != 0 || len(acl.DenyCountries) != 0 || len(acl.DenyASNs) != 0) {
+		info, _ = acl.Lookup.LookupIPInfo(ip)
+	}
+
+	if len(info.Country) != 0 && containsCountry(acl.DenyCountries, info.Country) {
+		return false
+	}
+
+	for _, asn := range acl.DenyASNs {
+		if info.ASN != 0 && info.ASN == asn {
+			return false
+		}
+	}
+
+	if len(acl.Allow) == 0 && len(acl.AllowCountries) == 0 {
+		return true
+	}
+
+	return containsIP(acl.Allow, ip) || (len(info.Country) != 0 && containsCountry(acl.AllowCountries, info.Country))
+}
+
+// ParseCIDRs parses a list of networks in the CIDR notation, like
+// "192.0.2.0/24" or "2001:db8::/32". IP addresses without a prefix length are
+// parsed as networks containing only this address.
+func ParseCIDRs(list ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", s)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// ACLListener is a net.Listener which rejects the connections from clients that
+// the ACL doesn't allow, they are closed right away. Connections which don't
+// have an IP address (unix sockets for example) are accepted.
+//
+// When the listener wraps a ProxyProtoListener, the ACL is applied to the
+// client address sent in the proxy protocol header. The header is read when
+// the connection is first used rather than in Accept, so a slow client doesn't
+// block Accept, and the connection is closed then if the client is rejected:
+// Read and Write return ErrDenied.
+type ACLListener struct {
+	net.Listener
+
+	// ACL decides which clients the listener accepts connections from.
+	ACL *ACL
+
+	// Reset configures the listener to reset the connections it rejects,
+	// instead of closing them gracefully.
+	Reset bool
+
+	rejected int64
+}
+
+// Rejected returns the number of connections that were rejected by the ACL.
+func (l *ACLListener) Rejected() int64 {
+	return atomic.LoadInt64(&l.rejected)
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *ACLListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if hasLazyRemoteAddr(conn) {
+			return &aclConn{Conn: conn, listener: l}, nil
+		}
+
+		if l.allowed(conn.RemoteAddr()) {
+			return conn, nil
+		}
+
+		l.reject(conn)
+	}
+}
+
+func (l *ACLListener) allowed(addr net.Addr) bool {
+	ip := addrIP(addr)
+	return ip == nil || l.ACL.Allowed(ip)
+}
+
+func (l *ACLListener) reject(conn net.Conn) {
+	atomic.AddInt64(&l.rejected, 1)
+	if l.Reset {
+		resetConn(conn)
+	} else {
+		conn.Close()
+	}
+}
+
+// aclConn is the connection type returned by ACLListener for connections that
+// are checked when they are first used.
+type aclConn struct {
+	net.Conn
+	listener *ACLListener
+	once     sync.Once
+	err      error
+}
+
+func (c *aclConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *aclConn) Read(b []byte) (int, error) {
+	if c.once.Do(c.check); c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *aclConn) Write(b []byte) (int, error) {
+	if c.once.Do(c.check); c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *aclConn) check() {
+	if !c.listener.allowed(c.Conn.RemoteAddr()) {
+		c.err = &net.OpError{Op: "accept", Net: c.LocalAddr().Network(), Source: c.LocalAddr(), Addr: c.Conn.RemoteAddr(), Err: ErrDenied}
+		c.listener.reject(c.Conn)
+	}
+}
+
+func containsCountry(countries []string, country string) bool {
+	for _, c := range countries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLazyRemoteAddr checks whether the remote address of conn is read from a
+// proxy protocol header which was not received yet.
+func hasLazyRemoteAddr(conn net.Conn) bool {
+	for {
+		if _, ok := conn.(*proxyProtoListenerConn); ok {
+			return true
+		}
+		b, ok := conn.(baseConn)
+		if !ok {
+			return false
+		}
+		conn = b.BaseConn()
+	}
+}
+
+// addrIP returns the IP address of addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
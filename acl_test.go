@@ -0,0 +1,206 @@
+package netx
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestACLAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		acl     *ACL
+		ip      string
+		allowed bool
+	}{
+		{"nil", nil, "10.0.0.1", true},
+		{"empty", &ACL{}, "10.0.0.1", true},
+		{"allowed", &ACL{Allow: mustParseCIDRs(t, "10.0.0.0/8")}, "10.1.2.3", true},
+		{"not allowed", &ACL{Allow: mustParseCIDRs(t, "10.0.0.0/8")}, "192.168.0.1", false},
+		{"denied", &ACL{Deny: mustParseCIDRs(t, "192.0.2.1")}, "192.0.2.1", false},
+		{"not denied", &ACL{Deny: mustParseCIDRs(t, "192.0.2.1")}, "192.0.2.2", true},
+		{"deny takes precedence", &ACL{Allow: mustParseCIDRs(t, "10.0.0.0/8"), Deny: mustParseCIDRs(t, "10.0.0.0/24")}, "10.0.0.1", false},
+		{"ipv4-mapped", &ACL{Allow: mustParseCIDRs(t, "10.0.0.0/8")}, "::ffff:10.0.0.1", true},
+		{"ipv6", &ACL{Allow: mustParseCIDRs(t, "2001:db8::/32")}, "2001:db8::1", true},
+		{"ipv6 host", &ACL{Deny: mustParseCIDRs(t, "2001:db8::1")}, "2001:db8::1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := test.acl.Allowed(net.ParseIP(test.ip)); allowed != test.allowed {
+				t.Errorf("%s: allowed=%t", test.ip, allowed)
+			}
+		})
+	}
+}
+
+func TestACLAllowedGeo(t *testing.T) {
+	lookup := IPInfoLookupFunc(func(ip net.IP) (IPInfo, error) {
+		switch ip.String() {
+		case "192.0.2.1":
+			return IPInfo{Country: "FR", ASN: 64496}, nil
+		case "192.0.2.2":
+			return IPInfo{Country: "US", ASN: 64497}, nil
+		case "192.0.2.3":
+			return IPInfo{}, errors.New("lookup failed")
+		}
+		return IPInfo{}, nil
+	})
+
+	tests := []struct {
+		name    string
+		acl     *ACL
+		ip      string
+		allowed bool
+	}{
+		{"allowed country", &ACL{AllowCountries: []string{"fr"}, Lookup: lookup}, "192.0.2.1", true},
+		{"not allowed country", &ACL{AllowCountries: []string{"FR"}, Lookup: lookup}, "192.0.2.2", false},
+		{"unknown country", &ACL{AllowCountries: []string{"FR"}, Lookup: lookup}, "192.0.2.4", false},
+		{"lookup error", &ACL{AllowCountries: []string{"FR"}, Lookup: lookup}, "192.0.2.3", false},
+		{"no lookup", &ACL{AllowCountries: []string{"FR"}}, "192.0.2.1", false},
+		{"denied country", &ACL{DenyCountries: []string{"US"}, Lookup: lookup}, "192.0.2.2", false},
+		{"not denied country", &ACL{DenyCountries: []string{"US"}, Lookup: lookup}, "192.0.2.1", true},
+		{"unknown country not denied", &ACL{DenyCountries: []string{"US"}, Lookup: lookup}, "192.0.2.4", true},
+		{"denied asn", &ACL{DenyASNs: []uint32{64496}, Lookup: lookup}, "192.0.2.1", false},
+		{"not denied asn", &ACL{DenyASNs: []uint32{64496}, Lookup: lookup}, "192.0.2.2", true},
+		{"allowed network or country", &ACL{Allow: mustParseCIDRs(t, "10.0.0.0/8"), AllowCountries: []string{"FR"}, Lookup: lookup}, "10.0.0.1", true},
+		{"denied asn takes precedence", &ACL{Allow: mustParseCIDRs(t, "192.0.2.0/24"), DenyASNs: []uint32{64497}, Lookup: lookup}, "192.0.2.2", false},
+		{"denied network takes precedence", &ACL{Deny: mustParseCIDRs(t, "192.0.2.1"), AllowCountries: []string{"FR"}, Lookup: lookup}, "192.0.2.1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := test.acl.Allowed(net.ParseIP(test.ip)); allowed != test.allowed {
+				t.Errorf("%s: allowed=%t", test.ip, allowed)
+			}
+		})
+	}
+}
+
+func mustParseCIDRs(t *testing.T, list ...string) []*net.IPNet {
+	networks, err := ParseCIDRs(list...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return networks
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs("10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32", "::1/128"}
+
+	for i, n := range networks {
+		if n.String() != expected[i] {
+			t.Errorf("bad network at index %d: %s", i, n)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "localhost", ""} {
+		if _, err := ParseCIDRs(s); err == nil {
+			t.Errorf("no error returned for %q", s)
+		}
+	}
+}
+
+func TestACLListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deny, _ := ParseCIDRs("127.0.0.0/8")
+	lstn := &ACLListener{Listener: l, ACL: &ACL{Deny: deny}}
+	defer lstn.Close()
+
+	go func() {
+		for {
+			conn, err := lstn.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			t.Error("a denied connection was accepted")
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("bad error reading from a rejected connection:", err)
+	}
+
+	if n := lstn.Rejected(); n != 1 {
+		t.Error("bad count of rejected connections:", n)
+	}
+}
+
+func TestACLListenerProxyProto(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The loopback address of the load balancer is allowed, only the address
+	// of the clients is checked.
+	allow, _ := ParseCIDRs("127.0.0.0/8", "192.168.0.0/16")
+	lstn := &ACLListener{
+		Listener: &ProxyProtoListener{Listener: l},
+		ACL:      &ACL{Allow: allow},
+	}
+	defer lstn.Close()
+
+	tests := []struct {
+		header string
+		err    error
+	}{
+		{"PROXY TCP4 192.168.0.1 192.168.0.2 56789 80\r\n", nil},
+		{"PROXY TCP4 10.0.0.1 192.168.0.2 56789 80\r\n", ErrDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header[:len(test.header)-2], func(t *testing.T) {
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// The connection is accepted before the client sent the header.
+			conn, err := lstn.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := io.WriteString(client, test.header+"Hello"); err != nil {
+				t.Fatal(err)
+			}
+
+			b := make([]byte, 5)
+			_, err = io.ReadFull(conn, b)
+
+			if !errors.Is(err, test.err) {
+				t.Error("bad error:", err)
+			}
+			if err == nil && string(b) != "Hello" {
+				t.Errorf("bad data: %q", b)
+			}
+		})
+	}
+
+	if n := lstn.Rejected(); n != 1 {
+		t.Error("bad count of rejected connections:", n)
+	}
+}
@@ -0,0 +1,159 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// BridgeOptions carries the configuration of a call to Bridge.
+type BridgeOptions struct {
+	// IdleTimeout is the maximum amount of time that the connections may stay
+	// idle, with no bytes flowing in any direction, before the bridge is torn
+	// down. Zero means no timeout.
+	//
+	// Setting an idle timeout disables the zero-copy optimizations of Copy
+	// because the bridge has to observe every read.
+	IdleTimeout time.Duration
+
+	// BufferPool is used to get the buffers for copying bytes between the
+	// connections. If nil, the internal buffer pool of Copy is used.
+	BufferPool BufferPool
+}
+
+// Bridge passes bytes back and forth between a and b until both directions
+// are done, the connections are closed when the function returns.
+//
+// When one of the directions reaches EOF, the write side of the destination is
+// shut down (if the connection exposes a CloseWrite method, directly or on one
+// of its base connections) and the other direction keeps flowing until it also
+// reaches EOF. If half-closing is not supported, or if one of the directions
+// fails, the bridge is torn down right away.
+//
+// The returned error is the first error that occurred, nil if both directions
+// ended with EOF, or ctx.Err() if the context was canceled. When the idle
+// timeout expires, the error is a timeout error (see IsTimeout).
+func Bridge(ctx context.Context, a net.Conn, b net.Conn, opts BridgeOptions) (stats CopyStats, err error) {
+	start := time.Now()
+	br := &bridge{timeout: opts.IdleTimeout, pool: opts.BufferPool}
+	br.touch()
+
+	errs := make(chan error, 2)
+	go func() { errs <- br.copy(b, a, &stats.AToB) }()
+	go func() { errs <- br.copy(a, b, &stats.BToA) }()
+
+	done := ctx.Done()
+	closed := false
+
+	for n := 0; n != 2; {
+		var e error
+
+		select {
+		case e = <-errs:
+			n++
+		case <-done:
+			done, e = nil, ctx.Err()
+		}
+
+		if e != nil && !closed {
+			// The other direction is interrupted by closing the connections,
+			// its error is not reported since it was caused by the bridge.
+			closed = true
+			if e != io.EOF {
+				err = e
+			}
+			a.Close()
+			b.Close()
+		}
+	}
+
+	if !closed {
+		a.Close()
+		b.Close()
+	}
+
+	stats.Duration = time.Since(start)
+	return
+}
+
+type bridge struct {
+	timeout time.Duration
+	pool    BufferPool
+	last    int64 // time of the last read, in nanoseconds
+}
+
+// copy copies bytes from r to w, it returns io.EOF if r reached EOF but the
+// write side of w could not be shut down.
+func (br *bridge) copy(w net.Conn, r net.Conn, n *int64) (err error) {
+	if br.timeout > 0 {
+		*n, err = CopyBuffer(w, &idleReader{conn: r, bridge: br}, br.pool)
+	} else {
+		*n, err = CopyBuffer(w, r, br.pool)
+	}
+
+	if err == nil && closeWrite(w) != nil {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (br *bridge) touch() {
+	atomic.StoreInt64(&br.last, time.Now().UnixNano())
+}
+
+func (br *bridge) idle() bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&br.last))) >= br.timeout
+}
+
+// idleReader reads from a connection of a bridge, setting a read deadline
+// before each read to detect when the bridge becomes idle.
+type idleReader struct {
+	conn   net.Conn
+	bridge *bridge
+}
+
+func (r *idleReader) Read(b []byte) (n int, err error) {
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(r.bridge.timeout))
+
+		if n, err = r.conn.Read(b); n > 0 {
+			r.bridge.touch()
+		}
+
+		if n == 0 && IsTimeout(err) {
+			// The deadline expired but bytes may have been flowing in the other
+			// direction, the bridge is only idle if both directions are.
+			if !r.bridge.idle() {
+				continue
+			}
+			err = Timeout("bridge idle timeout exceeded")
+		}
+
+		return
+	}
+}
+
+var errCloseWriteNotSupported = errors.New("the connection does not support shutting down its write side")
+
+// closeWrite shuts down the write side of conn, looking for a CloseWrite
+// method on conn itself or on its base connections.
+func closeWrite(conn net.Conn) error {
+	for {
+		if c, ok := conn.(interface {
+			CloseWrite() error
+		}); ok {
+			return c.CloseWrite()
+		}
+
+		b, ok := conn.(baseConn)
+		if !ok {
+			return errCloseWriteNotSupported
+		}
+
+		conn = b.BaseConn()
+	}
+}
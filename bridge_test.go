@@ -0,0 +1,128 @@
+package netx
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func bridgePairs(t *testing.T) (client net.Conn, server net.Conn, a net.Conn, b net.Conn) {
+	var err error
+
+	if client, a, err = ConnPair("tcp"); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, server, err = ConnPair("tcp"); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestBridgeHalfClose(t *testing.T) {
+	client, server, a, b := bridgePairs(t)
+	defer client.Close()
+	defer server.Close()
+
+	type result struct {
+		stats CopyStats
+		err   error
+	}
+
+	done := make(chan result)
+	go func() {
+		stats, err := Bridge(context.Background(), a, b, BridgeOptions{})
+		done <- result{stats, err}
+	}()
+
+	go func() {
+		// The server replies after reading the full request, which only works
+		// if the half-close of the client was propagated.
+		if b, err := ioutil.ReadAll(server); err == nil && string(b) == "ping" {
+			server.Write([]byte("pong!"))
+		}
+		server.Close()
+	}()
+
+	client.Write([]byte("ping"))
+	client.(*net.TCPConn).CloseWrite()
+
+	b2, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Error(err)
+	}
+	if s := string(b2); s != "pong!" {
+		t.Error("bad response:", s)
+	}
+
+	res := <-done
+
+	if res.err != nil {
+		t.Error(res.err)
+	}
+
+	if res.stats.AToB != 4 || res.stats.BToA != 5 {
+		t.Errorf("bad byte counts: %d a->b, %d b->a", res.stats.AToB, res.stats.BToA)
+	}
+}
+
+func TestBridgeIdleTimeout(t *testing.T) {
+	client, server, a, b := bridgePairs(t)
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := Bridge(context.Background(), a, b, BridgeOptions{IdleTimeout: 50 * time.Millisecond})
+		done <- err
+	}()
+
+	// Traffic on the connections keeps the bridge alive.
+	for i := 0; i != 5; i++ {
+		client.Write([]byte("x"))
+		server.Read(make([]byte, 1))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatal("the bridge was closed while it was active:", err)
+	default:
+	}
+
+	select {
+	case err := <-done:
+		if !IsTimeout(err) {
+			t.Error("bad error:", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("the bridge was not closed after the idle timeout")
+	}
+}
+
+func TestBridgeContext(t *testing.T) {
+	client, server, a, b := bridgePairs(t)
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := Bridge(ctx, a, b, BridgeOptions{})
+		done <- err
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Error("bad error:", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("the bridge was not closed after the context was canceled")
+	}
+}
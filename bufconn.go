@@ -0,0 +1,90 @@
+package netx
+
+import (
+	"bufio"
+	"io"
+	"net"
+)
+
+// BufferedConn is a connection wrapper with a read buffer, it lets programs
+// peek at the bytes received on a connection before deciding how to handle
+// it, without losing them: the bytes that were peeked are returned by the
+// next reads.
+//
+// This is useful to build protocol sniffers, for example to route connections
+// based on the TLS server name, detect a proxy protocol header, or recognize
+// a HTTP method.
+type BufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewBufferedConn returns a new BufferedConn wrapping conn, with a buffer of
+// the default size (4096 bytes).
+func NewBufferedConn(conn net.Conn) *BufferedConn {
+	return &BufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// NewBufferedConnSize returns a new BufferedConn wrapping conn, with a buffer
+// of at least size bytes.
+func NewBufferedConnSize(conn net.Conn, size int) *BufferedConn {
+	return &BufferedConn{Conn: conn, r: bufio.NewReaderSize(conn, size)}
+}
+
+// BaseConn returns the connection wrapped by c.
+func (c *BufferedConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+// Peek returns the next n bytes without advancing the reader, blocking until
+// they were received (or until the read deadline of the connection expires).
+// The bytes stop being valid at the next read call.
+//
+// If Peek returns fewer than n bytes, it also returns an error explaining why
+// the read is short. The error is bufio.ErrBufferFull if n is larger than the
+// buffer size.
+func (c *BufferedConn) Peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+// Discard skips the next n bytes, returning the number of bytes discarded.
+//
+// If Discard skips fewer than n bytes, it also returns an error.
+func (c *BufferedConn) Discard(n int) (int, error) {
+	return c.r.Discard(n)
+}
+
+// Buffered returns the number of bytes that can be read from the buffer
+// without reading from the connection.
+func (c *BufferedConn) Buffered() int {
+	return c.r.Buffered()
+}
+
+// Read satisfies the io.Reader interface.
+func (c *BufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// ReadFrom satisfies the io.ReaderFrom interface.
+func (c *BufferedConn) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(c.Conn, c, r)
+}
+
+// WriteTo satisfies the io.WriterTo interface.
+//
+// Once the buffered bytes have been written the copy is delegated to the
+// connection, which lets Copy use its optimized code paths.
+func (c *BufferedConn) WriteTo(w io.Writer) (n int64, err error) {
+	if k := c.r.Buffered(); k != 0 {
+		b, _ := c.r.Peek(k)
+		k, err = w.Write(b)
+		c.r.Discard(k)
+		n = int64(k)
+		if err != nil {
+			return
+		}
+	}
+	k, err := writeTo(c.Conn, c, w)
+	n += k
+	return
+}
@@ -0,0 +1,71 @@
+package netx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBufferedConn(t *testing.T) {
+	c1, c2, err := TCPConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		c1.Write([]byte("GET / HTTP/1.1\r\n"))
+		c1.Close()
+	}()
+
+	conn := NewBufferedConn(c2)
+
+	b, err := conn.Peek(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "GET" {
+		t.Errorf("bad peeked bytes: %q", b)
+	}
+
+	if n, err := conn.Discard(4); n != 4 || err != nil {
+		t.Fatal("discard:", n, err)
+	}
+
+	all, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != "/ HTTP/1.1\r\n" {
+		t.Errorf("bad bytes read: %q", all)
+	}
+}
+
+func TestBufferedConnWriteTo(t *testing.T) {
+	c1, c2, err := TCPConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		c1.Write([]byte("Hello World!"))
+		c1.Close()
+	}()
+
+	conn := NewBufferedConnSize(c2, 16)
+
+	if _, err := conn.Peek(5); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := conn.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); s != "Hello World!" {
+		t.Errorf("bad bytes copied: %q", s)
+	}
+}
@@ -0,0 +1,60 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialFunc is the signature of the functions used to establish connections,
+// like the DialContext method of net.Dialer.
+type DialFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+
+// A Hop is a function which returns the dialer of a proxy in a chain, the dial
+// function it receives must be used to establish connections to the proxy.
+//
+// The dialers of the socksx and httpx packages have a Hop method which can be
+// used here.
+type Hop func(dial DialFunc) DialFunc
+
+// ChainDialer establishes connections through a chain of proxies, each proxy
+// is reached through the ones that come before it in the chain, and the last
+// one connects to the destination.
+//
+//	d := &netx.ChainDialer{
+//		Hops: []netx.Hop{
+//			(&socksx.Dialer{Address: "10.0.0.1:1080"}).Hop,
+//			(&httpx.ProxyDialer{URL: proxyURL}).Hop,
+//		},
+//	}
+//
+// Whether a network other than tcp can be used depends on the proxies, note
+// that UDP datagrams relayed by a SOCKS5 proxy are not sent through the
+// previous hops.
+type ChainDialer struct {
+	// Hops is the list of proxies that connections go through, in order. When
+	// the list is empty connections are established directly.
+	Hops []Hop
+
+	// Dial is used to connect to the first hop of the chain. If nil, a
+	// net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+}
+
+// DialContext connects to address on the named network through the chain of
+// proxies.
+//
+// The method has the signature expected by the DialContext fields of
+// transports and proxies.
+func (d *ChainDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := DialFunc(d.Dial)
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	for _, hop := range d.Hops {
+		dial = hop(dial)
+	}
+
+	return dial(ctx, network, address)
+}
@@ -0,0 +1,155 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// listenLineProxy starts a proxy which reads the address to connect to on the
+// first line sent by clients, it reports the addresses on the returned channel.
+func listenLineProxy(t *testing.T) (net.Listener, chan string) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := make(chan string, 10)
+
+	go (&Server{
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			target := line[:len(line)-1]
+			targets <- target
+
+			backend, err := net.Dial("tcp", target)
+			if err != nil {
+				return
+			}
+			defer backend.Close()
+
+			if n := r.Buffered(); n != 0 {
+				b, _ := r.Peek(n)
+				backend.Write(b)
+			}
+
+			Bridge(ctx, conn, backend, BridgeOptions{})
+		}),
+	}).Serve(lstn)
+
+	return lstn, targets
+}
+
+func lineProxyHop(address string) Hop {
+	return func(dial DialFunc) DialFunc {
+		return func(ctx context.Context, network string, target string) (net.Conn, error) {
+			conn, err := dial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write([]byte(target + "\n")); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+}
+
+func TestChainDialer(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go (&Server{Handler: Echo}).Serve(echo)
+
+	proxy1, targets1 := listenLineProxy(t)
+	defer proxy1.Close()
+
+	proxy2, targets2 := listenLineProxy(t)
+	defer proxy2.Close()
+
+	dials := make(chan string, 10)
+
+	d := &ChainDialer{
+		Hops: []Hop{
+			lineProxyHop(proxy1.Addr().String()),
+			lineProxyHop(proxy2.Addr().String()),
+		},
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			dials <- address
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		},
+	}
+
+	conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("Hello World!"))
+	b := make([]byte, 12)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Error("bad response:", string(b))
+	}
+
+	if addr := <-dials; addr != proxy1.Addr().String() {
+		t.Error("the first hop was not dialed directly:", addr)
+	}
+	if addr := <-targets1; addr != proxy2.Addr().String() {
+		t.Error("the first hop did not connect to the second hop:", addr)
+	}
+	if addr := <-targets2; addr != echo.Addr().String() {
+		t.Error("the second hop did not connect to the destination:", addr)
+	}
+}
+
+func TestChainDialerDirect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go (&Server{Handler: Echo}).Serve(echo)
+
+	conn, err := (&ChainDialer{}).DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestChainDialerError(t *testing.T) {
+	fail := errors.New("hop failed")
+
+	d := &ChainDialer{
+		Hops: []Hop{
+			func(dial DialFunc) DialFunc {
+				return func(ctx context.Context, network string, address string) (net.Conn, error) {
+					return nil, fail
+				}
+			},
+			lineProxyHop("127.0.0.1:1"),
+		},
+	}
+
+	if _, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:2"); err != fail {
+		t.Error("bad error:", err)
+	}
+}
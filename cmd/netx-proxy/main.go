@@ -0,0 +1,204 @@
+// Command netx-proxy is an edge proxy serving the configuration file passed on
+// the command line, see the config package for the format of the file:
+//
+//	netx-proxy -config netx.json
+//
+// Configuration files can be written in JSON or YAML, the format is determined
+// by the extension of the file (.json, .yaml, or .yml).
+//
+// The configuration is reloaded when the file changes or when the program
+// receives SIGHUP, except for the listeners and the timeouts of the client
+// connections which require a restart.
+//
+// When an admin address is configured, it serves the admin API of the proxy
+// (see httpx.AdminHandler) and the following endpoints:
+//
+//	GET /debug       statistics of the proxy (see httpx.DebugHandler)
+//	GET /debug/vars  the statistics and the runtime variables of the expvar package
+//	GET /healthz     liveness check
+//	GET /readyz      readiness check, fails while the proxy is shutting down
+//
+// On SIGINT or SIGTERM, the proxy stops accepting connections and waits for the
+// requests in flight to complete before exiting.
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/netx"
+	"github.com/segmentio/netx/config"
+	"github.com/segmentio/netx/httpx"
+)
+
+func main() {
+	var path string
+	var check bool
+	var shutdownTimeout time.Duration
+
+	flag.StringVar(&path, "config", "netx.json", "The path to the configuration file, in JSON or YAML.")
+	flag.BoolVar(&check, "check", false, "Validate the configuration file and exit.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "The maximum amount of time to wait for the requests in flight when shutting down.")
+	flag.Parse()
+
+	if check {
+		if _, err := config.Load(path); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: configuration OK", path)
+		return
+	}
+
+	logger := netx.NewStdLogger(nil)
+	proxy := &config.Proxy{}
+	watcher := &config.Watcher{
+		Path:   path,
+		Apply:  proxy.Apply,
+		Logger: logger,
+	}
+
+	// The initial configuration decides the listeners, which cannot change
+	// without a restart.
+	if err := watcher.Reload(); err != nil {
+		log.Fatal(err)
+	}
+	initial := proxy.Config()
+
+	watcher.Apply = func(c *config.Config) error {
+		if needsRestart(initial, c) {
+			logger.Warn("the listeners, client timeouts, or admin address changed, restart to apply the changes", "path", path)
+		}
+		return proxy.Apply(c)
+	}
+
+	stats := &metrics{}
+	handler := stats.middleware(proxy)
+	servers := make([]*http.Server, 0, len(initial.Listeners)+1)
+
+	for _, l := range initial.Listeners {
+		lstn, err := proxy.Listen(l)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		server := proxy.Server()
+		server.Handler = handler
+		server.ConnState = stats.connState
+		servers = append(servers, server)
+
+		log.Printf("listening on %s", lstn.Addr())
+		go serve(server, lstn)
+	}
+
+	var shuttingDown int32
+
+	if addr := initial.Admin.Address; len(addr) != 0 {
+		lstn, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		debug := &httpx.DebugHandler{}
+		debug.Handle("proxy", func() interface{} { return stats.snapshot() })
+		debug.Publish("netx-proxy")
+
+		health := &httpx.HealthHandler{Logger: logger}
+		health.HandleReadiness("shutdown", httpx.HealthCheckFunc(func(context.Context) error {
+			if atomic.LoadInt32(&shuttingDown) != 0 {
+				return errors.New("the proxy is shutting down")
+			}
+			return nil
+		}))
+
+		mux := http.NewServeMux()
+		mux.Handle("/", proxy.Admin())
+		mux.Handle("/debug", debug)
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.Handle("/healthz", health)
+		mux.Handle("/readyz", health)
+
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		servers = append(servers, server)
+
+		log.Printf("serving the admin API on %s", lstn.Addr())
+		go serve(server, lstn)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+	log.Print("signal: ", <-sigchan)
+	atomic.StoreInt32(&shuttingDown, 1)
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Warn("shutdown error", "err", err)
+				server.Close()
+			}
+		}(server)
+	}
+
+	wg.Wait()
+}
+
+func serve(server *http.Server, lstn net.Listener) {
+	if err := server.Serve(lstn); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// needsRestart checks whether c2 changed parts of the configuration c1 which
+// are only read when the proxy starts.
+func needsRestart(c1 *config.Config, c2 *config.Config) bool {
+	t1, t2 := c1.Timeouts, c2.Timeouts
+
+	if t1.Read != t2.Read || t1.Write != t2.Write || t1.Idle != t2.Idle || c1.Admin != c2.Admin {
+		return true
+	}
+
+	return !sameListeners(c1.Listeners, c2.Listeners)
+}
+
+func sameListeners(l1 []config.Listener, l2 []config.Listener) bool {
+	if len(l1) != len(l2) {
+		return false
+	}
+
+	for i := range l1 {
+		a, b := l1[i], l2[i]
+
+		// Rate limits are updated by the proxy when a configuration is
+		// applied.
+		a.Limits.Rate, a.Limits.Burst = 0, 0
+		b.Limits.Rate, b.Limits.Burst = 0, 0
+
+		if a.Address != b.Address || a.Limits != b.Limits || (a.TLS == nil) != (b.TLS == nil) || (a.TLS != nil && *a.TLS != *b.TLS) {
+			return false
+		}
+	}
+
+	return true
+}
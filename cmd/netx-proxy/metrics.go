@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// metrics counts the connections and requests served by the proxy.
+type metrics struct {
+	conns    int64    // open client connections
+	inflight int64    // requests in flight
+	status   [6]int64 // completed requests by status class, upgrades at index 0
+}
+
+type metricsSnapshot struct {
+	Conns    int64            `json:"conns"`
+	Inflight int64            `json:"inflight"`
+	Requests map[string]int64 `json:"requests"`
+}
+
+func (m *metrics) snapshot() metricsSnapshot {
+	return metricsSnapshot{
+		Conns:    atomic.LoadInt64(&m.conns),
+		Inflight: atomic.LoadInt64(&m.inflight),
+		Requests: map[string]int64{
+			"upgraded": atomic.LoadInt64(&m.status[0]),
+			"1xx":      atomic.LoadInt64(&m.status[1]),
+			"2xx":      atomic.LoadInt64(&m.status[2]),
+			"3xx":      atomic.LoadInt64(&m.status[3]),
+			"4xx":      atomic.LoadInt64(&m.status[4]),
+			"5xx":      atomic.LoadInt64(&m.status[5]),
+		},
+	}
+}
+
+func (m *metrics) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&m.conns, 1)
+	case http.StateHijacked, http.StateClosed:
+		atomic.AddInt64(&m.conns, -1)
+	}
+}
+
+func (m *metrics) middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&m.inflight, 1)
+		defer atomic.AddInt64(&m.inflight, -1)
+
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(mw, req)
+
+		class := 0
+		switch {
+		case mw.hijacked:
+		case mw.status == 0:
+			class = 2 // the handler returned without writing a response
+		case mw.status >= 100 && mw.status < 600:
+			class = mw.status / 100
+		default:
+			class = 5
+		}
+
+		atomic.AddInt64(&m.status[class], 1)
+	})
+}
+
+// metricsResponseWriter records the status of responses, it supports the
+// optional interfaces used by the proxy to stream responses and to tunnel
+// protocol upgrades.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (w *metricsResponseWriter) WriteHeader(status int) {
+	if w.status == 0 || w.status < 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *metricsResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *metricsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection cannot be hijacked")
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
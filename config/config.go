@@ -0,0 +1,541 @@
+// Package config builds proxies from configuration files, which lets netx run
+// as a standalone edge proxy. Configurations can be reloaded while the proxy is
+// serving requests, see Proxy and Watcher.
+//
+// A configuration file looks like this, in JSON:
+//
+//	{
+//	  "listeners": [
+//	    {
+//	      "address": ":443",
+//	      "tls": { "cert_file": "edge.crt", "key_file": "edge.key" },
+//	      "limits": { "max_conns": 10000, "rate": 500, "burst": 1000 }
+//	    }
+//	  ],
+//	  "pools": {
+//	    "api": {
+//	      "backends": [
+//	        { "addr": "10.0.0.1:8080", "weight": 2 },
+//	        { "addr": "10.0.0.2:8080", "weight": 1 }
+//	      ],
+//	      "balancer": { "type": "hash", "key": "cookie:session" }
+//	    }
+//	  },
+//	  "routes": [
+//	    { "host": "api.example.com", "path": "/v1/", "pool": "api" }
+//	  ],
+//	  "timeouts": { "read": "30s", "write": "30s", "idle": "90s", "dial": "5s" },
+//	  "admin": { "address": "127.0.0.1:9901" }
+//	}
+//
+// Or in YAML, when the file has a .yaml or .yml extension:
+//
+//	listeners:
+//	  - address: ":443"
+//	    tls: { cert_file: edge.crt, key_file: edge.key }
+//	pools:
+//	  api:
+//	    backends:
+//	      - { addr: "10.0.0.1:8080", weight: 2 }
+//	routes:
+//	  - { host: api.example.com, path: /v1/, pool: api }
+//
+// Clients can be restricted, or routed, by network or by origin region with an
+// access control list, and the countries of routes:
+//
+//	{
+//	  "geoip": { "databases": ["GeoLite2-Country.mmdb", "GeoLite2-ASN.mmdb"] },
+//	  "trusted_proxies": ["10.0.0.0/8"],
+//	  "client_ip_header": "X-Forwarded-For",
+//	  "paths": { "normalize": true },
+//	  "acl": { "deny_countries": ["KP"], "deny_asns": [64496] },
+//	  "routes": [
+//	    { "path": "/", "pool": "eu", "countries": ["FR", "DE", "ES"] },
+//	    { "path": "/", "pool": "us" }
+//	  ]
+//	}
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/segmentio/netx"
+	"github.com/segmentio/netx/httpx"
+)
+
+// Config is the configuration of a proxy.
+type Config struct {
+	// Listeners is the list of addresses that the proxy accepts connections
+	// on. Changing the listeners requires restarting the proxy, except for
+	// their rate limits.
+	Listeners []Listener `json:"listeners"`
+
+	// Pools maps names to the pools of backends that the routes forward
+	// requests to.
+	Pools map[string]Pool `json:"pools"`
+
+	// Routes is the list of routes of the proxy, requests are forwarded to
+	// the pool of the first route that they match.
+	Routes []Route `json:"routes"`
+
+	// Timeouts configures the timeouts of the proxy.
+	Timeouts Timeouts `json:"timeouts"`
+
+	// Admin configures the admin API of the proxy.
+	Admin Admin `json:"admin"`
+
+	// ACL, if not nil, restricts the clients that the proxy accepts requests
+	// from, the other clients get 403 Forbidden responses.
+	ACL *ACL `json:"acl,omitempty"`
+
+	// GeoIP configures the databases providing the country and autonomous
+	// system of clients, which are required by the rules of the ACL and the
+	// routes based on them.
+	GeoIP GeoIP `json:"geoip"`
+
+	// Paths configures the normalization of the paths of requests.
+	Paths Paths `json:"paths"`
+
+	// TrustedProxies is the list of networks of the load balancers in front of
+	// the proxy, which are trusted to report the address of clients in the
+	// forwarding headers (see httpx.ClientIP).
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// ClientIPHeader is the forwarding header that the trusted proxies append
+	// the address of clients to, either "X-Forwarded-For" or "Forwarded". It
+	// is required when TrustedProxies is set, since the proxies usually pass
+	// the other header from clients through untouched.
+	ClientIPHeader string `json:"client_ip_header,omitempty"`
+}
+
+// Listener is the configuration of an address that the proxy accepts
+// connections on.
+type Listener struct {
+	// Address is the address of the listener, in the host:port form.
+	Address string `json:"address"`
+
+	// TLS, if not nil, configures the listener to accept TLS connections.
+	TLS *TLS `json:"tls,omitempty"`
+
+	// Limits configures the limits applied to the connections accepted by
+	// the listener.
+	Limits Limits `json:"limits"`
+}
+
+// TLS is the configuration of the certificates of a listener, either a pair
+// of certificate and key files, or a directory of certificates selected by
+// the server name of the clients (see tlsx.CertDir). The certificates are
+// reloaded when the files change.
+type TLS struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CertDir  string `json:"cert_dir,omitempty"`
+
+	// DefaultName is the name of the certificate of CertDir which is used
+	// for clients that sent no server name.
+	DefaultName string `json:"default_name,omitempty"`
+}
+
+// Limits is the configuration of the limits of a listener, zero values mean
+// no limit.
+type Limits struct {
+	// MaxConns is the maximum number of connections served at the same time,
+	// see netx.LimitListener.
+	MaxConns int `json:"max_conns,omitempty"`
+
+	// Rate and Burst limit the rate at which connections are accepted, see
+	// netx.RateLimitListener.
+	Rate  int `json:"rate,omitempty"`
+	Burst int `json:"burst,omitempty"`
+}
+
+// Pool is the configuration of a pool of backends.
+type Pool struct {
+	// Backends is the list of backends of the pool.
+	Backends []httpx.Backend `json:"backends"`
+
+	// TLS, when true, makes the proxy use TLS to connect to the backends.
+	TLS bool `json:"tls,omitempty"`
+
+	// Balancer configures the selection of the backends that requests are
+	// sent to. If empty, requests are balanced with weighted round-robin.
+	Balancer Balancer `json:"balancer,omitempty"`
+}
+
+// Balancer is the configuration of the balancer of a pool, see httpx.Balancer.
+type Balancer struct {
+	// Type is the algorithm of the balancer, "round_robin" (the default),
+	// "hash" to send the requests sharing a key to the same backend (see
+	// httpx.HashBalancer), or "peak_ewma" to send requests to the backends
+	// with the lowest latency and number of requests in flight (see
+	// httpx.EWMABalancer).
+	Type string `json:"type,omitempty"`
+
+	// Key is the attribute of requests that the "hash" balancer uses as key:
+	// "client_ip", "url", "header:<name>", or "cookie:<name>". The addresses
+	// of clients are resolved with the trusted proxies of the configuration.
+	Key string `json:"key,omitempty"`
+
+	// Decay is the time window over which the "peak_ewma" balancer averages
+	// the latency of backends. If zero, it defaults to 10 seconds.
+	Decay Duration `json:"decay,omitempty"`
+}
+
+// Route is the configuration of a route of the proxy.
+type Route struct {
+	// Host is the host name that requests must be sent to, names starting
+	// with "*." match all the subdomains of a domain. If empty, the route
+	// matches all hosts.
+	Host string `json:"host,omitempty"`
+
+	// Path is the prefix that the path of requests must start with. If
+	// empty, the route matches all paths.
+	Path string `json:"path,omitempty"`
+
+	// Pool is the name of the pool that requests are forwarded to.
+	Pool string `json:"pool"`
+
+	// StripPrefix removes Path from the path of requests before forwarding
+	// them.
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+
+	// Countries is the list of countries that clients must be located in, as
+	// ISO 3166-1 alpha-2 codes like "US" or "FR". If empty, the route matches
+	// all clients.
+	Countries []string `json:"countries,omitempty"`
+}
+
+// Timeouts is the configuration of the timeouts of the proxy, zero values
+// mean no timeout. The read, write, and idle timeouts apply to the client
+// connections and require restarting the proxy to change.
+type Timeouts struct {
+	Read  Duration `json:"read,omitempty"`
+	Write Duration `json:"write,omitempty"`
+	Idle  Duration `json:"idle,omitempty"`
+
+	// Dial is the maximum amount of time allowed to connect to a backend.
+	// If zero, it defaults to 10 seconds.
+	Dial Duration `json:"dial,omitempty"`
+
+	// ResponseHeader is the maximum amount of time that the proxy waits for
+	// the response header of a backend.
+	ResponseHeader Duration `json:"response_header,omitempty"`
+}
+
+// ACL is the configuration of the access control list of the proxy, see
+// netx.ACL.
+type ACL struct {
+	// Allow and Deny are lists of networks in the CIDR notation, or of IP
+	// addresses.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	AllowCountries []string `json:"allow_countries,omitempty"`
+	DenyCountries  []string `json:"deny_countries,omitempty"`
+	DenyASNs       []uint32 `json:"deny_asns,omitempty"`
+}
+
+// GeoIP is the configuration of the IP metadata databases of the proxy.
+type GeoIP struct {
+	// Databases is the list of paths to MaxMind databases, like a country
+	// database and an ASN database, see geoipx.DB. The databases are loaded
+	// when the configuration is applied.
+	Databases []string `json:"databases,omitempty"`
+}
+
+// Paths is the configuration of the normalization of request paths, which
+// prevents clients from reaching the paths of backends that the routes don't
+// expose with paths like "/public/%2e%2e/admin".
+type Paths struct {
+	// Normalize enables the normalization of the paths of requests before
+	// they are matched against routes and forwarded, see httpx.NormalizePath.
+	// Requests with invalid paths, like ones containing encoded NUL bytes,
+	// are rejected with 400 Bad Request.
+	Normalize bool `json:"normalize,omitempty"`
+
+	// PreserveRaw makes the proxy forward the request targets byte-for-byte
+	// as they were received, for backends which depend on their exact
+	// encoding (see httpx.ReverseProxy.PreserveRequestURI). The normalized
+	// paths are then only used to match the routes.
+	PreserveRaw bool `json:"preserve_raw,omitempty"`
+}
+
+// Admin is the configuration of the admin API, see httpx.AdminHandler.
+type Admin struct {
+	// Address is the address of the listener of the admin API, which must
+	// not be reachable by clients, like "127.0.0.1:9901". The admin API has
+	// no authentication. If empty, the admin API is disabled.
+	Address string `json:"address,omitempty"`
+}
+
+// Duration is a time.Duration represented by a string like "1m30s" in
+// configuration files.
+type Duration time.Duration
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings like \"10s\": %s", b)
+	}
+
+	x, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(x)
+	return nil
+}
+
+// Unmarshalers maps the extensions of configuration files to the functions
+// used to decode them. JSON and YAML are supported by default.
+//
+// Since the package has no dependencies, the YAML decoder only supports the
+// subset of the language which is useful in configuration files: anchors,
+// aliases, tags, and multiple documents are rejected. Programs that need them
+// can register a library which honors the json struct tags instead, like
+// sigs.k8s.io/yaml:
+//
+//	config.Unmarshalers[".yaml"] = yaml.Unmarshal
+//	config.Unmarshalers[".yml"] = yaml.Unmarshal
+//
+// The default decoders reject unknown fields, so typos are reported instead of
+// being silently ignored. The map must not be modified while configurations are
+// loaded.
+var Unmarshalers = map[string]func([]byte, interface{}) error{
+	".json": unmarshalJSON,
+	".yaml": unmarshalYAML,
+	".yml":  unmarshalYAML,
+}
+
+// Load reads and validates the configuration file at path, the format of the
+// file is determined by its extension.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFile(path, b)
+}
+
+// Parse decodes a configuration from b with unmarshal, then validates it.
+func Parse(b []byte, unmarshal func([]byte, interface{}) error) (*Config, error) {
+	c := &Config{}
+
+	if err := unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func unmarshalJSON(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+func parseFile(path string, b []byte) (*Config, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	unmarshal := Unmarshalers[ext]
+	if unmarshal == nil {
+		return nil, fmt.Errorf("%s: unsupported configuration format: %q", path, ext)
+	}
+
+	c, err := Parse(b, unmarshal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Validate checks that the configuration is valid, it returns an error
+// describing all the problems that were found.
+func (c *Config) Validate() error {
+	var errs []error
+
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	addrs := make(map[string]bool, len(c.Listeners))
+
+	for i, l := range c.Listeners {
+		switch {
+		case len(l.Address) == 0:
+			check(fmt.Errorf("listeners[%d]: missing address", i))
+		case addrs[l.Address]:
+			check(fmt.Errorf("listeners[%d]: duplicate address: %s", i, l.Address))
+		}
+		addrs[l.Address] = true
+
+		if t := l.TLS; t != nil {
+			hasPair := len(t.CertFile) != 0 || len(t.KeyFile) != 0
+
+			switch {
+			case hasPair && len(t.CertDir) != 0:
+				check(fmt.Errorf("listeners[%d].tls: cert_file and key_file cannot be used with cert_dir", i))
+			case hasPair && (len(t.CertFile) == 0 || len(t.KeyFile) == 0):
+				check(fmt.Errorf("listeners[%d].tls: cert_file and key_file must be set together", i))
+			case !hasPair && len(t.CertDir) == 0:
+				check(fmt.Errorf("listeners[%d].tls: missing certificates", i))
+			}
+		}
+
+		if l.Limits.MaxConns < 0 || l.Limits.Rate < 0 || l.Limits.Burst < 0 {
+			check(fmt.Errorf("listeners[%d].limits: limits cannot be negative", i))
+		}
+	}
+
+	for name, p := range c.Pools {
+		if len(p.Backends) == 0 {
+			check(fmt.Errorf("pools[%q]: no backends", name))
+		}
+		// The validation of the backends is the one of the pools they are
+		// loaded into.
+		check(prefix(fmt.Sprintf("pools[%q]", name), (&httpx.BackendPool{}).SetBackends(p.Backends)))
+		check(prefix(fmt.Sprintf("pools[%q].balancer", name), p.Balancer.validate()))
+	}
+
+	geoRules := false
+
+	if a := c.ACL; a != nil {
+		_, err := netx.ParseCIDRs(a.Allow...)
+		check(prefix("acl.allow", err))
+		_, err = netx.ParseCIDRs(a.Deny...)
+		check(prefix("acl.deny", err))
+		check(prefix("acl.allow_countries", validateCountries(a.AllowCountries)))
+		check(prefix("acl.deny_countries", validateCountries(a.DenyCountries)))
+		geoRules = len(a.AllowCountries) != 0 || len(a.DenyCountries) != 0 || len(a.DenyASNs) != 0
+	}
+
+	_, err := netx.ParseCIDRs(c.TrustedProxies...)
+	check(prefix("trusted_proxies", err))
+
+	switch http.CanonicalHeaderKey(c.ClientIPHeader) {
+	case "X-Forwarded-For", "Forwarded":
+	case "":
+		if len(c.TrustedProxies) != 0 {
+			check(errors.New("client_ip_header: required with trusted_proxies"))
+		}
+	default:
+		check(fmt.Errorf("client_ip_header: unsupported header: %q", c.ClientIPHeader))
+	}
+
+	for i, r := range c.Routes {
+		check(prefix(fmt.Sprintf("routes[%d].countries", i), validateCountries(r.Countries)))
+		geoRules = geoRules || len(r.Countries) != 0
+
+		if _, ok := c.Pools[r.Pool]; !ok {
+			check(fmt.Errorf("routes[%d]: unknown pool: %q", i, r.Pool))
+		}
+		if len(r.Path) != 0 && !strings.HasPrefix(r.Path, "/") {
+			check(fmt.Errorf("routes[%d]: the path must start with a slash: %q", i, r.Path))
+		}
+		if r.StripPrefix && c.Paths.PreserveRaw {
+			check(fmt.Errorf("routes[%d]: strip_prefix cannot be used when paths are forwarded raw", i))
+		}
+	}
+
+	if c.Paths.PreserveRaw && !c.Paths.Normalize {
+		check(errors.New("paths: preserve_raw requires normalize"))
+	}
+
+	if geoRules && len(c.GeoIP.Databases) == 0 {
+		check(errors.New("geoip: the rules based on countries or autonomous systems require databases"))
+	}
+
+	t := c.Timeouts
+	if t.Read < 0 || t.Write < 0 || t.Idle < 0 || t.Dial < 0 || t.ResponseHeader < 0 {
+		check(errors.New("timeouts: durations cannot be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b Balancer) validate() error {
+	switch b.Type {
+	case "", "round_robin", "hash", "peak_ewma":
+	default:
+		return fmt.Errorf("unknown type: %q", b.Type)
+	}
+
+	if b.Type == "hash" {
+		if _, _, err := parseKey(b.Key); err != nil {
+			return err
+		}
+	} else if len(b.Key) != 0 {
+		return errors.New("the key requires the hash balancer")
+	}
+
+	switch {
+	case b.Decay < 0:
+		return errors.New("the decay cannot be negative")
+	case b.Decay != 0 && b.Type != "peak_ewma":
+		return errors.New("the decay requires the peak_ewma balancer")
+	}
+
+	return nil
+}
+
+// parseKey splits the key of a hash balancer into its kind and, for headers
+// and cookies, the name of the field.
+func parseKey(key string) (kind string, name string, err error) {
+	kind, name, named := strings.Cut(key, ":")
+
+	switch kind {
+	case "":
+		err = errors.New("the hash balancer requires a key")
+	case "client_ip", "url":
+		if named {
+			err = fmt.Errorf("invalid key: %q", key)
+		}
+	case "header", "cookie":
+		if len(name) == 0 {
+			err = fmt.Errorf("missing name in key: %q", key)
+		}
+	default:
+		err = fmt.Errorf("unknown key: %q", key)
+	}
+
+	return
+}
+
+func validateCountries(countries []string) error {
+	for _, c := range countries {
+		if len(c) != 2 {
+			return fmt.Errorf("invalid country code: %q", c)
+		}
+	}
+	return nil
+}
+
+func prefix(p string, err error) error {
+	if err != nil {
+		err = fmt.Errorf("%s: %w", p, err)
+	}
+	return err
+}
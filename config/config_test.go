@@ -0,0 +1,298 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx/httpx"
+)
+
+const testConfig = `{
+  "listeners": [
+    {
+      "address": ":443",
+      "tls": { "cert_file": "edge.crt", "key_file": "edge.key" },
+      "limits": { "max_conns": 10000, "rate": 500, "burst": 1000 }
+    }
+  ],
+  "pools": {
+    "api": {
+      "backends": [
+        { "addr": "10.0.0.1:8080", "weight": 2 },
+        { "addr": "10.0.0.2:8080", "weight": 1 }
+      ]
+    }
+  },
+  "routes": [
+    { "host": "api.example.com", "path": "/v1/", "pool": "api" }
+  ],
+  "timeouts": { "read": "30s", "write": "30s", "idle": "90s", "dial": "5s" },
+  "admin": { "address": "127.0.0.1:9901" }
+}`
+
+const testConfigYAML = `# The same configuration as testConfig.
+listeners:
+  - address: ":443"
+    tls: { cert_file: edge.crt, key_file: edge.key }
+    limits:
+      max_conns: 10000
+      rate: 500
+      burst: 1000
+
+pools:
+  api:
+    backends:
+    - addr: 10.0.0.1:8080
+      weight: 2
+    - { addr: "10.0.0.2:8080", weight: 1 }
+
+routes:
+  - host: api.example.com
+    path: /v1/
+    pool: api
+
+timeouts: { read: 30s, write: 30s, idle: 90s, dial: 5s }
+admin:
+  address: '127.0.0.1:9901' # loopback only
+`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "netx.json")
+
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Config{
+		Listeners: []Listener{{
+			Address: ":443",
+			TLS:     &TLS{CertFile: "edge.crt", KeyFile: "edge.key"},
+			Limits:  Limits{MaxConns: 10000, Rate: 500, Burst: 1000},
+		}},
+		Pools: map[string]Pool{
+			"api": {Backends: []httpx.Backend{
+				{Addr: "10.0.0.1:8080", Weight: 2},
+				{Addr: "10.0.0.2:8080", Weight: 1},
+			}},
+		},
+		Routes: []Route{{Host: "api.example.com", Path: "/v1/", Pool: "api"}},
+		Timeouts: Timeouts{
+			Read:  Duration(30 * time.Second),
+			Write: Duration(30 * time.Second),
+			Idle:  Duration(90 * time.Second),
+			Dial:  Duration(5 * time.Second),
+		},
+		Admin: Admin{Address: "127.0.0.1:9901"},
+	}
+
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("bad configuration:\n%#v\n%#v", c, expected)
+	}
+
+	if _, err := Load(filepath.Join(dir, "netx.toml")); !os.IsNotExist(err) {
+		t.Error("bad error when loading a missing file:", err)
+	}
+
+	yamlPath := filepath.Join(dir, "netx.yaml")
+
+	if err := ioutil.WriteFile(yamlPath, []byte(testConfigYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := Load(yamlPath); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, expected) {
+		t.Errorf("bad YAML configuration:\n%#v\n%#v", c, expected)
+	}
+
+	tomlPath := filepath.Join(dir, "netx.toml")
+	ioutil.WriteFile(tomlPath, []byte("{}"), 0644)
+
+	if _, err := Load(tomlPath); err == nil || !strings.Contains(err.Error(), "unsupported configuration format") {
+		t.Error("bad error when loading an unsupported format:", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "unknown field",
+			config: `{"routes": [{"pool": "api", "prefix": "/"}]}`,
+			err:    `unknown field "prefix"`,
+		},
+		{
+			name:   "bad duration",
+			config: `{"timeouts": {"read": 30}}`,
+			err:    `durations must be strings`,
+		},
+		{
+			name:   "duplicate listener",
+			config: `{"listeners": [{"address": ":80"}, {"address": ":80"}]}`,
+			err:    `listeners[1]: duplicate address: :80`,
+		},
+		{
+			name:   "incomplete certificate",
+			config: `{"listeners": [{"address": ":443", "tls": {"cert_file": "edge.crt"}}]}`,
+			err:    `listeners[0].tls: cert_file and key_file must be set together`,
+		},
+		{
+			name:   "missing certificates",
+			config: `{"listeners": [{"address": ":443", "tls": {}}]}`,
+			err:    `listeners[0].tls: missing certificates`,
+		},
+		{
+			name:   "negative limits",
+			config: `{"listeners": [{"address": ":80", "limits": {"rate": -1}}]}`,
+			err:    `listeners[0].limits: limits cannot be negative`,
+		},
+		{
+			name:   "empty pool",
+			config: `{"pools": {"api": {"backends": []}}}`,
+			err:    `pools["api"]: no backends`,
+		},
+		{
+			name:   "duplicate backend",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}, {"addr": "a:80"}]}}}`,
+			err:    `pools["api"]: duplicate backend address: a:80`,
+		},
+		{
+			name:   "unknown pool",
+			config: `{"routes": [{"pool": "api"}]}`,
+			err:    `routes[0]: unknown pool: "api"`,
+		},
+		{
+			name:   "relative path",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}]}}, "routes": [{"path": "v1", "pool": "api"}]}`,
+			err:    `routes[0]: the path must start with a slash: "v1"`,
+		},
+		{
+			name:   "bad acl network",
+			config: `{"acl": {"deny": ["10.0.0.0/33"]}}`,
+			err:    `acl.deny: invalid CIDR address: 10.0.0.0/33`,
+		},
+		{
+			name:   "bad country",
+			config: `{"acl": {"deny_countries": ["France"]}}`,
+			err:    `acl.deny_countries: invalid country code: "France"`,
+		},
+		{
+			name:   "bad trusted proxy",
+			config: `{"trusted_proxies": ["proxy"], "client_ip_header": "X-Forwarded-For"}`,
+			err:    `trusted_proxies: invalid IP address: "proxy"`,
+		},
+		{
+			name:   "trusted proxies without client ip header",
+			config: `{"trusted_proxies": ["10.0.0.0/8"]}`,
+			err:    `client_ip_header: required with trusted_proxies`,
+		},
+		{
+			name:   "bad client ip header",
+			config: `{"trusted_proxies": ["10.0.0.0/8"], "client_ip_header": "X-Real-IP"}`,
+			err:    `client_ip_header: unsupported header: "X-Real-IP"`,
+		},
+		{
+			name:   "unknown balancer",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"type": "random"}}}}`,
+			err:    `pools["api"].balancer: unknown type: "random"`,
+		},
+		{
+			name:   "hash balancer without key",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"type": "hash"}}}}`,
+			err:    `pools["api"].balancer: the hash balancer requires a key`,
+		},
+		{
+			name:   "hash balancer with unknown key",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"type": "hash", "key": "query:id"}}}}`,
+			err:    `pools["api"].balancer: unknown key: "query:id"`,
+		},
+		{
+			name:   "hash balancer without header name",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"type": "hash", "key": "header:"}}}}`,
+			err:    `pools["api"].balancer: missing name in key: "header:"`,
+		},
+		{
+			name:   "key without hash balancer",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"key": "url"}}}}`,
+			err:    `pools["api"].balancer: the key requires the hash balancer`,
+		},
+		{
+			name:   "decay without peak_ewma balancer",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"type": "hash", "key": "url", "decay": "5s"}}}}`,
+			err:    `pools["api"].balancer: the decay requires the peak_ewma balancer`,
+		},
+		{
+			name:   "negative decay",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}], "balancer": {"type": "peak_ewma", "decay": "-5s"}}}}`,
+			err:    `pools["api"].balancer: the decay cannot be negative`,
+		},
+		{
+			name:   "missing geoip databases",
+			config: `{"pools": {"api": {"backends": [{"addr": "a:80"}]}}, "routes": [{"pool": "api", "countries": ["FR"]}]}`,
+			err:    `geoip: the rules based on countries or autonomous systems require databases`,
+		},
+		{
+			name:   "raw paths without normalization",
+			config: `{"paths": {"preserve_raw": true}}`,
+			err:    `paths: preserve_raw requires normalize`,
+		},
+		{
+			name:   "raw paths with prefix stripping",
+			config: `{"paths": {"normalize": true, "preserve_raw": true}, "pools": {"api": {"backends": [{"addr": "a:80"}]}}, "routes": [{"path": "/v1/", "pool": "api", "strip_prefix": true}]}`,
+			err:    `routes[0]: strip_prefix cannot be used when paths are forwarded raw`,
+		},
+		{
+			name:   "negative timeout",
+			config: `{"timeouts": {"idle": "-1s"}}`,
+			err:    `timeouts: durations cannot be negative`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.config), unmarshalJSON)
+			if err == nil {
+				t.Fatal("no error returned for an invalid configuration")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("bad error:\n%s\nexpected:\n%s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	c := &Config{
+		Listeners: []Listener{{}},
+		Routes:    []Route{{Pool: "api"}},
+	}
+
+	// All the problems are reported at once.
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("no error returned for an invalid configuration")
+	}
+
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 2 {
+		t.Error("bad error:", err)
+	}
+}
@@ -0,0 +1,477 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/netx"
+	"github.com/segmentio/netx/geoipx"
+	"github.com/segmentio/netx/httpx"
+	"github.com/segmentio/netx/tlsx"
+)
+
+// Proxy is a http.Handler which routes requests to pools of backends as
+// described by a Config.
+//
+// A new configuration is applied by calling Apply, which validates it then
+// swaps it atomically with the previous one: the requests in flight complete
+// with the configuration they started with, and the following ones use the
+// new routes. Pools are matched by name across configurations so the state of
+// their backends (like the requests in flight) is preserved.
+//
+// The listeners and the timeouts of client connections are only read when
+// the proxy starts, changing them requires a restart.
+type Proxy struct {
+	mutex      sync.Mutex // serializes calls to Apply
+	state      atomic.Value
+	admin      httpx.AdminHandler
+	pools      map[string]*httpx.BackendPool
+	transports map[string]*poolTransport
+	limits     map[string]*netx.RateLimitListener
+}
+
+// proxyState is a snapshot of the state of a Proxy, it is never modified after
+// it was stored.
+type proxyState struct {
+	config  *Config
+	routes  []proxyRoute
+	acl     *netx.ACL
+	lookup  netx.IPInfoLookup
+	trusted []*net.IPNet
+	geo     bool // some routes match the countries of clients
+}
+
+type proxyRoute struct {
+	Route
+	scheme string
+	proxy  *httpx.ReverseProxy
+}
+
+// DefaultDialTimeout is the timeout for establishing connections to backends
+// used when the configuration sets none.
+const DefaultDialTimeout = 10 * time.Second
+
+// openDatabase opens the IP metadata database at path, tests replace it so
+// they don't depend on database files.
+var openDatabase = func(path string) (netx.IPInfoLookup, error) {
+	return geoipx.Open(path)
+}
+
+// NewProxy returns a Proxy serving c.
+func NewProxy(c *Config) (*Proxy, error) {
+	p := &Proxy{}
+	if err := p.Apply(c); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Config returns the configuration currently applied to the proxy, the
+// returned value must not be modified.
+func (p *Proxy) Config() *Config {
+	if s := p.load(); s != nil {
+		return s.config
+	}
+	return nil
+}
+
+// Admin returns the admin API of the proxy, which exposes the pools of
+// backends, the rate limits of the listeners, and the current configuration.
+func (p *Proxy) Admin() http.Handler {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.admin.Config == nil {
+		p.admin.Config = func() interface{} { return p.Config() }
+	}
+
+	return &p.admin
+}
+
+// Apply validates c then makes it the configuration of the proxy. When an
+// error is returned the proxy keeps using the previous configuration.
+//
+// The changes made to the pools of backends through the admin API are lost
+// when a new configuration is applied.
+func (p *Proxy) Apply(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	var lookup netx.IPInfoLookup
+
+	if paths := c.GeoIP.Databases; len(paths) != 0 {
+		dbs := make(netx.MultiIPInfoLookup, len(paths))
+
+		for i, path := range paths {
+			db, err := openDatabase(path)
+			if err != nil {
+				return fmt.Errorf("geoip: %w", err)
+			}
+			dbs[i] = db
+		}
+
+		lookup = dbs
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.pools == nil {
+		p.pools = make(map[string]*httpx.BackendPool)
+		p.transports = make(map[string]*poolTransport)
+	}
+
+	for name := range p.pools {
+		if _, ok := c.Pools[name]; !ok {
+			p.admin.RemovePool(name)
+			p.transports[name].swap(nil)
+			delete(p.pools, name)
+			delete(p.transports, name)
+		}
+	}
+
+	// The configuration was validated so the networks cannot be rejected.
+	trusted, _ := netx.ParseCIDRs(c.TrustedProxies...)
+
+	prev := p.Config()
+	changed := prev == nil || prev.Timeouts.Dial != c.Timeouts.Dial || prev.Timeouts.ResponseHeader != c.Timeouts.ResponseHeader
+
+	for name, config := range c.Pools {
+		pool, transport := p.pools[name], p.transports[name]
+
+		if pool == nil {
+			transport = &poolTransport{}
+			transport.swap(newTransport(c.Timeouts))
+			pool = &httpx.BackendPool{Transport: transport, Dial: transport.DialContext}
+			p.pools[name], p.transports[name] = pool, transport
+			p.admin.HandlePool(name, pool)
+		} else if changed {
+			transport.swap(newTransport(c.Timeouts))
+		}
+
+		// The configuration was validated so the backends cannot be rejected.
+		pool.SetBackends(config.Backends)
+
+		// The balancers are only replaced when they change, so they keep the
+		// latency they observed.
+		if prev == nil || prev.Pools[name].Balancer != config.Balancer || prev.ClientIPHeader != c.ClientIPHeader || !slices.Equal(prev.TrustedProxies, c.TrustedProxies) {
+			pool.SetBalancer(newBalancer(config.Balancer, c.ClientIPHeader, trusted))
+		}
+	}
+
+	s := &proxyState{config: c, lookup: lookup, trusted: trusted}
+
+	if a := c.ACL; a != nil {
+		s.acl = &netx.ACL{
+			AllowCountries: a.AllowCountries,
+			DenyCountries:  a.DenyCountries,
+			DenyASNs:       a.DenyASNs,
+			Lookup:         lookup,
+		}
+		s.acl.Allow, _ = netx.ParseCIDRs(a.Allow...)
+		s.acl.Deny, _ = netx.ParseCIDRs(a.Deny...)
+	}
+
+	s.routes = make([]proxyRoute, len(c.Routes))
+
+	for i, r := range c.Routes {
+		s.geo = s.geo || len(r.Countries) != 0
+
+		pool := p.pools[r.Pool]
+		scheme := "http"
+
+		if c.Pools[r.Pool].TLS {
+			scheme = "https"
+		}
+
+		s.routes[i] = proxyRoute{
+			Route:  r,
+			scheme: scheme,
+			proxy: &httpx.ReverseProxy{
+				Transport:          pool,
+				DialContext:        pool.DialContext,
+				PreserveRequestURI: c.Paths.PreserveRaw,
+			},
+		}
+	}
+
+	for _, l := range c.Listeners {
+		if lstn := p.limits[l.Address]; lstn != nil {
+			lstn.SetLimit(l.Limits.Rate, l.Limits.Burst)
+		}
+	}
+
+	p.state.Store(s)
+	return nil
+}
+
+// newBalancer returns the balancer described by b, which was validated, or nil
+// for the round-robin balancing of pools.
+func newBalancer(b Balancer, header string, trusted []*net.IPNet) httpx.Balancer {
+	switch b.Type {
+	case "hash":
+		return &httpx.HashBalancer{Key: newKey(b.Key, header, trusted)}
+	case "peak_ewma":
+		return &httpx.EWMABalancer{Decay: time.Duration(b.Decay)}
+	default:
+		return nil
+	}
+}
+
+// newKey returns the function extracting the key of requests for a hash
+// balancer.
+func newKey(key, header string, trusted []*net.IPNet) func(*http.Request) string {
+	switch kind, name, _ := parseKey(key); kind {
+	case "client_ip":
+		return httpx.ClientIPKey(header, trusted)
+	case "url":
+		return httpx.URLKey()
+	case "header":
+		return httpx.HeaderKey(name)
+	default:
+		return httpx.CookieKey(name)
+	}
+}
+
+// Listen opens the listener described by l, applying its limits and TLS
+// configuration. The rate limit of the listener is updated when configurations
+// are applied and is exposed by the admin API under the listener address.
+func (p *Proxy) Listen(l Listener) (net.Listener, error) {
+	lstn, err := net.Listen("tcp", l.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Limits.MaxConns > 0 {
+		lstn = &netx.LimitListener{Listener: lstn, MaxConns: l.Limits.MaxConns}
+	}
+
+	rate := &netx.RateLimitListener{Listener: lstn, Rate: l.Limits.Rate, Burst: l.Limits.Burst}
+	lstn = rate
+
+	if t := l.TLS; t != nil {
+		if len(t.CertDir) != 0 {
+			var d *tlsx.CertDir
+
+			if d, err = tlsx.NewCertDir(t.CertDir); err == nil {
+				d.DefaultName = t.DefaultName
+				lstn = tls.NewListener(lstn, &tls.Config{GetCertificate: d.GetCertificate})
+			}
+		} else {
+			lstn, err = tlsx.NewListener(lstn, t.CertFile, t.KeyFile, nil)
+		}
+
+		if err != nil {
+			rate.Close()
+			return nil, err
+		}
+	}
+
+	p.mutex.Lock()
+	if p.limits == nil {
+		p.limits = make(map[string]*netx.RateLimitListener)
+	}
+	p.limits[l.Address] = rate
+	p.admin.HandleRateLimit(l.Address, rate)
+	p.mutex.Unlock()
+
+	return lstn, nil
+}
+
+// Server returns a http.Server serving the proxy with the timeouts of the
+// current configuration.
+func (p *Proxy) Server() *http.Server {
+	t := p.Config().Timeouts
+	return &http.Server{
+		Handler:      p,
+		ReadTimeout:  time.Duration(t.Read),
+		WriteTimeout: time.Duration(t.Write),
+		IdleTimeout:  time.Duration(t.Idle),
+	}
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := p.load()
+	if s == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	target := req.URL
+
+	if s.config.Paths.Normalize {
+		var err error
+
+		if target, err = normalizeURL(req.URL); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var ip net.IP
+	var country string
+
+	if s.acl != nil || s.geo {
+		ip = httpx.ClientIP(req, s.config.ClientIPHeader, s.trusted)
+	}
+
+	if s.acl != nil && (ip == nil || !s.acl.Allowed(ip)) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if s.geo && ip != nil {
+		// Clients of unknown origin only match the routes without countries.
+		info, _ := s.lookup.LookupIPInfo(ip)
+		country = info.Country
+	}
+
+	for i := range s.routes {
+		r := &s.routes[i]
+
+		if !r.match(req.Host, target.Path, country) {
+			continue
+		}
+
+		r2 := *req
+		u := *target
+
+		if s.config.Paths.PreserveRaw {
+			u = *req.URL
+		}
+
+		u.Scheme, u.Host = r.scheme, req.Host
+
+		if r.StripPrefix && len(r.Path) != 0 {
+			u.Path = "/" + strings.TrimLeft(strings.TrimPrefix(u.Path, r.Path), "/")
+			u.RawPath = ""
+		}
+
+		r2.URL = &u
+		r.proxy.ServeHTTP(w, &r2)
+		return
+	}
+
+	http.NotFound(w, req)
+}
+
+func (p *Proxy) load() *proxyState {
+	s, _ := p.state.Load().(*proxyState)
+	return s
+}
+
+func (r *proxyRoute) match(host string, path string, country string) bool {
+	return matchHost(r.Host, host) && strings.HasPrefix(path, r.Path) && matchCountry(r.Countries, country)
+}
+
+// normalizeURL returns a copy of u with a normalized path, see
+// httpx.NormalizePath.
+func normalizeURL(u *url.URL) (*url.URL, error) {
+	p, err := httpx.NormalizePath(u.EscapedPath())
+	if err != nil {
+		return nil, err
+	}
+
+	v := *u
+	// The path was validated by NormalizePath so it cannot be invalid.
+	v.Path, _ = url.PathUnescape(p)
+	v.RawPath = p
+	return &v, nil
+}
+
+// matchHost checks whether host matches pattern, which is either empty, a host
+// name, or a wildcard like *.example.com.
+func matchHost(pattern string, host string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix)
+	}
+
+	return strings.EqualFold(pattern, host)
+}
+
+// matchCountry checks whether country is one of countries, or countries is
+// empty.
+func matchCountry(countries []string, country string) bool {
+	if len(countries) == 0 {
+		return true
+	}
+	for _, c := range countries {
+		if len(country) != 0 && strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// poolTransport is the transport of the pools of a Proxy, it lets Apply change
+// the timeouts of the pools while they are serving requests.
+type poolTransport struct {
+	transport atomic.Value // *http.Transport
+}
+
+func newTransport(t Timeouts) *http.Transport {
+	dialTimeout := time.Duration(t.Dial)
+	if dialTimeout == 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ResponseHeaderTimeout: time.Duration(t.ResponseHeader),
+		TLSHandshakeTimeout:   dialTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   32,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func (t *poolTransport) load() *http.Transport {
+	return t.transport.Load().(*http.Transport)
+}
+
+// swap replaces the transport with x, the idle connections of the previous
+// transport are closed so it can be garbage collected once the requests in
+// flight complete. When x is nil only the idle connections are closed.
+func (t *poolTransport) swap(x *http.Transport) {
+	if prev, _ := t.transport.Load().(*http.Transport); prev != nil {
+		prev.CloseIdleConnections()
+	}
+	if x != nil {
+		t.transport.Store(x)
+	}
+}
+
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.load().RoundTrip(req)
+}
+
+func (t *poolTransport) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return t.load().DialContext(ctx, network, address)
+}
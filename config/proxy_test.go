@@ -0,0 +1,500 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+	"github.com/segmentio/netx/httpx"
+)
+
+func TestProxy(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name + " " + req.Host + " " + req.URL.Path))
+		}))
+	}
+
+	api := backend("api")
+	defer api.Close()
+
+	www := backend("www")
+	defer www.Close()
+
+	proxy, err := NewProxy(&Config{
+		Pools: map[string]Pool{
+			"api": {Backends: []httpx.Backend{{Addr: api.Listener.Addr().String(), Weight: 1}}},
+			"www": {Backends: []httpx.Backend{{Addr: www.Listener.Addr().String(), Weight: 1}}},
+		},
+		Routes: []Route{
+			{Host: "api.example.com", Path: "/v1/", Pool: "api", StripPrefix: true},
+			{Host: "*.example.com", Pool: "www"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+		body   string
+	}{
+		{"GET", "http://api.example.com/v1/users", http.StatusOK, "api api.example.com /users"},
+		{"GET", "http://api.example.com:8080/v1/", http.StatusOK, "api api.example.com:8080 /"},
+		{"GET", "http://api.example.com/v2/users", http.StatusOK, "www api.example.com /v2/users"},
+		{"GET", "http://WWW.example.com/", http.StatusOK, "www WWW.example.com /"},
+		{"GET", "http://example.com/", http.StatusNotFound, ""},
+		{"CONNECT", "http://api.example.com:443", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+
+			if w.Code != test.status {
+				t.Error("bad status:", w.Code)
+			}
+
+			if test.status == http.StatusOK && w.Body.String() != test.body {
+				t.Errorf("bad body: %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxyApply(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+
+	b1 := backend("b1")
+	defer b1.Close()
+
+	b2 := backend("b2")
+	defer b2.Close()
+
+	config := func(addr string) *Config {
+		return &Config{
+			Pools:  map[string]Pool{"api": {Backends: []httpx.Backend{{Addr: addr, Weight: 1}}}},
+			Routes: []Route{{Pool: "api"}},
+		}
+	}
+
+	get := func(h http.Handler, url string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+
+	proxy, err := NewProxy(config(b1.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, body := get(proxy, "http://localhost/"); body != "b1" {
+		t.Error("bad response:", body)
+	}
+
+	if err := proxy.Apply(config(b2.Listener.Addr().String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, body := get(proxy, "http://localhost/"); body != "b2" {
+		t.Error("the configuration was not applied:", body)
+	}
+
+	// Invalid configurations leave the proxy unchanged.
+	if err := proxy.Apply(&Config{Routes: []Route{{Pool: "www"}}}); err == nil {
+		t.Error("no error returned when applying an invalid configuration")
+	}
+
+	if _, body := get(proxy, "http://localhost/"); body != "b2" {
+		t.Error("an invalid configuration was applied:", body)
+	}
+
+	// The admin API exposes the pools of the configuration.
+	code, body := get(proxy.Admin(), "http://localhost/config")
+	if code != http.StatusOK {
+		t.Fatal("bad status:", code)
+	}
+
+	var state struct {
+		Backends map[string][]httpx.BackendStatus
+		Config   *Config
+	}
+
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	if b := state.Backends["api"]; len(b) != 1 || b[0].Addr != b2.Listener.Addr().String() {
+		t.Errorf("bad backends: %+v", state.Backends)
+	}
+
+	if state.Config == nil || len(state.Config.Routes) != 1 {
+		t.Errorf("bad config: %+v", state.Config)
+	}
+
+	// Pools that are removed from the configuration are removed from the
+	// admin API.
+	c := config(b1.Listener.Addr().String())
+	c.Pools = map[string]Pool{"www": c.Pools["api"]}
+	c.Routes[0].Pool = "www"
+
+	if err := proxy.Apply(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := get(proxy.Admin(), "http://localhost/backends/api"); code != http.StatusNotFound {
+		t.Error("the removed pool is still exposed by the admin API:", code)
+	}
+}
+
+func TestProxyListen(t *testing.T) {
+	proxy, err := NewProxy(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Listener{Address: "127.0.0.1:0", Limits: Limits{Rate: 10, Burst: 20}}
+
+	lstn, err := proxy.Listen(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	// The rate limit of listeners is updated by new configurations.
+	l.Limits.Rate = 100
+	if err := proxy.Apply(&Config{Listeners: []Listener{l}}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	proxy.Admin().ServeHTTP(w, httptest.NewRequest("GET", "/limits", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+
+	if !strings.Contains(string(body), `"rate": 100`) {
+		t.Errorf("bad rate limits: %s", body)
+	}
+
+	l.TLS = &TLS{CertFile: "missing.crt", KeyFile: "missing.key"}
+
+	if _, err := proxy.Listen(l); err == nil {
+		t.Error("no error returned when loading missing certificates")
+	}
+}
+
+func TestProxyGeoIP(t *testing.T) {
+	defer func(open func(string) (netx.IPInfoLookup, error)) { openDatabase = open }(openDatabase)
+
+	openDatabase = func(path string) (netx.IPInfoLookup, error) {
+		if path != "country.mmdb" {
+			return nil, os.ErrNotExist
+		}
+		return netx.IPInfoLookupFunc(func(ip net.IP) (netx.IPInfo, error) {
+			switch ip.String() {
+			case "192.0.2.1":
+				return netx.IPInfo{Country: "FR"}, nil
+			case "192.0.2.2":
+				return netx.IPInfo{Country: "US"}, nil
+			case "192.0.2.3":
+				return netx.IPInfo{Country: "KP"}, nil
+			}
+			return netx.IPInfo{}, nil
+		}), nil
+	}
+
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+
+	eu := backend("eu")
+	defer eu.Close()
+
+	us := backend("us")
+	defer us.Close()
+
+	config := &Config{
+		Pools: map[string]Pool{
+			"eu": {Backends: []httpx.Backend{{Addr: eu.Listener.Addr().String(), Weight: 1}}},
+			"us": {Backends: []httpx.Backend{{Addr: us.Listener.Addr().String(), Weight: 1}}},
+		},
+		Routes: []Route{
+			{Pool: "eu", Countries: []string{"FR", "DE"}},
+			{Pool: "us"},
+		},
+		ACL:            &ACL{Deny: []string{"198.51.100.0/24"}, DenyCountries: []string{"KP"}},
+		GeoIP:          GeoIP{Databases: []string{"country.mmdb"}},
+		TrustedProxies: []string{"10.0.0.0/8"},
+		ClientIPHeader: "X-Forwarded-For",
+	}
+
+	proxy, err := NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		status     int
+		body       string
+	}{
+		{"192.0.2.1:56789", "", http.StatusOK, "eu"},
+		{"192.0.2.2:56789", "", http.StatusOK, "us"},
+		{"192.0.2.4:56789", "", http.StatusOK, "us"},
+		{"192.0.2.3:56789", "", http.StatusForbidden, ""},
+		{"198.51.100.1:56789", "", http.StatusForbidden, ""},
+		{"10.0.0.1:56789", "192.0.2.1", http.StatusOK, "eu"},
+		{"10.0.0.1:56789", "192.0.2.3", http.StatusForbidden, ""},
+		{"192.0.2.2:56789", "192.0.2.1", http.StatusOK, "us"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.remoteAddr+" "+test.forwarded, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if len(test.forwarded) != 0 {
+				req.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != test.status {
+				t.Error("bad status:", w.Code)
+			}
+
+			if test.status == http.StatusOK && w.Body.String() != test.body {
+				t.Errorf("bad body: %q", w.Body.String())
+			}
+		})
+	}
+
+	// Configurations with databases that cannot be loaded are rejected.
+	c := *config
+	c.GeoIP.Databases = []string{"missing.mmdb"}
+
+	if err := proxy.Apply(&c); !errors.Is(err, os.ErrNotExist) {
+		t.Error("bad error when applying a configuration with a missing database:", err)
+	}
+}
+
+func TestProxyPaths(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name + " " + req.RequestURI))
+		}))
+	}
+
+	public := backend("public")
+	defer public.Close()
+
+	admin := backend("admin")
+	defer admin.Close()
+
+	config := func(paths Paths) *Config {
+		return &Config{
+			Pools: map[string]Pool{
+				"public": {Backends: []httpx.Backend{{Addr: public.Listener.Addr().String(), Weight: 1}}},
+				"admin":  {Backends: []httpx.Backend{{Addr: admin.Listener.Addr().String(), Weight: 1}}},
+			},
+			Routes: []Route{
+				{Path: "/admin/", Pool: "admin"},
+				{Pool: "public"},
+			},
+			Paths: paths,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		paths  Paths
+		target string
+		status int
+		body   string
+	}{
+		{
+			name:   "not normalized",
+			target: "/public/%2e%2e/admin/users",
+			status: http.StatusOK,
+			body:   "public /public/%2e%2e/admin/users",
+		},
+		{
+			name:   "normalized",
+			paths:  Paths{Normalize: true},
+			target: "/public/%2e%2e/admin/users",
+			status: http.StatusOK,
+			body:   "admin /admin/users",
+		},
+		{
+			name:   "normalized escapes",
+			paths:  Paths{Normalize: true},
+			target: "/%7Euser/a%2fb",
+			status: http.StatusOK,
+			body:   "public /~user/a%2Fb",
+		},
+		{
+			name:   "raw",
+			paths:  Paths{Normalize: true, PreserveRaw: true},
+			target: "/public/%2e%2e/admin/%7eusers",
+			status: http.StatusOK,
+			body:   "admin /public/%2e%2e/admin/%7eusers",
+		},
+		{
+			name:   "encoded NUL",
+			paths:  Paths{Normalize: true},
+			target: "/a%00b",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy, err := NewProxy(config(test.paths))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+test.target, nil))
+
+			if w.Code != test.status {
+				t.Error("bad status:", w.Code)
+			}
+
+			if test.status == http.StatusOK && w.Body.String() != test.body {
+				t.Errorf("bad body: %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxyBalancer(t *testing.T) {
+	var backends []httpx.Backend
+
+	for i := 0; i < 3; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, req.Context().Value(http.LocalAddrContextKey))
+		}))
+		defer server.Close()
+		backends = append(backends, httpx.Backend{Addr: server.Listener.Addr().String(), Weight: 1})
+	}
+
+	config := func(b Balancer) *Config {
+		return &Config{
+			Pools:          map[string]Pool{"api": {Backends: backends, Balancer: b}},
+			Routes:         []Route{{Pool: "api"}},
+			TrustedProxies: []string{"192.0.2.0/24"},
+			ClientIPHeader: "X-Forwarded-For",
+		}
+	}
+
+	proxy, err := NewProxy(config(Balancer{Type: "hash", Key: "client_ip"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(client string) string {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("X-Forwarded-For", client)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatal("bad status:", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	for _, client := range []string{"198.51.100.1", "198.51.100.2", "203.0.113.1"} {
+		first := get(client)
+		for i := 0; i < 5; i++ {
+			if addr := get(client); addr != first {
+				t.Errorf("client %s was sent to %s then %s", client, first, addr)
+			}
+		}
+	}
+
+	// Applying a configuration without balancer restores round-robin.
+	if err := proxy.Apply(config(Balancer{})); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[get("198.51.100.1")] = true
+	}
+	if len(seen) != 3 {
+		t.Error("requests were not balanced across the backends:", seen)
+	}
+}
+
+func TestProxyBalancerReload(t *testing.T) {
+	config := func(b Balancer, trusted ...string) *Config {
+		return &Config{
+			Pools:          map[string]Pool{"api": {Backends: []httpx.Backend{{Addr: "a:80", Weight: 1}}, Balancer: b}},
+			Routes:         []Route{{Pool: "api"}},
+			TrustedProxies: trusted,
+			ClientIPHeader: "X-Forwarded-For",
+		}
+	}
+
+	ewma := Balancer{Type: "peak_ewma", Decay: Duration(5 * time.Second)}
+
+	proxy, err := NewProxy(config(ewma))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := proxy.pools["api"]
+	balancer, ok := pool.Balancer.(*httpx.EWMABalancer)
+	if !ok {
+		t.Fatalf("bad balancer: %T", pool.Balancer)
+	}
+	if balancer.Decay != 5*time.Second {
+		t.Error("bad decay:", balancer.Decay)
+	}
+
+	// The balancer keeps its state when the configuration doesn't change it.
+	if err := proxy.Apply(config(ewma)); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Balancer != balancer {
+		t.Error("the balancer was replaced by an identical configuration")
+	}
+
+	if err := proxy.Apply(config(Balancer{Type: "hash", Key: "client_ip"})); err != nil {
+		t.Fatal(err)
+	}
+	hash := pool.Balancer
+	if _, ok := hash.(*httpx.HashBalancer); !ok {
+		t.Fatalf("bad balancer: %T", hash)
+	}
+
+	// Hash balancers use the trusted proxies to resolve client addresses.
+	if err := proxy.Apply(config(Balancer{Type: "hash", Key: "client_ip"}, "10.0.0.0/8")); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Balancer == hash {
+		t.Error("the balancer was not replaced when the trusted proxies changed")
+	}
+}
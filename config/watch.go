@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// Watcher reloads a configuration file when it changes, or when the program
+// receives a signal (SIGHUP by default):
+//
+//	proxy, _ := config.NewProxy(c)
+//	watcher := &config.Watcher{Path: path, Apply: proxy.Apply}
+//	go watcher.Run(ctx)
+//
+// The file is watched with inotify on linux, and polled on all platforms.
+// Reloads which find the file unchanged do nothing, and configurations that
+// fail to load or to apply are reported to the logger while the previous one
+// remains in use.
+type Watcher struct {
+	// Path is the path to the configuration file.
+	Path string
+
+	// Apply is called with each new version of the configuration.
+	Apply func(*Config) error
+
+	// Logger, if not nil, is used to report reloads and their errors.
+	Logger netx.Logger
+
+	// Interval is the amount of time between two checks of the file. If zero,
+	// DefaultWatchInterval is used, a negative value disables polling.
+	Interval time.Duration
+
+	// Signals is the list of signals which trigger a reload. If nil, SIGHUP
+	// is used, an empty list disables reloads on signals.
+	Signals []os.Signal
+
+	mutex sync.Mutex
+	sum   []byte
+}
+
+// DefaultWatchInterval is the default interval at which a Watcher checks the
+// configuration file for changes.
+const DefaultWatchInterval = 10 * time.Second
+
+// Run applies the configuration file then reloads it when it changes, until ctx
+// is canceled. It returns an error if the initial configuration could not be
+// applied, otherwise it returns ctx.Err().
+func (w *Watcher) Run(ctx context.Context) error {
+	if err := w.Reload(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	signals := w.Signals
+	if signals == nil {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+
+	sigchan := make(chan os.Signal, 1)
+	if len(signals) != 0 {
+		signal.Notify(sigchan, signals...)
+		defer signal.Stop(sigchan)
+	}
+
+	var tick <-chan time.Time
+
+	switch interval := w.Interval; {
+	case interval == 0:
+		interval = DefaultWatchInterval
+		fallthrough
+	case interval > 0:
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	events, err := watchFile(ctx, w.Path)
+	if err != nil {
+		w.logger().Warn("watching configuration file", "path", w.Path, "err", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sig := <-sigchan:
+			w.logger().Info("reloading configuration", "path", w.Path, "signal", sig)
+		case <-tick:
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+		}
+
+		if err := w.Reload(); err != nil {
+			w.logger().Error("reloading configuration", "path", w.Path, "err", err)
+		}
+	}
+}
+
+// Reload loads the configuration file and passes it to Apply if it changed
+// since the last configuration that was successfully applied.
+func (w *Watcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	b, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(b)
+	if bytes.Equal(sum[:], w.sum) {
+		return nil
+	}
+
+	c, err := parseFile(w.Path, b)
+	if err != nil {
+		return err
+	}
+
+	if err := w.Apply(c); err != nil {
+		return err
+	}
+
+	w.sum = sum[:]
+	w.logger().Info("configuration applied", "path", w.Path)
+	return nil
+}
+
+func (w *Watcher) logger() netx.Logger {
+	if w.Logger != nil {
+		return w.Logger
+	}
+	return netx.DiscardLogger
+}
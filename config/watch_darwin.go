@@ -0,0 +1,8 @@
+package config
+
+import "context"
+
+// watchFile is not implemented on this platform, the file is only polled.
+func watchFile(ctx context.Context, path string) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "netx.json")
+	write := func(s string) {
+		// The file is replaced atomically, like editors and deployment
+		// tools do.
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"admin": {"address": "127.0.0.1:1"}}`)
+
+	configs := make(chan *Config, 10)
+	w := &Watcher{
+		Path: path,
+		Apply: func(c *Config) error {
+			if c.Admin.Address == "refused" {
+				return errors.New("refused")
+			}
+			configs <- c
+			return nil
+		},
+		Interval: 50 * time.Millisecond,
+		Signals:  []os.Signal{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	next := func() string {
+		select {
+		case c := <-configs:
+			return c.Admin.Address
+		case err := <-done:
+			t.Fatal("the watcher stopped:", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the configuration to be applied")
+		}
+		return ""
+	}
+
+	if addr := next(); addr != "127.0.0.1:1" {
+		t.Error("bad initial configuration:", addr)
+	}
+
+	// Invalid configurations, and configurations that the program refuses,
+	// are not applied.
+	write(`{"admin": {"address": 42}}`)
+	write(`{"admin": {"address": "refused"}}`)
+	write(`{"admin": {"address": "127.0.0.1:2"}}`)
+
+	if addr := next(); addr != "127.0.0.1:2" {
+		t.Error("bad configuration after reload:", addr)
+	}
+
+	// Reloads which find the file unchanged don't apply it again.
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-configs:
+		t.Error("the configuration was applied again:", c.Admin.Address)
+	default:
+	}
+
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Error("bad error returned by the watcher:", err)
+	}
+}
+
+func TestWatcherInitialError(t *testing.T) {
+	w := &Watcher{
+		Path:  filepath.Join(os.TempDir(), "missing-netx-config.json"),
+		Apply: func(*Config) error { return nil },
+	}
+
+	if err := w.Run(context.Background()); !os.IsNotExist(err) {
+		t.Error("bad error when the configuration file is missing:", err)
+	}
+}
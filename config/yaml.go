@@ -0,0 +1,805 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unmarshalYAML decodes the YAML document in b into v. The document is
+// converted to JSON first so v is decoded with the json struct tags and the
+// json.Unmarshaler implementations, rejecting unknown fields like JSON files.
+//
+// Only the subset of YAML which is useful in configuration files is supported:
+// block and flow collections, plain and quoted scalars, literal and folded
+// block scalars, and comments. Anchors, aliases, tags, complex keys, and
+// streams of multiple documents are rejected.
+func unmarshalYAML(b []byte, v interface{}) error {
+	p := newYAMLParser(string(b))
+
+	doc, err := p.parseDocument()
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("yaml: %w", err)
+	}
+
+	return unmarshalJSON(j, v)
+}
+
+type yamlLine struct {
+	num    int    // line number, starting at 1
+	indent int    // number of spaces before the text
+	text   string // content of the line without the indentation and comments
+	raw    string // content of the line, used for block scalars
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+	err   error
+}
+
+func newYAMLParser(s string) *yamlParser {
+	s = strings.TrimPrefix(s, "\uFEFF")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+
+	raw := strings.Split(s, "\n")
+	lines := make([]yamlLine, len(raw))
+
+	for i, line := range raw {
+		text := strings.TrimLeft(line, " ")
+		lines[i] = yamlLine{
+			num:    i + 1,
+			indent: len(line) - len(text),
+			text:   strings.TrimRight(stripYAMLComment(text), " \t"),
+			raw:    line,
+		}
+	}
+
+	return &yamlParser{lines: lines}
+}
+
+func (p *yamlParser) errorf(line yamlLine, msg string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", line.num, fmt.Sprintf(msg, args...))
+}
+
+// next skips the blank and comment lines, it returns the next line holding
+// content and true, or false at the end of the document.
+//
+// Lines indented with tabs also end the document, the error is reported by
+// parseDocument.
+func (p *yamlParser) next() (yamlLine, bool) {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if strings.HasPrefix(line.text, "\t") {
+			if p.err == nil {
+				p.err = p.errorf(line, "tabs cannot be used for indentation")
+			}
+			return yamlLine{}, false
+		}
+		if len(line.text) != 0 {
+			return line, true
+		}
+		p.pos++
+	}
+	return yamlLine{}, false
+}
+
+func (p *yamlParser) parseDocument() (interface{}, error) {
+	line, ok := p.next()
+
+	for ok && strings.HasPrefix(line.text, "%") {
+		p.pos++
+		line, ok = p.next()
+	}
+
+	if ok && line.indent == 0 && line.text == "---" {
+		p.pos++
+		line, ok = p.next()
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	doc, err := p.parseNode(line.indent)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if line, ok := p.next(); ok {
+		if isYAMLDocumentMarker(line) {
+			if line.text == "---" {
+				return nil, p.errorf(line, "multiple documents are not supported")
+			}
+			p.pos++
+			if line, ok := p.next(); ok {
+				return nil, p.errorf(line, "unexpected content after the end of the document")
+			}
+		} else {
+			return nil, p.errorf(line, "unexpected content, the indentation may be wrong")
+		}
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	return doc, nil
+}
+
+// parseNode parses the node starting on the current line, which must be
+// indented with indent spaces.
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	line, _ := p.next()
+
+	switch {
+	case isYAMLSequenceItem(line.text):
+		return p.parseSequence(indent)
+	case isYAMLKey(line.text):
+		return p.parseMapping(indent)
+	}
+
+	p.pos++
+	return p.parseValue(line, line.text, indent-1)
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+
+	for {
+		line, ok := p.next()
+		if !ok || line.indent < indent || isYAMLDocumentMarker(line) {
+			return seq, nil
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "bad indentation of a sequence item")
+		}
+		if !isYAMLSequenceItem(line.text) {
+			if isYAMLKey(line.text) {
+				return seq, nil // the sequence was the value of a mapping key
+			}
+			return nil, p.errorf(line, "expected a sequence item")
+		}
+
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var item interface{}
+		var err error
+
+		switch {
+		case len(rest) == 0:
+			p.pos++
+			if next, ok := p.next(); ok && next.indent > indent {
+				item, err = p.parseNode(next.indent)
+			}
+
+		case isYAMLSequenceItem(rest) || isYAMLKey(rest):
+			// The item is a collection starting on the same line as the dash,
+			// the line is parsed again as if the collection started on its own
+			// line, at the column of its first character.
+			offset := len(line.text) - len(rest)
+			p.lines[p.pos].indent += offset
+			p.lines[p.pos].text = rest
+			p.lines[p.pos].raw = strings.Repeat(" ", line.indent+offset) + rest
+			item, err = p.parseNode(line.indent + offset)
+
+		default:
+			p.pos++
+			item, err = p.parseValue(line, rest, indent)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+
+	for {
+		line, ok := p.next()
+		if !ok || line.indent < indent || isYAMLDocumentMarker(line) {
+			return m, nil
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "bad indentation of a mapping key")
+		}
+		if isYAMLSequenceItem(line.text) {
+			return nil, p.errorf(line, "unexpected sequence item in a mapping")
+		}
+
+		key, rest, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, p.errorf(line, "%s", err)
+		}
+		if _, exists := m[key]; exists {
+			return nil, p.errorf(line, "duplicate key: %q", key)
+		}
+		p.pos++
+
+		var value interface{}
+
+		if len(rest) == 0 {
+			// Sequences may be indented at the same level as their key.
+			if next, ok := p.next(); ok && (next.indent > indent || (next.indent == indent && isYAMLSequenceItem(next.text))) {
+				value, err = p.parseNode(next.indent)
+			}
+		} else {
+			value, err = p.parseValue(line, rest, indent)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+}
+
+// parseValue parses the value s found on line, after a key or a sequence item
+// indicator of a collection indented with parent spaces.
+func (p *yamlParser) parseValue(line yamlLine, s string, parent int) (interface{}, error) {
+	switch s[0] {
+	case '|', '>':
+		return p.parseBlockScalar(line, s, parent)
+
+	case '[', '{':
+		// Flow collections may span multiple lines, as long as the following
+		// lines are indented more than the parent collection.
+		for !isYAMLFlowClosed(s) {
+			next, ok := p.next()
+			if !ok || next.indent <= parent {
+				return nil, p.errorf(line, "unterminated flow collection")
+			}
+			s += " " + next.text
+			p.pos++
+		}
+	}
+
+	f := &yamlFlow{s: s}
+
+	v, err := f.parseValue()
+	if err == nil {
+		if f.skipSpaces(); f.i != len(f.s) {
+			err = fmt.Errorf("unexpected characters after the value: %q", f.s[f.i:])
+		}
+	}
+	if err != nil {
+		return nil, p.errorf(line, "%s", err)
+	}
+
+	return v, nil
+}
+
+// parseBlockScalar parses a literal (|) or folded (>) block scalar, which
+// holds the lines following line indented more than parent.
+func (p *yamlParser) parseBlockScalar(line yamlLine, header string, parent int) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := byte(0)
+	indent := 0
+	auto := true
+
+	for _, c := range []byte(header[1:]) {
+		switch {
+		case (c == '-' || c == '+') && chomp == 0:
+			chomp = c
+		case c >= '1' && c <= '9' && indent == 0:
+			indent, auto = max(parent, 0)+int(c-'0'), false
+		default:
+			return nil, p.errorf(line, "bad block scalar header: %q", header)
+		}
+	}
+
+	var lines []string
+
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos].raw
+
+		if len(strings.TrimSpace(raw)) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+
+		n := len(raw) - len(strings.TrimLeft(raw, " "))
+		if auto {
+			indent, auto = n, false
+		}
+		if n <= parent || n < indent {
+			break
+		}
+		lines = append(lines, raw[indent:])
+	}
+
+	// Trailing blank lines belong to the block scalar only if it keeps them,
+	// otherwise they may precede the next key.
+	end := len(lines)
+	for end > 0 && len(lines[end-1]) == 0 {
+		end--
+	}
+	trailing := len(lines) - end
+	lines = lines[:end]
+
+	var s string
+
+	if folded {
+		var b strings.Builder
+		for i, l := range lines {
+			if i > 0 {
+				prev := lines[i-1]
+				switch {
+				case isYAMLFoldable(prev) && isYAMLFoldable(l):
+					b.WriteByte(' ')
+				case isYAMLFoldable(prev) && len(l) == 0:
+					// The line break is trimmed when followed by blank
+					// lines, which are each turned into a line break.
+				default:
+					b.WriteByte('\n')
+				}
+			}
+			b.WriteString(l)
+		}
+		s = b.String()
+	} else {
+		s = strings.Join(lines, "\n")
+	}
+
+	switch {
+	case len(lines) == 0:
+	case chomp == '-':
+	case chomp == '+':
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+
+	return s, nil
+}
+
+// isYAMLFoldable returns true if the line breaks around l are folded into
+// spaces in folded block scalars, which is the case when the line is not blank
+// nor more indented than the others.
+func isYAMLFoldable(l string) bool {
+	return len(l) != 0 && l[0] != ' ' && l[0] != '\t'
+}
+
+// yamlFlow parses the values found on a single line, scalars or flow
+// collections.
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func (f *yamlFlow) skipSpaces() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) parseValue() (interface{}, error) {
+	return f.parse(false)
+}
+
+// parse parses the value at the current position, inFlow is true when the
+// value is nested in a flow collection where the ',', ']', and '}' characters
+// terminate plain scalars.
+func (f *yamlFlow) parse(inFlow bool) (interface{}, error) {
+	f.skipSpaces()
+
+	if f.i == len(f.s) {
+		return nil, nil
+	}
+
+	switch c := f.s[f.i]; c {
+	case '[':
+		return f.parseSequence()
+	case '{':
+		return f.parseMapping()
+	case '"', '\'':
+		return f.parseQuoted()
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases, and tags are not supported")
+	case '?':
+		return nil, fmt.Errorf("complex keys are not supported")
+	case '@', '`':
+		return nil, fmt.Errorf("plain scalars cannot start with %q", c)
+	}
+
+	s := f.parsePlain(inFlow)
+
+	if !inFlow && f.i < len(f.s) && f.s[f.i] == ':' {
+		// The mapping indicator isn't allowed in the plain scalars of block
+		// collections, "a: b: c" is an error rather than the string "b: c".
+		return nil, fmt.Errorf("mapping values are not allowed in plain scalars, the value must be quoted")
+	}
+
+	return resolveYAMLScalar(s), nil
+}
+
+// parsePlain returns the text of the plain scalar at the current position,
+// which ends at the first ':' followed by a space or the end of the line.
+func (f *yamlFlow) parsePlain(inFlow bool) string {
+	start := f.i
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		if inFlow && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		if c == ':' && (f.i+1 == len(f.s) || strings.IndexByte(" \t", f.s[f.i+1]) >= 0 || (inFlow && strings.IndexByte(",]}", f.s[f.i+1]) >= 0)) {
+			break
+		}
+		f.i++
+	}
+	return strings.TrimRight(f.s[start:f.i], " \t")
+}
+
+// parseKey parses the key of a flow mapping entry, plain keys are not resolved
+// so they keep the text of the configuration file.
+func (f *yamlFlow) parseKey() (string, error) {
+	if f.skipSpaces(); f.i < len(f.s) {
+		switch f.s[f.i] {
+		case '"', '\'':
+			k, err := f.parseQuoted()
+			if err != nil {
+				return "", err
+			}
+			return k.(string), nil
+		case '[', '{':
+			return "", fmt.Errorf("mapping keys must be scalars")
+		case '&', '*', '!', '?':
+			return "", fmt.Errorf("anchors, aliases, tags, and complex keys are not supported")
+		}
+	}
+	if key := f.parsePlain(true); len(key) != 0 {
+		return key, nil
+	}
+	return "", fmt.Errorf("mapping keys cannot be empty")
+}
+
+func (f *yamlFlow) parseSequence() (interface{}, error) {
+	seq := []interface{}{}
+	f.i++ // [
+
+	for {
+		f.skipSpaces()
+		if f.i == len(f.s) {
+			return nil, fmt.Errorf("unterminated flow sequence")
+		}
+		if f.s[f.i] == ']' {
+			f.i++
+			return seq, nil
+		}
+
+		v, err := f.parse(true)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+
+		if err := f.parseSeparator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) parseMapping() (interface{}, error) {
+	m := map[string]interface{}{}
+	f.i++ // {
+
+	for {
+		f.skipSpaces()
+		if f.i == len(f.s) {
+			return nil, fmt.Errorf("unterminated flow mapping")
+		}
+		if f.s[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+
+		key, err := f.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := m[key]; exists {
+			return nil, fmt.Errorf("duplicate key: %q", key)
+		}
+
+		var value interface{}
+
+		if f.skipSpaces(); f.i < len(f.s) && f.s[f.i] == ':' {
+			f.i++
+			if value, err = f.parse(true); err != nil {
+				return nil, err
+			}
+		}
+		m[key] = value
+
+		if err := f.parseSeparator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseSeparator consumes the comma following an item of a flow collection,
+// or leaves the closing character to be consumed by the caller.
+func (f *yamlFlow) parseSeparator(end byte) error {
+	f.skipSpaces()
+	switch {
+	case f.i == len(f.s):
+		return fmt.Errorf("unterminated flow collection")
+	case f.s[f.i] == ',':
+		f.i++
+	case f.s[f.i] != end:
+		return fmt.Errorf("expected ',' or '%c' in flow collection but found %q", end, f.s[f.i])
+	}
+	return nil
+}
+
+func (f *yamlFlow) parseQuoted() (interface{}, error) {
+	quote := f.s[f.i]
+	f.i++
+
+	var b strings.Builder
+
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		f.i++
+
+		switch {
+		case c == quote && quote == '\'' && f.i < len(f.s) && f.s[f.i] == '\'':
+			b.WriteByte('\'')
+			f.i++
+
+		case c == quote:
+			return b.String(), nil
+
+		case c == '\\' && quote == '"':
+			if err := f.parseEscape(&b); err != nil {
+				return nil, err
+			}
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return nil, fmt.Errorf("unterminated quoted string")
+}
+
+func (f *yamlFlow) parseEscape(b *strings.Builder) error {
+	if f.i == len(f.s) {
+		return fmt.Errorf("unterminated escape sequence")
+	}
+
+	c := f.s[f.i]
+	f.i++
+
+	switch c {
+	case '0':
+		b.WriteByte(0)
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 't', '\t':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'v':
+		b.WriteByte('\v')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case ' ', '"', '/', '\\':
+		b.WriteByte(c)
+	case 'x', 'u', 'U':
+		n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		if f.i+n > len(f.s) {
+			return fmt.Errorf("bad escape sequence: \\%c", c)
+		}
+		r, err := strconv.ParseUint(f.s[f.i:f.i+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("bad escape sequence: \\%c%s", c, f.s[f.i:f.i+n])
+		}
+		b.WriteRune(rune(r))
+		f.i += n
+	default:
+		return fmt.Errorf("bad escape sequence: \\%c", c)
+	}
+
+	return nil
+}
+
+// resolveYAMLScalar returns the value of the plain scalar s, following the
+// core schema of YAML 1.2.
+func resolveYAMLScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+
+	base, digits := 10, s
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		base, digits = 16, s[2:]
+	case strings.HasPrefix(s, "0o"):
+		base, digits = 8, s[2:]
+	}
+
+	if !strings.ContainsAny(digits, "_") {
+		if i, err := strconv.ParseInt(digits, base, 64); err == nil {
+			return json.Number(strconv.FormatInt(i, 10))
+		}
+	}
+
+	if isYAMLFloat(s) {
+		if x, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(strconv.FormatFloat(x, 'g', -1, 64))
+		}
+	}
+
+	return s
+}
+
+// isYAMLFloat returns true if s matches [-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?
+func isYAMLFloat(s string) bool {
+	i := 0
+	digits := func() int {
+		n := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i, n = i+1, n+1
+		}
+		return n
+	}
+
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+
+	n := digits()
+	if i < len(s) && s[i] == '.' {
+		i++
+		n += digits()
+	}
+	if n == 0 {
+		return false
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+
+	return i == len(s)
+}
+
+// isYAMLDocumentMarker returns true if line starts (---) or ends (...) a
+// document.
+func isYAMLDocumentMarker(line yamlLine) bool {
+	return line.indent == 0 && (line.text == "---" || line.text == "...")
+}
+
+// isYAMLSequenceItem returns true if s starts with a sequence item indicator.
+func isYAMLSequenceItem(s string) bool {
+	return s == "-" || strings.HasPrefix(s, "- ")
+}
+
+// isYAMLKey returns true if s starts with a mapping key of a block mapping.
+func isYAMLKey(s string) bool {
+	if len(s) == 0 || s[0] == '[' || s[0] == '{' {
+		return false
+	}
+	_, _, err := splitYAMLKey(s)
+	return err == nil
+}
+
+// splitYAMLKey splits s, a line of a block mapping, into the key and the rest
+// of the line holding the value.
+func splitYAMLKey(s string) (key string, rest string, err error) {
+	f := &yamlFlow{s: s}
+
+	switch s[0] {
+	case '&', '*', '!', '?':
+		return "", "", fmt.Errorf("anchors, aliases, tags, and complex keys are not supported")
+	}
+
+	if s[0] == '"' || s[0] == '\'' {
+		k, err := f.parseQuoted()
+		if err != nil {
+			return "", "", err
+		}
+		key = k.(string)
+		f.skipSpaces()
+		if f.i == len(s) || s[f.i] != ':' || (f.i+1 < len(s) && s[f.i+1] != ' ' && s[f.i+1] != '\t') {
+			return "", "", fmt.Errorf("expected ':' after the key %q", key)
+		}
+		return key, strings.TrimSpace(s[f.i+1:]), nil
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ' || s[i+1] == '\t') {
+			return strings.TrimRight(s[:i], " \t"), strings.TrimSpace(s[i+1:]), nil
+		}
+	}
+
+	return "", "", fmt.Errorf("expected a mapping key")
+}
+
+// isYAMLFlowClosed returns true if all the flow collections opened in s are
+// closed.
+func isYAMLFlowClosed(s string) bool {
+	depth := 0
+
+	scanYAMLLine(s, func(i int) bool {
+		switch s[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+		return true
+	})
+
+	return depth <= 0
+}
+
+// stripYAMLComment removes the comment at the end of s, comments start with a
+// '#' character at the beginning of the line or following a space.
+func stripYAMLComment(s string) string {
+	end := len(s)
+
+	scanYAMLLine(s, func(i int) bool {
+		if s[i] == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t') {
+			end = i
+			return false
+		}
+		return true
+	})
+
+	return s[:end]
+}
+
+// scanYAMLLine calls f with the index of each character of s which is not part
+// of a quoted scalar, until f returns false.
+func scanYAMLLine(s string, f func(int) bool) {
+	quote := byte(0)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,:", s[i-1]) >= 0):
+			quote = c
+		case !f(i):
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		json string
+	}{
+		{
+			name: "empty",
+			yaml: "# nothing\n",
+			json: `null`,
+		},
+		{
+			name: "scalars",
+			yaml: "a: 1\nb: -2.5\nc: true\nd: ~\ne: hello world\nf: \"quoted # not a comment\"\ng: 'it''s'\nh: 0x1f\ni: 010\nj: 30s # comment\nk:\n",
+			json: `{"a":1,"b":-2.5,"c":true,"d":null,"e":"hello world","f":"quoted # not a comment","g":"it's","h":31,"i":10,"j":"30s","k":null}`,
+		},
+		{
+			name: "escapes",
+			yaml: `a: "tab\there\n\u00e9\x41"`,
+			json: `{"a":"tab\there\néA"}`,
+		},
+		{
+			name: "nested",
+			yaml: "---\na:\n  b:\n    c: 1\n  d: [1, two, {e: f}]\n",
+			json: `{"a":{"b":{"c":1},"d":[1,"two",{"e":"f"}]}}`,
+		},
+		{
+			name: "sequences",
+			yaml: "a:\n- 1\n- - 2\n  - 3\n- b: 4\n  c: 5\n-\n  d: 6\ne: []\n",
+			json: `{"a":[1,[2,3],{"b":4,"c":5},{"d":6}],"e":[]}`,
+		},
+		{
+			name: "top-level sequence",
+			yaml: "- a\n- b\n",
+			json: `["a","b"]`,
+		},
+		{
+			name: "multi-line flow",
+			yaml: "a: [\n  1,\n  2,\n  ]\nb: {c: d,\n  e: f}\n",
+			json: `{"a":[1,2],"b":{"c":"d","e":"f"}}`,
+		},
+		{
+			name: "literal block",
+			yaml: "a: |\n  line 1\n    line 2\n\n  line 3\n\nb: |-\n  x\n",
+			json: `{"a":"line 1\n  line 2\n\nline 3\n","b":"x"}`,
+		},
+		{
+			name: "folded block",
+			yaml: "a: >\n  one\n  two\n\n  three\n    indented\n  four\n",
+			json: `{"a":"one two\nthree\n  indented\nfour\n"}`,
+		},
+		{
+			name: "keys",
+			yaml: "\"quoted key\": 1\n8080: 2\n1.10: 3\n",
+			json: `{"1.10":3,"8080":2,"quoted key":1}`,
+		},
+		{
+			name: "windows line endings",
+			yaml: "a: 1\r\nb: 2\r\n",
+			json: `{"a":1,"b":2}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var v interface{}
+
+			if err := unmarshalYAML([]byte(test.yaml), &v); err != nil {
+				t.Fatal(err)
+			}
+
+			b, _ := json.Marshal(v)
+			if string(b) != test.json {
+				t.Errorf("bad value:\n%s\n%s", b, test.json)
+			}
+		})
+	}
+}
+
+func TestUnmarshalYAMLError(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{
+			name: "tabs",
+			yaml: "a:\n\tb: 1\n",
+			err:  "line 2: tabs cannot be used for indentation",
+		},
+		{
+			name: "bad indentation",
+			yaml: "a:\n    b: 1\n  c: 2\n",
+			err:  "line 3: bad indentation",
+		},
+		{
+			name: "duplicate key",
+			yaml: "a: 1\na: 2\n",
+			err:  `line 2: duplicate key: "a"`,
+		},
+		{
+			name: "anchors",
+			yaml: "a: &x 1\nb: *x\n",
+			err:  "line 1: anchors, aliases, and tags are not supported",
+		},
+		{
+			name: "multiple documents",
+			yaml: "a: 1\n---\nb: 2\n",
+			err:  "line 2: multiple documents are not supported",
+		},
+		{
+			name: "unterminated flow",
+			yaml: "a: [1, 2\nb: 3\n",
+			err:  "line 1: unterminated flow collection",
+		},
+		{
+			name: "unterminated string",
+			yaml: "a: \"hello\n",
+			err:  "line 1: unterminated quoted string",
+		},
+		{
+			name: "mapping value in a plain scalar",
+			yaml: "a: b: c\n",
+			err:  "line 1: mapping values are not allowed in plain scalars",
+		},
+		{
+			name: "mapping value at the end of a plain scalar",
+			yaml: "a: b:\n",
+			err:  "line 1: mapping values are not allowed in plain scalars",
+		},
+		{
+			name: "mapping value in a sequence item",
+			yaml: "a:\n- b: c: d\n",
+			err:  "line 2: mapping values are not allowed in plain scalars",
+		},
+		{
+			name: "mapping value in a flow sequence",
+			yaml: "a: [b: c]\n",
+			err:  "line 1: expected ',' or ']' in flow collection",
+		},
+		{
+			name: "unknown field",
+			yaml: "listeners: []\nlistenrs: []\n",
+			err:  `unknown field "listenrs"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c Config
+
+			err := unmarshalYAML([]byte(test.yaml), &c)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("bad error: %v", err)
+			}
+		})
+	}
+}
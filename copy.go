@@ -1,13 +1,63 @@
 package netx
 
 import (
+	"context"
 	"io"
+	"net"
 	"sync"
+	"time"
 )
 
+// BufferPool is an interface for getting and returning temporary byte slices
+// used when copying data between connections.
+//
+// The interface has the same definition as httputil.BufferPool, so values
+// implementing one can be used as the other.
+type BufferPool interface {
+	Get() []byte
+	Put([]byte)
+}
+
+// NewBufferPool returns a BufferPool backed by a sync.Pool, which allocates
+// byte slices of the given size.
+func NewBufferPool(size int) BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &syncBufferPool{
+		pool: sync.Pool{
+			New: func() interface{} { return make([]byte, size) },
+		},
+	}
+}
+
+type syncBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *syncBufferPool) Get() []byte {
+	return p.pool.Get().([]byte)
+}
+
+func (p *syncBufferPool) Put(b []byte) {
+	p.pool.Put(b[:cap(b)])
+}
+
 // Copy behaves exactly like io.Copy but uses an internal buffer pool to release
 // pressure off of the garbage collector.
+//
+// When both ends are TCP connections, unix sockets or files, the copy is
+// delegated to the runtime which uses zero-copy system calls like splice(2) or
+// sendfile(2) where they are available. The connection wrappers of this
+// package which don't alter the stream of bytes forward the copy to their base
+// connection so they don't prevent this optimization.
 func Copy(w io.Writer, r io.Reader) (n int64, err error) {
+	return CopyBuffer(w, r, nil)
+}
+
+// CopyBuffer behaves like Copy but gets the buffer used for the copy from
+// pool. If pool is nil, the internal buffer pool of Copy is used instead.
+func CopyBuffer(w io.Writer, r io.Reader, pool BufferPool) (n int64, err error) {
 	// Check for io.WriterTo and io.ReaderFrom so we don't hold a buffer during
 	// the copy if one of these interfaces is already implemented, io.CopyBuffer
 	// will double-check on that and fail but that's OK, the cost is likely
@@ -19,16 +69,152 @@ func Copy(w io.Writer, r io.Reader) (n int64, err error) {
 	if to, ok := w.(io.ReaderFrom); ok {
 		return to.ReadFrom(r)
 	}
+	if pool != nil {
+		b := pool.Get()
+		n, err = io.CopyBuffer(w, r, b)
+		pool.Put(b)
+		return
+	}
 	buf := bufferPool.Get().(*buffer)
 	n, err = io.CopyBuffer(w, r, buf.b)
 	bufferPool.Put(buf)
 	return
 }
 
+// CopyContext behaves like Copy but aborts when ctx is canceled, the copy is
+// interrupted by setting the read deadline of r and the write deadline of w in
+// the past, which only works when they have SetReadDeadline and
+// SetWriteDeadline methods (like net.Conn). When the copy was aborted the
+// returned error is ctx.Err().
+//
+// The deadlines are not restored after the context was canceled, the program
+// should assume the connections to be unusable and close them.
+func CopyContext(ctx context.Context, w io.Writer, r io.Reader) (n int64, err error) {
+	return CopyBufferContext(ctx, w, r, nil)
+}
+
+// CopyBufferContext behaves like CopyContext but gets the buffer used for the
+// copy from pool. If pool is nil, the internal buffer pool of Copy is used
+// instead.
+func CopyBufferContext(ctx context.Context, w io.Writer, r io.Reader, pool BufferPool) (n int64, err error) {
+	done := ctx.Done()
+	if done == nil {
+		return CopyBuffer(w, r, pool)
+	}
+
+	stop := make(chan struct{})
+	exit := make(chan bool)
+
+	go func() {
+		select {
+		case <-done:
+			if c, ok := r.(interface {
+				SetReadDeadline(time.Time) error
+			}); ok {
+				c.SetReadDeadline(aLongTimeAgo)
+			}
+			if c, ok := w.(interface {
+				SetWriteDeadline(time.Time) error
+			}); ok {
+				c.SetWriteDeadline(aLongTimeAgo)
+			}
+			exit <- true
+		case <-stop:
+			exit <- false
+		}
+	}()
+
+	n, err = CopyBuffer(w, r, pool)
+	close(stop)
+
+	if canceled := <-exit; canceled && err != nil {
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// aLongTimeAgo is a deadline in the past, used to interrupt blocking I/O
+// operations.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// CopyStats reports the number of bytes transferred by CopyMetered in each
+// direction, and how long the copy lasted.
+type CopyStats struct {
+	AToB     int64         // number of bytes read from a and written to b
+	BToA     int64         // number of bytes read from b and written to a
+	Duration time.Duration // time elapsed between the start and end of the copy
+}
+
+// CopyMetered copies bytes in both directions between a and b, getting the
+// buffers from pool (which may be nil), until one of the directions reaches
+// EOF or fails. Both connections are then closed to interrupt the other
+// direction, and the function returns when both copies have completed.
+//
+// The returned error is the error that ended the copy, it is nil if the copy
+// ended because one of the connections reached EOF.
+func CopyMetered(a net.Conn, b net.Conn, pool BufferPool) (stats CopyStats, err error) {
+	start := time.Now()
+	errs := make(chan error, 2)
+
+	copy := func(w net.Conn, r net.Conn, n *int64) {
+		var err error
+		*n, err = CopyBuffer(w, r, pool)
+		errs <- err
+	}
+
+	go copy(b, a, &stats.AToB)
+	go copy(a, b, &stats.BToA)
+
+	err = <-errs
+	a.Close()
+	b.Close()
+	<-errs
+
+	stats.Duration = time.Since(start)
+	return
+}
+
+// readFrom implements the io.ReaderFrom interface for a connection wrapper w of
+// base, the copy is forwarded to base if it supports it, otherwise the bytes are
+// written through w.
+func readFrom(base net.Conn, w io.Writer, r io.Reader) (int64, error) {
+	if from, ok := base.(io.ReaderFrom); ok {
+		return from.ReadFrom(r)
+	}
+	return CopyBuffer(writerOnly{w}, r, nil)
+}
+
+// writeTo implements the io.WriterTo interface for a connection wrapper r of
+// base, the copy is forwarded to base if it supports it, otherwise the bytes are
+// read through r.
+func writeTo(base net.Conn, r io.Reader, w io.Writer) (int64, error) {
+	if to, ok := base.(io.WriterTo); ok {
+		return to.WriteTo(w)
+	}
+	return CopyBuffer(w, readerOnly{r}, nil)
+}
+
+// writerOnly and readerOnly hide the io.ReaderFrom and io.WriterTo methods of
+// the values they wrap, preventing infinite recursions in readFrom and writeTo.
+type writerOnly struct{ io.Writer }
+
+type readerOnly struct{ io.Reader }
+
 // buffer is a simple wrapper around []byte, it prevents Go from making a memory
 // allocation when converting the byte slice to an interface{}.
 type buffer struct{ b []byte }
 
+// DefaultBufferSize is the size of the buffers used by Copy, and by the buffer
+// pools created by NewBufferPool with a size of zero.
+//
+// The best size depends on the traffic: bulk transfers like CONNECT tunnels
+// benefit from larger buffers, while small-message protocols waste memory with
+// them. Programs can use a different size by passing a pool created with
+// NewBufferPool to CopyBuffer, or to the BufferPool fields of tunnels, bridges,
+// and proxies.
+const DefaultBufferSize = 8192
+
 var bufferPool = sync.Pool{
-	New: func() interface{} { return &buffer{make([]byte, 8192, 8192)} },
+	New: func() interface{} { return &buffer{make([]byte, DefaultBufferSize, DefaultBufferSize)} },
 }
@@ -2,9 +2,12 @@ package netx
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestCopy(t *testing.T) {
@@ -67,6 +70,104 @@ func TestCopy(t *testing.T) {
 	})
 }
 
+func TestCopyConnWrappers(t *testing.T) {
+	wrappers := []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"limitConn", func(c net.Conn) net.Conn { return &limitConn{Conn: c, release: func() {}} }},
+		{"serverConn", func(c net.Conn) net.Conn { return &serverConn{Conn: c, cancel: func() {}} }},
+		{"sniffConn", func(c net.Conn) net.Conn { return &sniffConn{Conn: c, buf: []byte("Hello ")} }},
+	}
+
+	for _, wrapper := range wrappers {
+		t.Run(wrapper.name, func(t *testing.T) {
+			r := &readFromConn{}
+			w := &writeToConn{b: []byte("World!")}
+
+			if _, err := wrapper.wrap(r).(io.ReaderFrom).ReadFrom(bytes.NewReader(nil)); err != nil {
+				t.Error(err)
+			}
+			if !r.called {
+				t.Error("ReadFrom was not forwarded to the base connection")
+			}
+
+			b := &bytes.Buffer{}
+			c := wrapper.wrap(w)
+
+			if _, err := Copy(b, c); err != nil {
+				t.Error(err)
+			}
+			if !w.called {
+				t.Error("WriteTo was not forwarded to the base connection")
+			}
+
+			if _, ok := c.(*sniffConn); ok && b.String() != "Hello World!" {
+				t.Error("bad output:", b.String())
+			}
+		})
+	}
+}
+
+type readFromConn struct {
+	net.Conn
+	called bool
+}
+
+func (c *readFromConn) ReadFrom(r io.Reader) (int64, error) {
+	c.called = true
+	return 0, nil
+}
+
+type writeToConn struct {
+	net.Conn
+	b      []byte
+	called bool
+}
+
+func (c *writeToConn) WriteTo(w io.Writer) (int64, error) {
+	c.called = true
+	n, err := w.Write(c.b)
+	return int64(n), err
+}
+
+func TestCopyBuffer(t *testing.T) {
+	pool := &testBufferPool{BufferPool: NewBufferPool(16)}
+
+	w := &testBuffer{}
+	r := &readOneByOne{[]byte("Hello World!")}
+
+	if n, err := CopyBuffer(w, r, pool); err != nil {
+		t.Error(err)
+	} else if n != 12 {
+		t.Error("bad byte count:", n)
+	}
+
+	if s := string(w.b); s != "Hello World!" {
+		t.Error("bad output:", s)
+	}
+
+	if pool.gets != 1 || pool.puts != 1 {
+		t.Errorf("bad buffer pool usage: %d gets, %d puts", pool.gets, pool.puts)
+	}
+}
+
+type testBufferPool struct {
+	BufferPool
+	gets int
+	puts int
+}
+
+func (p *testBufferPool) Get() []byte {
+	p.gets++
+	return p.BufferPool.Get()
+}
+
+func (p *testBufferPool) Put(b []byte) {
+	p.puts++
+	p.BufferPool.Put(b)
+}
+
 type testBuffer struct{ b []byte }
 
 func (buf *testBuffer) Read(b []byte) (n int, err error) {
@@ -81,3 +182,99 @@ func (buf *testBuffer) Read(b []byte) (n int, err error) {
 	buf.b = buf.b[n:]
 	return
 }
+
+func (buf *testBuffer) Write(b []byte) (n int, err error) {
+	buf.b = append(buf.b, b...)
+	n = len(b)
+	return
+}
+
+func TestCopyMetered(t *testing.T) {
+	a1, a2, err := ConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a1.Close()
+
+	b1, b2, err := ConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b2.Close()
+
+	done := make(chan CopyStats)
+	go func() {
+		stats, err := CopyMetered(a2, b1, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- stats
+	}()
+
+	buf := make([]byte, 32)
+
+	a1.Write([]byte("Hello"))
+	if n, _ := io.ReadFull(b2, buf[:5]); string(buf[:n]) != "Hello" {
+		t.Error("bad output:", string(buf[:n]))
+	}
+
+	b2.Write([]byte("World!"))
+	if n, _ := io.ReadFull(a1, buf[:6]); string(buf[:n]) != "World!" {
+		t.Error("bad output:", string(buf[:n]))
+	}
+
+	a1.Close()
+	stats := <-done
+
+	if stats.AToB != 5 || stats.BToA != 6 {
+		t.Errorf("bad byte counts: %d a->b, %d b->a", stats.AToB, stats.BToA)
+	}
+
+	if stats.Duration <= 0 {
+		t.Error("bad duration:", stats.Duration)
+	}
+}
+
+func TestCopyContext(t *testing.T) {
+	c1, c2, err := ConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := CopyContext(ctx, ioutil.Discard, c1)
+		errs <- err
+	}()
+
+	c2.Write([]byte("Hello World!"))
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Error("bad error:", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("the copy was not interrupted by the context cancellation")
+	}
+}
+
+func TestCopyContextCompleted(t *testing.T) {
+	w := &bytes.Buffer{}
+	r := bytes.NewReader([]byte("Hello World!"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if n, err := CopyContext(ctx, w, r); err != nil {
+		t.Error(err)
+	} else if n != 12 {
+		t.Error("bad byte count:", n)
+	}
+}
@@ -0,0 +1,212 @@
+package netx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DestinationPolicy decides which addresses a program may establish
+// connections to, it protects proxies and other programs connecting to
+// addresses received from untrusted clients against server-side request
+// forgery (SSRF), where clients use the program to reach internal services.
+//
+// Host names are resolved by the policy, and the connections are established
+// to the IP addresses that were checked. This prevents DNS rebinding attacks,
+// where a name resolves to an allowed address when it is checked, then to an
+// internal address when the connection is established.
+//
+// The DialContext method can be used as the DialContext field of proxies and
+// transports, and the Hop method applies the policy to other dial functions.
+type DestinationPolicy struct {
+	// Allow is a list of networks that connections may be established to even
+	// if they are part of DefaultDeniedNetworks.
+	Allow []*net.IPNet
+
+	// Deny is a list of networks that connections are refused to, in addition
+	// to DefaultDeniedNetworks. It takes precedence over Allow.
+	Deny []*net.IPNet
+
+	// LookupIP is used to resolve host names, it has the signature of the
+	// LookupIP methods of net.Resolver and CachingResolver. If nil, the
+	// default resolver of the net package is used.
+	LookupIP func(context.Context, string, string) ([]net.IP, error)
+
+	// Dial is used to establish connections to the allowed IP addresses. If
+	// nil, a net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+}
+
+// DefaultDeniedNetworks is the list of networks that a DestinationPolicy
+// refuses to connect to unless they are explicitly allowed: loopback,
+// link-local, private (RFC 1918, shared address space, and unique local IPv6),
+// multicast, and broadcast addresses, the metadata services of cloud
+// providers, and the IPv6 prefixes translated to IPv4 addresses by gateways,
+// which could reach the other networks.
+var DefaultDeniedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),          // "this" network, 0.0.0.0 reaches the local host
+	mustParseCIDR("127.0.0.0/8"),        // loopback
+	mustParseCIDR("10.0.0.0/8"),         // private
+	mustParseCIDR("172.16.0.0/12"),      // private
+	mustParseCIDR("192.168.0.0/16"),     // private
+	mustParseCIDR("100.64.0.0/10"),      // shared address space (carrier-grade NAT), used by some cloud networks
+	mustParseCIDR("169.254.0.0/16"),     // link-local, including the AWS, GCP, and Azure metadata services
+	mustParseCIDR("100.100.100.200/32"), // Alibaba Cloud metadata service
+	mustParseCIDR("224.0.0.0/4"),        // multicast
+	mustParseCIDR("240.0.0.0/4"),        // reserved, including the broadcast address
+	mustParseCIDR("::/128"),             // unspecified
+	mustParseCIDR("::1/128"),            // loopback
+	mustParseCIDR("fe80::/10"),          // link-local
+	mustParseCIDR("fc00::/7"),           // unique local, including the AWS IPv6 metadata service
+	mustParseCIDR("ff00::/8"),           // multicast
+	mustParseCIDR("64:ff9b::/96"),       // NAT64, 64:ff9b::a9fe:a9fe reaches 169.254.169.254
+	mustParseCIDR("64:ff9b:1::/48"),     // local-use NAT64
+	mustParseCIDR("2002::/16"),          // 6to4, which embeds IPv4 addresses
+}
+
+// DestinationError is returned by DestinationPolicy when a connection is
+// refused.
+type DestinationError struct {
+	Network string
+	Address string // address that the connection was requested to
+	IP      net.IP // denied address, nil if the network is not supported
+}
+
+// Error satisfies the error interface.
+func (e *DestinationError) Error() string {
+	if e.IP == nil {
+		return fmt.Sprintf("connections to %s on the %s network are not allowed by the destination policy", e.Address, e.Network)
+	}
+	if host, _, _ := net.SplitHostPort(e.Address); host != e.IP.String() {
+		return fmt.Sprintf("connections to %s (%s) are not allowed by the destination policy", e.Address, e.IP)
+	}
+	return fmt.Sprintf("connections to %s are not allowed by the destination policy", e.Address)
+}
+
+// Allowed checks whether p allows connections to ip.
+func (p *DestinationPolicy) Allowed(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4 // IPv4-mapped IPv6 addresses are checked as IPv4
+	}
+	if containsIP(p.Deny, ip) {
+		return false
+	}
+	if containsIP(p.Allow, ip) {
+		return true
+	}
+	return !containsIP(DefaultDeniedNetworks, ip)
+}
+
+// DialContext connects to address on the named network if the policy allows
+// it, which must be one of the tcp or udp networks.
+//
+// When the host is a name it is resolved and the connection is refused if any
+// of its addresses is denied, otherwise the addresses are tried in order until
+// a connection is established.
+func (p *DestinationPolicy) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := p.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	ips, port, err := p.resolve(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		var conn net.Conn
+
+		if conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Check returns an error if the policy refuses connections to address on the
+// named network, resolving its host like DialContext does. It lets programs
+// check the destinations that other proxies connect to on their behalf.
+func (p *DestinationPolicy) Check(ctx context.Context, network string, address string) error {
+	_, _, err := p.resolve(ctx, network, address)
+	return err
+}
+
+// resolve returns the IP addresses and the port of address, or an error if
+// the policy refuses connections to any of the addresses.
+func (p *DestinationPolicy) resolve(ctx context.Context, network string, address string) ([]net.IP, string, error) {
+	var family string
+
+	switch network {
+	case "tcp", "udp":
+		family = "ip"
+	case "tcp4", "udp4":
+		family = "ip4"
+	case "tcp6", "udp6":
+		family = "ip6"
+	default:
+		return nil, "", &net.OpError{Op: "dial", Net: network, Err: &DestinationError{Network: network, Address: address}}
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, "", &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	ips, err := p.lookupIP(ctx, family, host)
+	if err != nil {
+		return nil, "", &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	for _, ip := range ips {
+		if !p.Allowed(ip) {
+			return nil, "", &net.OpError{Op: "dial", Net: network, Err: &DestinationError{Network: network, Address: address, IP: ip}}
+		}
+	}
+
+	return ips, port, nil
+}
+
+// Hop returns a dial function which applies the policy to the connections
+// established with dial. It can be used in a ChainDialer.
+func (p *DestinationPolicy) Hop(dial DialFunc) DialFunc {
+	q := *p
+	q.Dial = dial
+	return q.DialContext
+}
+
+func (p *DestinationPolicy) lookupIP(ctx context.Context, family string, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	lookupIP := p.LookupIP
+	if lookupIP == nil {
+		lookupIP = net.DefaultResolver.LookupIP
+	}
+
+	ips, err := lookupIP(ctx, family, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, err
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
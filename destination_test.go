@@ -0,0 +1,196 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestDestinationPolicyAllowed(t *testing.T) {
+	policy := &DestinationPolicy{
+		Allow: []*net.IPNet{mustParseCIDR("10.1.0.0/16")},
+		Deny:  []*net.IPNet{mustParseCIDR("10.1.2.0/24"), mustParseCIDR("203.0.113.0/24")},
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"0.0.0.0", false},
+		{"10.0.0.1", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"::1", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"ff02::1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"2002:a9fe:a9fe::1", false},
+		{"10.1.0.1", true},
+		{"10.1.2.1", false},
+		{"203.0.113.1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if allowed := policy.Allowed(net.ParseIP(test.ip)); allowed != test.allowed {
+				t.Error("bad result:", allowed)
+			}
+		})
+	}
+}
+
+func TestDestinationPolicyDial(t *testing.T) {
+	lookupIP := func(ctx context.Context, network string, host string) ([]net.IP, error) {
+		switch host {
+		case "public.example":
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		case "rebind.example":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("169.254.169.254")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	tests := []struct {
+		network string
+		address string
+		dialed  string
+		denied  bool
+	}{
+		{network: "tcp", address: "public.example:80", dialed: "93.184.216.34:80"},
+		{network: "tcp", address: "93.184.216.34:443", dialed: "93.184.216.34:443"},
+		{network: "udp", address: "public.example:53", dialed: "93.184.216.34:53"},
+		{network: "tcp", address: "rebind.example:80", denied: true},
+		{network: "tcp", address: "127.0.0.1:80", denied: true},
+		{network: "tcp6", address: "[::1]:80", denied: true},
+		{network: "unix", address: "/var/run/docker.sock", denied: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			dialed := ""
+			policy := &DestinationPolicy{
+				LookupIP: lookupIP,
+				Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+					dialed = address
+					c1, c2 := net.Pipe()
+					c2.Close()
+					return c1, nil
+				},
+			}
+
+			conn, err := policy.DialContext(context.Background(), test.network, test.address)
+
+			if test.denied {
+				var e *DestinationError
+				if !errors.As(err, &e) {
+					t.Error("the connection was not denied:", err)
+				}
+				if len(dialed) != 0 {
+					t.Error("a connection was established to", dialed)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+
+			if dialed != test.dialed {
+				t.Error("bad address dialed:", dialed)
+			}
+		})
+	}
+}
+
+func TestDestinationPolicyHop(t *testing.T) {
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	d := &ChainDialer{
+		Hops: []Hop{(&DestinationPolicy{}).Hop},
+	}
+
+	if _, err := d.DialContext(context.Background(), "tcp", lstn.Addr().String()); err == nil {
+		t.Error("connecting to a loopback address was allowed")
+	}
+
+	d.Hops[0] = (&DestinationPolicy{Allow: []*net.IPNet{mustParseCIDR("127.0.0.0/8")}}).Hop
+
+	conn, err := d.DialContext(context.Background(), "tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestDestinationPolicyCheck(t *testing.T) {
+	policy := &DestinationPolicy{
+		LookupIP: func(ctx context.Context, network string, host string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.1")}, nil
+		},
+	}
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"127.0.0.1:80", false},
+		{"rebind.example.com:80", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			err := policy.Check(context.Background(), "tcp", test.address)
+
+			var denied *DestinationError
+			if test.allowed != (err == nil) || (err != nil && !errors.As(err, &denied)) {
+				t.Error("bad result:", err)
+			}
+		})
+	}
+}
+
+func TestDestinationErrorString(t *testing.T) {
+	tests := []struct {
+		err *DestinationError
+		str string
+	}{
+		{
+			err: &DestinationError{Network: "tcp", Address: "127.0.0.1:80", IP: net.ParseIP("127.0.0.1")},
+			str: "connections to 127.0.0.1:80 are not allowed by the destination policy",
+		},
+		{
+			err: &DestinationError{Network: "tcp", Address: "localhost:80", IP: net.ParseIP("127.0.0.1")},
+			str: "connections to localhost:80 (127.0.0.1) are not allowed by the destination policy",
+		},
+		{
+			err: &DestinationError{Network: "unix", Address: "/tmp/sock"},
+			str: "connections to /tmp/sock on the unix network are not allowed by the destination policy",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.str, func(t *testing.T) {
+			if s := test.err.Error(); s != test.str {
+				t.Error(s)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dial is equivalent to net.Dial but guesses the network from the address, it
+// accepts the same URL-style addresses as Listen.
+func Dial(address string) (net.Conn, error) {
+	return DialContext(context.Background(), address)
+}
+
+// DialContext is like Dial but takes a context which may be used to cancel or
+// set a deadline on establishing the connection.
+//
+// Supported protocols are tcp, tcp4, tcp6, udp, udp4, udp6, unix, unixpacket,
+// unixgram, tls, and fd. Addresses without a protocol are assumed to use tcp
+// when they are a pair of a host and port, or to be the path to a unix socket
+// otherwise.
+//
+// Addresses using the tls protocol may set the ca query parameter to the path
+// of a PEM file containing the authorities used to verify the server
+// certificate, the cert and key parameters to present a client certificate,
+// and the servername parameter to override the name used to verify the
+// server, for example:
+//
+//	tls://example.com:443?ca=/etc/ssl/ca.pem
+func DialContext(ctx context.Context, address string) (conn net.Conn, err error) {
+	network, addr := SplitNetAddr(address)
+
+	if len(network) == 0 {
+		if _, _, e := net.SplitHostPort(addr); e == nil {
+			network = "tcp"
+		} else {
+			network = "unix"
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixpacket", "unixgram":
+		return dialer.DialContext(ctx, network, addr)
+
+	case "tls":
+		var config *tls.Config
+
+		if addr, config, err = parseTLSAddress(addr, false); err != nil {
+			return
+		}
+
+		return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", addr)
+
+	case "fd":
+		var fd int
+		var f *os.File
+
+		if fd, err = strconv.Atoi(addr); err != nil {
+			err = errors.New("invalid file descriptor in fd://" + addr)
+			return
+		} else if fd < 0 {
+			err = errors.New("invalid negative file descriptor in fd://" + addr)
+			return
+		}
+
+		f = os.NewFile(uintptr(fd), network)
+		defer f.Close()
+		return net.FileConn(f)
+	}
+
+	err = errors.New("unsupported protocol: " + network)
+	return
+}
+
+// parseTLSAddress splits the address and the query parameters of a tls://
+// address, returning the TLS configuration that they describe. When server is
+// true the configuration is for a listener and requires a certificate.
+func parseTLSAddress(s string, server bool) (addr string, config *tls.Config, err error) {
+	var query url.Values
+
+	addr = s
+
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		if query, err = url.ParseQuery(s[i+1:]); err != nil {
+			err = errors.New("malformed query in tls://" + s + ": " + err.Error())
+			return
+		}
+		addr = s[:i]
+	}
+
+	config = &tls.Config{}
+
+	for name := range query {
+		switch name {
+		case "cert", "key", "ca":
+		case "servername":
+			if !server {
+				config.ServerName = query.Get(name)
+				break
+			}
+			fallthrough
+		default:
+			err = errors.New("unsupported parameter in tls://" + s + ": " + name)
+			return
+		}
+	}
+
+	certFile, keyFile := query.Get("cert"), query.Get("key")
+
+	switch {
+	case len(certFile) != 0 && len(keyFile) != 0:
+		var cert tls.Certificate
+
+		if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+
+	case len(certFile) != 0 || len(keyFile) != 0 || server:
+		err = errors.New("the cert and key parameters must be set together in tls://" + s)
+		return
+	}
+
+	if caFile := query.Get("ca"); len(caFile) != 0 {
+		var pem []byte
+		var pool = x509.NewCertPool()
+
+		if pem, err = ioutil.ReadFile(caFile); err != nil {
+			return
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			err = errors.New("no certificates found in " + caFile)
+			return
+		}
+
+		if server {
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.RootCAs = pool
+		}
+	}
+
+	return
+}
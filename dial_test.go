@@ -0,0 +1,195 @@
+package netx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDialListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+
+	tests := []struct {
+		listen  string
+		network string
+		dial    func(addr string) string
+	}{
+		{
+			listen:  "127.0.0.1:0",
+			network: "tcp",
+			dial:    func(addr string) string { return addr },
+		},
+		{
+			listen:  "tcp://127.0.0.1:0",
+			network: "tcp",
+			dial:    func(addr string) string { return "tcp://" + addr },
+		},
+		{
+			listen:  "unix://" + filepath.Join(dir, "unix.sock"),
+			network: "unix",
+			dial:    func(addr string) string { return "unix://" + addr },
+		},
+		{
+			listen:  filepath.Join(dir, "path.sock"),
+			network: "unix",
+			dial:    func(addr string) string { return addr },
+		},
+		{
+			listen:  "tls://127.0.0.1:0?cert=" + certFile + "&key=" + keyFile,
+			network: "tls",
+			dial:    func(addr string) string { return "tls://" + addr + "?servername=localhost&ca=" + certFile },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.listen, func(t *testing.T) {
+			lstn, err := Listen(test.listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lstn.Close()
+
+			if network := lstn.Addr().Network(); network != test.network {
+				t.Error("bad network:", network)
+			}
+
+			go (&Server{Handler: Echo}).Serve(lstn)
+
+			conn, err := Dial(test.dial(lstn.Addr().String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("Hello World!"))
+			b := make([]byte, 12)
+
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "Hello World!" {
+				t.Error("bad response:", string(b))
+			}
+		})
+	}
+}
+
+func TestDialFD(t *testing.T) {
+	c1, c2, err := ConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	f, err := c1.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := Dial("fd://" + strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("Hello World!"))
+	b := make([]byte, 12)
+
+	if _, err := io.ReadFull(c2, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Error("bad response:", string(b))
+	}
+}
+
+func TestDialListenErrors(t *testing.T) {
+	for _, address := range []string{
+		"tls://:0",
+		"tls://:0?cert=cert.pem",
+		"tls://:0?cert=cert.pem&key=key.pem&servername=localhost",
+		"tls://:0?what=ever",
+		"fd://-1",
+		"sctp://:0",
+	} {
+		t.Run(address, func(t *testing.T) {
+			if lstn, err := Listen(address); err == nil {
+				lstn.Close()
+				t.Error("no error returned by Listen")
+			}
+		})
+	}
+
+	for _, address := range []string{
+		"tls://localhost:1?what=ever",
+		"tls://localhost:1?key=key.pem",
+		"fd://-1",
+		"sctp://localhost:1",
+	} {
+		t.Run(address, func(t *testing.T) {
+			if conn, err := Dial(address); err == nil {
+				conn.Close()
+				t.Error("no error returned by Dial")
+			}
+		})
+	}
+}
+
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
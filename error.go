@@ -38,4 +38,8 @@ var (
 	// ErrNoPipeline should be used by handlers that detect an attempt to use
 	// pipelining when they don't support it.
 	ErrNoPipeline = errors.New("pipelining is not supported")
+
+	// ErrServerClosed is returned by the Serve method of a Server after a call
+	// to Shutdown or Close.
+	ErrServerClosed = errors.New("the server is closed")
 )
@@ -0,0 +1,183 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType represents the kind of events published on an EventBus.
+type EventType int
+
+const (
+	// EventAccept is published when a server accepts a new connection.
+	EventAccept EventType = iota
+
+	// EventTLSHandshake is published when a TLS handshake completed, or
+	// failed in which case the Err field of the event is set.
+	EventTLSHandshake
+
+	// EventRequestStart is published when a proxy starts serving a request.
+	EventRequestStart
+
+	// EventUpstreamSelected is published when a proxy selected the address
+	// that it forwards a request or a connection to, in the Target field.
+	EventUpstreamSelected
+
+	// EventTunnelOpen is published when a tunnel between a client and a
+	// target was established.
+	EventTunnelOpen
+
+	// EventClose is published when a server is done with a connection.
+	EventClose
+)
+
+// String returns a human-readable representation of t.
+func (t EventType) String() string {
+	switch t {
+	case EventAccept:
+		return "accept"
+	case EventTLSHandshake:
+		return "tls-handshake"
+	case EventRequestStart:
+		return "request-start"
+	case EventUpstreamSelected:
+		return "upstream-selected"
+	case EventTunnelOpen:
+		return "tunnel-open"
+	case EventClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// Event represents a step of the lifecycle of a connection.
+type Event struct {
+	Type       EventType
+	Time       time.Time
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Target     string            // address of the target or upstream, if any
+	Err        error             // set when the step failed
+	Meta       map[string]string // shared, must not be modified
+}
+
+// EventBus is a publish/subscribe stream of connection lifecycle events, it
+// lets external systems like auditing or anomaly detection observe the
+// connections served by a program.
+//
+// Components like Server, Tunnel, or httpx.ReverseProxy publish their events
+// to the bus they are configured with, a nil bus discards all events so they
+// can publish unconditionally.
+//
+// Publishing never blocks: events are dropped for the subscribers whose
+// buffer is full, and counted by their Dropped method.
+//
+// Buses are safe to use concurrently from multiple goroutines, the zero value
+// is a bus with no subscribers ready to use.
+type EventBus struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]struct{}
+}
+
+// Subscription is a subscriber of an EventBus, created by calling Subscribe.
+type Subscription struct {
+	// C is the channel on which the events are delivered, it is closed when
+	// the subscription is closed.
+	C <-chan Event
+
+	bus     *EventBus
+	events  chan Event
+	types   uint64 // bit set of the event types, zero means all
+	dropped uint64 // atomic
+	once    sync.Once
+}
+
+// Subscribe registers a new subscriber on the bus which receives the events
+// of the given types, or all events if no types are given. The size is the
+// number of events buffered for the subscriber.
+//
+// The subscription must be closed when the program doesn't need it anymore.
+func (b *EventBus) Subscribe(size int, types ...EventType) *Subscription {
+	events := make(chan Event, size)
+	s := &Subscription{C: events, bus: b, events: events}
+
+	for _, t := range types {
+		s.types |= 1 << uint(t)
+	}
+
+	b.mutex.Lock()
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[s] = struct{}{}
+	b.mutex.Unlock()
+	return s
+}
+
+// Publish sends e to the subscribers of the bus. If the time of the event is
+// zero it is set to the current time.
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for s := range b.subs {
+		if s.types != 0 && (s.types&(1<<uint(e.Type))) == 0 {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Handler returns a handler which publishes EventAccept and EventClose events
+// for the connections served by h.
+func (b *EventBus) Handler(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		b.PublishConn(EventAccept, conn, nil)
+		defer b.PublishConn(EventClose, conn, nil)
+		h.ServeConn(ctx, conn)
+	})
+}
+
+// PublishConn publishes an event of type t with the addresses of conn.
+func (b *EventBus) PublishConn(t EventType, conn net.Conn, err error) {
+	if b != nil {
+		b.Publish(Event{
+			Type:       t,
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+			Err:        err,
+		})
+	}
+}
+
+// Dropped returns the number of events that were dropped because the buffer
+// of the subscription was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close removes the subscription from its bus and closes its channel.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mutex.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mutex.Unlock()
+		close(s.events)
+	})
+}
@@ -0,0 +1,145 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := &EventBus{}
+
+	all := bus.Subscribe(10)
+	defer all.Close()
+
+	tunnels := bus.Subscribe(10, EventTunnelOpen)
+	defer tunnels.Close()
+
+	small := bus.Subscribe(1)
+	defer small.Close()
+
+	bus.Publish(Event{Type: EventAccept})
+	bus.Publish(Event{Type: EventTunnelOpen, Target: "10.0.0.1:80"})
+	bus.Publish(Event{Type: EventClose})
+
+	if n := len(all.C); n != 3 {
+		t.Error("bad number of events:", n)
+	}
+
+	if n := len(tunnels.C); n != 1 {
+		t.Error("bad number of filtered events:", n)
+	} else if e := <-tunnels.C; e.Target != "10.0.0.1:80" || e.Time.IsZero() {
+		t.Errorf("bad event: %+v", e)
+	}
+
+	if n := small.Dropped(); n != 2 {
+		t.Error("bad number of dropped events:", n)
+	}
+
+	all.Close()
+	all.Close()
+
+	for range all.C {
+	}
+
+	bus.Publish(Event{Type: EventAccept})
+
+	if n := len(small.C); n != 1 {
+		t.Error("bad number of events after closing a subscription:", n)
+	}
+}
+
+func TestEventBusNil(t *testing.T) {
+	var bus *EventBus
+	bus.Publish(Event{Type: EventAccept}) // must not panic
+}
+
+func TestEventTypeString(t *testing.T) {
+	tests := []struct {
+		t EventType
+		s string
+	}{
+		{EventAccept, "accept"},
+		{EventTLSHandshake, "tls-handshake"},
+		{EventRequestStart, "request-start"},
+		{EventUpstreamSelected, "upstream-selected"},
+		{EventTunnelOpen, "tunnel-open"},
+		{EventClose, "close"},
+		{EventType(-1), "unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if s := test.t.String(); s != test.s {
+				t.Error(s)
+			}
+		})
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	bus := &EventBus{}
+	sub := bus.Subscribe(10)
+	defer sub.Close()
+
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Handler: Echo, Events: bus}
+
+	done := make(chan struct{})
+	go func() {
+		server.Serve(lstn)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.Read(make([]byte, 5))
+	conn.Close()
+
+	for _, typ := range []EventType{EventAccept, EventClose} {
+		if e := <-sub.C; e.Type != typ || e.RemoteAddr.String() != conn.LocalAddr().String() {
+			t.Errorf("bad %s event: %+v", typ, e)
+		}
+	}
+
+	server.Close()
+	<-done
+}
+
+func TestTunnelEvents(t *testing.T) {
+	bus := &EventBus{}
+	sub := bus.Subscribe(10)
+	defer sub.Close()
+
+	errDial := errors.New("unreachable")
+
+	tunnel := &Tunnel{
+		Handler: TunnelRaw,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errDial
+		},
+		Logger: DiscardLogger,
+		Events: bus,
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	tunnel.ServeProxy(context.Background(), c1, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80})
+
+	if e := <-sub.C; e.Type != EventUpstreamSelected || e.Target != "10.0.0.1:80" {
+		t.Errorf("bad upstream-selected event: %+v", e)
+	}
+
+	if e := <-sub.C; e.Type != EventTunnelOpen || e.Err != errDial {
+		t.Errorf("bad tunnel-open event: %+v", e)
+	}
+}
@@ -0,0 +1,259 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// HappyEyeballsDialer is a dialer which implements the Happy Eyeballs
+// algorithm described in RFC 8305, it resolves both the IPv6 and IPv4
+// addresses of a host and races connection attempts to the two address
+// families, returning the first connection that was established.
+//
+// Attempts are started in order, alternating between IPv6 and IPv4 addresses
+// (starting with IPv6), each new attempt starts when the previous one failed
+// or after a short delay. This gives fast connections on dual-stack hosts even
+// when one of the address families is broken.
+//
+// The DialContext method can be used as the DialContext field of proxies and
+// transports.
+type HappyEyeballsDialer struct {
+	// Dial is used to establish the connections to the resolved IP addresses.
+	// If nil, a net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+
+	// LookupIP is used to resolve the addresses of the host, it is called with
+	// "ip6" and "ip4" as network. If nil, the LookupIP method of the default
+	// resolver of the net package is used.
+	LookupIP func(context.Context, string, string) ([]net.IP, error)
+
+	// ResolutionDelay is the amount of time that the dialer waits for the IPv6
+	// addresses when the IPv4 addresses were resolved first. If zero, it
+	// defaults to 50ms, which is the value recommended by the RFC.
+	ResolutionDelay time.Duration
+
+	// AttemptDelay is the amount of time that the dialer waits for a
+	// connection attempt before starting the next one. If zero, it defaults to
+	// 250ms, which is the value recommended by the RFC.
+	AttemptDelay time.Duration
+}
+
+// DialContext connects to the address on the named network, using the Happy
+// Eyeballs algorithm for the tcp network when the host is a name which
+// resolves to multiple addresses.
+func (d *HappyEyeballsDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := d.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	var families []string
+
+	switch network {
+	case "tcp":
+		families = []string{"ip6", "ip4"}
+	case "tcp6":
+		families = []string{"ip6"}
+	case "tcp4":
+		families = []string{"ip4"}
+	default:
+		return dial(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(ctx, network, address)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lookups := make(chan eyeballsLookup, len(families))
+
+	for _, family := range families {
+		go d.lookup(ctx, family, host, lookups)
+	}
+
+	h := &eyeballs{
+		dial:    dial,
+		network: network,
+		host:    host,
+		port:    port,
+		last:    "ip4",
+		results: make(chan eyeballsResult),
+		done:    ctx.Done(),
+	}
+
+	return h.run(ctx, lookups, len(families), d.resolutionDelay(), d.attemptDelay())
+}
+
+func (d *HappyEyeballsDialer) lookup(ctx context.Context, family string, host string, lookups chan<- eyeballsLookup) {
+	lookupIP := d.LookupIP
+	if lookupIP == nil {
+		lookupIP = net.DefaultResolver.LookupIP
+	}
+	ips, err := lookupIP(ctx, family, host)
+	lookups <- eyeballsLookup{family: family, ips: ips, err: err}
+}
+
+func (d *HappyEyeballsDialer) resolutionDelay() time.Duration {
+	if d.ResolutionDelay > 0 {
+		return d.ResolutionDelay
+	}
+	return 50 * time.Millisecond
+}
+
+func (d *HappyEyeballsDialer) attemptDelay() time.Duration {
+	if d.AttemptDelay > 0 {
+		return d.AttemptDelay
+	}
+	return 250 * time.Millisecond
+}
+
+type eyeballsLookup struct {
+	family string
+	ips    []net.IP
+	err    error
+}
+
+type eyeballsResult struct {
+	conn net.Conn
+	err  error
+}
+
+// eyeballs holds the state of a single call to HappyEyeballsDialer.DialContext.
+type eyeballs struct {
+	dial     func(context.Context, string, string) (net.Conn, error)
+	network  string
+	host     string
+	port     string
+	ip6      []net.IP
+	ip4      []net.IP
+	last     string // family of the last address attempted
+	attempts int    // number of connection attempts in progress
+	results  chan eyeballsResult
+	done     <-chan struct{}
+
+	dialErr   error // first error returned by a connection attempt
+	lookupErr error // first error returned by a lookup
+}
+
+func (h *eyeballs) run(ctx context.Context, lookups <-chan eyeballsLookup, pending int, resolutionDelay time.Duration, attemptDelay time.Duration) (net.Conn, error) {
+	var delay <-chan time.Time
+
+	// Wait for the first answer, then give a chance to the IPv6 lookup to
+	// complete if the IPv4 addresses came first.
+	select {
+	case l := <-lookups:
+		pending--
+		h.add(l)
+		if l.family == "ip4" && pending != 0 {
+			timer := time.NewTimer(resolutionDelay)
+			select {
+			case l := <-lookups:
+				pending--
+				h.add(l)
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+			timer.Stop()
+		}
+	case <-ctx.Done():
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if delay == nil && h.start(ctx) {
+			delay = time.After(attemptDelay)
+		}
+
+		if h.attempts == 0 && pending == 0 {
+			return nil, h.error()
+		}
+
+		select {
+		case l := <-lookups:
+			pending--
+			h.add(l)
+
+		case r := <-h.results:
+			h.attempts--
+			if r.err == nil {
+				return r.conn, nil
+			}
+			if h.dialErr == nil {
+				h.dialErr = r.err
+			}
+			delay = nil // failures start the next attempt right away
+
+		case <-delay:
+			delay = nil
+
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (h *eyeballs) add(l eyeballsLookup) {
+	if l.err != nil {
+		if h.lookupErr == nil {
+			h.lookupErr = l.err
+		}
+		return
+	}
+	switch l.family {
+	case "ip6":
+		h.ip6 = append(h.ip6, l.ips...)
+	case "ip4":
+		h.ip4 = append(h.ip4, l.ips...)
+	}
+}
+
+// error returns the error reported when all attempts failed, errors from
+// connection attempts are more relevant than errors from the lookup of one of
+// the address families.
+func (h *eyeballs) error() error {
+	if h.dialErr != nil {
+		return h.dialErr
+	}
+	if h.lookupErr != nil {
+		return h.lookupErr
+	}
+	return &net.DNSError{Err: "no such host", Name: h.host, IsNotFound: true}
+}
+
+// start starts a connection attempt to the next address, alternating between
+// the address families, it returns false if there were no more addresses.
+func (h *eyeballs) start(ctx context.Context) bool {
+	var ip net.IP
+
+	switch {
+	case len(h.ip4) != 0 && (h.last == "ip6" || len(h.ip6) == 0):
+		ip, h.ip4, h.last = h.ip4[0], h.ip4[1:], "ip4"
+	case len(h.ip6) != 0:
+		ip, h.ip6, h.last = h.ip6[0], h.ip6[1:], "ip6"
+	default:
+		return false
+	}
+
+	h.attempts++
+	go h.attempt(ctx, net.JoinHostPort(ip.String(), h.port))
+	return true
+}
+
+func (h *eyeballs) attempt(ctx context.Context, address string) {
+	conn, err := h.dial(ctx, h.network, address)
+
+	select {
+	case h.results <- eyeballsResult{conn, err}:
+	case <-h.done:
+		// Another attempt won the race, or the dial was canceled.
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
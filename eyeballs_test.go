@@ -0,0 +1,171 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testLookupIP(ip6 []string, ip4 []string, ip6Delay time.Duration) func(context.Context, string, string) ([]net.IP, error) {
+	parse := func(addrs []string) (ips []net.IP) {
+		for _, a := range addrs {
+			ips = append(ips, net.ParseIP(a))
+		}
+		return
+	}
+	return func(ctx context.Context, network string, host string) ([]net.IP, error) {
+		if network == "ip6" {
+			time.Sleep(ip6Delay)
+			return parse(ip6), nil
+		}
+		return parse(ip4), nil
+	}
+}
+
+type testEyeballsDialer struct {
+	mutex sync.Mutex
+	addrs []string
+	dial  func(context.Context, string) (net.Conn, error)
+}
+
+func (d *testEyeballsDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	d.mutex.Lock()
+	d.addrs = append(d.addrs, address)
+	d.mutex.Unlock()
+	return d.dial(ctx, address)
+}
+
+func (d *testEyeballsDialer) attempts() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string{}, d.addrs...)
+}
+
+func TestHappyEyeballsDialer(t *testing.T) {
+	errRefused := errors.New("connection refused")
+
+	t.Run("prefers-ipv6", func(t *testing.T) {
+		d := &testEyeballsDialer{dial: func(ctx context.Context, address string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			c2.Close()
+			return c1, nil
+		}}
+
+		conn, err := (&HappyEyeballsDialer{
+			Dial:     d.DialContext,
+			LookupIP: testLookupIP([]string{"::1"}, []string{"127.0.0.1"}, 10*time.Millisecond),
+		}).DialContext(context.Background(), "tcp", "localhost:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		if addrs := d.attempts(); addrs[0] != "[::1]:80" {
+			t.Error("the first attempt was not made to the IPv6 address:", addrs)
+		}
+	})
+
+	t.Run("fallback-on-failure", func(t *testing.T) {
+		d := &testEyeballsDialer{dial: func(ctx context.Context, address string) (net.Conn, error) {
+			if address != "127.0.0.2:80" {
+				return nil, errRefused
+			}
+			c1, c2 := net.Pipe()
+			c2.Close()
+			return c1, nil
+		}}
+
+		start := time.Now()
+
+		conn, err := (&HappyEyeballsDialer{
+			Dial:         d.DialContext,
+			LookupIP:     testLookupIP([]string{"::1", "::2"}, []string{"127.0.0.1", "127.0.0.2"}, 10*time.Millisecond),
+			AttemptDelay: 1 * time.Second,
+		}).DialContext(context.Background(), "tcp", "localhost:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Error("failed attempts did not start the next one right away:", elapsed)
+		}
+
+		addrs := d.attempts()
+		expect := []string{"[::1]:80", "127.0.0.1:80", "[::2]:80", "127.0.0.2:80"}
+
+		if len(addrs) != len(expect) {
+			t.Fatal("bad attempts:", addrs)
+		}
+		for i := range addrs {
+			if addrs[i] != expect[i] {
+				t.Error("bad attempts:", addrs)
+				break
+			}
+		}
+	})
+
+	t.Run("staggered-attempts", func(t *testing.T) {
+		d := &testEyeballsDialer{dial: func(ctx context.Context, address string) (net.Conn, error) {
+			if address == "[::1]:80" {
+				<-ctx.Done() // black hole
+				return nil, ctx.Err()
+			}
+			c1, c2 := net.Pipe()
+			c2.Close()
+			return c1, nil
+		}}
+
+		start := time.Now()
+
+		conn, err := (&HappyEyeballsDialer{
+			Dial:         d.DialContext,
+			LookupIP:     testLookupIP([]string{"::1"}, []string{"127.0.0.1"}, 0),
+			AttemptDelay: 50 * time.Millisecond,
+		}).DialContext(context.Background(), "tcp", "localhost:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 1*time.Second {
+			t.Error("bad connection delay:", elapsed)
+		}
+	})
+
+	t.Run("all-failed", func(t *testing.T) {
+		d := &testEyeballsDialer{dial: func(ctx context.Context, address string) (net.Conn, error) {
+			return nil, errRefused
+		}}
+
+		_, err := (&HappyEyeballsDialer{
+			Dial:     d.DialContext,
+			LookupIP: testLookupIP([]string{"::1"}, []string{"127.0.0.1"}, 0),
+		}).DialContext(context.Background(), "tcp", "localhost:80")
+
+		if err != errRefused {
+			t.Error("bad error:", err)
+		}
+
+		if n := len(d.attempts()); n != 2 {
+			t.Error("bad number of attempts:", n)
+		}
+	})
+
+	t.Run("ip-literal", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		conn, err := (&HappyEyeballsDialer{}).DialContext(context.Background(), "tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+}
@@ -0,0 +1,46 @@
+package netx
+
+import (
+	"net"
+	"strings"
+	"syscall"
+)
+
+// FastOpenQueueLength is the maximum number of pending TCP Fast Open requests
+// configured on listening sockets by ControlFastOpenListen.
+var FastOpenQueueLength = 256
+
+// ControlFastOpenListen can be used as the Control function of a
+// net.ListenConfig to enable TCP Fast Open on the listening sockets, which
+// lets clients send data (like a TLS ClientHello or HTTP request) with the SYN
+// packet and saves a round trip when they reconnect.
+//
+// Errors setting the socket option are ignored, so the listener falls back to
+// regular TCP handshakes on platforms or kernels that don't support it.
+func ControlFastOpenListen(network string, address string, c syscall.RawConn) error {
+	if strings.HasPrefix(network, "tcp") {
+		c.Control(func(fd uintptr) { setFastOpenListen(fd, FastOpenQueueLength) })
+	}
+	return nil
+}
+
+// ControlFastOpenDial can be used as the Control function of a net.Dialer to
+// enable TCP Fast Open on the dialed connections, sending the first bytes
+// written to the connection with the SYN packet when the client has a Fast
+// Open cookie for the server.
+//
+// Errors setting the socket option are ignored, so dialing falls back to
+// regular TCP handshakes on platforms or kernels that don't support it.
+// Currently only linux supports Fast Open on dialed connections.
+func ControlFastOpenDial(network string, address string, c syscall.RawConn) error {
+	if strings.HasPrefix(network, "tcp") {
+		c.Control(func(fd uintptr) { setFastOpenDial(fd) })
+	}
+	return nil
+}
+
+// ListenFastOpen is like Listen but enables TCP Fast Open on the TCP sockets it
+// creates.
+func ListenFastOpen(address string) (net.Listener, error) {
+	return listenConfig(address, &net.ListenConfig{Control: ControlFastOpenListen})
+}
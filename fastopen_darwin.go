@@ -0,0 +1,16 @@
+package netx
+
+import "syscall"
+
+func setFastOpenListen(fd uintptr, qlen int) error {
+	const (
+		TCP_FASTOPEN = 0x105 // missing from the syscall package
+	)
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, TCP_FASTOPEN, 1)
+}
+
+func setFastOpenDial(fd uintptr) error {
+	// Fast Open on client sockets requires using connectx on darwin, which
+	// the net package doesn't do.
+	return nil
+}
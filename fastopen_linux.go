@@ -0,0 +1,17 @@
+package netx
+
+import "syscall"
+
+func setFastOpenListen(fd uintptr, qlen int) error {
+	const (
+		TCP_FASTOPEN = 23 // missing from the syscall package
+	)
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, TCP_FASTOPEN, qlen)
+}
+
+func setFastOpenDial(fd uintptr) error {
+	const (
+		TCP_FASTOPEN_CONNECT = 30 // missing from the syscall package
+	)
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, TCP_FASTOPEN_CONNECT, 1)
+}
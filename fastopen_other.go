@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package netx
+
+func setFastOpenListen(fd uintptr, qlen int) error {
+	return nil
+}
+
+func setFastOpenDial(fd uintptr) error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package netx
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestFastOpen(t *testing.T) {
+	lstn, err := ListenFastOpen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	go func() {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	dialer := &net.Dialer{Control: ControlFastOpenDial}
+
+	conn, err := dialer.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "Hello World!"); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s := string(b); s != "Hello World!" {
+		t.Errorf("bad response: %q", s)
+	}
+}
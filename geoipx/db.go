@@ -0,0 +1,273 @@
+// Package geoipx reads the MaxMind DB format used by IP geolocation databases
+// like GeoLite2 and GeoIP2, or the ones of DB-IP and IPinfo, so programs can
+// route or block traffic by origin region.
+//
+// The format is documented at https://maxmind.github.io/MaxMind-DB/.
+package geoipx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// Metadata describes a database.
+type Metadata struct {
+	// DatabaseType is the type of the database, like "GeoLite2-Country" or
+	// "GeoLite2-ASN".
+	DatabaseType string
+
+	// Description of the database, by language code.
+	Description map[string]string
+
+	// Languages is the list of languages that names are available in.
+	Languages []string
+
+	// IPVersion is 4 for databases containing only IPv4 addresses, or 6 for
+	// databases containing IPv4 and IPv6 addresses.
+	IPVersion int
+
+	// BuildTime is the time at which the database was built.
+	BuildTime time.Time
+
+	NodeCount  uint32
+	RecordSize int
+}
+
+// DB is a MaxMind database loaded in memory, it is safe to use concurrently.
+//
+// DB implements netx.IPInfoLookup, returning the country of addresses from
+// country and city databases, and their autonomous system from ASN databases.
+type DB struct {
+	meta     Metadata
+	tree     []byte // search tree
+	data     []byte // data section
+	ipv4Root uint32 // node of the ::/96 subtree in IPv6 databases
+}
+
+var _ netx.IPInfoLookup = (*DB)(nil)
+
+// metadataMarker separates the metadata section from the rest of a database.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMetadataSize bounds the search for the metadata section.
+const maxMetadataSize = 128 * 1024
+
+// Open loads the database at path.
+func Open(path string) (*DB, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := New(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// New returns a database reading from b, which must not be modified while
+// the database is in use.
+func New(b []byte) (*DB, error) {
+	start := len(b) - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+
+	i := bytes.LastIndex(b[start:], metadataMarker)
+	if i < 0 {
+		return nil, errors.New("invalid MaxMind database: metadata not found")
+	}
+
+	metaStart := start + i + len(metadataMarker)
+	v, _, err := (&decoder{data: b[metaStart:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind database metadata: %w", err)
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind database metadata: not a map")
+	}
+
+	db := &DB{
+		meta: Metadata{
+			DatabaseType: stringValue(m["database_type"]),
+			IPVersion:    int(uintValue(m["ip_version"])),
+			BuildTime:    time.Unix(int64(uintValue(m["build_epoch"])), 0).UTC(),
+			NodeCount:    uint32(uintValue(m["node_count"])),
+			RecordSize:   int(uintValue(m["record_size"])),
+		},
+	}
+
+	if desc, ok := m["description"].(map[string]interface{}); ok {
+		db.meta.Description = make(map[string]string, len(desc))
+		for lang, s := range desc {
+			db.meta.Description[lang] = stringValue(s)
+		}
+	}
+
+	if langs, ok := m["languages"].([]interface{}); ok {
+		for _, lang := range langs {
+			db.meta.Languages = append(db.meta.Languages, stringValue(lang))
+		}
+	}
+
+	if major := uintValue(m["binary_format_major_version"]); major != 2 {
+		return nil, fmt.Errorf("unsupported MaxMind database format version: %d", major)
+	}
+
+	switch db.meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MaxMind database record size: %d", db.meta.RecordSize)
+	}
+
+	switch db.meta.IPVersion {
+	case 4, 6:
+	default:
+		return nil, fmt.Errorf("unsupported MaxMind database IP version: %d", db.meta.IPVersion)
+	}
+
+	treeSize := int(db.meta.NodeCount) * db.meta.RecordSize / 4
+	dataStart := treeSize + 16 // the tree is followed by 16 zero bytes
+
+	if dataStart > start+i {
+		return nil, errors.New("invalid MaxMind database: the search tree overflows the file")
+	}
+
+	db.tree = b[:treeSize]
+	db.data = b[dataStart : start+i]
+
+	if db.meta.IPVersion == 6 {
+		node := uint32(0)
+		for i := 0; i < 96 && node < db.meta.NodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Root = node
+	}
+
+	return db, nil
+}
+
+// Metadata returns the metadata of the database.
+func (db *DB) Metadata() Metadata {
+	return db.meta
+}
+
+// Lookup returns the record of the database for ip, decoded as maps, slices,
+// strings, numbers (uint64, int32, float32, float64, or *big.Int), booleans,
+// and byte slices. The record is nil if the database has no data for ip.
+func (db *DB) Lookup(ip net.IP) (interface{}, error) {
+	offset, ok, err := db.lookup(ip)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	v, _, err := (&decoder{data: db.data}).decode(offset)
+	return v, err
+}
+
+// LookupIPInfo satisfies the netx.IPInfoLookup interface.
+func (db *DB) LookupIPInfo(ip net.IP) (netx.IPInfo, error) {
+	var info netx.IPInfo
+
+	v, err := db.Lookup(ip)
+	if err != nil {
+		return info, err
+	}
+
+	m, _ := v.(map[string]interface{})
+
+	country, _ := m["country"].(map[string]interface{})
+	if country == nil {
+		// Addresses of anycast networks or satellite providers only have the
+		// country they are registered in.
+		country, _ = m["registered_country"].(map[string]interface{})
+	}
+
+	info.Country = strings.ToUpper(stringValue(country["iso_code"]))
+	info.ASN = uint32(uintValue(m["autonomous_system_number"]))
+	info.Organization = stringValue(m["autonomous_system_organization"])
+	return info, nil
+}
+
+// lookup returns the offset of the record for ip in the data section.
+func (db *DB) lookup(ip net.IP) (offset int, ok bool, err error) {
+	var node uint32
+	var bits []byte
+
+	if ip4 := ip.To4(); ip4 != nil {
+		node, bits = db.ipv4Root, ip4
+	} else if ip16 := ip.To16(); ip16 != nil && db.meta.IPVersion == 6 {
+		node, bits = 0, ip16
+	} else if ip16 != nil {
+		return 0, false, nil // IPv6 address in an IPv4 database
+	} else {
+		return 0, false, fmt.Errorf("invalid IP address: %v", ip)
+	}
+
+	count := db.meta.NodeCount
+
+	for i := 0; i < 8*len(bits) && node < count; i++ {
+		node = db.record(node, (bits[i/8]>>(7-uint(i%8)))&1)
+	}
+
+	switch {
+	case node == count:
+		return 0, false, nil
+	case node < count:
+		return 0, false, errors.New("invalid MaxMind database: the search tree is too deep")
+	}
+
+	offset = int(node-count) - 16
+	if offset < 0 || offset >= len(db.data) {
+		return 0, false, fmt.Errorf("invalid MaxMind database: data offset out of bounds: %d", offset)
+	}
+	return offset, true, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *DB) record(node uint32, bit byte) uint32 {
+	size := db.meta.RecordSize / 4
+	b := db.tree[int(node)*size : int(node+1)*size]
+
+	switch db.meta.RecordSize {
+	case 24:
+		b = b[3*bit:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		b = b[4*bit:]
+		return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	}
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func uintValue(v interface{}) uint64 {
+	switch x := v.(type) {
+	case uint64:
+		return x
+	case int32:
+		if x > 0 {
+			return uint64(x)
+		}
+	}
+	return 0
+}
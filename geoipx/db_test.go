@@ -0,0 +1,419 @@
+package geoipx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/segmentio/netx"
+)
+
+func TestDB(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			t.Run("", func(t *testing.T) {
+				testDB(t, recordSize, ipVersion)
+			})
+		}
+	}
+}
+
+func testDB(t *testing.T, recordSize int, ipVersion int) {
+	w := &testWriter{ipVersion: ipVersion}
+	fr := w.pointer("FR")
+
+	w.insert("1.0.0.0/24", map[string]interface{}{
+		"country":                        map[string]interface{}{"iso_code": "AU"},
+		"autonomous_system_number":       uint32(13335),
+		"autonomous_system_organization": "CLOUDFLARENET",
+	})
+	w.insert("2.0.0.0/16", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": fr},
+	})
+	w.insert("2.1.0.0/16", map[string]interface{}{
+		"registered_country": map[string]interface{}{"iso_code": fr},
+	})
+	if ipVersion == 6 {
+		w.insert("2001:db8::/32", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "de"},
+		})
+	}
+
+	db, err := New(w.bytes(recordSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := db.Metadata()
+	if meta.DatabaseType != "Test" || meta.IPVersion != ipVersion || meta.RecordSize != recordSize || meta.Description["en"] != "test database" {
+		t.Errorf("bad metadata: %+v", meta)
+	}
+
+	tests := []struct {
+		ip   string
+		info netx.IPInfo
+	}{
+		{"1.0.0.1", netx.IPInfo{Country: "AU", ASN: 13335, Organization: "CLOUDFLARENET"}},
+		{"::ffff:1.0.0.255", netx.IPInfo{Country: "AU", ASN: 13335, Organization: "CLOUDFLARENET"}},
+		{"1.0.1.1", netx.IPInfo{}},
+		{"2.0.42.42", netx.IPInfo{Country: "FR"}},
+		{"2.1.0.1", netx.IPInfo{Country: "FR"}},
+		{"2.2.0.1", netx.IPInfo{}},
+		{"192.0.2.1", netx.IPInfo{}},
+		{"2001:db9::1", netx.IPInfo{}},
+	}
+
+	if ipVersion == 6 {
+		tests = append(tests, struct {
+			ip   string
+			info netx.IPInfo
+		}{"2001:db8::1", netx.IPInfo{Country: "DE"}})
+	}
+
+	for _, test := range tests {
+		info, err := db.LookupIPInfo(net.ParseIP(test.ip))
+		if err != nil {
+			t.Errorf("%s: %s", test.ip, err)
+		} else if info != test.info {
+			t.Errorf("%s: bad info: %+v", test.ip, info)
+		}
+	}
+}
+
+func TestDBLookup(t *testing.T) {
+	record := map[string]interface{}{
+		"string":  "hello",
+		"bytes":   []byte{1, 2, 3},
+		"float64": 0.5,
+		"float32": float32(0.25),
+		"uint16":  uint16(42),
+		"uint32":  uint32(1 << 20),
+		"uint64":  uint64(1 << 40),
+		"int32":   int32(-1),
+		"uint128": new(big.Int).Lsh(big.NewInt(1), 100),
+		"bool":    true,
+		"array":   []interface{}{"a", uint32(1)},
+		"long":    string(bytes.Repeat([]byte("x"), 300)),
+	}
+
+	w := &testWriter{ipVersion: 6}
+	w.insert("::/1", record)
+
+	db, err := New(w.bytes(28))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := db.Lookup(net.ParseIP("2001:db8::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"string":  "hello",
+		"bytes":   []byte{1, 2, 3},
+		"float64": 0.5,
+		"float32": float32(0.25),
+		"uint16":  uint64(42),
+		"uint32":  uint64(1 << 20),
+		"uint64":  uint64(1 << 40),
+		"int32":   int32(-1),
+		"uint128": new(big.Int).Lsh(big.NewInt(1), 100),
+		"bool":    true,
+		"array":   []interface{}{"a", uint64(1)},
+		"long":    string(bytes.Repeat([]byte("x"), 300)),
+	}
+
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("bad record:\n%#v\n%#v", v, expected)
+	}
+}
+
+func TestDBInvalid(t *testing.T) {
+	w := &testWriter{ipVersion: 4}
+	w.insert("1.0.0.0/8", map[string]interface{}{"country": map[string]interface{}{"iso_code": "AU"}})
+	b := w.bytes(24)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no metadata", b[:len(b)-100]},
+		{"truncated", b[len(b)/2:]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if db, err := New(test.data); err == nil {
+				// The metadata may be intact, in which case lookups must
+				// fail instead.
+				if _, err := db.Lookup(net.ParseIP("1.2.3.4")); err == nil {
+					t.Error("no error returned for an invalid database")
+				}
+			}
+		})
+	}
+
+	// Corrupted data sections return errors instead of panicking.
+	for i := 0; i < len(b); i++ {
+		c := append([]byte{}, b...)
+		c[i] ^= 0xff
+		if db, err := New(c); err == nil {
+			db.Lookup(net.ParseIP("1.2.3.4"))
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoipx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := &testWriter{ipVersion: 6}
+	w.insert("1.0.0.0/8", map[string]interface{}{"country": map[string]interface{}{"iso_code": "AU"}})
+
+	path := filepath.Join(dir, "test.mmdb")
+	if err := ioutil.WriteFile(path, w.bytes(24), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info, _ := db.LookupIPInfo(net.ParseIP("1.1.1.1")); info.Country != "AU" {
+		t.Errorf("bad info: %+v", info)
+	}
+
+	if _, err := Open(filepath.Join(dir, "missing.mmdb")); !os.IsNotExist(err) {
+		t.Error("bad error when opening a missing database:", err)
+	}
+}
+
+// testWriter builds MaxMind databases for tests.
+type testWriter struct {
+	ipVersion int
+	root      testNode
+	data      []byte
+}
+
+type testNode struct {
+	children [2]*testNode
+	leaf     bool
+	offset   int
+	index    uint32
+}
+
+// testPointer is a value written once in the data section and referenced by
+// pointers.
+type testPointer int
+
+func (w *testWriter) pointer(v interface{}) testPointer {
+	offset := len(w.data)
+	w.data = appendValue(w.data, v)
+	return testPointer(offset)
+}
+
+func (w *testWriter) insert(cidr string, v interface{}) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	ip, bits := network.IP, 0
+	ones, _ := network.Mask.Size()
+
+	if ip4 := ip.To4(); ip4 != nil && w.ipVersion == 6 {
+		ip, bits = ip.To16(), 96 // IPv4 addresses are in the ::/96 subtree
+		copy(ip[10:12], []byte{0, 0})
+	} else if ip4 != nil {
+		ip = ip4
+	}
+
+	node := &w.root
+
+	for i := 0; i < bits+ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &testNode{}
+		}
+		node = node.children[bit]
+	}
+
+	node.leaf, node.offset = true, len(w.data)
+	w.data = appendValue(w.data, v)
+}
+
+func (w *testWriter) bytes(recordSize int) []byte {
+	// Number the internal nodes in breadth-first order, starting with the
+	// root at index 0.
+	var nodes []*testNode
+	queue := []*testNode{&w.root}
+
+	for len(queue) != 0 {
+		n := queue[0]
+		queue = queue[1:]
+		n.index = uint32(len(nodes))
+		nodes = append(nodes, n)
+
+		for _, c := range n.children {
+			if c != nil && !c.leaf {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	count := uint32(len(nodes))
+	record := func(c *testNode) uint32 {
+		switch {
+		case c == nil:
+			return count
+		case c.leaf:
+			return count + 16 + uint32(c.offset)
+		default:
+			return c.index
+		}
+	}
+
+	var b []byte
+
+	for _, n := range nodes {
+		l, r := record(n.children[0]), record(n.children[1])
+
+		switch recordSize {
+		case 24:
+			b = append(b, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			b = append(b, byte(l>>16), byte(l>>8), byte(l), byte(l>>24)<<4|byte(r>>24)&0xf, byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			b = append(b, byte(l>>24), byte(l>>16), byte(l>>8), byte(l), byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	b = append(b, make([]byte, 16)...)
+	b = append(b, w.data...)
+	b = append(b, metadataMarker...)
+	b = appendValue(b, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{"en": "test database"},
+		"ip_version":                  uint16(w.ipVersion),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  count,
+		"record_size":                 uint16(recordSize),
+	})
+	return b
+}
+
+func appendControl(b []byte, typ int, size int) []byte {
+	var ext []byte
+	var sizeBytes []byte
+
+	switch {
+	case size < 29:
+	case size < 285:
+		size, sizeBytes = 29, []byte{byte(size - 29)}
+	case size < 65821:
+		n := size - 285
+		size, sizeBytes = 30, []byte{byte(n >> 8), byte(n)}
+	default:
+		n := size - 65821
+		size, sizeBytes = 31, []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	if typ > 7 {
+		typ, ext = 0, []byte{byte(typ - 7)}
+	}
+
+	b = append(b, byte(typ<<5|size))
+	b = append(b, ext...)
+	return append(b, sizeBytes...)
+}
+
+func appendUint(b []byte, typ int, u uint64) []byte {
+	var n []byte
+	for ; u != 0; u >>= 8 {
+		n = append([]byte{byte(u)}, n...)
+	}
+	return append(appendControl(b, typ, len(n)), n...)
+}
+
+func appendValue(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case testPointer:
+		return append(b, byte(typePointer<<5|int(x)>>8&0x7), byte(x))
+	case string:
+		return append(appendControl(b, typeString, len(x)), x...)
+	case []byte:
+		return append(appendControl(b, typeBytes, len(x)), x...)
+	case float64:
+		b = appendControl(b, typeFloat64, 8)
+		return append(b, float64Bytes(x)...)
+	case float32:
+		b = appendControl(b, typeFloat32, 4)
+		return append(b, float32Bytes(x)...)
+	case uint16:
+		return appendUint(b, typeUint16, uint64(x))
+	case uint32:
+		return appendUint(b, typeUint32, uint64(x))
+	case uint64:
+		return appendUint(b, typeUint64, x)
+	case int32:
+		b = appendControl(b, typeInt32, 4)
+		return append(b, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+	case *big.Int:
+		n := x.Bytes()
+		return append(appendControl(b, typeUint128, len(n)), n...)
+	case bool:
+		if x {
+			return appendControl(b, typeBool, 1)
+		}
+		return appendControl(b, typeBool, 0)
+	case []interface{}:
+		b = appendControl(b, typeArray, len(x))
+		for _, e := range x {
+			b = appendValue(b, e)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendControl(b, typeMap, len(x))
+		for _, k := range keys {
+			b = appendValue(b, k)
+			b = appendValue(b, x[k])
+		}
+		return b
+	default:
+		panic("unsupported type")
+	}
+}
+
+func float64Bytes(f float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
+
+func float32Bytes(f float32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, math.Float32bits(f))
+	return b
+}
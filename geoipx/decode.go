@@ -0,0 +1,256 @@
+package geoipx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Types of the values of the data section.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeFloat64   = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat32   = 15
+)
+
+// maxDepth bounds the nesting of maps and arrays, so corrupted databases cannot
+// exhaust the stack.
+const maxDepth = 64
+
+var errTruncated = errors.New("truncated data")
+
+// decoder decodes the values of a data section.
+type decoder struct {
+	data  []byte
+	depth int
+}
+
+// decode decodes the value at offset, and returns the offset of the next one.
+func (d *decoder) decode(offset int) (interface{}, int, error) {
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		ptr, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Pointers cannot point to pointers, which prevents cycles.
+		if t, _, _, err := d.decodeControl(ptr); err != nil || t == typePointer {
+			return nil, 0, fmt.Errorf("invalid pointer at offset %d", offset)
+		}
+		v, _, err := d.decode(ptr)
+		return v, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		return d.decodeMap(size, offset)
+	case typeArray:
+		return d.decodeArray(size, offset)
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("invalid boolean size: %d", size)
+		}
+		return size != 0, offset, nil
+	}
+
+	if size > len(d.data)-offset {
+		return nil, 0, errTruncated
+	}
+
+	b, next := d.data[offset:offset+size], offset+size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+
+	case typeBytes:
+		return append([]byte{}, b...), next, nil
+
+	case typeFloat64:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size: %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+
+	case typeFloat32:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size: %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+
+	case typeUint16, typeUint32, typeUint64:
+		if (typ == typeUint16 && size > 2) || (typ == typeUint32 && size > 4) || size > 8 {
+			return nil, 0, fmt.Errorf("invalid unsigned integer size: %d", size)
+		}
+		return decodeUint(b), next, nil
+
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid signed integer size: %d", size)
+		}
+		return int32(decodeUint(b)), next, nil
+
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid unsigned integer size: %d", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d at offset %d", typ, offset)
+	}
+}
+
+// decodeControl decodes the control byte of the value at offset, it returns
+// the type and size of the value, and the offset of its payload.
+func (d *decoder) decodeControl(offset int) (typ int, size int, next int, err error) {
+	if offset >= len(d.data) {
+		return 0, 0, 0, errTruncated
+	}
+
+	ctrl := d.data[offset]
+	offset++
+	typ = int(ctrl >> 5)
+
+	if typ == typeExtended {
+		if offset >= len(d.data) {
+			return 0, 0, 0, errTruncated
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+	}
+
+	size = int(ctrl & 0x1f)
+
+	if typ == typePointer {
+		return typ, int(ctrl & 0x1f), offset, nil
+	}
+
+	if size >= 29 {
+		n := size - 28 // number of bytes of the size
+		if n > len(d.data)-offset {
+			return 0, 0, 0, errTruncated
+		}
+		b := d.data[offset : offset+n]
+		offset += n
+
+		switch n {
+		case 1:
+			size = 29 + int(b[0])
+		case 2:
+			size = 285 + (int(b[0])<<8 | int(b[1]))
+		default:
+			size = 65821 + (int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+		}
+	}
+
+	return typ, size, offset, nil
+}
+
+// decodePointer decodes the pointer with the given control bits at offset.
+func (d *decoder) decodePointer(bits int, offset int) (ptr int, next int, err error) {
+	n := (bits>>3)&0x3 + 1 // number of bytes of the pointer
+	if n > len(d.data)-offset {
+		return 0, 0, errTruncated
+	}
+
+	b := d.data[offset : offset+n]
+	v := bits & 0x7
+
+	switch n {
+	case 1:
+		ptr = v<<8 | int(b[0])
+	case 2:
+		ptr = (v<<16 | int(b[0])<<8 | int(b[1])) + 2048
+	case 3:
+		ptr = (v<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336
+	default:
+		ptr = int(binary.BigEndian.Uint32(b))
+	}
+
+	return ptr, offset + n, nil
+}
+
+func (d *decoder) decodeMap(size int, offset int) (interface{}, int, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	defer func() { d.depth-- }()
+
+	if size > (len(d.data)-offset)/2 {
+		return nil, 0, errTruncated // each key and value takes at least one byte
+	}
+
+	m := make(map[string]interface{}, size)
+
+	for i := 0; i < size; i++ {
+		k, next, err := d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		key, ok := k.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid map key at offset %d", offset)
+		}
+
+		v, next, err := d.decode(next)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		m[key], offset = v, next
+	}
+
+	return m, offset, nil
+}
+
+func (d *decoder) decodeArray(size int, offset int) (interface{}, int, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	defer func() { d.depth-- }()
+
+	if size > len(d.data)-offset {
+		return nil, 0, errTruncated // each value takes at least one byte
+	}
+
+	a := make([]interface{}, size)
+
+	for i := range a {
+		v, next, err := d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		a[i], offset = v, next
+	}
+
+	return a, offset, nil
+}
+
+func decodeUint(b []byte) uint64 {
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
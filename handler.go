@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"io"
+	"log"
 	"net"
 	"time"
 )
@@ -38,6 +39,24 @@ func CloseHandler(handler Handler) Handler {
 	})
 }
 
+// RecoverHandler wraps handler to recover from the panics it raises, the error
+// and stack trace are written to logger (or the standard logger if nil) and
+// the connection is closed, keeping the rest of the program alive.
+//
+// Servers already recover from panics, RecoverHandler is useful to serve
+// connections that weren't accepted by a Server.
+func RecoverHandler(handler Handler, logger *log.Logger) Handler {
+	return HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		defer func() {
+			if err := recover(); err != nil {
+				Recover(err, conn, logger)
+				conn.Close()
+			}
+		}()
+		handler.ServeConn(ctx, conn)
+	})
+}
+
 var (
 	// Echo is the implementation of a connection handler that simply sends what
 	// it receives back to the client.
@@ -1,8 +1,12 @@
 package netx
 
 import (
+	"bytes"
 	"context"
 	"io/ioutil"
+	"log"
+	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -87,3 +91,27 @@ func TestPass(t *testing.T) {
 		t.Error("bad output:", s)
 	}
 }
+
+func TestRecoverHandler(t *testing.T) {
+	c1, c2, err := TCPConnPair("tcp")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	buf := &bytes.Buffer{}
+	handler := RecoverHandler(HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		panic("oops")
+	}), log.New(buf, "", 0))
+
+	handler.ServeConn(context.Background(), c2)
+
+	if _, err := ioutil.ReadAll(c1); err != nil {
+		t.Error(err)
+	}
+	if s := buf.String(); !strings.Contains(s, "panic serving") || !strings.Contains(s, "oops") {
+		t.Error("bad log output:", s)
+	}
+}
@@ -0,0 +1,136 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/segmentio/netx"
+)
+
+// ACLHandler is a HTTP handler which rejects the requests from clients that its
+// ACL doesn't allow with 403 Forbidden, and passes the other requests to its
+// sub-handler.
+//
+// The client address is determined by ClientIP, so the handler can be placed
+// behind load balancers or other proxies listed in TrustedProxies which append
+// the client addresses to the ClientIPHeader. When the
+// handler is served by a listener accepting the proxy protocol (see
+// netx.ProxyProtoListener), the address of the connections is already the
+// one of the clients.
+type ACLHandler struct {
+	// Handler is the sub-handler that the ACLHandler delegates the allowed
+	// requests to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+
+	// ACL decides which clients the requests are allowed from.
+	ACL *netx.ACL
+
+	// TrustedProxies is the list of networks of the proxies that the
+	// forwarding headers are trusted from. If empty, the headers are ignored.
+	TrustedProxies []*net.IPNet
+
+	// ClientIPHeader is the name of the forwarding header that the trusted
+	// proxies append the client addresses to, see ClientIP.
+	// If empty, the headers are ignored.
+	ClientIPHeader string
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *ACLHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if ip := ClientIP(req, h.ClientIPHeader, h.TrustedProxies); ip == nil || !h.ACL.Allowed(ip) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	h.Handler.ServeHTTP(w, req)
+}
+
+// ClientIP returns the IP address of the client that sent req, or nil if it
+// could not be determined.
+//
+// The header argument is the name of the forwarding header that the trusted
+// proxies append the addresses they received requests from to, either
+// "Forwarded" (RFC 7239) or "X-Forwarded-For". Only this header is read: load
+// balancers usually maintain one of them and pass the other through untouched,
+// so the addresses that clients put in it could otherwise be used. If header is
+// empty or names another header, the forwarding headers are ignored.
+//
+// When the request was received from one of the trusted proxies, the client
+// is found by walking the addresses of the header from the last to the first,
+// skipping the addresses of trusted proxies. Trusting a proxy means trusting
+// that it appends the address it received the request from to the header, the
+// addresses that clients put in the header are never used.
+//
+// Addresses hidden by a proxy, like "unknown" or obfuscated identifiers, make
+// the client IP unknown.
+func ClientIP(req *http.Request, header string, trustedProxies []*net.IPNet) net.IP {
+	ip := parseIP(req.RemoteAddr)
+
+	if ip == nil || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+
+	var addrs []string
+
+	switch http.CanonicalHeaderKey(header) {
+	case "Forwarded":
+		forEachHeaderValues(req.Header["Forwarded"], func(v string) {
+			addrs = append(addrs, forwardedFor(v))
+		})
+	case "X-Forwarded-For":
+		forEachHeaderValues(req.Header["X-Forwarded-For"], func(v string) {
+			addrs = append(addrs, v)
+		})
+	default:
+		return ip
+	}
+
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if ip = parseIP(addrs[i]); ip == nil || !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// forwardedFor returns the value of the "for" parameter of a Forwarded header
+// element.
+func forwardedFor(elem string) string {
+	for len(elem) != 0 {
+		var pair, name, value string
+		pair, elem = splitTrimOWS(elem, ';')
+		name, value = splitTrimOWS(pair, '=')
+
+		if strings.EqualFold(name, "for") {
+			q, err := parseQuoted(value)
+			if err != nil {
+				return ""
+			}
+			return string(q)
+		}
+	}
+	return ""
+}
+
+// parseIP parses the IP address of addr, which may have a port and be
+// enclosed in brackets.
+func parseIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	} else if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		addr = addr[1 : len(addr)-1]
+	}
+	return net.ParseIP(addr)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
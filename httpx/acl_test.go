@@ -0,0 +1,151 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/segmentio/netx"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, _ := netx.ParseCIDRs("10.0.0.0/8", "2001:db8::/32")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		trusted    string
+		ip         string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "192.0.2.1:56789",
+			trusted:    "Forwarded",
+			ip:         "192.0.2.1",
+		},
+		{
+			name:       "untrusted forwarding headers",
+			remoteAddr: "192.0.2.1:56789",
+			header:     http.Header{"Forwarded": {"for=198.51.100.1"}},
+			trusted:    "Forwarded",
+			ip:         "192.0.2.1",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="[2001:db8::1]:4711";by=10.0.0.2`}},
+			trusted:    "Forwarded",
+			ip:         "198.51.100.1",
+		},
+		{
+			name:       "spoofed forwarded",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"Forwarded": {"for=127.0.0.1", "for=192.0.2.1"}},
+			trusted:    "Forwarded",
+			ip:         "192.0.2.1",
+		},
+		{
+			name:       "x-forwarded-for",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"X-Forwarded-For": {"127.0.0.1, 192.0.2.1, 10.0.0.2"}},
+			trusted:    "X-Forwarded-For",
+			ip:         "192.0.2.1",
+		},
+		{
+			name:       "forwarded passed through by the trusted proxy",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"Forwarded": {"for=127.0.0.1"}, "X-Forwarded-For": {"192.0.2.2"}},
+			trusted:    "X-Forwarded-For",
+			ip:         "192.0.2.2",
+		},
+		{
+			name:       "x-forwarded-for passed through by the trusted proxy",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"Forwarded": {"for=192.0.2.1"}, "X-Forwarded-For": {"127.0.0.1"}},
+			trusted:    "forwarded",
+			ip:         "192.0.2.1",
+		},
+		{
+			name:       "no trusted header",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"Forwarded": {"for=192.0.2.1"}, "X-Forwarded-For": {"192.0.2.2"}},
+			ip:         "10.0.0.1",
+		},
+		{
+			name:       "only trusted proxies",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.2"}},
+			trusted:    "X-Forwarded-For",
+			ip:         "10.0.0.2",
+		},
+		{
+			name:       "unknown",
+			remoteAddr: "10.0.0.1:56789",
+			header:     http.Header{"Forwarded": {"for=192.0.2.1, for=unknown"}},
+			trusted:    "Forwarded",
+		},
+		{
+			name:    "no address",
+			trusted: "Forwarded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			req.Header = test.header
+
+			ip := ClientIP(req, test.trusted, trusted)
+
+			if expected := net.ParseIP(test.ip); !ip.Equal(expected) {
+				t.Errorf("bad client IP: %s", ip)
+			}
+		})
+	}
+}
+
+func TestACLHandler(t *testing.T) {
+	trusted, _ := netx.ParseCIDRs("10.0.0.0/8")
+	deny, _ := netx.ParseCIDRs("192.0.2.0/24")
+
+	h := &ACLHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		ACL:            &netx.ACL{Deny: deny},
+		TrustedProxies: trusted,
+		ClientIPHeader: "Forwarded",
+	}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		status     int
+	}{
+		{"198.51.100.1:56789", "", http.StatusNoContent},
+		{"192.0.2.1:56789", "", http.StatusForbidden},
+		{"10.0.0.1:56789", "for=192.0.2.1", http.StatusForbidden},
+		{"10.0.0.1:56789", "for=198.51.100.1", http.StatusNoContent},
+		{"10.0.0.1:56789", "for=_hidden", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.remoteAddr+" "+test.forwarded, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if len(test.forwarded) != 0 {
+				req.Header.Set("Forwarded", test.forwarded)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != test.status {
+				t.Error("bad status:", w.Code)
+			}
+		})
+	}
+}
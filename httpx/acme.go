@@ -0,0 +1,69 @@
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ACMEChallengePath is the path prefix of the URLs that ACME servers request to
+// validate HTTP-01 challenges (see RFC 8555, section 8.3).
+const ACMEChallengePath = "/.well-known/acme-challenge/"
+
+// ACMEHandler is a HTTP handler which serves the responses to the HTTP-01
+// challenges of ACME servers, it must be exposed on port 80 of the domains
+// that certificates are requested for.
+//
+// The handler is intended to be used with an ACME client which obtains the
+// certificates, like tlsx.Autocert whose KeyAuthorization method can be used as
+// the KeyAuthorization function of the handler.
+type ACMEHandler struct {
+	// KeyAuthorization is called with the token of a challenge and returns
+	// the key authorization that the handler must respond with, or false if
+	// the token is unknown.
+	//
+	// ServeHTTP will panic if KeyAuthorization is nil.
+	KeyAuthorization func(token string) (string, bool)
+
+	// Handler is called for requests which are not ACME challenges. If nil,
+	// GET and HEAD requests are redirected to https and other requests are
+	// rejected with 400 Bad Request.
+	Handler http.Handler
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *ACMEHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, ACMEChallengePath) {
+		h.serveFallback(w, req)
+		return
+	}
+
+	token := req.URL.Path[len(ACMEChallengePath):]
+	keyAuth, ok := h.KeyAuthorization(token)
+
+	if !ok || len(token) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+func (h *ACMEHandler) serveFallback(w http.ResponseWriter, req *http.Request) {
+	if h.Handler != nil {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	host := req.Host
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusFound)
+}
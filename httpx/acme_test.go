@@ -0,0 +1,51 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestACMEHandler(t *testing.T) {
+	h := &ACMEHandler{
+		KeyAuthorization: func(token string) (string, bool) {
+			if token == "abc" {
+				return "abc.xyz", true
+			}
+			return "", false
+		},
+	}
+
+	tests := []struct {
+		method   string
+		url      string
+		status   int
+		body     string
+		location string
+	}{
+		{"GET", "http://example.com/.well-known/acme-challenge/abc", http.StatusOK, "abc.xyz", ""},
+		{"GET", "http://example.com/.well-known/acme-challenge/123", http.StatusNotFound, "", ""},
+		{"GET", "http://example.com:80/path?q=1", http.StatusFound, "", "https://example.com/path?q=1"},
+		{"POST", "http://example.com/path", http.StatusBadRequest, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, nil)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+
+			if len(test.body) != 0 && res.Body.String() != test.body {
+				t.Errorf("bad body: %q", res.Body.String())
+			}
+
+			if location := res.Header().Get("Location"); location != test.location {
+				t.Errorf("bad location: %q", location)
+			}
+		})
+	}
+}
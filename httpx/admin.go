@@ -0,0 +1,365 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/netx"
+)
+
+// AdminHandler is a HTTP handler which exposes an API to inspect and change
+// the state of a proxy at runtime. The API has no authentication, it must be
+// served on a separate listener which only operators can reach, typically
+// bound to the loopback interface:
+//
+//	admin := &httpx.AdminHandler{}
+//	admin.HandlePool("api", pool)
+//	admin.HandleRateLimit("public", lstn)
+//	go http.ListenAndServe("127.0.0.1:9901", admin)
+//
+// The API has the following endpoints, request and response bodies are JSON
+// objects:
+//
+//	GET   /backends                      state of the backends of all pools
+//	GET   /backends/{pool}               state of the backends of a pool
+//	PUT   /backends/{pool}               replace the backends of a pool
+//	PATCH /backends/{pool}/{addr}        change the weight or draining state of a backend
+//	POST  /backends/{pool}/{addr}/drain  stop sending new requests to a backend
+//	GET   /limits                        rate and burst of all rate limits
+//	PUT   /limits/{name}                 change the rate and burst of a rate limit
+//	GET   /config                        dump of the backends, limits, and config
+//
+// Each change is applied atomically, requests which fail validation leave the
+// state unchanged and get a 400 Bad Request response.
+//
+// Requests which change the state must have a "Content-Type: application/json"
+// header, others get a 415 Unsupported Media Type response. Browsers do not
+// send cross-origin requests with this content type without a preflight, so
+// web pages visited by operators cannot change the state of the proxy.
+//
+// It is safe to register new pools and rate limits while the handler is
+// serving requests.
+type AdminHandler struct {
+	// Config, if not nil, returns the configuration of the program which is
+	// included in the responses of the /config endpoint. The function must
+	// return a value which can be encoded to JSON and be safe to call
+	// concurrently.
+	Config func() interface{}
+
+	mutex  sync.RWMutex
+	pools  map[string]*BackendPool
+	limits map[string]*netx.RateLimitListener
+}
+
+// adminBackendPatch is the body of PATCH /backends/{pool}/{addr} requests, the
+// fields which are not set are left unchanged.
+type adminBackendPatch struct {
+	Weight   *int  `json:"weight"`
+	Draining *bool `json:"draining"`
+}
+
+// adminLimit is the representation of rate limits in the admin API.
+type adminLimit struct {
+	Rate  int `json:"rate"`
+	Burst int `json:"burst"`
+}
+
+func (limit adminLimit) validate() error {
+	if limit.Rate <= 0 {
+		return fmt.Errorf("invalid rate limit: the rate must be greater than zero but got %d", limit.Rate)
+	}
+	if limit.Burst < 0 {
+		return fmt.Errorf("invalid rate limit: the burst cannot be negative but got %d", limit.Burst)
+	}
+	return nil
+}
+
+// adminMaxBodySize is the maximum size of the request bodies accepted by
+// AdminHandler.
+const adminMaxBodySize = 1 << 20
+
+// HandlePool registers pool under name.
+func (h *AdminHandler) HandlePool(name string, pool *BackendPool) {
+	h.mutex.Lock()
+	if h.pools == nil {
+		h.pools = make(map[string]*BackendPool)
+	}
+	h.pools[name] = pool
+	h.mutex.Unlock()
+}
+
+// RemovePool removes the pool registered under name.
+func (h *AdminHandler) RemovePool(name string) {
+	h.mutex.Lock()
+	delete(h.pools, name)
+	h.mutex.Unlock()
+}
+
+// HandleRateLimit registers the rate limit of lstn under name.
+func (h *AdminHandler) HandleRateLimit(name string, lstn *netx.RateLimitListener) {
+	h.mutex.Lock()
+	if h.limits == nil {
+		h.limits = make(map[string]*netx.RateLimitListener)
+	}
+	h.limits[name] = lstn
+	h.mutex.Unlock()
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	if !isSafeMethod(req.Method) && !isJSONRequest(req) {
+		http.Error(w, "admin requests changing the state must be sent with Content-Type: application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	switch path[0] {
+	case "backends":
+		h.serveBackends(w, req, path[1:])
+	case "limits":
+		h.serveLimits(w, req, path[1:])
+	case "config":
+		h.serveConfig(w, req, path[1:])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (h *AdminHandler) serveBackends(w http.ResponseWriter, req *http.Request, path []string) {
+	if len(path) == 0 {
+		if allowMethods(w, req, http.MethodGet, http.MethodHead) {
+			writeAdminResponse(w, req, h.backends())
+		}
+		return
+	}
+
+	pool := h.pool(path[0])
+	if pool == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	var err error
+
+	switch len(path) {
+	case 1:
+		if !allowMethods(w, req, http.MethodGet, http.MethodHead, http.MethodPut) {
+			return
+		}
+		if req.Method == http.MethodPut {
+			var backends []Backend
+			if err = readAdminRequest(req, &backends); err == nil {
+				err = pool.SetBackends(backends)
+			}
+		}
+
+	case 2:
+		if !allowMethods(w, req, http.MethodPatch) {
+			return
+		}
+		var patch adminBackendPatch
+		if err = readAdminRequest(req, &patch); err == nil {
+			err = pool.Update(path[1], func(b *Backend) {
+				if patch.Weight != nil {
+					b.Weight = *patch.Weight
+				}
+				if patch.Draining != nil {
+					b.Draining = *patch.Draining
+				}
+			})
+		}
+
+	case 3:
+		if path[2] != "drain" {
+			http.NotFound(w, req)
+			return
+		}
+		if !allowMethods(w, req, http.MethodPost) {
+			return
+		}
+		err = pool.Drain(path[1])
+
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	writeAdminResponse(w, req, pool.Backends())
+}
+
+func (h *AdminHandler) serveLimits(w http.ResponseWriter, req *http.Request, path []string) {
+	switch len(path) {
+	case 0:
+		if allowMethods(w, req, http.MethodGet, http.MethodHead) {
+			writeAdminResponse(w, req, h.rateLimits())
+		}
+
+	case 1:
+		lstn := h.rateLimit(path[0])
+		if lstn == nil {
+			http.NotFound(w, req)
+			return
+		}
+
+		if !allowMethods(w, req, http.MethodGet, http.MethodHead, http.MethodPut) {
+			return
+		}
+
+		if req.Method == http.MethodPut {
+			var limit adminLimit
+
+			err := readAdminRequest(req, &limit)
+			if err == nil {
+				err = limit.validate()
+			}
+			if err != nil {
+				writeAdminError(w, err)
+				return
+			}
+
+			lstn.SetLimit(limit.Rate, limit.Burst)
+		}
+
+		rate, burst := lstn.Limit()
+		writeAdminResponse(w, req, adminLimit{Rate: rate, Burst: burst})
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (h *AdminHandler) serveConfig(w http.ResponseWriter, req *http.Request, path []string) {
+	if len(path) != 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	if !allowMethods(w, req, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	config := struct {
+		Backends map[string][]BackendStatus `json:"backends"`
+		Limits   map[string]adminLimit      `json:"limits"`
+		Config   interface{}                `json:"config,omitempty"`
+	}{
+		Backends: h.backends(),
+		Limits:   h.rateLimits(),
+	}
+
+	if h.Config != nil {
+		config.Config = h.Config()
+	}
+
+	writeAdminResponse(w, req, config)
+}
+
+func (h *AdminHandler) pool(name string) *BackendPool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.pools[name]
+}
+
+func (h *AdminHandler) rateLimit(name string) *netx.RateLimitListener {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.limits[name]
+}
+
+func (h *AdminHandler) backends() map[string][]BackendStatus {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	backends := make(map[string][]BackendStatus, len(h.pools))
+	for name, pool := range h.pools {
+		backends[name] = pool.Backends()
+	}
+	return backends
+}
+
+func (h *AdminHandler) rateLimits() map[string]adminLimit {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	limits := make(map[string]adminLimit, len(h.limits))
+	for name, lstn := range h.limits {
+		rate, burst := lstn.Limit()
+		limits[name] = adminLimit{Rate: rate, Burst: burst}
+	}
+	return limits
+}
+
+// allowMethods checks whether the method of req is one of methods, otherwise
+// it responds with 405 Method Not Allowed and returns false.
+func allowMethods(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+
+	allow := append([]string{}, methods...)
+	sort.Strings(allow)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	return false
+}
+
+// isSafeMethod returns true if method does not change the state exposed by
+// the admin API.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// isJSONRequest returns true if the content type of req is application/json.
+func isJSONRequest(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+func readAdminRequest(req *http.Request, v interface{}) error {
+	d := json.NewDecoder(http.MaxBytesReader(nil, req.Body, adminMaxBodySize))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+func writeAdminResponse(w http.ResponseWriter, req *http.Request, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if req.Method != http.MethodHead {
+		w.Write(append(b, '\n'))
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrBackendNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
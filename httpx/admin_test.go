@@ -0,0 +1,162 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/netx"
+)
+
+func TestAdminHandler(t *testing.T) {
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		result      string
+	}{
+		{
+			method: "GET",
+			path:   "/backends",
+			status: http.StatusOK,
+			result: `{"api":[{"addr":"a","weight":1,"draining":false,"active":0},{"addr":"b","weight":1,"draining":false,"active":0}]}`,
+		},
+		{
+			method: "PATCH",
+			path:   "/backends/api/a",
+			body:   `{"weight":3}`,
+			status: http.StatusOK,
+			result: `[{"addr":"a","weight":3,"draining":false,"active":0},{"addr":"b","weight":1,"draining":false,"active":0}]`,
+		},
+		{
+			method: "POST",
+			path:   "/backends/api/b/drain",
+			status: http.StatusOK,
+			result: `[{"addr":"a","weight":1,"draining":false,"active":0},{"addr":"b","weight":1,"draining":true,"active":0}]`,
+		},
+		{
+			method: "PUT",
+			path:   "/backends/api",
+			body:   `[{"addr":"c","weight":2}]`,
+			status: http.StatusOK,
+			result: `[{"addr":"c","weight":2,"draining":false,"active":0}]`,
+		},
+		{
+			method: "PUT",
+			path:   "/backends/api",
+			body:   `[{"addr":"c","weight":-2}]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			method: "PATCH",
+			path:   "/backends/api/a",
+			body:   `{"wieght":3}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			method: "PATCH",
+			path:   "/backends/api/z",
+			body:   `{"weight":3}`,
+			status: http.StatusNotFound,
+		},
+		{
+			method: "GET",
+			path:   "/backends/nope",
+			status: http.StatusNotFound,
+		},
+		{
+			method: "DELETE",
+			path:   "/backends/api",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			method:      "POST",
+			path:        "/backends/api/b/drain",
+			contentType: "text/plain",
+			status:      http.StatusUnsupportedMediaType,
+		},
+		{
+			method:      "PATCH",
+			path:        "/backends/api/a",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `{"weight":3}`,
+			status:      http.StatusUnsupportedMediaType,
+		},
+		{
+			method: "PUT",
+			path:   "/limits/public",
+			body:   `{"rate":100,"burst":200}`,
+			status: http.StatusOK,
+			result: `{"rate":100,"burst":200}`,
+		},
+		{
+			method: "PUT",
+			path:   "/limits/public",
+			body:   `{"rate":0,"burst":10}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			method: "PUT",
+			path:   "/limits/public",
+			body:   `{"rate":10,"burst":-1}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			method: "GET",
+			path:   "/limits",
+			status: http.StatusOK,
+			result: `{"public":{"rate":10,"burst":0}}`,
+		},
+		{
+			method: "GET",
+			path:   "/config",
+			status: http.StatusOK,
+			result: `{"backends":{"api":[{"addr":"a","weight":1,"draining":false,"active":0},{"addr":"b","weight":1,"draining":false,"active":0}]},"limits":{"public":{"rate":10,"burst":0}},"config":{"version":1}}`,
+		},
+		{
+			method: "GET",
+			path:   "/",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			admin := &AdminHandler{
+				Config: func() interface{} { return map[string]int{"version": 1} },
+			}
+			admin.HandlePool("api", NewBackendPool("a", "b"))
+			admin.HandleRateLimit("public", &netx.RateLimitListener{Listener: &testListener{}, Rate: 10})
+
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			} else {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			res := httptest.NewRecorder()
+			admin.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code, res.Body.String())
+			}
+
+			if len(test.result) != 0 {
+				if s := strings.Join(strings.Fields(res.Body.String()), ""); s != test.result {
+					t.Error("bad response body:", s)
+				}
+			}
+		})
+	}
+}
+
+// testListener is a net.Listener which never accepts connections.
+type testListener struct{}
+
+func (*testListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (*testListener) Close() error              { return nil }
+func (*testListener) Addr() net.Addr            { return &net.TCPAddr{} }
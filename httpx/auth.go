@@ -0,0 +1,159 @@
+package httpx
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// AuthHandler is a HTTP handler which authenticates requests with the Basic or
+// Bearer schemes before passing them to its sub-handler, rejecting them with
+// 401 Unauthorized (or 407 Proxy Authentication Required in proxy mode) if
+// the credentials are missing or invalid.
+//
+// The name of the authenticated user is available to the sub-handler by
+// calling AuthUser on the request context.
+type AuthHandler struct {
+	// Handler is the sub-handler that the AuthHandler delegates authenticated
+	// requests to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+
+	// VerifyBasic, if not nil, enables the Basic authentication scheme, it is
+	// called to verify the username and password sent by the client.
+	VerifyBasic func(req *http.Request, username string, password string) error
+
+	// VerifyBearer, if not nil, enables the Bearer authentication scheme, it is
+	// called to verify the token sent by the client and returns the name of
+	// the user it belongs to.
+	VerifyBearer func(req *http.Request, token string) (user string, err error)
+
+	// Realm is the realm reported in the challenges sent to the clients when
+	// authentication fails.
+	Realm string
+
+	// Proxy configures the handler to use the Proxy-Authorization and
+	// Proxy-Authenticate headers, and respond with 407 Proxy Authentication
+	// Required, as expected from forward proxies.
+	Proxy bool
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *AuthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	authorization, authenticate, status := "Authorization", "WWW-Authenticate", http.StatusUnauthorized
+
+	if h.Proxy {
+		authorization, authenticate, status = "Proxy-Authorization", "Proxy-Authenticate", http.StatusProxyAuthRequired
+	}
+
+	user, err := h.authenticate(req, req.Header.Get(authorization))
+
+	if err != nil {
+		realm := h.Realm
+		if len(realm) == 0 {
+			realm = "netx"
+		}
+		realm = quoted(realm).String()
+
+		if h.VerifyBasic != nil {
+			w.Header().Add(authenticate, "Basic realm="+realm)
+		}
+		if h.VerifyBearer != nil {
+			w.Header().Add(authenticate, "Bearer realm="+realm)
+		}
+
+		w.WriteHeader(status)
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), authUserContextKey{}, user)
+
+	if h.Proxy {
+		ctx = context.WithValue(ctx, proxyUserContextKey{}, user)
+	}
+
+	h.Handler.ServeHTTP(w, req.WithContext(ctx))
+}
+
+func (h *AuthHandler) authenticate(req *http.Request, auth string) (user string, err error) {
+	if h.VerifyBasic != nil {
+		if username, password, ok := parseBasicAuth(auth); ok {
+			if err = h.VerifyBasic(req, username, password); err == nil {
+				user = username
+			}
+			return
+		}
+	}
+
+	if h.VerifyBearer != nil {
+		if token, ok := parseBearerAuth(auth); ok {
+			user, err = h.VerifyBearer(req, token)
+			return
+		}
+	}
+
+	err = errMissingCredentials
+	return
+}
+
+// AuthUser returns the name of the user that was authenticated by an
+// AuthHandler for the request that ctx belongs to.
+func AuthUser(ctx context.Context) string {
+	user, _ := ctx.Value(authUserContextKey{}).(string)
+	return user
+}
+
+type authUserContextKey struct{}
+
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// StaticBasicCredentials returns a function that can be used as the
+// VerifyBasic field of an AuthHandler, which accepts the usernames and
+// passwords in credentials.
+func StaticBasicCredentials(credentials map[string]string) func(*http.Request, string, string) error {
+	return func(req *http.Request, username string, password string) error {
+		if expected, ok := credentials[username]; ok && secureCompare(expected, password) {
+			return nil
+		}
+		return errInvalidCredentials
+	}
+}
+
+// StaticBearerTokens returns a function that can be used as the VerifyBearer
+// field of an AuthHandler, which accepts the tokens in tokens, mapped to the
+// name of the users they belong to.
+func StaticBearerTokens(tokens map[string]string) func(*http.Request, string) (string, error) {
+	return func(req *http.Request, token string) (string, error) {
+		for t, user := range tokens {
+			if secureCompare(t, token) {
+				return user, nil
+			}
+		}
+		return "", errInvalidCredentials
+	}
+}
+
+// parseBearerAuth parses a HTTP Bearer Authentication string.
+func parseBearerAuth(auth string) (token string, ok bool) {
+	const prefix = "Bearer "
+
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return
+	}
+
+	if token = trimOWS(auth[len(prefix):]); len(token) != 0 {
+		ok = true
+	}
+	return
+}
+
+// secureCompare compares s1 and s2 in constant time.
+func secureCompare(s1 string, s2 string) bool {
+	return subtle.ConstantTimeCompare([]byte(s1), []byte(s2)) == 1
+}
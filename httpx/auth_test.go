@@ -0,0 +1,130 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthHandler(t *testing.T) {
+	handler := func(proxy bool) *AuthHandler {
+		return &AuthHandler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte(AuthUser(req.Context())))
+			}),
+			VerifyBasic:  StaticBasicCredentials(map[string]string{"luke": "secret"}),
+			VerifyBearer: StaticBearerTokens(map[string]string{"0123456789": "leia"}),
+			Realm:        "test",
+			Proxy:        proxy,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		proxy  bool
+		header string
+		auth   string
+		status int
+		user   string
+	}{
+		{
+			name:   "missing",
+			header: "Authorization",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "basic",
+			header: "Authorization",
+			auth:   "Basic bHVrZTpzZWNyZXQ=",
+			status: http.StatusOK,
+			user:   "luke",
+		},
+		{
+			name:   "basic:invalid",
+			header: "Authorization",
+			auth:   "Basic bHVrZTpvb3Bz",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "bearer",
+			header: "Authorization",
+			auth:   "Bearer 0123456789",
+			status: http.StatusOK,
+			user:   "leia",
+		},
+		{
+			name:   "bearer:invalid",
+			header: "Authorization",
+			auth:   "Bearer 9876543210",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "proxy",
+			proxy:  true,
+			header: "Proxy-Authorization",
+			auth:   "Basic bHVrZTpzZWNyZXQ=",
+			status: http.StatusOK,
+			user:   "luke",
+		},
+		{
+			name:   "proxy:wrong-header",
+			proxy:  true,
+			header: "Authorization",
+			auth:   "Basic bHVrZTpzZWNyZXQ=",
+			status: http.StatusProxyAuthRequired,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			res := httptest.NewRecorder()
+
+			if len(test.auth) != 0 {
+				req.Header.Set(test.header, test.auth)
+			}
+
+			handler(test.proxy).ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+
+			if user := res.Body.String(); user != test.user {
+				t.Errorf("bad user: %q", user)
+			}
+
+			if test.status != http.StatusOK {
+				challenge := "Www-Authenticate"
+				if test.proxy {
+					challenge = "Proxy-Authenticate"
+				}
+				if n := len(res.Header()[challenge]); n != 2 {
+					t.Errorf("bad number of challenges in the %s header: %d", challenge, n)
+				}
+			}
+		})
+	}
+}
+
+func TestParseBearerAuth(t *testing.T) {
+	tests := []struct {
+		auth  string
+		token string
+		ok    bool
+	}{
+		{"", "", false},
+		{"Basic bHVrZTpzZWNyZXQ=", "", false},
+		{"Bearer abc", "abc", true},
+		{"bearer abc", "abc", true},
+		{"Bearer ", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.auth, func(t *testing.T) {
+			if token, ok := parseBearerAuth(test.auth); token != test.token || ok != test.ok {
+				t.Errorf("bad bearer auth: %q %t", token, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package httpx
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Balancer is the interface of the algorithms selecting the backends that a
+// BackendPool sends requests to.
+type Balancer interface {
+	// Select returns the index of the backend that req is sent to, among the
+	// backends of the pool which can receive requests, or -1 to let the pool
+	// select one with its weighted round-robin algorithm. The list of backends
+	// is never empty.
+	//
+	// The request is nil when the pool selects a backend for DialContext.
+	//
+	// Select is called while the pool is locked, it must not call methods of
+	// the pool nor retain the list of backends.
+	Select(req *http.Request, backends []BackendStatus) int
+}
+
+// LatencyObserver is implemented by balancers which adapt to the latency of
+// backends, like EWMABalancer. BackendPool reports the round trips of requests
+// to the backends that its balancer selected.
+type LatencyObserver interface {
+	// ObserveLatency is called with the address of a backend, the time it
+	// took to receive the response header, and the error of the round trip.
+	// Round trips canceled by clients are not observed.
+	ObserveLatency(addr string, rtt time.Duration, err error)
+}
+
+// HashBalancer is a Balancer which selects backends by consistent hashing of a
+// key extracted from requests, so the requests sharing a key are sent to the
+// same backend, which keeps the caches of backends warm.
+//
+// Backends are selected with weighted rendezvous hashing: when backends are
+// added to or removed from a pool, only the keys of these backends move to
+// other backends. Requests with an empty key, and the connections established
+// by DialContext, are balanced with round-robin.
+type HashBalancer struct {
+	// Key returns the key of a request, like one of the functions returned by
+	// ClientIPKey, HeaderKey, CookieKey, or URLKey.
+	//
+	// Select will panic if Key is nil.
+	Key func(*http.Request) string
+}
+
+// Select satisfies the Balancer interface.
+func (h *HashBalancer) Select(req *http.Request, backends []BackendStatus) int {
+	if req == nil {
+		return -1
+	}
+
+	key := h.Key(req)
+	if len(key) == 0 {
+		return -1
+	}
+
+	hash := hashString(key)
+	best, bestScore := -1, 0.0
+
+	for i, b := range backends {
+		// The logarithmic method gives each backend a share of the keys which
+		// is proportional to its weight.
+		u := float64(mix(hash^hashString(b.Addr))>>11) + 0.5
+		score := float64(b.Weight) / -math.Log(u/(1<<53))
+
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	return best
+}
+
+// DefaultEWMADecay is the default time window of the latency averages of
+// EWMABalancer.
+const DefaultEWMADecay = 10 * time.Second
+
+const (
+	// ewmaFailure is the minimum latency that failed round trips are observed
+	// with, so backends which fail fast don't attract traffic.
+	ewmaFailure = time.Second
+
+	// ewmaPenalty is the load of backends with requests in flight and no
+	// observed latency, they receive one request at a time until a latency is
+	// observed.
+	ewmaPenalty = float64(math.MaxInt64 >> 16)
+)
+
+// EWMABalancer is a Balancer which sends requests to the backends with the
+// lowest load, estimated by the peak exponentially weighted moving average of
+// their latency multiplied by their number of requests in flight, like the
+// balancers of Finagle and Linkerd. Traffic is steered away from slow backends
+// without health checks: latency spikes are accounted for immediately, then
+// the averages decay so slow backends are tried again once they recovered.
+//
+// Backends are compared by pairs picked at random, the "power of two choices",
+// so the requests made between two observations are not all sent to the same
+// backend. The load of backends is divided by their weight.
+//
+// The latency of backends is observed by the BackendPool that the balancer is
+// attached to, see LatencyObserver.
+type EWMABalancer struct {
+	// Decay is the time window over which the latency of backends is
+	// averaged, longer windows make the balancer react slower to backends
+	// recovering from latency spikes.
+	//
+	// DefaultEWMADecay is used if Decay is zero.
+	Decay time.Duration
+
+	mutex    sync.Mutex
+	backends map[string]*ewmaBackend
+}
+
+type ewmaBackend struct {
+	cost  float64 // nanoseconds
+	stamp time.Time
+}
+
+// Select satisfies the Balancer interface.
+func (e *EWMABalancer) Select(req *http.Request, backends []BackendStatus) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.backends) > len(backends) {
+		e.prune(backends)
+	}
+
+	if len(backends) == 1 {
+		return 0
+	}
+
+	i := rand.Intn(len(backends))
+	j := rand.Intn(len(backends) - 1)
+	if j >= i {
+		j++
+	}
+
+	now := time.Now()
+
+	if e.load(backends[j], now) < e.load(backends[i], now) {
+		return j
+	}
+	return i
+}
+
+// ObserveLatency satisfies the LatencyObserver interface.
+func (e *EWMABalancer) ObserveLatency(addr string, rtt time.Duration, err error) {
+	if err != nil && rtt < ewmaFailure {
+		rtt = ewmaFailure
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if b := e.backends[addr]; b != nil {
+		b.observe(time.Now(), float64(rtt), e.decay())
+	}
+}
+
+// load returns the load of backend b divided by its weight, e must be locked.
+func (e *EWMABalancer) load(b BackendStatus, now time.Time) float64 {
+	x := e.backends[b.Addr]
+
+	if x == nil {
+		if e.backends == nil {
+			e.backends = make(map[string]*ewmaBackend)
+		}
+		x = &ewmaBackend{stamp: now}
+		e.backends[b.Addr] = x
+	}
+
+	// Observing a zero latency decays the average of backends which didn't
+	// receive requests in a while.
+	x.observe(now, 0, e.decay())
+
+	if x.cost == 0 && b.Active != 0 {
+		return (ewmaPenalty + float64(b.Active)) / float64(b.Weight)
+	}
+
+	return x.cost * float64(b.Active+1) / float64(b.Weight)
+}
+
+// prune removes the state of the backends which are not in the list, e must
+// be locked.
+func (e *EWMABalancer) prune(backends []BackendStatus) {
+	keep := make(map[string]bool, len(backends))
+
+	for _, b := range backends {
+		keep[b.Addr] = true
+	}
+
+	for addr := range e.backends {
+		if !keep[addr] {
+			delete(e.backends, addr)
+		}
+	}
+}
+
+func (e *EWMABalancer) decay() float64 {
+	if e.Decay > 0 {
+		return float64(e.Decay)
+	}
+	return float64(DefaultEWMADecay)
+}
+
+// observe updates the peak EWMA of the backend with a latency of rtt observed
+// at the given time.
+func (b *ewmaBackend) observe(now time.Time, rtt float64, decay float64) {
+	elapsed := now.Sub(b.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	b.stamp = now
+
+	if rtt > b.cost {
+		b.cost = rtt
+	} else {
+		w := math.Exp(-float64(elapsed) / decay)
+		b.cost = b.cost*w + rtt*(1-w)
+	}
+}
+
+// ClientIPKey returns a function which uses the address of clients as the key
+// of requests, see ClientIP.
+func ClientIPKey(header string, trustedProxies []*net.IPNet) func(*http.Request) string {
+	return func(req *http.Request) string {
+		if ip := ClientIP(req, header, trustedProxies); ip != nil {
+			return string(ip.To16())
+		}
+		return ""
+	}
+}
+
+// HeaderKey returns a function which uses the value of the header field with
+// the given name as the key of requests.
+func HeaderKey(name string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// CookieKey returns a function which uses the value of the cookie with the
+// given name as the key of requests.
+func CookieKey(name string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		if c, err := req.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	}
+}
+
+// URLKey returns a function which uses the host, path, and query of requests
+// as their key.
+func URLKey() func(*http.Request) string {
+	return func(req *http.Request) string {
+		return req.Host + req.URL.RequestURI()
+	}
+}
+
+// hashString returns the 64 bits FNV-1a hash of s.
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// mix is the finalizer of splitmix64, which spreads the bits of x so hashes
+// of similar strings are uniformly distributed.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
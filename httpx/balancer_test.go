@@ -0,0 +1,337 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+func TestHashBalancer(t *testing.T) {
+	h := &HashBalancer{Key: HeaderKey("X-Key")}
+
+	backends := func(addrs ...string) []BackendStatus {
+		list := make([]BackendStatus, len(addrs))
+		for i, addr := range addrs {
+			list[i] = BackendStatus{Backend: Backend{Addr: addr, Weight: 1}}
+		}
+		return list
+	}
+
+	selectAll := func(backends []BackendStatus) map[string]string {
+		selected := make(map[string]string)
+		for i := 0; i < 10000; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Key", strconv.Itoa(i))
+			selected[strconv.Itoa(i)] = backends[h.Select(req, backends)].Addr
+		}
+		return selected
+	}
+
+	t.Run("keys are spread across backends", func(t *testing.T) {
+		counts := make(map[string]int)
+		for _, addr := range selectAll(backends("a", "b", "c", "d")) {
+			counts[addr]++
+		}
+		for _, addr := range []string{"a", "b", "c", "d"} {
+			if n := counts[addr]; n < 2000 || n > 3000 {
+				t.Errorf("backend %s received %d keys out of 10000", addr, n)
+			}
+		}
+	})
+
+	t.Run("only the keys of removed backends move", func(t *testing.T) {
+		before := selectAll(backends("a", "b", "c", "d", "e"))
+		after := selectAll(backends("a", "b", "d", "e"))
+
+		for key, addr := range before {
+			if addr != "c" && after[key] != addr {
+				t.Fatalf("key %s moved from %s to %s", key, addr, after[key])
+			}
+		}
+	})
+
+	t.Run("the order of backends does not matter", func(t *testing.T) {
+		before := selectAll(backends("a", "b", "c"))
+		after := selectAll(backends("c", "a", "b"))
+
+		for key, addr := range before {
+			if after[key] != addr {
+				t.Fatalf("key %s moved from %s to %s", key, addr, after[key])
+			}
+		}
+	})
+
+	t.Run("keys are spread according to the weights", func(t *testing.T) {
+		list := backends("a", "b")
+		list[1].Weight = 3
+
+		counts := make(map[string]int)
+		for _, addr := range selectAll(list) {
+			counts[addr]++
+		}
+		if n := counts["b"]; n < 7000 || n > 8000 {
+			t.Errorf("backend b received %d keys out of 10000", n)
+		}
+	})
+
+	t.Run("requests without keys are not balanced", func(t *testing.T) {
+		if i := h.Select(httptest.NewRequest("GET", "/", nil), backends("a")); i != -1 {
+			t.Error("bad selection:", i)
+		}
+		if i := h.Select(nil, backends("a")); i != -1 {
+			t.Error("bad selection:", i)
+		}
+	})
+}
+
+func TestEWMABalancer(t *testing.T) {
+	backends := []BackendStatus{
+		{Backend: Backend{Addr: "a", Weight: 1}},
+		{Backend: Backend{Addr: "b", Weight: 1}},
+	}
+
+	selectAll := func(e *EWMABalancer, backends []BackendStatus) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < 100; i++ {
+			counts[backends[e.Select(nil, backends)].Addr]++
+		}
+		return counts
+	}
+
+	t.Run("slow backends are avoided", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends)
+		e.ObserveLatency("a", 10*time.Millisecond, nil)
+		e.ObserveLatency("b", 100*time.Millisecond, nil)
+
+		if n := selectAll(e, backends)["b"]; n != 0 {
+			t.Errorf("the slow backend received %d requests out of 100", n)
+		}
+	})
+
+	t.Run("latency spikes are accounted for immediately", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends)
+		for i := 0; i < 10; i++ {
+			e.ObserveLatency("a", 10*time.Millisecond, nil)
+			e.ObserveLatency("b", 20*time.Millisecond, nil)
+		}
+		e.ObserveLatency("a", time.Second, nil)
+
+		if n := selectAll(e, backends)["a"]; n != 0 {
+			t.Errorf("the slow backend received %d requests out of 100", n)
+		}
+	})
+
+	t.Run("failures count as slow round trips", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends)
+		e.ObserveLatency("a", time.Millisecond, errors.New("connection refused"))
+		e.ObserveLatency("b", 100*time.Millisecond, nil)
+
+		if n := selectAll(e, backends)["a"]; n != 0 {
+			t.Errorf("the failing backend received %d requests out of 100", n)
+		}
+	})
+
+	t.Run("requests in flight increase the load", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends)
+		e.ObserveLatency("a", 10*time.Millisecond, nil)
+		e.ObserveLatency("b", 20*time.Millisecond, nil)
+
+		busy := append([]BackendStatus{}, backends...)
+		busy[0].Active = 4
+
+		if n := selectAll(e, busy)["a"]; n != 0 {
+			t.Errorf("the busy backend received %d requests out of 100", n)
+		}
+	})
+
+	t.Run("the load is divided by the weights", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends)
+		e.ObserveLatency("a", 10*time.Millisecond, nil)
+		e.ObserveLatency("b", 20*time.Millisecond, nil)
+
+		weighted := append([]BackendStatus{}, backends...)
+		weighted[1].Weight = 4
+
+		if n := selectAll(e, weighted)["a"]; n != 0 {
+			t.Errorf("the backend with the lowest weight received %d requests out of 100", n)
+		}
+	})
+
+	t.Run("unobserved backends receive one request at a time", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends[:1])
+		e.ObserveLatency("a", 100*time.Millisecond, nil)
+
+		pending := append([]BackendStatus{}, backends...)
+		pending[1].Active = 1
+
+		if n := selectAll(e, pending)["b"]; n != 0 {
+			t.Errorf("the unobserved backend received %d requests out of 100", n)
+		}
+	})
+
+	t.Run("the latency of slow backends decays", func(t *testing.T) {
+		e := &EWMABalancer{Decay: time.Millisecond}
+		selectAll(e, backends)
+		e.ObserveLatency("a", 10*time.Millisecond, nil)
+		e.ObserveLatency("b", 100*time.Millisecond, nil)
+		time.Sleep(50 * time.Millisecond)
+		e.ObserveLatency("a", 10*time.Millisecond, nil)
+
+		if n := selectAll(e, backends)["b"]; n == 0 {
+			t.Error("the slow backend did not receive requests after recovering")
+		}
+	})
+
+	t.Run("removed backends are forgotten", func(t *testing.T) {
+		e := &EWMABalancer{}
+		selectAll(e, backends)
+		selectAll(e, backends[:1])
+
+		if _, ok := e.backends["b"]; ok {
+			t.Error("the state of the removed backend was retained")
+		}
+	})
+}
+
+func TestRequestKeys(t *testing.T) {
+	trusted, _ := netx.ParseCIDRs("10.0.0.0/8")
+
+	req := httptest.NewRequest("GET", "http://example.com/a?b=c", nil)
+	req.RemoteAddr = "10.0.0.1:56789"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "1234"})
+
+	tests := []struct {
+		name string
+		key  func(*http.Request) string
+		val  string
+	}{
+		{"client ip", ClientIPKey("X-Forwarded-For", trusted), string(net.ParseIP("192.0.2.1"))},
+		{"remote address", ClientIPKey("X-Forwarded-For", nil), string(net.ParseIP("10.0.0.1"))},
+		{"header", HeaderKey("X-User"), "alice"},
+		{"missing header", HeaderKey("X-Group"), ""},
+		{"cookie", CookieKey("session"), "1234"},
+		{"missing cookie", CookieKey("user"), ""},
+		{"url", URLKey(), "example.com/a?b=c"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if val := test.key(req); val != test.val {
+				t.Errorf("bad key: %q", val)
+			}
+		})
+	}
+}
+
+func TestBackendPoolBalancer(t *testing.T) {
+	var addrs []string
+
+	for i := 0; i < 3; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, req.Context().Value(http.LocalAddrContextKey))
+		}))
+		defer server.Close()
+		addrs = append(addrs, server.Listener.Addr().String())
+	}
+
+	pool := NewBackendPool(addrs...)
+	pool.Balancer = &HashBalancer{Key: CookieKey("session")}
+
+	get := func(session string) string {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RequestURI = ""
+		if len(session) != 0 {
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		}
+
+		res, err := pool.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	for _, session := range []string{"a", "b", "c", "d"} {
+		first := get(session)
+		for i := 0; i < 5; i++ {
+			if addr := get(session); addr != first {
+				t.Errorf("session %s was sent to %s then %s", session, first, addr)
+			}
+		}
+	}
+
+	// Requests without keys are balanced with round-robin.
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[get("")] = true
+	}
+	if len(seen) != 3 {
+		t.Error("requests without keys were not balanced across the backends:", seen)
+	}
+
+	// Removing the balancer restores round-robin for all requests.
+	pool.SetBalancer(nil)
+	seen = make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[get("a")] = true
+	}
+	if len(seen) != 3 {
+		t.Error("requests were not balanced across the backends:", seen)
+	}
+}
+
+func TestBackendPoolEWMA(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "fast")
+	}))
+	defer fast.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "slow")
+	}))
+	defer slow.Close()
+
+	pool := NewBackendPool(fast.Listener.Addr().String(), slow.Listener.Addr().String())
+	pool.Balancer = &EWMABalancer{}
+
+	counts := make(map[string]int)
+
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RequestURI = ""
+
+		res, err := pool.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		counts[string(b)]++
+	}
+
+	// The slow backend may receive the first requests, until its latency is
+	// observed.
+	if counts["slow"] > 2 {
+		t.Errorf("the slow backend received %d requests out of 50", counts["slow"])
+	}
+}
@@ -0,0 +1,100 @@
+package httpx
+
+import (
+	"io"
+	"net"
+)
+
+// batchWriter buffers small writes like a bufio.Writer, but instead of copying
+// large writes to the buffer it sends them along with the buffered bytes in a
+// single vectored write, which uses writev(2) on TCP connections.
+//
+// This coalesces the header and the body of requests into one system call,
+// where a bufio.Writer would fill its buffer, flush it, then write the rest of
+// the body.
+type batchWriter struct {
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+func newBatchWriter(w io.Writer, size int) *batchWriter {
+	return &batchWriter{w: w, buf: make([]byte, 0, size)}
+}
+
+// Write satisfies the io.Writer interface.
+func (b *batchWriter) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if len(b.buf)+len(p) <= cap(b.buf) {
+		b.buf = append(b.buf, p...)
+		return len(p), nil
+	}
+
+	if len(b.buf) == 0 {
+		n, err := b.w.Write(p)
+		b.err = err
+		return n, err
+	}
+
+	buffered := int64(len(b.buf))
+	bufs := net.Buffers{b.buf, p}
+	n, err := bufs.WriteTo(b.w)
+	b.buf = b.buf[:0]
+
+	if err != nil {
+		b.err = err
+	}
+
+	if n -= buffered; n < 0 {
+		n = 0
+	}
+	return int(n), err
+}
+
+// WriteByte satisfies the io.ByteWriter interface, which prevents
+// http.Request.Write from wrapping the writer in a bufio.Writer.
+func (b *batchWriter) WriteByte(c byte) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if len(b.buf) == cap(b.buf) {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+
+	b.buf = append(b.buf, c)
+	return nil
+}
+
+// WriteString satisfies the io.StringWriter interface.
+func (b *batchWriter) WriteString(s string) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if len(b.buf)+len(s) <= cap(b.buf) {
+		b.buf = append(b.buf, s...)
+		return len(s), nil
+	}
+
+	return b.Write([]byte(s))
+}
+
+// Flush writes the buffered bytes to the underlying writer.
+func (b *batchWriter) Flush() error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if len(b.buf) != 0 {
+		_, b.err = b.w.Write(b.buf)
+		b.buf = b.buf[:0]
+	}
+
+	return b.err
+}
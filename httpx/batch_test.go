@@ -0,0 +1,125 @@
+package httpx
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// writeRecorder records the writes it receives.
+type writeRecorder struct {
+	writes []string
+	err    error
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, string(b))
+	return len(b), nil
+}
+
+func TestBatchWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		output []string
+	}{
+		{
+			name:   "small writes are buffered",
+			writes: []string{"GET / HTTP/1.1\r\n", "Host: localhost\r\n\r\n"},
+			output: []string{"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"},
+		},
+		{
+			name:   "large writes are sent with the buffered bytes",
+			writes: []string{"header\r\n", strings.Repeat("x", 100)},
+			output: []string{"header\r\n", strings.Repeat("x", 100)},
+		},
+		{
+			name:   "large writes on an empty buffer are sent directly",
+			writes: []string{strings.Repeat("x", 100), "trailer"},
+			output: []string{strings.Repeat("x", 100), "trailer"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := &writeRecorder{}
+			w := newBatchWriter(rec, 64)
+
+			for _, s := range test.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("bad write: n=%d err=%v", n, err)
+				}
+			}
+
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(rec.writes, "|") != strings.Join(test.output, "|") {
+				t.Errorf("bad writes: %q", rec.writes)
+			}
+		})
+	}
+}
+
+func TestBatchWriterError(t *testing.T) {
+	errWrite := errors.New("broken pipe")
+	rec := &writeRecorder{}
+	w := newBatchWriter(rec, 64)
+
+	w.Write([]byte("header"))
+	rec.err = errWrite
+
+	if n, err := w.Write(bytes.Repeat([]byte("x"), 100)); err != errWrite || n != 0 {
+		t.Errorf("bad write: n=%d err=%v", n, err)
+	}
+
+	if err := w.Flush(); err != errWrite {
+		t.Error("the error was not kept:", err)
+	}
+}
+
+func TestBatchWriterRequest(t *testing.T) {
+	rec := &writeRecorder{}
+	w := newBatchWriter(rec, 256)
+	body := strings.Repeat("x", 300)
+
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(body))
+
+	// The writer implements io.ByteWriter, so the request is written to it
+	// directly instead of being copied to a bufio.Writer first.
+	if err := req.Write(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.writes) != 2 || !strings.HasSuffix(rec.writes[0], "\r\n\r\n") || rec.writes[1] != body {
+		t.Errorf("bad writes: %q", rec.writes)
+	}
+}
+
+func TestBatchWriterWriteString(t *testing.T) {
+	rec := &writeRecorder{}
+	w := newBatchWriter(rec, 4)
+
+	w.WriteString("ab")
+	w.WriteByte('c')
+	w.WriteByte('d')
+	w.WriteByte('e')
+	w.WriteString("fghij")
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := strings.Join(rec.writes, "|"); s != "abcd|e|fghij" {
+		t.Errorf("bad writes: %q", s)
+	}
+}
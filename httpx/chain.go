@@ -0,0 +1,54 @@
+package httpx
+
+import "net/http"
+
+// Middleware is the signature of functions which wrap a HTTP handler to extend
+// its behavior (logging, limits, authentication, metrics, etc...).
+type Middleware func(http.Handler) http.Handler
+
+// Chain is a list of middlewares which are applied in order around a HTTP
+// handler, the first middleware of the chain is the outermost one, it sees the
+// requests first and the responses last.
+//
+// Chains are immutable, calling Use returns a new chain, which makes it
+// possible to share a common chain between handlers and extend it where
+// some of them need extra middlewares:
+//
+//	base := httpx.Chain{logging, metrics}
+//	api := base.Use(auth).Then(proxy)
+//	static := base.Then(files)
+type Chain []Middleware
+
+// Use returns a new chain which applies the middlewares of c then m.
+func (c Chain) Use(m ...Middleware) Chain {
+	chain := make(Chain, 0, len(c)+len(m))
+	chain = append(chain, c...)
+	chain = append(chain, m...)
+	return chain
+}
+
+// Then wraps h with the middlewares of the chain and returns the resulting
+// handler. If h is nil, http.DefaultServeMux is used.
+func (c Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+// ThenFunc is like Then but takes a function as the handler.
+func (c Chain) ThenFunc(f http.HandlerFunc) http.Handler {
+	if f == nil {
+		return c.Then(nil)
+	}
+	return c.Then(f)
+}
+
+// Use wraps h with the middlewares m, the first middleware being the
+// outermost one. It is a shorthand for Chain(m).Then(h).
+func Use(h http.Handler, m ...Middleware) http.Handler {
+	return Chain(m).Then(h)
+}
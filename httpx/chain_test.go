@@ -0,0 +1,60 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	middleware := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Add("X-Chain", name)
+				h.ServeHTTP(w, req)
+			})
+		}
+	}
+
+	base := Chain{middleware("A"), middleware("B")}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		chain   string
+	}{
+		{
+			name:    "base",
+			handler: base.Then(StatusHandler(http.StatusOK)),
+			chain:   "A,B",
+		},
+		{
+			name:    "extended",
+			handler: base.Use(middleware("C")).ThenFunc(func(w http.ResponseWriter, req *http.Request) {}),
+			chain:   "A,B,C",
+		},
+		{
+			name:    "use",
+			handler: Use(StatusHandler(http.StatusOK), middleware("C"), middleware("A")),
+			chain:   "C,A",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			res := httptest.NewRecorder()
+
+			test.handler.ServeHTTP(res, req)
+
+			if chain := strings.Join(res.Header()["X-Chain"], ","); chain != test.chain {
+				t.Errorf("bad middleware chain: expected %s but got %s", test.chain, chain)
+			}
+		})
+	}
+
+	if len(base) != 2 {
+		t.Error("the base chain was modified")
+	}
+}
@@ -0,0 +1,229 @@
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CoalesceTransport is a http.RoundTripper which coalesces concurrent identical
+// GET and HEAD requests into a single request to the backend, the response is
+// then shared with all the callers. This protects backends from cache
+// stampedes, where many clients request the same resource at the same time,
+// for example right after it expired from a cache sitting in front of the
+// transport.
+//
+// Requests are identical when they have the same method, host, URL, and values
+// of the KeyHeaders. Other requests, including range and conditional requests
+// whose responses depend on the cache state of each client, are passed to the
+// sub-transport unchanged.
+//
+// Responses are shared by buffering their body in memory, the responses which
+// are too large to be buffered or which are specific to a client (setting
+// cookies or with a private Cache-Control directive) are not shared, the
+// waiting requests are sent to the backend instead.
+type CoalesceTransport struct {
+	// Transport is the sub-transport that the CoalesceTransport delegates
+	// requests to.
+	//
+	// http.DefaultTransport is used if Transport is nil.
+	Transport http.RoundTripper
+
+	// KeyHeaders is the list of request headers which must have the same
+	// values for requests to be coalesced, because they may change the
+	// response of the backend. If nil, DefaultCoalesceKeyHeaders is used.
+	KeyHeaders []string
+
+	// MaxBodySize is the maximum size of the response bodies that are
+	// buffered to be shared. If zero, DefaultCoalesceMaxBodySize is used.
+	MaxBodySize int64
+
+	mutex sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// DefaultCoalesceKeyHeaders is the default list of request headers which must
+// have the same values for requests to be coalesced by a CoalesceTransport.
+var DefaultCoalesceKeyHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Authorization",
+	"Cookie",
+}
+
+// coalesceBypassHeaders is the list of request headers which prevent requests
+// from being coalesced, the responses to range and conditional requests are
+// specific to the client (206 Partial Content, 304 Not Modified, or 412
+// Precondition Failed responses).
+var coalesceBypassHeaders = [...]string{
+	"Range",
+	"If-Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// DefaultCoalesceMaxBodySize is the default maximum size of the response bodies
+// shared by a CoalesceTransport.
+const DefaultCoalesceMaxBodySize = 1 << 20
+
+// isCoalescable returns true if req may be coalesced with identical requests.
+func isCoalescable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	for _, h := range coalesceBypassHeaders {
+		if len(req.Header[h]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// coalescedCall represents a request in flight that other requests wait for.
+type coalescedCall struct {
+	done   chan struct{} // closed when the response was received
+	shared bool          // whether res and err can be used by the waiters
+	res    *http.Response
+	body   []byte
+	err    error
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *CoalesceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	if !isCoalescable(req) {
+		return transport.RoundTrip(req)
+	}
+
+	key := t.key(req)
+	t.mutex.Lock()
+
+	if c := t.calls[key]; c != nil {
+		t.mutex.Unlock()
+
+		select {
+		case <-c.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		if !c.shared {
+			return transport.RoundTrip(req)
+		}
+
+		if c.err != nil {
+			return nil, c.err
+		}
+
+		return c.response(req), nil
+	}
+
+	c := &coalescedCall{done: make(chan struct{})}
+
+	if t.calls == nil {
+		t.calls = make(map[string]*coalescedCall)
+	}
+
+	t.calls[key] = c
+	t.mutex.Unlock()
+
+	defer func() {
+		t.mutex.Lock()
+		delete(t.calls, key)
+		t.mutex.Unlock()
+		close(c.done)
+	}()
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		// Errors caused by the cancellation of the request are not shared,
+		// the other requests may still be waiting for a response.
+		c.shared, c.err = req.Context().Err() == nil, err
+		return nil, err
+	}
+
+	if !isSharedResponse(res) {
+		return res, nil
+	}
+
+	maxBodySize := t.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultCoalesceMaxBodySize
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBodySize+1))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	if int64(len(body)) > maxBodySize {
+		res.Body = &struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return res, nil
+	}
+
+	res.Body.Close()
+	c.shared, c.res, c.body = true, res, body
+	return c.response(req), nil
+}
+
+// key returns the key that identical requests have in common.
+func (t *CoalesceTransport) key(req *http.Request) string {
+	headers := t.KeyHeaders
+	if headers == nil {
+		headers = DefaultCoalesceKeyHeaders
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.Host)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+
+	for _, name := range headers {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(req.Header[http.CanonicalHeaderKey(name)], ", "))
+	}
+
+	return b.String()
+}
+
+// response returns a copy of the shared response for req, callers may modify
+// the header of the response they receive.
+func (c *coalescedCall) response(req *http.Request) *http.Response {
+	res := *c.res
+	res.Header = c.res.Header.Clone()
+	res.Trailer = c.res.Trailer.Clone()
+	res.Body = ioutil.NopCloser(bytes.NewReader(c.body))
+	res.Request = req
+
+	if req.Method != http.MethodHead {
+		res.ContentLength, res.TransferEncoding = int64(len(c.body)), nil
+	}
+
+	return &res
+}
+
+// isSharedResponse returns true if res may be shared with other clients.
+func isSharedResponse(res *http.Response) bool {
+	return len(res.Header["Set-Cookie"]) == 0 && !headerValuesContainsToken(res.Header["Cache-Control"], "private")
+}
@@ -0,0 +1,175 @@
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestCoalesceTransportDefault(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &CoalesceTransport{}
+	})
+}
+
+func TestCoalesceTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   func(i int) http.Header
+		response http.Header
+		count    int32
+	}{
+		{
+			name:   "identical requests are coalesced",
+			method: "GET",
+			count:  1,
+		},
+		{
+			name:   "requests with different key headers are not coalesced",
+			method: "GET",
+			header: func(i int) http.Header {
+				return http.Header{"Accept-Language": {[]string{"en", "fr"}[i%2]}}
+			},
+			count: 2,
+		},
+		{
+			name:   "unsafe requests are not coalesced",
+			method: "DELETE",
+			count:  4,
+		},
+		{
+			name:   "range requests are not coalesced",
+			method: "GET",
+			header: func(i int) http.Header {
+				return http.Header{"Range": {"bytes=0-5"}}
+			},
+			count: 4,
+		},
+		{
+			name:   "conditional requests are not coalesced",
+			method: "GET",
+			header: func(i int) http.Header {
+				return http.Header{"If-None-Match": {`"v1"`}}
+			},
+			count: 4,
+		},
+		{
+			name:     "responses setting cookies are not shared",
+			method:   "GET",
+			response: http.Header{"Set-Cookie": {"session=42"}},
+			count:    4,
+		},
+		{
+			name:     "private responses are not shared",
+			method:   "GET",
+			response: http.Header{"Cache-Control": {"private, max-age=60"}},
+			count:    4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := int32(0)
+			received := make(chan struct{}, 4)
+			release := make(chan struct{})
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&count, 1)
+				received <- struct{}{}
+				<-release
+				copyHeader(w.Header(), test.response)
+				w.Write([]byte("Hello World!"))
+			}))
+			defer server.Close()
+
+			transport := &CoalesceTransport{}
+			wg := sync.WaitGroup{}
+
+			for i := 0; i < 4; i++ {
+				req, _ := http.NewRequest(test.method, server.URL, nil)
+				if test.header != nil {
+					req.Header = test.header(i)
+				}
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					res, err := transport.RoundTrip(req)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					b, _ := ioutil.ReadAll(res.Body)
+					res.Body.Close()
+
+					if string(b) != "Hello World!" {
+						t.Errorf("bad response body: %q", b)
+					}
+				}()
+			}
+
+			// Give time to all requests to reach the transport before the
+			// server responds.
+			<-received
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if n := atomic.LoadInt32(&count); n != test.count {
+				t.Error("bad number of requests received by the server:", n)
+			}
+		})
+	}
+}
+
+func TestCoalesceTransportMaxBodySize(t *testing.T) {
+	count := int32(0)
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			<-release
+		}
+		w.Write([]byte("Hello World!"))
+	}))
+	defer server.Close()
+
+	transport := &CoalesceTransport{MaxBodySize: 5}
+	bodies := make(chan string, 2)
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				bodies <- err.Error()
+				return
+			}
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			bodies <- string(b)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if body := <-bodies; body != "Hello World!" {
+			t.Errorf("bad response body: %q", body)
+		}
+	}
+
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Error("bad number of requests received by the server:", n)
+	}
+}
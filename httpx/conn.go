@@ -3,9 +3,11 @@ package httpx
 import (
 	"bufio"
 	"context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/segmentio/netx"
@@ -13,16 +15,38 @@ import (
 
 // ConnTransport is a http.RoundTripper that works on a pre-established network
 // connection.
+//
+// The transport supports keep-alive, sequential requests reuse the same
+// connection as long as neither the client nor the server asked for it to be
+// closed. Because a single connection is used, calls to RoundTrip block until
+// the body of the previous response was closed.
 type ConnTransport struct {
 	// Conn is the connection to use to send requests and receive responses.
+	//
+	// The transport never closes this connection unless its Close method is
+	// called, if the connection cannot be reused after a response, subsequent
+	// requests fail.
 	Conn net.Conn
 
 	// Buffer may be set to a bufio.ReadWriter which will be used to buffer all
 	// I/O done on the connection.
+	//
+	// When no writer is set, the transport sends the header of requests with
+	// the first chunk of their body in a single vectored write (writev(2) on
+	// TCP connections), instead of copying the body to a buffer.
 	Buffer *bufio.ReadWriter
 
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers used to
+	// read responses and write requests, they are ignored when Buffer has a
+	// reader or a writer. If zero, a default size of 4 KB is used.
+	ReadBufferSize  int
+	WriteBufferSize int
+
 	// DialContext is used to open a connection when Conn is set to nil.
 	// If the function is nil the transport uses a default dialer.
+	//
+	// Connections opened by the transport are closed when they cannot be
+	// reused anymore, a new one is dialed on the next request.
 	DialContext func(context.Context, string, string) (net.Conn, error)
 
 	// ResponseHeaderTimeout, if non-zero, specifies the amount of time to wait
@@ -36,50 +60,45 @@ type ConnTransport struct {
 	//
 	// Zero means to use a default limit.
 	MaxResponseHeaderBytes int
+
+	// Held while a request is in flight, until the response body is closed.
+	lock sync.Mutex
+
+	// The state of the connection, the mutex synchronizes it with Close.
+	mutex  sync.Mutex
+	conn   *connReader
+	r      *bufio.Reader
+	w      connWriter
+	dialed bool // conn was opened by the transport
+	broken bool // Conn is not reusable
+	closed bool // Close was called
 }
 
 // the default dialer used by ConnTransport when neither Conn nor DialContext is
 // set.
 var dialer net.Dialer
 
+var (
+	errConnTransportClosed = errors.New("the connection transport is closed")
+	errConnTransportBroken = errors.New("the connection of the transport cannot be reused")
+)
+
 // RoundTrip satisfies the http.RoundTripper interface.
 func (t *ConnTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
-	var ctx = req.Context()
-	var conn net.Conn
-	var dial func(context.Context, string, string) (net.Conn, error)
+	t.lock.Lock()
 
-	if conn = t.Conn; conn == nil {
-		if dial = t.DialContext; dial == nil {
-			dial = dialer.DialContext
-		}
-		if conn, err = dial(ctx, "tcp", req.Host); err != nil {
-			return
-		}
+	c, r, w, err := t.acquire(req)
+	if err != nil {
+		t.lock.Unlock()
+		return
 	}
 
-	var c = &connReader{Conn: conn, limit: -1}
-	var b = t.Buffer
-	var r *bufio.Reader
-	var w *bufio.Writer
-
-	if b != nil && b.Reader != nil {
-		r = b.Reader
-		r.Reset(c)
-	} else {
-		r = bufio.NewReader(c)
+	if err = req.Write(w); err == nil {
+		err = w.Flush()
 	}
 
-	if b != nil && b.Writer != nil {
-		w = b.Writer
-		w.Reset(c)
-	} else {
-		w = bufio.NewWriter(c)
-	}
-
-	if err = req.Write(w); err != nil {
-		return
-	}
-	if err = w.Flush(); err != nil {
+	if err != nil {
+		t.release(false)
 		return
 	}
 
@@ -93,22 +112,145 @@ func (t *ConnTransport) RoundTrip(req *http.Request) (res *http.Response, err er
 	if timeout := t.ResponseHeaderTimeout; timeout != 0 {
 		c.SetReadDeadline(time.Now().Add(timeout))
 	}
+
 	if res, err = http.ReadResponse(r, req); err != nil {
+		t.release(false)
+		return
+	}
+
+	c.limit = -1
+	c.SetReadDeadline(time.Time{})
+
+	// Protocol upgrades take over the connection, it cannot be used to send
+	// HTTP requests anymore.
+	res.Body = &connTransportBody{
+		ReadCloser: res.Body,
+		transport:  t,
+		reuse:      !res.Close && !req.Close && res.StatusCode != http.StatusSwitchingProtocols,
+	}
+	return
+}
+
+// Close closes the connection used by the transport, subsequent calls to
+// RoundTrip return an error.
+func (t *ConnTransport) Close() (err error) {
+	t.mutex.Lock()
+	t.closed = true
+	conn := t.Conn
+	if t.conn != nil {
+		conn = t.conn.Conn
+	}
+	t.mutex.Unlock()
+
+	if conn != nil {
+		err = conn.Close()
+	}
+	return
+}
+
+func (t *ConnTransport) acquire(req *http.Request) (c *connReader, r *bufio.Reader, w connWriter, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch {
+	case t.closed:
+		err = errConnTransportClosed
+		return
+	case t.broken:
+		err = errConnTransportBroken
+		return
+	case t.conn != nil:
+		c, r, w = t.conn, t.r, t.w
 		return
 	}
 
-	if dial != nil {
-		res.Body = struct {
-			io.Reader
-			io.Closer
-		}{
-			Reader: res.Body,
-			Closer: conn,
+	conn := t.Conn
+
+	if conn == nil {
+		dial := t.DialContext
+		if dial == nil {
+			dial = dialer.DialContext
 		}
+		if conn, err = dial(req.Context(), "tcp", req.Host); err != nil {
+			return
+		}
+		t.dialed = true
 	}
 
-	c.limit = -1
-	c.SetReadDeadline(time.Time{})
+	c = &connReader{Conn: conn, limit: -1}
+
+	if b := t.Buffer; b != nil && b.Reader != nil {
+		r = b.Reader
+		r.Reset(c)
+	} else {
+		r = bufio.NewReaderSize(c, bufferSize(t.ReadBufferSize))
+	}
+
+	if b := t.Buffer; b != nil && b.Writer != nil {
+		b.Writer.Reset(c)
+		w = b.Writer
+	} else {
+		// The header and body of requests are coalesced in vectored writes
+		// instead of being copied to a buffer.
+		w = newBatchWriter(c, bufferSize(t.WriteBufferSize))
+	}
+
+	t.conn, t.r, t.w = c, r, w
+	return
+}
+
+func (t *ConnTransport) release(reuse bool) {
+	if !reuse {
+		t.mutex.Lock()
+
+		if t.dialed {
+			t.conn.Close()
+			t.conn, t.r, t.w, t.dialed = nil, nil, nil, false
+		} else {
+			t.broken = true
+		}
+
+		t.mutex.Unlock()
+	}
+
+	t.lock.Unlock()
+}
+
+// bufferSize returns size, or the default buffer size if size is zero or
+// negative.
+func bufferSize(size int) int {
+	if size <= 0 {
+		return defaultBufferSize
+	}
+	return size
+}
+
+// defaultBufferSize is the default size of the I/O buffers of connections, it
+// is the default size of bufio readers and writers.
+const defaultBufferSize = 4096
+
+// connWriter is the interface of the writers used by ConnTransport, implemented
+// by *bufio.Writer and *batchWriter.
+type connWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// connTransportBody wraps the body of responses returned by a ConnTransport to
+// release the connection when the body is closed.
+type connTransportBody struct {
+	io.ReadCloser
+	transport *ConnTransport
+	reuse     bool
+	once      sync.Once
+}
+
+// Close satisfies the io.Closer interface.
+func (b *connTransportBody) Close() (err error) {
+	// Closing the body reads the remaining bytes so the next response can be
+	// read from the connection.
+	err = b.ReadCloser.Close()
+	b.once.Do(func() { b.transport.release(b.reuse && err == nil) })
 	return
 }
 
@@ -142,7 +284,7 @@ func (c *connReader) Read(b []byte) (n int, err error) {
 		c.limit -= n
 	}
 
-	if err != nil && !netx.IsTemporary(err) {
+	if err != nil && !netx.IsTemporary(err) && c.cancel != nil {
 		c.cancel()
 	}
 
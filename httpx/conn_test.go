@@ -3,8 +3,10 @@ package httpx
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -30,6 +32,65 @@ func TestConnTransportConfigured(t *testing.T) {
 	})
 }
 
+func TestConnTransportBufferSizes(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &ConnTransport{
+			ReadBufferSize:  64,
+			WriteBufferSize: 64,
+		}
+	})
+}
+
+func TestConnTransportConnectionClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte("Hello World!"))
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &ConnTransport{Conn: conn}
+	defer transport.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if _, err := transport.RoundTrip(req); err != errConnTransportBroken {
+		t.Error("expected an error when sending a request on a closed connection but got", err)
+	}
+}
+
+func TestConnTransportClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	transport := &ConnTransport{}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if err := transport.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := transport.RoundTrip(req); err != errConnTransportClosed {
+		t.Error("expected an error when sending a request on a closed transport but got", err)
+	}
+}
+
 func TestConnReader(t *testing.T) {
 	c1, c2 := net.Pipe()
 	defer c1.Close()
@@ -0,0 +1,124 @@
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// rewriteCookies applies the cookie rewriting options of the proxy to the
+// Set-Cookie headers in h.
+func (p *ReverseProxy) rewriteCookies(h http.Header) {
+	if len(p.CookieDomains) == 0 && len(p.CookiePaths) == 0 && !p.CookieSecure && p.CookieSameSite == 0 {
+		return
+	}
+
+	cookies := h["Set-Cookie"]
+
+	for i, cookie := range cookies {
+		cookies[i] = p.rewriteCookie(cookie)
+	}
+}
+
+func (p *ReverseProxy) rewriteCookie(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	secure := false
+	sameSite := false
+
+	for i := 1; i < len(parts); i++ {
+		attr := trimOWS(parts[i])
+		name, value := attr, ""
+
+		if j := strings.IndexByte(attr, '='); j >= 0 {
+			name, value = trimOWS(attr[:j]), trimOWS(attr[j+1:])
+		}
+
+		switch strings.ToLower(name) {
+		case "domain":
+			if domain, ok := rewriteCookieDomain(p.CookieDomains, value); ok {
+				if len(domain) == 0 {
+					parts = append(parts[:i], parts[i+1:]...)
+					i--
+					continue
+				}
+				attr = "Domain=" + domain
+			}
+
+		case "path":
+			if path, ok := rewritePathPrefix(p.CookiePaths, value); ok {
+				attr = "Path=" + path
+			}
+
+		case "secure":
+			secure = true
+
+		case "samesite":
+			if s := sameSiteString(p.CookieSameSite); len(s) != 0 {
+				attr = "SameSite=" + s
+			}
+			sameSite = true
+		}
+
+		parts[i] = " " + attr
+	}
+
+	if s := sameSiteString(p.CookieSameSite); len(s) != 0 && !sameSite {
+		parts = append(parts, " SameSite="+s)
+	}
+
+	if !secure && (p.CookieSecure || p.CookieSameSite == http.SameSiteNoneMode) {
+		parts = append(parts, " Secure")
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// rewriteCookieDomain looks up the replacement for domain in domains, the
+// special "*" key matches any domain.
+func rewriteCookieDomain(domains map[string]string, domain string) (string, bool) {
+	key := strings.ToLower(strings.TrimPrefix(domain, "."))
+
+	if s, ok := domains[key]; ok {
+		return s, true
+	}
+
+	if s, ok := domains["*"]; ok {
+		return s, true
+	}
+
+	return "", false
+}
+
+// rewritePathPrefix replaces the longest prefix of path found in paths.
+func rewritePathPrefix(paths map[string]string, path string) (string, bool) {
+	var match string
+	var found bool
+
+	for prefix := range paths {
+		if strings.HasPrefix(path, prefix) && len(prefix) >= len(match) {
+			match, found = prefix, true
+		}
+	}
+
+	if !found {
+		return "", false
+	}
+
+	if path = paths[match] + path[len(match):]; len(path) == 0 {
+		path = "/"
+	}
+
+	return path, true
+}
+
+func sameSiteString(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	default:
+		return ""
+	}
+}
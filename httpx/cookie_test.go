@@ -0,0 +1,102 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		proxy  ReverseProxy
+		cookie string
+		result string
+	}{
+		{
+			name:   "unchanged",
+			proxy:  ReverseProxy{CookieDomains: map[string]string{"backend.local": "example.com"}},
+			cookie: "sid=1234; Path=/; HttpOnly",
+			result: "sid=1234; Path=/; HttpOnly",
+		},
+		{
+			name:   "domain",
+			proxy:  ReverseProxy{CookieDomains: map[string]string{"backend.local": "example.com"}},
+			cookie: "sid=1234; domain=.Backend.local; Path=/",
+			result: "sid=1234; Domain=example.com; Path=/",
+		},
+		{
+			name:   "domain-wildcard",
+			proxy:  ReverseProxy{CookieDomains: map[string]string{"*": "example.com"}},
+			cookie: "sid=1234; Domain=backend.local",
+			result: "sid=1234; Domain=example.com",
+		},
+		{
+			name:   "domain-removed",
+			proxy:  ReverseProxy{CookieDomains: map[string]string{"backend.local": ""}},
+			cookie: "sid=1234; Domain=backend.local; Secure",
+			result: "sid=1234; Secure",
+		},
+		{
+			name:   "path",
+			proxy:  ReverseProxy{CookiePaths: map[string]string{"/": "/app/", "/api/": "/app/v1/"}},
+			cookie: "sid=1234; Path=/api/users",
+			result: "sid=1234; Path=/app/v1/users",
+		},
+		{
+			name:   "path-root",
+			proxy:  ReverseProxy{CookiePaths: map[string]string{"/app": ""}},
+			cookie: "sid=1234; Path=/app",
+			result: "sid=1234; Path=/",
+		},
+		{
+			name:   "secure",
+			proxy:  ReverseProxy{CookieSecure: true},
+			cookie: "sid=1234; Path=/",
+			result: "sid=1234; Path=/; Secure",
+		},
+		{
+			name:   "same-site",
+			proxy:  ReverseProxy{CookieSameSite: http.SameSiteStrictMode},
+			cookie: "sid=1234; SameSite=Lax",
+			result: "sid=1234; SameSite=Strict",
+		},
+		{
+			name:   "same-site-none",
+			proxy:  ReverseProxy{CookieSameSite: http.SameSiteNoneMode},
+			cookie: "sid=1234",
+			result: "sid=1234; SameSite=None; Secure",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if s := test.proxy.rewriteCookie(test.cookie); s != test.result {
+				t.Errorf("bad cookie:\n%q\n%q", test.result, s)
+			}
+		})
+	}
+}
+
+func TestProxyRewriteCookies(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Domain=backend.local")
+		w.Header().Add("Set-Cookie", "b=2; Path=/")
+	}))
+	defer origin.Close()
+
+	req := httptest.NewRequest("GET", origin.URL, nil)
+	res := httptest.NewRecorder()
+
+	(&ReverseProxy{
+		CookieDomains: map[string]string{"backend.local": "example.com"},
+		CookiePaths:   map[string]string{"/": "/app/"},
+	}).ServeHTTP(res, req)
+
+	cookies := res.Header()["Set-Cookie"]
+
+	if !reflect.DeepEqual(cookies, []string{"a=1; Domain=example.com", "b=2; Path=/app/"}) {
+		t.Error("bad cookies:", cookies)
+	}
+}
@@ -0,0 +1,191 @@
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSHandler is a HTTP handler which implements Cross-Origin Resource Sharing
+// (see https://www.w3.org/TR/cors/) in front of its sub-handler.
+//
+// Preflight requests are answered directly by the CORS handler, with 204 No
+// Content if the cross-origin request is allowed or 403 Forbidden otherwise.
+// Other requests are passed to the sub-handler, with the CORS headers added to
+// the response when the origin is allowed.
+type CORSHandler struct {
+	// Handler is the sub-handler that the CORSHandler delegates requests to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+
+	// AllowedOrigins is the list of origins that are allowed to make
+	// cross-origin requests. An origin may contain a wildcard for sub-domains
+	// (e.g. "https://*.example.com"), and "*" allows all origins.
+	AllowedOrigins []string
+
+	// AllowOrigin, if not nil, is called to determine whether origins that are
+	// not in AllowedOrigins are allowed.
+	AllowOrigin func(origin string) bool
+
+	// AllowedMethods is the list of methods that cross-origin requests may use.
+	// If empty, only the simple methods (GET, HEAD, and POST) are allowed.
+	AllowedMethods []string
+
+	// AllowedHeaders is the list of non-simple headers that cross-origin
+	// requests may have, "*" allows all headers.
+	AllowedHeaders []string
+
+	// ExposedHeaders is the list of non-simple response headers that the
+	// client is allowed to access.
+	ExposedHeaders []string
+
+	// AllowCredentials indicates whether cross-origin requests may include
+	// credentials like cookies or HTTP authentication.
+	AllowCredentials bool
+
+	// MaxAge is how long the results of preflight requests may be cached by
+	// clients. Zero lets the clients use their default.
+	MaxAge time.Duration
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *CORSHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+
+	if len(origin) == 0 {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	if req.Method == http.MethodOptions && len(req.Header.Get("Access-Control-Request-Method")) != 0 {
+		h.servePreflight(w, req, origin)
+		return
+	}
+
+	if h.allowOrigin(origin) {
+		h.setAllowOrigin(header, origin)
+
+		if len(h.ExposedHeaders) != 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(h.ExposedHeaders, ", "))
+		}
+	}
+
+	h.Handler.ServeHTTP(w, req)
+}
+
+func (h *CORSHandler) servePreflight(w http.ResponseWriter, req *http.Request, origin string) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	method := req.Header.Get("Access-Control-Request-Method")
+	var headers []string
+	forEachHeaderValues(req.Header["Access-Control-Request-Headers"], func(v string) {
+		headers = append(headers, v)
+	})
+
+	if !h.allowOrigin(origin) || !h.allowMethod(method) || !h.allowHeaders(headers) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h.setAllowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", method)
+
+	if len(headers) != 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+
+	if h.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(h.MaxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CORSHandler) setAllowOrigin(header http.Header, origin string) {
+	if h.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	} else if containsString(h.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+func (h *CORSHandler) allowOrigin(origin string) bool {
+	for _, allowed := range h.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return h.AllowOrigin != nil && h.AllowOrigin(origin)
+}
+
+func (h *CORSHandler) allowMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		return true
+	}
+	return containsString(h.AllowedMethods, method)
+}
+
+func (h *CORSHandler) allowHeaders(headers []string) bool {
+	if containsString(h.AllowedHeaders, "*") {
+		return true
+	}
+	for _, name := range headers {
+		if !isSimpleHeader(name) && !containsFold(h.AllowedHeaders, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchOrigin checks whether origin matches pattern, which may be "*" or have
+// a wildcard for sub-domains.
+func matchOrigin(pattern string, origin string) bool {
+	if pattern == "*" || strings.EqualFold(pattern, origin) {
+		return true
+	}
+	if i := strings.Index(pattern, "*."); i >= 0 {
+		prefix, suffix := pattern[:i], pattern[i+1:]
+		return len(origin) > len(prefix)+len(suffix) &&
+			strings.EqualFold(origin[:len(prefix)], prefix) &&
+			strings.EqualFold(origin[len(origin)-len(suffix):], suffix)
+	}
+	return false
+}
+
+// isSimpleHeader checks whether name is a CORS simple request header.
+func isSimpleHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Accept", "Accept-Language", "Content-Language", "Content-Type":
+		return true
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSHandler(t *testing.T) {
+	handler := &CORSHandler{
+		Handler:        StatusHandler(http.StatusOK),
+		AllowedOrigins: []string{"https://example.com", "https://*.example.net"},
+		AllowedMethods: []string{"PUT"},
+		AllowedHeaders: []string{"X-Token"},
+		ExposedHeaders: []string{"X-Request-Id"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		header  http.Header
+		status  int
+		allowed http.Header
+	}{
+		{
+			name:   "same-origin",
+			method: "GET",
+			status: http.StatusOK,
+		},
+		{
+			name:   "allowed",
+			method: "GET",
+			header: http.Header{"Origin": {"https://example.com"}},
+			status: http.StatusOK,
+			allowed: http.Header{
+				"Access-Control-Allow-Origin":   {"https://example.com"},
+				"Access-Control-Expose-Headers": {"X-Request-Id"},
+			},
+		},
+		{
+			name:   "wildcard",
+			method: "GET",
+			header: http.Header{"Origin": {"https://api.example.net"}},
+			status: http.StatusOK,
+			allowed: http.Header{
+				"Access-Control-Allow-Origin": {"https://api.example.net"},
+			},
+		},
+		{
+			name:   "not-allowed",
+			method: "GET",
+			header: http.Header{"Origin": {"https://example.org"}},
+			status: http.StatusOK,
+			allowed: http.Header{
+				"Access-Control-Allow-Origin": nil,
+			},
+		},
+		{
+			name:   "preflight",
+			method: "OPTIONS",
+			header: http.Header{
+				"Origin":                         {"https://example.com"},
+				"Access-Control-Request-Method":  {"PUT"},
+				"Access-Control-Request-Headers": {"x-token, content-type"},
+			},
+			status: http.StatusNoContent,
+			allowed: http.Header{
+				"Access-Control-Allow-Origin":  {"https://example.com"},
+				"Access-Control-Allow-Methods": {"PUT"},
+				"Access-Control-Allow-Headers": {"x-token, content-type"},
+				"Access-Control-Max-Age":       {"600"},
+			},
+		},
+		{
+			name:   "preflight:bad-method",
+			method: "OPTIONS",
+			header: http.Header{
+				"Origin":                        {"https://example.com"},
+				"Access-Control-Request-Method": {"DELETE"},
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "preflight:bad-header",
+			method: "OPTIONS",
+			header: http.Header{
+				"Origin":                         {"https://example.com"},
+				"Access-Control-Request-Method":  {"PUT"},
+				"Access-Control-Request-Headers": {"X-Other"},
+			},
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/", nil)
+			copyHeader(req.Header, test.header)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+
+			for name, values := range test.allowed {
+				if value := res.Header().Get(name); (len(values) == 0 && len(value) != 0) || (len(values) != 0 && value != values[0]) {
+					t.Errorf("bad %s header: %q", name, value)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSHandlerCredentials(t *testing.T) {
+	handler := &CORSHandler{
+		Handler:          StatusHandler(http.StatusOK),
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	res := httptest.NewRecorder()
+
+	handler.ServeHTTP(res, req)
+
+	if origin := res.Header().Get("Access-Control-Allow-Origin"); origin != "https://example.com" {
+		t.Error("the origin must be echoed when credentials are allowed:", origin)
+	}
+
+	if creds := res.Header().Get("Access-Control-Allow-Credentials"); creds != "true" {
+		t.Error("bad Access-Control-Allow-Credentials header:", creds)
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		match   bool
+	}{
+		{"*", "https://example.com", true},
+		{"https://example.com", "https://EXAMPLE.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://*.example.com", "https://a.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://a.example.com.evil.org", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+"~"+test.origin, func(t *testing.T) {
+			if match := matchOrigin(test.pattern, test.origin); match != test.match {
+				t.Error(match)
+			}
+		})
+	}
+}
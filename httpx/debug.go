@@ -0,0 +1,163 @@
+package httpx
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// DebugHandler is a HTTP handler which exposes live statistics of a program as
+// a JSON object, it is intended to be mounted on an admin port so the state of
+// a server can be inspected without setting up a metrics stack.
+//
+// Statistics are registered under a name with a function returning their
+// current value, which is called every time the handler serves a request:
+//
+//	debug := &httpx.DebugHandler{}
+//	debug.HandleConns("conns", tracker)
+//	debug.Handle("limits", func() interface{} { return lstn.Stats() })
+//
+// The response contains all the registered statistics, unless the request has
+// name query parameters in which case only the statistics with those names
+// are returned.
+//
+// It is safe to register new statistics while the handler is serving requests.
+type DebugHandler struct {
+	mutex sync.RWMutex
+	vars  map[string]func() interface{}
+}
+
+// Handle registers value under name, the function must return a value which
+// can be encoded to JSON and be safe to call concurrently.
+func (h *DebugHandler) Handle(name string, value func() interface{}) {
+	h.mutex.Lock()
+	if h.vars == nil {
+		h.vars = make(map[string]func() interface{})
+	}
+	h.vars[name] = value
+	h.mutex.Unlock()
+}
+
+// HandleConns registers under name the number of connections open in tracker
+// and the details of each of them.
+func (h *DebugHandler) HandleConns(name string, tracker *netx.ConnTracker) {
+	h.Handle(name, func() interface{} {
+		now := time.Now()
+		conns := tracker.Conns()
+		stats := debugConns{
+			Open:  len(conns),
+			Conns: make([]debugConn, len(conns)),
+		}
+
+		for i, c := range conns {
+			stats.Conns[i] = debugConn{
+				ID:         c.ID,
+				LocalAddr:  addrString(c.LocalAddr),
+				RemoteAddr: addrString(c.RemoteAddr),
+				Age:        now.Sub(c.Start).Round(time.Millisecond).String(),
+				Meta:       c.Meta,
+			}
+		}
+
+		return stats
+	})
+}
+
+// Remove removes the statistics registered under name.
+func (h *DebugHandler) Remove(name string) {
+	h.mutex.Lock()
+	delete(h.vars, name)
+	h.mutex.Unlock()
+}
+
+// Publish exposes the statistics of the handler as an expvar variable with the
+// given name, which makes them available on the /debug/vars endpoint of the
+// expvar package as well.
+//
+// Like expvar.Publish, the method panics if a variable with the same name was
+// already published.
+func (h *DebugHandler) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return h.values(nil)
+	}))
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	values := h.values(req.URL.Query()["name"])
+
+	b, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if req.Method != http.MethodHead {
+		w.Write(append(b, '\n'))
+	}
+}
+
+// values returns the current values of the statistics with the given names, or
+// of all statistics if names is empty.
+func (h *DebugHandler) values(names []string) map[string]interface{} {
+	h.mutex.RLock()
+	vars := make(map[string]func() interface{}, len(h.vars))
+
+	if len(names) == 0 {
+		for name, value := range h.vars {
+			vars[name] = value
+		}
+	} else {
+		for _, name := range names {
+			if value, ok := h.vars[name]; ok {
+				vars[name] = value
+			}
+		}
+	}
+	h.mutex.RUnlock()
+
+	// The functions are called without holding the lock since they may take
+	// a while to produce the values.
+	values := make(map[string]interface{}, len(vars))
+	for name, value := range vars {
+		values[name] = value()
+	}
+	return values
+}
+
+type debugConns struct {
+	Open  int         `json:"open"`
+	Conns []debugConn `json:"conns"`
+}
+
+type debugConn struct {
+	ID         uint64            `json:"id"`
+	LocalAddr  string            `json:"local_addr,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Age        string            `json:"age"`
+	Meta       map[string]string `json:"meta,omitempty"`
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
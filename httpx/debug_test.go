@@ -0,0 +1,111 @@
+package httpx
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/segmentio/netx"
+)
+
+func TestDebugHandler(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	tracker := &netx.ConnTracker{}
+	tracker.Track(c1, map[string]string{"kind": "tunnel"})
+
+	handler := &DebugHandler{}
+	handler.HandleConns("conns", tracker)
+	handler.Handle("backends", func() interface{} {
+		return map[string]string{"10.0.0.1:80": "up", "10.0.0.2:80": "down"}
+	})
+
+	tests := []struct {
+		url   string
+		names []string
+	}{
+		{url: "/debug", names: []string{"backends", "conns"}},
+		{url: "/debug?name=conns", names: []string{"conns"}},
+		{url: "/debug?name=conns&name=backends&name=other", names: []string{"backends", "conns"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.url, nil)
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Fatal("bad status code:", res.Code)
+			}
+
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(res.Body.Bytes(), &values); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(values) != len(test.names) {
+				t.Error("bad values:", res.Body.String())
+			}
+
+			for _, name := range test.names {
+				if _, ok := values[name]; !ok {
+					t.Error("missing value:", name)
+				}
+			}
+
+			if raw, ok := values["conns"]; ok {
+				var conns struct {
+					Open  int `json:"open"`
+					Conns []struct {
+						ID   uint64            `json:"id"`
+						Meta map[string]string `json:"meta"`
+					} `json:"conns"`
+				}
+				json.Unmarshal(raw, &conns)
+
+				if conns.Open != 1 || len(conns.Conns) != 1 || conns.Conns[0].Meta["kind"] != "tunnel" {
+					t.Error("bad connections:", string(raw))
+				}
+			}
+		})
+	}
+
+	t.Run("POST", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/debug", nil)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != http.StatusMethodNotAllowed {
+			t.Error("bad status code:", res.Code)
+		}
+	})
+}
+
+func TestDebugHandlerPublish(t *testing.T) {
+	n := 0
+
+	handler := &DebugHandler{}
+	handler.Handle("counter", func() interface{} { n++; return n })
+	handler.Publish("httpx-debug-test")
+
+	v := expvar.Get("httpx-debug-test")
+	if v == nil {
+		t.Fatal("the statistics were not published")
+	}
+
+	if s := v.String(); s != `{"counter":1}` {
+		t.Error("bad expvar value:", s)
+	}
+
+	handler.Remove("counter")
+
+	if s := v.String(); s != `{}` {
+		t.Error("bad expvar value after removing the counter:", s)
+	}
+}
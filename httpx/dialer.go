@@ -0,0 +1,142 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// ProxyDialer establishes connections through a HTTP proxy by sending CONNECT
+// requests, the connections it returns can be used for any protocol.
+type ProxyDialer struct {
+	// URL is the address of the proxy, with the http or https scheme. When the
+	// URL has user information it is sent to the proxy in the
+	// Proxy-Authorization header, using basic authentication.
+	URL *url.URL
+
+	// TLSClientConfig is used to establish TLS connections to proxies using
+	// the https scheme.
+	TLSClientConfig *tls.Config
+
+	// ProxyHeader contains the header fields sent to the proxy with every
+	// CONNECT request.
+	ProxyHeader http.Header
+
+	// Dial is used to establish connections to the proxy. If nil, a
+	// net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+}
+
+// DialContext connects to address through the proxy, the network must be one
+// of tcp, tcp4, or tcp6.
+//
+// The method has the signature expected by the DialContext fields of
+// transports and proxies.
+func (d *ProxyDialer) DialContext(ctx context.Context, network string, address string) (conn net.Conn, err error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		err = &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+		return
+	}
+
+	dial := d.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	if conn, err = dial(ctx, "tcp", urlAddr(d.URL)); err != nil {
+		return
+	}
+
+	if d.URL.Scheme == "https" {
+		config := d.TLSClientConfig
+		if config == nil {
+			config = &tls.Config{}
+		} else {
+			config = config.Clone()
+		}
+		if len(config.ServerName) == 0 {
+			config.ServerName = d.URL.Hostname()
+		}
+		conn = tls.Client(conn, config)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header, len(d.ProxyHeader)+1),
+	}
+
+	copyHeader(req.Header, d.ProxyHeader)
+
+	if user := d.URL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+
+	var r = bufio.NewReader(conn)
+	var res *http.Response
+
+	if err = req.Write(conn); err == nil {
+		res, err = http.ReadResponse(r, req)
+	}
+
+	if err == nil && res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("CONNECT %s: %s", address, res.Status)
+	}
+
+	if err != nil {
+		conn.Close()
+		conn = nil
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	if r.Buffered() != 0 {
+		conn = &bufferedConn{Conn: conn, r: r}
+	}
+
+	return
+}
+
+// Hop returns a dialer which reaches the proxy through dial, the method can be
+// used as a hop of a netx.ChainDialer.
+func (d *ProxyDialer) Hop(dial netx.DialFunc) netx.DialFunc {
+	c := *d
+	c.Dial = dial
+	return c.DialContext
+}
+
+// urlAddr returns the address of the server at u in the host:port form, with
+// the default port of its scheme if u has none.
+func urlAddr(u *url.URL) string {
+	port := u.Port()
+
+	if len(port) == 0 {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
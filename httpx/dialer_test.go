@@ -0,0 +1,117 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/segmentio/netx"
+	"github.com/segmentio/netx/socksx"
+)
+
+func TestProxyDialer(t *testing.T) {
+	origin, closeOrigin := listenAndServe(netx.Echo)
+	defer closeOrigin()
+
+	headers := make(chan http.Header, 1)
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &ForwardProxy{
+			Authenticate: func(req *http.Request) (string, error) {
+				headers <- req.Header
+				if user, pass, ok := ProxyBasicAuth(req); ok && pass == "secret" {
+					return user, nil
+				}
+				return "", errors.New("bad credentials")
+			},
+		},
+	})
+	defer closeProxy()
+
+	proxyURL, _ := url.Parse(proxy)
+	proxyURL.User = url.UserPassword("luke", "secret")
+
+	_, originAddr := netx.SplitNetAddr(origin)
+
+	d := &ProxyDialer{
+		URL:         proxyURL,
+		ProxyHeader: http.Header{"X-Test": {"42"}},
+	}
+
+	conn, err := d.DialContext(context.Background(), "tcp", originAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if h := <-headers; h.Get("X-Test") != "42" {
+		t.Error("the proxy header was not sent:", h)
+	}
+
+	conn.Write([]byte("Hello World!"))
+	b := make([]byte, 12)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Error("bad response:", string(b))
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		proxyURL, _ := url.Parse(proxy)
+		d := &ProxyDialer{URL: proxyURL}
+
+		if _, err := d.DialContext(context.Background(), "tcp", originAddr); err == nil {
+			t.Error("no error returned when the proxy rejected the request")
+		}
+		<-headers
+	})
+
+	t.Run("bad-network", func(t *testing.T) {
+		if _, err := d.DialContext(context.Background(), "udp", originAddr); err == nil {
+			t.Error("no error returned for an unsupported network")
+		}
+	})
+}
+
+func TestProxyDialerChain(t *testing.T) {
+	origin, closeOrigin := listenAndServe(netx.Echo)
+	defer closeOrigin()
+
+	proxy, closeProxy := listenAndServe(&Server{Handler: &ForwardProxy{}})
+	defer closeProxy()
+
+	socks, closeSocks := listenAndServe(&socksx.Server{})
+	defer closeSocks()
+
+	proxyURL, _ := url.Parse(proxy)
+	_, originAddr := netx.SplitNetAddr(origin)
+	_, socksAddr := netx.SplitNetAddr(socks)
+
+	d := &netx.ChainDialer{
+		Hops: []netx.Hop{
+			(&socksx.Dialer{Address: socksAddr}).Hop,
+			(&ProxyDialer{URL: proxyURL}).Hop,
+		},
+	}
+
+	conn, err := d.DialContext(context.Background(), "tcp", originAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("Hello World!"))
+	b := make([]byte, 12)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Error("bad response:", string(b))
+	}
+}
@@ -0,0 +1,142 @@
+package httpx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+)
+
+// DumpTransport is a http.RoundTripper which writes the requests it sends and
+// the responses it receives to an io.Writer, in their HTTP/1.1 wire format.
+// It is intended to be used for debugging interoperability problems with
+// backend servers.
+//
+// The bytes of each round trip are written in a single block when the body of
+// the response is closed, so dumps of concurrent requests don't interleave.
+// Because of this, and because request bodies are read in memory before being
+// sent, the transport isn't suited for large payloads.
+type DumpTransport struct {
+	// Transport is the sub-transport that the DumpTransport delegates requests
+	// to.
+	//
+	// http.DefaultTransport is used if Transport is nil.
+	Transport http.RoundTripper
+
+	// Output is where the round trips are written.
+	//
+	// RoundTrip will panic if Output is nil.
+	Output io.Writer
+
+	// Redact is called with a copy of the header of each request and response
+	// before they are written to the output, giving the program a chance to
+	// hide sensitive information.
+	//
+	// If nil, RedactAuthorization is used.
+	Redact func(http.Header)
+
+	mutex sync.Mutex
+}
+
+// RedactAuthorization replaces the values of the Authorization and
+// Proxy-Authorization headers of h with a placeholder.
+func RedactAuthorization(h http.Header) {
+	for _, name := range [...]string{"Authorization", "Proxy-Authorization"} {
+		if _, ok := h[name]; ok {
+			h.Set(name, "REDACTED")
+		}
+	}
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *DumpTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	dump := &dumpBody{transport: t}
+
+	// The request is dumped from a copy with a redacted header, the body gets
+	// replaced with one which can be read again when sending the request.
+	dumpreq := *req
+	dumpreq.Header = t.redact(req.Header)
+
+	b, err := httputil.DumpRequestOut(&dumpreq, true)
+	if err != nil {
+		return
+	}
+	dump.buffer.Write(b)
+
+	outreq := *req
+	outreq.Body = dumpreq.Body
+
+	if res, err = transport.RoundTrip(&outreq); err != nil {
+		fmt.Fprintf(&dump.buffer, "\n%s\n\n", err)
+		dump.flush()
+		return
+	}
+
+	dumpres := *res
+	dumpres.Header = t.redact(res.Header)
+
+	if b, err = httputil.DumpResponse(&dumpres, false); err != nil {
+		res.Body.Close()
+		res = nil
+		return
+	}
+	dump.buffer.Write(b)
+
+	dump.ReadCloser = res.Body
+	res.Body = dump
+	return
+}
+
+func (t *DumpTransport) redact(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+	copyHeader(h, header)
+
+	if redact := t.Redact; redact != nil {
+		redact(h)
+	} else {
+		RedactAuthorization(h)
+	}
+
+	return h
+}
+
+// dumpBody is a io.ReadCloser wrapper which records the bytes of a response
+// body, and writes the dump of the round trip to the transport's output when
+// it is closed.
+type dumpBody struct {
+	io.ReadCloser
+	transport *DumpTransport
+	buffer    bytes.Buffer
+	once      sync.Once
+}
+
+// Read satisfies the io.Reader interface.
+func (d *dumpBody) Read(b []byte) (n int, err error) {
+	if n, err = d.ReadCloser.Read(b); n > 0 {
+		d.buffer.Write(b[:n])
+	}
+	return
+}
+
+// Close satisfies the io.Closer interface.
+func (d *dumpBody) Close() error {
+	d.once.Do(func() {
+		d.buffer.WriteString("\n\n")
+		d.flush()
+	})
+	return d.ReadCloser.Close()
+}
+
+func (d *dumpBody) flush() {
+	t := d.transport
+	t.mutex.Lock()
+	d.buffer.WriteTo(t.Output)
+	t.mutex.Unlock()
+}
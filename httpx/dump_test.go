@@ -0,0 +1,70 @@
+package httpx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestDumpTransportDefault(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &DumpTransport{Output: ioutil.Discard}
+	})
+}
+
+func TestDumpTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			t.Error("the authorization header was not sent to the server")
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(b)
+	}))
+	defer server.Close()
+
+	output := &bytes.Buffer{}
+	transport := &DumpTransport{Output: output}
+
+	req, _ := http.NewRequest("POST", server.URL+"/echo", strings.NewReader("Hello World!"))
+	req.Header.Set("Authorization", "Bearer secret")
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if s := string(b); s != "Hello World!" {
+		t.Errorf("bad response body: %q", s)
+	}
+
+	dump := output.String()
+
+	for _, s := range []string{
+		"POST /echo HTTP/1.1\r\n",
+		"Authorization: REDACTED\r\n",
+		"\r\n\r\nHello World!",
+		"HTTP/1.1 200 OK\r\n",
+		"Content-Type: text/plain\r\n",
+	} {
+		if !strings.Contains(dump, s) {
+			t.Errorf("%q not found in the dump:\n%s", s, dump)
+		}
+	}
+
+	if strings.Contains(dump, "secret") {
+		t.Errorf("the authorization header was not redacted:\n%s", dump)
+	}
+
+	if n := strings.Count(dump, "Hello World!"); n != 2 {
+		t.Errorf("the request and response bodies were not dumped:\n%s", dump)
+	}
+}
@@ -101,6 +101,10 @@ func (r *contentEncodingReader) Close() error {
 //
 // If contentEncodings is nil (no arguments were passed) the returned handler
 // uses DefaultEncodings.
+//
+// Requests accepting text/event-stream responses are never encoded, since the
+// encoders would buffer the events instead of delivering them as they are
+// written.
 func NewEncodingHandler(handler http.Handler, contentEncodings ...ContentEncoding) http.Handler {
 	if contentEncodings == nil {
 		contentEncodings = defaultEncodings()
@@ -118,7 +122,7 @@ func NewEncodingHandler(handler http.Handler, contentEncodings ...ContentEncodin
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		accept := req.Header["Accept-Encoding"]
 
-		if len(accept) != 0 {
+		if len(accept) != 0 && !acceptsEventStream(req) {
 			coding := NegotiateEncoding(accept[0], codings...)
 
 			if len(coding) != 0 {
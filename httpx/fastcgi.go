@@ -0,0 +1,521 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FastCGITransport is a HTTP transport which forwards requests to FastCGI
+// servers, like PHP-FPM, translating them to CGI parameters and streaming the
+// responses back. Using it as the transport of a ReverseProxy lets programs
+// front legacy application servers:
+//
+//	proxy := &httpx.ReverseProxy{
+//		Transport: &httpx.FastCGITransport{
+//			Network:   "unix",
+//			Address:   "/run/php/php-fpm.sock",
+//			Root:      "/var/www/html",
+//			SplitPath: ".php",
+//			Index:     "index.php",
+//		},
+//	}
+//
+// Each request is sent on a new connection to the FastCGI server. Request
+// bodies of unknown length are buffered in memory, since FastCGI applications
+// need the CONTENT_LENGTH parameter to read them.
+type FastCGITransport struct {
+	// Network and Address are the network and address of the FastCGI server,
+	// for example "unix" and "/run/php/php-fpm.sock", or "tcp" and
+	// "127.0.0.1:9000". If Address is empty, requests are sent over TCP to
+	// the host of their URL.
+	Network string
+	Address string
+
+	// DialContext is used to establish connections to the FastCGI server. If
+	// nil, a net.Dialer with a 10 seconds timeout is used.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// Root is the document root on the FastCGI server, the path of the scripts
+	// (the SCRIPT_FILENAME parameter) is the cleaned path of the URL under it,
+	// so requests cannot reach files outside of the root.
+	Root string
+
+	// SplitPath is the extension of scripts, used to split the path of the URL
+	// between the script name and the extra path information passed to it
+	// (SCRIPT_NAME and PATH_INFO). For example with ".php", the path
+	// /index.php/users/42 calls the /index.php script with /users/42 as path
+	// information.
+	SplitPath string
+
+	// Index is the name of the script called for the paths ending with a
+	// slash, for example "index.php".
+	Index string
+
+	// Params is a set of extra parameters passed to the FastCGI server, they
+	// override the parameters computed by the transport.
+	Params map[string]string
+
+	// ErrorLog is used to log the messages written by the application to its
+	// standard error. If nil, the default logger is used.
+	ErrorLog *log.Logger
+}
+
+// FastCGI record types.
+const (
+	fcgiBeginRequest = 1
+	fcgiAbortRequest = 2
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+)
+
+const (
+	fcgiVersion   = 1
+	fcgiResponder = 1
+	fcgiRequestID = 1 // each connection carries a single request
+	fcgiMaxWrite  = 65535
+)
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *FastCGITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	body, length, err := fcgiRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	network, address := t.Network, t.Address
+	if len(address) == 0 {
+		network, address = "tcp", req.URL.Host
+	} else if len(network) == 0 {
+		network = "tcp"
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	params, err := t.params(req, length)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context()
+
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The connection is closed if the context is canceled, which interrupts
+	// the requests and the reads of the response body.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	res, err := t.roundTrip(conn, req, params, body)
+	if err != nil {
+		stop()
+		conn.Close()
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, err
+	}
+
+	res.Body = &fcgiResponseBody{Reader: res.Body, conn: conn, stop: stop}
+	return res, nil
+}
+
+func (t *FastCGITransport) roundTrip(conn net.Conn, req *http.Request, params []byte, body io.Reader) (*http.Response, error) {
+	w := &fcgiWriter{w: bufio.NewWriter(conn)}
+
+	// The role is a 16 bits integer followed by the flags and 5 reserved
+	// bytes, the connection is not kept open after the request.
+	w.writeRecord(fcgiBeginRequest, []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0})
+	w.writeStream(fcgiParams, bytes.NewReader(params))
+	w.writeStream(fcgiStdin, body)
+
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	r := &fcgiReader{r: bufio.NewReader(conn), logf: t.logf}
+	br := bufio.NewReader(r)
+
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("fastcgi: reading the response header: %w", err)
+	}
+
+	res := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(header),
+		Body:          ioutil.NopCloser(br),
+		ContentLength: -1,
+		Request:       req,
+	}
+
+	if status := res.Header.Get("Status"); len(status) != 0 {
+		code, _, _ := strings.Cut(status, " ")
+		if res.StatusCode, err = strconv.Atoi(code); err != nil || res.StatusCode < 100 || res.StatusCode > 999 {
+			return nil, fmt.Errorf("fastcgi: invalid status in the response: %q", status)
+		}
+		res.Status = status
+		res.Header.Del("Status")
+	} else if len(res.Header.Get("Location")) != 0 {
+		res.Status, res.StatusCode = "302 Found", http.StatusFound
+	}
+
+	if cl := res.Header.Get("Content-Length"); len(cl) != 0 {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			res.ContentLength = n
+		}
+	}
+
+	return res, nil
+}
+
+// params returns the encoded CGI parameters of req, or an error if the path of
+// req is outside of the document root.
+func (t *FastCGITransport) params(req *http.Request, length int64) ([]byte, error) {
+	scriptName, pathInfo := fcgiCleanPath(req.URL.Path), ""
+
+	if len(t.SplitPath) != 0 {
+		scriptName, pathInfo = fcgiSplitPath(scriptName, t.SplitPath)
+	}
+
+	if strings.HasSuffix(scriptName, "/") && len(t.Index) != 0 {
+		scriptName += t.Index
+	}
+
+	scriptFilename, err := fcgiFilename(t.Root, scriptName)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host, port = req.Host, "80"
+		if req.TLS != nil || req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "netx",
+		"SERVER_PROTOCOL":   req.Proto,
+		"SERVER_NAME":       host,
+		"SERVER_PORT":       port,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"DOCUMENT_ROOT":     t.Root,
+		"DOCUMENT_URI":      scriptName,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"PATH_INFO":         pathInfo,
+		"CONTENT_TYPE":      req.Header.Get("Content-Type"),
+		"CONTENT_LENGTH":    strconv.FormatInt(length, 10),
+	}
+
+	if len(pathInfo) != 0 {
+		if params["PATH_TRANSLATED"], err = fcgiFilename(t.Root, pathInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	if remoteHost, remotePort, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"], params["REMOTE_PORT"] = remoteHost, remotePort
+	}
+
+	if req.TLS != nil || req.URL.Scheme == "https" {
+		params["HTTPS"] = "on"
+	}
+
+	if addr := contextLocalAddr(req.Context()); addr != nil {
+		if localHost, _, err := net.SplitHostPort(addr.String()); err == nil {
+			params["SERVER_ADDR"] = localHost
+		}
+	}
+
+	for name, values := range req.Header {
+		switch name {
+		case "Content-Type", "Content-Length":
+			continue
+		case "Proxy":
+			// Passing the Proxy header as HTTP_PROXY would let clients set the
+			// proxy used by the application (httpoxy).
+			continue
+		}
+		params["HTTP_"+strings.ToUpper(strings.Replace(name, "-", "_", -1))] = strings.Join(values, ", ")
+	}
+
+	if _, ok := params["HTTP_HOST"]; !ok && len(req.Host) != 0 {
+		params["HTTP_HOST"] = req.Host
+	}
+
+	for name, value := range t.Params {
+		params[name] = value
+	}
+
+	b := make([]byte, 0, 1024)
+	for name, value := range params {
+		b = appendFCGILength(b, len(name))
+		b = appendFCGILength(b, len(value))
+		b = append(b, name...)
+		b = append(b, value...)
+	}
+	return b, nil
+}
+
+// fcgiCleanPath returns the cleaned and rooted form of the URL path p, so it
+// has no ".." segments, preserving its trailing slash.
+func fcgiCleanPath(p string) string {
+	c := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && c != "/" {
+		c += "/"
+	}
+	return c
+}
+
+// fcgiSplitPath splits p after the first segment ending with ext, between the
+// script name and the extra path information. Paths like /a.phpx/b or
+// /a.php.png are not split.
+func fcgiSplitPath(p string, ext string) (string, string) {
+	for i := 0; i < len(p); {
+		j := strings.Index(p[i:], ext)
+		if j < 0 {
+			break
+		}
+		end := i + j + len(ext)
+		if end == len(p) || p[end] == '/' {
+			return p[:end], p[end:]
+		}
+		i += j + 1
+	}
+	return p, ""
+}
+
+// fcgiFilename returns the path of the file at p under the document root, or
+// an error if it is outside of it.
+func fcgiFilename(root string, p string) (string, error) {
+	name := path.Join(root, p)
+	dir := strings.TrimSuffix(root, "/") + "/"
+
+	if len(root) != 0 && name != root && !strings.HasPrefix(name, dir) {
+		return "", fmt.Errorf("fastcgi: the path is outside of the document root: %q", p)
+	}
+
+	return name, nil
+}
+
+func (t *FastCGITransport) logf(format string, args ...interface{}) {
+	if t.ErrorLog != nil {
+		t.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// fcgiRequestBody returns the body of req and its length, reading it in memory
+// if the length is unknown.
+func fcgiRequestBody(req *http.Request) (io.Reader, int64, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return bytes.NewReader(nil), 0, nil
+	}
+
+	if req.ContentLength >= 0 {
+		return io.LimitReader(req.Body, req.ContentLength), req.ContentLength, nil
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes.NewReader(b), int64(len(b)), nil
+}
+
+func appendFCGILength(b []byte, n int) []byte {
+	if n < 128 {
+		return append(b, byte(n))
+	}
+	return append(b, byte(n>>24)|0x80, byte(n>>16), byte(n>>8), byte(n))
+}
+
+// fcgiWriter writes FastCGI records, the first error is retained and makes the
+// following writes no-ops.
+type fcgiWriter struct {
+	w   *bufio.Writer
+	buf [fcgiMaxWrite]byte
+	err error
+}
+
+func (w *fcgiWriter) writeRecord(recType byte, content []byte) {
+	if w.err != nil {
+		return
+	}
+
+	header := [8]byte{fcgiVersion, recType}
+	binary.BigEndian.PutUint16(header[2:], fcgiRequestID)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+
+	if _, w.err = w.w.Write(header[:]); w.err == nil {
+		_, w.err = w.w.Write(content)
+	}
+}
+
+// writeStream writes the content of r as a stream of records, terminated by
+// an empty record.
+func (w *fcgiWriter) writeStream(recType byte, r io.Reader) {
+	for w.err == nil {
+		n, err := io.ReadFull(r, w.buf[:])
+
+		if n != 0 {
+			w.writeRecord(recType, w.buf[:n])
+		}
+
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				w.err = err
+			}
+			break
+		}
+	}
+
+	w.writeRecord(recType, nil)
+}
+
+// fcgiReader reads the standard output stream of a FastCGI response, the
+// messages written to the standard error stream are logged.
+type fcgiReader struct {
+	r    *bufio.Reader
+	logf func(string, ...interface{})
+	n    int // remaining bytes of the current stdout record
+	pad  int // padding of the current stdout record
+	err  error
+}
+
+var errFCGIRequestFailed = errors.New("fastcgi: the application could not handle the request")
+
+func (r *fcgiReader) Read(b []byte) (int, error) {
+	for r.n == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		if r.pad != 0 {
+			if _, r.err = r.r.Discard(r.pad); r.err != nil {
+				continue
+			}
+			r.pad = 0
+		}
+
+		var header [8]byte
+		if _, r.err = io.ReadFull(r.r, header[:]); r.err != nil {
+			if r.err == io.EOF {
+				r.err = io.ErrUnexpectedEOF
+			}
+			continue
+		}
+
+		recType := header[1]
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		padding := int(header[6])
+
+		switch recType {
+		case fcgiStdout:
+			r.n, r.pad = length, padding
+
+		case fcgiStderr, fcgiEndRequest:
+			content := make([]byte, length+padding)
+			if _, r.err = io.ReadFull(r.r, content); r.err != nil {
+				continue
+			}
+			content = content[:length]
+
+			if recType == fcgiStderr {
+				if msg := strings.TrimSpace(string(content)); len(msg) != 0 {
+					r.logf("fastcgi: %s", msg)
+				}
+				continue
+			}
+
+			// The protocol status is the fifth byte of the END_REQUEST body,
+			// zero means that the request was completed.
+			if len(content) >= 5 && content[4] != 0 {
+				r.err = errFCGIRequestFailed
+			} else {
+				r.err = io.EOF
+			}
+
+		default:
+			if _, r.err = r.r.Discard(length + padding); r.err == nil {
+				r.err = fmt.Errorf("fastcgi: unexpected record of type %d", recType)
+			}
+		}
+	}
+
+	if len(b) > r.n {
+		b = b[:r.n]
+	}
+
+	n, err := r.r.Read(b)
+	r.n -= n
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// fcgiResponseBody is the body of responses returned by FastCGITransport, it
+// closes the connection to the FastCGI server when it is closed.
+type fcgiResponseBody struct {
+	io.Reader
+	conn net.Conn
+	stop func() bool
+	once sync.Once
+}
+
+func (b *fcgiResponseBody) Close() error {
+	b.once.Do(func() {
+		b.stop()
+		b.conn.Close()
+	})
+	return nil
+}
@@ -0,0 +1,188 @@
+package httpx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFastCGITransport(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	go fcgi.Serve(lstn, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		env := fcgi.ProcessEnv(req)
+		body, _ := ioutil.ReadAll(req.Body)
+
+		if req.URL.Path == "/missing.php" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("X-Script", env["SCRIPT_FILENAME"]+"|"+env["PATH_TRANSLATED"])
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s?%s %s %s", req.Method, req.URL.Path, req.URL.RawQuery, req.Header.Get("X-Test"), body)
+	}))
+
+	transport := &FastCGITransport{
+		Address:   lstn.Addr().String(),
+		Root:      "/var/www",
+		SplitPath: ".php",
+		Index:     "index.php",
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		script string
+		output string
+	}{
+		{
+			name:   "get",
+			method: "GET",
+			url:    "http://example.com/index.php/users/42?a=1",
+			status: http.StatusCreated,
+			script: "/var/www/index.php|/var/www/users/42",
+			output: "GET /index.php/users/42?a=1 hello ",
+		},
+		{
+			name:   "post",
+			method: "POST",
+			url:    "http://example.com/",
+			body:   "Hello World!",
+			status: http.StatusCreated,
+			script: "/var/www/index.php|",
+			output: "POST /? hello Hello World!",
+		},
+		{
+			name:   "traversal",
+			method: "GET",
+			url:    "http://example.com/../../../etc/x.php/a",
+			status: http.StatusCreated,
+			script: "/var/www/etc/x.php|/var/www/a",
+		},
+		{
+			name:   "extension prefix",
+			method: "GET",
+			url:    "http://example.com/a.phpx/b",
+			status: http.StatusCreated,
+			script: "/var/www/a.phpx/b|",
+		},
+		{
+			name:   "double extension",
+			method: "GET",
+			url:    "http://example.com/a.php.png/b.php/c",
+			status: http.StatusCreated,
+			script: "/var/www/a.php.png/b.php|/var/www/c",
+		},
+		{
+			name:   "not-found",
+			method: "GET",
+			url:    "http://example.com/missing.php",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			req.Header.Set("X-Test", "hello")
+			req.RemoteAddr = "192.168.0.1:56789"
+
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != test.status {
+				t.Error("bad status:", res.StatusCode)
+			}
+
+			if script := res.Header.Get("X-Script"); script != test.script {
+				t.Error("bad script:", script)
+			}
+
+			if test.output != "" && string(b) != test.output {
+				t.Errorf("bad output: %q", b)
+			}
+		})
+	}
+}
+
+func TestFastCGIPaths(t *testing.T) {
+	tests := []struct {
+		path   string
+		script string
+		info   string
+		file   string
+	}{
+		{"/index.php", "/index.php", "", "/var/www/index.php"},
+		{"/index.php/a/b", "/index.php", "/a/b", "/var/www/index.php"},
+		{"/a/../../b.php", "/b.php", "", "/var/www/b.php"},
+		{"../b.php/c", "/b.php", "/c", "/var/www/b.php"},
+		{"/a.phpx/b", "/a.phpx/b", "", "/var/www/a.phpx/b"},
+		{"/a.php.png", "/a.php.png", "", "/var/www/a.php.png"},
+		{"/dir/", "/dir/", "", "/var/www/dir"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			script, info := fcgiSplitPath(fcgiCleanPath(test.path), ".php")
+			if script != test.script || info != test.info {
+				t.Errorf("bad split: %q %q", script, info)
+			}
+			if file, err := fcgiFilename("/var/www", script); err != nil || file != test.file {
+				t.Errorf("bad file: %q (%v)", file, err)
+			}
+		})
+	}
+
+	if _, err := fcgiFilename("/var/www", "../etc/passwd"); err == nil {
+		t.Error("no error returned for a path outside of the document root")
+	}
+}
+
+func TestFastCGITransportProxy(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	go fcgi.Serve(lstn, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "Hello %s!", req.RemoteAddr)
+	}))
+
+	server := httptest.NewServer(&ReverseProxy{
+		Transport: &FastCGITransport{Address: lstn.Addr().String()},
+	})
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if host, _, _ := net.SplitHostPort(strings.TrimSuffix(strings.TrimPrefix(string(b), "Hello "), "!")); host != "127.0.0.1" {
+		t.Errorf("bad response: %q", b)
+	}
+}
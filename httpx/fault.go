@@ -0,0 +1,124 @@
+package httpx
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FaultHandler is a HTTP handler which injects faults in the requests it
+// passes to its sub-handler, it is intended to be used to test the resilience
+// of programs to latency spikes, network failures, and server errors.
+//
+// Faults are either injected randomly, with the configured percentages of
+// requests, or on demand when the request has the trigger header. The value of
+// the trigger header is a comma-separated list of directives:
+//
+//	delay=<duration>   wait for duration before serving the request
+//	abort              close the connection without sending a response
+//	status=<code>      respond with the status code
+//
+// For example "X-Fault: delay=500ms, status=503".
+type FaultHandler struct {
+	// Handler is the sub-handler that the FaultHandler delegates requests to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+
+	// Delay is the latency added to the percentage of requests set in
+	// DelayPercent.
+	Delay        time.Duration
+	DelayPercent float64
+
+	// AbortPercent is the percentage of requests for which the connection is
+	// closed without sending a response.
+	AbortPercent float64
+
+	// ErrorStatus is the status code returned to the percentage of requests
+	// set in ErrorPercent. Zero means to use 503 Service Unavailable.
+	ErrorStatus  int
+	ErrorPercent float64
+
+	// Header is the name of the trigger header, if empty faults are never
+	// injected on demand. The header is removed from requests passed to the
+	// sub-handler.
+	Header string
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *FaultHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	delay, abort, status := time.Duration(0), false, 0
+
+	if chance(h.DelayPercent) {
+		delay = h.Delay
+	}
+
+	if chance(h.AbortPercent) {
+		abort = true
+	}
+
+	if chance(h.ErrorPercent) {
+		if status = h.ErrorStatus; status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	if len(h.Header) != 0 {
+		if values, ok := req.Header[http.CanonicalHeaderKey(h.Header)]; ok {
+			forEachHeaderValues(values, func(v string) {
+				name, value := v, ""
+				if i := strings.IndexByte(v, '='); i >= 0 {
+					name, value = trimOWS(v[:i]), trimOWS(v[i+1:])
+				}
+				switch name {
+				case "delay":
+					if d, err := time.ParseDuration(value); err == nil {
+						delay = d
+					}
+				case "abort":
+					abort = true
+				case "status":
+					if s, err := strconv.Atoi(value); err == nil && s >= 100 && s <= 999 {
+						status = s
+					}
+				}
+			})
+
+			outreq := req.WithContext(req.Context())
+			outreq.Header = make(http.Header, len(req.Header))
+			copyHeader(outreq.Header, req.Header)
+			outreq.Header.Del(h.Header)
+			req = outreq
+		}
+	}
+
+	if delay > 0 {
+		if sleep(req.Context(), delay) != nil {
+			return
+		}
+	}
+
+	if abort {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	h.Handler.ServeHTTP(w, req)
+}
+
+// chance returns true with a probability of percent/100.
+func chance(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
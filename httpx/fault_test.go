@@ -0,0 +1,90 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFaultHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *FaultHandler
+		header  string
+		status  int
+		delay   time.Duration
+	}{
+		{
+			name:    "none",
+			handler: &FaultHandler{},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "error",
+			handler: &FaultHandler{ErrorPercent: 100},
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "delay",
+			handler: &FaultHandler{Delay: 20 * time.Millisecond, DelayPercent: 100},
+			status:  http.StatusOK,
+			delay:   20 * time.Millisecond,
+		},
+		{
+			name:    "header",
+			handler: &FaultHandler{Header: "X-Fault"},
+			header:  "delay=20ms, status=502",
+			status:  http.StatusBadGateway,
+			delay:   20 * time.Millisecond,
+		},
+		{
+			name:    "header:ignored",
+			handler: &FaultHandler{},
+			header:  "status=502",
+			status:  http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.handler.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if _, ok := req.Header["X-Fault"]; ok && len(test.handler.Header) != 0 {
+					t.Error("the trigger header was passed to the sub-handler")
+				}
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if len(test.header) != 0 {
+				req.Header.Set("X-Fault", test.header)
+			}
+			res := httptest.NewRecorder()
+
+			t0 := time.Now()
+			test.handler.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+
+			if elapsed := time.Since(t0); elapsed < test.delay {
+				t.Error("the request was not delayed:", elapsed)
+			}
+		})
+	}
+}
+
+func TestFaultHandlerAbort(t *testing.T) {
+	url, close := listenAndServe(&Server{
+		Handler: &FaultHandler{
+			Handler:      StatusHandler(http.StatusOK),
+			AbortPercent: 100,
+		},
+	})
+	defer close()
+
+	if res, err := http.Get(url); err == nil {
+		res.Body.Close()
+		t.Error("expected an error when the connection is aborted but got", res.Status)
+	}
+}
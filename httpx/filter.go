@@ -0,0 +1,255 @@
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ResponseFilter is used by ReverseProxy to transform the body of responses
+// while they are streamed from backend servers to clients.
+type ResponseFilter struct {
+	// ContentTypes is the list of media types that the filter applies to,
+	// wildcards like "text/*" are supported. If empty, the filter applies to
+	// all responses.
+	ContentTypes []string
+
+	// Filter is called with a response and a reader producing its body, it
+	// returns a reader producing the transformed body. The response header
+	// may be modified as well.
+	//
+	// The reader returned by Filter is read by the proxy until it reaches
+	// EOF or an error occurs, it must not be read after the original body
+	// returned an error.
+	Filter func(res *http.Response, body io.Reader) io.Reader
+}
+
+// Match returns true if the filter applies to responses with the given
+// content type.
+func (f *ResponseFilter) Match(contentType string) bool {
+	if len(f.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	t2, err := ParseMediaType(mediaType)
+	if err != nil {
+		return false
+	}
+
+	for _, s := range f.ContentTypes {
+		if t1, err := ParseMediaType(strings.ToLower(s)); err == nil && t1.Contains(t2) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// URLRewriteFilter returns a response filter which rewrites the URLs starting
+// with from to start with to in HTML and CSS documents, which is useful when
+// proxying legacy applications that generate links to their internal host
+// names.
+//
+// When both from and to are absolute URLs, the scheme-relative form of the
+// URLs (starting with "//") are rewritten as well.
+func URLRewriteFilter(from string, to string) ResponseFilter {
+	old := []string{from}
+	new := []string{to}
+
+	u1, err1 := url.Parse(from)
+	u2, err2 := url.Parse(to)
+
+	if err1 == nil && err2 == nil && len(u1.Host) != 0 && len(u2.Host) != 0 {
+		old = append(old, strings.TrimPrefix(from, u1.Scheme+":"))
+		new = append(new, strings.TrimPrefix(to, u2.Scheme+":"))
+	}
+
+	return ReplaceFilter(old, new, "text/html", "application/xhtml+xml", "text/css")
+}
+
+// ReplaceFilter returns a response filter which replaces each occurrence of
+// old[i] by new[i] in the body of responses with one of the given content
+// types (all responses if contentTypes is empty).
+//
+// When multiple strings match at the same position, the longest is replaced.
+// The function panics if old and new don't have the same length.
+func ReplaceFilter(old []string, new []string, contentTypes ...string) ResponseFilter {
+	if len(old) != len(new) {
+		panic("httpx.ReplaceFilter: old and new must have the same length")
+	}
+
+	r := &replacer{
+		old: make([][]byte, 0, len(old)),
+		new: make([][]byte, 0, len(new)),
+	}
+
+	for i := range old {
+		if len(old[i]) == 0 {
+			continue
+		}
+		r.old = append(r.old, []byte(old[i]))
+		r.new = append(r.new, []byte(new[i]))
+		if len(old[i]) > r.max {
+			r.max = len(old[i])
+		}
+	}
+
+	return ResponseFilter{
+		ContentTypes: contentTypes,
+		Filter: func(res *http.Response, body io.Reader) io.Reader {
+			return &replaceReader{r: body, replacer: r}
+		},
+	}
+}
+
+// filterResponse applies the response filters of the proxy to res, the
+// function replaces the response body and removes the Content-Length header
+// if at least one filter was applied.
+//
+// Responses with a Content-Encoding are not filtered since the filters only
+// operate on plain bodies.
+func (p *ReverseProxy) filterResponse(res *http.Response) {
+	if len(p.ResponseFilters) == 0 || !bodyAllowed(res) {
+		return
+	}
+
+	if coding := res.Header.Get("Content-Encoding"); len(coding) != 0 && !strings.EqualFold(coding, "identity") {
+		return
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	body := io.Reader(res.Body)
+	filtered := false
+
+	for i := range p.ResponseFilters {
+		if f := &p.ResponseFilters[i]; f.Match(contentType) {
+			body, filtered = f.Filter(res, body), true
+		}
+	}
+
+	if filtered {
+		res.Body = &filteredBody{Reader: body, Closer: res.Body}
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+	}
+}
+
+// bodyAllowed returns true if res may have a body.
+func bodyAllowed(res *http.Response) bool {
+	if res.Request != nil && res.Request.Method == http.MethodHead {
+		return false
+	}
+	switch status := res.StatusCode; {
+	case status < 200, status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+type filteredBody struct {
+	io.Reader
+	io.Closer
+}
+
+// replacer holds the strings replaced by a replaceReader.
+type replacer struct {
+	old [][]byte
+	new [][]byte
+	max int
+}
+
+// index returns the position of the first match in b, and the index of the
+// matching string, or -1 if nothing matched.
+func (r *replacer) index(b []byte) (pos int, match int) {
+	pos, match = -1, -1
+
+	for i, s := range r.old {
+		if j := bytes.Index(b, s); j >= 0 && (pos < 0 || j < pos || (j == pos && len(s) > len(r.old[match]))) {
+			pos, match = j, i
+		}
+	}
+
+	return
+}
+
+// replaceReader is an io.Reader which replaces strings in the bytes it reads
+// from a sub-reader, holding back enough bytes to detect matches spanning
+// multiple reads.
+type replaceReader struct {
+	r   io.Reader
+	in  []byte
+	out []byte
+	off int
+	err error
+	*replacer
+}
+
+func (r *replaceReader) Read(b []byte) (n int, err error) {
+	for r.off == len(r.out) {
+		if r.err != nil && len(r.in) == 0 {
+			return 0, r.err
+		}
+
+		r.out, r.off = r.out[:0], 0
+
+		if r.err == nil {
+			r.fill()
+		}
+
+		r.process(r.err != nil)
+	}
+
+	n = copy(b, r.out[r.off:])
+	r.off += n
+	return
+}
+
+func (r *replaceReader) fill() {
+	const minRead = 4096
+
+	if cap(r.in)-len(r.in) < minRead {
+		in := make([]byte, len(r.in), 2*cap(r.in)+minRead)
+		copy(in, r.in)
+		r.in = in
+	}
+
+	n, err := r.r.Read(r.in[len(r.in):cap(r.in)])
+	r.in = r.in[:len(r.in)+n]
+	r.err = err
+}
+
+func (r *replaceReader) process(final bool) {
+	limit := len(r.in)
+
+	if !final && r.max > 1 {
+		if limit -= r.max - 1; limit < 0 {
+			limit = 0
+		}
+	}
+
+	i := 0
+
+	for i < limit {
+		pos, match := r.index(r.in[i:])
+
+		if pos < 0 || i+pos >= limit {
+			r.out = append(r.out, r.in[i:limit]...)
+			i = limit
+			break
+		}
+
+		r.out = append(r.out, r.in[i:i+pos]...)
+		r.out = append(r.out, r.new[match]...)
+		i += pos + len(r.old[match])
+	}
+
+	r.in = r.in[:copy(r.in, r.in[i:])]
+}
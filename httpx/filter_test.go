@@ -0,0 +1,116 @@
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestResponseFilterMatch(t *testing.T) {
+	tests := []struct {
+		types       []string
+		contentType string
+		match       bool
+	}{
+		{nil, "", true},
+		{nil, "text/html", true},
+		{[]string{"text/html"}, "text/html; charset=utf-8", true},
+		{[]string{"text/html"}, "TEXT/HTML", true},
+		{[]string{"text/*"}, "text/css", true},
+		{[]string{"text/html"}, "text/css", false},
+		{[]string{"text/html"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			f := &ResponseFilter{ContentTypes: test.types}
+
+			if match := f.Match(test.contentType); match != test.match {
+				t.Errorf("%v: expected %t but got %t", test.types, test.match, match)
+			}
+		})
+	}
+}
+
+func TestReplaceFilter(t *testing.T) {
+	tests := []struct {
+		old []string
+		new []string
+		in  string
+		out string
+	}{
+		{
+			old: []string{"abc"},
+			new: []string{"X"},
+			in:  "abcabc-ab-abc",
+			out: "XX-ab-X",
+		},
+		{
+			old: []string{"a", "abc"},
+			new: []string{"1", "3"},
+			in:  "abcab",
+			out: "31b",
+		},
+		{
+			old: []string{"hello"},
+			new: []string{""},
+			in:  "hello",
+			out: "",
+		},
+		{
+			old: []string{"hello"},
+			new: []string{"world"},
+			in:  "hell",
+			out: "hell",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			f := ReplaceFilter(test.old, test.new)
+
+			// Reading one byte at a time ensures that the matches spanning
+			// multiple reads are detected.
+			r := f.Filter(nil, iotest.OneByteReader(strings.NewReader(test.in)))
+
+			b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+			if err != nil {
+				t.Error(err)
+			}
+
+			if s := string(b); s != test.out {
+				t.Errorf("expected %q but got %q", test.out, s)
+			}
+		})
+	}
+}
+
+func TestProxyResponseFilters(t *testing.T) {
+	const page = `<a href="http://backend.local:8080/a">A</a><img src="//backend.local:8080/b.png">`
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer origin.Close()
+
+	req := httptest.NewRequest("GET", origin.URL, nil)
+	res := httptest.NewRecorder()
+
+	(&ReverseProxy{
+		ResponseFilters: []ResponseFilter{
+			URLRewriteFilter("http://backend.local:8080", "https://www.example.com"),
+		},
+	}).ServeHTTP(res, req)
+
+	if s := res.Body.String(); s != `<a href="https://www.example.com/a">A</a><img src="//www.example.com/b.png">` {
+		t.Errorf("bad response body: %q", s)
+	}
+
+	if s := res.Header().Get("Content-Length"); len(s) != 0 {
+		t.Errorf("the Content-Length header was not removed: %q", s)
+	}
+}
@@ -0,0 +1,330 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// ForwardProxy is a HTTP handler which implements the logic of an explicit
+// forward HTTP proxy, serving requests from clients that were configured to
+// use a proxy (browsers, or programs honoring the HTTP_PROXY environment
+// variable for example).
+//
+// Unlike ReverseProxy, the forward proxy only accepts requests in the
+// absolute-form (with the full URL in the request line), or CONNECT requests in
+// the authority-form, and rejects any other request with 400 Bad Request.
+//
+// Forwarding of the requests is delegated to a ReverseProxy, so all the
+// features it supports (Forwarded and Via headers, protocol upgrades, CONNECT
+// tunnels, etc...) are available as well.
+type ForwardProxy struct {
+	// Transport is used to forward HTTP requests to backend servers. If nil a
+	// default transport is used, which goes through Upstream if it is set.
+	Transport http.RoundTripper
+
+	// DialContext is used for dialing new TCP connections on HTTP upgrades or
+	// CONNECT requests. When Upstream is set the connections are established to
+	// the upstream proxy instead.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// TLSClientConfig specifies the TLS configuration to use for HTTP upgrades
+	// that happen over a secured link, and for connections to Upstream when it
+	// uses the https scheme.
+	// If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// Upstream may be set to the URL of another HTTP proxy that the forward
+	// proxy will chain requests to. User information set on the URL is sent in
+	// the Proxy-Authorization header.
+	Upstream *url.URL
+
+	// Authenticate is called by the proxy on every request to verify the
+	// client's credentials, it returns the name of the user making the request
+	// or an error if the request must be rejected with 407 Proxy Authentication
+	// Required.
+	//
+	// If nil, no authentication is done.
+	Authenticate func(*http.Request) (user string, err error)
+
+	// Realm is the realm reported in the Proxy-Authenticate header of the
+	// responses sent when authentication fails.
+	Realm string
+
+	// Intercept enables terminating the TLS connections tunneled by CONNECT
+	// requests, using certificates minted by the certificate authority. The
+	// HTTP requests received on those connections are then forwarded like any
+	// other request, letting the proxy apply its HTTP-level features to HTTPS
+	// traffic.
+	//
+	// Clients must trust the certificate authority for this to work.
+	// If nil, CONNECT requests are served as plain TCP tunnels.
+	Intercept *CertAuthority
+
+	// BufferPool is used to get the buffers for copying response bodies and
+	// tunneled bytes. If nil, the internal buffer pool of netx.Copy is used.
+	BufferPool netx.BufferPool
+
+	// Pseudonym is the name that the proxy uses to identify itself in the Via
+	// header, requests that already went through the proxy are rejected with
+	// 508 Loop Detected. See ReverseProxy.Pseudonym for details.
+	Pseudonym string
+
+	// Log, if not nil, is called after the proxy is done serving each request
+	// with the name of the user that was returned by Authenticate, the request,
+	// and the status code that was sent to the client.
+	Log func(user string, req *http.Request, status int)
+
+	// TunnelLogger, if not nil, receives the accounting of the tunnels
+	// established for CONNECT requests. See ReverseProxy.TunnelLogger for
+	// details.
+	TunnelLogger TunnelLogger
+
+	// TunnelIdleTimeout is the maximum amount of time that the tunnels
+	// established for CONNECT requests may stay idle before they are closed.
+	// Zero means no timeout.
+	TunnelIdleTimeout time.Duration
+
+	// ConnTracker, if not nil, registers the client connections of tunnels
+	// while they are open. See ReverseProxy.ConnTracker for details.
+	ConnTracker *netx.ConnTracker
+
+	// Recorder, if not nil, records the traffic of the client connections of
+	// tunnels. See ReverseProxy.Recorder for details.
+	Recorder *netx.Recorder
+
+	// DestinationPolicy, if not nil, is applied to the connections that the
+	// proxy establishes to the destinations of requests. See
+	// ReverseProxy.DestinationPolicy for details.
+	//
+	// When Upstream is set, the policy is applied to the tunnels of CONNECT
+	// requests and protocol upgrades, which are established to the resolved
+	// addresses through the upstream proxy, other requests are checked before
+	// being forwarded to the upstream proxy, which resolves their destination
+	// again.
+	DestinationPolicy *netx.DestinationPolicy
+
+	once    sync.Once
+	reverse ReverseProxy
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (p *ForwardProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.once.Do(p.init)
+
+	res := &proxyResponseWriter{ResponseWriter: w}
+	user := ""
+
+	defer func() {
+		if p.Log != nil {
+			p.Log(user, req, res.status)
+		}
+	}()
+
+	if !isForwardRequest(req) {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if p.Authenticate != nil {
+		var err error
+
+		if user, err = p.Authenticate(req); err != nil {
+			realm := p.Realm
+			if len(realm) == 0 {
+				realm = "proxy"
+			}
+			res.Header().Set("Proxy-Authenticate", "Basic realm="+quoted(realm).String())
+			res.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		req = p.withUser(req, user)
+	}
+
+	if req.Method == http.MethodConnect && p.Intercept != nil {
+		p.serveIntercept(res, req, user)
+		return
+	}
+
+	p.reverse.ServeHTTP(res, req)
+}
+
+func (p *ForwardProxy) withUser(req *http.Request, user string) *http.Request {
+	if len(user) == 0 {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), proxyUserContextKey{}, user))
+}
+
+func (p *ForwardProxy) init() {
+	p.reverse = ReverseProxy{
+		Transport:         p.Transport,
+		DialContext:       p.DialContext,
+		TLSClientConfig:   p.TLSClientConfig,
+		BufferPool:        p.BufferPool,
+		Pseudonym:         p.Pseudonym,
+		TunnelLogger:      p.TunnelLogger,
+		TunnelIdleTimeout: p.TunnelIdleTimeout,
+		ConnTracker:       p.ConnTracker,
+		Recorder:          p.Recorder,
+		DestinationPolicy: p.DestinationPolicy,
+	}
+
+	if upstream := p.Upstream; upstream != nil {
+		dial := p.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+		}
+
+		if p.reverse.Transport == nil {
+			p.reverse.Transport = &http.Transport{
+				Proxy:               http.ProxyURL(upstream),
+				DialContext:         dial,
+				TLSClientConfig:     p.TLSClientConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+			}
+		}
+
+		p.reverse.DialContext = (&ProxyDialer{
+			URL:             upstream,
+			TLSClientConfig: p.TLSClientConfig,
+			Dial:            dial,
+		}).DialContext
+	}
+}
+
+// ProxyUser returns the name of the user that was authenticated by a
+// ForwardProxy for the request that ctx belongs to.
+func ProxyUser(ctx context.Context) string {
+	user, _ := ctx.Value(proxyUserContextKey{}).(string)
+	return user
+}
+
+type proxyUserContextKey struct{}
+
+// ProxyBasicAuth returns the username and password provided in the request's
+// Proxy-Authorization header, if the request uses HTTP Basic Authentication.
+//
+// The function is the equivalent of (*http.Request).BasicAuth for proxies.
+func ProxyBasicAuth(req *http.Request) (username string, password string, ok bool) {
+	return parseBasicAuth(req.Header.Get("Proxy-Authorization"))
+}
+
+// parseBasicAuth parses a HTTP Basic Authentication string.
+func parseBasicAuth(auth string) (username string, password string, ok bool) {
+	const prefix = "Basic "
+
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return
+	}
+
+	b, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return
+	}
+
+	s := string(b)
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return
+	}
+
+	username, password, ok = s[:i], s[i+1:], true
+	return
+}
+
+// isForwardRequest checks whether req is a valid request for a forward proxy.
+func isForwardRequest(req *http.Request) bool {
+	if len(req.URL.Host) == 0 {
+		return false
+	}
+	if req.Method == http.MethodConnect {
+		return true
+	}
+	return req.URL.IsAbs()
+}
+
+// bufferedConn is a net.Conn which reads from a buffered reader before reading
+// from the connection itself, it is used when some bytes have been buffered
+// after reading the response to a CONNECT request.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *bufferedConn) WriteTo(w io.Writer) (n int64, err error) {
+	// Once the buffered bytes have been written the copy is delegated to the
+	// connection, which lets netx.Copy use its optimized code paths.
+	if k := c.r.Buffered(); k != 0 {
+		b, _ := c.r.Peek(k)
+		k, err = w.Write(b)
+		c.r.Discard(k)
+		n = int64(k)
+		if err != nil {
+			return
+		}
+	}
+	k, err := netx.Copy(w, c.Conn)
+	n += k
+	return
+}
+
+// proxyResponseWriter is a http.ResponseWriter wrapper which captures the
+// status code of the final response sent to the client, and supports hijacking
+// the connection.
+type proxyResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader satisfies the http.ResponseWriter interface.
+func (w *proxyResponseWriter) WriteHeader(status int) {
+	if w.status == 0 && !isInformational(status) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write satisfies the io.Writer and http.ResponseWriter interfaces.
+func (w *proxyResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush satisfies the http.Flusher interface.
+func (w *proxyResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack satisfies the http.Hijacker interface.
+func (w *proxyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer doesn't support hijacking")
+	}
+	return h.Hijack()
+}
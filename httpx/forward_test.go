@@ -0,0 +1,212 @@
+package httpx
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestForwardProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("Hello World!"))
+	}))
+	defer origin.Close()
+
+	originTLS := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("Hello World!"))
+	}))
+	defer originTLS.Close()
+
+	users := make(chan string, 10)
+
+	proxy1, closeProxy1 := listenAndServe(&Server{
+		Handler: &ForwardProxy{
+			Authenticate: func(req *http.Request) (string, error) {
+				if user, pass, ok := ProxyBasicAuth(req); ok && pass == "secret" {
+					return user, nil
+				}
+				return "", errors.New("bad credentials")
+			},
+			Log: func(user string, req *http.Request, status int) {
+				users <- user
+			},
+		},
+	})
+	defer closeProxy1()
+
+	proxy1URL, _ := url.Parse(proxy1)
+	proxy1URL.User = url.UserPassword("luke", "secret")
+
+	proxy2, closeProxy2 := listenAndServe(&Server{
+		Handler: &ForwardProxy{
+			Upstream: proxy1URL,
+		},
+	})
+	defer closeProxy2()
+
+	tests := []struct {
+		name   string
+		proxy  string
+		user   *url.Userinfo
+		target string
+		status int
+	}{
+		{
+			name:   "http:unauthorized",
+			proxy:  proxy1,
+			target: origin.URL,
+			status: http.StatusProxyAuthRequired,
+		},
+		{
+			name:   "http:authorized",
+			proxy:  proxy1,
+			user:   url.UserPassword("luke", "secret"),
+			target: origin.URL,
+			status: http.StatusOK,
+		},
+		{
+			name:   "https:authorized",
+			proxy:  proxy1,
+			user:   url.UserPassword("luke", "secret"),
+			target: originTLS.URL,
+			status: http.StatusOK,
+		},
+		{
+			name:   "http:chained",
+			proxy:  proxy2,
+			target: origin.URL,
+			status: http.StatusOK,
+		},
+		{
+			name:   "https:chained",
+			proxy:  proxy2,
+			target: originTLS.URL,
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for len(users) != 0 {
+				<-users
+			}
+
+			proxyURL, _ := url.Parse(test.proxy)
+			proxyURL.User = test.user
+
+			transport := &http.Transport{
+				Proxy:           http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}
+			defer transport.CloseIdleConnections()
+
+			res, err := (&http.Client{Transport: transport}).Get(test.target)
+			if err != nil {
+				if test.status == http.StatusOK {
+					t.Error(err)
+				}
+				return
+			}
+
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			transport.CloseIdleConnections()
+
+			if res.StatusCode != test.status {
+				t.Errorf("bad status code: expected %d but got %d", test.status, res.StatusCode)
+				return
+			}
+
+			if test.status == http.StatusOK {
+				if s := string(b); s != "Hello World!" {
+					t.Errorf("bad response body: %#v", s)
+				}
+				select {
+				case user := <-users:
+					if user != "luke" {
+						t.Errorf("bad user logged by the proxy: %q", user)
+					}
+				case <-time.After(1 * time.Second):
+					t.Error("no user logged by the proxy")
+				}
+			}
+		})
+	}
+}
+
+func TestForwardProxyRejectsOriginForm(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.URL.Host = ""
+	res := httptest.NewRecorder()
+
+	(&ForwardProxy{}).ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Error("bad status code:", res.Code)
+	}
+}
+
+func TestParseBasicAuth(t *testing.T) {
+	tests := []struct {
+		auth string
+		user string
+		pass string
+		ok   bool
+	}{
+		{"", "", "", false},
+		{"Bearer abc", "", "", false},
+		{"Basic bHVrZTpzZWNyZXQ=", "luke", "secret", true},
+		{"basic bHVrZTpzZWNyZXQ=", "luke", "secret", true},
+		{"Basic bHVrZQ==", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.auth, func(t *testing.T) {
+			user, pass, ok := parseBasicAuth(test.auth)
+			if user != test.user || pass != test.pass || ok != test.ok {
+				t.Errorf("bad basic auth: %q %q %t", user, pass, ok)
+			}
+		})
+	}
+}
+
+func TestForwardProxyLogInformational(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer origin.Close()
+
+	statuses := make(chan int, 1)
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &ForwardProxy{
+			Log: func(user string, req *http.Request, status int) { statuses <- status },
+		},
+	})
+	defer closeProxy()
+
+	proxyURL, _ := url.Parse(proxy)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	defer client.CloseIdleConnections()
+
+	res, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	select {
+	case status := <-statuses:
+		if status != http.StatusNoContent {
+			t.Error("bad status logged by the proxy:", status)
+		}
+	case <-time.After(time.Second):
+		t.Error("the request was not logged")
+	}
+}
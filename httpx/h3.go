@@ -0,0 +1,273 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAltSvcMaxAge is the default amount of time that clients may
+	// remember the alternative services advertised by an AltSvcHandler, and
+	// that a H3Transport remembers the ones advertised by backends.
+	DefaultAltSvcMaxAge = 24 * time.Hour
+
+	// DefaultH3BrokenTimeout is the default amount of time that a H3Transport
+	// stops using HTTP/3 for a backend after a failure.
+	DefaultH3BrokenTimeout = 5 * time.Minute
+)
+
+// AltSvcHandler is a HTTP handler which advertises a HTTP/3 endpoint to clients
+// by setting the Alt-Svc header on the responses of the requests it receives
+// over TLS, letting the clients upgrade to HTTP/3 on their next connections.
+//
+// The package doesn't implement QUIC, the HTTP/3 server must be provided by
+// the program (for example with github.com/quic-go/quic-go/http3), serving
+// the same handler, which may be a ReverseProxy. Set StripAltSvc on the proxy
+// to keep the alternative services of backends from reaching the clients.
+type AltSvcHandler struct {
+	// Handler is called to handle the requests.
+	Handler http.Handler
+
+	// Port is the UDP port that the HTTP/3 server listens on. If zero, the
+	// port that the request was received on is advertised.
+	Port int
+
+	// MaxAge is the amount of time that clients may remember the HTTP/3
+	// endpoint. If zero, DefaultAltSvcMaxAge is used.
+	MaxAge time.Duration
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *AltSvcHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Alternative services may only be advertised by secure origins, and
+	// clients already using HTTP/3 don't need to be told about it.
+	if req.TLS != nil && req.ProtoMajor < 3 {
+		port := h.Port
+
+		if port == 0 {
+			if addr := contextLocalAddr(req.Context()); addr != nil {
+				_, p, _ := net.SplitHostPort(addr.String())
+				port, _ = strconv.Atoi(p)
+			}
+		}
+
+		maxAge := h.MaxAge
+		if maxAge == 0 {
+			maxAge = DefaultAltSvcMaxAge
+		}
+
+		if port != 0 {
+			w.Header().Add("Alt-Svc", `h3=":`+strconv.Itoa(port)+`"; ma=`+strconv.Itoa(int(maxAge/time.Second)))
+		}
+	}
+
+	h.Handler.ServeHTTP(w, req)
+}
+
+// H3Transport is a HTTP transport which sends requests to backends over
+// HTTP/3 when they support it, and falls back to HTTP/2 or HTTP/1.1 when they
+// don't, or when HTTP/3 fails (for example because UDP is blocked on the way
+// to the backend).
+//
+// Backends are discovered to support HTTP/3 by the Alt-Svc header of their
+// responses, like browsers do. A backend for which a HTTP/3 request failed is
+// only reached through the fallback transport for some time, the request is
+// retried on the fallback transport if its body can be sent again.
+//
+// The package doesn't implement QUIC, the HTTP/3 transport must be provided
+// by the program (for example with github.com/quic-go/quic-go/http3).
+type H3Transport struct {
+	// H3 is the transport used to send requests over HTTP/3. If nil, all
+	// requests are sent through the fallback transport.
+	H3 http.RoundTripper
+
+	// Fallback is the transport used for backends that don't support HTTP/3,
+	// or when HTTP/3 failed. If nil, http.DefaultTransport is used.
+	Fallback http.RoundTripper
+
+	// Always enables sending the requests to https backends over HTTP/3 first,
+	// instead of waiting for them to advertise it.
+	Always bool
+
+	// BrokenTimeout is the amount of time that the transport stops using
+	// HTTP/3 for a backend after a failure. If zero, DefaultH3BrokenTimeout is
+	// used.
+	BrokenTimeout time.Duration
+
+	mutex  sync.Mutex
+	alts   map[string]h3Alt     // backend => HTTP/3 endpoint
+	broken map[string]time.Time // backend => expiration time
+}
+
+type h3Alt struct {
+	authority string
+	expires   time.Time
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *H3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fallback := t.Fallback
+	if fallback == nil {
+		fallback = http.DefaultTransport
+	}
+
+	if t.H3 == nil || req.URL.Scheme != "https" {
+		return fallback.RoundTrip(req)
+	}
+
+	backend := canonicalHostPort(req.URL.Host)
+	now := time.Now()
+
+	if authority, ok := t.lookup(backend, now); ok {
+		outurl := *req.URL
+		outurl.Host = authority
+		outreq := *req
+		outreq.URL = &outurl
+		body := &retryRequestBody{ReadCloser: req.Body}
+
+		if len(outreq.Host) == 0 {
+			outreq.Host = req.URL.Host
+		}
+
+		if req.Body != nil {
+			outreq.Body = body
+		}
+
+		res, err := t.H3.RoundTrip(&outreq)
+		if err == nil {
+			t.learn(backend, req.URL.Hostname(), res.Header["Alt-Svc"], now)
+			return res, nil
+		}
+
+		t.markBroken(backend, now)
+
+		if body.n != 0 {
+			if req.GetBody == nil {
+				return nil, err
+			}
+
+			retry := *req
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+			req = &retry
+		}
+	}
+
+	res, err := fallback.RoundTrip(req)
+	if err == nil {
+		t.learn(backend, req.URL.Hostname(), res.Header["Alt-Svc"], now)
+	}
+	return res, err
+}
+
+// lookup returns the HTTP/3 endpoint of backend, and whether the request
+// should be sent to it.
+func (t *H3Transport) lookup(backend string, now time.Time) (string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if expires, ok := t.broken[backend]; ok {
+		if now.Before(expires) {
+			return "", false
+		}
+		delete(t.broken, backend)
+	}
+
+	if alt, ok := t.alts[backend]; ok {
+		if now.Before(alt.expires) {
+			return alt.authority, true
+		}
+		delete(t.alts, backend)
+	}
+
+	return backend, t.Always
+}
+
+func (t *H3Transport) markBroken(backend string, now time.Time) {
+	timeout := t.BrokenTimeout
+	if timeout == 0 {
+		timeout = DefaultH3BrokenTimeout
+	}
+
+	t.mutex.Lock()
+	if t.broken == nil {
+		t.broken = make(map[string]time.Time)
+	}
+	t.broken[backend] = now.Add(timeout)
+	t.mutex.Unlock()
+}
+
+// learn records the HTTP/3 endpoint advertised by the Alt-Svc header values
+// of a response received from backend.
+func (t *H3Transport) learn(backend string, host string, values []string, now time.Time) {
+	if len(values) == 0 {
+		return
+	}
+
+	authority, maxAge, clear := parseAltSvcH3(values)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch {
+	case clear:
+		delete(t.alts, backend)
+
+	case len(authority) != 0:
+		if strings.HasPrefix(authority, ":") {
+			authority = net.JoinHostPort(host, authority[1:])
+		}
+		if t.alts == nil {
+			t.alts = make(map[string]h3Alt)
+		}
+		t.alts[backend] = h3Alt{authority: authority, expires: now.Add(maxAge)}
+	}
+}
+
+// parseAltSvcH3 parses the values of Alt-Svc headers, returning the authority
+// of the first h3 alternative and its max age, or clear set to true if the
+// alternatives were invalidated.
+func parseAltSvcH3(values []string) (authority string, maxAge time.Duration, clear bool) {
+	for _, value := range values {
+		for _, alt := range strings.Split(value, ",") {
+			alt = strings.TrimSpace(alt)
+
+			if alt == "clear" {
+				return "", 0, true
+			}
+
+			params := strings.Split(alt, ";")
+			proto, auth, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+
+			if !ok || proto != "h3" || len(authority) != 0 {
+				continue
+			}
+
+			authority = strings.Trim(auth, `"`)
+			maxAge = DefaultAltSvcMaxAge
+
+			for _, param := range params[1:] {
+				if name, value, _ := strings.Cut(strings.TrimSpace(param), "="); name == "ma" {
+					if seconds, err := strconv.Atoi(value); err == nil {
+						maxAge = time.Duration(seconds) * time.Second
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// canonicalHostPort returns hostport with the default https port added if it
+// had none.
+func canonicalHostPort(hostport string) string {
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return net.JoinHostPort(strings.Trim(hostport, "[]"), "443")
+	}
+	return hostport
+}
@@ -0,0 +1,128 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestH3TransportDefault(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &H3Transport{}
+	})
+}
+
+func TestH3Transport(t *testing.T) {
+	var h3Hosts []string
+	var h3Fail bool
+	var fallbackCalls int
+
+	transport := &H3Transport{
+		H3: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			h3Hosts = append(h3Hosts, req.URL.Host)
+			if h3Fail {
+				if req.Body != nil {
+					ioutil.ReadAll(req.Body)
+				}
+				return nil, errors.New("UDP blocked")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		}),
+		Fallback: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			fallbackCalls++
+			if req.Body != nil {
+				ioutil.ReadAll(req.Body)
+			}
+			header := http.Header{"Alt-Svc": {`h3=":8443"; ma=60, h2=":443"`}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody}, nil
+		}),
+	}
+
+	get := func(body string) error {
+		req, _ := http.NewRequest("POST", "https://backend.local/", strings.NewReader(body))
+		res, err := transport.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	// The first request discovers that the backend supports HTTP/3.
+	if err := get("1"); err != nil || fallbackCalls != 1 || len(h3Hosts) != 0 {
+		t.Fatal("bad first request:", err, fallbackCalls, h3Hosts)
+	}
+
+	// The second request is sent to the advertised endpoint.
+	if err := get("2"); err != nil || fallbackCalls != 1 || len(h3Hosts) != 1 || h3Hosts[0] != "backend.local:8443" {
+		t.Fatal("bad second request:", err, fallbackCalls, h3Hosts)
+	}
+
+	// The third request fails over HTTP/3, it is retried on the fallback
+	// transport since its body can be sent again.
+	h3Fail = true
+	if err := get("3"); err != nil || fallbackCalls != 2 || len(h3Hosts) != 2 {
+		t.Fatal("bad third request:", err, fallbackCalls, h3Hosts)
+	}
+
+	// HTTP/3 is considered broken for the backend.
+	h3Fail = false
+	if err := get("4"); err != nil || fallbackCalls != 3 || len(h3Hosts) != 2 {
+		t.Fatal("bad fourth request:", err, fallbackCalls, h3Hosts)
+	}
+}
+
+func TestParseAltSvcH3(t *testing.T) {
+	tests := []struct {
+		values    []string
+		authority string
+		maxAge    int
+		clear     bool
+	}{
+		{[]string{`h3=":443"`}, ":443", 86400, false},
+		{[]string{`h2=":443", h3="alt.example.com:8443"; ma=60; persist=1`}, "alt.example.com:8443", 60, false},
+		{[]string{`h3-29=":443"`}, "", 0, false},
+		{[]string{`clear`}, "", 0, true},
+	}
+
+	for _, test := range tests {
+		authority, maxAge, clear := parseAltSvcH3(test.values)
+
+		if authority != test.authority || int(maxAge.Seconds()) != test.maxAge || clear != test.clear {
+			t.Errorf("%q: bad result: %q %s %t", test.values, authority, maxAge, clear)
+		}
+	}
+}
+
+func TestAltSvcHandler(t *testing.T) {
+	handler := &AltSvcHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4443}
+	ctx := context.WithValue(context.Background(), http.LocalAddrContextKey, addr)
+
+	req := httptest.NewRequest("GET", "https://localhost:4443/", nil).WithContext(ctx)
+	req.TLS = &tls.ConnectionState{}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if altSvc := res.Header().Get("Alt-Svc"); altSvc != `h3=":4443"; ma=86400` {
+		t.Error("bad Alt-Svc header:", altSvc)
+	}
+
+	req = httptest.NewRequest("GET", "http://localhost:4443/", nil).WithContext(ctx)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if altSvc := res.Header().Get("Alt-Svc"); altSvc != "" {
+		t.Error("unexpected Alt-Svc header on a plain text request:", altSvc)
+	}
+}
@@ -0,0 +1,193 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// HealthCheck is the interface implemented by types which report the health of
+// a component of a program to a HealthHandler.
+type HealthCheck interface {
+	// CheckHealth returns a non-nil error if the component is unhealthy, ctx is
+	// canceled when the check times out.
+	CheckHealth(ctx context.Context) error
+}
+
+// HealthCheckFunc makes it possible to use regular functions as health checks.
+type HealthCheckFunc func(context.Context) error
+
+// CheckHealth calls f.
+func (f HealthCheckFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+// DialHealthCheck returns a health check which succeeds if a TCP connection can
+// be established to address, it is useful to report whether a backend server
+// is reachable.
+func DialHealthCheck(address string) HealthCheck {
+	return HealthCheckFunc(func(ctx context.Context) error {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// HealthHandler is a HTTP handler which serves the liveness and readiness
+// endpoints commonly used by orchestrators to manage the lifecycle of a
+// program.
+//
+// Requests for paths ending with /healthz run the liveness checks, requests
+// for paths ending with /readyz run both the liveness and readiness checks,
+// other paths get 404 Not Found. The handler responds with 200 OK if all
+// checks succeeded or 503 Service Unavailable otherwise, the body is a JSON
+// object detailing the status of each check:
+//
+//	{
+//	  "status": "fail",
+//	  "checks": {
+//	    "backend": { "status": "fail", "error": "dial tcp 10.0.0.1:80: connection refused" },
+//	    "cache": { "status": "ok" }
+//	  }
+//	}
+//
+// It is safe to register new checks while the handler is serving requests.
+type HealthHandler struct {
+	// Timeout is the maximum amount of time that each check can take.
+	// Zero means to use a default timeout of 5 seconds.
+	Timeout time.Duration
+
+	// Logger, if not nil, is used to report the checks that failed.
+	Logger netx.Logger
+
+	mutex     sync.RWMutex
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+}
+
+// HandleLiveness registers check under name for the liveness endpoint.
+func (h *HealthHandler) HandleLiveness(name string, check HealthCheck) {
+	h.mutex.Lock()
+	if h.liveness == nil {
+		h.liveness = make(map[string]HealthCheck)
+	}
+	h.liveness[name] = check
+	h.mutex.Unlock()
+}
+
+// HandleReadiness registers check under name for the readiness endpoint.
+func (h *HealthHandler) HandleReadiness(name string, check HealthCheck) {
+	h.mutex.Lock()
+	if h.readiness == nil {
+		h.readiness = make(map[string]HealthCheck)
+	}
+	h.readiness[name] = check
+	h.mutex.Unlock()
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var checks map[string]HealthCheck
+
+	switch path := req.URL.Path; {
+	case strings.HasSuffix(path, "/healthz"):
+		checks = h.checks(false)
+	case strings.HasSuffix(path, "/readyz"):
+		checks = h.checks(true)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	report := healthReport{
+		Status: "ok",
+		Checks: make(map[string]healthStatus, len(checks)),
+	}
+
+	mutex := sync.Mutex{}
+	join := sync.WaitGroup{}
+
+	for name, check := range checks {
+		join.Add(1)
+		go func(name string, check HealthCheck) {
+			defer join.Done()
+			status := healthStatus{Status: "ok"}
+
+			if err := check.CheckHealth(ctx); err != nil {
+				status = healthStatus{Status: "fail", Error: err.Error()}
+
+				if h.Logger != nil {
+					h.Logger.Warn("health check failed", "check", name, "path", req.URL.Path, "err", err)
+				}
+			}
+
+			mutex.Lock()
+			report.Checks[name] = status
+			mutex.Unlock()
+		}(name, check)
+	}
+
+	join.Wait()
+	status := http.StatusOK
+
+	for _, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status, status = "fail", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	b, _ := json.MarshalIndent(report, "", "  ")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+
+	if req.Method != http.MethodHead {
+		w.Write(append(b, '\n'))
+	}
+}
+
+func (h *HealthHandler) checks(readiness bool) map[string]HealthCheck {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	checks := make(map[string]HealthCheck, len(h.liveness)+len(h.readiness))
+
+	for name, check := range h.liveness {
+		checks[name] = check
+	}
+
+	if readiness {
+		for name, check := range h.readiness {
+			checks[name] = check
+		}
+	}
+
+	return checks
+}
+
+type healthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]healthStatus `json:"checks"`
+}
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,114 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+func TestHealthHandler(t *testing.T) {
+	handler := &HealthHandler{Timeout: 100 * time.Millisecond}
+	ready := errors.New("not ready")
+
+	handler.HandleLiveness("alive", HealthCheckFunc(func(ctx context.Context) error {
+		return nil
+	}))
+	handler.HandleReadiness("ready", HealthCheckFunc(func(ctx context.Context) error {
+		return ready
+	}))
+	handler.HandleReadiness("slow", HealthCheckFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	tests := []struct {
+		path   string
+		status int
+		checks map[string]string
+	}{
+		{
+			path:   "/healthz",
+			status: http.StatusOK,
+			checks: map[string]string{"alive": "ok"},
+		},
+		{
+			path:   "/readyz",
+			status: http.StatusServiceUnavailable,
+			checks: map[string]string{"alive": "ok", "ready": "fail", "slow": "fail"},
+		},
+		{
+			path:   "/other",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.path, nil)
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+
+			if test.checks == nil {
+				return
+			}
+
+			var report healthReport
+
+			if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(report.Checks) != len(test.checks) {
+				t.Error("bad number of checks:", len(report.Checks))
+			}
+
+			for name, status := range test.checks {
+				if s := report.Checks[name].Status; s != status {
+					t.Errorf("bad status for %s: %s", name, s)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthHandlerLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := &HealthHandler{Logger: netx.NewStdLogger(log.New(buf, "", 0))}
+
+	handler.HandleReadiness("db", HealthCheckFunc(func(ctx context.Context) error {
+		return errors.New("not ready")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+
+	if s := buf.String(); s != "[warn] health check failed check=db path=/readyz err=\"not ready\"\n" {
+		t.Error("bad log output:", s)
+	}
+}
+
+func TestDialHealthCheck(t *testing.T) {
+	server := httptest.NewServer(StatusHandler(http.StatusOK))
+	addr := server.Listener.Addr().String()
+
+	if err := DialHealthCheck(addr).CheckHealth(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	server.Close()
+
+	if err := DialHealthCheck(addr).CheckHealth(context.Background()); err == nil {
+		t.Error("expected an error when the server is not reachable")
+	}
+}
@@ -0,0 +1,237 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgeTransport is a http.RoundTripper which sends a second "hedged" request
+// when the first one didn't get a response after some delay, then returns the
+// first successful response and cancels the other request.
+//
+// Hedging requests reduces the tail latency of a service at the cost of some
+// extra load on the backends, so it only applies to GET, HEAD, and OPTIONS
+// requests, which are safe to send more than once. Other requests are passed
+// to the sub-transport unchanged.
+type HedgeTransport struct {
+	// Transport is the sub-transport that the HedgeTransport delegates requests
+	// to.
+	//
+	// http.DefaultTransport is used if Transport is nil.
+	Transport http.RoundTripper
+
+	// Delay is the amount of time that the transport waits for a response
+	// before sending the hedged request. When Percentile is set, Delay is only
+	// used until enough requests were made to compute the latency percentile.
+	//
+	// No requests are hedged if both Delay and Percentile are zero.
+	Delay time.Duration
+
+	// Percentile, if non-zero, configures the transport to compute the hedging
+	// delay from the latency of recent requests, for example setting it to 0.95
+	// hedges the 5% slowest requests.
+	Percentile float64
+
+	// Backends is an optional list of addresses that hedged requests are sent
+	// to instead of the host of the original request, the transport picks the
+	// addresses in a round-robin fashion, skipping the original host.
+	Backends []string
+
+	mutex   sync.Mutex
+	next    int
+	samples []time.Duration
+	index   int
+}
+
+const (
+	// hedgeSamples is the number of latency samples that a HedgeTransport keeps
+	// track of to compute the latency percentile.
+	hedgeSamples = 128
+
+	// hedgeMinSamples is the number of latency samples that a HedgeTransport
+	// must have collected before using the latency percentile.
+	hedgeMinSamples = 16
+)
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *HedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	delay := t.delay()
+
+	if delay == 0 || !isSafe(req.Method) || (req.Body != nil && req.Body != http.NoBody) {
+		return transport.RoundTrip(req)
+	}
+
+	results := make(chan hedgeResult, 2)
+	cancels := make([]context.CancelFunc, 0, 2)
+	pending := 0
+	hedged := false
+
+	send := func(req *http.Request) {
+		ctx, cancel := context.WithCancel(req.Context())
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		pending++
+
+		go func() {
+			start := time.Now()
+			res, err := transport.RoundTrip(req.WithContext(ctx))
+			results <- hedgeResult{res: res, err: err, cancel: cancel, index: index, latency: time.Since(start)}
+		}()
+	}
+
+	send(req)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last hedgeResult
+
+	for pending != 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				send(t.hedge(req))
+			}
+
+		case r := <-results:
+			pending--
+
+			if r.err == nil && r.res.StatusCode < 500 {
+				t.observe(r.latency)
+				for i, cancel := range cancels {
+					if i != r.index {
+						cancel()
+					}
+				}
+				last.discard()
+				t.discard(results, pending)
+				r.res.Body = &cancelBody{ReadCloser: r.res.Body, cancel: r.cancel}
+				return r.res, nil
+			}
+
+			last.discard()
+			last = r
+
+			// The first request failed before the hedging delay expired, there's
+			// no point in waiting to send the hedged request.
+			if !hedged {
+				hedged = true
+				send(t.hedge(req))
+			}
+		}
+	}
+
+	if last.err != nil {
+		last.cancel()
+		return nil, last.err
+	}
+
+	last.res.Body = &cancelBody{ReadCloser: last.res.Body, cancel: last.cancel}
+	return last.res, nil
+}
+
+// delay returns the hedging delay that the transport should use.
+func (t *HedgeTransport) delay() time.Duration {
+	if t.Percentile == 0 {
+		return t.Delay
+	}
+
+	t.mutex.Lock()
+	samples := append(make([]time.Duration, 0, len(t.samples)), t.samples...)
+	t.mutex.Unlock()
+
+	if len(samples) < hedgeMinSamples {
+		return t.Delay
+	}
+
+	sort.Slice(samples, func(i int, j int) bool { return samples[i] < samples[j] })
+	i := int(t.Percentile * float64(len(samples)))
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i]
+}
+
+// observe records the latency of a request which got a successful response,
+// measured from the time it was sent, whether it was hedged or not.
+func (t *HedgeTransport) observe(latency time.Duration) {
+	if t.Percentile == 0 {
+		return
+	}
+
+	t.mutex.Lock()
+
+	if len(t.samples) < hedgeSamples {
+		t.samples = append(t.samples, latency)
+	} else {
+		t.samples[t.index] = latency
+		t.index = (t.index + 1) % hedgeSamples
+	}
+
+	t.mutex.Unlock()
+}
+
+// hedge returns the hedged version of req.
+func (t *HedgeTransport) hedge(req *http.Request) *http.Request {
+	if len(t.Backends) == 0 {
+		return req
+	}
+
+	t.mutex.Lock()
+	backend := t.Backends[t.next%len(t.Backends)]
+	if backend == req.URL.Host && len(t.Backends) > 1 {
+		t.next++
+		backend = t.Backends[t.next%len(t.Backends)]
+	}
+	t.next++
+	t.mutex.Unlock()
+
+	u := *req.URL
+	u.Host = backend
+
+	hedge := req.WithContext(req.Context())
+	hedge.URL = &u
+
+	if len(hedge.Host) == 0 {
+		hedge.Host = req.URL.Host
+	}
+
+	return hedge
+}
+
+// discard releases the results of the n requests still in flight, in a
+// background goroutine.
+func (t *HedgeTransport) discard(results <-chan hedgeResult, n int) {
+	go func() {
+		for i := 0; i != n; i++ {
+			r := <-results
+			r.discard()
+		}
+	}()
+}
+
+type hedgeResult struct {
+	res     *http.Response
+	err     error
+	cancel  context.CancelFunc
+	index   int
+	latency time.Duration
+}
+
+func (r *hedgeResult) discard() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.res != nil {
+		r.res.Body.Close()
+	}
+}
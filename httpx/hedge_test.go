@@ -0,0 +1,143 @@
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestHedgeTransportDefault(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &HedgeTransport{}
+	})
+}
+
+func TestHedgeTransportConfigured(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &HedgeTransport{
+			Delay:      10 * time.Millisecond,
+			Percentile: 0.9,
+		}
+	})
+}
+
+func TestHedgeTransport(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	transport := &HedgeTransport{
+		Delay:    10 * time.Millisecond,
+		Backends: []string{fast.Listener.Addr().String()},
+	}
+
+	req, _ := http.NewRequest("GET", slow.URL, nil)
+	t0 := time.Now()
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if s := string(b); s != "fast" {
+		t.Errorf("bad response body: %q", s)
+	}
+
+	if time.Since(t0) > 1*time.Second {
+		t.Error("the hedged request did not complete before the slow one")
+	}
+}
+
+func TestHedgeTransportNotSafe(t *testing.T) {
+	count := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	res, err := (&HedgeTransport{Delay: 1 * time.Millisecond}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Error("bad number of requests received by the server:", n)
+	}
+}
+
+func TestHedgeTransportPercentile(t *testing.T) {
+	transport := &HedgeTransport{
+		Delay:      time.Second,
+		Percentile: 0.5,
+	}
+
+	if delay := transport.delay(); delay != time.Second {
+		t.Error("bad delay without samples:", delay)
+	}
+
+	for i := 1; i <= 100; i++ {
+		transport.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	if delay := transport.delay(); delay != 51*time.Millisecond {
+		t.Error("bad delay computed from the latency percentile:", delay)
+	}
+}
+
+func TestHedgeTransportObserveHedged(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer fast.Close()
+
+	transport := &HedgeTransport{
+		Delay:      100 * time.Millisecond,
+		Percentile: 0.5,
+		Backends:   []string{fast.Listener.Addr().String()},
+	}
+
+	req, _ := http.NewRequest("GET", slow.URL, nil)
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// The latency of the hedged request is recorded from the time it was
+	// sent, not from the time the original request was.
+	transport.mutex.Lock()
+	samples := transport.samples
+	transport.mutex.Unlock()
+
+	if len(samples) != 1 || samples[0] >= 100*time.Millisecond {
+		t.Error("bad latency samples:", samples)
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -35,7 +39,11 @@ func TestServer(t *testing.T, f MakeServer) {
 		})
 	}
 	run("Basic", testServerBasic)
+	run("Keep-Alive", testServerKeepAlive)
 	run("Transfer-Encoding:chunked", testServerTransferEncodingChunked)
+	run("Trailer", testServerTrailer)
+	run("Expect:100-continue", testServerExpectContinue)
+	run("1xx", testServerInformational)
 	run("ErrBodyNotAllowed", testServerErrBodyNotAllowed)
 	run("ErrContentLength", testServerErrContentLength)
 	run("ReadTimeout", testServerReadTimeout)
@@ -74,6 +82,44 @@ func testServerBasic(t *testing.T, f MakeServer) {
 	}
 }
 
+// test that the server can serve multiple requests over the same connection.
+func testServerKeepAlive(t *testing.T, f MakeServer) {
+	url, close := f(ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(req.URL.Path))
+		}),
+	})
+	defer close()
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+
+	for i, path := range []string{"/0", "/1", "/2"} {
+		var reused bool
+
+		req, _ := http.NewRequest("GET", url+path, nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+		}))
+
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if s := string(b); s != path {
+			t.Errorf("bad response body: %#v", s)
+		}
+		if i != 0 && !reused {
+			t.Error("the connection was not reused")
+		}
+	}
+}
+
 // test that a chunked transfer encoding on the connection works as expected,
 // this is done by sending a huge payload via multiple calls to Write.
 func testServerTransferEncodingChunked(t *testing.T, f MakeServer) {
@@ -113,6 +159,151 @@ func testServerTransferEncodingChunked(t *testing.T, f MakeServer) {
 	}
 }
 
+// test that trailers set by the handler are sent after the response body,
+// whether they were announced in the Trailer header or set with the
+// http.TrailerPrefix.
+func testServerTrailer(t *testing.T, f MakeServer) {
+	url, close := f(ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Trailer", "X-Announced")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Hello World!"))
+			w.Header().Set("X-Announced", "1")
+			w.Header().Set(http.TrailerPrefix+"X-Prefixed", "2")
+		}),
+	})
+	defer close()
+
+	res, err := http.Get(url + "/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if v := res.Trailer.Get("X-Announced"); v != "1" {
+		t.Errorf("bad value of the announced trailer: %#v", v)
+	}
+	if v := res.Trailer.Get("X-Prefixed"); v != "2" {
+		t.Errorf("bad value of the prefixed trailer: %#v", v)
+	}
+	if v := res.Header.Get(http.TrailerPrefix + "X-Prefixed"); len(v) != 0 {
+		t.Errorf("the prefixed trailer was sent in the response header: %#v", v)
+	}
+}
+
+// test that clients sending "Expect: 100-continue" receive the interim response
+// when the handler reads the request body, and don't when the handler rejects
+// the request without reading it.
+func testServerExpectContinue(t *testing.T, f MakeServer) {
+	url, close := f(ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/reject" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			io.Copy(w, req.Body)
+		}),
+	})
+	defer close()
+
+	tests := []struct {
+		path    string
+		status  int
+		interim bool
+	}{
+		{"/accept", http.StatusOK, true},
+		{"/reject", http.StatusUnauthorized, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			var got100 int32
+
+			transport := &http.Transport{ExpectContinueTimeout: 10 * time.Second}
+			defer transport.CloseIdleConnections()
+
+			req, _ := http.NewRequest("POST", url+test.path, strings.NewReader("Hello World!"))
+			req.Header.Set("Expect", "100-continue")
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				Got100Continue: func() { atomic.StoreInt32(&got100, 1) },
+			}))
+
+			t0 := time.Now()
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if res.StatusCode != test.status {
+				t.Error("bad response code:", res.StatusCode)
+			}
+			if (atomic.LoadInt32(&got100) != 0) != test.interim {
+				t.Errorf("bad interim response, expected 100 Continue: %t", test.interim)
+			}
+			if test.interim && string(b) != "Hello World!" {
+				t.Errorf("bad response body: %#v", string(b))
+			}
+			if time.Since(t0) > 5*time.Second {
+				t.Error("the client waited for the expect continue timeout")
+			}
+		})
+	}
+}
+
+// test that interim 1xx responses written by the handler are sent to the client
+// before the final response.
+func testServerInformational(t *testing.T, f MakeServer) {
+	url, close := f(ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.Header().Del("Link")
+			w.Write([]byte("Hello World!"))
+		}),
+	})
+	defer close()
+
+	var status int
+	var link string
+
+	req, _ := http.NewRequest("GET", url+"/", nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			status, link = code, header.Get("Link")
+			return nil
+		},
+	}))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if status != http.StatusEarlyHints {
+		t.Error("bad interim response code:", status)
+	}
+	if link != "</style.css>; rel=preload; as=style" {
+		t.Errorf("bad Link header in the interim response: %#v", link)
+	}
+	if v := res.Header.Get("Link"); len(v) != 0 {
+		t.Errorf("the Link header leaked into the final response: %#v", v)
+	}
+	if res.StatusCode != http.StatusOK || string(b) != "Hello World!" {
+		t.Errorf("bad final response: %d %#v", res.StatusCode, string(b))
+	}
+}
+
 // test that the server's response writer returns http.ErrBodyNotAllowed when
 // the program attempts to write a body on a response that doesn't allow one.
 func testServerErrBodyNotAllowed(t *testing.T, f MakeServer) {
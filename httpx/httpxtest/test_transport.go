@@ -2,9 +2,11 @@ package httpxtest
 
 import (
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -22,6 +24,7 @@ func TestTransport(t *testing.T, f MakeTransport) {
 		})
 	}
 	run("Basic", testTransportHEAD)
+	run("Keep-Alive", testTransportKeepAlive)
 }
 
 func testTransportHEAD(t *testing.T, f MakeTransport) {
@@ -116,3 +119,49 @@ func testTransportHEAD(t *testing.T, f MakeTransport) {
 		})
 	}
 }
+
+func testTransportKeepAlive(t *testing.T, f MakeTransport) {
+	conns := int32(0)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.Path))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	transport := f()
+
+	for _, path := range []string{"/A", "/B", "/C"} {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if s := string(b); s != path {
+			t.Errorf("bad body received by the client, expected %#v but got %#v", path, s)
+		}
+	}
+
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("the transport opened %d connections instead of reusing a single one", n)
+	}
+}
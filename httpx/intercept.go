@@ -0,0 +1,273 @@
+package httpx
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertAuthority is used by proxies intercepting TLS connections to mint leaf
+// certificates on the fly, signed by a configurable certificate authority.
+//
+// Certificates are cached in memory and reused until they expire, the least
+// recently used ones are evicted when the cache is full. It is safe to use a
+// CertAuthority from multiple goroutines.
+type CertAuthority struct {
+	// CacheSize is the maximum number of certificates kept in memory. If
+	// zero, DefaultCertCacheSize is used. The field must not be modified
+	// after the first call to Certificate.
+	CacheSize int
+
+	cert     tls.Certificate
+	leaf     *x509.Certificate
+	validity time.Duration
+
+	mutex sync.Mutex
+	cache map[string]*list.Element // values are *certCacheEntry
+	lru   list.List                // most recently used first
+}
+
+// certCacheEntry is an entry of the certificate cache of a CertAuthority.
+type certCacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+const (
+	// DefaultCertValidity is the default validity period of certificates
+	// minted by a CertAuthority.
+	DefaultCertValidity = 24 * time.Hour
+
+	// DefaultCertCacheSize is the default number of certificates cached by a
+	// CertAuthority.
+	DefaultCertCacheSize = 1000
+)
+
+// NewCertAuthority creates a new certificate authority from cert, which must
+// be a CA certificate with its private key.
+func NewCertAuthority(cert tls.Certificate) (*CertAuthority, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("the certificate authority has no certificate")
+	}
+
+	if cert.PrivateKey == nil {
+		return nil, errors.New("the certificate authority has no private key")
+	}
+
+	leaf := cert.Leaf
+
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	if !leaf.IsCA {
+		return nil, errors.New("the certificate of the certificate authority is not a CA certificate")
+	}
+
+	return &CertAuthority{
+		cert:     cert,
+		leaf:     leaf,
+		validity: DefaultCertValidity,
+		cache:    make(map[string]*list.Element),
+	}, nil
+}
+
+// GetCertificate satisfies the signature of the tls.Config.GetCertificate
+// field, returning a certificate for the server name of the ClientHello.
+func (ca *CertAuthority) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return ca.Certificate(hello.ServerName)
+}
+
+// Certificate returns a certificate for host, which may be a host name or an
+// IP address.
+func (ca *CertAuthority) Certificate(host string) (*tls.Certificate, error) {
+	if len(host) == 0 {
+		return nil, errors.New("cannot mint a certificate for an empty host name")
+	}
+	host = strings.ToLower(host)
+	now := time.Now()
+
+	if cert := ca.lookup(host, now); cert != nil {
+		return cert, nil
+	}
+
+	cert, err := ca.mint(host, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.store(host, cert, now)
+	return cert, nil
+}
+
+// lookup returns the cached certificate for host, or nil if there are none or
+// it expired.
+func (ca *CertAuthority) lookup(host string, now time.Time) *tls.Certificate {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	elem := ca.cache[host]
+	if elem == nil {
+		return nil
+	}
+
+	entry := elem.Value.(*certCacheEntry)
+	if !now.Before(entry.cert.Leaf.NotAfter) {
+		ca.lru.Remove(elem)
+		delete(ca.cache, host)
+		return nil
+	}
+
+	ca.lru.MoveToFront(elem)
+	return entry.cert
+}
+
+// store adds cert to the cache, evicting the expired certificates and the
+// least recently used ones above the cache size.
+func (ca *CertAuthority) store(host string, cert *tls.Certificate, now time.Time) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if elem := ca.cache[host]; elem != nil {
+		// Another goroutine minted a certificate for the same host.
+		elem.Value.(*certCacheEntry).cert = cert
+		ca.lru.MoveToFront(elem)
+	} else {
+		ca.cache[host] = ca.lru.PushFront(&certCacheEntry{host: host, cert: cert})
+	}
+
+	size := ca.CacheSize
+	if size <= 0 {
+		size = DefaultCertCacheSize
+	}
+
+	for elem := ca.lru.Back(); elem != nil; elem = ca.lru.Back() {
+		entry := elem.Value.(*certCacheEntry)
+		if ca.lru.Len() <= size && now.Before(entry.cert.Leaf.NotAfter) {
+			break
+		}
+		ca.lru.Remove(elem)
+		delete(ca.cache, entry.host)
+	}
+}
+
+func (ca *CertAuthority) mint(host string, now time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-1 * time.Hour), // tolerate clock skews
+		NotAfter:     now.Add(ca.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.leaf, &key.PublicKey, ca.cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Certificate[0]},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// serveIntercept terminates the TLS connection tunneled by a CONNECT request
+// using a certificate minted by the proxy's certificate authority, then serves
+// the HTTP requests received on the connection as if they had been sent to the
+// proxy directly.
+func (p *ForwardProxy) serveIntercept(w http.ResponseWriter, req *http.Request, user string) {
+	io.Copy(ioutil.Discard, req.Body)
+	req.Body.Close()
+	w.WriteHeader(http.StatusOK)
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	if err := rw.Writer.Flush(); err != nil {
+		return // the client is gone
+	}
+
+	if rw.Reader.Buffered() != 0 {
+		conn = &bufferedConn{Conn: conn, r: rw.Reader}
+	}
+
+	target := req.URL.Host
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if len(hello.ServerName) != 0 {
+				return p.Intercept.Certificate(hello.ServerName)
+			}
+			return p.Intercept.Certificate(host)
+		},
+	})
+	defer tlsConn.Close()
+
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		res := &proxyResponseWriter{ResponseWriter: w}
+
+		if p.Log != nil {
+			defer func() { p.Log(user, req, res.status) }()
+		}
+
+		req.URL.Scheme = "https"
+		req.URL.Host = target
+		p.reverse.ServeHTTP(res, p.withUser(req, user))
+	})
+
+	(&Server{
+		Handler:  handler,
+		Upgrader: handler,
+	}).ServeConn(req.Context(), tlsConn)
+}
@@ -0,0 +1,162 @@
+package httpx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCertAuthority(t *testing.T) {
+	ca := newTestCertAuthority(t)
+
+	c1, err := ca.Certificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := ca.Certificate("EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c1 != c2 {
+		t.Error("certificates minted for the same host were not cached")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.leaf)
+
+	if _, err := c1.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: pool}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCertAuthorityCache(t *testing.T) {
+	ca := newTestCertAuthority(t)
+	ca.CacheSize = 2
+
+	a, _ := ca.Certificate("a.example.com")
+	b, _ := ca.Certificate("b.example.com")
+
+	if x, _ := ca.Certificate("a.example.com"); x != a {
+		t.Error("the certificate of a.example.com was not cached")
+	}
+
+	// b.example.com is the least recently used host, its certificate is
+	// evicted to make room for c.example.com.
+	c, _ := ca.Certificate("c.example.com")
+
+	if n := len(ca.cache); n != 2 {
+		t.Error("bad number of cached certificates:", n)
+	}
+	if x, _ := ca.Certificate("b.example.com"); x == b {
+		t.Error("the least recently used certificate was not evicted")
+	}
+
+	// Expired certificates are evicted when they are looked up.
+	c.Leaf.NotAfter = time.Now().Add(-time.Second)
+
+	if x, _ := ca.Certificate("c.example.com"); x == c {
+		t.Error("an expired certificate was returned from the cache")
+	}
+}
+
+func TestNewCertAuthorityNotCA(t *testing.T) {
+	cert := newTestCertificate(t, false)
+
+	if _, err := NewCertAuthority(cert); err == nil {
+		t.Error("expected an error when creating a certificate authority from a leaf certificate")
+	}
+}
+
+func TestForwardProxyIntercept(t *testing.T) {
+	ca := newTestCertAuthority(t)
+
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The Via header is only added if the proxy sees the HTTP request,
+		// which proves that the TLS connection was intercepted.
+		w.Write([]byte(req.Header.Get("Via")))
+	}))
+	defer origin.Close()
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &ForwardProxy{
+			Intercept: ca,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	})
+	defer closeProxy()
+
+	proxyURL, _ := url.Parse(proxy)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.leaf)
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}
+	defer transport.CloseIdleConnections()
+
+	res, err := (&http.Client{Transport: transport}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Error("bad status code:", res.StatusCode)
+	}
+
+	if len(b) == 0 {
+		t.Error("the request was not intercepted by the proxy")
+	}
+}
+
+func newTestCertAuthority(t *testing.T) *CertAuthority {
+	ca, err := NewCertAuthority(newTestCertificate(t, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func newTestCertificate(t *testing.T, isCA bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "netx test"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
@@ -0,0 +1,537 @@
+package httpx
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for crypto.SHA256.New
+	_ "crypto/sha512" // register SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTKeyFunc is the signature of functions returning the key used to verify
+// the signature of JSON Web Tokens signed with alg, and identified by kid (both
+// values come from the JOSE header of the token).
+//
+// The key must be a []byte for HMAC algorithms (HS256, HS384, HS512), a
+// *rsa.PublicKey for RSA algorithms (RS256, RS384, RS512), or a
+// *ecdsa.PublicKey for ECDSA algorithms (ES256, ES384, ES512).
+type JWTKeyFunc func(alg string, kid string) (interface{}, error)
+
+// StaticJWTKey returns a JWTKeyFunc which always returns key.
+func StaticJWTKey(key interface{}) JWTKeyFunc {
+	return func(string, string) (interface{}, error) { return key, nil }
+}
+
+// JWTHandler is a HTTP handler which validates the JSON Web Token sent by
+// clients in the Authorization header of their requests (with the Bearer
+// scheme) before passing them to its sub-handler. Requests with a missing or
+// invalid token are rejected with 401 Unauthorized. Tokens must have an
+// expiration time (exp claim), the ones which never expire are rejected.
+//
+// The claims of the token are available to the sub-handler by calling
+// JWTClaims on the request context.
+type JWTHandler struct {
+	// Handler is the sub-handler that the JWTHandler delegates requests with a
+	// valid token to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+
+	// Key is called to get the key that the token signatures are verified
+	// with, the Key method of a JWKS can be used to fetch keys from a JSON
+	// Web Key Set.
+	//
+	// ServeHTTP will panic if Key is nil.
+	Key JWTKeyFunc
+
+	// If not empty, tokens must have been issued by Issuer, and for Audience.
+	Issuer   string
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking the expiration and
+	// activation times of tokens.
+	Leeway time.Duration
+
+	// ForwardClaims maps the names of claims to the headers that their values
+	// are set to on the requests passed to the sub-handler, which lets a proxy
+	// forward them to backend servers. Headers with these names sent by the
+	// clients are always removed.
+	ForwardClaims map[string]string
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *JWTHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token, ok := parseBearerAuth(req.Header.Get("Authorization"))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := VerifyJWT(token, h.Key)
+
+	if err == nil {
+		err = h.validate(claims, time.Now())
+	}
+
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+quoted(err.Error()).String())
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), jwtClaimsContextKey{}, claims))
+
+	if len(h.ForwardClaims) != 0 {
+		header := make(http.Header, len(req.Header)+len(h.ForwardClaims))
+		copyHeader(header, req.Header)
+
+		for claim, name := range h.ForwardClaims {
+			header.Del(name)
+
+			if value, ok := claims[claim]; ok {
+				header.Set(name, claimString(value))
+			}
+		}
+
+		req.Header = header
+	}
+
+	h.Handler.ServeHTTP(w, req)
+}
+
+func (h *JWTHandler) validate(claims map[string]interface{}, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("the token has no expiration time")
+	}
+
+	if now.After(unixTime(exp).Add(h.Leeway)) {
+		return errors.New("the token is expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(h.Leeway).Before(unixTime(nbf)) {
+		return errors.New("the token is not valid yet")
+	}
+
+	if len(h.Issuer) != 0 {
+		if iss, _ := claims["iss"].(string); iss != h.Issuer {
+			return errors.New("the token was not issued by " + h.Issuer)
+		}
+	}
+
+	if len(h.Audience) != 0 && !claimContains(claims["aud"], h.Audience) {
+		return errors.New("the token is not intended for " + h.Audience)
+	}
+
+	return nil
+}
+
+// JWTClaims returns the claims of the JSON Web Token that was validated by a
+// JWTHandler for the request that ctx belongs to.
+func JWTClaims(ctx context.Context) map[string]interface{} {
+	claims, _ := ctx.Value(jwtClaimsContextKey{}).(map[string]interface{})
+	return claims
+}
+
+type jwtClaimsContextKey struct{}
+
+// VerifyJWT verifies the signature of the JSON Web Token in its compact
+// serialization, using the key returned by the key function, and returns the
+// claims of the token.
+//
+// The function only verifies the signature, validating the claims (expiration
+// time, issuer, etc...) is left to the caller.
+func VerifyJWT(token string, key JWTKeyFunc) (claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = errors.New("malformed token")
+		return
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err = decodeJWTPart(parts[0], &header); err != nil {
+		return
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		err = errors.New("malformed token signature")
+		return
+	}
+
+	k, err := key(header.Alg, header.Kid)
+	if err != nil {
+		return
+	}
+
+	if err = verifyJWTSignature(header.Alg, k, parts[0]+"."+parts[1], signature); err != nil {
+		return
+	}
+
+	err = decodeJWTPart(parts[1], &claims)
+	return
+}
+
+func decodeJWTPart(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errors.New("malformed token: " + err.Error())
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed token: " + err.Error())
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key interface{}, input string, signature []byte) error {
+	var hash crypto.Hash
+
+	if len(alg) == 5 {
+		switch alg[2:] {
+		case "256":
+			hash = crypto.SHA256
+		case "384":
+			hash = crypto.SHA384
+		case "512":
+			hash = crypto.SHA512
+		}
+	}
+
+	if hash == 0 {
+		return errors.New("unsupported token signing algorithm: " + alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+	invalid := errors.New("invalid token signature")
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			break
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(input))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid
+		}
+		return nil
+
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		if rsa.VerifyPKCS1v15(pub, hash, digest, signature) != nil {
+			return invalid
+		}
+		return nil
+
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return invalid
+		}
+		return nil
+
+	default:
+		return errors.New("unsupported token signing algorithm: " + alg)
+	}
+
+	return fmt.Errorf("the key of type %T cannot verify tokens signed with %s", key, alg)
+}
+
+// JWKS is a JSON Web Key Set fetched from a URL, typically the jwks_uri of an
+// OpenID Connect provider.
+//
+// The keys are cached in memory and fetched again after RefreshInterval, or
+// when a token references a key which isn't in the set (keys rotation), but
+// not more than once per minute. After a failed fetch, the key set isn't
+// fetched again for ten seconds, the cached keys remain in use meanwhile.
+//
+// Only asymmetric keys (RSA and EC) are loaded from the key set, symmetric
+// keys must not be published and are ignored.
+type JWKS struct {
+	// URL is where the key set is fetched from.
+	URL string
+
+	// Client is used to fetch the key set. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// RefreshInterval is how long the keys are cached for.
+	// Zero means to use a default value of one hour.
+	RefreshInterval time.Duration
+
+	// Timeout is the maximum amount of time that fetching the key set can
+	// take. Zero means to use a default value of ten seconds.
+	Timeout time.Duration
+
+	mutex    sync.Mutex
+	keys     map[string]interface{}
+	fetched  time.Time
+	failed   time.Time
+	err      error
+	fetching *jwksFetch
+}
+
+const (
+	// DefaultJWKSTimeout is the default value of the Timeout field of JWKS.
+	DefaultJWKSTimeout = 10 * time.Second
+
+	// jwksRetryInterval is how long JWKS waits after a failed fetch before
+	// trying again.
+	jwksRetryInterval = 10 * time.Second
+)
+
+// jwksFetch represents a fetch of the key set in progress, which the callers
+// of JWKS.Key needing the new keys wait for.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// Key satisfies the JWTKeyFunc signature.
+//
+// The key set is fetched without holding the lock, by a single goroutine at a
+// time. Callers which find the key in the cache while it is being refreshed
+// use the cached key instead of waiting for the fetch to complete.
+func (s *JWKS) Key(alg string, kid string) (interface{}, error) {
+	refresh := s.RefreshInterval
+	if refresh == 0 {
+		refresh = 1 * time.Hour
+	}
+
+	s.mutex.Lock()
+	now := time.Now()
+	key, ok := s.keys[kid]
+
+	if s.keys != nil && now.Sub(s.fetched) <= refresh && (ok || now.Sub(s.fetched) <= time.Minute) {
+		s.mutex.Unlock()
+		return jwksKey(key, ok, kid)
+	}
+
+	call := s.fetching
+
+	if call == nil && now.Sub(s.failed) < jwksRetryInterval {
+		// The last fetch failed, don't hammer the endpoint and return the
+		// cached key or the error of the fetch instead.
+		err, noKeys := s.err, s.keys == nil
+		s.mutex.Unlock()
+
+		if noKeys {
+			return nil, err
+		}
+
+		return jwksKey(key, ok, kid)
+	}
+
+	switch {
+	case call == nil:
+		call = &jwksFetch{done: make(chan struct{})}
+		s.fetching = call
+		s.mutex.Unlock()
+
+		keys, err := s.fetch()
+
+		s.mutex.Lock()
+		if err == nil {
+			s.keys, s.fetched, s.failed, s.err = keys, now, time.Time{}, nil
+		} else {
+			s.failed, s.err = time.Now(), err
+		}
+		call.err = err
+		s.fetching = nil
+		close(call.done)
+
+	case ok:
+		s.mutex.Unlock()
+		return key, nil
+
+	default:
+		s.mutex.Unlock()
+		<-call.done
+		s.mutex.Lock()
+	}
+
+	key, ok = s.keys[kid]
+	noKeys := s.keys == nil
+	s.mutex.Unlock()
+
+	if noKeys {
+		return nil, call.err
+	}
+
+	return jwksKey(key, ok, kid)
+}
+
+func jwksKey(key interface{}, ok bool, kid string) (interface{}, error) {
+	if !ok {
+		return nil, errors.New("unknown token signing key: " + kid)
+	}
+	return key, nil
+}
+
+func (s *JWKS) fetch() (keys map[string]interface{}, err error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultJWKSTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("GET %s: %s", s.URL, res.Status)
+		return
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return
+	}
+
+	keys = make(map[string]interface{}, len(set.Keys))
+
+	for _, k := range set.Keys {
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return
+}
+
+// jwk is the representation of a JSON Web Key (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := decodeJWKInt(k.N)
+		e, err2 := decodeJWKInt(k.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() {
+			return nil, errors.New("malformed RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported elliptic curve: " + k.Crv)
+		}
+		x, err1 := decodeJWKInt(k.X)
+		y, err2 := decodeJWKInt(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("malformed EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("unsupported key type: " + k.Kty)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// unixTime converts a NumericDate claim to a time value.
+func unixTime(t float64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// claimContains checks whether claim, which may be a string or a list of
+// strings, contains s.
+func claimContains(claim interface{}, s string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == s
+	case []interface{}:
+		for _, item := range v {
+			if item == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString returns the representation of a claim value in a header.
+func claimString(claim interface{}) string {
+	switch v := claim.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(claim)
+	return string(b)
+}
@@ -0,0 +1,385 @@
+package httpx
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	claims := map[string]interface{}{"sub": "luke"}
+
+	tests := []struct {
+		name  string
+		token string
+		key   interface{}
+		ok    bool
+	}{
+		{
+			name:  "HS256",
+			token: makeTestJWT(t, "HS256", "", claims, secret),
+			key:   secret,
+			ok:    true,
+		},
+		{
+			name:  "HS256:bad-secret",
+			token: makeTestJWT(t, "HS256", "", claims, secret),
+			key:   []byte("other"),
+		},
+		{
+			name:  "RS256",
+			token: makeTestJWT(t, "RS256", "", claims, rsaKey),
+			key:   &rsaKey.PublicKey,
+			ok:    true,
+		},
+		{
+			name:  "ES256",
+			token: makeTestJWT(t, "ES256", "", claims, ecKey),
+			key:   &ecKey.PublicKey,
+			ok:    true,
+		},
+		{
+			name:  "ES256:wrong-key-type",
+			token: makeTestJWT(t, "ES256", "", claims, ecKey),
+			key:   &rsaKey.PublicKey,
+		},
+		{
+			name:  "none",
+			token: makeTestJWT(t, "none", "", claims, nil),
+			key:   secret,
+		},
+		{
+			name:  "malformed",
+			token: "a.b",
+			key:   secret,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := VerifyJWT(test.token, StaticJWTKey(test.key))
+
+			if test.ok {
+				if err != nil {
+					t.Error(err)
+				} else if c["sub"] != "luke" {
+					t.Error("bad claims:", c)
+				}
+			} else if err == nil {
+				t.Error("expected an error but the token was verified")
+			}
+		})
+	}
+}
+
+func TestJWTHandler(t *testing.T) {
+	secret := []byte("secret")
+	now := float64(time.Now().Unix())
+
+	handler := &JWTHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(JWTClaims(req.Context())["sub"].(string) + " " + req.Header.Get("X-User") + " " + req.Header.Get("X-Admin")))
+		}),
+		Key:           StaticJWTKey(secret),
+		Issuer:        "netx",
+		Audience:      "api",
+		ForwardClaims: map[string]string{"sub": "X-User", "admin": "X-Admin"},
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		status int
+		body   string
+	}{
+		{
+			name:   "valid",
+			claims: map[string]interface{}{"sub": "luke", "iss": "netx", "aud": []string{"web", "api"}, "exp": now + 60},
+			status: http.StatusOK,
+			body:   "luke luke ",
+		},
+		{
+			name:   "admin",
+			claims: map[string]interface{}{"sub": "leia", "iss": "netx", "aud": "api", "admin": true, "exp": now + 60},
+			status: http.StatusOK,
+			body:   "leia leia true",
+		},
+		{
+			name:   "expired",
+			claims: map[string]interface{}{"sub": "luke", "iss": "netx", "aud": "api", "exp": now - 60},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "no-expiration",
+			claims: map[string]interface{}{"sub": "luke", "iss": "netx", "aud": "api"},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "not-before",
+			claims: map[string]interface{}{"sub": "luke", "iss": "netx", "aud": "api", "nbf": now + 60, "exp": now + 60},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "bad-issuer",
+			claims: map[string]interface{}{"sub": "luke", "iss": "other", "aud": "api", "exp": now + 60},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "bad-audience",
+			claims: map[string]interface{}{"sub": "luke", "iss": "netx", "aud": "web", "exp": now + 60},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+makeTestJWT(t, "HS256", "", test.claims, secret))
+			req.Header.Set("X-Admin", "spoofed")
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+
+			if test.status == http.StatusOK {
+				if s := res.Body.String(); s != test.body {
+					t.Errorf("bad response body: %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fetches := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "key-1",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	jwks := &JWKS{URL: server.URL}
+	token := makeTestJWT(t, "ES256", "key-1", map[string]interface{}{"sub": "luke"}, key)
+
+	for i := 0; i != 2; i++ {
+		if _, err := VerifyJWT(token, jwks.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fetches != 1 {
+		t.Error("the key set was not cached:", fetches, "fetches")
+	}
+
+	if _, err := jwks.Key("ES256", "key-2"); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestJWKSConcurrentFetch(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	release := make(chan struct{})
+	fetches := int32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&fetches, 1) != 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "key-1",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	jwks := &JWKS{URL: server.URL}
+
+	if _, err := jwks.Key("ES256", "key-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second fetch, triggered by an unknown key, blocks until released.
+	jwks.mutex.Lock()
+	jwks.fetched = jwks.fetched.Add(-2 * time.Minute)
+	jwks.mutex.Unlock()
+
+	errs := make(chan error, 4)
+	for i := 0; i != cap(errs); i++ {
+		go func() {
+			_, err := jwks.Key("ES256", "key-2")
+			errs <- err
+		}()
+	}
+
+	// The keys in the cache remain available while they are being fetched.
+	done := make(chan error)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := jwks.Key("ES256", "key-1")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the cached key was not returned while the key set was fetched")
+	}
+
+	close(release)
+
+	for i := 0; i != cap(errs); i++ {
+		if err := <-errs; err == nil {
+			t.Error("expected an error for an unknown key")
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Error("bad number of fetches:", n)
+	}
+}
+
+func TestJWKSSymmetricKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "oct",
+				"kid": "key-1",
+				"k":   base64.RawURLEncoding.EncodeToString([]byte("secret")),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	jwks := &JWKS{URL: server.URL}
+	token := makeTestJWT(t, "HS256", "key-1", map[string]interface{}{"sub": "luke"}, []byte("secret"))
+
+	if _, err := VerifyJWT(token, jwks.Key); err == nil {
+		t.Error("a token was verified with a symmetric key of the key set")
+	}
+}
+
+func TestJWKSFetchError(t *testing.T) {
+	fetches := int32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	jwks := &JWKS{URL: server.URL}
+
+	for i := 0; i != 3; i++ {
+		if _, err := jwks.Key("ES256", "key-1"); err == nil {
+			t.Fatal("expected an error when the key set cannot be fetched")
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Error("the key set was fetched again right after a failure:", n, "fetches")
+	}
+
+	// The fetch is retried after the backoff.
+	jwks.mutex.Lock()
+	jwks.failed = jwks.failed.Add(-jwksRetryInterval)
+	jwks.mutex.Unlock()
+
+	jwks.Key("ES256", "key-1")
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Error("the key set was not fetched again after the backoff:", n, "fetches")
+	}
+}
+
+func TestJWKSTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	jwks := &JWKS{URL: server.URL, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+
+	if _, err := jwks.Key("ES256", "key-1"); err == nil {
+		t.Error("expected an error when fetching the key set times out")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("fetching the key set did not time out:", elapsed)
+	}
+}
+
+func makeTestJWT(t *testing.T, alg string, kid string, claims map[string]interface{}, key interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(padBigInt(r, 32), padBigInt(s, 32)...)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func padBigInt(i *big.Int, size int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
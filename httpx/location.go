@@ -0,0 +1,55 @@
+package httpx
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/segmentio/netx"
+)
+
+// rewriteLocation rewrites the Location header of redirects sent by backend
+// servers so it points at the address that the client sent its request to
+// instead of the backend address in outreq.
+func (p *ReverseProxy) rewriteLocation(res *http.Response, req *http.Request, outreq *http.Request) {
+	if !p.RewriteLocation || len(req.Host) == 0 || res.StatusCode < 300 || res.StatusCode >= 400 {
+		return
+	}
+
+	location := res.Header.Get("Location")
+	if len(location) == 0 {
+		return
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	if u.IsAbs() || len(u.Host) != 0 {
+		if !strings.EqualFold(u.Host, outreq.URL.Host) {
+			return // redirect to another site
+		}
+		if len(u.Scheme) != 0 {
+			u.Scheme = requestScheme(req)
+		}
+		u.Host = req.Host
+	}
+
+	if path, ok := rewritePathPrefix(p.LocationPaths, u.Path); ok {
+		u.Path, u.RawPath = path, ""
+	}
+
+	res.Header.Set("Location", u.String())
+}
+
+// requestScheme returns the scheme that the client used to send req.
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	if scheme, _ := netx.SplitNetAddr(requestLocalAddr(req)); scheme == "tls" {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,72 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyRewriteLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		location string
+		result   string
+	}{
+		{
+			name:     "backend",
+			status:   http.StatusFound,
+			location: "http://{backend}/login?next=%2F",
+			result:   "http://www.example.com/app/login?next=%2F",
+		},
+		{
+			name:     "path",
+			status:   http.StatusMovedPermanently,
+			location: "/login",
+			result:   "/app/login",
+		},
+		{
+			name:     "other",
+			status:   http.StatusFound,
+			location: "https://auth.example.com/login",
+			result:   "https://auth.example.com/login",
+		},
+		{
+			name:     "not-redirect",
+			status:   http.StatusCreated,
+			location: "http://{backend}/users/1",
+			result:   "http://{backend}/users/1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var backend string
+
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Location", replaceBackend(test.location, backend))
+				w.WriteHeader(test.status)
+			}))
+			defer origin.Close()
+			backend = origin.Listener.Addr().String()
+
+			req := httptest.NewRequest("GET", origin.URL, nil)
+			req.Host = "www.example.com"
+			res := httptest.NewRecorder()
+
+			(&ReverseProxy{
+				RewriteLocation: true,
+				LocationPaths:   map[string]string{"/": "/app/"},
+			}).ServeHTTP(res, req)
+
+			if s := res.Header().Get("Location"); s != replaceBackend(test.result, backend) {
+				t.Errorf("bad location: %q", s)
+			}
+		})
+	}
+}
+
+func replaceBackend(s string, backend string) string {
+	return strings.Replace(s, "{backend}", backend, -1)
+}
@@ -0,0 +1,246 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// RoundTripInfo carries information about a request sent by a LogTransport.
+type RoundTripInfo struct {
+	// Method and URL of the request.
+	Method string
+	URL    *url.URL
+
+	// Status code of the response, zero if the request failed.
+	Status int
+
+	// Duration is the time it took to receive the response header.
+	Duration time.Duration
+
+	// Err is the error returned by the transport, nil on success.
+	Err error
+
+	// Retries is the number of times the request was retried, it is only known
+	// when the logging transport wraps a RetryTransport.
+	Retries int
+}
+
+// String returns a human-readable representation of info.
+func (info RoundTripInfo) String() string {
+	s := fmt.Sprintf("%s %s", info.Method, info.URL)
+
+	if info.Err != nil {
+		s += " error: " + info.Err.Error()
+	} else {
+		s += fmt.Sprintf(" %d", info.Status)
+	}
+
+	s += " " + info.Duration.String()
+
+	if info.Retries != 0 {
+		s += fmt.Sprintf(" (%d retries)", info.Retries)
+	}
+
+	return s
+}
+
+// RoundTripLogger is the interface implemented by types that receive the logs
+// of a LogTransport.
+type RoundTripLogger interface {
+	LogRoundTrip(RoundTripInfo)
+}
+
+// RoundTripLoggerFunc makes it possible to use regular functions as loggers for
+// a LogTransport.
+type RoundTripLoggerFunc func(RoundTripInfo)
+
+// LogRoundTrip calls f.
+func (f RoundTripLoggerFunc) LogRoundTrip(info RoundTripInfo) {
+	f(info)
+}
+
+// NewRoundTripLogger returns a RoundTripLogger which outputs one line to l for
+// each request.
+func NewRoundTripLogger(l *log.Logger) RoundTripLogger {
+	return RoundTripLoggerFunc(func(info RoundTripInfo) {
+		l.Print(info.String())
+	})
+}
+
+// LogTransport is a http.RoundTripper which logs the requests it sends through
+// its sub-transport.
+type LogTransport struct {
+	// Transport is the sub-transport that the LogTransport delegates requests
+	// to.
+	//
+	// http.DefaultTransport is used if Transport is nil.
+	Transport http.RoundTripper
+
+	// Logger receives the logs of the transport. If nil, the standard logger of
+	// the log package is used.
+	Logger RoundTripLogger
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *LogTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	logger := t.Logger
+	if logger == nil {
+		logger = defaultRoundTripLogger
+	}
+
+	retries := new(int32)
+	start := time.Now()
+
+	res, err = transport.RoundTrip(req.WithContext(context.WithValue(req.Context(), retriesContextKey{}, retries)))
+
+	info := RoundTripInfo{
+		Method:   req.Method,
+		URL:      req.URL,
+		Duration: time.Since(start),
+		Err:      err,
+		Retries:  int(atomic.LoadInt32(retries)),
+	}
+
+	if res != nil {
+		info.Status = res.StatusCode
+	}
+
+	logger.LogRoundTrip(info)
+	return
+}
+
+var defaultRoundTripLogger = RoundTripLoggerFunc(func(info RoundTripInfo) {
+	log.Print(info.String())
+})
+
+// TunnelCause represents the reason why a tunnel was closed.
+type TunnelCause string
+
+const (
+	// TunnelClosed means that both ends closed their connection.
+	TunnelClosed TunnelCause = "closed"
+
+	// TunnelIdleTimeout means that no bytes were transferred for longer than
+	// the idle timeout of the proxy.
+	TunnelIdleTimeout TunnelCause = "idle-timeout"
+
+	// TunnelCanceled means that the context of the request was canceled, for
+	// example because the server was shut down.
+	TunnelCanceled TunnelCause = "canceled"
+
+	// TunnelAborted means that one of the connections was closed by the
+	// program, for example with netx.ConnTracker.CloseConn.
+	TunnelAborted TunnelCause = "aborted"
+
+	// TunnelError means that reading or writing one of the connections failed.
+	TunnelError TunnelCause = "error"
+)
+
+// TunnelInfo carries information about a tunnel established by a proxy for a
+// CONNECT request or a protocol upgrade, reported when the tunnel is closed.
+type TunnelInfo struct {
+	// Method and target host of the request that established the tunnel.
+	Method string
+	Host   string
+
+	// Upgrade is the protocol that the connection was upgraded to, it is
+	// empty for CONNECT tunnels.
+	Upgrade string
+
+	// Client is the network address of the client.
+	Client string
+
+	// BytesUp is the number of bytes sent by the client to the target, and
+	// BytesDown the number of bytes sent by the target to the client.
+	BytesUp   int64
+	BytesDown int64
+
+	// Duration is the amount of time that the tunnel stayed open.
+	Duration time.Duration
+
+	// Cause is the reason why the tunnel was closed, and Err the error which
+	// caused it, if any.
+	Cause TunnelCause
+	Err   error
+}
+
+// String returns a human-readable representation of info.
+func (info TunnelInfo) String() string {
+	s := fmt.Sprintf("%s %s", info.Method, info.Host)
+
+	if len(info.Upgrade) != 0 {
+		s += " (" + info.Upgrade + ")"
+	}
+
+	s += fmt.Sprintf(" from %s up=%d down=%d %s %s", info.Client, info.BytesUp, info.BytesDown, info.Duration, info.Cause)
+
+	if info.Err != nil {
+		s += ": " + info.Err.Error()
+	}
+
+	return s
+}
+
+// TunnelLogger is the interface implemented by types that receive the
+// accounting of the tunnels established by proxies.
+type TunnelLogger interface {
+	LogTunnel(TunnelInfo)
+}
+
+// TunnelLoggerFunc makes it possible to use regular functions as tunnel
+// loggers.
+type TunnelLoggerFunc func(TunnelInfo)
+
+// LogTunnel calls f.
+func (f TunnelLoggerFunc) LogTunnel(info TunnelInfo) {
+	f(info)
+}
+
+// NewTunnelLogger returns a TunnelLogger which outputs one line to l for each
+// tunnel.
+func NewTunnelLogger(l *log.Logger) TunnelLogger {
+	return TunnelLoggerFunc(func(info TunnelInfo) {
+		l.Print(info.String())
+	})
+}
+
+// tunnelCause returns the cause of the termination of a tunnel which ended with
+// err, as returned by netx.Bridge.
+func tunnelCause(err error) TunnelCause {
+	switch {
+	case err == nil:
+		return TunnelClosed
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return TunnelCanceled
+	case netx.IsTimeout(err):
+		return TunnelIdleTimeout
+	case errors.Is(err, net.ErrClosed):
+		return TunnelAborted
+	default:
+		return TunnelError
+	}
+}
+
+type retriesContextKey struct{}
+
+// countRetry increments the retry counter which may have been set on ctx by a
+// LogTransport.
+func countRetry(ctx context.Context) {
+	if retries, _ := ctx.Value(retriesContextKey{}).(*int32); retries != nil {
+		atomic.AddInt32(retries, 1)
+	}
+}
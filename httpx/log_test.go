@@ -0,0 +1,142 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestLogTransportDefault(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &LogTransport{
+			Logger: RoundTripLoggerFunc(func(RoundTripInfo) {}),
+		}
+	})
+}
+
+func TestLogTransport(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	var logs []RoundTripInfo
+
+	transport := &LogTransport{
+		Transport: &RetryTransport{},
+		Logger: RoundTripLoggerFunc(func(info RoundTripInfo) {
+			logs = append(logs, info)
+		}),
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/hello", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if len(logs) != 1 {
+		t.Fatal("bad number of logs:", len(logs))
+	}
+
+	info := logs[0]
+
+	if info.Method != "GET" || info.URL.Path != "/hello" {
+		t.Error("bad request logged:", info.Method, info.URL)
+	}
+
+	if info.Status != http.StatusOK {
+		t.Error("bad status logged:", info.Status)
+	}
+
+	if info.Retries != 1 {
+		t.Error("bad number of retries logged:", info.Retries)
+	}
+
+	if info.Err != nil {
+		t.Error("unexpected error logged:", info.Err)
+	}
+}
+
+func TestRoundTripInfoString(t *testing.T) {
+	u, _ := url.Parse("http://localhost/hello")
+
+	tests := []struct {
+		info RoundTripInfo
+		str  string
+	}{
+		{
+			info: RoundTripInfo{Method: "GET", URL: u, Status: 200, Duration: time.Second},
+			str:  "GET http://localhost/hello 200 1s",
+		},
+		{
+			info: RoundTripInfo{Method: "GET", URL: u, Err: errors.New("oops"), Duration: time.Second, Retries: 2},
+			str:  "GET http://localhost/hello error: oops 1s (2 retries)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.str, func(t *testing.T) {
+			if s := test.info.String(); s != test.str {
+				t.Error(s)
+			}
+		})
+	}
+}
+
+func TestTunnelInfoString(t *testing.T) {
+	tests := []struct {
+		info TunnelInfo
+		str  string
+	}{
+		{
+			info: TunnelInfo{Method: "CONNECT", Host: "localhost:443", Client: "127.0.0.1:1234", BytesUp: 10, BytesDown: 20, Duration: time.Second, Cause: TunnelClosed},
+			str:  "CONNECT localhost:443 from 127.0.0.1:1234 up=10 down=20 1s closed",
+		},
+		{
+			info: TunnelInfo{Method: "GET", Host: "localhost", Upgrade: "websocket", Client: "127.0.0.1:1234", Duration: time.Second, Cause: TunnelError, Err: errors.New("oops")},
+			str:  "GET localhost (websocket) from 127.0.0.1:1234 up=0 down=0 1s error: oops",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.str, func(t *testing.T) {
+			if s := test.info.String(); s != test.str {
+				t.Error(s)
+			}
+		})
+	}
+}
+
+func TestTunnelCause(t *testing.T) {
+	tests := []struct {
+		err   error
+		cause TunnelCause
+	}{
+		{nil, TunnelClosed},
+		{context.Canceled, TunnelCanceled},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, TunnelIdleTimeout},
+		{&net.OpError{Op: "read", Err: net.ErrClosed}, TunnelAborted},
+		{errors.New("connection reset by peer"), TunnelError},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.cause), func(t *testing.T) {
+			if cause := tunnelCause(test.err); cause != test.cause {
+				t.Error(cause)
+			}
+		})
+	}
+}
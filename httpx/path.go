@@ -0,0 +1,154 @@
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errEncodedNUL    = errors.New("the path contains an encoded NUL byte")
+	errInvalidEscape = errors.New("the path contains an invalid percent-escape")
+)
+
+// NormalizePath normalizes the escaped URL path p, as returned by
+// url.URL.EscapedPath, so servers which interpret paths differently agree on
+// the resource that a request targets:
+//
+//   - the percent-escapes of unreserved characters (letters, digits, '-', '.',
+//     '_', and '~') are decoded, and the other ones are upper-cased,
+//   - the "." and ".." segments are removed, as described by RFC 3986 section
+//     5.2.4, after decoding so "%2e%2e" segments are removed as well.
+//
+// Escaped slashes are preserved, and so are consecutive slashes. The function
+// returns an error if p contains an encoded NUL byte or an invalid escape.
+func NormalizePath(p string) (string, error) {
+	if strings.IndexByte(p, '%') < 0 && !strings.Contains(p, "/.") && !strings.HasPrefix(p, ".") {
+		return p, nil // fast path for paths which are already normalized
+	}
+
+	b := make([]byte, 0, len(p))
+
+	for i := 0; i < len(p); i++ {
+		if p[i] != '%' {
+			b = append(b, p[i])
+			continue
+		}
+
+		if i+2 >= len(p) || !isHex(p[i+1]) || !isHex(p[i+2]) {
+			return "", errInvalidEscape
+		}
+
+		switch c := unhex(p[i+1])<<4 | unhex(p[i+2]); {
+		case c == 0:
+			return "", errEncodedNUL
+		case isUnreserved(c):
+			b = append(b, c)
+		default:
+			b = append(b, '%', upperHex[c>>4], upperHex[c&0xf])
+		}
+
+		i += 2
+	}
+
+	return removeDotSegments(string(b)), nil
+}
+
+// NormalizePathHandler is a HTTP handler which normalizes the path of requests
+// with NormalizePath before passing them to its sub-handler. Requests with
+// invalid paths are rejected with 400 Bad Request.
+type NormalizePathHandler struct {
+	// Handler is the sub-handler that the NormalizePathHandler delegates the
+	// requests to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *NormalizePathHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	u := *req.URL
+
+	if err := normalizeURLPath(&u); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r := *req
+	r.URL = &u
+	h.Handler.ServeHTTP(w, &r)
+}
+
+// normalizeURLPath sets the path of u to its normalized form.
+func normalizeURLPath(u *url.URL) error {
+	raw := u.EscapedPath()
+
+	p, err := NormalizePath(raw)
+	if err != nil {
+		return err
+	}
+
+	if p != raw {
+		// The path was validated by NormalizePath so it cannot be invalid.
+		u.Path, _ = url.PathUnescape(p)
+		u.RawPath = p
+	}
+
+	return nil
+}
+
+// removeDotSegments removes the "." and ".." segments from p, preserving the
+// trailing slash of paths ending with one of these segments.
+func removeDotSegments(p string) string {
+	segments := strings.Split(p, "/")
+	result := make([]string, 0, len(segments))
+	root := 0
+
+	if strings.HasPrefix(p, "/") {
+		root = 1 // the empty segment before the leading slash is never removed
+	}
+
+	for i, s := range segments {
+		last := i == len(segments)-1
+
+		switch s {
+		case ".":
+		case "..":
+			if len(result) > root {
+				result = result[:len(result)-1]
+			}
+		default:
+			result = append(result, s)
+			continue
+		}
+
+		if last {
+			result = append(result, "")
+		}
+	}
+
+	return strings.Join(result, "/")
+}
+
+const upperHex = "0123456789ABCDEF"
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	default:
+		return c - '0'
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
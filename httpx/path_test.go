@@ -0,0 +1,83 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		err error
+	}{
+		{"", "", nil},
+		{"/", "/", nil},
+		{"*", "*", nil},
+		{"/a/b/c", "/a/b/c", nil},
+		{"/a/./b", "/a/b", nil},
+		{"/a/../b", "/b", nil},
+		{"/a/b/..", "/a/", nil},
+		{"/a/b/.", "/a/b/", nil},
+		{"/..", "/", nil},
+		{"/../../a", "/a", nil},
+		{"/a//b/", "/a//b/", nil},
+		{"/.well-known/acme", "/.well-known/acme", nil},
+		{"/a/%2e%2e/b", "/b", nil},
+		{"/a/%2E/b", "/a/b", nil},
+		{"/%7euser/%41%62c", "/~user/Abc", nil},
+		{"/a%2fb", "/a%2Fb", nil},
+		{"/a%2f..%2fb", "/a%2F..%2Fb", nil},
+		{"/%e2%82%ac", "/%E2%82%AC", nil},
+		{"a/../b", "b", nil},
+		{"/a%00", "", errEncodedNUL},
+		{"/a%2", "", errInvalidEscape},
+		{"/a%zz", "", errInvalidEscape},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			out, err := NormalizePath(test.in)
+			if err != test.err {
+				t.Errorf("bad error: %v", err)
+			}
+			if out != test.out {
+				t.Errorf("bad path: %q", out)
+			}
+		})
+	}
+}
+
+func TestNormalizePathHandler(t *testing.T) {
+	h := &NormalizePathHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(req.URL.Path + " " + req.URL.EscapedPath()))
+		}),
+	}
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/a/b", http.StatusOK, "/a/b /a/b"},
+		{"/a/%2e%2e/admin", http.StatusOK, "/admin /admin"},
+		{"/%7Euser/a%2fb", http.StatusOK, "/~user/a/b /~user/a%2Fb"},
+		{"/a%00b", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", test.target, nil))
+
+			if w.Code != test.status {
+				t.Error("bad status:", w.Code)
+			}
+			if w.Body.String() != test.body {
+				t.Errorf("bad body: %q", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend represents a backend server of a BackendPool.
+type Backend struct {
+	// Addr is the network address of the backend, in the host:port form.
+	Addr string `json:"addr"`
+
+	// Weight is the share of the requests that the backend receives relative
+	// to the other backends of the pool, backends with a zero weight receive
+	// no requests.
+	Weight int `json:"weight"`
+
+	// Draining is true when the backend was taken out of the pool, it doesn't
+	// receive new requests but the requests in flight are allowed to
+	// complete.
+	Draining bool `json:"draining"`
+}
+
+// BackendStatus is the current state of a backend of a BackendPool.
+type BackendStatus struct {
+	Backend
+
+	// Active is the number of requests in flight to the backend.
+	Active int64 `json:"active"`
+}
+
+var (
+	// ErrNoBackend is returned by BackendPool when it has no backend
+	// available to serve a request.
+	ErrNoBackend = errors.New("no backend available to serve the request")
+
+	// ErrBackendNotFound is returned by BackendPool when updating a backend
+	// which is not part of the pool.
+	ErrBackendNotFound = errors.New("backend not found")
+)
+
+// BackendPool is a http.RoundTripper which balances requests across a pool of
+// backend servers, the host of the request URLs is replaced by the address of
+// the backend selected for each request. Backends are selected in a smooth
+// weighted round-robin fashion, so the requests to a backend are spread over
+// time rather than sent in bursts, unless the pool has a Balancer.
+//
+// It is typically used as the Transport of a ReverseProxy, with its DialContext
+// method establishing the tunnels of protocol upgrades:
+//
+//	pool := httpx.NewBackendPool("10.0.0.1:80", "10.0.0.2:80")
+//	proxy := &httpx.ReverseProxy{Transport: pool, DialContext: pool.DialContext}
+//
+// The backends can be changed while the pool is serving requests, each change
+// is applied atomically. AdminHandler exposes these operations over HTTP.
+type BackendPool struct {
+	// Transport is the sub-transport that the BackendPool delegates requests
+	// to.
+	//
+	// http.DefaultTransport is used if Transport is nil.
+	Transport http.RoundTripper
+
+	// Dial is used by DialContext to establish connections to the backends. If
+	// nil, a net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+
+	// Balancer, if not nil, selects the backends that requests are sent to,
+	// see HashBalancer and EWMABalancer. It is changed with SetBalancer while
+	// the pool is serving requests.
+	Balancer Balancer
+
+	mutex     sync.Mutex
+	backends  []*poolBackend
+	available []*poolBackend  // backends passed to the balancer
+	statuses  []BackendStatus // statuses of the available backends
+}
+
+type poolBackend struct {
+	Backend
+	current int   // smooth weighted round-robin state
+	active  int64 // atomic
+}
+
+// NewBackendPool returns a pool of backends with the given addresses, each
+// with a weight of 1. It panics if an address is empty or repeated.
+func NewBackendPool(addrs ...string) *BackendPool {
+	backends := make([]Backend, len(addrs))
+
+	for i, addr := range addrs {
+		backends[i] = Backend{Addr: addr, Weight: 1}
+	}
+
+	p := &BackendPool{}
+	if err := p.SetBackends(backends); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Backends returns the current state of the backends of the pool.
+func (p *BackendPool) Backends() []BackendStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	backends := make([]BackendStatus, len(p.backends))
+
+	for i, b := range p.backends {
+		backends[i] = BackendStatus{
+			Backend: b.Backend,
+			Active:  atomic.LoadInt64(&b.active),
+		}
+	}
+
+	return backends
+}
+
+// SetBackends replaces the backends of the pool. The requests in flight to the
+// backends which are not part of the new list are allowed to complete.
+func (p *BackendPool) SetBackends(backends []Backend) error {
+	seen := make(map[string]bool, len(backends))
+
+	for _, b := range backends {
+		if err := validateBackend(b); err != nil {
+			return err
+		}
+		if seen[b.Addr] {
+			return fmt.Errorf("duplicate backend address: %s", b.Addr)
+		}
+		seen[b.Addr] = true
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	active := make(map[string]*poolBackend, len(p.backends))
+	for _, b := range p.backends {
+		active[b.Addr] = b
+	}
+
+	list := make([]*poolBackend, len(backends))
+
+	for i, b := range backends {
+		// Backends that remain in the pool keep their state, so the count of
+		// requests in flight stays accurate.
+		if x := active[b.Addr]; x != nil {
+			x.Backend = b
+			list[i] = x
+		} else {
+			list[i] = &poolBackend{Backend: b}
+		}
+	}
+
+	p.backends = list
+	return nil
+}
+
+// SetBalancer changes the balancer of the pool, a nil balancer restores the
+// weighted round-robin selection of backends.
+func (p *BackendPool) SetBalancer(b Balancer) {
+	p.mutex.Lock()
+	p.Balancer = b
+	p.mutex.Unlock()
+}
+
+// Update calls f with the backend of the pool that has the given address, the
+// changes made by f are validated then applied atomically.
+//
+// The address of the backend cannot be changed.
+func (p *BackendPool) Update(addr string, f func(*Backend)) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, b := range p.backends {
+		if b.Addr == addr {
+			x := b.Backend
+			f(&x)
+			x.Addr = addr
+
+			if err := validateBackend(x); err != nil {
+				return err
+			}
+
+			b.Backend = x
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrBackendNotFound, addr)
+}
+
+// SetWeight changes the weight of the backend with the given address.
+func (p *BackendPool) SetWeight(addr string, weight int) error {
+	return p.Update(addr, func(b *Backend) { b.Weight = weight })
+}
+
+// Drain stops sending new requests to the backend with the given address.
+func (p *BackendPool) Drain(addr string) error {
+	return p.Update(addr, func(b *Backend) { b.Draining = true })
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (p *BackendPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := p.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	b, balancer := p.next(req)
+	if b == nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrNoBackend
+	}
+
+	atomic.AddInt64(&b.active, 1)
+
+	r := *req
+	u := *req.URL
+	u.Host = b.Addr
+	r.URL = &u
+
+	start := time.Now()
+	res, err := transport.RoundTrip(&r)
+
+	if o, ok := balancer.(LatencyObserver); ok && req.Context().Err() == nil {
+		o.ObserveLatency(b.Addr, time.Since(start), err)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&b.active, -1)
+		return nil, err
+	}
+
+	res.Body = &poolBody{ReadCloser: res.Body, backend: b}
+	return res, nil
+}
+
+// DialContext connects to a backend of the pool selected like RoundTrip does,
+// the address is ignored. The connection counts as an active request to the
+// backend until it is closed.
+func (p *BackendPool) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := p.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	b, _ := p.next(nil)
+	if b == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ErrNoBackend}
+	}
+
+	conn, err := dial(ctx, network, b.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&b.active, 1)
+	return &poolConn{Conn: conn, backend: b}, nil
+}
+
+// next selects the backend that req is sent to with the balancer of the pool,
+// or the smooth weighted round-robin algorithm of nginx. It also returns the
+// balancer of the pool.
+func (p *BackendPool) next(req *http.Request) (*poolBackend, Balancer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.Balancer != nil {
+		if b := p.balance(req); b != nil {
+			return b, p.Balancer
+		}
+	}
+
+	var best *poolBackend
+	total := 0
+
+	for _, b := range p.backends {
+		if b.Draining || b.Weight == 0 {
+			continue
+		}
+		b.current += b.Weight
+		total += b.Weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+
+	if best != nil {
+		best.current -= total
+	}
+
+	return best, p.Balancer
+}
+
+// balance selects the backend that req is sent to with the balancer of the
+// pool, the pool must be locked.
+func (p *BackendPool) balance(req *http.Request) *poolBackend {
+	p.available, p.statuses = p.available[:0], p.statuses[:0]
+
+	for _, b := range p.backends {
+		if !b.Draining && b.Weight != 0 {
+			p.available = append(p.available, b)
+			p.statuses = append(p.statuses, BackendStatus{Backend: b.Backend, Active: atomic.LoadInt64(&b.active)})
+		}
+	}
+
+	if len(p.available) == 0 {
+		return nil
+	}
+
+	if i := p.Balancer.Select(req, p.statuses); i >= 0 && i < len(p.available) {
+		return p.available[i]
+	}
+
+	return nil
+}
+
+func validateBackend(b Backend) error {
+	if len(b.Addr) == 0 {
+		return errors.New("backend address is empty")
+	}
+	if b.Weight < 0 {
+		return fmt.Errorf("negative weight for backend %s: %d", b.Addr, b.Weight)
+	}
+	return nil
+}
+
+// poolBody wraps the body of responses received from a backend of a pool, it
+// counts the request as complete when the body is closed.
+type poolBody struct {
+	io.ReadCloser
+	backend *poolBackend
+	closed  int32
+}
+
+func (b *poolBody) Close() error {
+	if atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		atomic.AddInt64(&b.backend.active, -1)
+	}
+	return b.ReadCloser.Close()
+}
+
+// poolConn wraps the connections established to a backend of a pool, it counts
+// the connection as an active request until it is closed.
+type poolConn struct {
+	net.Conn
+	backend *poolBackend
+	closed  int32
+}
+
+func (c *poolConn) BaseConn() net.Conn { return c.Conn }
+
+func (c *poolConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(&c.backend.active, -1)
+	}
+	return c.Conn.Close()
+}
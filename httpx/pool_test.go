@@ -0,0 +1,182 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestBackendPoolTransport(t *testing.T) {
+	httpxtest.TestTransport(t, func() http.RoundTripper {
+		return &hostPool{}
+	})
+}
+
+// hostPool is a transport which uses a BackendPool containing the host of the
+// request, used to run the standard transport tests.
+type hostPool struct{}
+
+func (hostPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	return NewBackendPool(req.URL.Host).RoundTrip(req)
+}
+
+func TestBackendPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []Backend
+		picks    string
+	}{
+		{
+			name:     "round-robin",
+			backends: []Backend{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}},
+			picks:    "abcabc",
+		},
+		{
+			name:     "smooth weighted round-robin",
+			backends: []Backend{{Addr: "a", Weight: 5}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}},
+			picks:    "aabacaa",
+		},
+		{
+			name:     "draining and zero weight backends are skipped",
+			backends: []Backend{{Addr: "a", Weight: 1, Draining: true}, {Addr: "b", Weight: 0}, {Addr: "c", Weight: 1}},
+			picks:    "ccc",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			picks := ""
+			pool := &BackendPool{
+				Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					picks += req.URL.Host
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+				}),
+			}
+
+			if err := pool.SetBackends(test.backends); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i != len(test.picks); i++ {
+				req := httptest.NewRequest("GET", "http://localhost/", nil)
+				res, err := pool.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+
+				if req.URL.Host != "localhost" {
+					t.Error("the request was modified:", req.URL.Host)
+				}
+			}
+
+			if picks != test.picks {
+				t.Error("bad backend selection:", picks)
+			}
+		})
+	}
+}
+
+func TestBackendPoolActive(t *testing.T) {
+	pool := NewBackendPool("a", "b")
+	pool.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	res, err := pool.RoundTrip(httptest.NewRequest("GET", "http://localhost/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The state of the backends that remain in the pool is preserved.
+	if err := pool.SetBackends([]Backend{{Addr: "a", Weight: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b := pool.Backends(); len(b) != 1 || b[0].Active != 1 || b[0].Weight != 2 {
+		t.Errorf("bad backends: %+v", b)
+	}
+
+	res.Body.Close()
+	res.Body.Close()
+
+	if b := pool.Backends(); b[0].Active != 0 {
+		t.Errorf("bad backends: %+v", b)
+	}
+}
+
+func TestBackendPoolErrors(t *testing.T) {
+	pool := NewBackendPool("a")
+
+	if err := pool.Drain("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.RoundTrip(httptest.NewRequest("GET", "http://localhost/", nil)); err != ErrNoBackend {
+		t.Error("bad error when all backends are draining:", err)
+	}
+
+	if err := pool.SetWeight("b", 1); !errors.Is(err, ErrBackendNotFound) {
+		t.Error("bad error when updating a missing backend:", err)
+	}
+
+	if err := pool.SetWeight("a", -1); err == nil || !strings.Contains(err.Error(), "negative weight") {
+		t.Error("bad error when setting a negative weight:", err)
+	}
+
+	if err := pool.SetBackends([]Backend{{Addr: "a"}, {Addr: "a"}}); err == nil {
+		t.Error("no error when setting duplicate backends")
+	}
+
+	if b := pool.Backends(); len(b) != 1 || b[0].Weight != 1 || !b[0].Draining {
+		t.Errorf("the pool was modified by invalid changes: %+v", b)
+	}
+}
+
+func TestBackendPoolDialContext(t *testing.T) {
+	dialed := ""
+	pool := NewBackendPool("a", "b")
+	pool.Dial = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialed += address
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+
+	conn, err := pool.DialContext(context.Background(), "tcp", "localhost:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := pool.Backends(); b[0].Active != 1 || b[1].Active != 0 {
+		t.Errorf("bad backends: %+v", b)
+	}
+
+	conn.Close()
+	conn.Close()
+
+	if b := pool.Backends(); b[0].Active != 0 {
+		t.Errorf("bad backends: %+v", b)
+	}
+
+	if conn, err = pool.DialContext(context.Background(), "tcp", "localhost:80"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if dialed != "ab" {
+		t.Error("bad backend selection:", dialed)
+	}
+
+	pool.SetBackends(nil)
+
+	if _, err := pool.DialContext(context.Background(), "tcp", "localhost:80"); !errors.Is(err, ErrNoBackend) {
+		t.Error("bad error when the pool has no backends:", err)
+	}
+}
@@ -3,6 +3,7 @@ package httpx
 import (
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -26,18 +27,72 @@ func protoVersion(req *http.Request) string {
 
 // copyHeader copies the HTTP header src into dst.
 func copyHeader(dst http.Header, src http.Header) {
+	copyHeaderBuffer(dst, src, nil)
+}
+
+// copyHeaderBuffer copies the HTTP header src into dst, the values of all
+// headers are stored in a single slice which reuses the capacity of buf, and
+// is returned so it can be passed to the next call.
+//
+// The slices set in dst have no spare capacity, appending to one of them never
+// overwrites the values of another header.
+func copyHeaderBuffer(dst http.Header, src http.Header, buf []string) []string {
+	n := 0
+	for _, values := range src {
+		n += len(values)
+	}
+
+	if cap(buf) < n {
+		buf = make([]string, 0, n)
+	} else {
+		buf = buf[:0]
+	}
+
 	for name, values := range src {
-		dst[name] = append(make([]string, 0, len(values)), values...)
+		i := len(buf)
+		buf = append(buf, values...)
+		dst[name] = buf[i:len(buf):len(buf)]
+	}
+
+	return buf
+}
+
+// copyTrailer copies the HTTP trailer src into dst, prefixing the keys with
+// http.TrailerPrefix so they get sent as trailers after the response body.
+func copyTrailer(dst http.Header, src http.Header) {
+	for name, values := range src {
+		if len(values) != 0 {
+			dst[http.TrailerPrefix+name] = append(make([]string, 0, len(values)), values...)
+		}
+	}
+}
+
+// trailerKeys returns a comma-separated list of the keys in the HTTP trailer t,
+// sorted to make the output deterministic.
+func trailerKeys(t http.Header) string {
+	keys := make([]string, 0, len(t))
+	for name := range t {
+		keys = append(keys, name)
 	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
 }
 
-// deleteHopFields deletes the hop-by-hop fields from header.
-func deleteHopFields(h http.Header) {
-	forEachHeaderValues(h["Connection"], func(v string) {
-		if v != "close" {
+// DeleteHopFields deletes the hop-by-hop fields from h, which must not be
+// forwarded by proxies.
+//
+// Besides the standard hop-by-hop fields (Connection, Keep-Alive, Upgrade,
+// etc...), the function also removes the fields nominated in the values of the
+// Connection and Proxy-Connection headers, as required by RFC 7230 section 6.1
+// (see https://tools.ietf.org/html/rfc7230#section-6.1).
+func DeleteHopFields(h http.Header) {
+	del := func(v string) {
+		if isToken(v) && !tokenEqual(v, "close") {
 			h.Del(v)
 		}
-	})
+	}
+	forEachHeaderValues(h["Connection"], del)
+	forEachHeaderValues(h["Proxy-Connection"], del)
 	h.Del("Connection")
 	h.Del("Keep-Alive")
 	h.Del("Proxy-Authenticate")
@@ -86,6 +141,41 @@ func translateXForwarded(h http.Header) {
 	h.Del("X-Forwarded-Proto")
 }
 
+// makeXForwarded returns the values of the X-Forwarded-For, X-Forwarded-Proto
+// and X-Forwarded-Host headers for a request forwarded from forAddr, extending
+// the values already present in h.
+func makeXForwarded(h http.Header, proto string, forAddr string, host string) (xFor string, xProto string, xHost string) {
+	if ip, _, err := net.SplitHostPort(forAddr); err == nil {
+		forAddr = ip
+	}
+
+	if xFor = h.Get("X-Forwarded-For"); len(xFor) == 0 {
+		xFor = forAddr
+	} else if len(forAddr) != 0 {
+		xFor += ", " + forAddr
+	}
+
+	if xProto = h.Get("X-Forwarded-Proto"); len(xProto) == 0 {
+		xProto = proto
+	}
+
+	if xHost = h.Get("X-Forwarded-Host"); len(xHost) == 0 {
+		xHost = host
+	}
+
+	return
+}
+
+// deleteForwarded deletes all the forwarding headers from h.
+func deleteForwarded(h http.Header) {
+	h.Del("Forwarded")
+	h.Del("X-Forwarded-For")
+	h.Del("X-Forwarded-By")
+	h.Del("X-Forwarded-Host")
+	h.Del("X-Forwarded-Port")
+	h.Del("X-Forwarded-Proto")
+}
+
 // quoteForwarded returns addr, quoted if necessary in order to be used in the
 // Forwarded header.
 func quoteForwarded(addr string) string {
@@ -128,6 +218,32 @@ func addVia(header http.Header, version string, host string) {
 	addHeaderValue(header, "Via", makeVia(version, host))
 }
 
+// setHeaderValue sets the name header to value, unless value is empty.
+func setHeaderValue(header http.Header, name string, value string) {
+	if len(value) != 0 {
+		header.Set(name, value)
+	}
+}
+
+// viaContains checks whether one of the recipients listed in the Via header
+// values is host.
+func viaContains(via []string, host string) (found bool) {
+	forEachHeaderValues(via, func(v string) {
+		// Each value is "protocol received-by [comment]", the protocol part
+		// is skipped to compare only the recipient.
+		if i := strings.IndexAny(v, " \t"); i >= 0 {
+			v = trimOWS(v[i+1:])
+			if j := strings.IndexAny(v, " \t"); j >= 0 {
+				v = v[:j]
+			}
+			if strings.EqualFold(v, host) {
+				found = true
+			}
+		}
+	})
+	return
+}
+
 // addHeaderValue adds value to the name header.
 func addHeaderValue(header http.Header, name string, value string) {
 	if prev := header.Get(name); len(prev) != 0 {
@@ -155,6 +271,12 @@ func connectionUpgrade(header http.Header) string {
 	return header.Get("Upgrade")
 }
 
+// expectsContinue returns true if the client that sent req expects to receive
+// "100 Continue" before sending the request body.
+func expectsContinue(req *http.Request) bool {
+	return req.ProtoAtLeast(1, 1) && headerValuesContainsToken(req.Header["Expect"], "100-continue")
+}
+
 // headerValuesRemoveTokens removes tokens from values, returning a new list of values.
 func headerValuesRemoveTokens(values []string, tokens ...string) []string {
 	result := make([]string, 0, len(values))
@@ -201,6 +323,16 @@ func readHeaderValue(s string) (value string, tail string) {
 	return
 }
 
+// isSafe returns true if method is safe, meaning that it only retrieves data
+// from the server.
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodHead, http.MethodGet, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
 // isIdempotent returns true if method is idempotent.
 func isIdempotent(method string) bool {
 	switch method {
@@ -210,6 +342,27 @@ func isIdempotent(method string) bool {
 	return false
 }
 
+// hasIdempotencyKey returns true if header has an idempotency key, which makes
+// the request safe to retry even if its method isn't idempotent.
+func hasIdempotencyKey(header http.Header) bool {
+	_, ok1 := header["Idempotency-Key"]
+	_, ok2 := header["X-Idempotency-Key"]
+	return ok1 || ok2
+}
+
+// isRetriableResponse returns true if res is a response to a request that
+// can be retried.
+func isRetriableResponse(res *http.Response) bool {
+	return isRetriable(res.StatusCode) || res.StatusCode == http.StatusTooManyRequests
+}
+
+// isInformational returns true if status is the status of an interim 1xx
+// response, which is followed by the final response. 101 Switching Protocols
+// is a final response.
+func isInformational(status int) bool {
+	return status >= 100 && status <= 199 && status != http.StatusSwitchingProtocols
+}
+
 // isRetriable returns true if the status is a retriable error.
 func isRetriable(status int) bool {
 	switch status {
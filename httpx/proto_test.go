@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -98,6 +99,67 @@ func TestCopyHeader(t *testing.T) {
 	}
 }
 
+func TestCopyHeaderBuffer(t *testing.T) {
+	src := http.Header{"Accept": {"text/html", "text/plain"}, "Via": {"1.1 a"}, "Empty": {}}
+	dst := http.Header{}
+
+	buf := copyHeaderBuffer(dst, src, make([]string, 0, 1))
+
+	if !reflect.DeepEqual(dst, src) {
+		t.Error(dst)
+	}
+
+	if len(buf) != 3 {
+		t.Error("bad buffer length:", len(buf))
+	}
+
+	// Appending to a header must not overwrite the values of the others.
+	dst["Accept"] = append(dst["Accept"], "application/json")
+	dst["Via"] = append(dst["Via"], "1.1 b")
+
+	if !reflect.DeepEqual(dst["Accept"], []string{"text/html", "text/plain", "application/json"}) {
+		t.Error("bad Accept header:", dst["Accept"])
+	}
+
+	if !reflect.DeepEqual(dst["Via"], []string{"1.1 a", "1.1 b"}) {
+		t.Error("bad Via header:", dst["Via"])
+	}
+}
+
+func BenchmarkCopyHeader(b *testing.B) {
+	src := benchmarkHeader()
+
+	b.Run("copyHeader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			copyHeader(make(http.Header, len(src)), src)
+		}
+	})
+
+	b.Run("copyHeaderBuffer", func(b *testing.B) {
+		dst := make(http.Header, len(src))
+		buf := []string(nil)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf = copyHeaderBuffer(dst, src, buf)
+		}
+	})
+}
+
+// benchmarkHeader returns a header typical of requests sent by browsers.
+func benchmarkHeader() http.Header {
+	return http.Header{
+		"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		"Accept-Encoding": {"gzip, deflate, br"},
+		"Accept-Language": {"en-US,en;q=0.5"},
+		"Cache-Control":   {"no-cache"},
+		"Connection":      {"keep-alive"},
+		"Cookie":          {"session=0123456789abcdef", "theme=dark"},
+		"Referer":         {"https://example.com/"},
+		"User-Agent":      {"Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0"},
+	}
+}
+
 func TestDleeteHopFields(t *testing.T) {
 	h := http.Header{
 		"Connection":          {"Upgrade", "Other"},
@@ -113,15 +175,77 @@ func TestDleeteHopFields(t *testing.T) {
 		"Content-Type":        {"text/html"},
 	}
 
-	deleteHopFields(h)
+	DeleteHopFields(h)
+
+	if !reflect.DeepEqual(h, http.Header{
+		"Content-Type": {"text/html"},
+	}) {
+		t.Error(h)
+	}
+}
+
+func TestDeleteHopFieldsNominated(t *testing.T) {
+	h := http.Header{
+		"Connection":       {"close, x-secret", "Keep-Alive"},
+		"Proxy-Connection": {"X-Token"},
+		"X-Secret":         {"42"},
+		"X-Token":          {"abc"},
+		"Close":            {"1"},
+		"Content-Type":     {"text/html"},
+	}
+
+	DeleteHopFields(h)
 
 	if !reflect.DeepEqual(h, http.Header{
+		"Close":        {"1"},
 		"Content-Type": {"text/html"},
 	}) {
 		t.Error(h)
 	}
 }
 
+func TestMakeXForwarded(t *testing.T) {
+	tests := []struct {
+		in     http.Header
+		xFor   string
+		xProto string
+		xHost  string
+	}{
+		{
+			in:     http.Header{},
+			xFor:   "127.0.0.1",
+			xProto: "http",
+			xHost:  "localhost",
+		},
+		{
+			in: http.Header{
+				"X-Forwarded-For":   {"212.53.1.6, 10.0.0.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+			xFor:   "212.53.1.6, 10.0.0.1, 127.0.0.1",
+			xProto: "https",
+			xHost:  "example.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			xFor, xProto, xHost := makeXForwarded(test.in, "http", "127.0.0.1:56789", "localhost")
+
+			if xFor != test.xFor {
+				t.Error("bad X-Forwarded-For:", xFor)
+			}
+			if xProto != test.xProto {
+				t.Error("bad X-Forwarded-Proto:", xProto)
+			}
+			if xHost != test.xHost {
+				t.Error("bad X-Forwarded-Host:", xHost)
+			}
+		})
+	}
+}
+
 func TestTranslateXForwarded(t *testing.T) {
 	tests := []struct {
 		in  http.Header
@@ -321,6 +445,29 @@ func TestMakeVia(t *testing.T) {
 	}
 }
 
+func TestViaContains(t *testing.T) {
+	tests := []struct {
+		via   []string
+		host  string
+		found bool
+	}{
+		{nil, "proxy", false},
+		{[]string{"1.1 proxy"}, "proxy", true},
+		{[]string{"1.0 fred, 1.1 Proxy (netx)"}, "proxy", true},
+		{[]string{"1.0 fred", "1.1 p.example.net"}, "p.example.net", true},
+		{[]string{"1.1 proxy-2"}, "proxy", false},
+		{[]string{"proxy"}, "proxy", false},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.via, ","), func(t *testing.T) {
+			if found := viaContains(test.via, test.host); found != test.found {
+				t.Error(found)
+			}
+		})
+	}
+}
+
 func TestAddVia(t *testing.T) {
 	tests := []struct {
 		version string
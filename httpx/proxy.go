@@ -4,13 +4,19 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/textproto"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/netx"
@@ -50,19 +56,214 @@ type ReverseProxy struct {
 	// that happen over a secured link.
 	// If nil, the default configuration is used.
 	TLSClientConfig *tls.Config
+
+	// DialTLSContext, if not nil, is used for dialing new TLS connections on
+	// HTTP upgrades that happen over a secured link, instead of DialContext
+	// and TLSClientConfig. It lets programs use a different configuration for
+	// each backend, for example to present per-backend client certificates
+	// (see tlsx.BackendConfigs).
+	DialTLSContext func(context.Context, string, string) (net.Conn, error)
+
+	// Proxy, if not nil, returns the URL of the upstream proxy that the
+	// request is sent through, a nil URL means that the backend server is
+	// reached directly. It has the signature of the Proxy field of
+	// http.Transport, http.ProxyFromEnvironment honors the HTTP_PROXY,
+	// HTTPS_PROXY, and NO_PROXY environment variables, http.ProxyURL always
+	// returns the same upstream proxy.
+	//
+	// The upstream proxy is used by the default transport when Transport is
+	// nil, and for the tunnels of CONNECT requests and protocol upgrades,
+	// which are established with CONNECT requests sent to the upstream proxy
+	// over connections opened with DialContext. DialTLSContext is not used
+	// when the request goes through an upstream proxy.
+	//
+	// If nil, the backend servers are always reached directly: unlike
+	// http.DefaultTransport, the proxy doesn't honor the environment
+	// variables unless Proxy is set to http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// PreserveRequestURI makes the proxy forward the request target that the
+	// client sent (see http.Request.RequestURI) byte-for-byte, instead of the
+	// one serialized from the request URL, which may encode the path
+	// differently. Backends verifying the signatures of URLs, or depending on
+	// unusual encodings, need it.
+	//
+	// The request target is only preserved when the path and query of the
+	// request URL were not modified by the handlers in front of the proxy.
+	// Requests sent through an upstream proxy use the absolute form of the
+	// target, with the path and query as received: the upstream proxy is the
+	// one returned by Proxy for the default transport, or by the Proxy
+	// function of Transport if it is a *http.Transport. Other transports are
+	// assumed to connect to backend servers directly.
+	PreserveRequestURI bool
+
+	// BufferPool is used to get the buffers for copying response bodies and
+	// tunneled bytes. If nil, the internal buffer pool of netx.Copy is used.
+	BufferPool netx.BufferPool
+
+	// FlushInterval is the interval at which the proxy flushes the response
+	// body to the client while copying it from the backend. If zero, the body
+	// is only flushed when the response is complete, a negative value means
+	// to flush after each write.
+	//
+	// Streaming responses, either with a text/event-stream content type or
+	// an unknown length, are always flushed after each write so Server-Sent
+	// Events and long-polling work through the proxy.
+	FlushInterval time.Duration
+
+	// ForwardedHeaders configures which headers the proxy uses to pass the
+	// client information to backend servers. If zero, only the Forwarded
+	// header is set.
+	ForwardedHeaders ForwardedHeaders
+
+	// TrustForwarded is called to determine whether the forwarding headers
+	// received with a request can be trusted. Untrusted headers are removed
+	// before the proxy adds its own, so clients cannot spoof their address.
+	// If nil, all forwarding headers are trusted.
+	TrustForwarded func(*http.Request) bool
+
+	// Pseudonym is the name that the proxy uses to identify itself in the Via
+	// header of forwarded requests. If empty, the local address of the
+	// connection that the request was received on is used instead.
+	//
+	// When set, requests with a Via header which already contains the
+	// pseudonym are rejected with 508 Loop Detected, preventing misconfigured
+	// routing from creating infinite proxy loops.
+	Pseudonym string
+
+	// ResponseFilters is a list of filters that are applied in order to the
+	// body of responses received from backend servers, the filters matching
+	// the content type of a response are chained, each one reading the
+	// output of the previous one.
+	//
+	// Filtered responses are sent without a Content-Length header since the
+	// filters may change the size of the body.
+	ResponseFilters []ResponseFilter
+
+	// CookieDomains maps the domains set on cookies by backend servers to the
+	// domains that the proxy exposes them under, the special "*" key matches
+	// any domain. Mapping a domain to an empty string removes the Domain
+	// attribute, restricting the cookie to the host it was received from.
+	CookieDomains map[string]string
+
+	// CookiePaths maps path prefixes set on cookies by backend servers to the
+	// prefixes that the proxy exposes them under, the longest matching prefix
+	// is replaced.
+	CookiePaths map[string]string
+
+	// CookieSecure adds the Secure attribute to the cookies set by backend
+	// servers, which is useful when the proxy terminates TLS connections.
+	CookieSecure bool
+
+	// CookieSameSite, if not zero, sets the SameSite attribute of the cookies
+	// set by backend servers. Setting it to http.SameSiteNoneMode implies
+	// CookieSecure since browsers reject such cookies otherwise.
+	CookieSameSite http.SameSite
+
+	// RewriteLocation enables rewriting the Location header of redirects sent
+	// by backend servers, absolute URLs pointing at the backend address have
+	// their scheme and host replaced by the ones the client used to reach the
+	// proxy, so clients aren't sent to addresses they cannot reach.
+	RewriteLocation bool
+
+	// LocationPaths maps path prefixes of the redirect locations to the
+	// prefixes that the proxy exposes them under, the longest matching prefix
+	// is replaced. It is only used when RewriteLocation is true.
+	LocationPaths map[string]string
+
+	// StripAltSvc removes the Alt-Svc header from the responses of backend
+	// servers, which is useful when the alternative services they advertise
+	// are not reachable by clients. The proxy may advertise its own with
+	// AltSvcHandler instead.
+	StripAltSvc bool
+
+	// TunnelLogger, if not nil, receives the accounting of the tunnels
+	// established for CONNECT requests and protocol upgrades when they are
+	// closed: the bytes transferred in each direction, how long the tunnels
+	// stayed open, and why they were closed.
+	TunnelLogger TunnelLogger
+
+	// TunnelIdleTimeout is the maximum amount of time that the tunnels
+	// established for CONNECT requests and protocol upgrades may stay idle
+	// before they are closed. Zero means no timeout.
+	TunnelIdleTimeout time.Duration
+
+	// ConnTracker, if not nil, registers the client connections of tunnels
+	// while they are open, with the method of the request, the host it was
+	// sent to, and the client address as metadata. Closing a connection with
+	// the tracker terminates its tunnel.
+	ConnTracker *netx.ConnTracker
+
+	// Recorder, if not nil, records the traffic of the client connections of
+	// tunnels, which helps investigating issues with tunneled protocols. With
+	// a netx.PcapWriter as sink, the traffic is written as a capture file
+	// that can be opened in Wireshark.
+	Recorder *netx.Recorder
+
+	// Logger, if not nil, is used to report the errors which made the proxy
+	// respond with 502 Bad Gateway, like failing to reach a backend server.
+	Logger netx.Logger
+
+	// Events, if not nil, receives the EventRequestStart,
+	// EventUpstreamSelected, and EventTunnelOpen events of the requests
+	// served by the proxy.
+	Events *netx.EventBus
+
+	// DestinationPolicy, if not nil, is applied to the connections that the
+	// proxy establishes to backend servers, requests to addresses denied by
+	// the policy are rejected with 403 Forbidden.
+	//
+	// The policy is applied to the connections of CONNECT requests and
+	// protocol upgrades, and to the connections of the transport: when
+	// Transport is nil or a *http.Transport, the proxy uses a copy of it whose
+	// dial functions go through the policy. Other transports cannot be
+	// guarded, the proxy responds with 500 Internal Server Error to the
+	// requests that would be sent through them.
+	//
+	// The upstream proxies returned by Proxy are trusted, the policy applies
+	// to the destinations of the requests sent through them: tunnels are
+	// established to the addresses resolved by the policy, other requests are
+	// checked before being sent to the upstream proxy, which resolves their
+	// destination again.
+	DestinationPolicy *netx.DestinationPolicy
+
+	guarded atomic.Value // *guardedTransport
 }
 
+// ForwardedHeaders is a bit set representing the headers that a ReverseProxy
+// uses to pass client information to backend servers.
+type ForwardedHeaders int
+
+const (
+	// ForwardedHeader is the RFC 7239 Forwarded header. When the flag is set
+	// the X-Forwarded-For, X-Forwarded-By, X-Forwarded-Port and
+	// X-Forwarded-Proto headers of requests with no Forwarded header are
+	// translated to their Forwarded equivalent.
+	ForwardedHeader ForwardedHeaders = 1 << iota
+
+	// XForwardedHeaders are the classic X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host headers, understood by backends which don't support
+	// the Forwarded header. The client address is appended to the
+	// X-Forwarded-For list, the other headers are preserved if they were
+	// already set.
+	XForwardedHeaders
+)
+
 // ServeHTTP satisfies the http.Handler interface.
 func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	remoteAddr := req.RemoteAddr
 	localAddr := requestLocalAddr(req)
+	p.publish(netx.EventRequestStart, req, "")
 
 	// Forwarded requests always use the HTTP/1.1 protocol when talking to the
 	// backend server.
-	outurl := *req.URL
-	outreq := *req
-	outreq.URL = &outurl
-	outreq.Header = make(http.Header, len(req.Header))
+	out := acquireOutgoingRequest(req)
+	defer func() {
+		if out != nil {
+			releaseOutgoingRequest(out)
+		}
+	}()
+	outreq := &out.req
 	outreq.Proto = "HTTP/1.1"
 	outreq.ProtoMajor = 1
 	outreq.ProtoMinor = 1
@@ -82,8 +283,7 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	// Remove hop-by-hop headers from the request so they aren't forwarded to
 	// the backend servers.
-	copyHeader(outreq.Header, req.Header)
-	deleteHopFields(outreq.Header)
+	DeleteHopFields(outreq.Header)
 
 	// There must be host set on the URL otherwise the proxy cannot forward the
 	// request to any backend server.
@@ -92,25 +292,38 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The request already went through this proxy, forwarding it again would
+	// only create a loop.
+	if len(p.Pseudonym) != 0 && viaContains(outreq.Header["Via"], p.Pseudonym) {
+		w.WriteHeader(http.StatusLoopDetected)
+		return
+	}
+
 	// Add proxy headers, Forwarded, Via, and convert X-Forwarded-For.
-	if _, hasFwd := outreq.Header["Forwarded"]; !hasFwd {
-		translateXForwarded(outreq.Header)
+	if p.TrustForwarded != nil && !p.TrustForwarded(req) {
+		deleteForwarded(outreq.Header)
 	}
-	addForwarded(outreq.Header, outreq.URL.Scheme, remoteAddr, localAddr)
-	addVia(outreq.Header, protoVersion(req), localAddr)
+	p.addForwarded(outreq.Header, outreq.URL.Scheme, remoteAddr, localAddr, req.Host)
+	if len(p.Pseudonym) != 0 {
+		addVia(outreq.Header, protoVersion(req), p.Pseudonym)
+	} else {
+		addVia(outreq.Header, protoVersion(req), localAddr)
+	}
+
+	p.publish(netx.EventUpstreamSelected, req, outreq.URL.Host)
 
 	switch method := outreq.Method; method {
 	case http.MethodConnect:
-		p.serveCONNECT(w, &outreq)
+		p.serveCONNECT(w, outreq)
 		return
 	case http.MethodTrace, http.MethodOptions:
 		// Decrement the Max-Forward header for TRACE and OPTIONS requests.
 		max, err := maxForwards(outreq.Header)
 		if max--; max == 0 || err != nil {
 			if method == http.MethodTrace {
-				p.serveTRACE(w, &outreq)
+				p.serveTRACE(w, outreq)
 			} else {
-				p.serveOPTIONS(w, &outreq)
+				p.serveOPTIONS(w, outreq)
 			}
 			return
 		}
@@ -121,49 +334,108 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// the target host that we can make exclusive use of, then the handshake is
 	// performed and the proxy starts passing bytes back and forth.
 	if upgrade := connectionUpgrade(req.Header); len(upgrade) != 0 {
+		// Protocol upgrades are sent over tunnels, in the origin form, even
+		// when they go through upstream proxies.
+		if p.PreserveRequestURI {
+			preserveRequestURI(outreq, req, false)
+		}
 		outreq.Header.Set("Connection", "Upgrade")
 		outreq.Header.Set("Upgrade", upgrade)
-		p.serveUpgrade(w, &outreq)
+		p.serveUpgrade(w, outreq)
+		return
+	}
+
+	// Relay the interim 1xx responses that the transport gets from the backend.
+	// "100 Continue" is relayed when the client expects the backend to decide
+	// whether it wants to receive the request body, it has to be written before
+	// the transport starts sending the body which is why it isn't handled by
+	// Got1xxResponse.
+	if req.ProtoAtLeast(1, 1) {
+		*outreq = *outreq.WithContext(httptrace.WithClientTrace(outreq.Context(), &httptrace.ClientTrace{
+			Got100Continue: func() {
+				w.WriteHeader(http.StatusContinue)
+			},
+			Got1xxResponse: func(status int, header textproto.MIMEHeader) error {
+				if status != http.StatusContinue {
+					writeInformational(w, status, http.Header(header))
+				}
+				return nil
+			},
+		}))
+	}
+
+	upstream, err := p.upstreamURL(outreq)
+	if err != nil {
+		p.backendError(w, "upstream proxy error", req, err)
 		return
 	}
 
-	transport := p.Transport
-	if transport == nil {
-		transport = http.DefaultTransport
+	if upstream != nil {
+		if policy := p.DestinationPolicy; policy != nil {
+			if err := policy.Check(outreq.Context(), "tcp", urlAddr(outreq.URL)); err != nil {
+				p.backendError(w, "backend dial error", req, err)
+				return
+			}
+		}
+		*outreq = *outreq.WithContext(context.WithValue(outreq.Context(), upstreamContextKey{}, upstream))
+	}
+
+	if p.PreserveRequestURI {
+		preserveRequestURI(outreq, req, upstream != nil)
 	}
 
-	res, err := transport.RoundTrip(&outreq)
+	transport, err := p.roundTripper()
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		p.logError("transport error", req, err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	deleteHopFields(res.Header)
+	res, err := transport.RoundTrip(outreq)
+	if err != nil {
+		// The transport may still be using the request after returning an
+		// error, it cannot be reused.
+		out = nil
+		p.backendError(w, "backend round trip error", req, err)
+		return
+	}
+
+	p.filterResponse(res)
+	p.rewriteCookies(res.Header)
+	p.rewriteLocation(res, req, outreq)
+	DeleteHopFields(res.Header)
+
+	if p.StripAltSvc {
+		res.Header.Del("Alt-Svc")
+	}
 	copyHeader(w.Header(), res.Header)
 
+	// The trailer keys have to be announced before the response header is
+	// written, the values are only known after the body was fully read.
+	if len(res.Trailer) != 0 {
+		w.Header()["Trailer"] = []string{trailerKeys(res.Trailer)}
+	}
+
 	w.WriteHeader(res.StatusCode)
-	netx.Copy(w, res.Body)
+	p.copyResponse(w, res)
 	res.Body.Close()
 
-	deleteHopFields(res.Trailer)
-	copyHeader(w.Header(), res.Trailer)
+	DeleteHopFields(res.Trailer)
+	copyTrailer(w.Header(), res.Trailer)
 }
 
 func (p *ReverseProxy) serveCONNECT(w http.ResponseWriter, req *http.Request) {
-	dial := p.DialContext
-	if dial == nil {
-		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
-	}
-
-	join := &sync.WaitGroup{}
-	defer join.Wait()
+	ctx := req.Context()
 
-	ctx, cancel := context.WithCancel(req.Context())
-	defer cancel()
+	dial, _, err := p.dialer(req)
+	if err != nil {
+		p.backendError(w, "upstream proxy error", req, err)
+		return
+	}
 
 	backend, err := dial(ctx, "tcp", req.URL.Host)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		p.backendError(w, "backend dial error", req, err)
 		return
 	}
 	defer backend.Close()
@@ -178,34 +450,11 @@ func (p *ReverseProxy) serveCONNECT(w http.ResponseWriter, req *http.Request) {
 	}
 	defer frontend.Close()
 
-	join.Add(1)
-	go func(r *bufio.Reader) {
-		defer join.Done()
-		defer cancel()
-
-		if _, err := r.WriteTo(backend); err != nil {
-			return
-		}
-
-		r = nil
-		netx.Copy(backend, frontend)
-	}(rw.Reader)
-
-	join.Add(1)
-	go func(w *bufio.Writer) {
-		defer join.Done()
-		defer cancel()
-
-		if err := w.Flush(); err != nil {
-			return
-		}
-
-		w = nil
-		netx.Copy(frontend, backend)
-	}(rw.Writer)
+	if err := rw.Writer.Flush(); err != nil {
+		return // the client is gone
+	}
 
-	rw = nil
-	<-ctx.Done()
+	p.tunnel(ctx, req, hijackedConn(frontend, rw.Reader), backend)
 }
 
 func (p *ReverseProxy) serveOPTIONS(w http.ResponseWriter, req *http.Request) {
@@ -223,29 +472,40 @@ func (p *ReverseProxy) serveTRACE(w http.ResponseWriter, req *http.Request) {
 }
 
 func (p *ReverseProxy) serveUpgrade(w http.ResponseWriter, req *http.Request) {
-	dial := p.DialContext
-	if dial == nil {
-		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	ctx := req.Context()
+	secure := req.URL.Scheme == "https"
+
+	dial, upstream, err := p.dialer(req)
+	if err != nil {
+		p.backendError(w, "upstream proxy error", req, err)
+		return
 	}
 
-	ctx := req.Context()
+	if secure && p.DialTLSContext != nil && !upstream {
+		dial, secure = p.guard(p.DialTLSContext), false
+	}
 
 	backend, err := dial(ctx, "tcp", req.URL.Host)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		p.backendError(w, "backend dial error", req, err)
 		return
 	}
-	if req.URL.Scheme == "https" {
+	if secure {
 		backend = tls.Client(backend, p.TLSClientConfig)
 	}
 	defer backend.Close()
 
+	// The reader is kept to forward the bytes that the backend sends after the
+	// response to the protocol upgrade.
+	br := bufio.NewReader(backend)
+
 	res, err := (&ConnTransport{
-		Conn: backend,
+		Conn:                  backend,
+		Buffer:                &bufio.ReadWriter{Reader: br},
 		ResponseHeaderTimeout: 10 * time.Second,
 	}).RoundTrip(req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		p.backendError(w, "backend upgrade error", req, err)
 		return
 	}
 
@@ -253,14 +513,14 @@ func (p *ReverseProxy) serveUpgrade(w http.ResponseWriter, req *http.Request) {
 	// hop-by-hop headers, except the Upgrade header which is used by some
 	// protocol upgrades.
 	upgrade := res.Header["Upgrade"]
-	deleteHopFields(res.Header)
+	DeleteHopFields(res.Header)
 	if len(upgrade) != 0 {
 		res.Header["Upgrade"] = upgrade
 		res.Header["Connection"] = []string{"Upgrade"}
 	}
 	copyHeader(w.Header(), res.Header)
 	w.WriteHeader(res.StatusCode)
-	netx.Copy(w, res.Body)
+	netx.CopyBuffer(w, res.Body, p.BufferPool)
 	res.Body.Close()
 
 	// Switching to a different protocol failed apparently, stopping here and
@@ -272,7 +532,6 @@ func (p *ReverseProxy) serveUpgrade(w http.ResponseWriter, req *http.Request) {
 	// No need to keep references to these objects anymore, the GC may collect
 	// them if possible.
 	upgrade = nil
-	req = nil
 	res = nil
 
 	frontend, rw, err := w.(http.Hijacker).Hijack()
@@ -282,22 +541,503 @@ func (p *ReverseProxy) serveUpgrade(w http.ResponseWriter, req *http.Request) {
 	}
 	defer frontend.Close()
 
+	// The bytes that the backend sent along with the response are written to
+	// the client with the response header, in a single write when they fit in
+	// the buffer of the writer.
+	if n := br.Buffered(); n != 0 {
+		b, _ := br.Peek(n)
+		rw.Writer.Write(b)
+		br.Discard(n)
+	}
+
 	if err := rw.Writer.Flush(); err != nil {
 		return // the client is gone
 	}
 
-	done := make(chan struct{}, 2)
-	go forward(rw.Writer, backend, done)
-	go forward(backend, rw.Reader, done)
+	p.tunnel(ctx, req, hijackedConn(frontend, rw.Reader), backend)
+}
+
+// roundTripper returns the transport used to forward requests to backend
+// servers, guarded by the destination policy of the proxy, or an error if the
+// policy cannot be applied to the transport.
+func (p *ReverseProxy) roundTripper() (http.RoundTripper, error) {
+	policy := p.DestinationPolicy
+
+	switch t := p.Transport.(type) {
+	case nil:
+		if policy == nil {
+			return defaultTransport, nil
+		}
+		return p.guardedTransport(nil, policy), nil
+	case *http.Transport:
+		if policy == nil {
+			return t, nil
+		}
+		return p.guardedTransport(t, policy), nil
+	default:
+		if policy != nil {
+			return nil, errUnguardedTransport
+		}
+		return t, nil
+	}
+}
+
+var errUnguardedTransport = errors.New("the destination policy can only be applied to transports of type *http.Transport")
+
+// upstreamURL returns the URL of the upstream proxy that the transport of the
+// proxy sends req through, or nil if it connects to the backend directly.
+func (p *ReverseProxy) upstreamURL(req *http.Request) (*url.URL, error) {
+	switch t := p.Transport.(type) {
+	case nil:
+		if p.Proxy != nil {
+			return p.Proxy(req)
+		}
+	case *http.Transport:
+		if t.Proxy != nil {
+			return t.Proxy(req)
+		}
+	}
+	return nil, nil
+}
+
+// guardedTransport is a copy of the transport of a proxy whose dial functions go
+// through its destination policy. The proxy keeps the copy so the connections
+// to backend servers are reused across requests.
+type guardedTransport struct {
+	base      *http.Transport // nil for the default transport
+	policy    *netx.DestinationPolicy
+	transport *http.Transport
+}
+
+// guardedTransport returns the copy of base, or of the default transport if
+// base is nil, whose dial functions go through policy. The copy is replaced
+// when the transport or the policy of the proxy change.
+func (p *ReverseProxy) guardedTransport(base *http.Transport, policy *netx.DestinationPolicy) *http.Transport {
+	for {
+		old := p.guarded.Load()
+
+		if g, _ := old.(*guardedTransport); g != nil && g.base == base && g.policy == policy {
+			return g.transport
+		}
+
+		g := &guardedTransport{base: base, policy: policy, transport: newGuardedTransport(base, policy)}
+
+		if p.guarded.CompareAndSwap(old, g) {
+			if prev, _ := old.(*guardedTransport); prev != nil {
+				prev.transport.CloseIdleConnections()
+			}
+			return g.transport
+		}
+	}
+}
+
+func newGuardedTransport(base *http.Transport, policy *netx.DestinationPolicy) *http.Transport {
+	var t *http.Transport
+
+	if base == nil {
+		t = newDefaultTransport()
+	} else {
+		t = base.Clone()
+
+		if t.DialContext == nil {
+			if dial := t.Dial; dial != nil {
+				t.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+					return dial(network, address)
+				}
+			} else {
+				t.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+			}
+		}
+
+		if t.DialTLSContext == nil {
+			if dial := t.DialTLS; dial != nil {
+				t.DialTLSContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+					return dial(network, address)
+				}
+			}
+		}
+	}
+
+	t.DialContext = guardDial(policy, t.DialContext)
+
+	if t.DialTLSContext != nil {
+		t.DialTLSContext = guardDial(policy, t.DialTLSContext)
+	}
+
+	return t
+}
+
+// defaultTransport is the transport of the proxies which have neither a
+// transport nor a destination policy.
+var defaultTransport = newDefaultTransport()
+
+// newDefaultTransport returns a transport configured like http.DefaultTransport,
+// except for its Proxy function which returns the upstream proxy carried by the
+// context of requests, as selected by ServeHTTP.
+func newDefaultTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, _ := req.Context().Value(upstreamContextKey{}).(*url.URL)
+		return u, nil
+	}
+	t.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	return t
+}
+
+// guardDial applies policy to the connections established with dial, except
+// the ones to the upstream proxy carried by their context, which are trusted.
+func guardDial(policy *netx.DestinationPolicy, dial netx.DialFunc) netx.DialFunc {
+	guarded := policy.Hop(dial)
+
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if u, _ := ctx.Value(upstreamContextKey{}).(*url.URL); u != nil && address == urlAddr(u) {
+			return dial(ctx, network, address)
+		}
+		return guarded(ctx, network, address)
+	}
+}
+
+type upstreamContextKey struct{}
+
+// dialer returns the function used to connect to the backend server of req,
+// going through the upstream proxy returned by the Proxy function when there
+// is one, which is reported by the boolean return value.
+func (p *ReverseProxy) dialer(req *http.Request) (dial netx.DialFunc, upstream bool, err error) {
+	if dial = p.DialContext; dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	if p.Proxy != nil {
+		var u *url.URL
+
+		if u, err = p.Proxy(req); err != nil {
+			return
+		}
+
+		if upstream = u != nil; upstream {
+			dial = (&ProxyDialer{
+				URL:             u,
+				TLSClientConfig: p.TLSClientConfig,
+				Dial:            dial,
+			}).DialContext
+		}
+	}
+
+	dial = p.guard(dial)
+	return
+}
+
+// guard applies the destination policy of the proxy to dial.
+func (p *ReverseProxy) guard(dial netx.DialFunc) netx.DialFunc {
+	if p.DestinationPolicy != nil {
+		dial = p.DestinationPolicy.Hop(dial)
+	}
+	return dial
+}
+
+// backendError responds to req when the proxy failed to get a response from
+// the backend server, which is 502 Bad Gateway unless the destination policy
+// of the proxy refused to connect to the backend.
+func (p *ReverseProxy) backendError(w http.ResponseWriter, msg string, req *http.Request, err error) {
+	var denied *netx.DestinationError
+
+	if errors.As(err, &denied) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	p.logError(msg, req, err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// logError reports err to the logger of the proxy, if it has one.
+func (p *ReverseProxy) logError(msg string, req *http.Request, err error) {
+	if p.Logger != nil {
+		p.Logger.Warn(msg, "method", req.Method, "host", req.URL.Host, "remote", req.RemoteAddr, "err", err)
+	}
+}
 
-	// Wait for either the connections to be closed or the context to be
-	// canceled.
-	select {
-	case <-done:
-	case <-ctx.Done():
+// publish sends an event about req to the event bus of the proxy, if it has
+// one.
+func (p *ReverseProxy) publish(typ netx.EventType, req *http.Request, target string) {
+	if p.Events != nil {
+		p.Events.Publish(netx.Event{
+			Type:       typ,
+			LocalAddr:  contextLocalAddr(req.Context()),
+			RemoteAddr: &netx.NetAddr{Net: "tcp", Addr: req.RemoteAddr},
+			Target:     target,
+			Meta:       map[string]string{"method": req.Method, "host": req.Host},
+		})
 	}
 }
 
+// tunnel passes bytes back and forth between frontend and backend until both
+// directions are done or ctx is canceled, then reports the accounting of the
+// tunnel to the loggers of the proxy.
+func (p *ReverseProxy) tunnel(ctx context.Context, req *http.Request, frontend net.Conn, backend net.Conn) {
+	p.publish(netx.EventTunnelOpen, req, req.URL.Host)
+
+	if t := p.ConnTracker; t != nil {
+		id := t.Track(frontend, map[string]string{
+			"method": req.Method,
+			"host":   req.URL.Host,
+			"client": req.RemoteAddr,
+		})
+		defer t.Untrack(id)
+	}
+
+	if r := p.Recorder; r != nil {
+		frontend = r.Record(frontend)
+	}
+
+	stats, err := netx.Bridge(ctx, frontend, backend, netx.BridgeOptions{
+		IdleTimeout: p.TunnelIdleTimeout,
+		BufferPool:  p.BufferPool,
+	})
+
+	if p.TunnelLogger != nil {
+		info := TunnelInfo{
+			Method:    req.Method,
+			Host:      req.URL.Host,
+			Client:    frontend.RemoteAddr().String(),
+			BytesUp:   stats.AToB,
+			BytesDown: stats.BToA,
+			Duration:  stats.Duration,
+			Cause:     tunnelCause(err),
+			Err:       err,
+		}
+
+		if req.Method != http.MethodConnect {
+			info.Upgrade = req.Header.Get("Upgrade")
+		}
+
+		p.TunnelLogger.LogTunnel(info)
+	}
+}
+
+// outgoingRequest holds the request that a ReverseProxy forwards to a backend
+// server, the values are pooled to reduce the allocations done per request.
+type outgoingRequest struct {
+	req    http.Request
+	url    url.URL
+	header http.Header
+	values []string
+}
+
+var outgoingRequestPool = sync.Pool{
+	New: func() interface{} { return &outgoingRequest{header: make(http.Header)} },
+}
+
+// acquireOutgoingRequest returns an outgoing request initialized as a copy of
+// req, including its URL and header.
+func acquireOutgoingRequest(req *http.Request) *outgoingRequest {
+	out := outgoingRequestPool.Get().(*outgoingRequest)
+	out.url = *req.URL
+	out.req = *req
+	out.req.URL = &out.url
+	out.req.Header = out.header
+	out.values = copyHeaderBuffer(out.header, req.Header, out.values)
+	return out
+}
+
+// releaseOutgoingRequest puts out back to the pool, the request must not be
+// used by the transport anymore, which is the case when the body of its
+// response was closed.
+func releaseOutgoingRequest(out *outgoingRequest) {
+	for name := range out.header {
+		delete(out.header, name)
+	}
+	for i := range out.values {
+		out.values[i] = ""
+	}
+	out.req = http.Request{}
+	out.url = url.URL{}
+	outgoingRequestPool.Put(out)
+}
+
+// preserveRequestURI sets the request target of outreq to the one that req was
+// received with, when the path and query of the request URL still match it.
+// The target is set in the absolute form when absolute is true.
+func preserveRequestURI(outreq *http.Request, req *http.Request, absolute bool) {
+	uri := req.RequestURI
+
+	u, err := url.ParseRequestURI(uri)
+	// The escaped paths are compared as well, since handlers may change the
+	// encoding of the path without changing the decoded path.
+	if err != nil || u.Path != req.URL.Path || u.EscapedPath() != req.URL.EscapedPath() ||
+		u.RawQuery != req.URL.RawQuery || u.ForceQuery != req.URL.ForceQuery {
+		return
+	}
+
+	if len(u.Scheme) != 0 { // absolute form
+		uri = uri[len(u.Scheme)+3:]
+		if i := strings.IndexByte(uri, '/'); i >= 0 {
+			uri = uri[i:]
+		} else {
+			return
+		}
+	}
+
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
+	}
+
+	// The transport writes the opaque URL as the request target, opaque URLs
+	// starting with a double slash are written in the absolute form.
+	if strings.HasPrefix(uri, "//") || absolute {
+		uri = "//" + outreq.URL.Host + uri
+	}
+
+	outreq.URL.Opaque = uri
+}
+
+// hijackedConn returns a net.Conn which reads the bytes buffered in r before
+// reading from conn.
+func hijackedConn(conn net.Conn, r *bufio.Reader) net.Conn {
+	if r.Buffered() == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, r: r}
+}
+
+// addForwarded adds the forwarding headers configured on the proxy to h.
+func (p *ReverseProxy) addForwarded(h http.Header, proto string, forAddr string, byAddr string, host string) {
+	headers := p.ForwardedHeaders
+	if headers == 0 {
+		headers = ForwardedHeader
+	}
+
+	// Translating the X-Forwarded-* headers removes them, so the values have
+	// to be computed beforehand when both kinds of headers are used.
+	var xFor, xProto, xHost string
+
+	if headers&XForwardedHeaders != 0 {
+		xFor, xProto, xHost = makeXForwarded(h, proto, forAddr, host)
+	}
+
+	if headers&ForwardedHeader != 0 {
+		if _, hasFwd := h["Forwarded"]; !hasFwd {
+			translateXForwarded(h)
+		}
+		addForwarded(h, proto, forAddr, byAddr)
+	}
+
+	if headers&XForwardedHeaders != 0 {
+		setHeaderValue(h, "X-Forwarded-For", xFor)
+		setHeaderValue(h, "X-Forwarded-Proto", xProto)
+		setHeaderValue(h, "X-Forwarded-Host", xHost)
+	}
+}
+
+// copyResponse copies the body of res to w, flushing it to the client according
+// to the proxy's flush interval.
+func (p *ReverseProxy) copyResponse(w http.ResponseWriter, res *http.Response) {
+	flusher, ok := w.(http.Flusher)
+	interval := p.flushInterval(res)
+
+	if !ok || interval == 0 {
+		netx.CopyBuffer(w, res.Body, p.BufferPool)
+		return
+	}
+
+	fw := &flushWriter{
+		w:        w,
+		flusher:  flusher,
+		interval: interval,
+	}
+	netx.CopyBuffer(fw, res.Body, p.BufferPool)
+	fw.stop()
+}
+
+// flushInterval returns the interval at which the body of res must be flushed
+// to the client.
+func (p *ReverseProxy) flushInterval(res *http.Response) time.Duration {
+	if res.ContentLength == -1 {
+		return -1
+	}
+	if isEventStream(res.Header) {
+		return -1
+	}
+	return p.FlushInterval
+}
+
+// flushWriter is an io.Writer which flushes the data written to a response
+// writer at most after interval, or after each write if interval is
+// negative.
+type flushWriter struct {
+	w        io.Writer
+	flusher  http.Flusher
+	interval time.Duration
+
+	mutex   sync.Mutex
+	timer   *time.Timer
+	pending bool
+}
+
+func (w *flushWriter) Write(b []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	n, err = w.w.Write(b)
+
+	if w.interval < 0 {
+		w.flusher.Flush()
+		return
+	}
+
+	if w.pending {
+		return
+	}
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	} else {
+		w.timer.Reset(w.interval)
+	}
+
+	w.pending = true
+	return
+}
+
+func (w *flushWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.pending {
+		w.pending = false
+		w.flusher.Flush()
+	}
+}
+
+func (w *flushWriter) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = false
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// writeInformational sends an interim 1xx response with header to w, the
+// header of w is restored when the function returns so the fields of the
+// interim response don't leak into the final response.
+func writeInformational(w http.ResponseWriter, status int, header http.Header) {
+	h := w.Header()
+	saved := make(http.Header, len(h))
+	copyHeader(saved, h)
+
+	DeleteHopFields(header)
+	copyHeader(h, header)
+	w.WriteHeader(status)
+
+	for name := range h {
+		delete(h, name)
+	}
+	copyHeader(h, saved)
+}
+
 // guessScheme attempts to guess the protocol that should be used for a proxied
 // request (either http or https).
 func guessScheme(localAddr string, remoteAddr string) string {
@@ -313,13 +1053,6 @@ func guessScheme(localAddr string, remoteAddr string) string {
 	return "http"
 }
 
-// forward copies bytes from r to w, sending a signal on the done channel when
-// the copy completes.
-func forward(w io.Writer, r io.Reader, done chan<- struct{}) {
-	defer func() { done <- struct{}{} }()
-	netx.Copy(w, r)
-}
-
 // requestLocalAddr looks for the request's local address in its context and
 // returns the string representation.
 func requestLocalAddr(req *http.Request) string {
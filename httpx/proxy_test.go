@@ -1,8 +1,21 @@
 package httpx
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/segmentio/netx"
 	"github.com/segmentio/netx/httpx/httpxtest"
@@ -33,3 +46,827 @@ func TestProxy(t *testing.T) {
 		}
 	})
 }
+
+func TestProxyFlush(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        http.Header
+		flushInterval time.Duration
+	}{
+		{
+			name:   "event-stream",
+			header: http.Header{"Content-Type": {"text/event-stream"}, "Content-Length": {"24"}},
+		},
+		{
+			name:   "unknown-length",
+			header: http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name:          "interval",
+			header:        http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"24"}},
+			flushInterval: 10 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			done := make(chan struct{})
+
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				copyHeader(w.Header(), test.header)
+				w.Write([]byte("data: Hello World!\n\n"))
+				w.(http.Flusher).Flush()
+				<-done
+				w.Write([]byte("\n\n"))
+			}))
+			defer origin.Close()
+			defer close(done)
+
+			proxy, closeProxy := listenAndServe(&Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					_, req.URL.Host = netx.SplitNetAddr(origin.URL)
+					(&ReverseProxy{FlushInterval: test.flushInterval}).ServeHTTP(w, req)
+				}),
+			})
+			defer closeProxy()
+
+			b := make([]byte, 20)
+			c := make(chan error, 1)
+			go func() {
+				res, err := http.Get(proxy)
+				if err == nil {
+					_, err = io.ReadFull(res.Body, b)
+					res.Body.Close()
+				}
+				c <- err
+			}()
+
+			select {
+			case err := <-c:
+				if err != nil {
+					t.Error(err)
+				} else if s := string(b); s != "data: Hello World!\n\n" {
+					t.Errorf("bad response body: %q", s)
+				}
+			case <-time.After(1 * time.Second):
+				t.Error("the response was not flushed by the proxy")
+			}
+		})
+	}
+}
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers ForwardedHeaders
+		in      http.Header
+		out     http.Header
+	}{
+		{
+			name: "default",
+			in:   http.Header{"X-Forwarded-For": {"10.0.0.1"}},
+			out:  http.Header{"Forwarded": {"for=10.0.0.1, proto=http;for=\"127.0.0.1:56789\";by=\"127.0.0.1:80\""}},
+		},
+		{
+			name:    "x-forwarded",
+			headers: XForwardedHeaders,
+			in:      http.Header{"X-Forwarded-For": {"10.0.0.1"}, "X-Forwarded-Proto": {"https"}},
+			out: http.Header{
+				"X-Forwarded-For":   {"10.0.0.1, 127.0.0.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+		},
+		{
+			name:    "both",
+			headers: ForwardedHeader | XForwardedHeaders,
+			in:      http.Header{},
+			out: http.Header{
+				"Forwarded":         {"proto=http;for=\"127.0.0.1:56789\";by=\"127.0.0.1:80\""},
+				"X-Forwarded-For":   {"127.0.0.1"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &ReverseProxy{ForwardedHeaders: test.headers}
+			p.addForwarded(test.in, "http", "127.0.0.1:56789", "127.0.0.1:80", "example.com")
+
+			if !reflect.DeepEqual(test.in, test.out) {
+				t.Error(test.in)
+			}
+		})
+	}
+}
+
+func TestProxyTrustForwarded(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("X-Forwarded-For")))
+	}))
+	defer origin.Close()
+
+	req := httptest.NewRequest("GET", origin.URL, nil)
+	req.RemoteAddr = "127.0.0.1:56789"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	res := httptest.NewRecorder()
+
+	(&ReverseProxy{
+		ForwardedHeaders: XForwardedHeaders,
+		TrustForwarded:   func(*http.Request) bool { return false },
+	}).ServeHTTP(res, req)
+
+	if s := res.Body.String(); s != "127.0.0.1" {
+		t.Errorf("bad X-Forwarded-For header: %q", s)
+	}
+}
+
+func TestProxyLoopDetected(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("Via", "1.1 other, 1.1 netx")
+	res := httptest.NewRecorder()
+
+	(&ReverseProxy{Pseudonym: "netx"}).ServeHTTP(res, req)
+
+	if res.Code != http.StatusLoopDetected {
+		t.Error("bad status code:", res.Code)
+	}
+}
+
+func TestProxyLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	req := httptest.NewRequest("GET", "http://localhost/", nil)
+	req.RemoteAddr = "127.0.0.1:56789"
+	res := httptest.NewRecorder()
+
+	(&ReverseProxy{
+		Transport: RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		}),
+		Logger: netx.NewStdLogger(log.New(buf, "", 0)),
+	}).ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadGateway {
+		t.Error("bad status code:", res.Code)
+	}
+
+	if s := buf.String(); s != "[warn] backend round trip error method=GET host=localhost remote=127.0.0.1:56789 err=\"connection refused\"\n" {
+		t.Error("bad log output:", s)
+	}
+}
+
+func TestProxyStripAltSvc(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "http://localhost/", nil)
+		res := httptest.NewRecorder()
+
+		(&ReverseProxy{
+			Transport: RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				header := http.Header{"Alt-Svc": {`h3=":8443"`}}
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody}, nil
+			}),
+			StripAltSvc: strip,
+		}).ServeHTTP(res, req)
+
+		if altSvc := res.Header().Get("Alt-Svc"); (altSvc == "") != strip {
+			t.Errorf("bad Alt-Svc header with StripAltSvc=%t: %q", strip, altSvc)
+		}
+	}
+}
+
+func TestProxyDestinationPolicy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer origin.Close()
+
+	loopback := &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		policy *netx.DestinationPolicy
+		status int
+	}{
+		{
+			name:   "requests to denied addresses are forbidden",
+			method: "GET",
+			target: origin.URL + "/",
+			policy: &netx.DestinationPolicy{},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "tunnels to denied addresses are forbidden",
+			method: "CONNECT",
+			target: origin.Listener.Addr().String(),
+			policy: &netx.DestinationPolicy{},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "requests to allowed addresses are forwarded",
+			method: "GET",
+			target: origin.URL + "/",
+			policy: &netx.DestinationPolicy{Allow: []*net.IPNet{loopback}},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, nil)
+			res := httptest.NewRecorder()
+
+			(&ReverseProxy{DestinationPolicy: test.policy}).ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+		})
+	}
+}
+
+func TestProxyDestinationPolicyTransport(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer origin.Close()
+
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		status    int
+	}{
+		{
+			name:      "the policy is applied to http transports",
+			transport: &http.Transport{},
+			status:    http.StatusForbidden,
+		},
+		{
+			name:      "the policy is applied to the TLS dial function of http transports",
+			transport: &http.Transport{DialTLSContext: (&net.Dialer{}).DialContext},
+			status:    http.StatusForbidden,
+		},
+		{
+			name: "other transports are rejected",
+			transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+
+			(&ReverseProxy{
+				Transport:         test.transport,
+				DestinationPolicy: &netx.DestinationPolicy{},
+			}).ServeHTTP(res, httptest.NewRequest("GET", origin.URL+"/", nil))
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+		})
+	}
+}
+
+func TestProxyGuardedTransport(t *testing.T) {
+	policy := &netx.DestinationPolicy{}
+	proxy := &ReverseProxy{DestinationPolicy: policy}
+
+	t1, _ := proxy.roundTripper()
+	t2, _ := proxy.roundTripper()
+
+	if t1 != t2 {
+		t.Error("the guarded transport was not reused")
+	}
+
+	proxy.DestinationPolicy = &netx.DestinationPolicy{}
+
+	if t3, _ := proxy.roundTripper(); t3 == t1 {
+		t.Error("the guarded transport was not replaced when the policy changed")
+	}
+
+	proxy.Transport = &http.Transport{}
+
+	if t4, _ := proxy.roundTripper(); t4 == proxy.Transport || t4 == t1 {
+		t.Error("the guarded transport was not replaced when the transport changed")
+	}
+}
+
+func TestProxyPreserveRequestURI(t *testing.T) {
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.RequestURI))
+	}))
+	defer echo.Close()
+
+	echoURL, _ := url.Parse(echo.URL)
+	const target = "/a|b/%7euser;v=1?q=a%20b+c&sig=x%2Fy"
+
+	tests := []struct {
+		name     string
+		proxy    *ReverseProxy
+		target   string
+		modify   func(*http.Request)
+		upstream bool
+		expected string
+	}{
+		{
+			name:     "disabled",
+			proxy:    &ReverseProxy{},
+			expected: "/a%7Cb/~user;v=1?q=a%20b+c&sig=x%2Fy",
+		},
+		{
+			name:     "enabled",
+			proxy:    &ReverseProxy{PreserveRequestURI: true},
+			expected: target,
+		},
+		{
+			name:     "modified path",
+			proxy:    &ReverseProxy{PreserveRequestURI: true},
+			modify:   func(req *http.Request) { req.URL.Path = "/b" },
+			expected: "/b?q=a%20b+c&sig=x%2Fy",
+		},
+		{
+			name:     "re-encoded path",
+			proxy:    &ReverseProxy{PreserveRequestURI: true},
+			target:   "/x%2Fy",
+			modify:   func(req *http.Request) { req.URL.RawPath = "" },
+			expected: "/x/y",
+		},
+		{
+			name:     "upstream proxy",
+			proxy:    &ReverseProxy{PreserveRequestURI: true, Proxy: http.ProxyURL(echoURL)},
+			upstream: true,
+			expected: "http://backend.example.com" + target,
+		},
+		{
+			name:     "upstream proxy of the transport",
+			proxy:    &ReverseProxy{PreserveRequestURI: true, Transport: &http.Transport{Proxy: http.ProxyURL(echoURL)}},
+			upstream: true,
+			expected: "http://backend.example.com" + target,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if len(test.target) == 0 {
+				test.target = target
+			}
+
+			req := httptest.NewRequest("GET", test.target, nil)
+			req.URL.Scheme = "http"
+			req.URL.Host = echoURL.Host
+			if test.upstream {
+				req.URL.Host = "backend.example.com"
+			}
+			if test.modify != nil {
+				test.modify(req)
+			}
+
+			res := httptest.NewRecorder()
+			test.proxy.ServeHTTP(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Fatal("bad status:", res.Code)
+			}
+			if uri := res.Body.String(); uri != test.expected {
+				t.Errorf("bad request URI received by the backend:\n%s\n%s", uri, test.expected)
+			}
+		})
+	}
+}
+
+func TestProxyUpstream(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("Hello World!"))
+	}))
+	defer origin.Close()
+
+	methods := make(chan string, 10)
+	upstream := httptest.NewServer(&ForwardProxy{
+		Log: func(user string, req *http.Request, status int) { methods <- req.Method },
+	})
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	proxy := &ReverseProxy{Proxy: http.ProxyURL(upstreamURL)}
+
+	t.Run("requests are forwarded through the upstream proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", origin.URL+"/", nil)
+		res := httptest.NewRecorder()
+
+		proxy.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK || res.Body.String() != "Hello World!" {
+			t.Errorf("bad response: %d %q", res.Code, res.Body.String())
+		}
+
+		if method := <-methods; method != "GET" {
+			t.Error("bad method received by the upstream proxy:", method)
+		}
+	})
+
+	t.Run("tunnels are established through the upstream proxy", func(t *testing.T) {
+		server, closeServer := listenAndServe(&Server{Handler: proxy})
+		defer closeServer()
+
+		_, serverAddr := netx.SplitNetAddr(server)
+		originAddr := origin.Listener.Addr().String()
+
+		conn, err := net.Dial("tcp", serverAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", originAddr, originAddr)
+		r := bufio.NewReader(conn)
+
+		res, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatal("bad status code:", res.StatusCode)
+		}
+
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", originAddr)
+
+		if res, err = http.ReadResponse(r, nil); err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		conn.Close()
+
+		if string(body) != "Hello World!" {
+			t.Errorf("bad response body: %q", body)
+		}
+
+		select {
+		case method := <-methods:
+			if method != "CONNECT" {
+				t.Error("bad method received by the upstream proxy:", method)
+			}
+		case <-time.After(1 * time.Second):
+			t.Error("the tunnel did not go through the upstream proxy")
+		}
+	})
+
+	t.Run("errors returned by the proxy function are reported", func(t *testing.T) {
+		req := httptest.NewRequest("CONNECT", origin.Listener.Addr().String(), nil)
+		res := httptest.NewRecorder()
+
+		(&ReverseProxy{
+			Proxy: func(*http.Request) (*url.URL, error) { return nil, errors.New("invalid proxy") },
+		}).ServeHTTP(res, req)
+
+		if res.Code != http.StatusBadGateway {
+			t.Error("bad status code:", res.Code)
+		}
+	})
+}
+
+func TestProxyUpstreamDestinationPolicy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("upstream " + req.URL.String()))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	proxy := &ReverseProxy{
+		// Only the requests to the test domains go through the upstream
+		// proxy, the other ones are sent directly.
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			if strings.HasSuffix(req.URL.Hostname(), ".test") {
+				return upstreamURL, nil
+			}
+			return nil, nil
+		},
+		DestinationPolicy: &netx.DestinationPolicy{
+			LookupIP: func(ctx context.Context, network string, host string) ([]net.IP, error) {
+				if host == "internal.test" {
+					return []net.IP{net.ParseIP("10.0.0.1")}, nil
+				}
+				return []net.IP{net.ParseIP("93.184.216.34")}, nil
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{
+			name:   "the upstream proxy is trusted",
+			target: "http://public.test/",
+			status: http.StatusOK,
+			body:   "upstream http://public.test/",
+		},
+		{
+			name:   "the destinations of the upstream proxy are checked",
+			target: "http://internal.test/",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "requests sent directly are checked",
+			target: origin.URL + "/",
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			proxy.ServeHTTP(res, httptest.NewRequest("GET", test.target, nil))
+
+			if res.Code != test.status {
+				t.Error("bad status code:", res.Code)
+			}
+			if test.status == http.StatusOK && res.Body.String() != test.body {
+				t.Errorf("bad response body: %q", res.Body.String())
+			}
+		})
+	}
+}
+
+func BenchmarkProxy(b *testing.B) {
+	proxy := &ReverseProxy{
+		Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+				Body:       http.NoBody,
+			}, nil
+		}),
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost/", nil)
+	req.Header = benchmarkHeader()
+	res := &benchmarkResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for name := range res.header {
+			delete(res.header, name)
+		}
+		proxy.ServeHTTP(res, req)
+	}
+}
+
+type benchmarkResponseWriter struct {
+	header http.Header
+}
+
+func (w *benchmarkResponseWriter) Header() http.Header         { return w.header }
+func (w *benchmarkResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *benchmarkResponseWriter) WriteHeader(int)             {}
+
+func TestProxyUpgradeServerFirst(t *testing.T) {
+	// The origin sends its first bytes in the same segment as the response
+	// to the upgrade request, before the client sent anything.
+	origin, closeOrigin := listenAndServe(netx.HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\nHello World!"))
+		io.Copy(io.Discard, conn)
+	}))
+	defer closeOrigin()
+
+	proxy, closeProxy := listenAndServe(&Server{Upgrader: &ReverseProxy{}})
+	defer closeProxy()
+
+	_, proxyAddr := netx.SplitNetAddr(proxy)
+	_, originAddr := netx.SplitNetAddr(origin)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n", originAddr)
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal("bad status code:", res.StatusCode)
+	}
+
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Errorf("bad bytes received after the upgrade: %q", b)
+	}
+}
+
+func TestProxyTunnelLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		close bool
+		cause TunnelCause
+	}{
+		{name: "closed", close: true, cause: TunnelClosed},
+		{name: "idle-timeout", close: false, cause: TunnelIdleTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			origin, closeOrigin := listenAndServe(netx.Echo)
+			defer closeOrigin()
+
+			tunnels := make(chan TunnelInfo, 1)
+
+			proxy, closeProxy := listenAndServe(&Server{
+				Handler: &ReverseProxy{
+					TunnelIdleTimeout: 100 * time.Millisecond,
+					TunnelLogger: TunnelLoggerFunc(func(info TunnelInfo) {
+						tunnels <- info
+					}),
+				},
+			})
+			defer closeProxy()
+
+			_, proxyAddr := netx.SplitNetAddr(proxy)
+			_, originAddr := netx.SplitNetAddr(origin)
+
+			conn, err := net.Dial("tcp", proxyAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", originAddr, originAddr)
+
+			r := bufio.NewReader(conn)
+			if _, err := http.ReadResponse(r, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			conn.Write([]byte("Hello World!"))
+
+			if _, err := io.ReadFull(r, make([]byte, 12)); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.close {
+				conn.(*net.TCPConn).CloseWrite()
+			}
+
+			select {
+			case info := <-tunnels:
+				if info.Method != "CONNECT" || info.Host != originAddr || info.Client != conn.LocalAddr().String() {
+					t.Errorf("bad tunnel request: %+v", info)
+				}
+				if info.BytesUp != 12 || info.BytesDown != 12 {
+					t.Errorf("bad byte counts: %d up, %d down", info.BytesUp, info.BytesDown)
+				}
+				if info.Cause != test.cause {
+					t.Errorf("bad termination cause: %s (%v)", info.Cause, info.Err)
+				}
+			case <-time.After(1 * time.Second):
+				t.Error("no tunnel logged by the proxy")
+			}
+		})
+	}
+}
+
+func TestProxyConnTracker(t *testing.T) {
+	origin, closeOrigin := listenAndServe(netx.Echo)
+	defer closeOrigin()
+
+	tracker := &netx.ConnTracker{}
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &ReverseProxy{ConnTracker: tracker},
+	})
+	defer closeProxy()
+
+	_, proxyAddr := netx.SplitNetAddr(proxy)
+	_, originAddr := netx.SplitNetAddr(origin)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", originAddr, originAddr)
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("bad status code:", res.StatusCode)
+	}
+
+	// The tunnel is registered after the response was sent, exchanging bytes
+	// guarantees that it was.
+	conn.Write([]byte("Hello World!"))
+	if _, err := io.ReadFull(r, make([]byte, 12)); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := tracker.Conns()
+	if len(conns) != 1 {
+		t.Fatal("bad number of tracked connections:", len(conns))
+	}
+	if info := conns[0]; info.Meta["method"] != "CONNECT" || info.Meta["host"] != originAddr {
+		t.Error("bad connection metadata:", info.Meta)
+	}
+
+	tracker.CloseConn(conns[0].ID)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Error("the tunnel was not terminated:", err)
+	}
+
+	for i := 0; len(tracker.Conns()) != 0; i++ {
+		if i == 100 {
+			t.Fatal("the tunnel was not removed from the tracker")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProxyRecorder(t *testing.T) {
+	origin, closeOrigin := listenAndServe(netx.Echo)
+	defer closeOrigin()
+
+	ring := netx.NewTranscriptRing(1024)
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &ReverseProxy{Recorder: &netx.Recorder{Sink: ring}},
+	})
+	defer closeProxy()
+
+	_, proxyAddr := netx.SplitNetAddr(proxy)
+	_, originAddr := netx.SplitNetAddr(origin)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", originAddr, originAddr)
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("bad status code:", res.StatusCode)
+	}
+
+	conn.Write([]byte("Hello World!"))
+	if _, err := io.ReadFull(r, make([]byte, 12)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write is recorded after the bytes were sent to the client, which
+	// may have read them already.
+	for i := 0; ; i++ {
+		var read, written string
+		for _, e := range ring.Events() {
+			switch e.Op {
+			case netx.TranscriptRead:
+				read += string(e.Data)
+			case netx.TranscriptWrite:
+				written += string(e.Data)
+			}
+		}
+
+		if read == "Hello World!" && written == "Hello World!" {
+			break
+		}
+
+		if i == 100 {
+			t.Fatalf("bad transcript: read=%q written=%q", read, written)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,296 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// RecordTransport is a http.RoundTripper which records the requests it sends
+// and the responses it receives to a file, and replays them on later runs. It
+// is intended to let integration tests against backend servers run
+// hermetically: the first run talks to the backends and saves the round trips,
+// following runs are served from the file without using the network.
+//
+// If the file doesn't exist, the transport records the round trips by
+// appending them to it in the HTTP/1.1 wire format, which makes the recordings
+// easy to review and edit. Otherwise each request is answered with the
+// response of the first recorded round trip that matches it and wasn't replayed
+// yet; requests that don't match any recording fail. Deleting the file causes
+// the round trips to be recorded again.
+//
+// Because request and response bodies are read in memory, the transport isn't
+// suited for large payloads.
+type RecordTransport struct {
+	// Transport is the sub-transport that the RecordTransport delegates
+	// requests to when recording.
+	//
+	// http.DefaultTransport is used if Transport is nil.
+	Transport http.RoundTripper
+
+	// Path is the location of the file where round trips are recorded.
+	//
+	// RoundTrip will panic if Path is empty.
+	Path string
+
+	// Redact is called with a copy of the header of each request and response
+	// before they are recorded, giving the program a chance to hide sensitive
+	// information.
+	//
+	// If nil, RedactAuthorization is used.
+	Redact func(http.Header)
+
+	// Match returns true if req matches the recorded request, the bodies of
+	// both requests can be read.
+	//
+	// If nil, requests match when they have the same method and URL.
+	Match func(req *http.Request, recorded *http.Request) bool
+
+	mutex   sync.Mutex
+	loaded  bool
+	replay  bool
+	records []*recordedRoundTrip
+}
+
+type recordedRoundTrip struct {
+	req     *http.Request
+	reqBody []byte
+	res     *http.Response
+	resBody []byte
+	used    bool
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.Path) == 0 {
+		panic("httpx.RecordTransport: missing path")
+	}
+
+	var body []byte
+
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	t.mutex.Lock()
+
+	if !t.loaded {
+		if err := t.load(); err != nil {
+			t.mutex.Unlock()
+			return nil, err
+		}
+		t.loaded = true
+	}
+
+	if t.replay {
+		defer t.mutex.Unlock()
+		return t.replayRoundTrip(req, body)
+	}
+
+	t.mutex.Unlock()
+	return t.recordRoundTrip(req, body)
+}
+
+func (t *RecordTransport) load() error {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			break
+		}
+
+		rec := &recordedRoundTrip{}
+
+		if rec.req, rec.reqBody, err = readRecordedRequest(r); err != nil {
+			return errors.New("httpx.RecordTransport: reading " + t.Path + ": " + err.Error())
+		}
+
+		if rec.res, rec.resBody, err = readRecordedResponse(r, rec.req); err != nil {
+			return errors.New("httpx.RecordTransport: reading " + t.Path + ": " + err.Error())
+		}
+
+		t.records = append(t.records, rec)
+	}
+
+	t.replay = true
+	return nil
+}
+
+func (t *RecordTransport) replayRoundTrip(req *http.Request, body []byte) (*http.Response, error) {
+	match := t.Match
+	if match == nil {
+		match = matchMethodAndURL
+	}
+
+	// The request is matched from a copy since round trippers must not modify
+	// the requests they are given.
+	matchreq := *req
+
+	for _, rec := range t.records {
+		if rec.used {
+			continue
+		}
+
+		matchreq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		rec.req.Body = ioutil.NopCloser(bytes.NewReader(rec.reqBody))
+
+		if match(&matchreq, rec.req) {
+			rec.used = true
+
+			res := *rec.res
+			res.Header = make(http.Header, len(rec.res.Header))
+			copyHeader(res.Header, rec.res.Header)
+			res.Body = ioutil.NopCloser(bytes.NewReader(rec.resBody))
+			res.Request = req
+			return &res, nil
+		}
+	}
+
+	return nil, errors.New("httpx.RecordTransport: no recorded round trip matches " + req.Method + " " + req.URL.String())
+}
+
+func (t *RecordTransport) recordRoundTrip(req *http.Request, body []byte) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	outreq := *req
+	outreq.Body, outreq.ContentLength = recordedBody(body), int64(len(body))
+
+	res, err := transport.RoundTrip(&outreq)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	recreq := &http.Request{
+		Method:        req.Method,
+		URL:           req.URL,
+		Header:        t.redact(req.Header),
+		Host:          req.Host,
+		Body:          recordedBody(body),
+		ContentLength: int64(len(body)),
+	}
+	if _, ok := recreq.Header["User-Agent"]; !ok {
+		// Prevents the default user agent from being recorded.
+		recreq.Header["User-Agent"] = []string{""}
+	}
+	if err := recreq.WriteProxy(&buf); err != nil {
+		return nil, err
+	}
+
+	recres := &http.Response{
+		Status:        res.Status,
+		StatusCode:    res.StatusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        t.redact(res.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(resBody)),
+		ContentLength: int64(len(resBody)),
+	}
+	if err := recres.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	if err := t.append(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+	return res, nil
+}
+
+// append writes a recorded round trip at the end of the file.
+func (t *RecordTransport) append(b []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	f, err := os.OpenFile(t.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (t *RecordTransport) redact(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+	copyHeader(h, header)
+
+	if redact := t.Redact; redact != nil {
+		redact(h)
+	} else {
+		RedactAuthorization(h)
+	}
+
+	return h
+}
+
+// recordedBody returns a request body reading b, or nil if b is empty so the
+// request isn't sent with a chunked encoding.
+func recordedBody(b []byte) io.ReadCloser {
+	if len(b) == 0 {
+		return nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(b))
+}
+
+func matchMethodAndURL(req *http.Request, recorded *http.Request) bool {
+	return req.Method == recorded.Method && req.URL.String() == recorded.URL.String()
+}
+
+func readRecordedRequest(r *bufio.Reader) (*http.Request, []byte, error) {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.RequestURI = ""
+	return req, body, nil
+}
+
+func readRecordedResponse(r *bufio.Reader, req *http.Request) (*http.Response, []byte, error) {
+	res, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	res.ContentLength = int64(len(body))
+	return res, body, nil
+}
@@ -0,0 +1,131 @@
+package httpx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.http")
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		res.Header().Set("X-Method", req.Method)
+		res.Write([]byte(req.URL.Path + ":" + string(b)))
+	}))
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+		result string
+	}{
+		{"GET", "/a", "", "/a:"},
+		{"POST", "/b", "hello", "/b:hello"},
+		{"HEAD", "/c", "", ""},
+		{"GET", "/a", "", "/a:"},
+	}
+
+	send := func(t *testing.T, transport http.RoundTripper) {
+		client := &http.Client{Transport: transport}
+
+		for _, r := range requests {
+			req, _ := http.NewRequest(r.method, server.URL+r.path, strings.NewReader(r.body))
+			req.Header.Set("Authorization", "Bearer secret")
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if string(b) != r.result {
+				t.Errorf("%s %s: bad response body: %q", r.method, r.path, b)
+			}
+
+			if m := res.Header.Get("X-Method"); m != r.method {
+				t.Errorf("%s %s: bad response header: %q", r.method, r.path, m)
+			}
+		}
+	}
+
+	t.Run("record", func(t *testing.T) {
+		send(t, &RecordTransport{Path: path})
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(b, []byte("secret")) {
+			t.Error("the authorization header was not redacted")
+		}
+	})
+
+	server.Close()
+
+	t.Run("replay", func(t *testing.T) {
+		transport := &RecordTransport{Path: path}
+		send(t, transport)
+
+		// All recorded round trips were replayed.
+		req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+
+		if _, err := transport.RoundTrip(req); err == nil {
+			t.Error("expected an error when no recorded round trips match")
+		}
+	})
+}
+
+func TestRecordTransportMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.http")
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		res.Write(bytes.ToUpper(b))
+	}))
+
+	matchBody := func(req *http.Request, recorded *http.Request) bool {
+		b1, _ := ioutil.ReadAll(req.Body)
+		b2, _ := ioutil.ReadAll(recorded.Body)
+		return bytes.Equal(b1, b2)
+	}
+
+	post := func(transport http.RoundTripper, body string) string {
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader(body))
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(b)
+	}
+
+	recorder := &RecordTransport{Path: path, Match: matchBody}
+	post(recorder, "first")
+	post(recorder, "second")
+	server.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer := &RecordTransport{Path: path, Match: matchBody}
+
+	// The round trips are replayed in a different order than they were
+	// recorded, the bodies are used to select the responses.
+	if s := post(replayer, "second"); s != "SECOND" {
+		t.Error("bad response:", s)
+	}
+	if s := post(replayer, "first"); s != "FIRST" {
+		t.Error("bad response:", s)
+	}
+}
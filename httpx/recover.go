@@ -0,0 +1,69 @@
+package httpx
+
+import (
+	"log"
+	"net/http"
+	"runtime"
+)
+
+// RecoverHandler is a HTTP handler which recovers from the panics raised by
+// its sub-handler, logs the error and stack trace, and keeps the server alive.
+//
+// If the response header wasn't written yet the client receives a 500 Internal
+// Server Error, otherwise the connection is closed so the client can tell that
+// the response is incomplete.
+type RecoverHandler struct {
+	// Handler is the sub-handler that the RecoverHandler delegates requests
+	// to.
+	//
+	// ServeHTTP will panic if Handler is nil.
+	Handler http.Handler
+
+	// ErrorLog is the logger that panics are written to. If nil, the standard
+	// logger is used.
+	ErrorLog *log.Logger
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *RecoverHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res := &proxyResponseWriter{ResponseWriter: w}
+
+	defer func() {
+		err := recover()
+		switch err {
+		case nil:
+			return
+		case http.ErrAbortHandler:
+			panic(err)
+		}
+
+		buf := make([]byte, 262144)
+		buf = buf[:runtime.Stack(buf, false)]
+		h.logf("panic serving %s %s for %s: %v\n%s", req.Method, req.URL, req.RemoteAddr, err, string(buf))
+
+		if res.status == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if conn, _, err := res.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+
+		// The response writer doesn't support hijacking (HTTP/2 for example),
+		// aborting the handler is the only way to signal the client that the
+		// response is incomplete.
+		panic(http.ErrAbortHandler)
+	}()
+
+	h.Handler.ServeHTTP(res, req)
+}
+
+func (h *RecoverHandler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,73 @@
+package httpx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name: "before-header",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				panic("oops")
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "after-header",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Length", "100")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("Hello"))
+				w.(http.Flusher).Flush()
+				panic("oops")
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			server := httptest.NewServer(&RecoverHandler{
+				Handler:  test.handler,
+				ErrorLog: log.New(buf, "", 0),
+			})
+			defer server.Close()
+
+			res, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != test.status {
+				t.Error("bad status:", res.StatusCode)
+			}
+
+			_, err = ioutil.ReadAll(res.Body)
+			if test.status == http.StatusOK && err == nil {
+				t.Error("expected an error reading the incomplete response body")
+			}
+
+			// Closing the server waits for the handler to return, the log
+			// output can't be read before that.
+			res.Body.Close()
+			server.Close()
+
+			if s := buf.String(); !strings.Contains(s, "panic serving GET /") || !strings.Contains(s, "oops") {
+				t.Error("bad log output:", s)
+			}
+		})
+	}
+}
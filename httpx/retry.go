@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +14,10 @@ const (
 	// DefaultMaxAttempts is the default number of attempts used by RetryHandler
 	// and RetryTransport.
 	DefaultMaxAttempts = 10
+
+	// DefaultMaxRetryAfter is the default value of the longest Retry-After
+	// delay that RetryTransport waits for.
+	DefaultMaxRetryAfter = 10 * time.Second
 )
 
 // A RetryHandler is a http.Handler which retries calls to its sub-handler if
@@ -94,9 +99,14 @@ func (h *RetryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // success (because it is usually unlikely that a failed request will succeed
 // right away).
 //
+// When the server responds with a Retry-After header (usually along with 503
+// Service Unavailable or 429 Too Many Requests), the transport waits for the
+// delay requested by the server instead.
+//
 // Note that only idempotent methods are retried, because the handler doesn't
 // have enough context about why it failed, it wouldn't be safe to retry other
-// HTTP methods.
+// HTTP methods. Requests with an Idempotency-Key or X-Idempotency-Key header are
+// considered idempotent regardless of their method.
 type RetryTransport struct {
 	// Transport is the sub-transport that the RetryTransport delegates requests
 	// to.
@@ -108,6 +118,17 @@ type RetryTransport struct {
 	// at handling a single request.
 	// Zero means to use a default value.
 	MaxAttempts int
+
+	// AttemptTimeout, if non-zero, limits the amount of time that each attempt
+	// can take to receive the response header. Attempts that time out are
+	// retried like other errors.
+	AttemptTimeout time.Duration
+
+	// MaxRetryAfter is the longest delay requested by a Retry-After header that
+	// the transport will wait for, responses asking for longer delays are
+	// returned to the caller.
+	// Zero means to use a default value.
+	MaxRetryAfter time.Duration
 }
 
 // RoundTrip satisfies the http.RoundTripper interface.
@@ -117,44 +138,127 @@ func (t *RetryTransport) RoundTrip(req *http.Request) (res *http.Response, err e
 		transport = http.DefaultTransport
 	}
 
-	body := &retryRequestBody{ReadCloser: req.Body}
-	req.Body = body
-
 	max := t.MaxAttempts
 	if max == 0 {
 		max = DefaultMaxAttempts
 	}
 
-	for attempt := 0; true; {
-		if res, err = transport.RoundTrip(req); err == nil {
-			if res.StatusCode < 500 || !isRetriable(res.StatusCode) {
-				break // success
+	maxRetryAfter := t.MaxRetryAfter
+	if maxRetryAfter == 0 {
+		maxRetryAfter = DefaultMaxRetryAfter
+	}
+
+	for attempt := 1; true; attempt++ {
+		// The request is copied so the caller's request isn't modified, as
+		// required by the http.RoundTripper interface.
+		outreq := *req
+		body := &retryRequestBody{ReadCloser: req.Body}
+
+		// Requests which have a way to get a new copy of their body can be
+		// retried even if some bytes of the body were already sent.
+		if attempt > 1 && req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+			if body.ReadCloser, err = req.GetBody(); err != nil {
+				return
 			}
 		}
 
-		if body.n != 0 {
-			err = fmt.Errorf("%s %s: failed and cannot be retried because %d bytes of the body have already been sent", req.Method, req.URL.Path, body.n)
-			break
+		if req.Body != nil {
+			outreq.Body = body
 		}
 
-		if !isIdempotent(req.Method) {
-			err = fmt.Errorf("%s %s: failed and cannot be retried because the method is not idempotent", req.Method, req.URL.Path)
-			break
+		if res, err = t.roundTrip(transport, &outreq); err == nil && !isRetriableResponse(res) {
+			return // success
 		}
 
-		if attempt++; attempt >= max {
-			err = fmt.Errorf("%s %s: failed %d times: %s", req.Method, req.URL.Path, attempt, err)
-			break
+		delay := backoff(attempt)
+		retryAfter, ok := time.Duration(0), false
+
+		if err == nil {
+			if retryAfter, ok = parseRetryAfter(res.Header, time.Now()); ok {
+				delay = retryAfter
+			}
+		}
+
+		var cause error
+
+		switch {
+		case body.n != 0 && req.GetBody == nil:
+			cause = fmt.Errorf("%s %s: failed and cannot be retried because %d bytes of the body have already been sent", req.Method, req.URL.Path, body.n)
+		case !isIdempotent(req.Method) && !hasIdempotencyKey(req.Header):
+			cause = fmt.Errorf("%s %s: failed and cannot be retried because the method is not idempotent", req.Method, req.URL.Path)
+		case attempt >= max:
+			cause = fmt.Errorf("%s %s: failed %d times: %s", req.Method, req.URL.Path, attempt, err)
+		case ok && retryAfter > maxRetryAfter:
+			cause = fmt.Errorf("%s %s: failed and cannot be retried because the server asked to wait for %s", req.Method, req.URL.Path, delay)
+		}
+
+		// When giving up on a request, the last response that was received is
+		// returned to the caller as-is.
+		if cause != nil {
+			if res == nil {
+				err = cause
+			}
+			return
 		}
 
-		if err = sleep(req.Context(), backoff(attempt)); err != nil {
+		if res != nil {
+			res.Body.Close()
+			res = nil
+		}
+
+		if err = sleep(req.Context(), delay); err != nil {
 			break
 		}
+
+		countRetry(req.Context())
 	}
 
 	return
 }
 
+// roundTrip makes a single attempt at sending req through transport, applying
+// the attempt timeout configured on t.
+func (t *RetryTransport) roundTrip(transport http.RoundTripper, req *http.Request) (res *http.Response, err error) {
+	timeout := t.AttemptTimeout
+	if timeout == 0 {
+		return transport.RoundTrip(req)
+	}
+
+	// The timeout only applies until the response header is received, so
+	// context.WithTimeout cannot be used, it would also interrupt reading the
+	// response body.
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(timeout, cancel)
+
+	if res, err = transport.RoundTrip(req.WithContext(ctx)); err != nil {
+		cancel()
+		return
+	}
+
+	if !timer.Stop() {
+		res.Body.Close()
+		res, err = nil, fmt.Errorf("%s %s: no response received after %s", req.Method, req.URL.Path, timeout)
+		cancel()
+		return
+	}
+
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return
+}
+
+// cancelBody is a io.ReadCloser wrapper which cancels a context when it is
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close satisfies the io.Closer interface.
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
 // retryResponseWriter is a http.ResponseWriter which captures 5xx responses.
 type retryResponseWriter struct {
 	http.ResponseWriter
@@ -170,6 +274,14 @@ func (w *retryResponseWriter) Header() http.Header {
 
 // WriteHeader satisfies the http.ResponseWriter interface.
 func (w *retryResponseWriter) WriteHeader(status int) {
+	if isInformational(status) {
+		// Interim responses are sent to the client right away, they aren't
+		// the status of the response.
+		if w.status == 0 {
+			writeInformational(w.ResponseWriter, status, w.header.Clone())
+		}
+		return
+	}
 	if w.status == 0 {
 		w.status = status
 		if status < 500 {
@@ -203,6 +315,31 @@ func (r *retryRequestBody) Read(b []byte) (n int, err error) {
 	return
 }
 
+// parseRetryAfter parses the Retry-After header in h, returning how long the
+// client should wait before retrying the request.
+func parseRetryAfter(h http.Header, now time.Time) (delay time.Duration, ok bool) {
+	v := trimOWS(h.Get("Retry-After"))
+
+	if len(v) == 0 {
+		return
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds >= 0 {
+			delay, ok = time.Duration(seconds)*time.Second, true
+		}
+		return
+	}
+
+	if date, err := http.ParseTime(v); err == nil {
+		if delay, ok = date.Sub(now), true; delay < 0 {
+			delay = 0
+		}
+	}
+
+	return
+}
+
 // backoff returns the amount of time a goroutine should wait before retrying
 // what it was doing considering that it already made n attempts.
 func backoff(n int) time.Duration {
@@ -6,7 +6,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -94,6 +97,159 @@ func TestRetryHandler(t *testing.T) {
 	}
 }
 
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		body     string
+		failures []int
+		retry    string
+		maxRetry time.Duration
+		delay    time.Duration
+		status   int
+		attempts int
+	}{
+		{
+			name:     "GET",
+			method:   "GET",
+			failures: []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			status:   http.StatusOK,
+			attempts: 3,
+		},
+		{
+			name:     "POST",
+			method:   "POST",
+			failures: []int{http.StatusInternalServerError},
+			status:   http.StatusInternalServerError,
+			attempts: 1,
+		},
+		{
+			name:     "POST+Idempotency-Key",
+			method:   "POST",
+			header:   http.Header{"Idempotency-Key": {"42"}},
+			failures: []int{http.StatusInternalServerError},
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+		{
+			name:     "PUT+body",
+			method:   "PUT",
+			body:     "Hello World!",
+			failures: []int{http.StatusInternalServerError},
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+		{
+			name:     "Retry-After",
+			method:   "GET",
+			retry:    "0",
+			failures: []int{http.StatusTooManyRequests},
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+		{
+			name:     "Retry-After:too-long",
+			method:   "GET",
+			retry:    "3600",
+			failures: []int{http.StatusServiceUnavailable},
+			status:   http.StatusServiceUnavailable,
+			attempts: 1,
+		},
+		{
+			name:     "MaxRetryAfter does not cap the backoff",
+			method:   "GET",
+			maxRetry: 1 * time.Millisecond,
+			failures: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			status:   http.StatusOK,
+			attempts: 3,
+		},
+		{
+			name:     "AttemptTimeout",
+			method:   "GET",
+			delay:    1 * time.Second,
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := int32(0)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				attempt := int(atomic.AddInt32(&attempts, 1) - 1)
+
+				if b, _ := ioutil.ReadAll(req.Body); string(b) != test.body {
+					t.Errorf("bad request body received by the server: %q", b)
+				}
+
+				if attempt == 0 && test.delay != 0 {
+					time.Sleep(test.delay)
+				}
+
+				if attempt < len(test.failures) {
+					if len(test.retry) != 0 {
+						w.Header().Set("Retry-After", test.retry)
+					}
+					w.WriteHeader(test.failures[attempt])
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			req, _ := http.NewRequest(test.method, server.URL, strings.NewReader(test.body))
+			copyHeader(req.Header, test.header)
+
+			res, err := (&RetryTransport{
+				AttemptTimeout: 100 * time.Millisecond,
+				MaxRetryAfter:  test.maxRetry,
+			}).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != test.status {
+				t.Errorf("bad status code: expected %d but got %d", test.status, res.StatusCode)
+			}
+
+			if n := int(atomic.LoadInt32(&attempts)); n != test.attempts {
+				t.Errorf("bad number of attempts: expected %d but got %d", test.attempts, n)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Sun, 01 Jan 2017 00:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 31 Dec 2016 00:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			delay, ok := parseRetryAfter(http.Header{"Retry-After": {test.value}}, now)
+
+			if delay != test.delay || ok != test.ok {
+				t.Errorf("bad Retry-After: %s %t", delay, ok)
+			}
+		})
+	}
+}
+
 func TestSleepTimeout(t *testing.T) {
 	ctx := context.Background()
 	t0 := time.Now()
@@ -117,3 +273,92 @@ func TestSleepCanceled(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRetryHandlerInformational(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer origin.Close()
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &RetryHandler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				req.URL.Host = strings.TrimPrefix(origin.URL, "http://")
+				(&ReverseProxy{}).ServeHTTP(w, req)
+			}),
+		},
+	})
+	defer closeProxy()
+
+	links := make(chan string, 1)
+	req, _ := http.NewRequest("GET", proxy+"/", nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		Got1xxResponse: func(status int, header textproto.MIMEHeader) error {
+			if status == http.StatusEarlyHints {
+				links <- header.Get("Link")
+			}
+			return nil
+		},
+	}))
+
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("bad status code:", res.StatusCode)
+	}
+
+	select {
+	case link := <-links:
+		if link != "</style.css>; rel=preload" {
+			t.Error("bad Link header in the interim response:", link)
+		}
+	default:
+		t.Error("the interim response was not relayed to the client")
+	}
+}
+
+func TestRetryHandlerExpectContinue(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(b)
+	}))
+	defer origin.Close()
+
+	proxy, closeProxy := listenAndServe(&Server{
+		Handler: &RetryHandler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				req.URL.Host = strings.TrimPrefix(origin.URL, "http://")
+				(&ReverseProxy{}).ServeHTTP(w, req)
+			}),
+		},
+	})
+	defer closeProxy()
+
+	req, _ := http.NewRequest("PUT", proxy+"/", strings.NewReader("Hello World!"))
+	req.Header.Set("Expect", "100-continue")
+
+	transport := &http.Transport{ExpectContinueTimeout: 5 * time.Second}
+	defer transport.CloseIdleConnections()
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Error("bad status code:", res.StatusCode)
+	}
+	if string(b) != "Hello World!" {
+		t.Errorf("bad response body: %q", b)
+	}
+}
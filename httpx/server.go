@@ -11,6 +11,8 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/segmentio/netx"
@@ -54,6 +56,26 @@ type Server struct {
 	// ServerName is the name of the server, returned in the "Server" response
 	// header field.
 	ServerName string
+
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers used to
+	// read requests and write responses on each connection. If zero, a
+	// default size of 4 KB is used.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Strict enables a hardened parsing mode, which rejects the requests that
+	// HTTP implementations may interpret differently with 400 Bad Request,
+	// before they reach the handler. It should be enabled on servers which
+	// forward requests to other servers, to prevent request smuggling.
+	//
+	// In strict mode, the server rejects requests with lines not terminated
+	// by CRLF, bare CR characters, obsolete line folding, both Content-Length
+	// and Transfer-Encoding, multiple or obfuscated values of these fields,
+	// and chunked bodies sent by HTTP/1.0 clients.
+	//
+	// The request header must also fit in the read buffer, larger headers
+	// are rejected with 431 Request Header Fields Too Large.
+	Strict bool
 }
 
 // ServeConn satisfies the netx.Handler interface.
@@ -80,7 +102,7 @@ func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
 	reqctx = context.WithValue(reqctx, http.LocalAddrContextKey, conn.LocalAddr())
 	reqctx, cancel = context.WithCancel(reqctx)
 
-	sc := newServerConn(conn, cancel)
+	sc := newServerConn(conn, cancel, s.ReadBufferSize, s.WriteBufferSize)
 	defer sc.Close()
 
 	res := &responseWriter{
@@ -98,7 +120,10 @@ func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
 		if err = sc.waitReadyRead(ctx, s.IdleTimeout); err != nil {
 			return
 		}
-		if req, err = sc.readRequest(reqctx, maxHeaderBytes, s.ReadTimeout); err != nil {
+		if req, err = sc.readRequest(reqctx, maxHeaderBytes, s.ReadTimeout, s.Strict); err != nil {
+			if e, ok := err.(*strictError); ok {
+				sc.writeError(e.status, s.WriteTimeout)
+			}
 			return
 		}
 		res.req = req
@@ -121,6 +146,9 @@ func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
 		if closed || req.Close {
 			return
 		}
+		if res.expect && !res.continued {
+			return // "Connection: close" was sent in the response
+		}
 
 		netx.Copy(ioutil.Discard, req.Body)
 		req.Body.Close()
@@ -189,9 +217,16 @@ func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request, conn net.Co
 
 	handler := s.Handler
 	upgrade := connectionUpgrade(req.Header)
+	expect := req.Header["Expect"]
+
+	if expectsContinue(req) {
+		res := w.(*responseWriter)
+		res.expect = true
+		req.Body = &expectContinueReader{ReadCloser: req.Body, res: res}
+	}
 
 	switch {
-	case len(req.Header["Expect"]) != 0:
+	case len(expect) != 0 && !headerValuesContainsToken(expect, "100-continue"):
 		handler = StatusHandler(http.StatusExpectationFailed)
 
 	case len(upgrade) != 0:
@@ -214,10 +249,10 @@ type serverConn struct {
 	bufio.Writer
 }
 
-func newServerConn(conn net.Conn, cancel context.CancelFunc) *serverConn {
+func newServerConn(conn net.Conn, cancel context.CancelFunc, readBufferSize int, writeBufferSize int) *serverConn {
 	c := &serverConn{c: connReader{Conn: conn, limit: -1, cancel: cancel}}
-	c.Reader = *bufio.NewReader(&c.c)
-	c.Writer = *bufio.NewWriter(&c.c)
+	c.Reader = *bufio.NewReaderSize(&c.c, bufferSize(readBufferSize))
+	c.Writer = *bufio.NewWriterSize(&c.c, bufferSize(writeBufferSize))
 	return c
 }
 
@@ -254,7 +289,7 @@ func (conn *serverConn) waitReadyRead(ctx context.Context, timeout time.Duration
 	}
 }
 
-func (conn *serverConn) readRequest(ctx context.Context, maxHeaderBytes int, timeout time.Duration) (req *http.Request, err error) {
+func (conn *serverConn) readRequest(ctx context.Context, maxHeaderBytes int, timeout time.Duration, strict bool) (req *http.Request, err error) {
 	// Limit the size of the request header, if readRequest attempts to read
 	// more than maxHeaderBytes it will get io.EOF.
 	conn.c.limit = maxHeaderBytes
@@ -265,10 +300,22 @@ func (conn *serverConn) readRequest(ctx context.Context, maxHeaderBytes int, tim
 		conn.SetReadDeadline(time.Time{})
 	}
 
+	if strict {
+		var header []byte
+
+		if header, err = conn.peekHeader(); err != nil {
+			return
+		}
+		if err = checkHeader(header); err != nil {
+			return
+		}
+	}
+
 	if req, err = http.ReadRequest(&conn.Reader); err != nil {
 		return
 	}
 	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
 
 	// Drop the size limit on the connection reader to let the request body
 	// go through.
@@ -282,16 +329,22 @@ func (conn *serverConn) readRequest(ctx context.Context, maxHeaderBytes int, tim
 // standard library, however it doesn't do automatic detection of the content
 // type.
 type responseWriter struct {
-	status  int           // status code of the response
-	header  http.Header   // header sent in the response
-	conn    *serverConn   // connection that the server got a request from
-	req     *http.Request // request that the writer sends a response for
-	timeout time.Duration // timeout for the full write operation
-	err     error         // any error detected internally by the writer
-	remain  uint64        // the remaining number of bytes to write
-	hasBody bool          // true when the request method allows to send a response body
-	chunked bool          // true when the writer uses "Transfer-Encoding: chunked"
-	cw      chunkWriter   // chunk writer used with "Transfer-Encoding: chunked"
+	status    int           // status code of the response
+	header    http.Header   // header sent in the response
+	conn      *serverConn   // connection that the server got a request from
+	req       *http.Request // request that the writer sends a response for
+	timeout   time.Duration // timeout for the full write operation
+	err       error         // any error detected internally by the writer
+	remain    uint64        // the remaining number of bytes to write
+	hasBody   bool          // true when the request method allows to send a response body
+	expect    bool          // true when the client expects "100 Continue" before sending the body
+	continued bool          // true when "100 Continue" was sent to the client
+	chunked   bool          // true when the writer uses "Transfer-Encoding: chunked"
+	cw        chunkWriter   // chunk writer used with "Transfer-Encoding: chunked"
+
+	// Serializes writing the response header with interim responses, which
+	// may be sent from a goroutine reading the request body.
+	mutex sync.Mutex
 }
 
 // Hijack satisfies the http.Hijacker interface.
@@ -325,9 +378,19 @@ func (res *responseWriter) Header() http.Header {
 
 // WriteHeader satisfies the http.ResponseWriter interface.
 func (res *responseWriter) WriteHeader(status int) {
+	res.mutex.Lock()
+	res.writeHeader(status)
+	res.mutex.Unlock()
+}
+
+func (res *responseWriter) writeHeader(status int) {
 	if res.status != 0 {
 		return
 	}
+	if isInformational(status) {
+		res.writeInformational(status)
+		return
+	}
 	if status == 0 {
 		status = http.StatusOK
 	}
@@ -366,6 +429,14 @@ func (res *responseWriter) WriteHeader(status int) {
 		h.Del("Content-Length")
 	}
 
+	if res.expect && !res.continued {
+		// The client is waiting for "100 Continue" before sending the request
+		// body, it is now going to decide on its own whether to send it or
+		// not, the connection cannot be reused so we tell the client that it
+		// will be closed.
+		h.Set("Connection", "close")
+	}
+
 	if _, hasDate := h["Date"]; !hasDate {
 		h.Set("Date", now().Format(time.RFC1123))
 	}
@@ -381,7 +452,7 @@ func (res *responseWriter) WriteHeader(status int) {
 		res.err = err
 		return
 	}
-	if err := h.Write(c); err != nil {
+	if err := h.WriteSubset(c, trailerPrefixedKeys(h)); err != nil {
 		res.err = err
 		return
 	}
@@ -391,6 +462,57 @@ func (res *responseWriter) WriteHeader(status int) {
 	}
 }
 
+// writeContinue sends "100 Continue" to the client if it expects it and no
+// response was sent yet.
+func (res *responseWriter) writeContinue() {
+	if !res.expect || res.continued || res.status != 0 || res.err != nil {
+		return
+	}
+	res.continued = true
+
+	if _, res.err = res.conn.WriteString("HTTP/1.1 100 Continue\r\n\r\n"); res.err == nil {
+		res.err = res.conn.Flush()
+	}
+}
+
+// writeInformational sends an interim 1xx response to the client with the
+// current response header, the header is not cleared so the program has to
+// remove the fields that it doesn't want in the final response.
+//
+// Like "100 Continue", informational responses are only sent to HTTP/1.1
+// clients.
+func (res *responseWriter) writeInformational(status int) {
+	if status == http.StatusContinue {
+		res.writeContinue()
+		return
+	}
+
+	if res.err != nil || !res.req.ProtoAtLeast(1, 1) {
+		return
+	}
+
+	var b = res.cw.b[:0]
+	var c = res.conn
+	var h = res.header
+
+	b = append(b, "HTTP/1.1 "...)
+	b = strconv.AppendInt(b, int64(status), 10)
+	b = append(b, ' ')
+	b = append(b, http.StatusText(status)...)
+	b = append(b, '\r', '\n')
+
+	if _, res.err = c.Write(b); res.err != nil {
+		return
+	}
+	if res.err = h.WriteSubset(c, trailerPrefixedKeys(h)); res.err != nil {
+		return
+	}
+	if _, res.err = c.WriteString("\r\n"); res.err != nil {
+		return
+	}
+	res.err = c.Flush()
+}
+
 // Write satisfies the io.Writer and http.ResponseWriter interfaces.
 func (res *responseWriter) Write(b []byte) (n int, err error) {
 	if err = res.err; err == nil {
@@ -448,18 +570,60 @@ func (res *responseWriter) Flush() {
 }
 
 func (res *responseWriter) close() {
-	if res.chunked {
-		res.WriteHeader(0)
+	res.WriteHeader(0)
 
-		if res.err == nil {
-			res.err = res.cw.Close()
+	if res.chunked && res.err == nil {
+		res.err = res.cw.Close(res.trailer())
+	}
+}
+
+// trailer returns the list of trailer fields that the program set on the
+// response header, either by announcing them in the "Trailer" header or by
+// prefixing the keys with http.TrailerPrefix.
+func (res *responseWriter) trailer() (trailer http.Header) {
+	forEachHeaderValues(res.header["Trailer"], func(v string) {
+		key := http.CanonicalHeaderKey(v)
+
+		if values := res.header[key]; len(values) != 0 {
+			if trailer == nil {
+				trailer = make(http.Header)
+			}
+			trailer[key] = values
+		}
+	})
+
+	for key, values := range res.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			if trailer == nil {
+				trailer = make(http.Header)
+			}
+			trailer[http.CanonicalHeaderKey(key[len(http.TrailerPrefix):])] = values
 		}
 	}
+
+	return
+}
+
+// trailerPrefixedKeys returns the set of keys in h that are prefixed with
+// http.TrailerPrefix, which must not be sent in the response header.
+func trailerPrefixedKeys(h http.Header) (keys map[string]bool) {
+	for key := range h {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			if keys == nil {
+				keys = make(map[string]bool)
+			}
+			keys[key] = true
+		}
+	}
+	return
 }
 
 func (res *responseWriter) reset(baseHeader http.Header) {
+	res.status = 0
 	res.remain = 0
 	res.hasBody = false
+	res.expect = false
+	res.continued = false
 	res.chunked = false
 	res.cw.w = nil
 	res.cw.n = 0
@@ -468,6 +632,29 @@ func (res *responseWriter) reset(baseHeader http.Header) {
 	copyHeader(res.header, baseHeader)
 }
 
+// expectContinueReader wraps the body of requests expecting "100 Continue" to
+// send it to the client when the handler starts reading the body.
+type expectContinueReader struct {
+	io.ReadCloser
+	res *responseWriter
+}
+
+// Read satisfies the io.Reader interface.
+func (r *expectContinueReader) Read(b []byte) (int, error) {
+	if res := r.res; res != nil {
+		r.res = nil
+		res.mutex.Lock()
+		res.writeContinue()
+		err := res.err
+		res.mutex.Unlock()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+	return r.ReadCloser.Read(b)
+}
+
 // chunkWriter provides the implementation of an HTTP writer that outputs a
 // response body using the chunked transfer encoding.
 type chunkWriter struct {
@@ -504,10 +691,19 @@ func (res *chunkWriter) Write(b []byte) (n int, err error) {
 	return
 }
 
-func (res *chunkWriter) Close() (err error) {
-	if err = res.Flush(); err == nil {
-		_, err = res.w.Write(append(res.a[:0], "0\r\n\r\n"...))
+func (res *chunkWriter) Close(trailer http.Header) (err error) {
+	if err = res.Flush(); err != nil {
+		return
+	}
+	if _, err = res.w.Write(append(res.a[:0], "0\r\n"...)); err != nil {
+		return
+	}
+	if len(trailer) != 0 {
+		if err = trailer.Write(res.w); err != nil {
+			return
+		}
 	}
+	_, err = res.w.Write(append(res.a[:0], "\r\n"...))
 	return
 }
 
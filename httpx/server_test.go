@@ -2,7 +2,10 @@ package httpx
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"testing"
 
@@ -21,6 +24,19 @@ func TestServer(t *testing.T) {
 	})
 }
 
+func TestServerBufferSizes(t *testing.T) {
+	httpxtest.TestServer(t, func(config httpxtest.ServerConfig) (string, func()) {
+		return listenAndServe(&Server{
+			Handler:         config.Handler,
+			ReadTimeout:     config.ReadTimeout,
+			WriteTimeout:    config.WriteTimeout,
+			MaxHeaderBytes:  config.MaxHeaderBytes,
+			ReadBufferSize:  64,
+			WriteBufferSize: 64,
+		})
+	})
+}
+
 func listenAndServe(h netx.Handler) (url string, close func()) {
 	lstn, err := netx.Listen("127.0.0.1:0")
 	if err != nil {
@@ -37,3 +53,24 @@ func listenAndServe(h netx.Handler) (url string, close func()) {
 	url, close = "http://"+lstn.Addr().String(), cancel
 	return
 }
+
+func TestServerRemoteAddr(t *testing.T) {
+	url, close := listenAndServe(&Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(req.RemoteAddr))
+		}),
+	})
+	defer close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+
+	if host, _, err := net.SplitHostPort(string(b)); err != nil || host != "127.0.0.1" {
+		t.Errorf("bad remote address: %q", b)
+	}
+}
@@ -0,0 +1,283 @@
+package httpx
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEHeartbeat is the default interval at which SSEHandler sends
+// heartbeats on idle event streams.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// ErrSSENotSupported is returned by NewSSEWriter when the response writer cannot
+// flush the events to the client.
+var ErrSSENotSupported = errors.New("server-sent events are not supported by the response writer")
+
+// SSEEvent represents an event sent on a text/event-stream response, as
+// described in https://html.spec.whatwg.org/multipage/server-sent-events.html
+type SSEEvent struct {
+	// ID is the identifier of the event, the client sends the identifier of
+	// the last event it received in the Last-Event-ID header when it
+	// reconnects.
+	ID string
+
+	// Event is the type of the event, clients dispatch it as a "message" when
+	// empty.
+	Event string
+
+	// Data is the payload of the event, it may span multiple lines.
+	Data string
+
+	// Retry instructs the client to wait this amount of time before
+	// reconnecting when the stream is interrupted, zero leaves it unchanged.
+	Retry time.Duration
+}
+
+// SSEWriter formats server-sent events on a HTTP response, flushing each event
+// to the client as soon as it is written.
+//
+// It is safe to use an SSEWriter from multiple goroutines.
+type SSEWriter struct {
+	mutex   sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	lastID  string
+	idle    time.Time // time of the last write
+	buf     []byte
+}
+
+// NewSSEWriter writes the header of a text/event-stream response to w and
+// returns a writer for the events of the stream. The Last-Event-ID header of
+// req, if any, is exposed by the LastEventID method of the returned writer.
+func NewSSEWriter(w http.ResponseWriter, req *http.Request) (*SSEWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrSSENotSupported
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Disables response buffering in nginx and other proxies that honor it.
+	h.Set("X-Accel-Buffering", "no")
+	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &SSEWriter{
+		w:       w,
+		flusher: flusher,
+		lastID:  req.Header.Get("Last-Event-ID"),
+		idle:    time.Now(),
+	}, nil
+}
+
+// LastEventID returns the identifier of the last event sent on the stream, or
+// the one sent by the client in the Last-Event-ID header if no events with an
+// identifier were written yet. Handlers use it to resume the stream where a
+// client left off after reconnecting.
+func (w *SSEWriter) LastEventID() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.lastID
+}
+
+// WriteEvent writes e to the stream and flushes it to the client.
+func (w *SSEWriter) WriteEvent(e SSEEvent) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	b := w.buf[:0]
+
+	if len(e.ID) != 0 {
+		// Line breaks would terminate the field, and the specification makes
+		// clients ignore identifiers containing NUL characters.
+		if strings.ContainsAny(e.ID, "\r\n\x00") {
+			return errors.New("invalid server-sent event ID: " + strconv.Quote(e.ID))
+		}
+		b = appendSSEField(b, "id", e.ID)
+	}
+
+	if len(e.Event) != 0 {
+		if strings.ContainsAny(e.Event, "\r\n") {
+			return errors.New("invalid server-sent event type: " + strconv.Quote(e.Event))
+		}
+		b = appendSSEField(b, "event", e.Event)
+	}
+
+	if e.Retry > 0 {
+		b = appendSSEField(b, "retry", strconv.FormatInt(int64(e.Retry/time.Millisecond), 10))
+	}
+
+	for _, line := range splitSSELines(e.Data) {
+		b = appendSSEField(b, "data", line)
+	}
+
+	b = append(b, '\n')
+	w.buf = b
+
+	if err := w.write(b); err != nil {
+		return err
+	}
+
+	if len(e.ID) != 0 {
+		w.lastID = e.ID
+	}
+	return nil
+}
+
+// WriteComment writes a comment line to the stream, clients ignore comments
+// but they keep intermediaries from closing idle connections.
+func (w *SSEWriter) WriteComment(text string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	b := w.buf[:0]
+
+	for _, line := range splitSSELines(text) {
+		b = append(b, ':')
+		if len(line) != 0 {
+			b = append(b, ' ')
+			b = append(b, line...)
+		}
+		b = append(b, '\n')
+	}
+
+	b = append(b, '\n')
+	w.buf = b
+	return w.write(b)
+}
+
+func (w *SSEWriter) write(b []byte) error {
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	w.idle = time.Now()
+	return nil
+}
+
+// heartbeat writes a comment to the stream if nothing was written for the
+// given interval, it returns the amount of time until the next heartbeat is
+// due.
+func (w *SSEWriter) heartbeat(interval time.Duration) (time.Duration, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if wait := interval - time.Since(w.idle); wait > 0 {
+		return wait, nil
+	}
+
+	if err := w.write([]byte(":\n\n")); err != nil {
+		return 0, err
+	}
+	return interval, nil
+}
+
+func appendSSEField(b []byte, name string, value string) []byte {
+	b = append(b, name...)
+	b = append(b, ':', ' ')
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+// splitSSELines splits s on any of the line terminators recognized by clients
+// of event streams (CRLF, LF or CR).
+func splitSSELines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// SSEHandler is a HTTP handler which serves text/event-stream responses.
+//
+// The handler writes the response header, then calls ServeSSE with a writer
+// for the events of the stream. While ServeSSE is running, a heartbeat comment
+// is sent whenever no events were written for the heartbeat interval so
+// proxies and load balancers don't close the idle connection. The stream ends
+// when ServeSSE returns, which it should do when the request context is
+// canceled.
+type SSEHandler struct {
+	// ServeSSE produces the events of the stream. The Last-Event-ID sent by
+	// reconnecting clients is available via w.LastEventID.
+	ServeSSE func(w *SSEWriter, req *http.Request)
+
+	// Heartbeat is the interval at which heartbeats are sent on idle streams.
+	// Zero means to use DefaultSSEHeartbeat, a negative value disables the
+	// heartbeats.
+	Heartbeat time.Duration
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *SSEHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	w, err := NewSSEWriter(res, req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	heartbeat := h.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultSSEHeartbeat
+	}
+
+	if heartbeat > 0 {
+		done := make(chan struct{})
+		join := make(chan struct{})
+
+		go sseHeartbeat(w, heartbeat, done, join)
+		defer func() {
+			close(done)
+			<-join
+		}()
+	}
+
+	h.ServeSSE(w, req)
+}
+
+// sseHeartbeat sends heartbeats on the idle stream of w until done is closed.
+func sseHeartbeat(w *SSEWriter, interval time.Duration, done <-chan struct{}, join chan<- struct{}) {
+	defer close(join)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			wait, err := w.heartbeat(interval)
+			if err != nil {
+				return
+			}
+			timer.Reset(wait)
+		case <-done:
+			return
+		}
+	}
+}
+
+// isEventStream returns true if h declares a text/event-stream content type.
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// acceptsEventStream returns true if req was sent by a client expecting a
+// text/event-stream response, like browsers using the EventSource API.
+func acceptsEventStream(req *http.Request) bool {
+	for _, value := range req.Header["Accept"] {
+		for _, s := range strings.Split(value, ",") {
+			if mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(s)); mediaType == "text/event-stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package httpx
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEWriter(t *testing.T) {
+	tests := []struct {
+		event  SSEEvent
+		output string
+	}{
+		{
+			event:  SSEEvent{Data: "hello"},
+			output: "data: hello\n\n",
+		},
+		{
+			event:  SSEEvent{ID: "42", Event: "update", Data: "hello"},
+			output: "id: 42\nevent: update\ndata: hello\n\n",
+		},
+		{
+			event:  SSEEvent{Data: "line 1\nline 2\r\nline 3\rline 4"},
+			output: "data: line 1\ndata: line 2\ndata: line 3\ndata: line 4\n\n",
+		},
+		{
+			event:  SSEEvent{Retry: 3 * time.Second},
+			output: "retry: 3000\ndata: \n\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.output, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			res := httptest.NewRecorder()
+
+			w, err := NewSSEWriter(res, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.WriteEvent(test.event); err != nil {
+				t.Fatal(err)
+			}
+
+			if s := res.Body.String(); s != test.output {
+				t.Errorf("bad output: %q", s)
+			}
+
+			if !res.Flushed {
+				t.Error("the event was not flushed")
+			}
+
+			if s := res.Header().Get("Content-Type"); s != "text/event-stream" {
+				t.Error("bad content type:", s)
+			}
+		})
+	}
+}
+
+func TestSSEWriterInvalidEvent(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+
+	w, _ := NewSSEWriter(res, req)
+
+	if err := w.WriteEvent(SSEEvent{ID: "1\n2"}); err == nil {
+		t.Error("expected an error for an ID containing a line break")
+	}
+
+	if err := w.WriteEvent(SSEEvent{Event: "a\rb"}); err == nil {
+		t.Error("expected an error for an event type containing a line break")
+	}
+
+	if res.Body.Len() != 0 {
+		t.Errorf("invalid events were written: %q", res.Body.String())
+	}
+}
+
+func TestSSEWriterLastEventID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Last-Event-ID", "41")
+	res := httptest.NewRecorder()
+
+	w, _ := NewSSEWriter(res, req)
+
+	if id := w.LastEventID(); id != "41" {
+		t.Error("bad last event ID:", id)
+	}
+
+	w.WriteEvent(SSEEvent{Data: "no id"})
+
+	if id := w.LastEventID(); id != "41" {
+		t.Error("bad last event ID:", id)
+	}
+
+	w.WriteEvent(SSEEvent{ID: "42", Data: "hello"})
+
+	if id := w.LastEventID(); id != "42" {
+		t.Error("bad last event ID:", id)
+	}
+}
+
+func TestSSEHandler(t *testing.T) {
+	events := make(chan SSEEvent)
+
+	server := httptest.NewServer(&SSEHandler{
+		Heartbeat: 10 * time.Millisecond,
+		ServeSSE: func(w *SSEWriter, req *http.Request) {
+			w.WriteEvent(SSEEvent{ID: "ack", Data: w.LastEventID()})
+
+			for {
+				select {
+				case e := <-events:
+					w.WriteEvent(e)
+				case <-req.Context().Done():
+					return
+				}
+			}
+		},
+	})
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	r := bufio.NewReader(res.Body)
+
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if s := readEvent(); s != "id: ack\ndata: 1\n" {
+		t.Errorf("bad first event: %q", s)
+	}
+
+	// Nothing is written by the handler, heartbeats must keep the stream
+	// alive.
+	if s := readEvent(); s != ":\n" {
+		t.Errorf("bad heartbeat: %q", s)
+	}
+
+	events <- SSEEvent{Event: "tick", Data: "2"}
+
+	for {
+		s := readEvent()
+		if s == ":\n" {
+			continue
+		}
+		if s != "event: tick\ndata: 2\n" {
+			t.Errorf("bad event: %q", s)
+		}
+		break
+	}
+}
+
+func TestEncodingHandlerEventStream(t *testing.T) {
+	handler := NewEncodingHandler(&SSEHandler{
+		ServeSSE: func(w *SSEWriter, req *http.Request) {
+			w.WriteEvent(SSEEvent{Data: "hello"})
+		},
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if s := res.Header().Get("Content-Encoding"); s != "" {
+		t.Error("the event stream was encoded:", s)
+	}
+
+	if s := res.Body.String(); s != "data: hello\n\n" {
+		t.Errorf("bad output: %q", s)
+	}
+}
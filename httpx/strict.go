@@ -0,0 +1,154 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// strictError is the error returned when a request is rejected by the strict
+// parsing mode of a server, which responds with status before closing the
+// connection.
+type strictError struct {
+	status int
+	reason string
+}
+
+func (e *strictError) Error() string {
+	return "malformed request: " + e.reason
+}
+
+func badRequest(reason string) error {
+	return &strictError{status: http.StatusBadRequest, reason: reason}
+}
+
+// peekHeader returns the header of the next request, from the request line to
+// the empty line which terminates it, without consuming it from the buffer.
+func (conn *serverConn) peekHeader() ([]byte, error) {
+	for scanned, start := 0, 0; ; {
+		n := conn.Reader.Buffered()
+		if n <= scanned {
+			n = scanned + 1
+		}
+
+		if n > conn.Reader.Size() {
+			return nil, &strictError{
+				status: http.StatusRequestHeaderFieldsTooLarge,
+				reason: "the header does not fit in the read buffer",
+			}
+		}
+
+		b, err := conn.Reader.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+
+		for ; scanned < len(b); scanned++ {
+			if b[scanned] != '\n' {
+				continue
+			}
+			if line := b[start:scanned]; len(line) == 0 || (len(line) == 1 && line[0] == '\r') {
+				return b[:scanned+1], nil
+			}
+			start = scanned + 1
+		}
+	}
+}
+
+// writeError sends a response with status to the client, telling it that the
+// connection is being closed.
+func (conn *serverConn) writeError(status int, timeout time.Duration) {
+	if timeout != 0 {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	fmt.Fprintf(&conn.Writer, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+	conn.Flush()
+}
+
+// checkHeader validates the raw header of a request in strict mode, rejecting
+// the constructs that HTTP implementations are known to interpret differently,
+// which would let clients smuggle requests through proxies.
+func checkHeader(b []byte) error {
+	lines := strings.SplitAfter(string(b), "\n")
+	lines = lines[:len(lines)-1] // the header ends with a line feed
+
+	var proto string
+	var contentLength, transferEncoding int
+
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "\r\n") {
+			return badRequest("line not terminated by CRLF")
+		}
+
+		line = line[:len(line)-2]
+
+		if strings.IndexByte(line, '\r') >= 0 {
+			return badRequest("bare CR")
+		}
+
+		if i == 0 {
+			if j := strings.LastIndexByte(line, ' '); j >= 0 {
+				proto = line[j+1:]
+			}
+			continue
+		}
+
+		if len(line) == 0 {
+			break
+		}
+
+		if isOWS(line[0]) {
+			return badRequest("obsolete line folding")
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 || !isToken(line[:colon]) {
+			return badRequest("invalid header field name")
+		}
+
+		name, value := line[:colon], line[colon+1:]
+
+		for j := 0; j < len(value); j++ {
+			if c := value[j]; (c < ' ' && c != '\t') || c == 0x7f {
+				return badRequest("invalid character in header field value")
+			}
+		}
+
+		// Only spaces are accepted around the values of the fields which frame
+		// the body, other whitespace characters are a common obfuscation.
+		value = strings.Trim(value, " ")
+
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			if contentLength++; len(value) == 0 || strings.Trim(value, "0123456789") != "" {
+				return badRequest("invalid Content-Length")
+			}
+
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			if transferEncoding++; !strings.EqualFold(value, "chunked") {
+				return badRequest("unsupported Transfer-Encoding")
+			}
+
+		case strings.IndexByte(name, '_') >= 0:
+			// Some servers convert underscores to dashes, or dashes to
+			// underscores when passing headers to CGI-like interfaces.
+			if n := strings.Replace(name, "_", "-", -1); strings.EqualFold(n, "Content-Length") || strings.EqualFold(n, "Transfer-Encoding") {
+				return badRequest("ambiguous header field name: " + name)
+			}
+		}
+	}
+
+	switch {
+	case contentLength > 1:
+		return badRequest("multiple Content-Length fields")
+	case transferEncoding > 1:
+		return badRequest("multiple Transfer-Encoding fields")
+	case contentLength != 0 && transferEncoding != 0:
+		return badRequest("both Content-Length and Transfer-Encoding are set")
+	case transferEncoding != 0 && proto != "HTTP/1.1":
+		return badRequest("Transfer-Encoding is not supported by " + proto)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx/httpx/httpxtest"
+)
+
+func TestServerStrict(t *testing.T) {
+	httpxtest.TestServer(t, func(config httpxtest.ServerConfig) (string, func()) {
+		return listenAndServe(&Server{
+			Handler:        config.Handler,
+			ReadTimeout:    config.ReadTimeout,
+			WriteTimeout:   config.WriteTimeout,
+			MaxHeaderBytes: config.MaxHeaderBytes,
+			Strict:         true,
+		})
+	})
+}
+
+func TestServerStrictRequests(t *testing.T) {
+	url, close := listenAndServe(&Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			b, _ := ioutil.ReadAll(req.Body)
+			w.Write(b)
+		}),
+		ReadBufferSize: 1024,
+		Strict:         true,
+	})
+	defer close()
+
+	tests := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{
+			name:    "valid",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello",
+			status:  http.StatusOK,
+		},
+		{
+			name:    "valid chunked",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: Chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			status:  http.StatusOK,
+		},
+		{
+			name:    "bare LF",
+			request: "GET / HTTP/1.1\nHost: a\n\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "bare LF in header",
+			request: "GET / HTTP/1.1\r\nHost: a\nX-Test: 1\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "bare CR",
+			request: "GET / HTTP/1.1\r\nHost: a\r\nX-Test: 1\r2\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "obsolete line folding",
+			request: "GET / HTTP/1.1\r\nHost: a\r\nX-Test: 1\r\n 2\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "space before colon",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "content-length and transfer-encoding",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nX",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "multiple content-length",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "invalid content-length",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "multiple transfer-encoding",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "transfer-encoding with tab",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\tchunked\r\n\r\n0\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "transfer-encoding list",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "transfer-encoding with underscore",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer_Encoding: chunked\r\n\r\n0\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "transfer-encoding in HTTP/1.0",
+			request: "POST / HTTP/1.0\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "control character",
+			request: "GET / HTTP/1.1\r\nHost: a\r\nX-Test: 1\x002\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "header too large",
+			request: "GET / HTTP/1.1\r\nHost: a\r\nX-Test: " + strings.Repeat("a", 2000) + "\r\n\r\n",
+			status:  http.StatusRequestHeaderFieldsTooLarge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", url[len("http://"):])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err := io.WriteString(conn, test.request); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != test.status {
+				t.Error("bad status:", res.Status)
+			}
+
+			if test.status != http.StatusOK && !res.Close {
+				t.Error("the connection was not closed after rejecting a request")
+			}
+		})
+	}
+}
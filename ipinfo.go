@@ -0,0 +1,61 @@
+package netx
+
+import "net"
+
+// IPInfo carries metadata about the origin of an IP address.
+type IPInfo struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country that the address
+	// is located in, like "US" or "FR", or empty if unknown.
+	Country string `json:"country,omitempty"`
+
+	// ASN is the number of the autonomous system that the address belongs to,
+	// or zero if unknown.
+	ASN uint32 `json:"asn,omitempty"`
+
+	// Organization is the name of the organization operating the autonomous
+	// system.
+	Organization string `json:"organization,omitempty"`
+}
+
+// IPInfoLookup is the interface of the databases which provide metadata about
+// IP addresses, like geoipx.DB for MaxMind databases. Lookups must be safe to
+// call concurrently, and fast enough to be made when accepting connections.
+//
+// Databases return a zero IPInfo and no error for addresses they have no data
+// about.
+type IPInfoLookup interface {
+	LookupIPInfo(ip net.IP) (IPInfo, error)
+}
+
+// IPInfoLookupFunc makes it possible to use regular functions as IPInfoLookup.
+type IPInfoLookupFunc func(net.IP) (IPInfo, error)
+
+// LookupIPInfo calls f(ip).
+func (f IPInfoLookupFunc) LookupIPInfo(ip net.IP) (IPInfo, error) {
+	return f(ip)
+}
+
+// MultiIPInfoLookup combines the metadata returned by several databases, for
+// example a country database and an ASN database. The fields are set from the
+// first database that returns a non-empty value for them.
+type MultiIPInfoLookup []IPInfoLookup
+
+// LookupIPInfo satisfies the IPInfoLookup interface.
+func (m MultiIPInfoLookup) LookupIPInfo(ip net.IP) (IPInfo, error) {
+	var info IPInfo
+
+	for _, db := range m {
+		x, err := db.LookupIPInfo(ip)
+		if err != nil {
+			return info, err
+		}
+		if len(info.Country) == 0 {
+			info.Country = x.Country
+		}
+		if info.ASN == 0 {
+			info.ASN, info.Organization = x.ASN, x.Organization
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,33 @@
+package netx
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestMultiIPInfoLookup(t *testing.T) {
+	country := IPInfoLookupFunc(func(ip net.IP) (IPInfo, error) {
+		return IPInfo{Country: "FR"}, nil
+	})
+
+	asn := IPInfoLookupFunc(func(ip net.IP) (IPInfo, error) {
+		return IPInfo{Country: "DE", ASN: 64496, Organization: "Example"}, nil
+	})
+
+	info, err := MultiIPInfoLookup{country, asn}.LookupIPInfo(net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != (IPInfo{Country: "FR", ASN: 64496, Organization: "Example"}) {
+		t.Errorf("bad info: %+v", info)
+	}
+
+	failed := IPInfoLookupFunc(func(ip net.IP) (IPInfo, error) {
+		return IPInfo{}, errors.New("lookup failed")
+	})
+
+	if _, err := (MultiIPInfoLookup{country, failed}).LookupIPInfo(net.ParseIP("192.0.2.1")); err == nil {
+		t.Error("no error returned when a lookup failed")
+	}
+}
@@ -0,0 +1,80 @@
+package netx
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// KeepAlive represents the configuration of TCP keep-alive probes, which are
+// used to detect that the peer of an idle connection went away.
+//
+// A connection is considered dead after Idle + Interval * Count without any
+// response from the peer. Zero values leave the defaults of the operating
+// system in place.
+type KeepAlive struct {
+	// Idle is the amount of time that a connection must be idle before the
+	// first probe is sent.
+	Idle time.Duration
+
+	// Interval is the amount of time between two probes.
+	Interval time.Duration
+
+	// Count is the number of unanswered probes after which the connection is
+	// closed.
+	Count int
+}
+
+// SetKeepAlive enables TCP keep-alive on conn, using the given configuration.
+//
+// The function works on connections wrapping a TCP connection as long as they
+// expose it through a BaseConn method. On platforms which don't support
+// setting some of the parameters (for example the probe count on old versions
+// of windows), those are silently ignored.
+func SetKeepAlive(conn net.Conn, config KeepAlive) error {
+	tcp, ok := BaseConn(conn).(*net.TCPConn)
+	if !ok {
+		return errors.New("netx.SetKeepAlive: not a TCP connection")
+	}
+
+	if err := tcp.SetKeepAlive(true); err != nil {
+		return err
+	}
+
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	if e := raw.Control(func(fd uintptr) { err = setKeepAlive(fd, config) }); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// KeepAliveListener is a net.Listener which configures TCP keep-alive on the
+// connections it accepts.
+type KeepAliveListener struct {
+	net.Listener
+	KeepAlive KeepAlive
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *KeepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := SetKeepAlive(conn, l.KeepAlive); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// seconds converts d to a number of seconds, rounding up so sub-second
+// durations don't disable the option.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,34 @@
+package netx
+
+import (
+	"os"
+	"syscall"
+)
+
+func setKeepAlive(fd uintptr, config KeepAlive) error {
+	const (
+		// missing from the syscall package
+		TCP_KEEPINTVL = 0x101
+		TCP_KEEPCNT   = 0x102
+	)
+
+	if config.Idle > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPALIVE, seconds(config.Idle)); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	if config.Interval > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, TCP_KEEPINTVL, seconds(config.Interval)); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	if config.Count > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, TCP_KEEPCNT, config.Count); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package netx
+
+import (
+	"os"
+	"syscall"
+)
+
+func setKeepAlive(fd uintptr, config KeepAlive) error {
+	if config.Idle > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds(config.Idle)); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	if config.Interval > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(config.Interval)); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	if config.Count > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, config.Count); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	return nil
+}
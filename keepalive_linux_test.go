@@ -0,0 +1,70 @@
+package netx
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := KeepAlive{
+		Idle:     30 * time.Second,
+		Interval: 5 * time.Second,
+		Count:    3,
+	}
+
+	lstn := &KeepAliveListener{Listener: l, KeepAlive: config}
+	defer lstn.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := SetKeepAlive(client, config); err != nil {
+		t.Error(err)
+	}
+
+	conn, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testKeepAlive(t, client, config)
+	testKeepAlive(t, conn, config)
+}
+
+func testKeepAlive(t *testing.T, conn net.Conn, config KeepAlive) {
+	raw, err := BaseConn(conn).(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw.Control(func(fd uintptr) {
+		for _, opt := range []struct {
+			name  string
+			level int
+			opt   int
+			value int
+		}{
+			{"SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+			{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds(config.Idle)},
+			{"TCP_KEEPINTVL", syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(config.Interval)},
+			{"TCP_KEEPCNT", syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, config.Count},
+		} {
+			if v, err := syscall.GetsockoptInt(int(fd), opt.level, opt.opt); err != nil {
+				t.Error(opt.name, err)
+			} else if v != opt.value {
+				t.Errorf("%s: expected %d but got %d", opt.name, opt.value, v)
+			}
+		}
+	})
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package netx
+
+func setKeepAlive(fd uintptr, config KeepAlive) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+package netx
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSetKeepAliveNotTCP(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	if err := SetKeepAlive(c1, KeepAlive{Idle: time.Second}); err == nil {
+		t.Error("expected an error when setting keep-alive on a non-TCP connection")
+	}
+}
@@ -0,0 +1,52 @@
+package netx
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func setKeepAlive(fd uintptr, config KeepAlive) error {
+	const (
+		// missing from the syscall package
+		TCP_KEEPCNT = 16
+
+		// Defaults documented for the KeepAliveTime and KeepAliveInterval
+		// registry keys, SIO_KEEPALIVE_VALS must set both values.
+		defaultIdle     = 2 * time.Hour
+		defaultInterval = 1 * time.Second
+	)
+
+	if config.Idle > 0 || config.Interval > 0 {
+		idle, interval := config.Idle, config.Interval
+
+		if idle <= 0 {
+			idle = defaultIdle
+		}
+
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+
+		ka := syscall.TCPKeepalive{
+			OnOff:    1,
+			Time:     uint32(idle / time.Millisecond),
+			Interval: uint32(interval / time.Millisecond),
+		}
+		ret := uint32(0)
+		size := uint32(unsafe.Sizeof(ka))
+
+		if err := syscall.WSAIoctl(syscall.Handle(fd), syscall.SIO_KEEPALIVE_VALS, (*byte)(unsafe.Pointer(&ka)), size, nil, 0, &ret, nil, 0); err != nil {
+			return os.NewSyscallError("wsaioctl", err)
+		}
+	}
+
+	if config.Count > 0 {
+		// TCP_KEEPCNT is only supported since windows 10 version 1703, the
+		// error is ignored on older versions.
+		syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_TCP, TCP_KEEPCNT, config.Count)
+	}
+
+	return nil
+}
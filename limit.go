@@ -0,0 +1,447 @@
+package netx
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitListener is a net.Listener which limits the number of concurrent
+// connections that it accepts.
+//
+// When the limit is reached, new connections are held in a queue until one of
+// the active connections is closed, or rejected (closed right away) if the
+// queue is full or they waited for longer than the queue timeout. Unlike
+// netutil.LimitListener, this lets the kernel backlog drain and gives a quick
+// answer to clients when the server is overloaded.
+type LimitListener struct {
+	net.Listener
+
+	// MaxConns is the maximum number of connections that can be active at the
+	// same time. Zero or a negative value means no limit.
+	MaxConns int
+
+	// MaxQueue is the maximum number of connections that can wait for one of
+	// the active connections to be closed. If zero, connections are rejected
+	// as soon as the limit is reached.
+	MaxQueue int
+
+	// QueueTimeout is the maximum amount of time that a connection can wait
+	// in the queue before being rejected. If zero, there is no timeout.
+	QueueTimeout time.Duration
+
+	once      sync.Once
+	closeOnce sync.Once
+	slots     chan struct{}
+	conns     chan net.Conn
+	done      chan struct{}
+	stop      chan struct{}
+	err       error
+
+	queued   int64
+	accepted int64
+	rejected int64
+}
+
+// LimitStats is a snapshot of the state of a LimitListener.
+type LimitStats struct {
+	Active   int   // number of active connections
+	Queued   int   // number of connections waiting in the queue
+	Accepted int64 // total number of connections returned by Accept
+	Rejected int64 // total number of connections that were rejected
+}
+
+// Stats returns the current state of the listener.
+func (l *LimitListener) Stats() LimitStats {
+	l.once.Do(l.init)
+	return LimitStats{
+		Active:   len(l.slots),
+		Queued:   int(atomic.LoadInt64(&l.queued)),
+		Accepted: atomic.LoadInt64(&l.accepted),
+		Rejected: atomic.LoadInt64(&l.rejected),
+	}
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *LimitListener) Accept() (net.Conn, error) {
+	if l.MaxConns <= 0 {
+		return l.Listener.Accept()
+	}
+
+	l.once.Do(l.init)
+
+	select {
+	case conn := <-l.conns:
+		atomic.AddInt64(&l.accepted, 1)
+		return conn, nil
+	case <-l.stop:
+		return nil, l.err
+	}
+}
+
+// Close satisfies the net.Listener interface, it closes the base listener and
+// the connections waiting in the queue.
+func (l *LimitListener) Close() error {
+	l.once.Do(l.init)
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *LimitListener) init() {
+	n := l.MaxConns
+	if n < 0 {
+		n = 0
+	}
+
+	l.slots = make(chan struct{}, n)
+	l.conns = make(chan net.Conn)
+	l.done = make(chan struct{})
+	l.stop = make(chan struct{})
+
+	if l.MaxConns > 0 {
+		go l.run()
+	}
+}
+
+func (l *LimitListener) run() {
+	defer close(l.stop)
+
+	const maxBackoff = 1 * time.Second
+
+	for attempt := 0; ; {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			if IsTemporary(err) {
+				attempt++
+				backoff := time.Duration(attempt*attempt) * 10 * time.Millisecond
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				time.Sleep(backoff)
+				continue
+			}
+			l.err = err
+			return
+		}
+
+		attempt = 0
+
+		select {
+		case l.slots <- struct{}{}:
+			l.deliver(conn)
+			continue
+		default:
+		}
+
+		if atomic.AddInt64(&l.queued, 1) > int64(l.MaxQueue) {
+			atomic.AddInt64(&l.queued, -1)
+			l.reject(conn)
+			continue
+		}
+
+		go l.wait(conn)
+	}
+}
+
+// wait blocks until a slot becomes available for conn, then delivers it to
+// Accept.
+func (l *LimitListener) wait(conn net.Conn) {
+	var timeout <-chan time.Time
+
+	if l.QueueTimeout > 0 {
+		timer := time.NewTimer(l.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		atomic.AddInt64(&l.queued, -1)
+		l.deliver(conn)
+	case <-timeout:
+		atomic.AddInt64(&l.queued, -1)
+		l.reject(conn)
+	case <-l.done:
+		atomic.AddInt64(&l.queued, -1)
+		conn.Close()
+	}
+}
+
+func (l *LimitListener) deliver(conn net.Conn) {
+	c := &limitConn{Conn: conn, release: l.release}
+
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *LimitListener) release() {
+	<-l.slots
+}
+
+func (l *LimitListener) reject(conn net.Conn) {
+	atomic.AddInt64(&l.rejected, 1)
+	conn.Close()
+}
+
+// limitConn is the connection type returned by LimitListener, it releases its
+// slot when it is closed.
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *limitConn) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(c.Conn, c, r)
+}
+
+func (c *limitConn) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(c.Conn, c, w)
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// IPLimitListener is a net.Listener which limits the number of concurrent
+// connections accepted from each client IP address, connections exceeding the
+// limit are closed right away.
+//
+// IPv6 clients usually get allocated whole networks, so their addresses are
+// aggregated by prefix before being counted. Connections which don't have an
+// IP address (unix sockets for example) are not limited.
+type IPLimitListener struct {
+	net.Listener
+
+	// MaxConnsPerIP is the maximum number of concurrent connections accepted
+	// from a single address. Zero or a negative value means no limit.
+	MaxConnsPerIP int
+
+	// IPv4PrefixLen and IPv6PrefixLen are the lengths of the network prefixes
+	// that client addresses are aggregated by. If zero, they default to 32
+	// for IPv4 (one address), and 64 for IPv6.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// Reset configures the listener to reset the connections it rejects,
+	// instead of closing them gracefully, which releases the resources
+	// associated with those connections faster.
+	Reset bool
+
+	mutex    sync.Mutex
+	counts   map[string]int
+	rejected int64
+}
+
+// Rejected returns the number of connections that were rejected because they
+// exceeded the limit.
+func (l *IPLimitListener) Rejected() int64 {
+	return atomic.LoadInt64(&l.rejected)
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *IPLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.MaxConnsPerIP <= 0 {
+			return conn, nil
+		}
+
+		key, ok := l.key(conn.RemoteAddr())
+		if !ok {
+			return conn, nil
+		}
+
+		if !l.acquire(key) {
+			atomic.AddInt64(&l.rejected, 1)
+			if l.Reset {
+				resetConn(conn)
+			} else {
+				conn.Close()
+			}
+			continue
+		}
+
+		return &limitConn{Conn: conn, release: func() { l.release(key) }}, nil
+	}
+}
+
+func (l *IPLimitListener) acquire(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+
+	if l.counts[key] >= l.MaxConnsPerIP {
+		return false
+	}
+
+	l.counts[key]++
+	return true
+}
+
+func (l *IPLimitListener) release(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if n := l.counts[key] - 1; n > 0 {
+		l.counts[key] = n
+	} else {
+		delete(l.counts, key)
+	}
+}
+
+// key returns the network prefix that addr is counted by.
+func (l *IPLimitListener) key(addr net.Addr) (string, bool) {
+	var ip net.IP
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return "", false
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		bits := l.IPv4PrefixLen
+		if bits <= 0 || bits > 32 {
+			bits = 32
+		}
+		return ip4.Mask(net.CIDRMask(bits, 32)).String(), true
+	}
+
+	bits := l.IPv6PrefixLen
+	if bits <= 0 || bits > 128 {
+		bits = 64
+	}
+	return ip.Mask(net.CIDRMask(bits, 128)).String(), true
+}
+
+// resetConn closes conn and makes the operating system send a RST instead of
+// a FIN, if conn is a TCP connection.
+func resetConn(conn net.Conn) {
+	if tcp, ok := BaseConn(conn).(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// RateLimitListener is a net.Listener which limits the rate at which it
+// accepts connections, using a token bucket. When the rate is exceeded Accept
+// waits before returning the next connection, leaving the excess connections
+// in the kernel backlog, which prevents a burst of clients from overwhelming
+// the handlers.
+//
+// Random early drop can be enabled to shed load before the backlog fills up,
+// connections are then rejected with a probability that increases as the
+// bucket empties.
+//
+// The limit can be changed while the listener is accepting connections by
+// calling SetLimit, the Rate and Burst fields must not be modified after the
+// first call to Accept.
+type RateLimitListener struct {
+	net.Listener
+
+	// Rate is the number of connections per second that the listener accepts.
+	// Zero or a negative value means no limit.
+	Rate int
+
+	// Burst is the number of connections that can be accepted at once, above
+	// the rate. If zero, it defaults to Rate.
+	Burst int
+
+	// EarlyDrop is the ratio of the bucket capacity, between 0 and 1, below
+	// which connections start being randomly dropped. The drop probability
+	// grows linearly from 0 at the threshold to 1 when the bucket is empty.
+	// If zero, random early drop is disabled.
+	EarlyDrop float64
+
+	mutex   sync.Mutex
+	init    bool
+	bucket  *tokenBucket
+	dropped int64
+}
+
+// Limit returns the rate and burst currently configured on the listener.
+func (l *RateLimitListener) Limit() (rate int, burst int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.Rate, l.Burst
+}
+
+// SetLimit changes the rate and burst of the listener, the change applies to
+// the next connections accepted by the listener, starting with a full bucket.
+// Like the Rate field, a rate of zero or less removes the limit.
+func (l *RateLimitListener) SetLimit(rate int, burst int) {
+	l.mutex.Lock()
+	l.Rate, l.Burst = rate, burst
+	l.bucket, l.init = newTokenBucketBurst(rate, burst), true
+	l.mutex.Unlock()
+}
+
+// Dropped returns the number of connections that were dropped by random early
+// drop.
+func (l *RateLimitListener) Dropped() int64 {
+	return atomic.LoadInt64(&l.dropped)
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *RateLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return conn, err
+		}
+
+		bucket := l.tokenBucket()
+		if bucket == nil {
+			return conn, nil
+		}
+
+		if l.EarlyDrop > 0 {
+			if level := bucket.level(); level < l.EarlyDrop && rand.Float64() >= level/l.EarlyDrop {
+				atomic.AddInt64(&l.dropped, 1)
+				conn.Close()
+				continue
+			}
+		}
+
+		bucket.take(1, "accept")
+		return conn, nil
+	}
+}
+
+// tokenBucket returns the token bucket of the listener, or nil if the rate of
+// connections is not limited.
+func (l *RateLimitListener) tokenBucket() *tokenBucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.init {
+		l.bucket, l.init = newTokenBucketBurst(l.Rate, l.Burst), true
+	}
+
+	return l.bucket
+}
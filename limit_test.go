@@ -0,0 +1,335 @@
+package netx
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &LimitListener{
+		Listener: l,
+		MaxConns: 1,
+		MaxQueue: 1,
+	}
+	defer lstn.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	c1 := dial()
+	defer c1.Close()
+
+	conn1, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2 := dial() // queued
+	defer c2.Close()
+	waitForLimitStats(t, lstn, func(s LimitStats) bool { return s.Queued == 1 })
+
+	c3 := dial() // rejected
+	defer c3.Close()
+	waitForLimitStats(t, lstn, func(s LimitStats) bool { return s.Rejected == 1 })
+
+	c3.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if _, err := c3.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("expected the rejected connection to be closed but got", err)
+	}
+
+	conn1.Close()
+
+	conn2, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	if a1, a2 := conn2.RemoteAddr().String(), c2.LocalAddr().String(); a1 != a2 {
+		t.Errorf("the queued connection was not accepted: %s != %s", a1, a2)
+	}
+
+	if s := lstn.Stats(); s != (LimitStats{Active: 1, Queued: 0, Accepted: 2, Rejected: 1}) {
+		t.Errorf("bad stats: %+v", s)
+	}
+}
+
+func TestLimitListenerQueueTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &LimitListener{
+		Listener:     l,
+		MaxConns:     1,
+		MaxQueue:     1,
+		QueueTimeout: 10 * time.Millisecond,
+	}
+	defer lstn.Close()
+
+	for i := 0; i != 2; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	conn, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitForLimitStats(t, lstn, func(s LimitStats) bool { return s.Rejected == 1 && s.Queued == 0 })
+}
+
+func TestLimitListenerClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &LimitListener{Listener: l, MaxConns: 1}
+	lstn.Close()
+
+	if _, err := lstn.Accept(); err == nil {
+		t.Error("expected an error when accepting on a closed listener")
+	}
+}
+
+func waitForLimitStats(t *testing.T, lstn *LimitListener, f func(LimitStats) bool) {
+	for i := 0; !f(lstn.Stats()); i++ {
+		if i == 100 {
+			t.Fatalf("bad stats: %+v", lstn.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestIPLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &IPLimitListener{
+		Listener:      l,
+		MaxConnsPerIP: 2,
+		Reset:         true,
+	}
+	defer lstn.Close()
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := lstn.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	for i := 0; i != 2; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	c1, c2 := <-conns, <-conns
+
+	// The connection may be reset before Dial returns.
+	if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		defer conn.Close()
+	}
+
+	for i := 0; lstn.Rejected() != 1; i++ {
+		if i == 100 {
+			t.Fatal("the third connection was not rejected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Closing one of the accepted connections frees a slot.
+	c1.Close()
+	defer c2.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case c3 := <-conns:
+		c3.Close()
+	case <-time.After(1 * time.Second):
+		t.Error("the connection was not accepted after a slot was released")
+	}
+}
+
+func TestIPLimitListenerKey(t *testing.T) {
+	lstn := &IPLimitListener{}
+
+	tests := []struct {
+		addr net.Addr
+		key  string
+		ok   bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}, "10.0.0.1", true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:3:4:5:6")}, "2001:db8:1:2::", true},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.addr.String(), func(t *testing.T) {
+			if key, ok := lstn.key(test.addr); key != test.key || ok != test.ok {
+				t.Errorf("bad key: %q %t", key, ok)
+			}
+		})
+	}
+}
+
+func TestRateLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &RateLimitListener{
+		Listener: l,
+		Rate:     20,
+		Burst:    1,
+	}
+	defer lstn.Close()
+
+	for i := 0; i != 3; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	start := time.Now()
+
+	for i := 0; i != 3; i++ {
+		conn, err := lstn.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	// The first connection uses the burst, the next two have to wait 50ms
+	// each.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Error("the accept rate was not limited:", elapsed)
+	}
+}
+
+func TestRateLimitListenerSetLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &RateLimitListener{
+		Listener: l,
+		Rate:     1,
+		Burst:    1,
+	}
+	defer lstn.Close()
+
+	for i := 0; i != 3; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	// The first connection uses the burst, the next ones would have to wait
+	// one second each if the limit wasn't removed.
+	start := time.Now()
+
+	for i := 0; i != 3; i++ {
+		conn, err := lstn.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		if i == 0 {
+			lstn.SetLimit(0, 0)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Error("the new limit was not applied:", elapsed)
+	}
+
+	if rate, burst := lstn.Limit(); rate != 0 || burst != 0 {
+		t.Errorf("bad limit: rate=%d burst=%d", rate, burst)
+	}
+}
+
+func TestRateLimitListenerEarlyDrop(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn := &RateLimitListener{
+		Listener:  l,
+		Rate:      1,
+		Burst:     1,
+		EarlyDrop: 1,
+	}
+	defer lstn.Close()
+
+	for i := 0; i != 2; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	// The bucket is full so the first connection is accepted, the second one
+	// comes when the bucket is (almost) empty and is dropped.
+	conn, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		if conn, err := lstn.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	for i := 0; lstn.Dropped() != 1; i++ {
+		if i == 100 {
+			t.Fatal("the connection was not dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
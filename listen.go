@@ -1,6 +1,8 @@
 package netx
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -14,17 +16,48 @@ import (
 //
 // The function accepts addresses that may be prefixed by a URL scheme to set
 // the protocol that will be used, supported protocols are tcp, tcp4, tcp6,
-// unix, unixpacket, and fd.
+// unix, unixpacket, tls, and fd.
 //
 // The address may contain a path to a file for unix sockets, a pair of an IP
 // address and port, a pair of a network interface name and port, or just port.
 //
 // If the port is omitted for network addresses the operating system will pick
 // one automatically.
+//
+// Addresses using the tls protocol must set the paths to the certificate and
+// private key in the cert and key query parameters, the ca parameter may also
+// be set to require clients to present a certificate signed by one of the
+// authorities of the PEM file, for example:
+//
+//	tls://:443?cert=/etc/ssl/server.crt&key=/etc/ssl/server.key
+//
+// Examples of other addresses are tcp://0.0.0.0:8080, unix:///var/run/app.sock
+// or fd://3.
 func Listen(address string) (lstn net.Listener, err error) {
+	return listenConfig(address, nil)
+}
+
+// listenConfig implements Listen, using config to create the sockets if it is
+// not nil.
+func listenConfig(address string, config *net.ListenConfig) (lstn net.Listener, err error) {
 	var network string
 	var addrs []string
 
+	if strings.HasPrefix(address, "tls://") {
+		var tlsConfig *tls.Config
+
+		if address, tlsConfig, err = parseTLSAddress(address[6:], true); err != nil {
+			return
+		}
+
+		if lstn, err = listenConfig("tcp://"+address, config); err != nil {
+			return
+		}
+
+		lstn = &tlsListener{Listener: tls.NewListener(lstn, tlsConfig)}
+		return
+	}
+
 	if network, addrs, err = resolveListen(address, "tcp", "unix", []string{
 		"tcp",
 		"tcp4",
@@ -37,17 +70,18 @@ func Listen(address string) (lstn net.Listener, err error) {
 	}
 
 	if len(addrs) == 1 {
-		return listen(network, addrs[0])
+		return listen(network, addrs[0], config)
 	}
 
 	lstns := make([]net.Listener, 0, len(addrs))
 
 	for _, a := range addrs {
-		l, e := listen(network, a)
+		l, e := listen(network, a, config)
 		if e != nil {
 			for _, l := range lstns {
 				l.Close()
 			}
+			err = e
 			return
 		}
 		lstns = append(lstns, l)
@@ -57,7 +91,7 @@ func Listen(address string) (lstn net.Listener, err error) {
 	return
 }
 
-func listen(network string, address string) (lstn net.Listener, err error) {
+func listen(network string, address string, config *net.ListenConfig) (lstn net.Listener, err error) {
 	if network == "fd" {
 		var fd int
 		var f *os.File
@@ -79,6 +113,9 @@ func listen(network string, address string) (lstn net.Listener, err error) {
 		}
 		return NewRecvUnixListener(c.(*net.UnixConn)), nil
 	}
+	if config != nil {
+		return config.Listen(context.Background(), network, address)
+	}
 	return net.Listen(network, address)
 }
 
@@ -222,6 +259,46 @@ func resolveListen(address string, defaultProtoNetwork string, defaultProtoUnix
 	return
 }
 
+// tlsListener is the type of listeners created for tls:// addresses, it
+// reports their network as "tls".
+type tlsListener struct {
+	net.Listener
+}
+
+func (l *tlsListener) Addr() net.Addr {
+	return &NetAddr{Net: "tls", Addr: l.Listener.Addr().String()}
+}
+
+// ListenAll creates listeners on all the given addresses, which are any of the
+// addresses supported by Listen, and returns them as a single listener.
+//
+// The returned listener accepts connections from all the addresses, its Addr
+// method returns a MultiAddr, and closing it closes all the listeners. If one
+// of the addresses cannot be listened on, the listeners already created are
+// closed and the error is returned.
+//
+// Note that listening on an unspecified IPv6 address like [::]:80 usually
+// accepts IPv4 connections as well, programs that need separate listeners for
+// both families should use tcp4://0.0.0.0:80 and tcp6://[::]:80.
+func ListenAll(addresses ...string) (lstn net.Listener, err error) {
+	lstns := make([]net.Listener, 0, len(addresses))
+
+	for _, a := range addresses {
+		l, e := Listen(a)
+		if e != nil {
+			for _, l := range lstns {
+				l.Close()
+			}
+			err = e
+			return
+		}
+		lstns = append(lstns, l)
+	}
+
+	lstn = MultiListener(lstns...)
+	return
+}
+
 // MultiListener returns a compound listener made of the given list of
 // listeners.
 func MultiListener(lstn ...net.Listener) net.Listener {
@@ -0,0 +1,80 @@
+package netx
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addresses := []string{
+		"tcp4://127.0.0.1:0",
+		"127.0.0.1:0",
+		"unix://" + filepath.Join(dir, "test.sock"),
+	}
+
+	if l, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		l.Close()
+		addresses = append(addresses, "tcp6://[::1]:0")
+	}
+
+	lstn, err := ListenAll(addresses...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, ok := lstn.Addr().(MultiAddr)
+	if !ok {
+		t.Fatalf("bad address type: %T", lstn.Addr())
+	}
+	if len(addrs) != len(addresses) {
+		t.Fatal("bad number of addresses:", addrs)
+	}
+
+	for _, a := range addrs {
+		conn, err := net.Dial(a.Network(), a.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		accepted, err := lstn.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted.Close()
+	}
+
+	if err := lstn.Close(); err != nil {
+		t.Error(err)
+	}
+
+	for _, a := range addrs {
+		if conn, err := net.Dial(a.Network(), a.String()); err == nil {
+			conn.Close()
+			t.Error("the listener on", a, "was not closed")
+		}
+	}
+}
+
+func TestListenAllError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lstn, err := ListenAll("127.0.0.1:0", l.Addr().String())
+	if err == nil {
+		lstn.Close()
+		t.Fatal("no error returned when an address was already in use")
+	}
+}
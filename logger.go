@@ -0,0 +1,161 @@
+package netx
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Logger is the interface of the structured loggers that servers, proxies,
+// and other components use to report errors and events which cannot be
+// returned to the program.
+//
+// Messages are short and constant, the details are passed as alternating keys
+// and values, for example:
+//
+//	logger.Warn("accept error", "err", err, "retry", backoff)
+//
+// *slog.Logger implements the interface, so programs using the log/slog
+// package can pass their logger directly. Loggers with printf-style leveled
+// methods, like the ones of github.com/sirupsen/logrus, are adapted with
+// NewPrintfLogger, and *log.Logger with NewStdLogger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// DiscardLogger is a Logger which discards all messages.
+var DiscardLogger Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...interface{}) {}
+func (discardLogger) Info(string, ...interface{})  {}
+func (discardLogger) Warn(string, ...interface{})  {}
+func (discardLogger) Error(string, ...interface{}) {}
+
+// NewStdLogger returns a Logger which writes messages to l, or to the standard
+// logger of the log package if l is nil. Messages are formatted on a single
+// line with the level, the message, and the key/value pairs:
+//
+//	[warn] accept error err="accept tcp [::]:80: too many open files" retry=1s
+//
+// Values spanning multiple lines, like stack traces, are written as is on the
+// lines following the message.
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+}
+
+func (l stdLogger) Debug(msg string, keyvals ...interface{}) { l.log("debug", msg, keyvals) }
+func (l stdLogger) Info(msg string, keyvals ...interface{})  { l.log("info", msg, keyvals) }
+func (l stdLogger) Warn(msg string, keyvals ...interface{})  { l.log("warn", msg, keyvals) }
+func (l stdLogger) Error(msg string, keyvals ...interface{}) { l.log("error", msg, keyvals) }
+
+func (l stdLogger) log(level string, msg string, keyvals []interface{}) {
+	var inline []interface{}
+	var multiline []string
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			if v, ok := keyvals[i+1].(string); ok && strings.Contains(v, "\n") {
+				multiline = append(multiline, strings.TrimRight(v, "\n"))
+				continue
+			}
+		}
+		inline = append(inline, keyvals[i:min(i+2, len(keyvals))]...)
+	}
+
+	s := "[" + level + "] " + formatLog(msg, inline)
+	for _, v := range multiline {
+		s += "\n" + v
+	}
+	if l.logger == nil {
+		log.Print(s)
+	} else {
+		l.logger.Print(s)
+	}
+}
+
+// PrintfLogger is implemented by loggers with printf-style leveled methods,
+// like the loggers and entries of github.com/sirupsen/logrus or the sugared
+// loggers of go.uber.org/zap.
+type PrintfLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NewPrintfLogger adapts l to the Logger interface, the key/value pairs are
+// appended to the messages in the key=value format.
+func NewPrintfLogger(l PrintfLogger) Logger {
+	return printfLogger{l}
+}
+
+type printfLogger struct {
+	logger PrintfLogger
+}
+
+func (l printfLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debugf("%s", formatLog(msg, keyvals))
+}
+
+func (l printfLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Infof("%s", formatLog(msg, keyvals))
+}
+
+func (l printfLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Warnf("%s", formatLog(msg, keyvals))
+}
+
+func (l printfLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Errorf("%s", formatLog(msg, keyvals))
+}
+
+// formatLog formats msg followed by the key/value pairs, values containing
+// spaces or special characters are quoted so the output remains on a single
+// line and can be parsed back.
+func formatLog(msg string, keyvals []interface{}) string {
+	b := &strings.Builder{}
+	b.WriteString(msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		var key, value interface{} = keyvals[i], "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		b.WriteByte(' ')
+		b.WriteString(formatLogValue(key))
+		b.WriteByte('=')
+		b.WriteString(formatLogValue(value))
+	}
+
+	return b.String()
+}
+
+func formatLogValue(v interface{}) string {
+	s := fmt.Sprint(v)
+
+	if len(s) == 0 {
+		return `""`
+	}
+
+	for _, c := range s {
+		if c == '"' || c == '=' || unicode.IsSpace(c) || !unicode.IsPrint(c) {
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
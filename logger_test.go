@@ -0,0 +1,238 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records the messages it receives in the std logger format.
+type testLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.log("debug", msg, keyvals) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.log("info", msg, keyvals) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.log("warn", msg, keyvals) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.log("error", msg, keyvals) }
+
+func (l *testLogger) log(level string, msg string, keyvals []interface{}) {
+	l.mutex.Lock()
+	l.lines = append(l.lines, "["+level+"] "+formatLog(msg, keyvals))
+	l.mutex.Unlock()
+}
+
+func (l *testLogger) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// printfRecorder implements PrintfLogger like logrus loggers do.
+type printfRecorder struct {
+	lines []string
+}
+
+func (r *printfRecorder) Debugf(f string, a ...interface{}) { r.printf("debug", f, a) }
+func (r *printfRecorder) Infof(f string, a ...interface{})  { r.printf("info", f, a) }
+func (r *printfRecorder) Warnf(f string, a ...interface{})  { r.printf("warn", f, a) }
+func (r *printfRecorder) Errorf(f string, a ...interface{}) { r.printf("error", f, a) }
+
+func (r *printfRecorder) printf(level string, format string, args []interface{}) {
+	r.lines = append(r.lines, level+": "+fmt.Sprintf(format, args...))
+}
+
+func TestFormatLog(t *testing.T) {
+	tests := []struct {
+		msg     string
+		keyvals []interface{}
+		out     string
+	}{
+		{
+			msg: "hello",
+			out: "hello",
+		},
+		{
+			msg:     "accept error",
+			keyvals: []interface{}{"err", errors.New("too many open files"), "retry", time.Second},
+			out:     `accept error err="too many open files" retry=1s`,
+		},
+		{
+			msg:     "values",
+			keyvals: []interface{}{"empty", "", "quote", `a"b`, "line", "a\nb", "n", 42},
+			out:     `values empty="" quote="a\"b" line="a\nb" n=42`,
+		},
+		{
+			msg:     "odd",
+			keyvals: []interface{}{"key"},
+			out:     "odd key=(MISSING)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			if out := formatLog(test.msg, test.keyvals); out != test.out {
+				t.Error("bad output:", out)
+			}
+		})
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewStdLogger(log.New(buf, "", 0))
+
+	logger.Debug("a")
+	logger.Info("b", "k", 1)
+	logger.Warn("c", "k", "v w")
+	logger.Error("d")
+	logger.Error("e", "stack", "f()\n\tf.go:1\n", "k", 2)
+
+	if s := buf.String(); s != "[debug] a\n[info] b k=1\n[warn] c k=\"v w\"\n[error] d\n[error] e k=2\nf()\n\tf.go:1\n" {
+		t.Error("bad output:", s)
+	}
+}
+
+func TestPrintfLogger(t *testing.T) {
+	rec := &printfRecorder{}
+	logger := NewPrintfLogger(rec)
+
+	logger.Debug("a")
+	logger.Info("b", "k", 1)
+	logger.Warn("100%", "k", "%d")
+	logger.Error("d")
+
+	if s := strings.Join(rec.lines, "\n"); s != "debug: a\ninfo: b k=1\nwarn: 100% k=%d\nerror: d" {
+		t.Error("bad output:", s)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	var logger Logger = slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+
+	logger.Warn("accept error", "retry", time.Second)
+
+	if s := buf.String(); s != "level=WARN msg=\"accept error\" retry=1s\n" {
+		t.Error("bad output:", s)
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	logger := &testLogger{}
+
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) { panic("oops") }),
+		Logger:  logger,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Serve(lstn)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Read(make([]byte, 1))
+	conn.Close()
+
+	server.Close()
+	<-done
+
+	if s := logger.String(); !strings.HasPrefix(s, "[error] panic serving local=") || !strings.Contains(s, "panic=oops") {
+		t.Error("bad log output:", s)
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		Handler:  HandlerFunc(func(ctx context.Context, conn net.Conn) { panic("oops") }),
+		ErrorLog: log.New(buf, "", 0),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Serve(lstn)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Read(make([]byte, 1))
+	conn.Close()
+
+	server.Close()
+	<-done
+
+	// Without a structured logger the panics are reported in the format of
+	// the ErrorLog messages, with the stack on the following lines.
+	lines := strings.Split(buf.String(), "\n")
+
+	if !strings.HasPrefix(lines[0], "panic serving 127.0.0.1:") || !strings.HasSuffix(lines[0], ": oops") {
+		t.Error("bad log message:", lines[0])
+	}
+	if len(lines) < 3 || !strings.HasPrefix(lines[1], "goroutine ") {
+		t.Error("the stack was not written on the following lines:", lines)
+	}
+}
+
+func TestTunnelLogger(t *testing.T) {
+	logger := &testLogger{}
+	errDial := errors.New("unreachable")
+
+	tunnel := &Tunnel{
+		Handler: TunnelRaw,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errDial
+		},
+		Logger: logger,
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	target := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}
+	tunnel.ServeProxy(context.Background(), c1, target)
+
+	if _, err := c2.Read(make([]byte, 1)); err == nil {
+		t.Error("the client connection was not closed")
+	}
+
+	if s := logger.String(); s != "[warn] tunnel dial error remote=pipe target=10.0.0.1:80 err=unreachable" {
+		t.Error("bad log output:", s)
+	}
+}
@@ -0,0 +1,364 @@
+package mdnsx
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBrowseInterval is the default amount of time between two queries sent
+// by a Browser.
+const DefaultBrowseInterval = 10 * time.Second
+
+var errNoService = errors.New("no service instance discovered")
+
+// Browser discovers the instances of a service type advertised over mDNS on
+// the local network, and keeps track of them as they come and go.
+//
+// The browser sends its queries from an ephemeral port and asks for unicast
+// responses, so it doesn't need to share the mDNS port with other programs
+// running on the host.
+//
+// The Backend method makes it possible to use the browser as a udpx.Balancer,
+// or to plug the discovered addresses into other components which select
+// backends dynamically. The OnChange callback may also be set to be notified
+// when the list of instances changes.
+type Browser struct {
+	// Service is the type of service to browse, for example "_http._tcp".
+	Service string
+
+	// Domain is the domain to browse. If empty, DefaultDomain is used.
+	Domain string
+
+	// Addr is the address that queries are sent to. If empty, DefaultAddr is
+	// used.
+	Addr string
+
+	// Interval is the amount of time between two queries. If zero,
+	// DefaultBrowseInterval is used.
+	Interval time.Duration
+
+	// OnChange, if not nil, is called with the list of discovered instances
+	// when it changes.
+	OnChange func([]*Service)
+
+	// ErrorLog is used to report errors that occur while sending queries. If
+	// nil, the standard logger is used.
+	ErrorLog *log.Logger
+
+	mutex     sync.Mutex
+	instances map[string]*browseEntry // instance name => entry
+	hosts     map[string][]hostIP     // host name => addresses
+	next      int
+}
+
+type browseEntry struct {
+	instance string
+	host     string
+	port     int
+	text     []string
+	expires  time.Time
+}
+
+type hostIP struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// Run sends queries and collects the responses until ctx is canceled, it then
+// returns the context error.
+func (b *Browser) Run(ctx context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", b.addr())
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsMessage
+			if msg.unmarshal(buf[:n]) == nil && msg.response() {
+				b.update(msg.records(), time.Now())
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(b.interval())
+	defer ticker.Stop()
+
+	for {
+		b.expire(time.Now())
+
+		if _, err := conn.WriteTo(b.query().appendTo(make([]byte, 0, 512)), addr); err != nil && ctx.Err() == nil {
+			b.logf("mdnsx: querying %s: %s", addr, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Services returns the list of discovered service instances whose port and
+// addresses are known, sorted by name.
+func (b *Browser) Services() []*Service {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.services()
+}
+
+// Addrs returns the host:port addresses of the discovered service instances.
+func (b *Browser) Addrs() []string {
+	var addrs []string
+	for _, svc := range b.Services() {
+		addrs = append(addrs, svc.Addrs()...)
+	}
+	return addrs
+}
+
+// Backend returns the address of one of the discovered service instances,
+// cycling through them on each call.
+func (b *Browser) Backend(client net.Addr) (string, error) {
+	addrs := b.Addrs()
+	if len(addrs) == 0 {
+		return "", errNoService
+	}
+
+	b.mutex.Lock()
+	i := b.next
+	b.next++
+	b.mutex.Unlock()
+
+	return addrs[i%len(addrs)], nil
+}
+
+func (b *Browser) typeName() string {
+	return b.Service + "." + domain(b.Domain)
+}
+
+// query builds the next query of the browser, it asks for the service type,
+// and for the records of instances and hosts that weren't resolved yet.
+func (b *Browser) query() *dnsMessage {
+	msg := &dnsMessage{
+		questions: []dnsQuestion{{name: b.typeName(), typ: typePTR, class: classIN | classUnique}},
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for name, entry := range b.instances {
+		switch {
+		case entry.port == 0:
+			msg.questions = append(msg.questions, dnsQuestion{name: name, typ: typeSRV, class: classIN | classUnique})
+		case len(b.hosts[strings.ToLower(entry.host)]) == 0:
+			msg.questions = append(msg.questions, dnsQuestion{name: entry.host, typ: typeA, class: classIN | classUnique})
+		}
+	}
+
+	return msg
+}
+
+// update applies the records of a response received at time now.
+func (b *Browser) update(records []dnsRecord, now time.Time) {
+	typeName := b.typeName()
+
+	b.mutex.Lock()
+	before := b.services()
+
+	if b.instances == nil {
+		b.instances = make(map[string]*browseEntry)
+		b.hosts = make(map[string][]hostIP)
+	}
+
+	// Records are applied by type since the SRV and TXT records can only be
+	// associated with an instance that was discovered by a PTR record.
+	for _, typ := range []uint16{typePTR, typeSRV, typeTXT, typeA} {
+		for _, rr := range records {
+			if rr.typ != typ && !(typ == typeA && rr.typ == typeAAAA) {
+				continue
+			}
+
+			expires := now.Add(time.Duration(rr.ttl) * time.Second)
+			name := strings.ToLower(fqdn(rr.name))
+
+			switch typ {
+			case typePTR:
+				if !equalName(rr.name, typeName) {
+					continue
+				}
+				target := strings.ToLower(fqdn(rr.target))
+				if !strings.HasSuffix(target, "."+strings.ToLower(typeName)) {
+					continue
+				}
+				if rr.ttl == 0 {
+					delete(b.instances, target)
+					continue
+				}
+				entry := b.instances[target]
+				if entry == nil {
+					entry = &browseEntry{instance: fqdn(rr.target)[:len(target)-len(typeName)-1]}
+					b.instances[target] = entry
+				}
+				entry.expires = expires
+
+			case typeSRV:
+				if entry := b.instances[name]; entry != nil {
+					entry.host, entry.port = fqdn(rr.target), int(rr.port)
+				}
+
+			case typeTXT:
+				if entry := b.instances[name]; entry != nil {
+					entry.text = rr.text
+				}
+
+			case typeA:
+				b.hosts[name] = updateHostIPs(b.hosts[name], rr.ip, expires, rr.ttl == 0)
+			}
+		}
+	}
+
+	after := b.services()
+	b.mutex.Unlock()
+
+	b.notify(before, after)
+}
+
+func updateHostIPs(ips []hostIP, ip net.IP, expires time.Time, goodbye bool) []hostIP {
+	for i := range ips {
+		if ips[i].ip.Equal(ip) {
+			if goodbye {
+				return append(ips[:i], ips[i+1:]...)
+			}
+			ips[i].expires = expires
+			return ips
+		}
+	}
+	if goodbye {
+		return ips
+	}
+	return append(ips, hostIP{ip: ip, expires: expires})
+}
+
+// expire removes the instances and addresses whose records expired at time
+// now.
+func (b *Browser) expire(now time.Time) {
+	b.mutex.Lock()
+	before := b.services()
+
+	for name, entry := range b.instances {
+		if !now.Before(entry.expires) {
+			delete(b.instances, name)
+		}
+	}
+
+	for name, ips := range b.hosts {
+		live := ips[:0]
+		for _, ip := range ips {
+			if now.Before(ip.expires) {
+				live = append(live, ip)
+			}
+		}
+		if len(live) == 0 {
+			delete(b.hosts, name)
+		} else {
+			b.hosts[name] = live
+		}
+	}
+
+	after := b.services()
+	b.mutex.Unlock()
+
+	b.notify(before, after)
+}
+
+func (b *Browser) services() []*Service {
+	services := make([]*Service, 0, len(b.instances))
+
+	for _, entry := range b.instances {
+		ips := b.hosts[strings.ToLower(entry.host)]
+		if entry.port == 0 || len(ips) == 0 {
+			continue
+		}
+
+		svc := &Service{
+			Instance: entry.instance,
+			Service:  b.Service,
+			Domain:   domain(b.Domain),
+			Host:     entry.host,
+			Port:     entry.port,
+			Text:     entry.text,
+		}
+		for _, ip := range ips {
+			svc.IPs = append(svc.IPs, ip.ip)
+		}
+		services = append(services, svc)
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Instance < services[j].Instance
+	})
+	return services
+}
+
+func (b *Browser) notify(before []*Service, after []*Service) {
+	if b.OnChange == nil || sameServices(before, after) {
+		return
+	}
+	b.OnChange(after)
+}
+
+func sameServices(s1 []*Service, s2 []*Service) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for i := range s1 {
+		if s1[i].Instance != s2[i].Instance || strings.Join(s1[i].Addrs(), ",") != strings.Join(s2[i].Addrs(), ",") || strings.Join(s1[i].Text, ",") != strings.Join(s2[i].Text, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Browser) addr() string {
+	if len(b.Addr) != 0 {
+		return b.Addr
+	}
+	return DefaultAddr
+}
+
+func (b *Browser) interval() time.Duration {
+	if b.Interval > 0 {
+		return b.Interval
+	}
+	return DefaultBrowseInterval
+}
+
+func (b *Browser) logf(format string, args ...interface{}) {
+	if b.ErrorLog != nil {
+		b.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,105 @@
+package mdnsx
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBrowser(t *testing.T) {
+	r, addr := startResponder(t,
+		&Service{Instance: "web-1", Service: "_http._tcp", Host: "host-1.local.", Port: 8080, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		&Service{Instance: "web-2", Service: "_http._tcp", Host: "host-2.local.", Port: 8080, IPs: []net.IP{net.IPv4(10, 0, 0, 2)}},
+		&Service{Instance: "ssh", Service: "_ssh._tcp", Host: "host-1.local.", Port: 22, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+	)
+	defer r.Close()
+
+	changes := make(chan []*Service, 10)
+
+	b := &Browser{
+		Service:  "_http._tcp",
+		Addr:     addr.String(),
+		Interval: 50 * time.Millisecond,
+		OnChange: func(services []*Service) { changes <- services },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go b.Run(ctx)
+
+	for {
+		select {
+		case services := <-changes:
+			if len(services) != 2 {
+				continue
+			}
+			if services[0].Name() != "web-1._http._tcp.local." || services[1].Name() != "web-2._http._tcp.local." {
+				t.Fatalf("bad services: %+v %+v", services[0], services[1])
+			}
+
+			addrs := b.Addrs()
+			if len(addrs) != 2 || addrs[0] != "10.0.0.1:8080" || addrs[1] != "10.0.0.2:8080" {
+				t.Fatal("bad addresses:", addrs)
+			}
+
+			b1, _ := b.Backend(nil)
+			b2, _ := b.Backend(nil)
+			if b1 == b2 {
+				t.Error("backends are not balanced:", b1, b2)
+			}
+			return
+
+		case <-ctx.Done():
+			t.Fatal("services were not discovered:", b.Services())
+		}
+	}
+}
+
+func TestBrowserGoodbye(t *testing.T) {
+	b := &Browser{Service: "_http._tcp"}
+	now := time.Now()
+
+	b.update([]dnsRecord{
+		{name: "_http._tcp.local.", typ: typePTR, ttl: 120, target: "web-1._http._tcp.local."},
+		{name: "web-1._http._tcp.local.", typ: typeSRV, ttl: 120, target: "host.local.", port: 80},
+		{name: "host.local.", typ: typeA, ttl: 120, ip: net.IP{10, 0, 0, 1}},
+	}, now)
+
+	if addrs := b.Addrs(); len(addrs) != 1 || addrs[0] != "10.0.0.1:80" {
+		t.Fatal("bad addresses:", addrs)
+	}
+
+	b.update([]dnsRecord{
+		{name: "_http._tcp.local.", typ: typePTR, ttl: 0, target: "web-1._http._tcp.local."},
+	}, now)
+
+	if services := b.Services(); len(services) != 0 {
+		t.Error("service not removed after goodbye:", services)
+	}
+
+	if _, err := b.Backend(nil); err == nil {
+		t.Error("expected an error when no services are available")
+	}
+}
+
+func TestBrowserExpire(t *testing.T) {
+	b := &Browser{Service: "_http._tcp"}
+	now := time.Now()
+
+	b.update([]dnsRecord{
+		{name: "_http._tcp.local.", typ: typePTR, ttl: 10, target: "web-1._http._tcp.local."},
+		{name: "web-1._http._tcp.local.", typ: typeSRV, ttl: 10, target: "host.local.", port: 80},
+		{name: "host.local.", typ: typeA, ttl: 10, ip: net.IP{10, 0, 0, 1}},
+	}, now)
+
+	b.expire(now.Add(5 * time.Second))
+	if len(b.Services()) != 1 {
+		t.Fatal("service expired too early")
+	}
+
+	b.expire(now.Add(10 * time.Second))
+	if len(b.Services()) != 0 {
+		t.Fatal("service not expired")
+	}
+}
@@ -0,0 +1,370 @@
+package mdnsx
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultDomain is the domain that services are advertised and browsed in
+	// when none is set.
+	DefaultDomain = "local."
+
+	// DefaultAddr is the address of the mDNS multicast group.
+	DefaultAddr = "224.0.0.251:5353"
+)
+
+// Service represents an instance of a service advertised over DNS-SD (RFC
+// 6763), for example a HTTP server advertised as "web-1._http._tcp.local.".
+type Service struct {
+	// Instance is the name of the service instance, it must not contain dots.
+	Instance string
+
+	// Service is the type of service, for example "_http._tcp".
+	Service string
+
+	// Domain is the domain that the service is advertised in. If empty,
+	// DefaultDomain is used.
+	Domain string
+
+	// Host is the name of the host that the service runs on. When advertising
+	// a service it defaults to the host name followed by the domain.
+	Host string
+
+	// Port is the port that the service accepts connections on.
+	Port int
+
+	// IPs is the list of addresses of the host. When advertising a service it
+	// defaults to the addresses of the network interface that the responder
+	// is bound to, or of all interfaces.
+	IPs []net.IP
+
+	// Text is the list of key=value pairs published in the TXT record of the
+	// service.
+	Text []string
+}
+
+// Name returns the fully qualified name of the service instance.
+func (s *Service) Name() string {
+	return s.Instance + "." + s.typeName()
+}
+
+// Addrs returns the list of host:port addresses that the service can be
+// reached at.
+func (s *Service) Addrs() []string {
+	addrs := make([]string, len(s.IPs))
+	for i, ip := range s.IPs {
+		addrs[i] = net.JoinHostPort(ip.String(), strconv.Itoa(s.Port))
+	}
+	return addrs
+}
+
+func (s *Service) typeName() string {
+	return s.Service + "." + domain(s.Domain)
+}
+
+func domain(d string) string {
+	if len(d) == 0 {
+		return DefaultDomain
+	}
+	return fqdn(d)
+}
+
+func fqdn(name string) string {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeTXT  = 16
+	typeAAAA = 28
+	typeSRV  = 33
+	typeANY  = 255
+
+	classIN  = 1
+	classANY = 255
+
+	// The top bit of the class is the unicast-response bit in questions, and
+	// the cache-flush bit in records (RFC 6762 sections 5.4 and 10.2).
+	classMask   = 0x7fff
+	classUnique = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+
+	maxMessageSize = 9000
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+type dnsMessage struct {
+	id          uint16
+	flags       uint16
+	questions   []dnsQuestion
+	answers     []dnsRecord
+	additionals []dnsRecord
+}
+
+type dnsQuestion struct {
+	name  string
+	typ   uint16
+	class uint16
+}
+
+type dnsRecord struct {
+	name  string
+	typ   uint16
+	class uint16
+	ttl   uint32
+
+	target   string // PTR and SRV
+	port     uint16 // SRV
+	priority uint16 // SRV
+	weight   uint16 // SRV
+	text     []string
+	ip       net.IP
+}
+
+func (m *dnsMessage) response() bool {
+	return m.flags&flagResponse != 0
+}
+
+func (m *dnsMessage) records() []dnsRecord {
+	return append(m.answers[:len(m.answers):len(m.answers)], m.additionals...)
+}
+
+// appendTo appends the wire representation of m to b, names are not
+// compressed.
+func (m *dnsMessage) appendTo(b []byte) []byte {
+	b = appendUint16(b, m.id)
+	b = appendUint16(b, m.flags)
+	b = appendUint16(b, uint16(len(m.questions)))
+	b = appendUint16(b, uint16(len(m.answers)))
+	b = appendUint16(b, 0)
+	b = appendUint16(b, uint16(len(m.additionals)))
+
+	for _, q := range m.questions {
+		b = appendName(b, q.name)
+		b = appendUint16(b, q.typ)
+		b = appendUint16(b, q.class)
+	}
+
+	for _, rr := range m.records() {
+		b = appendName(b, rr.name)
+		b = appendUint16(b, rr.typ)
+		b = appendUint16(b, rr.class)
+		b = append(b, byte(rr.ttl>>24), byte(rr.ttl>>16), byte(rr.ttl>>8), byte(rr.ttl))
+
+		b = append(b, 0, 0)
+		n := len(b)
+
+		switch rr.typ {
+		case typeA:
+			b = append(b, rr.ip.To4()...)
+		case typeAAAA:
+			b = append(b, rr.ip.To16()...)
+		case typePTR:
+			b = appendName(b, rr.target)
+		case typeSRV:
+			b = appendUint16(b, rr.priority)
+			b = appendUint16(b, rr.weight)
+			b = appendUint16(b, rr.port)
+			b = appendName(b, rr.target)
+		case typeTXT:
+			for _, s := range rr.text {
+				if len(s) > 255 {
+					s = s[:255]
+				}
+				b = append(b, byte(len(s)))
+				b = append(b, s...)
+			}
+			if len(rr.text) == 0 {
+				b = append(b, 0)
+			}
+		}
+
+		binary.BigEndian.PutUint16(b[n-2:], uint16(len(b)-n))
+	}
+
+	return b
+}
+
+func (m *dnsMessage) unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return errMalformed
+	}
+
+	m.id = binary.BigEndian.Uint16(b[0:])
+	m.flags = binary.BigEndian.Uint16(b[2:])
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+	nscount := int(binary.BigEndian.Uint16(b[8:]))
+	arcount := int(binary.BigEndian.Uint16(b[10:]))
+	off := 12
+
+	m.questions = make([]dnsQuestion, 0, qdcount)
+	for i := 0; i < qdcount; i++ {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return err
+		}
+		if off = n + 4; off > len(b) {
+			return errMalformed
+		}
+		m.questions = append(m.questions, dnsQuestion{
+			name:  name,
+			typ:   binary.BigEndian.Uint16(b[n:]),
+			class: binary.BigEndian.Uint16(b[n+2:]),
+		})
+	}
+
+	m.answers, m.additionals = nil, nil
+
+	for i := 0; i < ancount+nscount+arcount; i++ {
+		rr, n, err := readRecord(b, off)
+		if err != nil {
+			return err
+		}
+		off = n
+
+		switch {
+		case i < ancount:
+			m.answers = append(m.answers, rr)
+		case i >= ancount+nscount:
+			m.additionals = append(m.additionals, rr)
+		}
+	}
+
+	return nil
+}
+
+func readRecord(b []byte, off int) (rr dnsRecord, next int, err error) {
+	if rr.name, off, err = readName(b, off); err != nil {
+		return
+	}
+	if off+10 > len(b) {
+		err = errMalformed
+		return
+	}
+
+	rr.typ = binary.BigEndian.Uint16(b[off:])
+	rr.class = binary.BigEndian.Uint16(b[off+2:])
+	rr.ttl = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	next = off + length
+
+	if next > len(b) {
+		err = errMalformed
+		return
+	}
+	data := b[off:next]
+
+	switch rr.typ {
+	case typeA, typeAAAA:
+		if len(data) != net.IPv4len && len(data) != net.IPv6len {
+			err = errMalformed
+			return
+		}
+		rr.ip = append(net.IP(nil), data...)
+	case typePTR:
+		rr.target, _, err = readName(b, off)
+	case typeSRV:
+		if len(data) < 7 {
+			err = errMalformed
+			return
+		}
+		rr.priority = binary.BigEndian.Uint16(data[0:])
+		rr.weight = binary.BigEndian.Uint16(data[2:])
+		rr.port = binary.BigEndian.Uint16(data[4:])
+		rr.target, _, err = readName(b, off+6)
+	case typeTXT:
+		for len(data) != 0 {
+			n := int(data[0]) + 1
+			if n > len(data) {
+				err = errMalformed
+				return
+			}
+			if n > 1 {
+				rr.text = append(rr.text, string(data[1:n]))
+			}
+			data = data[n:]
+		}
+	}
+
+	return
+}
+
+// readName reads the name at offset off of b, following compression pointers,
+// and returns the offset of the next field.
+func readName(b []byte, off int) (string, int, error) {
+	var name []byte
+	next := -1
+
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMalformed
+		}
+		n := int(b[off])
+
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if len(name) == 0 {
+				name = append(name, '.')
+			}
+			return string(name), next, nil
+
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			if jumps++; jumps > 64 {
+				return "", 0, errMalformed
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errMalformed
+			}
+			name = append(name, b[off+1:off+1+n]...)
+			name = append(name, '.')
+			off += 1 + n
+		}
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func equalName(a string, b string) bool {
+	return strings.EqualFold(fqdn(a), fqdn(b))
+}
@@ -0,0 +1,61 @@
+package mdnsx
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDNSMessage(t *testing.T) {
+	msg := &dnsMessage{
+		id:        42,
+		flags:     flagResponse | flagAuthoritative,
+		questions: []dnsQuestion{{name: "_http._tcp.local.", typ: typePTR, class: classIN | classUnique}},
+		answers: []dnsRecord{
+			{name: "_http._tcp.local.", typ: typePTR, class: classIN, ttl: 120, target: "web-1._http._tcp.local."},
+		},
+		additionals: []dnsRecord{
+			{name: "web-1._http._tcp.local.", typ: typeSRV, class: classIN | classUnique, ttl: 120, target: "host.local.", port: 8080},
+			{name: "web-1._http._tcp.local.", typ: typeTXT, class: classIN | classUnique, ttl: 120, text: []string{"path=/", "v=1"}},
+			{name: "host.local.", typ: typeA, class: classIN | classUnique, ttl: 120, ip: net.IP{10, 0, 0, 1}},
+			{name: "host.local.", typ: typeAAAA, class: classIN | classUnique, ttl: 120, ip: net.ParseIP("fe80::1")},
+		},
+	}
+
+	var found dnsMessage
+	if err := found.unmarshal(msg.appendTo(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&found, msg) {
+		t.Errorf("messages mismatch:\n%+v\n%+v", &found, msg)
+	}
+}
+
+func TestReadNameCompressed(t *testing.T) {
+	b := []byte{
+		5, 'l', 'o', 'c', 'a', 'l', 0, // offset 0: local.
+		4, 'h', 'o', 's', 't', 0xc0, 0, // offset 7: host.local.
+		0xc0, 7, // offset 14: pointer to host.local.
+	}
+
+	for _, test := range []struct {
+		off  int
+		name string
+		next int
+	}{
+		{0, "local.", 7},
+		{7, "host.local.", 14},
+		{14, "host.local.", 16},
+	} {
+		name, next, err := readName(b, test.off)
+		if err != nil || name != test.name || next != test.next {
+			t.Errorf("offset %d: bad name: %q %d %v", test.off, name, next, err)
+		}
+	}
+
+	// Pointer loops must be detected.
+	if _, _, err := readName([]byte{0xc0, 0}, 0); err == nil {
+		t.Error("expected an error on a pointer loop")
+	}
+}
@@ -0,0 +1,408 @@
+package mdnsx
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// DefaultTTL is the default time to live of the records published by a
+// Responder.
+const DefaultTTL = 2 * time.Minute
+
+// legacyTTL is the maximum time to live of records sent in responses to legacy
+// unicast queries (RFC 6762 section 6.7).
+const legacyTTL = 10
+
+const servicesName = "_services._dns-sd._udp."
+
+// Responder advertises services over mDNS, answering the queries of browsers
+// on the local network for the service types, instances and host names that
+// were registered.
+//
+// Services are announced when the responder starts serving or when they are
+// registered, and goodbye packets are sent when they are unregistered or when
+// the responder is closed, so browsers learn about changes without waiting for
+// their next query.
+type Responder struct {
+	// Interface is the network interface that the responder joins the mDNS
+	// multicast group on. If nil, the system picks the interface.
+	Interface *net.Interface
+
+	// TTL is the time to live of the records published by the responder. If
+	// zero, DefaultTTL is used.
+	TTL time.Duration
+
+	// ErrorLog is used to report errors that occur while serving queries. If
+	// nil, the standard logger is used.
+	ErrorLog *log.Logger
+
+	mutex    sync.Mutex
+	services []*Service
+	conns    map[net.PacketConn]struct{}
+	closed   bool
+}
+
+var errMissingService = errors.New("mDNS services must have an instance name, a service type and a port")
+
+// Register adds svc to the list of services advertised by the responder. The
+// Host and IPs fields of the service are set to default values if they were
+// empty, a copy of svc is registered so later modifications of svc have no
+// effect.
+func (r *Responder) Register(svc *Service) error {
+	if len(svc.Instance) == 0 || len(svc.Service) == 0 || svc.Port == 0 {
+		return errMissingService
+	}
+
+	s := *svc
+	s.Domain = domain(s.Domain)
+
+	if len(s.Host) == 0 {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		if i := strings.IndexByte(host, '.'); i >= 0 {
+			host = host[:i]
+		}
+		s.Host = host + "." + s.Domain
+	}
+	s.Host = fqdn(s.Host)
+
+	if len(s.IPs) == 0 {
+		ips, err := r.localIPs()
+		if err != nil {
+			return err
+		}
+		s.IPs = ips
+	}
+
+	r.mutex.Lock()
+	r.services = append(r.services, &s)
+	conns := r.connList()
+	r.mutex.Unlock()
+
+	r.announce(conns, []*Service{&s}, r.ttl())
+	return nil
+}
+
+// Unregister removes the service with the same name as svc from the list of
+// services advertised by the responder.
+func (r *Responder) Unregister(svc *Service) {
+	var removed []*Service
+
+	r.mutex.Lock()
+	services := r.services[:0]
+	for _, s := range r.services {
+		if equalName(s.Name(), svc.Name()) {
+			removed = append(removed, s)
+		} else {
+			services = append(services, s)
+		}
+	}
+	r.services = services
+	conns := r.connList()
+	r.mutex.Unlock()
+
+	r.announce(conns, removed, 0)
+}
+
+// ListenAndServe joins the mDNS multicast group and then calls Serve to answer
+// the queries it receives.
+func (r *Responder) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp4", DefaultAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", r.Interface, addr)
+	if err != nil {
+		return err
+	}
+
+	return r.Serve(conn)
+}
+
+// Serve answers the mDNS queries received on conn until an error occurs or the
+// responder is closed.
+//
+// The responder becomes the owner of conn which will be closed by the time the
+// Serve method returns.
+//
+// After Close was called, Serve returns netx.ErrServerClosed.
+func (r *Responder) Serve(conn net.PacketConn) error {
+	defer conn.Close()
+
+	if !r.trackConn(conn) {
+		return netx.ErrServerClosed
+	}
+	defer r.untrackConn(conn)
+
+	r.announce([]net.PacketConn{conn}, r.serviceList(), r.ttl())
+
+	buf := make([]byte, maxMessageSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if r.isClosed() {
+				return netx.ErrServerClosed
+			}
+			return err
+		}
+
+		var msg dnsMessage
+		if msg.unmarshal(buf[:n]) != nil || msg.response() {
+			continue
+		}
+
+		r.serve(conn, addr, &msg)
+	}
+}
+
+// Close sends goodbye packets for all registered services, then closes the
+// connections that the responder is serving.
+func (r *Responder) Close() error {
+	r.mutex.Lock()
+	r.closed = true
+	conns := r.connList()
+	r.mutex.Unlock()
+
+	r.announce(conns, r.serviceList(), 0)
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (r *Responder) serve(conn net.PacketConn, addr net.Addr, query *dnsMessage) {
+	ttl := r.ttl()
+	udpAddr, _ := addr.(*net.UDPAddr)
+	legacy := udpAddr != nil && udpAddr.Port != 5353
+	unicast := legacy
+
+	res := &dnsMessage{flags: flagResponse | flagAuthoritative}
+	services := r.serviceList()
+
+	for _, q := range query.questions {
+		if q.class&classMask != classIN && q.class&classMask != classANY {
+			continue
+		}
+		if q.class&classUnique != 0 {
+			unicast = true
+		}
+		for _, svc := range services {
+			answers, additionals := answer(svc, q, ttl)
+			res.answers = append(res.answers, answers...)
+			res.additionals = append(res.additionals, additionals...)
+		}
+	}
+
+	if len(res.answers) == 0 {
+		return
+	}
+
+	if legacy {
+		// Legacy resolvers expect the responses to carry the id and questions
+		// of their queries, and don't understand the cache-flush bit.
+		res.id = query.id
+		res.questions = query.questions
+		for _, records := range [][]dnsRecord{res.answers, res.additionals} {
+			for i := range records {
+				records[i].class &= classMask
+				if records[i].ttl > legacyTTL {
+					records[i].ttl = legacyTTL
+				}
+			}
+		}
+	}
+
+	if !unicast {
+		var err error
+		if addr, err = net.ResolveUDPAddr("udp4", DefaultAddr); err != nil {
+			r.logf("mdnsx: %s", err)
+			return
+		}
+	}
+
+	if _, err := conn.WriteTo(res.appendTo(make([]byte, 0, 512)), addr); err != nil {
+		r.logf("mdnsx: responding to %s: %s", addr, err)
+	}
+}
+
+// announce sends unsolicited responses for services on conns, a zero ttl sends
+// goodbye packets.
+func (r *Responder) announce(conns []net.PacketConn, services []*Service, ttl time.Duration) {
+	if len(conns) == 0 || len(services) == 0 {
+		return
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", DefaultAddr)
+	if err != nil {
+		r.logf("mdnsx: %s", err)
+		return
+	}
+
+	msg := &dnsMessage{flags: flagResponse | flagAuthoritative}
+	for _, svc := range services {
+		answers, additionals := answer(svc, dnsQuestion{name: svc.typeName(), typ: typePTR}, ttl)
+		msg.answers = append(msg.answers, answers...)
+		msg.answers = append(msg.answers, additionals...)
+	}
+	b := msg.appendTo(make([]byte, 0, 512))
+
+	for _, conn := range conns {
+		if _, err := conn.WriteTo(b, addr); err != nil {
+			r.logf("mdnsx: announcing services: %s", err)
+		}
+	}
+}
+
+// answer returns the records of svc which answer the question q.
+func answer(svc *Service, q dnsQuestion, ttl time.Duration) (answers []dnsRecord, additionals []dnsRecord) {
+	secs := uint32(ttl / time.Second)
+	name := svc.Name()
+	all := q.typ == typeANY
+
+	addrs := func() (records []dnsRecord) {
+		for _, ip := range svc.IPs {
+			rr := dnsRecord{name: svc.Host, typ: typeA, class: classIN | classUnique, ttl: secs, ip: ip}
+			if ip.To4() == nil {
+				rr.typ = typeAAAA
+			}
+			records = append(records, rr)
+		}
+		return
+	}
+
+	srv := dnsRecord{name: name, typ: typeSRV, class: classIN | classUnique, ttl: secs, target: svc.Host, port: uint16(svc.Port)}
+	txt := dnsRecord{name: name, typ: typeTXT, class: classIN | classUnique, ttl: secs, text: svc.Text}
+
+	switch {
+	case equalName(q.name, servicesName+svc.Domain):
+		if q.typ == typePTR || all {
+			answers = append(answers, dnsRecord{name: servicesName + svc.Domain, typ: typePTR, class: classIN, ttl: secs, target: svc.typeName()})
+		}
+
+	case equalName(q.name, svc.typeName()):
+		if q.typ == typePTR || all {
+			answers = append(answers, dnsRecord{name: svc.typeName(), typ: typePTR, class: classIN, ttl: secs, target: name})
+			additionals = append(additionals, srv, txt)
+			additionals = append(additionals, addrs()...)
+		}
+
+	case equalName(q.name, name):
+		if q.typ == typeSRV || all {
+			answers = append(answers, srv)
+		}
+		if q.typ == typeTXT || all {
+			answers = append(answers, txt)
+		}
+		if len(answers) != 0 {
+			additionals = addrs()
+		}
+
+	case equalName(q.name, svc.Host):
+		for _, rr := range addrs() {
+			if q.typ == rr.typ || all {
+				answers = append(answers, rr)
+			}
+		}
+	}
+
+	return
+}
+
+func (r *Responder) localIPs() ([]net.IP, error) {
+	var addrs []net.Addr
+	var err error
+
+	if r.Interface != nil {
+		addrs, err = r.Interface.Addrs()
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ips, loopback []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ipnet.IP.IsLoopback() {
+				loopback = append(loopback, ipnet.IP)
+			} else {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		ips = loopback
+	}
+	return ips, nil
+}
+
+func (r *Responder) ttl() time.Duration {
+	if r.TTL > 0 {
+		return r.TTL
+	}
+	return DefaultTTL
+}
+
+func (r *Responder) serviceList() []*Service {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*Service(nil), r.services...)
+}
+
+func (r *Responder) connList() []net.PacketConn {
+	conns := make([]net.PacketConn, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (r *Responder) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+func (r *Responder) trackConn(conn net.PacketConn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return false
+	}
+
+	if r.conns == nil {
+		r.conns = make(map[net.PacketConn]struct{})
+	}
+
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *Responder) untrackConn(conn net.PacketConn) {
+	r.mutex.Lock()
+	delete(r.conns, conn)
+	r.mutex.Unlock()
+}
+
+func (r *Responder) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,121 @@
+package mdnsx
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func startResponder(t *testing.T, services ...*Service) (*Responder, net.Addr) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Announcements are sent to the multicast group, which may not be routable
+	// in test environments.
+	r := &Responder{ErrorLog: log.New(ioutil.Discard, "", 0)}
+
+	for _, svc := range services {
+		if err := r.Register(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go r.Serve(conn)
+	return r, conn.LocalAddr()
+}
+
+func TestResponder(t *testing.T) {
+	r, addr := startResponder(t, &Service{
+		Instance: "web-1",
+		Service:  "_http._tcp",
+		Host:     "host.local",
+		Port:     8080,
+		IPs:      []net.IP{net.IPv4(10, 0, 0, 1)},
+		Text:     []string{"v=1"},
+	})
+	defer r.Close()
+
+	conn, err := net.Dial("udp4", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name    string
+		typ     uint16
+		answers []uint16
+	}{
+		{"_services._dns-sd._udp.local.", typePTR, []uint16{typePTR}},
+		{"_http._tcp.local.", typePTR, []uint16{typePTR, typeSRV, typeTXT, typeA}},
+		{"web-1._http._tcp.local.", typeANY, []uint16{typeSRV, typeTXT, typeA}},
+		{"HOST.local.", typeA, []uint16{typeA}},
+		{"host.local.", typeAAAA, nil},
+		{"_ssh._tcp.local.", typePTR, nil},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := &dnsMessage{id: uint16(i), questions: []dnsQuestion{{name: test.name, typ: test.typ, class: classIN}}}
+			if _, err := conn.Write(query.appendTo(nil)); err != nil {
+				t.Fatal(err)
+			}
+
+			timeout := 2 * time.Second
+			if test.answers == nil {
+				timeout = 100 * time.Millisecond
+			}
+			conn.SetReadDeadline(time.Now().Add(timeout))
+
+			buf := make([]byte, maxMessageSize)
+			n, err := conn.Read(buf)
+			if test.answers == nil {
+				if err == nil {
+					t.Error("unexpected response")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var res dnsMessage
+			if err := res.unmarshal(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			if res.id != query.id || len(res.questions) != 1 {
+				t.Error("legacy unicast responses must repeat the query id and questions")
+			}
+
+			var types []uint16
+			for _, rr := range res.records() {
+				types = append(types, rr.typ)
+				if rr.ttl > legacyTTL || rr.class != classIN {
+					t.Errorf("bad record for a legacy unicast response: %+v", rr)
+				}
+			}
+
+			if len(types) != len(test.answers) {
+				t.Fatalf("bad records: %v", types)
+			}
+			for i := range types {
+				if types[i] != test.answers[i] {
+					t.Fatalf("bad records: %v", types)
+				}
+			}
+		})
+	}
+}
+
+func TestResponderRegisterInvalid(t *testing.T) {
+	r := &Responder{}
+
+	if err := r.Register(&Service{Instance: "web-1", Service: "_http._tcp"}); err == nil {
+		t.Error("expected an error when registering a service without a port")
+	}
+}
@@ -0,0 +1,342 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Matcher is a function used by MuxListener to decide which sub-listener a
+// connection should be routed to, it reads the first bytes sent on the
+// connection from r and returns true if they match the protocol it detects.
+//
+// Matchers should read as few bytes as possible, since reading blocks until the
+// client sent enough data.
+type Matcher func(r io.Reader) bool
+
+// MatchAny returns a matcher which matches all connections, it is usually
+// registered last to catch the connections that no other matcher recognized.
+func MatchAny() Matcher {
+	return func(io.Reader) bool { return true }
+}
+
+// MatchPrefix returns a matcher which matches connections starting with one of
+// the given prefixes.
+func MatchPrefix(prefixes ...string) Matcher {
+	max := 0
+
+	for _, p := range prefixes {
+		if len(p) > max {
+			max = len(p)
+		}
+	}
+
+	return func(r io.Reader) bool {
+		b := make([]byte, max)
+		n, _ := io.ReadFull(r, b)
+		b = b[:n]
+
+		for _, p := range prefixes {
+			if bytes.HasPrefix(b, []byte(p)) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MatchHTTP1 returns a matcher which matches connections starting with a
+// HTTP/1.x request line.
+func MatchHTTP1() Matcher {
+	return func(r io.Reader) bool {
+		line, err := bufio.NewReaderSize(r, 4096).ReadSlice('\n')
+		if err != nil {
+			return false
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		i := bytes.IndexByte(line, ' ')
+		j := bytes.LastIndexByte(line, ' ')
+
+		if i <= 0 || j <= i {
+			return false
+		}
+
+		proto := line[j+1:]
+		return bytes.Equal(proto, []byte("HTTP/1.0")) || bytes.Equal(proto, []byte("HTTP/1.1"))
+	}
+}
+
+// MatchHTTP2 returns a matcher which matches connections starting with the
+// HTTP/2 client connection preface, as sent by clients using HTTP/2 with prior
+// knowledge (h2c).
+func MatchHTTP2() Matcher {
+	return MatchPrefix("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+}
+
+// MatchTLS returns a matcher which matches connections starting with a TLS
+// handshake record.
+func MatchTLS() Matcher {
+	return func(r io.Reader) bool {
+		var b [3]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return false
+		}
+		// Content type 22 (handshake), followed by the major version of the
+		// record protocol, which is 3 for SSL 3.0 and all TLS versions.
+		return b[0] == 0x16 && b[1] == 0x03
+	}
+}
+
+// MatchSSH returns a matcher which matches connections starting with a SSH
+// protocol version exchange.
+func MatchSSH() Matcher {
+	return MatchPrefix("SSH-")
+}
+
+// MuxListener demultiplexes the connections accepted by a listener to multiple
+// sub-listeners, based on the first bytes received on each connection. This
+// allows a single port to serve multiple protocols.
+//
+// Sub-listeners are created by calling Match, then the program must call Serve
+// to start accepting connections.
+type MuxListener struct {
+	// ReadTimeout is the maximum amount of time allowed to the clients to
+	// send enough bytes for the matchers to recognize the protocol. If zero,
+	// DefaultMuxReadTimeout is used.
+	ReadTimeout time.Duration
+
+	// MaxSniffBytes is the maximum number of bytes that the matchers may read
+	// from a connection. If zero, DefaultMaxSniffBytes is used.
+	MaxSniffBytes int
+
+	lstn  net.Listener
+	mutex sync.Mutex
+	subs  []*muxSubListener
+	once  sync.Once
+	done  chan struct{}
+}
+
+const (
+	// DefaultMuxReadTimeout is the default read timeout of a MuxListener.
+	DefaultMuxReadTimeout = 10 * time.Second
+
+	// DefaultMaxSniffBytes is the default number of bytes that the matchers
+	// of a MuxListener may read.
+	DefaultMaxSniffBytes = 4096
+)
+
+// NewMuxListener returns a new MuxListener which accepts connections from
+// lstn.
+func NewMuxListener(lstn net.Listener) *MuxListener {
+	return &MuxListener{
+		lstn: lstn,
+		done: make(chan struct{}),
+	}
+}
+
+// Match returns a sub-listener which receives the connections matched by one
+// of the given matchers.
+//
+// The matchers of sub-listeners are tried in the order that the sub-listeners
+// were created, the first match determines where the connection is routed.
+// Connections that don't match any sub-listener are closed.
+func (m *MuxListener) Match(matchers ...Matcher) net.Listener {
+	sub := &muxSubListener{
+		mux:      m,
+		matchers: matchers,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	m.mutex.Lock()
+	m.subs = append(m.subs, sub)
+	m.mutex.Unlock()
+	return sub
+}
+
+// Serve accepts connections on the base listener and dispatches them to the
+// sub-listeners, it returns when the base listener fails to accept a
+// connection (for example because it was closed).
+//
+// When Serve returns, the sub-listeners return errors from their Accept
+// method.
+func (m *MuxListener) Serve() error {
+	defer m.Close()
+	return acceptLoop(context.Background(), m.lstn, func(conn net.Conn) { go m.serve(conn) }, nil)
+}
+
+// Close closes the base listener and all the sub-listeners.
+func (m *MuxListener) Close() (err error) {
+	m.once.Do(func() {
+		close(m.done)
+		err = m.lstn.Close()
+	})
+	return
+}
+
+// Addr returns the address of the base listener.
+func (m *MuxListener) Addr() net.Addr {
+	return m.lstn.Addr()
+}
+
+func (m *MuxListener) serve(conn net.Conn) {
+	timeout := m.ReadTimeout
+	if timeout == 0 {
+		timeout = DefaultMuxReadTimeout
+	}
+
+	maxBytes := m.MaxSniffBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxSniffBytes
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	s := &sniffer{r: conn, max: maxBytes}
+	sub := m.match(s)
+
+	conn.SetReadDeadline(time.Time{})
+
+	if sub == nil || !sub.push(&sniffConn{Conn: conn, buf: s.buf}) {
+		conn.Close()
+	}
+}
+
+func (m *MuxListener) match(s *sniffer) *muxSubListener {
+	m.mutex.Lock()
+	subs := m.subs
+	m.mutex.Unlock()
+
+	for _, sub := range subs {
+		for _, match := range sub.matchers {
+			s.off = 0
+			if match(s) {
+				return sub
+			}
+		}
+	}
+
+	return nil
+}
+
+type muxSubListener struct {
+	mux      *MuxListener
+	matchers []Matcher
+	conns    chan net.Conn
+	once     sync.Once
+	done     chan struct{}
+}
+
+func (l *muxSubListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+	case <-l.mux.done:
+	}
+	return false
+}
+
+func (l *muxSubListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+	case <-l.mux.done:
+	}
+	return nil, &net.OpError{
+		Op:   "accept",
+		Net:  l.Addr().Network(),
+		Addr: l.Addr(),
+		Err:  io.ErrClosedPipe,
+	}
+}
+
+func (l *muxSubListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *muxSubListener) Addr() net.Addr {
+	return l.mux.Addr()
+}
+
+// sniffer is an io.Reader which buffers the bytes it reads from r, so they can
+// be read again after resetting the offset.
+type sniffer struct {
+	r   io.Reader
+	buf []byte
+	off int
+	max int
+	err error
+}
+
+func (s *sniffer) Read(b []byte) (n int, err error) {
+	if s.off == len(s.buf) {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		if len(s.buf) >= s.max {
+			return 0, io.EOF
+		}
+
+		if len(b) > s.max-len(s.buf) {
+			b = b[:s.max-len(s.buf)]
+		}
+
+		n, err = s.r.Read(b)
+		s.buf = append(s.buf, b[:n]...)
+		s.off += n
+		s.err = err
+		return
+	}
+
+	n = copy(b, s.buf[s.off:])
+	s.off += n
+	return
+}
+
+// sniffConn is the connection type returned by the sub-listeners of a
+// MuxListener, reading from the connection first returns the bytes that were
+// read by the matchers.
+type sniffConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *sniffConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *sniffConn) Read(b []byte) (n int, err error) {
+	if len(c.buf) != 0 {
+		n = copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *sniffConn) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(c.Conn, c, r)
+}
+
+func (c *sniffConn) WriteTo(w io.Writer) (n int64, err error) {
+	if len(c.buf) != 0 {
+		var k int
+		k, err = w.Write(c.buf)
+		c.buf, n = c.buf[k:], int64(k)
+		if err != nil {
+			return
+		}
+	}
+	k, err := writeTo(c.Conn, c, w)
+	n += k
+	return
+}
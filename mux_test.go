@@ -0,0 +1,103 @@
+package netx
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher Matcher
+		input   string
+		match   bool
+	}{
+		{"any", MatchAny(), "", true},
+		{"prefix", MatchPrefix("foo", "bar"), "barbaz", true},
+		{"prefix:short", MatchPrefix("foobar"), "foo", false},
+		{"http1", MatchHTTP1(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", true},
+		{"http1:no-proto", MatchHTTP1(), "GET /\r\n", false},
+		{"http1:http2", MatchHTTP1(), "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", false},
+		{"http2", MatchHTTP2(), "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", true},
+		{"tls", MatchTLS(), "\x16\x03\x01\x02\x00", true},
+		{"tls:http", MatchTLS(), "GET / HTTP/1.1\r\n", false},
+		{"ssh", MatchSSH(), "SSH-2.0-OpenSSH_7.4\r\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := test.matcher(strings.NewReader(test.input)); match != test.match {
+				t.Errorf("expected %t but got %t", test.match, match)
+			}
+		})
+	}
+}
+
+func TestMuxListener(t *testing.T) {
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := NewMuxListener(lstn)
+	mux.ReadTimeout = 100 * time.Millisecond
+	defer mux.Close()
+
+	ssh := mux.Match(MatchSSH())
+	http := mux.Match(MatchHTTP1())
+
+	go mux.Serve()
+
+	go serveFirstLine(ssh, "ssh")
+	go serveFirstLine(http, "http")
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"SSH-2.0-Test\r\n", "ssh: SSH-2.0-Test\r\n"},
+		{"GET / HTTP/1.1\r\n", "http: GET / HTTP/1.1\r\n"},
+		{"Hello World!\r\n", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			conn, err := net.Dial("tcp", lstn.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			io.WriteString(conn, test.input)
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+
+			if line != test.output {
+				t.Errorf("expected %q but got %q", test.output, line)
+			}
+		})
+	}
+
+	mux.Close()
+
+	if _, err := http.Accept(); err == nil {
+		t.Error("expected an error from a sub-listener of a closed multiplexer")
+	}
+}
+
+func serveFirstLine(lstn net.Listener, prefix string) {
+	for {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, prefix+": "+line)
+		conn.Close()
+	}
+}
@@ -0,0 +1,537 @@
+package muxx
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAcceptBacklog is the default number of streams opened by the
+	// remote peer that may wait to be accepted.
+	DefaultAcceptBacklog = 256
+
+	// DefaultKeepAliveInterval is the default amount of time between two pings
+	// sent to check that the remote peer is alive.
+	DefaultKeepAliveInterval = 30 * time.Second
+
+	// DefaultWriteTimeout is the default amount of time after which writing a
+	// frame to the underlying connection is considered to have failed.
+	DefaultWriteTimeout = 10 * time.Second
+
+	// DefaultStreamWindow is the initial and default maximum size of the
+	// receive window of streams, it is the amount of data that the remote
+	// peer may send before a stream is read by the program.
+	DefaultStreamWindow = 256 * 1024
+)
+
+var (
+	// ErrSessionShutdown is returned by operations on a session or its
+	// streams after the session was closed.
+	ErrSessionShutdown = errors.New("muxx: session shutdown")
+
+	// ErrStreamReset is returned by operations on a stream that was reset by
+	// the remote peer.
+	ErrStreamReset = errors.New("muxx: stream reset")
+
+	// ErrStreamsExhausted is returned by Open when the session ran out of
+	// stream identifiers.
+	ErrStreamsExhausted = errors.New("muxx: stream identifiers exhausted")
+
+	// ErrRemoteGoAway is returned by Open when the remote peer doesn't accept
+	// new streams anymore.
+	ErrRemoteGoAway = errors.New("muxx: remote peer is going away")
+
+	errProtocol = errors.New("muxx: protocol error")
+)
+
+// Config carries the configuration of a session, a nil config uses the
+// default values.
+type Config struct {
+	// AcceptBacklog is the number of streams opened by the remote peer that
+	// may wait to be accepted, more streams are reset. If zero,
+	// DefaultAcceptBacklog is used.
+	AcceptBacklog int
+
+	// KeepAliveInterval is the amount of time between two pings sent to the
+	// remote peer, the session is closed if a ping isn't answered. If zero,
+	// DefaultKeepAliveInterval is used, a negative value disables keepalives.
+	KeepAliveInterval time.Duration
+
+	// WriteTimeout is the amount of time after which writing a frame to the
+	// underlying connection is considered to have failed, which closes the
+	// session. If zero, DefaultWriteTimeout is used.
+	WriteTimeout time.Duration
+
+	// MaxStreamWindow is the maximum size of the receive window of streams,
+	// it cannot be less than DefaultStreamWindow.
+	MaxStreamWindow uint32
+
+	// ErrorLog is used to report errors that cause the session to be closed.
+	// If nil, the standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// Wire format of frame headers, compatible with yamux:
+//
+//	version (1 byte) | type (1 byte) | flags (2 bytes) | stream id (4 bytes) | length (4 bytes)
+const (
+	protoVersion = 0
+	headerLen    = 12
+
+	typeData         = 0
+	typeWindowUpdate = 1
+	typePing         = 2
+	typeGoAway       = 3
+
+	flagSYN = 1
+	flagACK = 2
+	flagFIN = 4
+	flagRST = 8
+
+	goAwayNormal   = 0
+	goAwayProtocol = 1
+)
+
+type header [headerLen]byte
+
+func makeHeader(typ uint8, flags uint16, id uint32, length uint32) header {
+	var h header
+	h[0] = protoVersion
+	h[1] = typ
+	binary.BigEndian.PutUint16(h[2:], flags)
+	binary.BigEndian.PutUint32(h[4:], id)
+	binary.BigEndian.PutUint32(h[8:], length)
+	return h
+}
+
+func (h *header) typ() uint8       { return h[1] }
+func (h *header) flags() uint16    { return binary.BigEndian.Uint16(h[2:]) }
+func (h *header) streamID() uint32 { return binary.BigEndian.Uint32(h[4:]) }
+func (h *header) length() uint32   { return binary.BigEndian.Uint32(h[8:]) }
+
+// Session multiplexes streams over a single connection.
+//
+// Both sides of the connection may open streams, which makes it possible for
+// a client that dialed a server to accept streams opened by the server, for
+// example to expose a service from behind a NAT (reverse tunneling). Session
+// implements the net.Listener interface, accepting the streams opened by the
+// remote peer.
+type Session struct {
+	conn   net.Conn
+	config Config
+	client bool
+
+	writeMutex sync.Mutex
+
+	mutex    sync.Mutex
+	streams  map[uint32]*Stream
+	nextID   uint32
+	pings    map[uint32]chan struct{}
+	pingID   uint32
+	goAway   bool
+	shutdown bool
+
+	accept chan *Stream
+	done   chan struct{}
+}
+
+// NewClient returns a session running over conn on the side of the connection
+// that dialed it. The session becomes the owner of conn.
+func NewClient(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, true)
+}
+
+// NewServer returns a session running over conn on the side of the connection
+// that accepted it. The session becomes the owner of conn.
+func NewServer(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, false)
+}
+
+func newSession(conn net.Conn, config *Config, client bool) *Session {
+	s := &Session{
+		conn:    conn,
+		client:  client,
+		streams: make(map[uint32]*Stream),
+		pings:   make(map[uint32]chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if config != nil {
+		s.config = *config
+	}
+	if s.config.AcceptBacklog <= 0 {
+		s.config.AcceptBacklog = DefaultAcceptBacklog
+	}
+	if s.config.KeepAliveInterval == 0 {
+		s.config.KeepAliveInterval = DefaultKeepAliveInterval
+	}
+	if s.config.WriteTimeout <= 0 {
+		s.config.WriteTimeout = DefaultWriteTimeout
+	}
+	if s.config.MaxStreamWindow < DefaultStreamWindow {
+		s.config.MaxStreamWindow = DefaultStreamWindow
+	}
+
+	// Clients use odd stream identifiers and servers use even ones so both
+	// sides can open streams without conflicts.
+	if client {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+
+	s.accept = make(chan *Stream, s.config.AcceptBacklog)
+
+	go s.recvLoop()
+
+	if s.config.KeepAliveInterval > 0 {
+		go s.keepAlive()
+	}
+
+	return s
+}
+
+// Open opens a new stream to the remote peer.
+func (s *Session) Open() (net.Conn, error) {
+	return s.OpenStream()
+}
+
+// OpenStream opens a new stream to the remote peer.
+func (s *Session) OpenStream() (*Stream, error) {
+	s.mutex.Lock()
+
+	switch {
+	case s.shutdown:
+		s.mutex.Unlock()
+		return nil, ErrSessionShutdown
+	case s.goAway:
+		s.mutex.Unlock()
+		return nil, ErrRemoteGoAway
+	case s.nextID == 0 || s.nextID > 1<<32-2:
+		s.mutex.Unlock()
+		return nil, ErrStreamsExhausted
+	}
+
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mutex.Unlock()
+
+	// The stream is announced to the remote peer by a window update carrying
+	// the SYN flag, the data may then be sent without waiting for the peer to
+	// acknowledge the stream.
+	if err := s.send(makeHeader(typeWindowUpdate, flagSYN, id, 0), nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// Accept waits for the remote peer to open a stream and returns it.
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// AcceptStream waits for the remote peer to open a stream and returns it.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.accept:
+		if err := s.send(makeHeader(typeWindowUpdate, flagACK, stream.id, 0), nil); err != nil {
+			return nil, err
+		}
+		return stream, nil
+	case <-s.done:
+		return nil, ErrSessionShutdown
+	}
+}
+
+// Addr returns the local address of the underlying connection.
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// NumStreams returns the number of streams currently open on the session.
+func (s *Session) NumStreams() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.streams)
+}
+
+// Done returns a channel which is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Ping sends a ping to the remote peer and returns the round trip time.
+func (s *Session) Ping() (time.Duration, error) {
+	ch := make(chan struct{})
+
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		return 0, ErrSessionShutdown
+	}
+	id := s.pingID
+	s.pingID++
+	s.pings[id] = ch
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pings, id)
+		s.mutex.Unlock()
+	}()
+
+	start := time.Now()
+
+	if err := s.send(makeHeader(typePing, flagSYN, 0, id), nil); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(s.config.WriteTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-timer.C:
+		return 0, errKeepAliveTimeout
+	case <-s.done:
+		return 0, ErrSessionShutdown
+	}
+}
+
+var errKeepAliveTimeout = errors.New("muxx: keepalive timeout")
+
+// GoAway tells the remote peer to stop opening new streams, the streams that
+// are already open are not affected.
+func (s *Session) GoAway() error {
+	return s.send(makeHeader(typeGoAway, 0, 0, goAwayNormal), nil)
+}
+
+// Close closes the session, all its streams and the underlying connection.
+func (s *Session) Close() error {
+	s.GoAway()
+	s.close()
+	return nil
+}
+
+func (s *Session) close() {
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		return
+	}
+	s.shutdown = true
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mutex.Unlock()
+
+	close(s.done)
+	s.conn.Close()
+
+	for _, stream := range streams {
+		stream.notify()
+	}
+}
+
+func (s *Session) isShutdown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.shutdown
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mutex.Lock()
+	delete(s.streams, id)
+	s.mutex.Unlock()
+}
+
+// send writes a frame to the underlying connection, frames are written
+// atomically so streams can send concurrently.
+func (s *Session) send(h header, body []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if s.isShutdown() {
+		return ErrSessionShutdown
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+
+	buffers := net.Buffers{h[:]}
+	if len(body) != 0 {
+		buffers = append(buffers, body)
+	}
+
+	if _, err := buffers.WriteTo(s.conn); err != nil {
+		s.logf("muxx: writing to %s: %s", s.conn.RemoteAddr(), err)
+		s.close()
+		return ErrSessionShutdown
+	}
+	return nil
+}
+
+func (s *Session) keepAlive() {
+	ticker := time.NewTicker(s.config.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Ping(); err != nil {
+				if err != ErrSessionShutdown {
+					s.logf("muxx: keepalive to %s failed: %s", s.conn.RemoteAddr(), err)
+					s.close()
+				}
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Session) recvLoop() {
+	var h header
+
+	for {
+		if _, err := io.ReadFull(s.conn, h[:]); err != nil {
+			if !s.isShutdown() && err != io.EOF {
+				s.logf("muxx: reading from %s: %s", s.conn.RemoteAddr(), err)
+			}
+			s.close()
+			return
+		}
+
+		var err error
+
+		if h[0] != protoVersion {
+			err = errProtocol
+		} else {
+			switch h.typ() {
+			case typeData, typeWindowUpdate:
+				err = s.handleStream(h)
+			case typePing:
+				err = s.handlePing(h)
+			case typeGoAway:
+				s.mutex.Lock()
+				s.goAway = true
+				s.mutex.Unlock()
+			default:
+				err = errProtocol
+			}
+		}
+
+		if err != nil {
+			if err == errProtocol {
+				s.logf("muxx: protocol error from %s, closing the session", s.conn.RemoteAddr())
+				s.send(makeHeader(typeGoAway, 0, 0, goAwayProtocol), nil)
+			}
+			s.close()
+			return
+		}
+	}
+}
+
+func (s *Session) handleStream(h header) error {
+	id, flags, length := h.streamID(), h.flags(), h.length()
+
+	if flags&flagSYN != 0 {
+		if err := s.incomingStream(id); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	stream := s.streams[id]
+	s.mutex.Unlock()
+
+	if h.typ() == typeWindowUpdate {
+		if stream != nil {
+			stream.updateSendWindow(flags, length)
+		}
+		return nil
+	}
+
+	if stream == nil {
+		// The stream was closed locally, the data is discarded and the remote
+		// peer is told that nobody will read it.
+		if _, err := io.CopyN(io.Discard, s.conn, int64(length)); err != nil {
+			return err
+		}
+		if length != 0 && flags&flagRST == 0 {
+			return s.send(makeHeader(typeWindowUpdate, flagRST, id, 0), nil)
+		}
+		return nil
+	}
+
+	return stream.readData(flags, length, s.conn)
+}
+
+func (s *Session) incomingStream(id uint32) error {
+	// Streams opened by the remote peer must use identifiers of the other
+	// parity.
+	if id == 0 || (id%2 == 1) == s.client {
+		return errProtocol
+	}
+
+	s.mutex.Lock()
+	if _, exists := s.streams[id]; exists {
+		s.mutex.Unlock()
+		return errProtocol
+	}
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mutex.Unlock()
+
+	select {
+	case s.accept <- stream:
+		return nil
+	default:
+		s.removeStream(id)
+		return s.send(makeHeader(typeWindowUpdate, flagRST, id, 0), nil)
+	}
+}
+
+func (s *Session) handlePing(h header) error {
+	if h.flags()&flagSYN != 0 {
+		go s.send(makeHeader(typePing, flagACK, 0, h.length()), nil)
+		return nil
+	}
+
+	s.mutex.Lock()
+	ch := s.pings[h.length()]
+	delete(s.pings, h.length())
+	s.mutex.Unlock()
+
+	if ch != nil {
+		close(ch)
+	}
+	return nil
+}
+
+func (s *Session) logf(format string, args ...interface{}) {
+	if s.config.ErrorLog != nil {
+		s.config.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
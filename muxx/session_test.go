@@ -0,0 +1,251 @@
+package muxx
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+func newSessionPair(t *testing.T, config *Config) (client *Session, server *Session) {
+	c1, c2 := net.Pipe()
+	return NewClient(c1, config), NewServer(c2, config)
+}
+
+func TestStreamConn(t *testing.T) {
+	nettest.TestConn(t, func() (c1 net.Conn, c2 net.Conn, stop func(), err error) {
+		client, server := newSessionPair(t, nil)
+
+		if c1, err = client.Open(); err != nil {
+			return
+		}
+		// The stream is accepted once data was sent on it.
+		if c2, err = server.Accept(); err != nil {
+			return
+		}
+
+		stop = func() {
+			client.Close()
+			server.Close()
+		}
+		return
+	})
+}
+
+func TestSession(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// Both sides may open streams, the server side can reach a listener
+	// running on the client side.
+	for _, test := range []struct {
+		name   string
+		opener *Session
+		lstn   net.Listener
+	}{
+		{name: "client", opener: client, lstn: server},
+		{name: "server", opener: server, lstn: client},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			go func() {
+				conn, err := test.lstn.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+
+			conn, err := test.opener.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte("Hello World!")); err != nil {
+				t.Fatal(err)
+			}
+			conn.(*Stream).CloseWrite()
+
+			b, err := ioutil.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "Hello World!" {
+				t.Errorf("bad echo: %q", b)
+			}
+		})
+	}
+}
+
+func TestSessionFlowControl(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	idle, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	// Fill the window of a stream which is never read, further writes must
+	// block without preventing other streams from making progress.
+	data := bytes.Repeat([]byte("x"), DefaultStreamWindow)
+	if _, err := idle.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	idle.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := idle.Write([]byte("x")); err == nil {
+		t.Fatal("write beyond the stream window did not block")
+	}
+
+	active, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := active.Write(bytes.Repeat([]byte("y"), 4*DefaultStreamWindow))
+		active.CloseWrite()
+		done <- err
+	}()
+
+	s1, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := io.Copy(ioutil.Discard, s2)
+	if err != nil || n != 4*DefaultStreamWindow {
+		t.Fatal("bad read:", n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading the idle stream opens its window again.
+	idle.SetWriteDeadline(time.Time{})
+	go io.Copy(ioutil.Discard, s1)
+
+	if _, err := idle.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionPing(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := client.Ping(); err != nil {
+		t.Error(err)
+	}
+	if _, err := server.Ping(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	// The remote side never answers pings, the session must be closed after
+	// the keepalive timeout.
+	go io.Copy(ioutil.Discard, c2)
+
+	session := NewClient(c1, &Config{
+		KeepAliveInterval: 10 * time.Millisecond,
+		WriteTimeout:      50 * time.Millisecond,
+		ErrorLog:          log.New(ioutil.Discard, "", 0),
+	})
+
+	select {
+	case <-session.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session not closed after the keepalive timeout")
+	}
+
+	if _, err := session.Open(); err != ErrSessionShutdown {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+	defer server.Close()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := stream.Read(make([]byte, 1))
+		errs <- err
+	}()
+	go func() {
+		_, err := client.Accept()
+		errs <- err
+	}()
+
+	client.Close()
+
+	for i := 0; i != 2; i++ {
+		if err := <-errs; err != ErrSessionShutdown {
+			t.Error("bad error:", err)
+		}
+	}
+
+	// The remote session sees the underlying connection closed.
+	select {
+	case <-server.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("remote session not closed")
+	}
+}
+
+func TestSessionResetAfterClose(t *testing.T) {
+	client, server := newSessionPair(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	remote, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.Close()
+
+	// Data sent to a stream closed by the remote peer causes it to be reset.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err = stream.Write([]byte("x")); err != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err != ErrStreamReset {
+		t.Error("bad error:", err)
+	}
+}
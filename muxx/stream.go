@@ -0,0 +1,342 @@
+package muxx
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// maxFrameSize is the maximum size of the data frames sent by streams, it
+// bounds the amount of time that a stream holds the underlying connection.
+const maxFrameSize = 64 * 1024
+
+// Stream is a logical connection multiplexed over a session, it implements the
+// net.Conn interface.
+//
+// Streams are flow controlled: a peer may only send as much data as the other
+// side advertised it can buffer, so a stream that isn't read doesn't prevent
+// other streams of the session from making progress.
+type Stream struct {
+	session *Session
+	id      uint32
+
+	mutex         sync.Mutex
+	recvBuf       bytes.Buffer
+	recvWindow    uint32 // amount of data that the remote peer may send
+	sendWindow    uint32 // amount of data that may be sent to the remote peer
+	closed        bool   // Close was called
+	localFIN      bool   // the write side was closed
+	remoteFIN     bool   // the remote peer closed its write side
+	reset         bool   // the stream was reset by the remote peer
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// signal is closed and replaced when the state of the stream changes, to
+	// wake up the goroutines blocked reading or writing.
+	signal chan struct{}
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		session:    session,
+		id:         id,
+		recvWindow: DefaultStreamWindow,
+		sendWindow: DefaultStreamWindow,
+		signal:     make(chan struct{}),
+	}
+}
+
+// ID returns the identifier of the stream within its session.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Read satisfies the io.Reader interface.
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.mutex.Lock()
+
+		if s.closed {
+			s.mutex.Unlock()
+			return 0, net.ErrClosed
+		}
+
+		if s.recvBuf.Len() != 0 {
+			n, _ := s.recvBuf.Read(b)
+			delta := s.windowUpdate()
+			s.mutex.Unlock()
+
+			if delta != 0 {
+				s.session.send(makeHeader(typeWindowUpdate, 0, s.id, delta), nil)
+			}
+			return n, nil
+		}
+
+		var err error
+		switch {
+		case s.remoteFIN:
+			err = io.EOF
+		case s.reset:
+			err = ErrStreamReset
+		case s.session.isShutdown():
+			err = ErrSessionShutdown
+		}
+
+		deadline, signal := s.readDeadline, s.signal
+		s.mutex.Unlock()
+
+		if err != nil {
+			return 0, err
+		}
+
+		if err := wait(signal, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// windowUpdate returns the amount by which the receive window of the stream is
+// increased after data was read, or zero if it is not worth sending an update
+// to the remote peer yet. The stream mutex must be held.
+func (s *Stream) windowUpdate() uint32 {
+	max := s.session.config.MaxStreamWindow
+	delta := max - uint32(s.recvBuf.Len()) - s.recvWindow
+
+	if delta < max/2 {
+		return 0
+	}
+
+	s.recvWindow += delta
+	return delta
+}
+
+// Write satisfies the io.Writer interface.
+func (s *Stream) Write(b []byte) (int, error) {
+	n := 0
+
+	for n < len(b) {
+		s.mutex.Lock()
+
+		var err error
+		switch {
+		case s.closed || s.localFIN:
+			err = net.ErrClosed
+		case s.reset:
+			err = ErrStreamReset
+		case s.session.isShutdown():
+			err = ErrSessionShutdown
+		}
+
+		if err != nil {
+			s.mutex.Unlock()
+			return n, err
+		}
+
+		if s.sendWindow == 0 {
+			deadline, signal := s.writeDeadline, s.signal
+			s.mutex.Unlock()
+
+			if err := wait(signal, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline) {
+			s.mutex.Unlock()
+			return n, errTimeout
+		}
+
+		chunk := b[n:]
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		if uint32(len(chunk)) > s.sendWindow {
+			chunk = chunk[:s.sendWindow]
+		}
+		s.sendWindow -= uint32(len(chunk))
+		s.mutex.Unlock()
+
+		if err := s.session.send(makeHeader(typeData, 0, s.id, uint32(len(chunk))), chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+
+	return n, nil
+}
+
+// Close closes the stream, the remote peer will see the end of the stream
+// after reading the data that was already written.
+func (s *Stream) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	sendFIN := !s.localFIN && !s.reset
+	s.localFIN = true
+	s.recvBuf.Reset()
+	s.mutex.Unlock()
+
+	s.notify()
+
+	// The stream is forgotten right away, data received afterwards causes it
+	// to be reset.
+	s.session.removeStream(s.id)
+
+	if sendFIN && !s.session.isShutdown() {
+		return s.session.send(makeHeader(typeWindowUpdate, flagFIN, s.id, 0), nil)
+	}
+	return nil
+}
+
+// CloseWrite closes the write side of the stream, the stream may still be read
+// until the remote peer closes it.
+func (s *Stream) CloseWrite() error {
+	s.mutex.Lock()
+	if s.localFIN {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.localFIN = true
+	done := s.remoteFIN
+	s.mutex.Unlock()
+
+	s.notify()
+
+	if done {
+		s.session.removeStream(s.id)
+	}
+
+	return s.session.send(makeHeader(typeWindowUpdate, flagFIN, s.id, 0), nil)
+}
+
+// LocalAddr returns the local address of the session's connection.
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the session's connection.
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.readDeadline = t
+	s.writeDeadline = t
+	s.mutex.Unlock()
+	s.notify()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.readDeadline = t
+	s.mutex.Unlock()
+	s.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.writeDeadline = t
+	s.mutex.Unlock()
+	s.notify()
+	return nil
+}
+
+// readData reads the payload of a data frame of the stream from r.
+func (s *Stream) readData(flags uint16, length uint32, r io.Reader) error {
+	if length != 0 {
+		s.mutex.Lock()
+		if length > s.recvWindow {
+			s.mutex.Unlock()
+			return errProtocol
+		}
+		s.recvWindow -= length
+		s.mutex.Unlock()
+
+		b := make([]byte, length)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+
+		s.mutex.Lock()
+		if !s.closed {
+			s.recvBuf.Write(b)
+		}
+		s.mutex.Unlock()
+	}
+
+	s.applyFlags(flags)
+	return nil
+}
+
+// updateSendWindow processes a window update frame received for the stream.
+func (s *Stream) updateSendWindow(flags uint16, delta uint32) {
+	s.mutex.Lock()
+	s.sendWindow += delta
+	s.mutex.Unlock()
+	s.applyFlags(flags)
+}
+
+func (s *Stream) applyFlags(flags uint16) {
+	s.mutex.Lock()
+	if flags&flagFIN != 0 {
+		s.remoteFIN = true
+	}
+	if flags&flagRST != 0 {
+		s.reset = true
+	}
+	done := s.reset || (s.remoteFIN && s.localFIN)
+	s.mutex.Unlock()
+
+	if done {
+		s.session.removeStream(s.id)
+	}
+	s.notify()
+}
+
+// notify wakes up the goroutines blocked reading or writing the stream so they
+// can check its state again.
+func (s *Stream) notify() {
+	s.mutex.Lock()
+	close(s.signal)
+	s.signal = make(chan struct{})
+	s.mutex.Unlock()
+}
+
+var errTimeout = netx.Timeout("muxx: i/o timeout")
+
+// wait blocks until ch is closed or the deadline is reached.
+func wait(ch <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return errTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return errTimeout
+	}
+}
@@ -0,0 +1,513 @@
+package muxx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+const (
+	// DefaultTunnelHandshakeTimeout is the default amount of time that agents
+	// and tunnel servers wait for the handshake to complete.
+	DefaultTunnelHandshakeTimeout = 10 * time.Second
+
+	// DefaultTunnelRetryInterval is the default amount of time that an agent
+	// waits before reconnecting to the tunnel server, it is doubled after each
+	// failed attempt, up to 30 seconds.
+	DefaultTunnelRetryInterval = 1 * time.Second
+
+	maxTunnelRetryInterval = 30 * time.Second
+	tunnelProto            = "NETX-TUNNEL/1"
+)
+
+var (
+	// ErrNoTunnel is returned by TunnelServer.DialContext when no agent is
+	// registered under the requested name.
+	ErrNoTunnel = errors.New("muxx: no agent registered for the address")
+
+	errTunnelHandshake = errors.New("muxx: bad tunnel handshake")
+	errTunnelNoAuth    = errors.New("agent registration is disabled")
+)
+
+// TunnelError is returned by TunnelAgent when the tunnel server rejected the
+// agent.
+type TunnelError struct {
+	Reason string
+}
+
+// Error satisfies the error interface.
+func (e *TunnelError) Error() string {
+	return "muxx: tunnel rejected: " + e.Reason
+}
+
+// TunnelServer accepts connections from agents running behind a NAT or a
+// firewall, and lets the program open connections to them through the
+// multiplexed session established by each agent (reverse tunneling).
+//
+// Agents register under a name, which is used as the host part of the
+// addresses passed to DialContext. The method can be used as the dial
+// function of a http.Transport, so a reverse proxy routes the requests for a
+// given host to the agent that registered it:
+//
+//	tunnels := &muxx.TunnelServer{Authenticate: authenticate}
+//	go tunnels.ListenAndServe(":4443")
+//
+//	proxy := &httpx.ReverseProxy{
+//		Transport: &http.Transport{DialContext: tunnels.DialContext},
+//	}
+//
+// When several agents register under the same name, connections are spread
+// across them.
+type TunnelServer struct {
+	// Authenticate is called to verify the name and token sent by agents,
+	// returning an error rejects the agent. If nil, all agents are rejected,
+	// since registering lets an agent receive the connections dialed for its
+	// name.
+	Authenticate func(name string, token string) error
+
+	// Config is used to configure the sessions established with agents.
+	Config *Config
+
+	// HandshakeTimeout is the amount of time that agents have to register
+	// after connecting. If zero, DefaultTunnelHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// ErrorLog is used to report agents that failed to register. If nil, the
+	// standard logger is used.
+	ErrorLog *log.Logger
+
+	mutex  sync.Mutex
+	agents map[string][]*Session
+	lstns  map[net.Listener]struct{}
+	next   int
+	closed bool
+}
+
+// ListenAndServe listens on the network address addr and then calls Serve to
+// accept agents.
+func (s *TunnelServer) ListenAndServe(addr string) error {
+	lstn, err := netx.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lstn)
+}
+
+// Serve accepts agents on lstn until it is closed or the server is closed.
+//
+// After Close was called, Serve returns netx.ErrServerClosed.
+func (s *TunnelServer) Serve(lstn net.Listener) error {
+	defer lstn.Close()
+
+	if !s.trackListener(lstn) {
+		return netx.ErrServerClosed
+	}
+	defer s.untrackListener(lstn)
+
+	err := netx.AcceptLoop(lstn, func(conn net.Conn) { go s.serveAgent(conn) })
+
+	if s.isClosed() {
+		err = netx.ErrServerClosed
+	}
+	return err
+}
+
+// DialContext opens a connection to the agent registered under the host part
+// of address, the network is ignored.
+func (s *TunnelServer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	name, _, err := net.SplitHostPort(address)
+	if err != nil {
+		name = address
+	}
+
+	for {
+		session := s.lookup(name)
+		if session == nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: ErrNoTunnel}
+		}
+
+		conn, err := session.Open()
+		if err == nil {
+			return conn, nil
+		}
+
+		// The session was closed or the agent is going away, it is removed so
+		// another agent can be tried.
+		s.unregister(name, session)
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Agents returns the sorted list of names that agents are registered under.
+func (s *TunnelServer) Agents() []string {
+	s.mutex.Lock()
+	names := make([]string, 0, len(s.agents))
+	for name := range s.agents {
+		names = append(names, name)
+	}
+	s.mutex.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the listeners and the sessions of all agents.
+func (s *TunnelServer) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	lstns := s.lstns
+	agents := s.agents
+	s.lstns, s.agents = nil, nil
+	s.mutex.Unlock()
+
+	for lstn := range lstns {
+		lstn.Close()
+	}
+	for _, sessions := range agents {
+		for _, session := range sessions {
+			session.Close()
+		}
+	}
+	return nil
+}
+
+func (s *TunnelServer) serveAgent(conn net.Conn) {
+	timeout := s.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultTunnelHandshakeTimeout
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	bc := netx.NewBufferedConn(conn)
+
+	name, token, err := readTunnelHello(bc)
+	if err == nil {
+		if s.Authenticate == nil {
+			err = errTunnelNoAuth
+		} else {
+			err = s.Authenticate(name, token)
+		}
+		if err != nil {
+			io.WriteString(conn, "ERR "+strings.ReplaceAll(err.Error(), "\n", " ")+"\n")
+		}
+	}
+	if err == nil {
+		_, err = io.WriteString(conn, "OK\n")
+	}
+	if err != nil {
+		s.logf("muxx: agent %s failed to register: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+	session := NewServer(bc, s.Config)
+
+	if !s.register(name, session) {
+		session.Close()
+		return
+	}
+
+	<-session.Done()
+	s.unregister(name, session)
+}
+
+func (s *TunnelServer) register(name string, session *Session) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	if s.agents == nil {
+		s.agents = make(map[string][]*Session)
+	}
+
+	s.agents[name] = append(s.agents[name], session)
+	return true
+}
+
+func (s *TunnelServer) unregister(name string, session *Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := s.agents[name]
+	for i, x := range sessions {
+		if x == session {
+			sessions = append(sessions[:i:i], sessions[i+1:]...)
+			break
+		}
+	}
+
+	if len(sessions) == 0 {
+		delete(s.agents, name)
+	} else {
+		s.agents[name] = sessions
+	}
+}
+
+func (s *TunnelServer) lookup(name string) *Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := s.agents[name]
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	s.next++
+	return sessions[s.next%len(sessions)]
+}
+
+func (s *TunnelServer) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *TunnelServer) trackListener(lstn net.Listener) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	if s.lstns == nil {
+		s.lstns = make(map[net.Listener]struct{})
+	}
+
+	s.lstns[lstn] = struct{}{}
+	return true
+}
+
+func (s *TunnelServer) untrackListener(lstn net.Listener) {
+	s.mutex.Lock()
+	delete(s.lstns, lstn)
+	s.mutex.Unlock()
+}
+
+func (s *TunnelServer) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// TunnelAgent connects to a TunnelServer and registers under a name, then
+// serves the connections that the server opens through the tunnel. It lets a
+// service running behind a NAT be reached through the tunnel server without
+// accepting inbound connections.
+type TunnelAgent struct {
+	// Addr is the address of the tunnel server.
+	Addr string
+
+	// Name is the name that the agent registers under.
+	Name string
+
+	// Token is sent to the server to authenticate the agent.
+	Token string
+
+	// DialContext is used to connect to the tunnel server, it may be set to
+	// establish TLS connections. If nil, a net.Dialer is used.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// Config is used to configure the session established with the server.
+	Config *Config
+
+	// HandshakeTimeout is the amount of time to wait for the server to accept
+	// the agent. If zero, DefaultTunnelHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// RetryInterval is the amount of time that Run waits before reconnecting
+	// to the server. If zero, DefaultTunnelRetryInterval is used.
+	RetryInterval time.Duration
+
+	// ErrorLog is used by Run to report connection errors. If nil, the
+	// standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// Connect connects to the tunnel server and registers the agent, the returned
+// session accepts the connections opened by the server.
+func (a *TunnelAgent) Connect(ctx context.Context) (*Session, error) {
+	if strings.ContainsAny(a.Name, " \r\n") || len(a.Name) == 0 || strings.ContainsAny(a.Token, " \r\n") {
+		return nil, errTunnelHandshake
+	}
+
+	dial := a.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", a.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := a.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultTunnelHandshakeTimeout
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	bc := netx.NewBufferedConn(conn)
+
+	hello := tunnelProto + " " + a.Name
+	if len(a.Token) != 0 {
+		hello += " " + a.Token
+	}
+
+	var line string
+	if _, err = io.WriteString(conn, hello+"\n"); err == nil {
+		line, err = readTunnelLine(bc)
+	}
+
+	if !stop() {
+		err = ctx.Err()
+	}
+
+	if err == nil && line != "OK" {
+		if reason, ok := strings.CutPrefix(line, "ERR "); ok {
+			err = &TunnelError{Reason: reason}
+		} else {
+			err = errTunnelHandshake
+		}
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return NewClient(bc, a.Config), nil
+}
+
+// Run connects to the tunnel server and forwards the connections opened
+// through the tunnel to the target address, reconnecting when the tunnel is
+// lost, until ctx is canceled.
+func (a *TunnelAgent) Run(ctx context.Context, target string) error {
+	retry := a.retryInterval()
+
+	for {
+		session, err := a.Connect(ctx)
+
+		if err == nil {
+			retry = a.retryInterval()
+			a.serve(ctx, session, target)
+		} else if ctx.Err() == nil {
+			a.logf("muxx: connecting to tunnel server %s: %s", a.Addr, err)
+		}
+
+		// Jitter the reconnections so agents don't all come back at the same
+		// time when the server restarts.
+		timer := time.NewTimer(retry/2 + time.Duration(rand.Int63n(int64(retry))))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if err != nil {
+			if retry *= 2; retry > maxTunnelRetryInterval {
+				retry = maxTunnelRetryInterval
+			}
+		}
+	}
+}
+
+func (a *TunnelAgent) serve(ctx context.Context, session *Session, target string) {
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+	defer session.Close()
+
+	for {
+		from, err := session.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer from.Close()
+
+			to, err := (&net.Dialer{}).DialContext(ctx, "tcp", target)
+			if err != nil {
+				a.logf("muxx: forwarding tunnel connection to %s: %s", target, err)
+				return
+			}
+			defer to.Close()
+
+			netx.TunnelRaw.ServeTunnel(ctx, from, to)
+		}()
+	}
+}
+
+func (a *TunnelAgent) retryInterval() time.Duration {
+	if a.RetryInterval > 0 {
+		return a.RetryInterval
+	}
+	return DefaultTunnelRetryInterval
+}
+
+func (a *TunnelAgent) logf(format string, args ...interface{}) {
+	if a.ErrorLog != nil {
+		a.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func readTunnelHello(r io.Reader) (name string, token string, err error) {
+	line, err := readTunnelLine(r)
+	if err != nil {
+		return
+	}
+
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 || len(fields) > 3 || fields[0] != tunnelProto || len(fields[1]) == 0 {
+		err = errTunnelHandshake
+		return
+	}
+
+	name = fields[1]
+	if len(fields) == 3 {
+		token = fields[2]
+	}
+	return
+}
+
+// readTunnelLine reads a handshake line from r, r is expected to be buffered
+// and keep the bytes that follow for the session.
+func readTunnelLine(r io.Reader) (string, error) {
+	var line []byte
+	var b [1]byte
+
+	for len(line) < 1024 {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+
+	return "", errTunnelHandshake
+}
@@ -0,0 +1,156 @@
+package muxx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func startTunnelServer(t *testing.T, server *TunnelServer) string {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lstn)
+	return lstn.Addr().String()
+}
+
+func waitAgents(t *testing.T, server *TunnelServer, n int) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if len(server.Agents()) == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("agents not registered:", server.Agents())
+}
+
+func TestTunnel(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "Hello from %s!", req.Host)
+	}))
+	defer backend.Close()
+
+	server := &TunnelServer{
+		Authenticate: func(name string, token string) error {
+			if token != "secret" {
+				return errors.New("bad token")
+			}
+			return nil
+		},
+	}
+	defer server.Close()
+	addr := startTunnelServer(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agent := &TunnelAgent{Addr: addr, Name: "myapp", Token: "secret"}
+	go agent.Run(ctx, backend.Listener.Addr().String())
+	waitAgents(t, server, 1)
+
+	client := &http.Client{Transport: &http.Transport{DialContext: server.DialContext}}
+	defer client.CloseIdleConnections()
+
+	res, err := client.Get("http://myapp/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(b) != "Hello from myapp!" {
+		t.Errorf("bad response: %q", b)
+	}
+
+	if _, err := client.Get("http://other/"); !errors.Is(err, ErrNoTunnel) {
+		t.Error("bad error for an unknown agent:", err)
+	}
+
+	// The agent is unregistered when it stops.
+	cancel()
+	waitAgents(t, server, 0)
+}
+
+func TestTunnelRejected(t *testing.T) {
+	server := &TunnelServer{
+		Authenticate: func(name string, token string) error { return errors.New("bad token") },
+		ErrorLog:     log.New(ioutil.Discard, "", 0),
+	}
+	defer server.Close()
+	addr := startTunnelServer(t, server)
+
+	agent := &TunnelAgent{Addr: addr, Name: "myapp", Token: "oops"}
+
+	_, err := agent.Connect(context.Background())
+	if e, ok := err.(*TunnelError); !ok || e.Reason != "bad token" {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestTunnelNoAuthenticate(t *testing.T) {
+	server := &TunnelServer{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	defer server.Close()
+	addr := startTunnelServer(t, server)
+
+	agent := &TunnelAgent{Addr: addr, Name: "myapp"}
+
+	if _, err := agent.Connect(context.Background()); err == nil {
+		t.Error("the agent registered on a server without an Authenticate function")
+	}
+}
+
+func acceptAllAgents(name string, token string) error { return nil }
+
+func TestTunnelSession(t *testing.T) {
+	server := &TunnelServer{Authenticate: acceptAllAgents}
+	defer server.Close()
+	addr := startTunnelServer(t, server)
+
+	agents := make([]*Session, 2)
+	for i := range agents {
+		session, err := (&TunnelAgent{Addr: addr, Name: "echo"}).Connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		agents[i] = session
+
+		go func(id int) {
+			for {
+				conn, err := session.Accept()
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "agent %d\n", id)
+				conn.Close()
+			}
+		}(i)
+	}
+	waitAgents(t, server, 1)
+
+	// Connections are spread across the agents registered under the same
+	// name.
+	seen := map[string]bool{}
+	for i := 0; i != 4; i++ {
+		conn, err := server.DialContext(context.Background(), "tcp", "echo:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(conn)
+		conn.Close()
+		seen[string(b)] = true
+	}
+
+	if len(seen) != 2 {
+		t.Error("connections not balanced across agents:", seen)
+	}
+}
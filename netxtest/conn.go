@@ -0,0 +1,285 @@
+// Package netxtest provides in-memory network connections and listeners which
+// behave like their TCP counterparts, for use in tests that shouldn't depend on
+// real sockets.
+package netxtest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultBufferSize is the default amount of data that may be written to a
+// connection before the writes block waiting for the peer to read.
+const DefaultBufferSize = 64 * 1024
+
+// Addr is the address of in-memory connections and listeners.
+type Addr string
+
+// Network returns "memory".
+func (a Addr) Network() string {
+	return "memory"
+}
+
+// String returns a as a string.
+func (a Addr) String() string {
+	return string(a)
+}
+
+// Conn is an end of an in-memory, full-duplex connection created by Pipe or by
+// dialing a Listener.
+//
+// Unlike net.Pipe, writes are buffered up to a limit and may complete before
+// the peer reads them, reads may return less data than what was written in a
+// single call, and the connections support half-closing, so a Conn behaves
+// like a TCP connection would.
+type Conn struct {
+	link   *link
+	rx     *buffer // data written by the peer
+	tx     *buffer // data written to the peer
+	laddr  net.Addr
+	raddr  net.Addr
+	closed bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// link is the state shared by both ends of a connection.
+type link struct {
+	mutex sync.Mutex
+
+	// signal is closed and replaced when the state of the connection changes,
+	// to wake up the goroutines blocked reading or writing.
+	signal chan struct{}
+}
+
+// buffer holds the data sent in one direction of a connection.
+type buffer struct {
+	data bytes.Buffer
+	size int
+	eof  bool // the writer won't send more data
+	shut bool // the reader won't read more data
+}
+
+// Pipe creates an in-memory connection, returning both ends of it. The
+// connection buffers up to DefaultBufferSize bytes in each direction.
+func Pipe() (*Conn, *Conn) {
+	return PipeSize(DefaultBufferSize)
+}
+
+// PipeSize is like Pipe but the connection buffers up to size bytes in each
+// direction.
+func PipeSize(size int) (*Conn, *Conn) {
+	return pipe(size, Addr("pipe"), Addr("pipe"))
+}
+
+func pipe(size int, addr1 net.Addr, addr2 net.Addr) (*Conn, *Conn) {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	l := &link{signal: make(chan struct{})}
+	b1 := &buffer{size: size}
+	b2 := &buffer{size: size}
+
+	c1 := &Conn{link: l, rx: b1, tx: b2, laddr: addr1, raddr: addr2}
+	c2 := &Conn{link: l, rx: b2, tx: b1, laddr: addr2, raddr: addr1}
+	return c1, c2
+}
+
+// Read satisfies the io.Reader interface.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.link.mutex.Lock()
+	defer c.link.mutex.Unlock()
+
+	for {
+		switch {
+		case c.closed:
+			return 0, c.opError("read", net.ErrClosed)
+		case expired(c.readDeadline):
+			return 0, c.opError("read", os.ErrDeadlineExceeded)
+		case c.rx.data.Len() != 0:
+			n, _ := c.rx.data.Read(b)
+			c.link.notify()
+			return n, nil
+		case len(b) == 0:
+			return 0, nil
+		case c.rx.eof || c.rx.shut:
+			return 0, io.EOF
+		}
+
+		c.link.wait(c.readDeadline)
+	}
+}
+
+// Write satisfies the io.Writer interface.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.link.mutex.Lock()
+	defer c.link.mutex.Unlock()
+
+	n := 0
+
+	for {
+		switch {
+		case c.closed:
+			return n, c.opError("write", net.ErrClosed)
+		case c.tx.eof || c.tx.shut:
+			return n, c.opError("write", os.NewSyscallError("write", syscall.EPIPE))
+		case expired(c.writeDeadline):
+			return n, c.opError("write", os.ErrDeadlineExceeded)
+		case n == len(b):
+			return n, nil
+		}
+
+		if space := c.tx.size - c.tx.data.Len(); space != 0 {
+			chunk := b[n:]
+			if len(chunk) > space {
+				chunk = chunk[:space]
+			}
+			c.tx.data.Write(chunk)
+			n += len(chunk)
+			c.link.notify()
+			continue
+		}
+
+		c.link.wait(c.writeDeadline)
+	}
+}
+
+// Close closes the connection, the peer reads io.EOF once it consumed the data
+// that was already written, and gets an error when writing to the connection.
+func (c *Conn) Close() error {
+	c.link.mutex.Lock()
+	defer c.link.mutex.Unlock()
+
+	if c.closed {
+		return c.opError("close", net.ErrClosed)
+	}
+
+	c.closed = true
+	c.tx.eof = true
+	c.rx.shut = true
+	c.rx.data.Reset()
+	c.link.notify()
+	return nil
+}
+
+// CloseRead shuts down the reading side of the connection.
+func (c *Conn) CloseRead() error {
+	c.link.mutex.Lock()
+	defer c.link.mutex.Unlock()
+
+	if c.closed {
+		return c.opError("close", net.ErrClosed)
+	}
+
+	c.rx.shut = true
+	c.rx.data.Reset()
+	c.link.notify()
+	return nil
+}
+
+// CloseWrite shuts down the writing side of the connection, the peer reads
+// io.EOF once it consumed the data that was already written.
+func (c *Conn) CloseWrite() error {
+	c.link.mutex.Lock()
+	defer c.link.mutex.Unlock()
+
+	if c.closed {
+		return c.opError("close", net.ErrClosed)
+	}
+
+	c.tx.eof = true
+	c.link.notify()
+	return nil
+}
+
+// LocalAddr returns the local address of the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.setDeadline(t, true, true)
+}
+
+// SetReadDeadline sets the read deadline of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(t, true, false)
+}
+
+// SetWriteDeadline sets the write deadline of the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(t, false, true)
+}
+
+func (c *Conn) setDeadline(t time.Time, read bool, write bool) error {
+	c.link.mutex.Lock()
+	defer c.link.mutex.Unlock()
+
+	if c.closed {
+		return c.opError("set", net.ErrClosed)
+	}
+
+	if read {
+		c.readDeadline = t
+	}
+	if write {
+		c.writeDeadline = t
+	}
+
+	c.link.notify()
+	return nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    "memory",
+		Source: c.laddr,
+		Addr:   c.raddr,
+		Err:    err,
+	}
+}
+
+// notify wakes up the goroutines waiting on the link, the mutex must be held.
+func (l *link) notify() {
+	close(l.signal)
+	l.signal = make(chan struct{})
+}
+
+// wait releases the mutex until the state of the link changes or the deadline
+// is reached, the mutex must be held.
+func (l *link) wait(deadline time.Time) {
+	var timeout <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	signal := l.signal
+	l.mutex.Unlock()
+	defer l.mutex.Lock()
+
+	select {
+	case <-signal:
+	case <-timeout:
+	}
+}
+
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
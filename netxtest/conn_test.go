@@ -0,0 +1,116 @@
+package netxtest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+func TestConn(t *testing.T) {
+	nettest.TestConn(t, func() (c1 net.Conn, c2 net.Conn, stop func(), err error) {
+		p1, p2 := Pipe()
+		c1, c2 = p1, p2
+		stop = func() {
+			c1.Close()
+			c2.Close()
+		}
+		return
+	})
+}
+
+func TestConnBufferedWrite(t *testing.T) {
+	c1, c2 := PipeSize(8)
+	defer c1.Close()
+	defer c2.Close()
+
+	// Writes complete without the peer reading as long as the data fits in the
+	// buffer.
+	if n, err := c1.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatal(n, err)
+	}
+
+	c1.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+
+	n, err := c1.Write([]byte("world"))
+	if n != 3 {
+		t.Error("bad number of bytes written:", n)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("expected a timeout error but got", err)
+	}
+
+	b := make([]byte, 3)
+
+	if n, err := c2.Read(b); n != 3 || err != nil || string(b) != "hel" {
+		t.Error("bad partial read:", n, err, string(b))
+	}
+
+	if b, _ := ioutil.ReadAll(io.LimitReader(c2, 5)); string(b) != "lowor" {
+		t.Errorf("bad data read: %q", b)
+	}
+}
+
+func TestConnCloseWrite(t *testing.T) {
+	c1, c2 := Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	c1.Write([]byte("request"))
+	c1.CloseWrite()
+
+	b, err := ioutil.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "request" {
+		t.Errorf("bad request: %q", b)
+	}
+
+	// The other direction remains open after the half-close.
+	c2.Write([]byte("response"))
+	c2.Close()
+
+	if b, _ := ioutil.ReadAll(c1); string(b) != "response" {
+		t.Errorf("bad response: %q", b)
+	}
+
+	if _, err := c1.Write([]byte("more")); !errors.Is(err, syscall.EPIPE) {
+		t.Error("expected a broken pipe error but got", err)
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	c1, c2 := Pipe()
+
+	c1.Write([]byte("bye"))
+
+	if err := c1.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err := c1.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Error("expected an error when closing twice but got", err)
+	}
+
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Error("expected an error reading a closed connection but got", err)
+	}
+
+	// The data written before closing the connection is still readable.
+	if b, err := ioutil.ReadAll(c2); err != nil || string(b) != "bye" {
+		t.Errorf("bad data read: %q %v", b, err)
+	}
+
+	if _, err := c2.Write([]byte("hello")); !errors.Is(err, syscall.EPIPE) {
+		t.Error("expected a broken pipe error but got", err)
+	}
+
+	c2.Close()
+}
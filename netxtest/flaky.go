@@ -0,0 +1,113 @@
+package netxtest
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Chaos describes the failures injected by listeners wrapped with Flaky.
+//
+// Failures are drawn from a random source seeded with Seed, each call to
+// Accept draws them in the same order, so a test accepting connections in the
+// same sequence observes the same failures on every run.
+type Chaos struct {
+	// Delay is the time that Accept waits for before returning the percentage
+	// of connections set in DelayPercent.
+	Delay        time.Duration
+	DelayPercent float64
+
+	// ErrorPercent is the percentage of calls to Accept which fail with Error
+	// without accepting a connection. If Error is nil, a temporary error
+	// reporting that the connection was aborted is returned.
+	Error        error
+	ErrorPercent float64
+
+	// ClosePercent is the percentage of accepted connections which are closed
+	// before being returned, as if the client had gone away right after
+	// connecting.
+	ClosePercent float64
+
+	// Seed is the seed of the random source used to inject failures.
+	Seed int64
+}
+
+// Flaky wraps lstn to inject the failures described by chaos in its Accept
+// method, which is useful to exercise the resilience of accept loops.
+func Flaky(lstn net.Listener, chaos Chaos) net.Listener {
+	return &flakyListener{
+		Listener: lstn,
+		chaos:    chaos,
+		rand:     rand.New(rand.NewSource(chaos.Seed)),
+		done:     make(chan struct{}),
+	}
+}
+
+type flakyListener struct {
+	net.Listener
+	chaos Chaos
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	delay := l.chance(l.chaos.DelayPercent)
+	fail := l.chance(l.chaos.ErrorPercent)
+	abort := l.chance(l.chaos.ClosePercent)
+	l.mutex.Unlock()
+
+	if fail {
+		if err := l.chaos.Error; err != nil {
+			return nil, err
+		}
+		return nil, &net.OpError{
+			Op:   "accept",
+			Net:  l.Addr().Network(),
+			Addr: l.Addr(),
+			Err:  syscall.ECONNABORTED,
+		}
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if delay && l.chaos.Delay > 0 {
+		timer := time.NewTimer(l.chaos.Delay)
+		select {
+		case <-timer.C:
+		case <-l.done:
+			timer.Stop()
+			conn.Close()
+			return nil, &net.OpError{
+				Op:   "accept",
+				Net:  l.Addr().Network(),
+				Addr: l.Addr(),
+				Err:  net.ErrClosed,
+			}
+		}
+	}
+
+	if abort {
+		conn.Close()
+	}
+
+	return conn, nil
+}
+
+func (l *flakyListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *flakyListener) chance(percent float64) bool {
+	return percent > 0 && l.rand.Float64()*100 < percent
+}
@@ -0,0 +1,122 @@
+package netxtest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+func TestFlakyError(t *testing.T) {
+	lstn := Flaky(NewListener("server"), Chaos{ErrorPercent: 100})
+	defer lstn.Close()
+
+	_, err := lstn.Accept()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if !netx.IsTemporary(err) {
+		t.Error("the error is not temporary:", err)
+	}
+}
+
+func TestFlakyClose(t *testing.T) {
+	base := NewListener("server")
+	lstn := Flaky(base, Chaos{ClosePercent: 100})
+	defer lstn.Close()
+
+	client, _ := base.Dial()
+	defer client.Close()
+
+	conn, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Error("expected the accepted connection to be closed but got", err)
+	}
+
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("expected the client to see the connection closing but got", err)
+	}
+}
+
+func TestFlakyDelay(t *testing.T) {
+	base := NewListener("server")
+	lstn := Flaky(base, Chaos{Delay: 20 * time.Millisecond, DelayPercent: 100})
+
+	client, _ := base.Dial()
+	defer client.Close()
+
+	start := time.Now()
+	conn, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Error("the accept was not delayed:", elapsed)
+	}
+
+	// Closing the listener interrupts the delayed accepts.
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		lstn.Close()
+	}()
+
+	base.Dial()
+
+	if _, err := lstn.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Error("expected the accept to be interrupted but got", err)
+	}
+}
+
+func TestFlakyAcceptLoop(t *testing.T) {
+	const clients = 20
+
+	base := NewListener("server")
+	lstn := Flaky(base, Chaos{
+		Delay:        time.Millisecond,
+		DelayPercent: 50,
+		ErrorPercent: 30,
+		ClosePercent: 25,
+		Seed:         1,
+	})
+
+	accepted := make(chan net.Conn, clients)
+	done := make(chan error)
+
+	go func() {
+		done <- netx.AcceptLoop(lstn, func(conn net.Conn) { accepted <- conn })
+	}()
+
+	for i := 0; i != clients; i++ {
+		client, err := base.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+	}
+
+	// Despite the failures the loop keeps running and accepts all connections.
+	for i := 0; i != clients; i++ {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for connections to be accepted")
+		}
+	}
+
+	lstn.Close()
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,277 @@
+package netxtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DefaultLeakTimeout is the default amount of time that a LeakDetector waits
+// for connections to be closed and goroutines to exit before reporting them.
+const DefaultLeakTimeout = 1 * time.Second
+
+// LeakDetector verifies that a test doesn't leak connections, listeners, or
+// goroutines running the code of the netx packages, like the goroutines
+// forwarding data between the connections of a proxy.
+//
+// Connections and listeners are only tracked when they are wrapped by the
+// detector, for example:
+//
+//	leaks := netxtest.VerifyNone(t)
+//
+//	lstn, err := netx.Listen("127.0.0.1:0")
+//	...
+//	lstn = leaks.Listener(lstn)
+//
+// Goroutines are detected by comparing the ones running at the end of the test
+// to those that existed when the detector was created, which means the detector
+// can't be used by tests running in parallel with other tests.
+type LeakDetector struct {
+	// Timeout is the amount of time to wait for connections to be closed and
+	// goroutines to exit before reporting them as leaked. Zero means to use
+	// DefaultLeakTimeout.
+	Timeout time.Duration
+
+	t          testing.TB
+	goroutines map[string]bool
+
+	mutex sync.Mutex
+	open  map[interface{}]string
+}
+
+// VerifyNone returns a leak detector which fails t if any of the connections
+// and listeners tracked by the detector are still open, or if goroutines of
+// the netx packages are still running, when the test ends.
+func VerifyNone(t testing.TB) *LeakDetector {
+	d := &LeakDetector{
+		t:          t,
+		goroutines: make(map[string]bool),
+		open:       make(map[interface{}]string),
+	}
+
+	for _, g := range goroutines() {
+		d.goroutines[g.id] = true
+	}
+
+	t.Cleanup(d.Verify)
+	return d
+}
+
+// Verify fails the test if the detector found leaks. It is called
+// automatically when the test ends, but may be called earlier to verify that
+// a component released its resources.
+func (d *LeakDetector) Verify() {
+	d.t.Helper()
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = DefaultLeakTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		conns, stacks := d.openResources(), d.leakedGoroutines()
+
+		if len(conns) == 0 && len(stacks) == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			for _, c := range conns {
+				d.t.Errorf("leaked %s", c)
+			}
+			for _, s := range stacks {
+				d.t.Errorf("leaked goroutine:\n%s", s)
+			}
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Conn returns a wrapper of conn which is tracked by the detector until it is
+// closed.
+//
+// The returned connection exposes conn through its BaseConn method.
+func (d *LeakDetector) Conn(conn net.Conn) net.Conn {
+	c := &leakConn{Conn: conn, detector: d}
+	d.track(c, fmt.Sprintf("connection %s->%s opened at %s", conn.LocalAddr(), conn.RemoteAddr(), caller()))
+	return c
+}
+
+// Listener returns a wrapper of lstn which is tracked by the detector until it
+// is closed, the connections it accepts are tracked as well.
+func (d *LeakDetector) Listener(lstn net.Listener) net.Listener {
+	l := &leakListener{Listener: lstn, detector: d}
+	d.track(l, fmt.Sprintf("listener %s opened at %s", lstn.Addr(), caller()))
+	return l
+}
+
+// DialFunc wraps dial to track the connections it returns. The function may
+// be used as the dial function of a http.Transport.
+func (d *LeakDetector) DialFunc(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return d.Conn(conn), nil
+	}
+}
+
+func (d *LeakDetector) track(resource interface{}, desc string) {
+	d.mutex.Lock()
+	d.open[resource] = desc
+	d.mutex.Unlock()
+}
+
+func (d *LeakDetector) untrack(resource interface{}) {
+	d.mutex.Lock()
+	delete(d.open, resource)
+	d.mutex.Unlock()
+}
+
+func (d *LeakDetector) openResources() []string {
+	d.mutex.Lock()
+	list := make([]string, 0, len(d.open))
+	for _, desc := range d.open {
+		list = append(list, desc)
+	}
+	d.mutex.Unlock()
+	sort.Strings(list)
+	return list
+}
+
+func (d *LeakDetector) leakedGoroutines() []string {
+	var stacks []string
+	self := currentGoroutine()
+
+	for _, g := range goroutines() {
+		if g.id != self && !d.goroutines[g.id] && runsNetx(g.stack) {
+			stacks = append(stacks, g.stack)
+		}
+	}
+
+	return stacks
+}
+
+type leakConn struct {
+	net.Conn
+	detector *LeakDetector
+}
+
+func (c *leakConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *leakConn) Close() error {
+	c.detector.untrack(c)
+	return c.Conn.Close()
+}
+
+type leakListener struct {
+	net.Listener
+	detector *LeakDetector
+}
+
+func (l *leakListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &leakConn{Conn: conn, detector: l.detector}
+	l.detector.track(c, fmt.Sprintf("connection %s->%s accepted on %s", conn.LocalAddr(), conn.RemoteAddr(), l.Addr()))
+	return c, nil
+}
+
+func (l *leakListener) Close() error {
+	l.detector.untrack(l)
+	return l.Listener.Close()
+}
+
+type goroutine struct {
+	id    string
+	stack string
+}
+
+// goroutines returns the list of goroutines currently running in the program.
+func goroutines() []goroutine {
+	var list []goroutine
+
+	for _, s := range strings.Split(string(stacks(true)), "\n\n") {
+		if id := goroutineID(s); len(id) != 0 {
+			list = append(list, goroutine{id: id, stack: s})
+		}
+	}
+
+	return list
+}
+
+func currentGoroutine() string {
+	return goroutineID(string(stacks(false)))
+}
+
+func stacks(all bool) []byte {
+	for size := 64 * 1024; ; size *= 2 {
+		b := make([]byte, size)
+		if n := runtime.Stack(b, all); n < size {
+			return bytes.TrimSpace(b[:n])
+		}
+	}
+}
+
+// goroutineID parses the ID of a goroutine from the header of its stack trace,
+// which looks like "goroutine 42 [running]:".
+func goroutineID(stack string) string {
+	const prefix = "goroutine "
+
+	if !strings.HasPrefix(stack, prefix) {
+		return ""
+	}
+
+	stack = stack[len(prefix):]
+
+	if i := strings.IndexByte(stack, ' '); i >= 0 {
+		return stack[:i]
+	}
+	return ""
+}
+
+// runsNetx returns true if one of the functions of the stack belongs to the
+// netx packages, excluding the netxtest package.
+func runsNetx(stack string) bool {
+	const pkg = "github.com/segmentio/netx"
+
+	for _, line := range strings.Split(stack, "\n") {
+		if !strings.HasPrefix(line, pkg) {
+			continue
+		}
+		if rest := line[len(pkg):]; strings.HasPrefix(rest, ".") || (strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, "/netxtest.")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// caller returns the location of the code which called the detector.
+func caller() string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/segmentio/netx/netxtest.(*LeakDetector)") || !more {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+	}
+}
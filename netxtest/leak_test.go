@@ -0,0 +1,127 @@
+package netxtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// leakTB captures the errors reported by leak detectors.
+type leakTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (t *leakTB) Helper() {}
+
+func (t *leakTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *leakTB) Cleanup(f func()) {
+	t.cleanups = append(t.cleanups, f)
+}
+
+func (t *leakTB) end() {
+	for _, f := range t.cleanups {
+		f()
+	}
+}
+
+func TestLeakDetectorNone(t *testing.T) {
+	tb := &leakTB{TB: t}
+	leaks := VerifyNone(tb)
+
+	base := NewListener("server")
+	lstn := leaks.Listener(base)
+	dial := leaks.DialFunc(base.DialContext)
+
+	client, _ := dial(context.Background(), "memory", "server")
+	server, _ := lstn.Accept()
+
+	done := make(chan struct{})
+	go func() {
+		netx.Copy(ioutil.Discard, server)
+		close(done)
+	}()
+
+	client.Write([]byte("hello"))
+	client.Close()
+	<-done
+	server.Close()
+	lstn.Close()
+
+	tb.end()
+
+	if len(tb.errors) != 0 {
+		t.Error("unexpected leaks:", tb.errors)
+	}
+}
+
+func TestLeakDetectorConns(t *testing.T) {
+	tb := &leakTB{TB: t}
+	leaks := VerifyNone(tb)
+	leaks.Timeout = 10 * time.Millisecond
+
+	c1, c2 := Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	leaks.Conn(c1)
+	leaks.Listener(NewListener("server"))
+
+	tb.end()
+
+	if len(tb.errors) != 2 {
+		t.Fatal("bad number of errors:", tb.errors)
+	}
+
+	if !strings.HasPrefix(tb.errors[0], "leaked connection pipe->pipe opened at ") || !strings.Contains(tb.errors[0], "leak_test.go") {
+		t.Error("bad connection leak report:", tb.errors[0])
+	}
+
+	if !strings.HasPrefix(tb.errors[1], "leaked listener server opened at ") {
+		t.Error("bad listener leak report:", tb.errors[1])
+	}
+}
+
+func TestLeakDetectorGoroutines(t *testing.T) {
+	tb := &leakTB{TB: t}
+	leaks := VerifyNone(tb)
+	leaks.Timeout = 10 * time.Millisecond
+
+	c1, c2 := Pipe()
+	r, w := Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		netx.Copy(w, c1)
+		close(done)
+	}()
+
+	// Wait for the copy to be running before ending the test.
+	c2.Write([]byte("x"))
+	r.Read(make([]byte, 1))
+
+	tb.end()
+
+	c2.Close()
+	<-done
+	c1.Close()
+	r.Close()
+	w.Close()
+
+	if len(tb.errors) != 1 {
+		t.Fatal("bad number of errors:", tb.errors)
+	}
+
+	if !strings.Contains(tb.errors[0], "github.com/segmentio/netx.Copy") {
+		t.Error("bad goroutine leak report:", tb.errors[0])
+	}
+}
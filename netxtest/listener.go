@@ -0,0 +1,143 @@
+package netxtest
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// DefaultBacklog is the default number of connections that may be dialed to a
+// listener before they are accepted.
+const DefaultBacklog = 128
+
+// Listener is an in-memory implementation of net.Listener, connections are
+// established by calling Dial or DialContext on the listener.
+//
+// A typical use is to serve a HTTP handler on a listener and configure the
+// DialContext function of a http.Transport to connect to it:
+//
+//	lstn := netxtest.NewListener("server")
+//	go http.Serve(lstn, handler)
+//
+//	client := &http.Client{
+//		Transport: &http.Transport{DialContext: lstn.DialContext},
+//	}
+type Listener struct {
+	addr    Addr
+	size    int
+	conns   chan *Conn
+	done    chan struct{}
+	once    sync.Once
+	clients uint64
+}
+
+// NewListener returns a listener with the given address, which may be any
+// string, that accepts up to DefaultBacklog pending connections.
+func NewListener(address string) *Listener {
+	return NewListenerSize(address, DefaultBacklog, DefaultBufferSize)
+}
+
+// NewListenerSize is like NewListener but sets the size of the backlog of the
+// listener, and the amount of data buffered in each direction by the
+// connections.
+func NewListenerSize(address string, backlog int, size int) *Listener {
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	return &Listener{
+		addr:  Addr(address),
+		size:  size,
+		conns: make(chan *Conn, backlog),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.opError("accept", nil, net.ErrClosed)
+	}
+}
+
+// Close closes the listener, the connections that were dialed but not accepted
+// yet are closed.
+func (l *Listener) Close() error {
+	err := l.opError("close", nil, net.ErrClosed)
+
+	l.once.Do(func() {
+		close(l.done)
+		l.drain()
+		err = nil
+	})
+
+	return err
+}
+
+func (l *Listener) drain() {
+	for {
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Addr returns the address of the listener.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Dial establishes a connection to the listener.
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "memory", l.addr.String())
+}
+
+// DialContext establishes a connection to the listener, network and address
+// are ignored so the method can be used as the dial function of a
+// http.Transport or a net.Dialer replacement. The call blocks while the
+// backlog of the listener is full, until ctx is canceled.
+func (l *Listener) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	laddr := Addr("client-" + strconv.FormatUint(atomic.AddUint64(&l.clients, 1), 10))
+	client, server := pipe(l.size, laddr, l.addr)
+
+	select {
+	case <-l.done:
+		return nil, l.opError("dial", laddr, os.NewSyscallError("connect", syscall.ECONNREFUSED))
+	default:
+	}
+
+	select {
+	case l.conns <- server:
+		select {
+		case <-l.done:
+			// The listener was closed concurrently and may have been drained
+			// already, the connection would never be accepted.
+			l.drain()
+		default:
+		}
+		return client, nil
+	case <-l.done:
+		return nil, l.opError("dial", laddr, os.NewSyscallError("connect", syscall.ECONNREFUSED))
+	case <-ctx.Done():
+		return nil, l.opError("dial", laddr, ctx.Err())
+	}
+}
+
+func (l *Listener) opError(op string, source net.Addr, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    "memory",
+		Source: source,
+		Addr:   l.addr,
+		Err:    err,
+	}
+}
@@ -0,0 +1,76 @@
+package netxtest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenerHTTP(t *testing.T) {
+	lstn := NewListener("server")
+	defer lstn.Close()
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Write([]byte(req.RemoteAddr))
+		}),
+	}
+	go server.Serve(lstn)
+
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: lstn.DialContext},
+	}
+
+	for i := 0; i != 3; i++ {
+		res, err := client.Get("http://server/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		// The connection is reused by the transport.
+		if string(b) != "client-1" {
+			t.Errorf("bad remote address: %q", b)
+		}
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	lstn := NewListenerSize("server", 1, 0)
+
+	pending, err := lstn.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backlog is full, dialing blocks until the context is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := lstn.DialContext(ctx, "memory", "server"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected the dial to time out but got", err)
+	}
+
+	if err := lstn.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := lstn.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Error("expected an error accepting on a closed listener but got", err)
+	}
+
+	if _, err := lstn.Dial(); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Error("expected the connection to be refused but got", err)
+	}
+
+	// The connection that was never accepted is closed.
+	if _, err := pending.Write([]byte("hello")); !errors.Is(err, syscall.EPIPE) {
+		t.Error("expected a broken pipe error but got", err)
+	}
+}
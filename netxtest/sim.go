@@ -0,0 +1,314 @@
+package netxtest
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Conditions describes the network conditions simulated by connections wrapped
+// with Simulate.
+//
+// Faults are drawn from a random source seeded with Seed, so a test performing
+// the same sequence of operations observes the same faults on every run.
+type Conditions struct {
+	// Latency is the delay added to each read and write on the connection.
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to Latency.
+	Jitter time.Duration
+
+	// Bandwidth is the maximum number of bytes per second transferred in each
+	// direction, zero means unlimited.
+	Bandwidth int
+
+	// ResetPercent is the percentage of reads and writes which fail because
+	// the connection is reset.
+	ResetPercent float64
+
+	// EOFPercent is the percentage of reads which fail with io.EOF because the
+	// connection was closed prematurely.
+	EOFPercent float64
+
+	// ResetAfter is the number of bytes that may be read from and written to
+	// the connection before it is reset, zero means it is never reset.
+	ResetAfter int
+
+	// Seed is the seed of the random source used to inject faults.
+	Seed int64
+}
+
+// Simulate wraps conn to apply the network conditions to its reads and writes.
+//
+// Once a reset or EOF was injected the underlying connection is closed and all
+// following operations fail. Delays honor the deadlines set on the connection,
+// returning timeout errors if they expire before the operation could complete.
+//
+// The returned connection exposes conn through its BaseConn method.
+func Simulate(conn net.Conn, conditions Conditions) net.Conn {
+	return &simConn{
+		Conn: conn,
+		cond: conditions,
+		rand: rand.New(rand.NewSource(conditions.Seed)),
+		done: make(chan struct{}),
+	}
+}
+
+// SimulateListener wraps lstn so the connections it accepts apply the network
+// conditions. Each connection draws its faults from a random source seeded by
+// the order in which it was accepted.
+func SimulateListener(lstn net.Listener, conditions Conditions) net.Listener {
+	return &simListener{
+		Listener: lstn,
+		cond:     conditions,
+		rand:     rand.New(rand.NewSource(conditions.Seed)),
+	}
+}
+
+type simListener struct {
+	net.Listener
+	cond  Conditions
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	cond := l.cond
+	l.mutex.Lock()
+	cond.Seed = l.rand.Int63()
+	l.mutex.Unlock()
+
+	return Simulate(conn, cond), nil
+}
+
+type simConn struct {
+	net.Conn
+	cond Conditions
+
+	mutex         sync.Mutex
+	rand          *rand.Rand
+	err           error // error returned by all operations after a fault
+	transferred   int
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *simConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	delay, limit, err := c.before("read", len(b), c.cond.EOFPercent)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.sleep(delay, &c.readDeadline); err != nil {
+		return 0, c.sleepError("read", err)
+	}
+
+	// When the read is truncated because the connection is about to be reset,
+	// the reset is reported by the next operation.
+	n, err := c.Conn.Read(b[:limit])
+	c.after(n)
+	return n, err
+}
+
+func (c *simConn) Write(b []byte) (int, error) {
+	delay, limit, err := c.before("write", len(b), 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.sleep(delay, &c.writeDeadline); err != nil {
+		return 0, c.sleepError("write", err)
+	}
+
+	n, err := c.Conn.Write(b[:limit])
+	c.after(n)
+
+	if err == nil && limit < len(b) {
+		err = c.fault(c.reset("write"))
+	}
+	return n, err
+}
+
+func (c *simConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+func (c *simConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mutex.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *simConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *simConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.writeDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// before draws the faults and the delay of an operation transferring size
+// bytes, it returns the number of bytes that may be transferred before the
+// connection is reset.
+func (c *simConn) before(op string, size int, eofPercent float64) (time.Duration, int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return 0, 0, c.err
+	}
+
+	if c.chance(c.cond.ResetPercent) {
+		return 0, 0, c.faultLocked(c.reset(op))
+	}
+
+	if c.chance(eofPercent) {
+		return 0, 0, c.faultLocked(io.EOF)
+	}
+
+	limit := size
+	if c.cond.ResetAfter > 0 {
+		remain := c.cond.ResetAfter - c.transferred
+		if remain <= 0 {
+			return 0, 0, c.faultLocked(c.reset(op))
+		}
+		if limit > remain {
+			limit = remain
+		}
+	}
+
+	delay := c.cond.Latency
+	if c.cond.Jitter > 0 {
+		delay += time.Duration(c.rand.Int63n(int64(c.cond.Jitter)))
+	}
+
+	return delay, limit, nil
+}
+
+// after accounts for n bytes transferred, waiting for the time it takes to
+// transfer them at the simulated bandwidth.
+func (c *simConn) after(n int) {
+	c.mutex.Lock()
+	c.transferred += n
+	c.mutex.Unlock()
+
+	if c.cond.Bandwidth > 0 && n > 0 {
+		c.sleep(time.Duration(n)*time.Second/time.Duration(c.cond.Bandwidth), nil)
+	}
+}
+
+func (c *simConn) chance(percent float64) bool {
+	return percent > 0 && c.rand.Float64()*100 < percent
+}
+
+// fault records err as the error returned by all following operations, and
+// closes the underlying connection so the peer observes the fault as well.
+func (c *simConn) fault(err error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.faultLocked(err)
+}
+
+func (c *simConn) faultLocked(err error) error {
+	if c.err == nil {
+		c.err = err
+		c.once.Do(func() { close(c.done) })
+
+		// Discarding the unsent data causes a TCP connection to send a RST
+		// instead of a FIN.
+		if err != io.EOF {
+			if tcp, ok := c.Conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+		}
+		c.Conn.Close()
+	}
+	return c.err
+}
+
+func (c *simConn) reset(op string) error {
+	return c.opError(op, os.NewSyscallError(op, syscall.ECONNRESET))
+}
+
+// sleep waits for the delay, or until the deadline is reached or the
+// connection is closed.
+func (c *simConn) sleep(delay time.Duration, deadline *time.Time) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	var expired bool
+
+	if deadline != nil {
+		c.mutex.Lock()
+		t := *deadline
+		c.mutex.Unlock()
+
+		if !t.IsZero() {
+			if timeout := time.Until(t); timeout < delay {
+				delay, expired = timeout, true
+			}
+		}
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-c.done:
+			return net.ErrClosed
+		}
+	}
+
+	if expired {
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// sleepError returns the error of an operation interrupted while sleeping,
+// which is the injected fault if one happened concurrently.
+func (c *simConn) sleepError(op string, err error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.opError(op, err)
+}
+
+func (c *simConn) opError(op string, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    c.LocalAddr().Network(),
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    err,
+	}
+}
@@ -0,0 +1,172 @@
+package netxtest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSimulateLatency(t *testing.T) {
+	p1, p2 := Pipe()
+	c1 := Simulate(p1, Conditions{Latency: 20 * time.Millisecond})
+	defer c1.Close()
+	defer p2.Close()
+
+	start := time.Now()
+	c1.Write([]byte("hello"))
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Error("the write was not delayed:", elapsed)
+	}
+
+	c1.SetWriteDeadline(time.Now().Add(5 * time.Millisecond))
+
+	if _, err := c1.Write([]byte("world")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("expected a timeout error but got", err)
+	}
+
+	b := make([]byte, 10)
+	n, _ := p2.Read(b)
+
+	if string(b[:n]) != "hello" {
+		t.Errorf("bad data read: %q", b[:n])
+	}
+}
+
+func TestSimulateBandwidth(t *testing.T) {
+	p1, p2 := Pipe()
+	c1 := Simulate(p1, Conditions{Bandwidth: 1000})
+	defer c1.Close()
+	defer p2.Close()
+
+	start := time.Now()
+	c1.Write(make([]byte, 50))
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Error("the write was not throttled:", elapsed)
+	}
+}
+
+func TestSimulateFaults(t *testing.T) {
+	tests := []struct {
+		scenario string
+		cond     Conditions
+		op       func(net.Conn) error
+		err      error
+	}{
+		{
+			scenario: "reset on write",
+			cond:     Conditions{ResetPercent: 100},
+			op: func(c net.Conn) error {
+				_, err := c.Write([]byte("hello"))
+				return err
+			},
+			err: syscall.ECONNRESET,
+		},
+		{
+			scenario: "reset on read",
+			cond:     Conditions{ResetPercent: 100},
+			op: func(c net.Conn) error {
+				_, err := c.Read(make([]byte, 10))
+				return err
+			},
+			err: syscall.ECONNRESET,
+		},
+		{
+			scenario: "premature EOF",
+			cond:     Conditions{EOFPercent: 100},
+			op: func(c net.Conn) error {
+				_, err := c.Read(make([]byte, 10))
+				return err
+			},
+			err: io.EOF,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			p1, p2 := Pipe()
+			c1 := Simulate(p1, test.cond)
+			defer c1.Close()
+			defer p2.Close()
+
+			if err := test.op(c1); !errors.Is(err, test.err) {
+				t.Error("bad error:", err)
+			}
+
+			// The fault is permanent and observed by the peer.
+			if err := test.op(c1); !errors.Is(err, test.err) {
+				t.Error("bad error after the fault:", err)
+			}
+
+			if _, err := p2.Read(make([]byte, 10)); err != io.EOF {
+				t.Error("the peer didn't see the connection closing:", err)
+			}
+		})
+	}
+}
+
+func TestSimulateResetAfter(t *testing.T) {
+	p1, p2 := Pipe()
+	c1 := Simulate(p1, Conditions{ResetAfter: 8})
+	defer c1.Close()
+	defer p2.Close()
+
+	n, err := c1.Write([]byte("hello world"))
+	if n != 8 {
+		t.Error("bad number of bytes written:", n)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Error("expected a reset error but got", err)
+	}
+
+	if b, _ := ioutil.ReadAll(p2); string(b) != "hello wo" {
+		t.Errorf("bad data read: %q", b)
+	}
+}
+
+func TestSimulateSeed(t *testing.T) {
+	run := func() (faults []int) {
+		base := NewListener("server")
+		lstn := SimulateListener(base, Conditions{ResetPercent: 30, Seed: 42})
+		defer lstn.Close()
+
+		for i := 0; i != 10; i++ {
+			client, _ := base.Dial()
+			conn, _ := lstn.Accept()
+
+			for j := 0; j != 10; j++ {
+				if _, err := conn.Write([]byte("x")); err != nil {
+					faults = append(faults, i*10+j)
+					break
+				}
+			}
+
+			conn.Close()
+			client.Close()
+		}
+		return
+	}
+
+	f1 := run()
+	f2 := run()
+
+	if len(f1) == 0 {
+		t.Error("no faults were injected")
+	}
+
+	if len(f1) != len(f2) {
+		t.Fatal("the faults differ between runs:", f1, f2)
+	}
+
+	for i := range f1 {
+		if f1[i] != f2[i] {
+			t.Fatal("the faults differ between runs:", f1, f2)
+		}
+	}
+}
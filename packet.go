@@ -0,0 +1,293 @@
+package netx
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A PacketHandler manages the datagrams received by a packet-oriented server.
+//
+// The ServePacket method is called by a PacketServer for each datagram it
+// receives, with the socket that the datagram was read from, which handlers
+// use to send replies to addr. The packet buffer is reused after the method
+// returns, handlers must copy it to retain the data.
+type PacketHandler interface {
+	ServePacket(ctx context.Context, conn net.PacketConn, packet []byte, addr net.Addr)
+}
+
+// The PacketHandlerFunc type allows simple functions to be used as packet
+// handlers.
+type PacketHandlerFunc func(context.Context, net.PacketConn, []byte, net.Addr)
+
+// ServePacket calls f.
+func (f PacketHandlerFunc) ServePacket(ctx context.Context, conn net.PacketConn, packet []byte, addr net.Addr) {
+	f(ctx, conn, packet, addr)
+}
+
+// ListenAndServePacket listens on the address addr and then call ServePacket
+// to handle the incoming datagrams.
+func ListenAndServePacket(addr string, handler PacketHandler) error {
+	return (&PacketServer{
+		Addr:    addr,
+		Handler: handler,
+	}).ListenAndServe()
+}
+
+// ServePacket reads datagrams from conn and passes them to handler.
+func ServePacket(conn net.PacketConn, handler PacketHandler) error {
+	return (&PacketServer{
+		Handler: handler,
+	}).Serve(conn)
+}
+
+// A PacketServer defines parameters for running servers that receive
+// datagrams, it is the packet-oriented counterpart of Server.
+//
+// Datagrams are dispatched to a pool of worker goroutines, all datagrams sent
+// by a peer go to the same worker, so handlers see the datagrams of each peer
+// in the order they were received and never concurrently.
+type PacketServer struct {
+	Addr     string          // address to listen on
+	Handler  PacketHandler   // handler to invoke on received datagrams
+	ErrorLog *log.Logger     // the logger used to output internal errors
+	Context  context.Context // the base context used by the server
+
+	// Logger is the structured logger used to report read errors and panics
+	// of the handler. If nil, the messages are written to ErrorLog.
+	Logger Logger
+
+	// Workers is the number of goroutines that invoke the handler for each
+	// socket served. If zero, runtime.NumCPU is used.
+	Workers int
+
+	// QueueSize is the number of datagrams that may be waiting for a worker,
+	// the server stops reading from the socket when a worker's queue is full.
+	// If zero, 64 datagrams are queued per worker.
+	QueueSize int
+
+	mutex   sync.Mutex
+	conns   map[net.PacketConn]context.CancelFunc
+	serving sync.WaitGroup
+	closed  bool
+}
+
+type packet struct {
+	buf  *[]byte
+	n    int
+	addr net.Addr
+}
+
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 65535)
+		return &b
+	},
+}
+
+// ListenAndServe listens on the server address and then call Serve to handle
+// the incoming datagrams.
+func (s *PacketServer) ListenAndServe() (err error) {
+	var conn net.PacketConn
+
+	if conn, err = ListenPacket(s.Addr); err == nil {
+		err = s.Serve(conn)
+	}
+
+	return
+}
+
+// Serve reads datagrams from conn and dispatches them to the server's workers,
+// which invoke the handler's ServePacket method.
+//
+// The server becomes the owner of the socket which will be closed by the time
+// the Serve method returns, after all queued datagrams were handled.
+//
+// After Shutdown or Close were called, Serve returns ErrServerClosed.
+func (s *PacketServer) Serve(conn net.PacketConn) error {
+	defer conn.Close()
+
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if !s.trackConn(conn, cancel) {
+		return ErrServerClosed
+	}
+	defer s.untrackConn(conn)
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	queueSize := s.QueueSize
+	if queueSize <= 0 {
+		queueSize = 64
+	}
+
+	join := &sync.WaitGroup{}
+	queues := make([]chan packet, workers)
+
+	for i := range queues {
+		queues[i] = make(chan packet, queueSize)
+		join.Add(1)
+		go s.work(ctx, conn, queues[i], join)
+	}
+
+	defer join.Wait()
+
+	for _, q := range queues {
+		defer close(q)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	for {
+		buf := packetPool.Get().(*[]byte)
+		n, addr, err := conn.ReadFrom(*buf)
+
+		if err != nil {
+			packetPool.Put(buf)
+
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if IsTemporary(err) {
+				if s.Logger != nil {
+					s.Logger.Warn("read error", "local", conn.LocalAddr(), "err", err)
+				} else {
+					logf(s.ErrorLog)("ReadFrom error: %v", err)
+				}
+				continue
+			}
+			return err
+		}
+
+		select {
+		case queues[peerIndex(addr, len(queues))] <- packet{buf: buf, n: n, addr: addr}:
+		case <-ctx.Done():
+			packetPool.Put(buf)
+		}
+	}
+}
+
+// Shutdown gracefully stops the server, it stops reading from the sockets and
+// waits for the datagrams already received to be handled. The sockets stay
+// open until then so handlers can still send replies.
+//
+// If ctx expires before all datagrams were handled, Shutdown calls Close and
+// returns the context's error.
+func (s *PacketServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		// Wake up the reader, Serve returns once the queues are drained.
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes all the sockets of the server, and cancels the
+// contexts passed to the handlers.
+//
+// Close does not wait for the handlers to return.
+func (s *PacketServer) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+
+	for conn, cancel := range s.conns {
+		cancel()
+		conn.Close()
+	}
+
+	return nil
+}
+
+func (s *PacketServer) work(ctx context.Context, conn net.PacketConn, queue <-chan packet, join *sync.WaitGroup) {
+	defer join.Done()
+
+	for p := range queue {
+		s.serve(ctx, conn, p)
+		packetPool.Put(p.buf)
+	}
+}
+
+func (s *PacketServer) serve(ctx context.Context, conn net.PacketConn, p packet) {
+	defer func() {
+		if err := recover(); err != nil {
+			logPanic(s.Logger, s.ErrorLog, conn.LocalAddr(), p.addr, err)
+		}
+	}()
+	s.Handler.ServePacket(ctx, conn, (*p.buf)[:p.n], p.addr)
+}
+
+func (s *PacketServer) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *PacketServer) trackConn(conn net.PacketConn, cancel context.CancelFunc) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[net.PacketConn]context.CancelFunc)
+	}
+
+	s.conns[conn] = cancel
+	s.serving.Add(1)
+	return true
+}
+
+func (s *PacketServer) untrackConn(conn net.PacketConn) {
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+	s.serving.Done()
+}
+
+// peerIndex returns the index of the worker that datagrams from addr are
+// dispatched to.
+func peerIndex(addr net.Addr, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(addr.String()))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,217 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startPacketServer(t *testing.T, server *PacketServer) (net.Addr, <-chan error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(conn) }()
+	return conn.LocalAddr(), errs
+}
+
+func TestPacketServer(t *testing.T) {
+	server := &PacketServer{
+		Handler: PacketHandlerFunc(func(ctx context.Context, conn net.PacketConn, packet []byte, addr net.Addr) {
+			conn.WriteTo(packet, addr)
+		}),
+		Workers: 4,
+	}
+
+	addr, errs := startPacketServer(t, server)
+
+	client, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	var buf [32]byte
+
+	for _, msg := range []string{"Hello", "World"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := client.Read(buf[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(buf[:n]); s != msg {
+			t.Errorf("bad response: %q", s)
+		}
+	}
+
+	server.Close()
+
+	if err := <-errs; err != ErrServerClosed {
+		t.Error("bad error returned by Serve:", err)
+	}
+}
+
+func TestPacketServerPeerOrder(t *testing.T) {
+	const count = 100
+
+	mutex := sync.Mutex{}
+	received := make(map[string][]int)
+	done := make(chan struct{})
+	total := 0
+
+	server := &PacketServer{
+		Handler: PacketHandlerFunc(func(ctx context.Context, conn net.PacketConn, packet []byte, addr net.Addr) {
+			i, _ := strconv.Atoi(string(packet))
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			received[addr.String()] = append(received[addr.String()], i)
+			if total++; total == 2*count {
+				close(done)
+			}
+		}),
+		Workers:   8,
+		QueueSize: 2 * count,
+	}
+
+	addr, _ := startPacketServer(t, server)
+	defer server.Close()
+
+	for c := 0; c != 2; c++ {
+		client, err := net.Dial("udp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		for i := 0; i != count; i++ {
+			client.Write([]byte(strconv.Itoa(i)))
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		// Datagrams may be dropped by the kernel, only check the order of
+		// those that were received.
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for peer, seq := range received {
+		for i := 1; i < len(seq); i++ {
+			if seq[i] <= seq[i-1] {
+				t.Errorf("datagrams from %s were handled out of order: %v", peer, seq)
+				break
+			}
+		}
+	}
+}
+
+func TestPacketServerShutdown(t *testing.T) {
+	ready := make(chan struct{})
+	handled := make(chan struct{})
+
+	server := &PacketServer{
+		Handler: PacketHandlerFunc(func(ctx context.Context, conn net.PacketConn, packet []byte, addr net.Addr) {
+			close(ready)
+			time.Sleep(50 * time.Millisecond)
+
+			// The socket is still usable while the server shuts down.
+			if _, err := conn.WriteTo(packet, addr); err != nil {
+				t.Error(err)
+			}
+			close(handled)
+		}),
+	}
+
+	addr, errs := startPacketServer(t, server)
+
+	client, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("Hello"))
+	<-ready
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case <-handled:
+	default:
+		t.Error("Shutdown returned before the datagram was handled")
+	}
+
+	if err := <-errs; err != ErrServerClosed {
+		t.Error("bad error returned by Serve:", err)
+	}
+}
+
+func TestPacketServerRecover(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mutex := sync.Mutex{}
+	replied := make(chan struct{})
+
+	server := &PacketServer{
+		Handler: PacketHandlerFunc(func(ctx context.Context, conn net.PacketConn, packet []byte, addr net.Addr) {
+			if string(packet) == "panic" {
+				panic("oops")
+			}
+			close(replied)
+		}),
+		ErrorLog: log.New(writerFunc(func(b []byte) (int, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return buf.Write(b)
+		}), "", 0),
+	}
+
+	addr, _ := startPacketServer(t, server)
+	defer server.Close()
+
+	client, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("panic"))
+	client.Write([]byte("Hello"))
+
+	select {
+	case <-replied:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server stopped handling datagrams after a panic")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if s := buf.String(); !strings.Contains(s, "panic serving") || !strings.Contains(s, "oops") {
+		t.Error("bad log output:", s)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
@@ -0,0 +1,259 @@
+package netx
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// PcapWriter is a transcript sink which writes the recorded traffic to a pcap
+// capture file, the format used by tcpdump, that can be opened in tools like
+// Wireshark.
+//
+// Since recorders only see the bytes exchanged on connections, the TCP/IP
+// headers are synthesized from the addresses of the connections: opening a
+// connection produces a three-way handshake initiated by the remote peer, the
+// data read and written produce segments with consistent sequence numbers,
+// and closing the connection produces a FIN exchange. Connections with
+// addresses that aren't IP addresses (unix sockets for example) are written
+// as TCP connections between loopback addresses.
+//
+// Writers are safe to use concurrently from multiple goroutines. Errors
+// returned by the underlying writer are ignored.
+type PcapWriter struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	conns  map[uint64]*pcapConn
+	header bool
+	buf    []byte
+}
+
+// NewPcapWriter returns a new writer which outputs a pcap capture to w.
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	return &PcapWriter{w: w}
+}
+
+type pcapConn struct {
+	local  pcapEndpoint
+	remote pcapEndpoint
+}
+
+type pcapEndpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32
+}
+
+const (
+	pcapMagic    = 0xa1b2c3d4 // microsecond timestamps
+	pcapSnapLen  = 65535
+	pcapLinkType = 101 // LINKTYPE_RAW, packets start with an IPv4 or IPv6 header
+
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	// Maximum payload of synthesized segments, which keeps packets within the
+	// snapshot length.
+	pcapMaxSegment = pcapSnapLen - 40 - 20
+)
+
+// RecordEvent satisfies the TranscriptSink interface.
+func (p *PcapWriter) RecordEvent(e TranscriptEvent) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.header {
+		p.header = true
+		p.writeHeader()
+	}
+
+	c := p.conns[e.ConnID]
+
+	if c == nil {
+		c = newPcapConn(e.LocalAddr, e.RemoteAddr)
+
+		if p.conns == nil {
+			p.conns = make(map[uint64]*pcapConn)
+		}
+		p.conns[e.ConnID] = c
+
+		r, l := &c.remote, &c.local
+		p.writeSegment(e, r, l, tcpSYN, nil)
+		r.seq++
+		p.writeSegment(e, l, r, tcpSYN|tcpACK, nil)
+		l.seq++
+		p.writeSegment(e, r, l, tcpACK, nil)
+	}
+
+	switch e.Op {
+	case TranscriptRead:
+		p.writeData(e, &c.remote, &c.local, e.Data)
+
+	case TranscriptWrite:
+		p.writeData(e, &c.local, &c.remote, e.Data)
+
+	case TranscriptClose:
+		r, l := &c.remote, &c.local
+		p.writeSegment(e, l, r, tcpFIN|tcpACK, nil)
+		l.seq++
+		p.writeSegment(e, r, l, tcpFIN|tcpACK, nil)
+		r.seq++
+		p.writeSegment(e, l, r, tcpACK, nil)
+		delete(p.conns, e.ConnID)
+	}
+}
+
+func (p *PcapWriter) writeHeader() {
+	var b [24]byte
+	binary.LittleEndian.PutUint32(b[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(b[4:], 2) // version major
+	binary.LittleEndian.PutUint16(b[6:], 4) // version minor
+	binary.LittleEndian.PutUint32(b[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(b[20:], pcapLinkType)
+	p.w.Write(b[:])
+}
+
+func (p *PcapWriter) writeData(e TranscriptEvent, src *pcapEndpoint, dst *pcapEndpoint, data []byte) {
+	for len(data) != 0 {
+		n := len(data)
+		if n > pcapMaxSegment {
+			n = pcapMaxSegment
+		}
+		p.writeSegment(e, src, dst, tcpPSH|tcpACK, data[:n])
+		src.seq += uint32(n)
+		data = data[n:]
+	}
+}
+
+func (p *PcapWriter) writeSegment(e TranscriptEvent, src *pcapEndpoint, dst *pcapEndpoint, flags byte, data []byte) {
+	b := append(p.buf[:0], make([]byte, 16)...)
+	ip := len(b)
+
+	if v4 := src.ip.To4(); v4 != nil {
+		b = append(b, make([]byte, 20)...)
+		h := b[ip:]
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:], uint16(20+20+len(data)))
+		binary.BigEndian.PutUint16(h[6:], 0x4000) // don't fragment
+		h[8] = 64                                 // TTL
+		h[9] = 6                                  // TCP
+		copy(h[12:], v4)
+		copy(h[16:], dst.ip.To4())
+		binary.BigEndian.PutUint16(h[10:], ^uint16(checksum(0, h[:20])))
+	} else {
+		b = append(b, make([]byte, 40)...)
+		h := b[ip:]
+		h[0] = 0x60
+		binary.BigEndian.PutUint16(h[4:], uint16(20+len(data)))
+		h[6] = 6  // TCP
+		h[7] = 64 // hop limit
+		copy(h[8:], src.ip.To16())
+		copy(h[24:], dst.ip.To16())
+	}
+
+	tcp := len(b)
+	b = append(b, make([]byte, 20)...)
+	b = append(b, data...)
+
+	h := b[tcp:]
+	binary.BigEndian.PutUint16(h[0:], src.port)
+	binary.BigEndian.PutUint16(h[2:], dst.port)
+	binary.BigEndian.PutUint32(h[4:], src.seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(h[8:], dst.seq)
+	}
+	h[12] = 5 << 4 // data offset
+	h[13] = flags
+	binary.BigEndian.PutUint16(h[14:], 65535) // window
+	binary.BigEndian.PutUint16(h[16:], ^uint16(checksum(pseudoHeaderSum(src.ip, dst.ip, len(h)), h)))
+
+	size := len(b) - 16
+	binary.LittleEndian.PutUint32(b[0:], uint32(e.Time.Unix()))
+	binary.LittleEndian.PutUint32(b[4:], uint32(e.Time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(b[8:], uint32(size))
+	binary.LittleEndian.PutUint32(b[12:], uint32(size))
+
+	p.w.Write(b)
+	p.buf = b
+}
+
+func newPcapConn(laddr net.Addr, raddr net.Addr) *pcapConn {
+	c := &pcapConn{
+		local:  pcapEndpointOf(laddr, net.IPv4(127, 0, 0, 1)),
+		remote: pcapEndpointOf(raddr, net.IPv4(127, 0, 0, 2)),
+	}
+
+	// Both endpoints must use the same IP version, loopback addresses are used
+	// if they don't.
+	if (c.local.ip.To4() == nil) != (c.remote.ip.To4() == nil) {
+		c.local.ip = net.IPv4(127, 0, 0, 1)
+		c.remote.ip = net.IPv4(127, 0, 0, 2)
+	}
+
+	return c
+}
+
+func pcapEndpointOf(addr net.Addr, defaultIP net.IP) pcapEndpoint {
+	e := pcapEndpoint{ip: defaultIP}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a.IP != nil {
+			e.ip = a.IP
+		}
+		e.port = uint16(a.Port)
+	case *net.UDPAddr:
+		if a.IP != nil {
+			e.ip = a.IP
+		}
+		e.port = uint16(a.Port)
+	case nil:
+	default:
+		if h, p := SplitAddrPort(a.String()); p >= 0 {
+			if ip := net.ParseIP(h); ip != nil {
+				e.ip = ip
+			}
+			e.port = uint16(p)
+		}
+	}
+
+	return e
+}
+
+func pseudoHeaderSum(src net.IP, dst net.IP, length int) uint32 {
+	var sum uint32
+
+	if s4, d4 := src.To4(), dst.To4(); s4 != nil && d4 != nil {
+		sum = checksum(sum, s4)
+		sum = checksum(sum, d4)
+	} else {
+		sum = checksum(sum, src.To16())
+		sum = checksum(sum, dst.To16())
+	}
+
+	return sum + 6 + uint32(length)
+}
+
+// Checksum returns the internet checksum of b (RFC 1071), the checksum of the
+// headers of IPv4, ICMP, TCP, and UDP packets.
+func Checksum(b []byte) uint16 {
+	return ^uint16(checksum(0, b))
+}
+
+// checksum adds b to the internet checksum sum (RFC 1071), the returned value
+// is folded to 16 bits.
+func checksum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 != 0 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return sum
+}
@@ -0,0 +1,115 @@
+package netx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPcapWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pcap := NewPcapWriter(buf)
+
+	laddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}
+	raddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 54321}
+	now := time.Now()
+
+	for _, e := range []TranscriptEvent{
+		{Op: TranscriptOpen},
+		{Op: TranscriptRead, Data: []byte("Hello")},
+		{Op: TranscriptWrite, Data: []byte("World!")},
+		{Op: TranscriptClose},
+	} {
+		e.ConnID, e.LocalAddr, e.RemoteAddr, e.Time = 1, laddr, raddr, now
+		pcap.RecordEvent(e)
+	}
+
+	b := buf.Bytes()
+
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != pcapMagic || binary.LittleEndian.Uint32(b[20:]) != pcapLinkType {
+		t.Fatal("bad pcap header")
+	}
+	b = b[24:]
+
+	type segment struct {
+		src     uint16
+		flags   byte
+		payload string
+	}
+
+	expect := []segment{
+		{54321, tcpSYN, ""},
+		{8080, tcpSYN | tcpACK, ""},
+		{54321, tcpACK, ""},
+		{54321, tcpPSH | tcpACK, "Hello"},
+		{8080, tcpPSH | tcpACK, "World!"},
+		{8080, tcpFIN | tcpACK, ""},
+		{54321, tcpFIN | tcpACK, ""},
+		{8080, tcpACK, ""},
+	}
+
+	for i, s := range expect {
+		if len(b) < 16 {
+			t.Fatalf("missing packet #%d", i)
+		}
+		size := int(binary.LittleEndian.Uint32(b[8:]))
+		pkt := b[16 : 16+size]
+		b = b[16+size:]
+
+		if pkt[0] != 0x45 {
+			t.Fatalf("packet #%d is not an IPv4 packet", i)
+		}
+		if checksum(0, pkt[:20]) != 0xffff {
+			t.Errorf("packet #%d: bad IP checksum", i)
+		}
+
+		tcp := pkt[20:]
+		if checksum(pseudoHeaderSum(net.IP(pkt[12:16]), net.IP(pkt[16:20]), len(tcp)), tcp) != 0xffff {
+			t.Errorf("packet #%d: bad TCP checksum", i)
+		}
+
+		src, flags, payload := binary.BigEndian.Uint16(tcp), tcp[13], string(tcp[20:])
+		if src != s.src || flags != s.flags || payload != s.payload {
+			t.Errorf("packet #%d: bad segment: src=%d flags=%#x payload=%q", i, src, flags, payload)
+		}
+	}
+
+	if len(b) != 0 {
+		t.Error("unexpected trailing bytes:", len(b))
+	}
+}
+
+func TestPcapWriterSequence(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pcap := NewPcapWriter(buf)
+
+	addr := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1}
+
+	for _, data := range []string{"abc", "defgh"} {
+		pcap.RecordEvent(TranscriptEvent{ConnID: 1, LocalAddr: addr, RemoteAddr: addr, Op: TranscriptWrite, Data: []byte(data)})
+	}
+
+	// Skip the file header and the handshake, then check that the sequence
+	// number of the second segment follows the first one.
+	b := buf.Bytes()[24:]
+	var seqs []uint32
+
+	for len(b) != 0 {
+		size := int(binary.LittleEndian.Uint32(b[8:]))
+		pkt := b[16 : 16+size]
+		b = b[16+size:]
+
+		if pkt[0]>>4 != 6 {
+			t.Fatal("not an IPv6 packet")
+		}
+		if tcp := pkt[40:]; len(tcp) > 20 {
+			seqs = append(seqs, binary.BigEndian.Uint32(tcp[4:]))
+		}
+	}
+
+	if len(seqs) != 2 || seqs[1] != seqs[0]+3 {
+		t.Error("bad sequence numbers:", seqs)
+	}
+}
@@ -0,0 +1,388 @@
+package pingx
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+const (
+	// DefaultCount is the default number of echo requests sent by a Pinger.
+	DefaultCount = 1
+
+	// DefaultInterval is the default amount of time between two echo requests
+	// sent by a Pinger.
+	DefaultInterval = 1 * time.Second
+
+	// DefaultTimeout is the default amount of time that a Pinger waits for the
+	// reply to an echo request.
+	DefaultTimeout = 1 * time.Second
+
+	// DefaultSize is the default size of the payload of echo requests.
+	DefaultSize = 56
+)
+
+// ErrNoReply is returned by Ping when none of the echo requests were answered.
+var ErrNoReply = errors.New("no reply to ICMP echo requests")
+
+const (
+	protoICMP   = 1
+	protoICMPv6 = 58
+
+	icmpEchoReply     = 0
+	icmpEchoRequest   = 8
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	icmpHeaderLen = 8
+	tokenLen      = 16
+)
+
+// Pinger sends ICMP echo requests to hosts and measures the round trip time of
+// their replies.
+//
+// The pinger uses raw ICMP sockets when the program is allowed to create them
+// (usually when it runs as root or has the CAP_NET_RAW capability), and falls
+// back to the unprivileged datagram ICMP sockets supported by Linux (within
+// the net.ipv4.ping_group_range sysctl) and darwin otherwise.
+type Pinger struct {
+	// Count is the number of echo requests sent to the host. If zero,
+	// DefaultCount is used.
+	Count int
+
+	// Interval is the amount of time between two echo requests. If zero,
+	// DefaultInterval is used.
+	Interval time.Duration
+
+	// Timeout is the amount of time to wait for the reply to an echo request.
+	// If zero, DefaultTimeout is used.
+	Timeout time.Duration
+
+	// Size is the size of the payload of echo requests, it cannot be less
+	// than 16 bytes. If zero, DefaultSize is used.
+	Size int
+
+	// Unprivileged forces the use of datagram ICMP sockets instead of
+	// attempting to create raw sockets first.
+	Unprivileged bool
+
+	// Resolver is used to lookup the addresses of host names. If nil, the
+	// default resolver is used.
+	Resolver *net.Resolver
+}
+
+// Stats carries the results of pinging a host.
+type Stats struct {
+	// Addr is the IP address that the echo requests were sent to.
+	Addr *net.IPAddr
+
+	// Sent is the number of echo requests sent.
+	Sent int
+
+	// Received is the number of echo replies received.
+	Received int
+
+	// RTTs is the list of round trip times measured for each reply.
+	RTTs []time.Duration
+
+	// Statistics of the round trip times, they are zero if no replies were
+	// received.
+	MinRTT    time.Duration
+	MaxRTT    time.Duration
+	AvgRTT    time.Duration
+	StdDevRTT time.Duration
+
+	// running sums of the round trip times and of their squares
+	sum, sum2 float64
+}
+
+// Loss returns the fraction of echo requests that weren't answered, between
+// 0 and 1.
+func (s *Stats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+func (s *Stats) add(rtt time.Duration) {
+	if s.Received == 0 || rtt < s.MinRTT {
+		s.MinRTT = rtt
+	}
+	if rtt > s.MaxRTT {
+		s.MaxRTT = rtt
+	}
+	s.Received++
+	s.RTTs = append(s.RTTs, rtt)
+	s.sum += float64(rtt)
+	s.sum2 += float64(rtt) * float64(rtt)
+
+	n := float64(len(s.RTTs))
+	avg := s.sum / n
+	s.AvgRTT = time.Duration(avg)
+	s.StdDevRTT = time.Duration(math.Sqrt(math.Max(s.sum2/n-avg*avg, 0)))
+}
+
+// Ping sends ICMP echo requests to host using a Pinger with the default
+// configuration.
+func Ping(ctx context.Context, host string) (*Stats, error) {
+	return (&Pinger{}).Ping(ctx, host)
+}
+
+// Ping sends echo requests to host, which may be a host name or an IP address,
+// and returns the statistics of the replies.
+//
+// The method returns ErrNoReply along with the statistics if no replies were
+// received, which makes it simple to use as a health check for hosts that
+// don't expose any TCP port. If ctx is canceled the method returns the
+// statistics collected so far and the context error.
+func (p *Pinger) Ping(ctx context.Context, host string) (*Stats, error) {
+	addr, err := p.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, raw, err := p.listen(addr.IP.To4() == nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	var dst net.Addr = addr
+	if !raw {
+		dst = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+	}
+
+	count := p.count()
+	interval := p.interval()
+	timeout := p.timeout()
+	stats := &Stats{Addr: addr}
+
+	id := 0
+	token := make([]byte, tokenLen)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	if raw {
+		// Datagram sockets have their identifier rewritten by the kernel,
+		// raw sockets need a value to tell replies apart from the ones of
+		// other processes.
+		id = int(binary.BigEndian.Uint16(token))
+	}
+
+	req := make([]byte, icmpHeaderLen+p.size())
+	buf := make([]byte, 1500)
+
+	for seq := 0; seq < count; seq++ {
+		if seq != 0 {
+			if err := sleep(ctx, interval); err != nil {
+				return stats, err
+			}
+		}
+
+		sent := time.Now()
+		marshalEcho(req, addr.IP.To4() == nil, id, seq, token)
+
+		if _, err := conn.WriteTo(req, dst); err != nil {
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			return stats, err
+		}
+		stats.Sent++
+
+		if err := conn.SetReadDeadline(sent.Add(timeout)); err != nil {
+			return stats, err
+		}
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return stats, ctx.Err()
+				}
+				if netx.IsTimeout(err) {
+					break
+				}
+				return stats, err
+			}
+			if matchEchoReply(buf[:n], addr.IP.To4() == nil, raw, id, seq, token) {
+				stats.add(time.Since(sent))
+				break
+			}
+		}
+	}
+
+	if stats.Received == 0 {
+		return stats, ErrNoReply
+	}
+	return stats, nil
+}
+
+func (p *Pinger) count() int {
+	if p.Count > 0 {
+		return p.Count
+	}
+	return DefaultCount
+}
+
+func (p *Pinger) interval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+	return DefaultInterval
+}
+
+func (p *Pinger) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (p *Pinger) size() int {
+	switch {
+	case p.Size == 0:
+		return DefaultSize
+	case p.Size < tokenLen:
+		return tokenLen
+	}
+	return p.Size
+}
+
+func (p *Pinger) resolve(ctx context.Context, host string) (*net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.IPAddr{IP: ip}, nil
+	}
+
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer IPv4 addresses, ICMPv6 is more often filtered on the way.
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return &addr, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return &addrs[0], nil
+}
+
+// listen opens the socket used to send echo requests, raw is true if it is a
+// raw socket, false if it is a datagram socket.
+func (p *Pinger) listen(v6 bool) (conn net.PacketConn, raw bool, err error) {
+	if !p.Unprivileged {
+		if v6 {
+			conn, err = net.ListenPacket("ip6:ipv6-icmp", "::")
+		} else {
+			conn, err = net.ListenPacket("ip4:icmp", "0.0.0.0")
+		}
+		if err == nil {
+			return conn, true, nil
+		}
+		if !errors.Is(err, os.ErrPermission) {
+			return nil, false, err
+		}
+	}
+	conn, err = listenDatagram(v6)
+	return conn, false, err
+}
+
+func listenDatagram(v6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, protoICMP
+	if v6 {
+		family, proto = syscall.AF_INET6, protoICMPv6
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// marshalEcho writes an ICMP echo request to b, the payload starts with token
+// and the rest is zeroed.
+func marshalEcho(b []byte, v6 bool, id int, seq int, token []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+
+	if v6 {
+		b[0] = icmpv6EchoRequest
+	} else {
+		b[0] = icmpEchoRequest
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(id))
+	binary.BigEndian.PutUint16(b[6:], uint16(seq))
+	copy(b[icmpHeaderLen:], token)
+
+	// The kernel computes the checksum of ICMPv6 messages since it depends
+	// on the IPv6 pseudo header.
+	if !v6 {
+		binary.BigEndian.PutUint16(b[2:], netx.Checksum(b))
+	}
+}
+
+// matchEchoReply returns true if b is the reply to the echo request with the
+// given identifier, sequence number and token. The identifier is ignored on
+// datagram sockets since the kernel sets it.
+func matchEchoReply(b []byte, v6 bool, raw bool, id int, seq int, token []byte) bool {
+	if len(b) < icmpHeaderLen+len(token) {
+		return false
+	}
+
+	typ := byte(icmpEchoReply)
+	if v6 {
+		typ = icmpv6EchoReply
+	}
+
+	switch {
+	case b[0] != typ || b[1] != 0:
+		return false
+	case raw && binary.BigEndian.Uint16(b[4:]) != uint16(id):
+		return false
+	case binary.BigEndian.Uint16(b[6:]) != uint16(seq):
+		return false
+	}
+
+	return bytes.Equal(b[icmpHeaderLen:icmpHeaderLen+len(token)], token)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,111 @@
+package pingx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		pinger *Pinger
+	}{
+		{
+			name:   "default",
+			pinger: &Pinger{Count: 3, Interval: 10 * time.Millisecond},
+		},
+		{
+			name:   "unprivileged",
+			pinger: &Pinger{Count: 3, Interval: 10 * time.Millisecond, Unprivileged: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := test.pinger.Ping(context.Background(), "127.0.0.1")
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					t.Skip(err)
+				}
+				t.Fatal(err)
+			}
+
+			if stats.Sent != 3 || stats.Received != 3 || len(stats.RTTs) != 3 {
+				t.Fatalf("bad stats: %+v", stats)
+			}
+
+			if stats.Loss() != 0 {
+				t.Error("bad loss:", stats.Loss())
+			}
+
+			if stats.MinRTT <= 0 || stats.MinRTT > stats.AvgRTT || stats.AvgRTT > stats.MaxRTT {
+				t.Errorf("bad round trip times: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestPingCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stats, err := (&Pinger{Count: 10}).Ping(ctx, "127.0.0.1")
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip(err)
+	}
+	if err != context.Canceled {
+		t.Fatal("bad error:", err)
+	}
+	if stats.Received != 0 {
+		t.Error("bad stats:", stats)
+	}
+}
+
+func TestEchoMessage(t *testing.T) {
+	token := []byte("0123456789abcdef")
+	b := make([]byte, icmpHeaderLen+DefaultSize)
+
+	marshalEcho(b, false, 42, 7, token)
+
+	if sum := netx.Checksum(b); sum != 0 {
+		t.Error("bad checksum:", sum)
+	}
+
+	// Turn the request into its reply the way a remote host would.
+	b[0] = icmpEchoReply
+
+	if !matchEchoReply(b, false, true, 42, 7, token) {
+		t.Error("reply not matched")
+	}
+	if matchEchoReply(b, false, true, 43, 7, token) {
+		t.Error("reply matched with a different identifier")
+	}
+	if !matchEchoReply(b, false, false, 43, 7, token) {
+		t.Error("reply not matched on datagram socket")
+	}
+	if matchEchoReply(b, false, true, 42, 8, token) {
+		t.Error("reply matched with a different sequence number")
+	}
+	if matchEchoReply(b, true, true, 42, 7, token) {
+		t.Error("ICMP reply matched as ICMPv6")
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := &Stats{Sent: 4}
+	stats.add(10 * time.Millisecond)
+	stats.add(30 * time.Millisecond)
+
+	if stats.MinRTT != 10*time.Millisecond || stats.MaxRTT != 30*time.Millisecond || stats.AvgRTT != 20*time.Millisecond || stats.StdDevRTT != 10*time.Millisecond {
+		t.Errorf("bad stats: %+v", stats)
+	}
+
+	if stats.Loss() != 0.5 {
+		t.Error("bad loss:", stats.Loss())
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync"
+	"time"
 )
 
 // ProxyHandler is an interface that must be implemented by types that intend to
@@ -56,15 +58,25 @@ type TransparentProxy struct {
 	//
 	// Calling ServeConn on the proxy will panic if this field is nil.
 	Handler ProxyHandler
+
+	// Logger is used to report the connections whose original target address
+	// couldn't be determined. If nil, the proxy panics to report errors.
+	Logger Logger
 }
 
 // ServeConn satisfies the Handler interface.
 //
-// The method panics to report errors.
+// Errors are reported to the proxy's logger, the method panics to report them
+// if no logger was set.
 func (p *TransparentProxy) ServeConn(ctx context.Context, conn net.Conn) {
 	target, err := OriginalTargetAddr(conn)
 	if err != nil {
-		panic(err)
+		if p.Logger == nil {
+			panic(err)
+		}
+		p.Logger.Warn("original target address error", "remote", conn.RemoteAddr(), "err", err)
+		conn.Close()
+		return
 	}
 	p.Handler.ServeProxy(ctx, conn, target)
 }
@@ -89,6 +101,10 @@ func OriginalTargetAddr(conn net.Conn) (net.Addr, error) {
 // http://www.haproxy.org/download/1.5/doc/proxy-protocol.txt
 type ProxyProtocol struct {
 	Handler Handler
+
+	// Logger is used to report the connections which sent an invalid proxy
+	// protocol header. If nil, the handler panics to report errors.
+	Logger Logger
 }
 
 // ServeConn satisifies the Handler interface.
@@ -96,7 +112,12 @@ func (p *ProxyProtocol) ServeConn(ctx context.Context, conn net.Conn) {
 	src, _, buf, local, err := parseProxyProto(conn)
 
 	if err != nil {
-		panic(err)
+		if p.Logger == nil {
+			panic(err)
+		}
+		p.Logger.Warn("proxy protocol error", "remote", conn.RemoteAddr(), "err", err)
+		conn.Close()
+		return
 	}
 
 	if local {
@@ -123,6 +144,9 @@ func (c *proxyProtoConn) Base() net.Conn {
 }
 
 func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.src == nil {
+		return c.Conn.RemoteAddr()
+	}
 	return c.src
 }
 
@@ -135,10 +159,125 @@ func (c *proxyProtoConn) Read(b []byte) (n int, err error) {
 	return c.Conn.Read(b)
 }
 
+// ProxyProtoListener is a net.Listener which accepts connections carrying a
+// proxy protocol header, as sent by load balancers like HAProxy or AWS ELB.
+// The header is stripped from the connections, and their RemoteAddr method
+// returns the address of the client that the header was sent for.
+//
+// The header is read when Read or RemoteAddr are first called on an accepted
+// connection, so a slow client doesn't block Accept. Connections with an
+// invalid header, or which failed to send it within the read timeout, return
+// an error from Read.
+//
+// Version 1 and 2 are supported.
+type ProxyProtoListener struct {
+	net.Listener
+
+	// Trusted is the list of networks that connections carrying a proxy
+	// protocol header can be accepted from. Connections from other sources
+	// are returned as-is, without reading the header, so clients cannot spoof
+	// their address. If empty, all sources are trusted.
+	Trusted []*net.IPNet
+
+	// ReadHeaderTimeout is the maximum amount of time allowed to the clients
+	// to send their proxy protocol header. If zero, there is no timeout.
+	ReadHeaderTimeout time.Duration
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *ProxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &proxyProtoListenerConn{
+		proxyProtoConn: proxyProtoConn{Conn: conn},
+		timeout:        l.ReadHeaderTimeout,
+	}, nil
+}
+
+func (l *ProxyProtoListener) trusted(addr net.Addr) bool {
+	if len(l.Trusted) == 0 {
+		return true
+	}
+
+	var ip net.IP
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+
+	for _, network := range l.Trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyProtoListenerConn is the connection type returned by ProxyProtoListener,
+// it lazily reads the proxy protocol header.
+type proxyProtoListenerConn struct {
+	proxyProtoConn
+	timeout time.Duration
+	once    sync.Once
+	err     error
+}
+
+func (c *proxyProtoListenerConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyProtoListenerConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	return c.proxyProtoConn.RemoteAddr()
+}
+
+func (c *proxyProtoListenerConn) Read(b []byte) (int, error) {
+	if c.once.Do(c.readHeader); c.err != nil {
+		return 0, c.err
+	}
+	return c.proxyProtoConn.Read(b)
+}
+
+func (c *proxyProtoListenerConn) readHeader() {
+	if c.timeout != 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	src, _, buf, local, err := parseProxyProto(c.Conn)
+
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	if !local {
+		c.src = src
+	}
+
+	c.buf = buf
+}
+
 var (
 	proxy     = [...]byte{'P', 'R', 'O', 'X', 'Y'}
 	tcp4      = [...]byte{'T', 'C', 'P', '4'}
 	tcp6      = [...]byte{'T', 'C', 'P', '6'}
+	unknown   = [...]byte{'U', 'N', 'K', 'N', 'O', 'W', 'N'}
 	crlf      = [...]byte{'\r', '\n'}
 	signature = [...]byte{'\x0D', '\x0A', '\x0D', '\x0A', '\x00', '\x0D', '\x0A', '\x51', '\x55', '\x49', '\x54', '\x0A'}
 )
@@ -175,7 +314,7 @@ func appendProxyProtoV1(b []byte, src net.Addr, dst net.Addr) []byte {
 	return b
 }
 
-func appendProxyProtoV2(b []byte, src net.Addr, dst net.Addr, local bool) []byte {
+func appendProxyProtoV2(b []byte, src net.Addr, dst net.Addr, local bool, tlvs ...ProxyProtoTLV) []byte {
 	const (
 		AF_UNSPEC = 0
 		AF_INET   = 1
@@ -247,7 +386,7 @@ func appendProxyProtoV2(b []byte, src net.Addr, dst net.Addr, local bool) []byte
 	}
 
 	if srcIP != nil {
-		if ip := srcIP.To4(); ip != nil {
+		if ip := srcIP.To4(); ip != nil && dstIP.To4() != nil {
 			family = AF_INET
 			srcAddr = ip
 			dstAddr = dstIP.To4()
@@ -258,13 +397,26 @@ func appendProxyProtoV2(b []byte, src net.Addr, dst net.Addr, local bool) []byte
 		}
 	}
 
+	size := len(srcAddr) + len(dstAddr) + len(srcPort) + len(dstPort)
+
+	for _, tlv := range tlvs {
+		size += 3 + len(tlv.Value)
+	}
+
 	b = append(b, signature[:]...)
 	b = append(b, vercmd)
 	b = append(b, (family<<4)|socktype)
+	b = append(b, byte(size>>8), byte(size))
 	b = append(b, srcAddr...)
 	b = append(b, dstAddr...)
 	b = append(b, srcPort...)
 	b = append(b, dstPort...)
+
+	for _, tlv := range tlvs {
+		b = append(b, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		b = append(b, tlv.Value...)
+	}
+
 	return b
 }
 
@@ -302,6 +454,12 @@ func parseProxyProto(r io.Reader) (src net.Addr, dst net.Addr, buf []byte, local
 		return
 
 	case bytes.HasPrefix(b, signature[:]):
+		if len(b) < 16 {
+			if _, err = io.ReadFull(r, a[len(b):16]); err != nil {
+				return
+			}
+			b = a[:16]
+		}
 		b = b[len(signature):]
 
 		if version := b[0] >> 4; version != 2 {
@@ -349,24 +507,33 @@ func parseProxyProto(r io.Reader) (src net.Addr, dst net.Addr, buf []byte, local
 			err = fmt.Errorf("invalid socket type found in proxy protocol header: %#x", socktype)
 			return
 		}
-		b = b[2:]
+		size := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
 
 		n1 := 2*addrLen + 2*portLen
 		n2 := len(b)
 
-		if n1 > n2 {
-			if _, err = io.ReadFull(r, b[n2:n1]); err != nil {
+		if size < n1 {
+			err = fmt.Errorf("invalid address length found in proxy protocol header: %d", size)
+			return
+		}
+
+		// The header may be followed by TLVs, which are skipped.
+		if n2 < size {
+			c := make([]byte, size)
+			copy(c, b)
+			if _, err = io.ReadFull(r, c[n2:]); err != nil {
 				return
 			}
-			b = b[:n1]
+			b = c
 		}
 
 		if makeAddr != nil {
 			src = makeAddr(socktype, b[:addrLen], b[2*addrLen:2*addrLen+portLen])
-			dst = makeAddr(socktype, b[addrLen:2*addrLen], b[2*addrLen+portLen:])
+			dst = makeAddr(socktype, b[addrLen:2*addrLen], b[2*addrLen+portLen:n1])
 		}
 
-		buf = b[n1:]
+		buf = b[size:]
 		return
 	}
 
@@ -387,6 +554,13 @@ func parseProxyProtoV1(b []byte) (src net.Addr, dst net.Addr, err error) {
 	}
 
 	family, b = parseProxyProtoWord(b)
+
+	if bytes.Equal(family, unknown[:]) {
+		// The sender couldn't determine the addresses, the rest of the line
+		// must be ignored and the connection endpoints used instead.
+		return
+	}
+
 	srcIP, b = parseProxyProtoWord(b)
 	dstIP, b = parseProxyProtoWord(b)
 	srcPort, b = parseProxyProtoWord(b)
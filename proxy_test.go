@@ -3,9 +3,12 @@ package netx
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type readOneByOne struct {
@@ -160,3 +163,137 @@ func TestProxyProtoV2Local(t *testing.T) {
 		t.Errorf("bad local state: %t", local)
 	}
 }
+
+func TestProxyProtoV2TLV(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 56789}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80}
+
+	b := appendProxyProtoV2(nil, src, dst, false)
+	// Extend the header with a NOOP TLV (type 0x04) of 3 bytes.
+	b[15] += 6
+	b = append(b, 0x04, 0x00, 0x03, 'a', 'b', 'c')
+	b = append(b, "Hello World!"...)
+
+	a1, a2, buf, _, err := parseProxyProto(&readOneByOne{b})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a1, src) || !reflect.DeepEqual(a2, dst) {
+		t.Errorf("bad addresses: %v %v", a1, a2)
+	}
+
+	if len(buf) != 0 {
+		t.Errorf("unexpected trailing bytes: %q", buf)
+	}
+}
+
+func TestProxyProtoV1Unknown(t *testing.T) {
+	src, dst, buf, _, err := parseProxyProto(&readOneByOne{[]byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if src != nil || dst != nil || len(buf) != 0 {
+		t.Errorf("bad header: %v %v %q", src, dst, buf)
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name    string
+		trusted []*net.IPNet
+		input   string
+		addr    string
+		output  string
+	}{
+		{
+			name:   "trusted",
+			input:  "PROXY TCP4 192.168.0.1 192.168.0.2 56789 80\r\nHello World!",
+			addr:   "192.168.0.1:56789",
+			output: "Hello World!",
+		},
+		{
+			name:    "trusted-network",
+			trusted: []*net.IPNet{private, loopback},
+			input:   "PROXY TCP4 192.168.0.1 192.168.0.2 56789 80\r\nHello World!",
+			addr:    "192.168.0.1:56789",
+			output:  "Hello World!",
+		},
+		{
+			name:    "untrusted",
+			trusted: []*net.IPNet{private},
+			input:   "PROXY TCP4 192.168.0.1 192.168.0.2 56789 80\r\n",
+			addr:    "127.0.0.1",
+			output:  "PROXY TCP4 192.168.0.1 192.168.0.2 56789 80\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lstn := &ProxyProtoListener{Listener: l, Trusted: test.trusted}
+			defer lstn.Close()
+
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			io.WriteString(client, test.input)
+			client.Close()
+
+			conn, err := lstn.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if addr := conn.RemoteAddr().String(); addr != test.addr && !strings.HasPrefix(addr, test.addr+":") {
+				t.Error("bad remote address:", addr)
+			}
+
+			b, err := ioutil.ReadAll(conn)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if s := string(b); s != test.output {
+				t.Errorf("bad output: %q", s)
+			}
+		})
+	}
+}
+
+func TestProxyProtoListenerTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lstn := &ProxyProtoListener{Listener: l, ReadHeaderTimeout: 50 * time.Millisecond}
+	defer lstn.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := lstn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Read(make([]byte, 1)); !IsTimeout(err) {
+		t.Error("expected a timeout error but got", err)
+	}
+}
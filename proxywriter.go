@@ -0,0 +1,318 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Types of the TLVs that can be carried by version 2 proxy protocol headers.
+const (
+	ProxyProtoTypeALPN      byte = 0x01 // application protocol negotiated by the client
+	ProxyProtoTypeAuthority byte = 0x02 // host name sent by the client (like the TLS server name)
+	ProxyProtoTypeCRC32C    byte = 0x03 // checksum of the header
+	ProxyProtoTypeNoop      byte = 0x04 // ignored, used for padding
+	ProxyProtoTypeUniqueID  byte = 0x05 // opaque identifier of the connection, up to 128 bytes
+	ProxyProtoTypeSSL       byte = 0x20 // information about the TLS session of the client
+	ProxyProtoTypeNetNS     byte = 0x30 // name of the network namespace
+)
+
+// ProxyProtoTLV is a Type-Length-Value extension of a version 2 proxy protocol
+// header.
+type ProxyProtoTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyProtoHeader represents a proxy protocol header, which is sent at the
+// beginning of the connections to a backend to pass it the addresses of the
+// client that the connections are proxied for.
+type ProxyProtoHeader struct {
+	// Version is the version of the proxy protocol, 1 or 2. If zero, version
+	// 2 is used.
+	Version int
+
+	// Local is true for connections established by the proxy itself (like
+	// health checks), the backends then use the actual connection endpoints.
+	Local bool
+
+	// Src and Dst are the addresses of the client and of the proxy. They must
+	// have the same type, or be both nil if the addresses are unknown.
+	// Version 1 headers only support TCP addresses.
+	Src net.Addr
+	Dst net.Addr
+
+	// TLVs is the list of extensions of the header, they cannot be sent in
+	// version 1 headers.
+	TLVs []ProxyProtoTLV
+
+	// Checksum adds a CRC32C TLV to version 2 headers, which lets the backends
+	// verify the integrity of the header.
+	Checksum bool
+}
+
+// NewProxyProtoHeader returns a proxy protocol header carrying the addresses of
+// conn, a connection accepted by a proxy.
+func NewProxyProtoHeader(conn net.Conn) *ProxyProtoHeader {
+	return &ProxyProtoHeader{
+		Src: conn.RemoteAddr(),
+		Dst: conn.LocalAddr(),
+	}
+}
+
+var errProxyProtoV1TLV = errors.New("version 1 proxy protocol headers cannot carry TLVs")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Bytes returns the binary or text representation of the header.
+func (h *ProxyProtoHeader) Bytes() ([]byte, error) {
+	if reflect.TypeOf(h.Src) != reflect.TypeOf(h.Dst) {
+		return nil, fmt.Errorf("mismatching address types in proxy protocol header: %T and %T", h.Src, h.Dst)
+	}
+
+	switch h.Version {
+	case 0, 2:
+	case 1:
+		return h.appendV1(nil)
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol version: %d", h.Version)
+	}
+
+	tlvs := h.TLVs
+
+	if h.Checksum {
+		// The checksum TLV is last so its value is found at the end of the
+		// header once it was encoded.
+		tlvs = append(tlvs[:len(tlvs):len(tlvs)], ProxyProtoTLV{Type: ProxyProtoTypeCRC32C, Value: make([]byte, 4)})
+	}
+
+	b := appendProxyProtoV2(nil, h.Src, h.Dst, h.Local, tlvs...)
+
+	if size := len(b) - 16; size > 0xFFFF {
+		return nil, fmt.Errorf("proxy protocol header too large: %d bytes", size)
+	}
+
+	if h.Checksum {
+		sum := crc32.Checksum(b, castagnoli)
+		b[len(b)-4] = byte(sum >> 24)
+		b[len(b)-3] = byte(sum >> 16)
+		b[len(b)-2] = byte(sum >> 8)
+		b[len(b)-1] = byte(sum)
+	}
+
+	return b, nil
+}
+
+func (h *ProxyProtoHeader) appendV1(b []byte) ([]byte, error) {
+	if len(h.TLVs) != 0 || h.Checksum {
+		return nil, errProxyProtoV1TLV
+	}
+
+	src, _ := h.Src.(*net.TCPAddr)
+	dst, _ := h.Dst.(*net.TCPAddr)
+
+	// The text format cannot represent non-TCP addresses, nor addresses of
+	// different families, the header falls back to reporting them unknown.
+	if h.Local || src == nil || dst == nil || (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+		b = append(b, proxy[:]...)
+		b = append(b, ' ')
+		b = append(b, unknown[:]...)
+		b = append(b, crlf[:]...)
+		return b, nil
+	}
+
+	return appendProxyProtoV1(b, src, dst), nil
+}
+
+// WriteTo writes the header to w.
+func (h *ProxyProtoHeader) WriteTo(w io.Writer) (int64, error) {
+	b, err := h.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// NewProxyProtoConn returns a connection wrapper which sends the proxy protocol
+// header h before the data written to conn.
+//
+// The header is sent with the first write, in the same system call so the
+// backend receives it in the same packet as the first bytes of the stream, or
+// before the first read for the protocols where the server speaks first.
+// Connections closed without reading or writing anything don't send the
+// header.
+func NewProxyProtoConn(conn net.Conn, h *ProxyProtoHeader) (net.Conn, error) {
+	b, err := h.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoWriterConn{Conn: conn, header: b}, nil
+}
+
+type proxyProtoWriterConn struct {
+	net.Conn
+	mutex  sync.Mutex
+	header []byte // not yet sent
+}
+
+func (c *proxyProtoWriterConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyProtoWriterConn) Read(b []byte) (int, error) {
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyProtoWriterConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+
+	if c.header == nil {
+		c.mutex.Unlock()
+		return c.Conn.Write(b)
+	}
+
+	// The mutex is held while the header is sent, so concurrent reads and
+	// writes cannot send data before it.
+	defer c.mutex.Unlock()
+
+	bufs := net.Buffers{c.header, b}
+	n, err := bufs.WriteTo(c.Conn)
+
+	if n -= int64(len(c.header)); n < 0 {
+		n = 0
+	}
+
+	c.header = nil
+	return int(n), err
+}
+
+func (c *proxyProtoWriterConn) ReadFrom(r io.Reader) (int64, error) {
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	return readFrom(c.Conn, c, r)
+}
+
+func (c *proxyProtoWriterConn) CloseWrite() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *proxyProtoWriterConn) flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.header == nil {
+		return nil
+	}
+
+	_, err := c.Conn.Write(c.header)
+	c.header = nil
+	return err
+}
+
+// ProxyProtoDialer is a dialer which sends a proxy protocol header at the
+// beginning of the connections it establishes, the header is taken from the
+// context passed to DialContext (see WithProxyProtoHeader).
+//
+// The dialer is typically used as the DialContext function of a reverse proxy
+// forwarding requests to backends which need to know the addresses of the
+// clients:
+//
+//	ctx = netx.WithProxyProtoHeader(ctx, netx.NewProxyProtoHeader(conn))
+//	backend, err := dialer.DialContext(ctx, "tcp", address)
+type ProxyProtoDialer struct {
+	// Dial is used to establish the connections. If nil, a net.Dialer with a
+	// 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+
+	// Version is the version of the proxy protocol used for headers that
+	// don't specify it. If zero, version 2 is used.
+	Version int
+
+	// TLVs is a list of extensions appended to the TLVs of all headers, for
+	// example to identify the proxy.
+	TLVs []ProxyProtoTLV
+}
+
+// DialContext connects to address on the named network, then sends a proxy
+// protocol header before returning the connection, so the protocols where the
+// server speaks first (like SMTP or MySQL) work through the dialer.
+//
+// If ctx doesn't carry a header, a LOCAL header is sent, meaning that the
+// connection was established by the proxy itself.
+func (d *ProxyProtoDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	h := ProxyProtoHeaderFromContext(ctx)
+	if h == nil {
+		h = &ProxyProtoHeader{Local: true}
+	}
+
+	header := *h
+
+	if header.Version == 0 {
+		header.Version = d.Version
+	}
+
+	if len(d.TLVs) != 0 {
+		header.TLVs = append(header.TLVs[:len(header.TLVs):len(header.TLVs)], d.TLVs...)
+	}
+
+	// Encode the header before establishing the connection, so invalid
+	// headers don't cause connections to be opened for nothing.
+	b, err := header.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	dial := d.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+
+	if _, err := conn.Write(b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// WithProxyProtoHeader returns a context carrying h, which is sent by the
+// connections established by a ProxyProtoDialer with the context.
+func WithProxyProtoHeader(ctx context.Context, h *ProxyProtoHeader) context.Context {
+	return context.WithValue(ctx, proxyProtoHeaderContextKey{}, h)
+}
+
+// ProxyProtoHeaderFromContext returns the proxy protocol header carried by ctx,
+// or nil if it has none.
+func ProxyProtoHeaderFromContext(ctx context.Context) *ProxyProtoHeader {
+	h, _ := ctx.Value(proxyProtoHeaderContextKey{}).(*ProxyProtoHeader)
+	return h
+}
+
+type proxyProtoHeaderContextKey struct{}
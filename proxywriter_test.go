@@ -0,0 +1,229 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProxyProtoHeaderTLV(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 56789}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 443}
+
+	h := &ProxyProtoHeader{
+		Src: src,
+		Dst: dst,
+		TLVs: []ProxyProtoTLV{
+			{Type: ProxyProtoTypeALPN, Value: []byte("h2")},
+			{Type: ProxyProtoTypeAuthority, Value: []byte("example.com")},
+		},
+		Checksum: true,
+	}
+
+	b, err := h.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Addresses (12 bytes) followed by three TLVs.
+	if size := int(binary.BigEndian.Uint16(b[14:])); size != 12+(3+2)+(3+11)+(3+4) || size != len(b)-16 {
+		t.Fatal("bad header size:", size)
+	}
+
+	tlvs := b[16+12:]
+	if string(tlvs[:5]) != "\x01\x00\x02h2" || string(tlvs[5:19]) != "\x02\x00\x0bexample.com" || tlvs[19] != ProxyProtoTypeCRC32C {
+		t.Errorf("bad TLVs: %q", tlvs)
+	}
+
+	sum := binary.BigEndian.Uint32(b[len(b)-4:])
+	copy(b[len(b)-4:], []byte{0, 0, 0, 0})
+
+	if crc32.Checksum(b, castagnoli) != sum {
+		t.Error("bad checksum")
+	}
+
+	a1, a2, buf, local, err := parseProxyProto(&readOneByOne{b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a1, src) || !reflect.DeepEqual(a2, dst) || len(buf) != 0 || local {
+		t.Errorf("bad header: %v %v %q %t", a1, a2, buf, local)
+	}
+}
+
+func TestProxyProtoHeaderV1(t *testing.T) {
+	tests := []struct {
+		name   string
+		header ProxyProtoHeader
+		output string
+	}{
+		{
+			name: "tcp4",
+			header: ProxyProtoHeader{
+				Src: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56789},
+				Dst: &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 80},
+			},
+			output: "PROXY TCP4 192.168.0.1 192.168.0.2 56789 80\r\n",
+		},
+		{
+			name: "mixed-families",
+			header: ProxyProtoHeader{
+				Src: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56789},
+				Dst: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 80},
+			},
+			output: "PROXY UNKNOWN\r\n",
+		},
+		{
+			name:   "local",
+			header: ProxyProtoHeader{Local: true},
+			output: "PROXY UNKNOWN\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.header.Version = 1
+
+			b, err := test.header.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(b) != test.output {
+				t.Errorf("bad header: %q", b)
+			}
+		})
+	}
+
+	h := &ProxyProtoHeader{Version: 1, TLVs: []ProxyProtoTLV{{Type: ProxyProtoTypeNoop}}}
+	if _, err := h.Bytes(); err == nil {
+		t.Error("expected an error for a version 1 header with TLVs")
+	}
+}
+
+func TestProxyProtoDialer(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lstn = &ProxyProtoListener{Listener: lstn}
+	defer lstn.Close()
+
+	type result struct {
+		addr string
+		data string
+	}
+	results := make(chan result, 2)
+
+	go func() {
+		for {
+			conn, err := lstn.Accept()
+			if err != nil {
+				return
+			}
+			b, _ := ioutil.ReadAll(conn)
+			results <- result{conn.RemoteAddr().String(), string(b)}
+			conn.Close()
+		}
+	}()
+
+	d := &ProxyProtoDialer{TLVs: []ProxyProtoTLV{{Type: ProxyProtoTypeUniqueID, Value: []byte("1234")}}}
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56789}
+	ctx := WithProxyProtoHeader(context.Background(), &ProxyProtoHeader{
+		Src: client,
+		Dst: &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 80},
+	})
+
+	for _, test := range []struct {
+		ctx  context.Context
+		addr string
+	}{
+		{ctx, client.String()},
+		{context.Background(), ""}, // LOCAL, the actual address is used
+	} {
+		conn, err := d.DialContext(test.ctx, "tcp", lstn.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("Hello World!"))
+		conn.(interface{ CloseWrite() error }).CloseWrite()
+
+		r := <-results
+		conn.Close()
+
+		if test.addr == "" {
+			test.addr = conn.LocalAddr().String()
+		}
+
+		if r.addr != test.addr || r.data != "Hello World!" {
+			t.Errorf("bad connection: %+v", r)
+		}
+	}
+}
+
+func TestProxyProtoServerFirst(t *testing.T) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lstn = &ProxyProtoListener{Listener: lstn}
+	defer lstn.Close()
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56789}
+	header := &ProxyProtoHeader{
+		Src: client,
+		Dst: &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 25},
+	}
+
+	go func() {
+		for {
+			conn, err := lstn.Accept()
+			if err != nil {
+				return
+			}
+			// The server speaks first, with the address of the client.
+			conn.Write([]byte(conn.RemoteAddr().String()))
+			conn.Close()
+		}
+	}()
+
+	dial := map[string]func() (net.Conn, error){
+		"dialer": func() (net.Conn, error) {
+			d := &ProxyProtoDialer{}
+			return d.DialContext(WithProxyProtoHeader(context.Background(), header), "tcp", lstn.Addr().String())
+		},
+		"conn": func() (net.Conn, error) {
+			conn, err := net.Dial("tcp", lstn.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return NewProxyProtoConn(conn, header)
+		},
+	}
+
+	for name, dial := range dial {
+		t.Run(name, func(t *testing.T) {
+			conn, err := dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			b, err := ioutil.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != client.String() {
+				t.Errorf("bad banner: %q", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachingResolver is a DNS resolver which caches the answers it receives from
+// its base resolver, which prevents programs opening many connections to the
+// same hosts (like proxies) from sending a query for every connection.
+//
+// Lookups of the same name that happen concurrently are merged into a single
+// query. Not-found answers are cached as well (negative caching), other errors
+// are not cached.
+//
+// The net package does not expose the TTL of DNS records, so the cache uses
+// fixed durations configured on the resolver.
+type CachingResolver struct {
+	// Resolver is the resolver used to send queries. If nil, the default
+	// resolver of the net package is used.
+	Resolver *net.Resolver
+
+	// TTL is the amount of time that successful answers are cached for. If
+	// zero, DefaultCacheTTL is used.
+	TTL time.Duration
+
+	// NegativeTTL is the amount of time that not-found answers are cached for.
+	// If zero, DefaultNegativeCacheTTL is used.
+	NegativeTTL time.Duration
+
+	// LookupTimeout is the maximum amount of time allowed for a query. If
+	// zero, it defaults to 10 seconds.
+	LookupTimeout time.Duration
+
+	// Dial is used by DialContext to establish connections to the resolved
+	// addresses. If nil, a net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+
+	mutex sync.Mutex
+	cache map[string]*resolverEntry
+	sweep int // size of the cache after the last sweep
+}
+
+const (
+	// DefaultCacheTTL is the default TTL of the answers cached by a
+	// CachingResolver.
+	DefaultCacheTTL = 1 * time.Minute
+
+	// DefaultNegativeCacheTTL is the default TTL of the not-found answers
+	// cached by a CachingResolver.
+	DefaultNegativeCacheTTL = 5 * time.Second
+)
+
+type resolverEntry struct {
+	ready   chan struct{} // closed when the query completed
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// LookupIP looks up host for the given network, which must be "ip", "ip4" or
+// "ip6", it has the same signature as net.Resolver.LookupIP.
+func (r *CachingResolver) LookupIP(ctx context.Context, network string, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	key := network + "/" + strings.ToLower(host)
+	now := time.Now()
+
+	r.mutex.Lock()
+	e := r.cache[key]
+
+	if e == nil || (e.done() && !now.Before(e.expires)) {
+		e = &resolverEntry{ready: make(chan struct{})}
+		r.insert(key, e, now)
+		go r.lookup(key, e, network, host)
+	}
+
+	r.mutex.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return append([]net.IP{}, e.ips...), nil
+}
+
+// LookupHost looks up the IP addresses of host, it has the same signature as
+// net.Resolver.LookupHost.
+func (r *CachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+
+	return addrs, nil
+}
+
+// DialContext connects to address on the named network, resolving the host
+// names with r.
+//
+// TCP connections are established with a HappyEyeballsDialer, for the other
+// networks the addresses are tried in order until one succeeds.
+func (r *CachingResolver) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := r.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	var family string
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return (&HappyEyeballsDialer{Dial: dial, LookupIP: r.LookupIP}).DialContext(ctx, network, address)
+	case "udp":
+		family = "ip"
+	case "udp4":
+		family = "ip4"
+	case "udp6":
+		family = "ip6"
+	default:
+		return dial(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return dial(ctx, network, address)
+	}
+
+	ips, err := r.LookupIP(ctx, family, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		var conn net.Conn
+
+		if conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Flush removes all entries from the cache.
+func (r *CachingResolver) Flush() {
+	r.mutex.Lock()
+	r.cache, r.sweep = nil, 0
+	r.mutex.Unlock()
+}
+
+func (r *CachingResolver) lookup(key string, e *resolverEntry, network string, host string) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	timeout := r.LookupTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	// The query is not bound to the context of the caller that triggered it
+	// since other callers may be waiting for the answer.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ips, err := resolver.LookupIP(ctx, network, host)
+	cancel()
+
+	var ttl time.Duration
+
+	switch {
+	case err == nil:
+		if ttl = r.TTL; ttl == 0 {
+			ttl = DefaultCacheTTL
+		}
+	case isNotFound(err):
+		if ttl = r.NegativeTTL; ttl == 0 {
+			ttl = DefaultNegativeCacheTTL
+		}
+	}
+
+	r.mutex.Lock()
+	e.ips, e.err, e.expires = ips, err, time.Now().Add(ttl)
+	close(e.ready)
+
+	if ttl <= 0 && r.cache[key] == e {
+		delete(r.cache, key)
+	}
+
+	r.mutex.Unlock()
+}
+
+// insert adds e to the cache, expired entries are removed every time the size
+// of the cache doubles. The method must be called with the mutex locked.
+func (r *CachingResolver) insert(key string, e *resolverEntry, now time.Time) {
+	if r.cache == nil {
+		r.cache = make(map[string]*resolverEntry)
+	}
+
+	if len(r.cache) >= 2*r.sweep && len(r.cache) >= 64 {
+		for k, x := range r.cache {
+			if x.done() && !now.Before(x.expires) {
+				delete(r.cache, k)
+			}
+		}
+		r.sweep = len(r.cache)
+	}
+
+	r.cache[key] = e
+}
+
+func (e *resolverEntry) done() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*net.DNSError)
+	return ok && e.IsNotFound
+}
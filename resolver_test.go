@@ -0,0 +1,187 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDNSServer is a minimal DNS server answering A queries for the names in
+// its zone, and NXDOMAIN for other names.
+type testDNSServer struct {
+	conn    net.PacketConn
+	zone    map[string]net.IP
+	queries int32
+}
+
+func newTestDNSServer(t *testing.T, zone map[string]net.IP) *testDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testDNSServer{conn: conn, zone: zone}
+	go s.serve()
+	return s
+}
+
+func (s *testDNSServer) Close() error {
+	return s.conn.Close()
+}
+
+func (s *testDNSServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return net.Dial("udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *testDNSServer) serve() {
+	b := make([]byte, 512)
+
+	for {
+		n, addr, err := s.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if res := s.answer(b[:n]); res != nil {
+			s.conn.WriteTo(res, addr)
+		}
+	}
+}
+
+func (s *testDNSServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// Parse the name of the question.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	question := query[12 : i+5]
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	if name == "cached.test" {
+		atomic.AddInt32(&s.queries, 1)
+	}
+
+	res := make([]byte, 12, 512)
+	copy(res, query[:2])
+	binary.BigEndian.PutUint16(res[4:], 1) // QDCOUNT
+	res = append(res, question...)
+
+	ip, ok := s.zone[name]
+	switch {
+	case !ok:
+		binary.BigEndian.PutUint16(res[2:], 0x8183) // NXDOMAIN
+	case qtype == 1: // A
+		binary.BigEndian.PutUint16(res[2:], 0x8180)
+		binary.BigEndian.PutUint16(res[6:], 1) // ANCOUNT
+		res = append(res, 0xC0, 0x0C, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+		res = append(res, ip.To4()...)
+	default:
+		binary.BigEndian.PutUint16(res[2:], 0x8180)
+	}
+
+	return res
+}
+
+func TestCachingResolver(t *testing.T) {
+	server := newTestDNSServer(t, map[string]net.IP{
+		"cached.test": net.ParseIP("10.0.0.1"),
+	})
+	defer server.Close()
+
+	r := &CachingResolver{
+		Resolver:    server.resolver(),
+		TTL:         100 * time.Millisecond,
+		NegativeTTL: 100 * time.Millisecond,
+	}
+
+	ctx := context.Background()
+
+	for i := 0; i != 3; i++ {
+		ips, err := r.LookupIP(ctx, "ip4", "cached.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+			t.Fatal("bad addresses:", ips)
+		}
+	}
+
+	if n := atomic.LoadInt32(&server.queries); n != 1 {
+		t.Error("bad number of queries:", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := r.LookupIP(ctx, "ip4", "cached.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&server.queries); n != 2 {
+		t.Error("the expired answer was not refreshed:", n)
+	}
+}
+
+func TestCachingResolverNotFound(t *testing.T) {
+	server := newTestDNSServer(t, nil)
+	defer server.Close()
+
+	r := &CachingResolver{Resolver: server.resolver()}
+
+	for i := 0; i != 2; i++ {
+		_, err := r.LookupIP(context.Background(), "ip4", "cached.test")
+		if !isNotFound(err) {
+			t.Fatal("bad error:", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&server.queries); n != 1 {
+		t.Error("the not-found answer was not cached:", n)
+	}
+}
+
+func TestCachingResolverDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	server := newTestDNSServer(t, map[string]net.IP{
+		"cached.test": net.ParseIP("127.0.0.1"),
+	})
+	defer server.Close()
+
+	r := &CachingResolver{Resolver: server.resolver()}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("cached.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if addr := conn.RemoteAddr().String(); addr != l.Addr().String() {
+		t.Error("bad remote address:", addr)
+	}
+}
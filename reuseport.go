@@ -0,0 +1,76 @@
+package netx
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ControlReusePort can be used as the Control function of a net.ListenConfig
+// to set SO_REUSEPORT on TCP and UDP sockets, which lets multiple sockets bind
+// the same address, the kernel distributing the incoming connections and
+// datagrams between them.
+func ControlReusePort(network string, address string, c syscall.RawConn) error {
+	if !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+		return errors.New("SO_REUSEPORT is not supported on " + network + " sockets")
+	}
+
+	var err error
+
+	if cerr := c.Control(func(fd uintptr) { err = setReusePort(fd) }); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+// ListenReusePort is like Listen but opens n listeners on address with
+// SO_REUSEPORT set, so each of them can be served by its own accept loop. If
+// the address has no port, or port zero, the listeners share the port picked
+// by the operating system for the first one.
+//
+// The function returns an error if the platform doesn't support SO_REUSEPORT
+// or if the address doesn't use TCP.
+func ListenReusePort(address string, n int) ([]net.Listener, error) {
+	config := &net.ListenConfig{Control: ControlReusePort}
+	lstns := make([]net.Listener, 0, n)
+
+	for i := 0; i < n; i++ {
+		lstn, err := listenConfig(address, config)
+		if err != nil {
+			for _, l := range lstns {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		if i == 0 {
+			address = reusePortAddress(address, lstn.Addr())
+		}
+
+		lstns = append(lstns, lstn)
+	}
+
+	return lstns, nil
+}
+
+// reusePortAddress returns the address that the listeners following the first
+// one must use, which is the address of the first listener if the port was
+// picked by the operating system.
+func reusePortAddress(address string, first net.Addr) string {
+	network, addr := SplitNetAddr(address)
+
+	if _, port := SplitAddrPort(addr); port > 0 {
+		return address
+	}
+
+	if a, ok := first.(*net.TCPAddr); ok {
+		if len(network) == 0 {
+			network = "tcp"
+		}
+		return network + "://" + a.String()
+	}
+
+	return address
+}
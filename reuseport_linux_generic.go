@@ -0,0 +1,7 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+
+package netx
+
+const (
+	soReusePort = 15 // SO_REUSEPORT, missing from the syscall package on some architectures
+)
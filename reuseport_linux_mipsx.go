@@ -0,0 +1,7 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package netx
+
+const (
+	soReusePort = 0x200 // SO_REUSEPORT, missing from the syscall package on some architectures
+)
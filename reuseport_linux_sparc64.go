@@ -0,0 +1,5 @@
+package netx
+
+const (
+	soReusePort = 0x200 // SO_REUSEPORT, missing from the syscall package on some architectures
+)
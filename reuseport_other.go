@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package netx
+
+import (
+	"errors"
+	"runtime"
+)
+
+func setReusePort(fd uintptr) error {
+	return errors.New("SO_REUSEPORT is not supported on " + runtime.GOOS)
+}
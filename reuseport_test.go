@@ -0,0 +1,99 @@
+package netx
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	lstns, err := ListenReusePort("127.0.0.1:0", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := lstns[0].Addr().String()
+
+	for _, lstn := range lstns[1:] {
+		if a := lstn.Addr().String(); a != addr {
+			t.Errorf("listeners don't share the same address: %s != %s", a, addr)
+		}
+	}
+
+	server := &Server{Handler: Echo}
+	done := make(chan error)
+	go func() { done <- server.serve(lstns, 1) }()
+
+	for i := 0; i != 20; i++ {
+		testEcho(t, addr)
+	}
+
+	server.Close()
+
+	if err := <-done; err != ErrServerClosed {
+		t.Error("bad error returned by serve:", err)
+	}
+}
+
+func TestServerAcceptLoops(t *testing.T) {
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Handler: Echo, AcceptLoops: 4}
+	done := make(chan error)
+	go func() { done <- server.Serve(lstn) }()
+
+	for i := 0; i != 20; i++ {
+		testEcho(t, lstn.Addr().String())
+	}
+
+	server.Close()
+
+	if err := <-done; err != ErrServerClosed {
+		t.Error("bad error returned by Serve:", err)
+	}
+}
+
+func TestReusePortAddress(t *testing.T) {
+	first := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}
+
+	tests := []struct {
+		address string
+		result  string
+	}{
+		{address: "127.0.0.1:0", result: "tcp://127.0.0.1:4242"},
+		{address: "tcp4://127.0.0.1:0", result: "tcp4://127.0.0.1:4242"},
+		{address: "127.0.0.1:8080", result: "127.0.0.1:8080"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			if s := reusePortAddress(test.address, first); s != test.result {
+				t.Error(s)
+			}
+		})
+	}
+}
+
+func testEcho(t *testing.T, addr string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "Hello World!"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := string(b); s != "Hello World!" {
+		t.Error("bad echo:", s)
+	}
+}
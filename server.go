@@ -4,9 +4,12 @@ import (
 	"context"
 	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,18 +33,73 @@ func Serve(lstn net.Listener, handler Handler) error {
 
 // A Server defines parameters for running servers that accept connections over
 // TCP or unix domains.
+//
+// The server keeps track of the connections it serves, which lets programs
+// gracefully stop it with the Shutdown method.
 type Server struct {
 	Addr     string          // address to listen on
 	Handler  Handler         // handler to invoke on new connections
 	ErrorLog *log.Logger     // the logger used to output internal errors
 	Context  context.Context // the base context used by the server
+
+	// Logger is the structured logger used to report accept errors and panics
+	// of the handler. If nil, the messages are written to ErrorLog.
+	Logger Logger
+
+	// Events, if not nil, receives the EventAccept and EventClose events of
+	// the connections served by the server.
+	Events *EventBus
+
+	// IdleTimeout is the maximum amount of time that connections may go
+	// without reading or writing any bytes before the server closes them,
+	// which prevents abandoned clients from holding file descriptors forever.
+	// Zero means no timeout.
+	//
+	// Setting a timeout disables the zero-copy optimizations of ReadFrom and
+	// WriteTo on the connections, since the bytes they transfer wouldn't be
+	// seen by the server.
+	IdleTimeout time.Duration
+
+	// MaxConnAge is the maximum amount of time that the server keeps
+	// connections open, older connections are closed as soon as they become
+	// idle (see Shutdown for the definition of an idle connection). This
+	// helps spread long lived connections across servers behind a layer 4
+	// load balancer, the clients reconnecting to other instances. The ages
+	// are randomly extended by up to 10% so that connections accepted at the
+	// same time aren't closed all at once. Zero means no limit.
+	MaxConnAge time.Duration
+
+	// MaxConnAgeGrace is the amount of time that connections older than
+	// MaxConnAge have to become idle, they are closed when it expires. Zero
+	// means to wait until the connections become idle, which may never
+	// happen for tunnels.
+	MaxConnAgeGrace time.Duration
+
+	// AcceptLoops is the number of goroutines accepting connections on each
+	// listener, which removes the bottleneck of a single accept loop on
+	// machines with many cores. Zero means a single goroutine.
+	AcceptLoops int
+
+	mutex  sync.Mutex
+	lstns  map[net.Listener]struct{}
+	conns  map[*serverConn]struct{}
+	closed bool
 }
 
 // ListenAndServe listens on the server address and then call Serve to handle
 // the incoming connections.
+//
+// When AcceptLoops is greater than one and the platform supports it, the
+// server opens one socket with SO_REUSEPORT for each accept loop.
 func (s *Server) ListenAndServe() (err error) {
 	var lstn net.Listener
 
+	if s.AcceptLoops > 1 {
+		if lstns, err := ListenReusePort(s.Addr, s.AcceptLoops); err == nil {
+			return s.serve(lstns, 1)
+		}
+	}
+
 	if lstn, err = Listen(s.Addr); err == nil {
 		err = s.Serve(lstn)
 	}
@@ -55,8 +113,32 @@ func (s *Server) ListenAndServe() (err error) {
 //
 // The server becomes the owner of the listener which will be closed by the time
 // the Serve method returns.
+//
+// After Shutdown or Close were called, Serve returns ErrServerClosed.
 func (s *Server) Serve(lstn net.Listener) error {
-	defer lstn.Close()
+	return s.serve([]net.Listener{lstn}, s.AcceptLoops)
+}
+
+// serve implements Serve, running the given number of accept loops on each of
+// the listeners.
+func (s *Server) serve(lstns []net.Listener, loops int) error {
+	closeListeners := func() {
+		for _, lstn := range lstns {
+			lstn.Close()
+		}
+	}
+	defer closeListeners()
+
+	for _, lstn := range lstns {
+		if !s.trackListener(lstn) {
+			return ErrServerClosed
+		}
+		defer s.untrackListener(lstn)
+	}
+
+	if loops < 1 {
+		loops = 1
+	}
 
 	join := &sync.WaitGroup{}
 	defer join.Wait()
@@ -70,24 +152,48 @@ func (s *Server) Serve(lstn net.Listener) error {
 	defer cancel()
 
 	done := ctx.Done()
-	errs := make(chan error)
+	errs := make(chan error, len(lstns)*loops)
 	conns := make(chan net.Conn)
+	accepts := &sync.WaitGroup{}
+
+	for _, lstn := range lstns {
+		for i := 0; i != loops; i++ {
+			accepts.Add(1)
+			go s.accept(ctx, lstn, conns, errs, accepts)
+		}
+	}
 
 	join.Add(1)
-	go s.accept(ctx, lstn, conns, errs, join)
+	go func() {
+		defer join.Done()
+		accepts.Wait()
+		close(errs)
+		close(conns)
+	}()
+
+	if s.IdleTimeout > 0 || s.MaxConnAge > 0 {
+		go s.reap(ctx)
+	}
+
+	var err error
 
+	// The loop runs until all accept loops have exited, the first error stops
+	// the other loops by closing the listeners.
 	for conns != nil || errs != nil {
 		select {
 		case <-done:
-			lstn.Close()
+			closeListeners()
 			done = nil
 
-		case err, ok := <-errs:
+		case e, ok := <-errs:
 			if !ok {
 				errs = nil
 				continue
 			}
-			return err
+			if err == nil {
+				err = e
+				closeListeners()
+			}
 
 		case conn, ok := <-conns:
 			if !ok {
@@ -95,84 +201,371 @@ func (s *Server) Serve(lstn net.Listener) error {
 				continue
 			}
 			join.Add(1)
-			go s.serve(ctx, conn, join)
+			go s.serveConn(ctx, conn, join)
 		}
 	}
 
-	return nil
+	if s.isClosed() {
+		// Let the connections drain, Shutdown or Close take care of closing
+		// them.
+		join.Wait()
+		return ErrServerClosed
+	}
+	return err
 }
 
-func (s *Server) accept(ctx context.Context, lstn net.Listener, conns chan<- net.Conn, errs chan<- error, join *sync.WaitGroup) {
-	defer join.Done()
-	defer close(errs)
-	defer close(conns)
+// Shutdown gracefully stops the server, it closes all its listeners, then the
+// idle connections, and waits for the active connections to become idle
+// before closing them as well.
+//
+// A connection is considered idle when it is blocked reading without having
+// received any bytes since the last time data was written to it (or since it
+// was accepted), for example when a request/response protocol waits for the
+// next request.
+//
+// If ctx expires before all connections were closed, Shutdown calls Close to
+// forcefully close the remaining connections and returns the context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	s.closeListeners()
+	s.mutex.Unlock()
+
+	const maxPollInterval = 500 * time.Millisecond
+	pollInterval := 1 * time.Millisecond
+
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
 
-	const maxBackoff = 1 * time.Second
 	for {
-		var conn net.Conn
-		var err error
+		if s.closeIdleConns() {
+			return nil
+		}
 
-		for attempt := 0; true; attempt++ {
-			if conn, err = lstn.Accept(); err == nil {
-				break
-			}
-			if !IsTemporary(err) {
-				break
-			}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-timer.C:
+		}
 
-			// Backoff strategy for handling temporary errors, this prevents from
-			// retrying too fast when errors like running out of file descriptors
-			// occur.
-			backoff := time.Duration(attempt*attempt) * 10 * time.Millisecond
-			if backoff > maxBackoff {
-				backoff = maxBackoff
-			}
-			s.logf("Accept error: %v; retrying in %v", err, backoff)
-			select {
-			case <-time.After(backoff):
-			case <-ctx.Done():
-				return
-			}
+		if pollInterval *= 2; pollInterval > maxPollInterval {
+			pollInterval = maxPollInterval
 		}
+		timer.Reset(pollInterval)
+	}
+}
 
-		if err != nil {
-			switch e := err.(type) {
-			case *net.OpError:
-				// Don't report EOF, this is a normal termination of the listener.
-				if e.Err == io.EOF {
-					err = nil
-				}
-			}
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					// Don't report errors when the server stopped because its
-					// context was canceled.
-				default:
-					errs <- err
-				}
-			}
+// Close immediately closes all the listeners and connections of the server,
+// and cancels the contexts passed to the handlers of those connections.
+//
+// Close does not wait for the handlers to return.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.closeListeners()
+
+	for c := range s.conns {
+		c.close()
+	}
+
+	return nil
+}
+
+// ActiveConns returns the number of connections that are currently being
+// served and are not idle.
+func (s *Server) ActiveConns() int {
+	active, _ := s.countConns()
+	return active
+}
+
+// IdleConns returns the number of connections that are currently idle. See
+// Shutdown for the definition of an idle connection.
+func (s *Server) IdleConns() int {
+	_, idle := s.countConns()
+	return idle
+}
+
+func (s *Server) countConns() (active int, idle int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.conns {
+		if c.isIdle() {
+			idle++
+		} else {
+			active++
+		}
+	}
+
+	return
+}
+
+// closeIdleConns closes the idle connections of the server and returns true
+// if no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.conns {
+		if c.isIdle() {
+			c.close()
+			delete(s.conns, c)
+		}
+	}
+
+	return len(s.conns) == 0
+}
+
+// reap periodically closes the connections that have been idle for longer
+// than the server's idle timeout or are older than its maximum age, until ctx
+// is canceled.
+func (s *Server) reap(ctx context.Context) {
+	interval := time.Duration(math.MaxInt64)
+
+	for _, d := range []time.Duration{s.IdleTimeout, s.MaxConnAge, s.MaxConnAgeGrace} {
+		if d > 0 && d/4 < interval {
+			interval = d / 4
+		}
+	}
+
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.closeExpiredConns(now)
+		case <-ctx.Done():
 			return
 		}
+	}
+}
 
-		conns <- conn
+func (s *Server) closeExpiredConns(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.conns {
+		switch {
+		case s.IdleTimeout > 0 && c.idleSince(now) >= s.IdleTimeout:
+			c.close()
+		case c.expires.IsZero() || now.Before(c.expires):
+		case c.isIdle():
+			c.close()
+		case s.MaxConnAgeGrace > 0 && !now.Before(c.expires.Add(s.MaxConnAgeGrace)):
+			c.close()
+		}
+	}
+}
+
+func (s *Server) closeListeners() {
+	for lstn := range s.lstns {
+		lstn.Close()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Server) trackListener(lstn net.Listener) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
 	}
+
+	if s.lstns == nil {
+		s.lstns = make(map[net.Listener]struct{})
+	}
+
+	s.lstns[lstn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(lstn net.Listener) {
+	s.mutex.Lock()
+	delete(s.lstns, lstn)
+	s.mutex.Unlock()
 }
 
-func (s *Server) serve(ctx context.Context, conn net.Conn, join *sync.WaitGroup) {
-	defer func() { Recover(recover(), conn, s.ErrorLog) }()
+func (s *Server) trackConn(c *serverConn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
+	if s.closed {
+		return false
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(c *serverConn) {
+	s.mutex.Lock()
+	delete(s.conns, c)
+	s.mutex.Unlock()
+}
+
+func (s *Server) accept(ctx context.Context, lstn net.Listener, conns chan<- net.Conn, errs chan<- error, join *sync.WaitGroup) {
 	defer join.Done()
+
+	err := acceptLoop(ctx, lstn, func(conn net.Conn) { conns <- conn }, func(err error, backoff time.Duration) {
+		if s.Logger != nil {
+			s.Logger.Warn("accept error", "addr", lstn.Addr(), "err", err, "retry", backoff)
+		} else {
+			logf(s.ErrorLog)("Accept error: %v; retrying in %v", err, backoff)
+		}
+	})
+
+	if e, ok := err.(*net.OpError); ok && e.Err == io.EOF {
+		// Don't report EOF, this is a normal termination of the listener.
+		err = nil
+	}
+
+	if err != nil {
+		errs <- err
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn, join *sync.WaitGroup) {
+	// Deferred first so it runs last, Serve doesn't return before panics of
+	// the handler were logged.
+	defer join.Done()
+
+	defer func() {
+		if err := recover(); err != nil {
+			logPanic(s.Logger, s.ErrorLog, conn.LocalAddr(), conn.RemoteAddr(), err)
+		}
+	}()
+
+	s.Events.PublishConn(EventAccept, conn, nil)
+	defer s.Events.PublishConn(EventClose, conn, nil)
 	defer conn.Close()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	s.Handler.ServeConn(ctx, conn)
+	c := &serverConn{
+		Conn:   conn,
+		cancel: cancel,
+		state:  connStateIdle,
+		last:   time.Now().UnixNano(),
+		track:  s.IdleTimeout > 0,
+	}
+
+	if maxAge := s.MaxConnAge; maxAge > 0 {
+		c.expires = time.Now().Add(maxAge + time.Duration(rand.Int63n(int64(maxAge/10)+1)))
+	}
+
+	if !s.trackConn(c) {
+		return
+	}
+	defer s.untrackConn(c)
+
+	s.Handler.ServeConn(ctx, c)
+}
+
+const (
+	connStateActive int32 = iota
+	connStateIdle
+)
+
+// serverConn is the net.Conn implementation passed to the handlers of a
+// Server, it tracks whether the connection is idle.
+//
+// The state starts as idle, becomes active when a read returns bytes, and
+// switches back to idle after data was written to the connection. An idle
+// connection is only reported as such while a read is in progress, meaning
+// that the handler is waiting for more input.
+type serverConn struct {
+	net.Conn
+	last   int64 // time of the last read or write, in unix nanoseconds
+	cancel context.CancelFunc
+	state  int32
+	reads  int32
+	track  bool // copies must go through Read and Write to update last
+
+	expires time.Time // set when the server has a maximum connection age
+}
+
+func (c *serverConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *serverConn) Read(b []byte) (n int, err error) {
+	atomic.AddInt32(&c.reads, 1)
+	n, err = c.Conn.Read(b)
+	atomic.AddInt32(&c.reads, -1)
+
+	if n > 0 {
+		atomic.StoreInt32(&c.state, connStateActive)
+		atomic.StoreInt64(&c.last, time.Now().UnixNano())
+	}
+
+	return
 }
 
-func (s *Server) logf(format string, args ...interface{}) {
-	logf(s.ErrorLog)(format, args...)
+func (c *serverConn) Write(b []byte) (n int, err error) {
+	if n, err = c.Conn.Write(b); n > 0 {
+		atomic.StoreInt32(&c.state, connStateIdle)
+		atomic.StoreInt64(&c.last, time.Now().UnixNano())
+	}
+	return
+}
+
+func (c *serverConn) ReadFrom(r io.Reader) (n int64, err error) {
+	base := c.Conn
+	if c.track {
+		base = nil
+	}
+
+	if n, err = readFrom(base, c, r); n > 0 {
+		atomic.StoreInt32(&c.state, connStateIdle)
+	}
+	return
+}
+
+func (c *serverConn) WriteTo(w io.Writer) (n int64, err error) {
+	// The handler consumes the whole stream, the connection is never idle
+	// while the copy is in progress.
+	atomic.StoreInt32(&c.state, connStateActive)
+
+	base := c.Conn
+	if c.track {
+		base = nil
+	}
+
+	return writeTo(base, c, w)
+}
+
+// idleSince returns the amount of time since the last read or write on the
+// connection.
+func (c *serverConn) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.last)))
+}
+
+func (c *serverConn) isIdle() bool {
+	return atomic.LoadInt32(&c.state) == connStateIdle && atomic.LoadInt32(&c.reads) != 0
+}
+
+func (c *serverConn) close() {
+	c.cancel()
+	c.Conn.Close()
 }
 
 // Recover is intended to be used by servers that gracefully handle panics from
@@ -182,13 +575,20 @@ func Recover(err interface{}, conn net.Conn, logger *log.Logger) {
 		return
 	}
 
-	logf := logf(logger)
-	laddr := conn.LocalAddr()
-	raddr := conn.RemoteAddr()
+	logPanic(nil, logger, conn.LocalAddr(), conn.RemoteAddr(), err)
+}
 
+// logPanic reports a panic of a handler with the stack of the goroutine, to
+// logger if it is not nil, or to std.
+func logPanic(logger Logger, std *log.Logger, laddr net.Addr, raddr net.Addr, err interface{}) {
 	buf := make([]byte, 262144)
 	buf = buf[:runtime.Stack(buf, false)]
-	logf("panic serving %s->%s: %v\n%s", laddr, raddr, err, string(buf))
+
+	if logger != nil {
+		logger.Error("panic serving", "local", laddr, "remote", raddr, "panic", err, "stack", string(buf))
+	} else {
+		logf(std)("panic serving %s->%s: %v\n%s", laddr, raddr, err, string(buf))
+	}
 }
 
 func logf(logger *log.Logger) func(string, ...interface{}) {
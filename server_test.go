@@ -1,7 +1,9 @@
 package netx
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -135,3 +137,248 @@ func listenAndServe(h Handler) (addr net.Addr, close func()) {
 	}
 	return
 }
+
+func TestServerShutdown(t *testing.T) {
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+				conn.Write([]byte(line))
+			}
+		}),
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(lstn) }()
+
+	idle, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	active, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	if _, err := active.Write([]byte("Hello World!\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the server to have received the request.
+	for i := 0; server.ActiveConns() != 1 || server.IdleConns() != 1; i++ {
+		if i == 100 {
+			t.Fatal("bad connection counts:", server.ActiveConns(), server.IdleConns())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if err := <-served; err != ErrServerClosed {
+		t.Error("bad error returned by Serve:", err)
+	}
+
+	if line, err := bufio.NewReader(active).ReadString('\n'); err != nil || line != "Hello World!\n" {
+		t.Errorf("bad response: %q %v", line, err)
+	}
+
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("the idle connection was not closed:", err)
+	}
+
+	if err := server.Serve(lstn); err != ErrServerClosed {
+		t.Error("bad error returned by Serve after Shutdown:", err)
+	}
+}
+
+func TestServerListenerClosed(t *testing.T) {
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Handler: Echo}
+	served := make(chan error, 1)
+
+	go func() { served <- server.Serve(lstn) }()
+
+	// Wait for the accept loop to be running before closing the listener.
+	conn, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	lstn.Close()
+
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Error("bad error returned by Serve after the listener was closed:", err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	done := make(chan struct{})
+
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			conn.Read(make([]byte, 1))
+			<-ctx.Done()
+			close(done)
+		}),
+	}
+
+	go server.Serve(lstn)
+
+	conn, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("A"))
+
+	for server.ActiveConns() != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Error("bad error returned by Shutdown:", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the context of the handler was not canceled")
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	lstn, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		Handler:     Echo,
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	go server.Serve(lstn)
+	defer server.Close()
+
+	idle, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	active, err := net.Dial("tcp", lstn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		idle.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := idle.Read(make([]byte, 1))
+		closed <- err
+	}()
+
+	b := make([]byte, 1)
+
+	for start := time.Now(); time.Since(start) < 300*time.Millisecond; {
+		if _, err := active.Write([]byte("A")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(active, b); err != nil {
+			t.Fatal("the active connection was closed:", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := <-closed; err != io.EOF {
+		t.Error("the idle connection was not closed:", err)
+	}
+}
+
+func TestServerMaxConnAge(t *testing.T) {
+	// The handler reads one byte then holds the connection without reading,
+	// so the connection stays active until the server closes it.
+	handler := HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			return
+		}
+		<-ctx.Done()
+	})
+
+	for _, test := range []struct {
+		name   string
+		grace  time.Duration
+		closed bool
+	}{
+		{"no-grace", 0, false},
+		{"grace", 100 * time.Millisecond, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lstn, err := Listen("127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := &Server{
+				Handler:         handler,
+				MaxConnAge:      100 * time.Millisecond,
+				MaxConnAgeGrace: test.grace,
+			}
+			go server.Serve(lstn)
+			defer server.Close()
+
+			idle, err := net.Dial("tcp", lstn.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idle.Close()
+
+			active, err := net.Dial("tcp", lstn.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer active.Close()
+			active.Write([]byte("A"))
+
+			idle.SetReadDeadline(time.Now().Add(time.Second))
+
+			if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+				t.Error("the idle connection was not closed:", err)
+			}
+
+			active.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			_, err = active.Read(make([]byte, 1))
+
+			if closed := err == io.EOF; closed != test.closed {
+				t.Error("bad state of the active connection:", err)
+			}
+		})
+	}
+}
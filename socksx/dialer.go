@@ -0,0 +1,360 @@
+package socksx
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// Dialer establishes connections through a SOCKS5 proxy server.
+//
+// TCP connections use the CONNECT command, UDP connections use the UDP
+// ASSOCIATE command, with datagrams relayed by the proxy. Host names are sent
+// to the proxy which resolves them, so the client doesn't need access to a DNS
+// server.
+type Dialer struct {
+	// Address is the address of the SOCKS5 proxy server.
+	Address string
+
+	// Username and Password are the credentials sent to the proxy. If
+	// Username is empty, the client does not offer to authenticate.
+	Username string
+	Password string
+
+	// Dial is used to establish the connections to the proxy. If nil, a
+	// net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+}
+
+// DialContext connects to address on the named network through the proxy, the
+// network must be one of tcp, tcp4, tcp6, udp, udp4, or udp6.
+//
+// The method has the signature expected by the DialContext fields of
+// transports and proxies.
+func (d *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		target, err := parseAddr(address)
+		if err != nil {
+			return nil, d.opError(network, err)
+		}
+		c, err := d.associate(ctx, target)
+		if err != nil {
+			return nil, d.opError(network, err)
+		}
+		return c, nil
+	default:
+		return nil, d.opError(network, net.UnknownNetworkError(network))
+	}
+
+	target, err := parseAddr(address)
+	if err != nil {
+		return nil, d.opError(network, err)
+	}
+
+	conn, _, err := d.request(ctx, cmdConnect, target)
+	if err != nil {
+		return nil, d.opError(network, err)
+	}
+
+	return &Conn{Conn: conn, remote: target}, nil
+}
+
+// ListenPacket returns a packet connection which sends and receives datagrams
+// relayed by the proxy, to and from any address.
+func (d *Dialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	c, err := d.associate(ctx, nil)
+	if err != nil {
+		return nil, d.opError("udp", err)
+	}
+	return c, nil
+}
+
+// Hop returns a dialer which reaches the proxy through dial, the method can be
+// used as a hop of a netx.ChainDialer.
+func (d *Dialer) Hop(dial netx.DialFunc) netx.DialFunc {
+	c := *d
+	c.Dial = dial
+	return c.DialContext
+}
+
+func (d *Dialer) opError(network string, err error) error {
+	return &net.OpError{Op: "socks", Net: network, Addr: &Addr{Host: d.Address}, Err: err}
+}
+
+func (d *Dialer) associate(ctx context.Context, target *Addr) (*PacketConn, error) {
+	// The client doesn't know the address it will send datagrams from yet,
+	// RFC 1928 says to use zeros in this case.
+	ctrl, relay, err := d.request(ctx, cmdUDPAssociate, &Addr{Host: "0.0.0.0"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Servers usually reply with an unspecified address when they relay
+	// datagrams on the same address that they accept connections on.
+	if ip := net.ParseIP(relay.Host); ip == nil || ip.IsUnspecified() {
+		host, _, _ := net.SplitHostPort(ctrl.RemoteAddr().String())
+		relay = &Addr{Host: host, Port: relay.Port}
+	}
+
+	dial := d.dialer()
+	conn, err := dial(ctx, "udp", relay.String())
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	c := &PacketConn{
+		conn:   conn,
+		ctrl:   ctrl,
+		remote: target,
+	}
+
+	// The association lasts as long as the control connection is open, it is
+	// monitored to close the packet connection when the proxy ends it.
+	go c.watch()
+	return c, nil
+}
+
+func (d *Dialer) dialer() func(context.Context, string, string) (net.Conn, error) {
+	if d.Dial != nil {
+		return d.Dial
+	}
+	return (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+}
+
+// request connects to the proxy, negotiates authentication and sends a
+// request with the given command and address. It returns the connection to
+// the proxy and the address sent in the reply.
+func (d *Dialer) request(ctx context.Context, cmd byte, addr *Addr) (conn net.Conn, bound *Addr, err error) {
+	if conn, err = d.dialer()(ctx, "tcp", d.Address); err != nil {
+		return
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err = d.handshake(conn); err == nil {
+		bound, err = d.command(conn, cmd, addr)
+	}
+
+	if err != nil {
+		conn.Close()
+		conn = nil
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+	return
+}
+
+func (d *Dialer) handshake(conn net.Conn) error {
+	methods := []byte{socksVersion, 1, authNone}
+
+	if len(d.Username) != 0 {
+		methods = []byte{socksVersion, 2, authNone, authPassword}
+	}
+
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+
+	var b [2]byte
+
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return err
+	}
+
+	if b[0] != socksVersion {
+		return errBadVersion
+	}
+
+	switch b[1] {
+	case authNone:
+		return nil
+	case authPassword:
+		if len(d.Username) != 0 {
+			return d.authenticate(conn)
+		}
+	}
+
+	return ErrNoAcceptableAuth
+}
+
+// authenticate runs the username/password subnegotiation of RFC 1929.
+func (d *Dialer) authenticate(conn net.Conn) error {
+	user, pass := d.Username, d.Password
+
+	if len(user) > 255 || len(pass) > 255 {
+		return ErrAuthFailed
+	}
+
+	b := make([]byte, 0, 3+len(user)+len(pass))
+	b = append(b, authPasswordVersion, byte(len(user)))
+	b = append(b, user...)
+	b = append(b, byte(len(pass)))
+	b = append(b, pass...)
+
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return err
+	}
+
+	if b[1] != 0 {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+func (d *Dialer) command(conn net.Conn, cmd byte, addr *Addr) (*Addr, error) {
+	if _, err := conn.Write(appendAddr([]byte{socksVersion, cmd, 0}, addr)); err != nil {
+		return nil, err
+	}
+
+	var b [3]byte
+
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return nil, err
+	}
+
+	if b[0] != socksVersion {
+		return nil, errBadVersion
+	}
+
+	if reply := Reply(b[1]); reply != Succeeded {
+		return nil, reply
+	}
+
+	return readAddr(conn)
+}
+
+// Conn is the type of TCP connections established through a SOCKS5 proxy.
+type Conn struct {
+	net.Conn
+	remote *Addr
+}
+
+// BaseConn returns the connection to the proxy.
+func (c *Conn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+// RemoteAddr returns the address that the connection was established to,
+// which may contain a host name.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// PacketConn is the type of UDP connections established through a SOCKS5
+// proxy.
+//
+// It implements both the net.Conn and net.PacketConn interfaces, the Read and
+// Write methods exchange datagrams with the address passed to DialContext,
+// while ReadFrom and WriteTo may be used with any address. Read may also
+// return datagrams received from other addresses.
+type PacketConn struct {
+	conn   net.Conn // the UDP socket connected to the relay
+	ctrl   net.Conn // the control connection
+	remote *Addr
+	once   sync.Once
+}
+
+// Read satisfies the net.Conn interface.
+func (c *PacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// Write satisfies the net.Conn interface.
+func (c *PacketConn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: errMissingAddress}
+	}
+	return c.WriteTo(b, c.remote)
+}
+
+// ReadFrom satisfies the net.PacketConn interface.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+262) // room for the largest header
+
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		addr, payload, err := parseUDPHeader(buf[:n])
+		if err != nil {
+			continue // discard malformed datagrams
+		}
+
+		return copy(b, payload), addr, nil
+	}
+}
+
+// WriteTo satisfies the net.PacketConn interface.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	a := addrOf(addr)
+	buf := append(appendUDPHeader(make([]byte, 0, 262+len(b)), a), b...)
+
+	if _, err := c.conn.Write(buf); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Close closes the connection, ending the association with the proxy.
+func (c *PacketConn) Close() error {
+	c.once.Do(func() { c.ctrl.Close() })
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address of the UDP socket.
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the address passed to DialContext, or nil if the
+// connection was created by ListenPacket.
+func (c *PacketConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote
+}
+
+// SetDeadline satisfies the net.Conn interface.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline satisfies the net.Conn interface.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline satisfies the net.Conn interface.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *PacketConn) watch() {
+	var b [1]byte
+	for {
+		if _, err := c.ctrl.Read(b[:]); err != nil {
+			c.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package socksx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveTestProxy runs a minimal SOCKS5 server on lstn which requires the
+// user "luke" with password "secret", and relays connections to their target
+// address. UDP datagrams are echoed back to the client.
+func serveTestProxy(t *testing.T, lstn net.Listener) {
+	for {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+		go serveTestConn(t, conn)
+	}
+}
+
+func serveTestConn(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	b := make([]byte, 512)
+
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, b[:b[1]]); err != nil {
+		return
+	}
+	if bytes.IndexByte(b[:b[1]], authPassword) < 0 {
+		conn.Write([]byte{socksVersion, authNoAcceptable})
+		return
+	}
+	conn.Write([]byte{socksVersion, authPassword})
+
+	io.ReadFull(conn, b[:2])
+	user := make([]byte, b[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, b[:1])
+	pass := make([]byte, b[0])
+	io.ReadFull(conn, pass)
+
+	if string(user) != "luke" || string(pass) != "secret" {
+		conn.Write([]byte{authPasswordVersion, 1})
+		return
+	}
+	conn.Write([]byte{authPasswordVersion, 0})
+
+	if _, err := io.ReadFull(conn, b[:3]); err != nil {
+		return
+	}
+	cmd := b[1]
+
+	addr, err := readAddr(conn)
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case cmdConnect:
+		target, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			conn.Write(appendAddr([]byte{socksVersion, byte(ConnectionRefused), 0}, &Addr{Host: "0.0.0.0"}))
+			return
+		}
+		defer target.Close()
+		conn.Write(appendAddr([]byte{socksVersion, byte(Succeeded), 0}, addrOf(target.LocalAddr())))
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+
+	case cmdUDPAssociate:
+		relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		port := relay.LocalAddr().(*net.UDPAddr).Port
+		conn.Write(appendAddr([]byte{socksVersion, byte(Succeeded), 0}, &Addr{Host: "0.0.0.0", Port: port}))
+		go func() {
+			for {
+				n, from, err := relay.ReadFrom(b)
+				if err != nil {
+					return
+				}
+				relay.WriteTo(b[:n], from)
+			}
+		}()
+		io.Copy(ioutil.Discard, conn)
+
+	default:
+		conn.Write(appendAddr([]byte{socksVersion, byte(CommandNotSupported), 0}, &Addr{Host: "0.0.0.0"}))
+	}
+}
+
+func listenTestProxy(t *testing.T) net.Listener {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveTestProxy(t, lstn)
+	return lstn
+}
+
+func TestDialerConnect(t *testing.T) {
+	proxy := listenTestProxy(t)
+	defer proxy.Close()
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+
+	go func() {
+		conn, err := echo.Accept()
+		if err == nil {
+			io.Copy(conn, conn)
+			conn.Close()
+		}
+	}()
+
+	d := &Dialer{Address: proxy.Addr().String(), Username: "luke", Password: "secret"}
+
+	conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if addr := conn.RemoteAddr().String(); addr != echo.Addr().String() {
+		t.Error("bad remote address:", addr)
+	}
+
+	conn.Write([]byte("Hello World!"))
+	b := make([]byte, 12)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Error("bad response:", string(b))
+	}
+}
+
+func TestDialerAuthFailed(t *testing.T) {
+	proxy := listenTestProxy(t)
+	defer proxy.Close()
+
+	d := &Dialer{Address: proxy.Addr().String(), Username: "luke", Password: "wrong"}
+
+	_, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+
+	if e, ok := err.(*net.OpError); !ok || e.Err != ErrAuthFailed {
+		t.Error("bad error:", err)
+	}
+
+	d = &Dialer{Address: proxy.Addr().String()}
+
+	_, err = d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+
+	if e, ok := err.(*net.OpError); !ok || e.Err != ErrNoAcceptableAuth {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestDialerUDP(t *testing.T) {
+	proxy := listenTestProxy(t)
+	defer proxy.Close()
+
+	d := &Dialer{Address: proxy.Addr().String(), Username: "luke", Password: "secret"}
+
+	conn, err := d.DialContext(context.Background(), "udp", "example.com:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(1 * time.Second))
+
+	if _, err := conn.Write([]byte("Hello World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test server echoes the datagrams, header included.
+	b := make([]byte, 64)
+	n, addr, err := conn.(net.PacketConn).ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b[:n]) != "Hello World!" {
+		t.Error("bad datagram:", string(b[:n]))
+	}
+
+	if addr.String() != "example.com:53" {
+		t.Error("bad address:", addr)
+	}
+}
+
+func TestAddrEncoding(t *testing.T) {
+	for _, a := range []*Addr{
+		{Host: "127.0.0.1", Port: 80},
+		{Host: "::1", Port: 443},
+		{Host: "example.com", Port: 8080},
+	} {
+		t.Run(a.String(), func(t *testing.T) {
+			b := appendAddr(nil, a)
+			x, err := readAddr(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *x != *a {
+				t.Error("bad address:", x)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package socksx
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// Server is a SOCKS5 server, it implements the netx.Handler interface so it can
+// be used with a netx.Server to serve connections accepted by any listener.
+//
+// The server supports the CONNECT and UDP ASSOCIATE commands, connections are
+// tunneled with netx.Bridge.
+type Server struct {
+	// Authenticate is called to verify the credentials sent by clients, which
+	// are then required to use the username/password authentication method.
+	// If nil, clients are not authenticated.
+	Authenticate func(ctx context.Context, username string, password string) error
+
+	// Allow is called before connecting to a destination (or relaying a
+	// datagram) to decide whether the client is allowed to reach it. The
+	// network is either "tcp" or "udp", user is the name of the authenticated
+	// user, if any. If nil, all destinations are allowed.
+	Allow func(ctx context.Context, user string, network string, address string) bool
+
+	// DialContext is used to establish the connections requested by clients.
+	// If nil, a net.Dialer with a 10 seconds timeout is used.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// HandshakeTimeout is the maximum amount of time that clients have to
+	// authenticate and send their request. If zero, DefaultHandshakeTimeout
+	// is used.
+	HandshakeTimeout time.Duration
+
+	// IdleTimeout is the maximum amount of time that tunneled connections and
+	// UDP associations may stay idle before they are closed. Zero means no
+	// timeout.
+	IdleTimeout time.Duration
+
+	// BufferPool is used to get the buffers for copying tunneled bytes. If nil,
+	// the internal buffer pool of netx.Copy is used.
+	BufferPool netx.BufferPool
+}
+
+// DefaultHandshakeTimeout is the default handshake timeout of a SOCKS5 server.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// ServeConn satisfies the netx.Handler interface.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	timeout := s.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	user, err := s.handshake(ctx, conn)
+	if err != nil {
+		return
+	}
+
+	var b [3]byte
+
+	if _, err := io.ReadFull(conn, b[:]); err != nil || b[0] != socksVersion {
+		return
+	}
+
+	target, err := readAddr(conn)
+	if err != nil {
+		if err == AddressTypeNotSupported {
+			reply(conn, AddressTypeNotSupported, nil)
+		}
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	switch b[1] {
+	case cmdConnect:
+		s.serveConnect(ctx, conn, user, target)
+	case cmdUDPAssociate:
+		s.serveAssociate(ctx, conn, user, target)
+	default:
+		reply(conn, CommandNotSupported, nil)
+	}
+}
+
+func (s *Server) handshake(ctx context.Context, conn net.Conn) (user string, err error) {
+	var b [255]byte
+
+	if _, err = io.ReadFull(conn, b[:2]); err != nil {
+		return
+	}
+
+	if b[0] != socksVersion {
+		err = errBadVersion
+		return
+	}
+
+	methods := b[:b[1]]
+
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(authNone)
+
+	if s.Authenticate != nil {
+		method = authPassword
+	}
+
+	if !hasMethod(methods, method) {
+		conn.Write([]byte{socksVersion, authNoAcceptable})
+		err = ErrNoAcceptableAuth
+		return
+	}
+
+	if _, err = conn.Write([]byte{socksVersion, method}); err != nil || method == authNone {
+		return
+	}
+
+	// RFC 1929 username/password subnegotiation.
+	if _, err = io.ReadFull(conn, b[:2]); err != nil {
+		return
+	}
+
+	username := make([]byte, b[1])
+
+	if _, err = io.ReadFull(conn, username); err != nil {
+		return
+	}
+
+	if _, err = io.ReadFull(conn, b[:1]); err != nil {
+		return
+	}
+
+	password := make([]byte, b[0])
+
+	if _, err = io.ReadFull(conn, password); err != nil {
+		return
+	}
+
+	if err = s.Authenticate(ctx, string(username), string(password)); err != nil {
+		conn.Write([]byte{authPasswordVersion, 1})
+		return
+	}
+
+	_, err = conn.Write([]byte{authPasswordVersion, 0})
+	user = string(username)
+	return
+}
+
+func (s *Server) serveConnect(ctx context.Context, conn net.Conn, user string, target *Addr) {
+	if s.Allow != nil && !s.Allow(ctx, user, "tcp", target.String()) {
+		reply(conn, ConnectionNotAllowed, nil)
+		return
+	}
+
+	backend, err := s.dial(ctx, "tcp", target.String())
+	if err != nil {
+		reply(conn, replyOf(err), nil)
+		return
+	}
+	defer backend.Close()
+
+	if err := reply(conn, Succeeded, backend.LocalAddr()); err != nil {
+		return
+	}
+
+	netx.Bridge(ctx, conn, backend, netx.BridgeOptions{
+		IdleTimeout: s.IdleTimeout,
+		BufferPool:  s.BufferPool,
+	})
+}
+
+func (s *Server) serveAssociate(ctx context.Context, conn net.Conn, user string, source *Addr) {
+	// The relay listens on the address that the client connected to, which
+	// the client is known to be able to reach.
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+
+	relay, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		reply(conn, GeneralFailure, nil)
+		return
+	}
+	defer relay.Close()
+
+	if err := reply(conn, Succeeded, relay.LocalAddr()); err != nil {
+		return
+	}
+
+	client, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	a := &association{
+		server: s,
+		ctx:    ctx,
+		user:   user,
+		relay:  relay,
+		client: net.ParseIP(client),
+	}
+
+	// Clients may send the address they will use in the request, otherwise
+	// it is learned from the first datagram received from the client's IP.
+	if ip := net.ParseIP(source.Host); ip != nil && !ip.IsUnspecified() && source.Port != 0 {
+		a.addr = &net.UDPAddr{IP: ip, Port: source.Port}
+	}
+
+	relayed := make(chan struct{})
+	go func() {
+		a.run()
+		close(relayed)
+	}()
+
+	// The association lasts until the client closes the control connection
+	// or the relay fails.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-relayed:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Server) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := s.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+	return dial(ctx, network, address)
+}
+
+// association is the state of a UDP association, datagrams received from the
+// client are forwarded to their destination, and datagrams received from
+// other addresses are relayed to the client.
+type association struct {
+	server *Server
+	ctx    context.Context
+	user   string
+	relay  net.PacketConn
+	client net.IP
+	addr   *net.UDPAddr // address that the client sends datagrams from
+}
+
+func (a *association) run() {
+	buf := make([]byte, 65535)
+	out := make([]byte, 0, 65535)
+
+	for {
+		if timeout := a.server.IdleTimeout; timeout != 0 {
+			a.relay.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		n, from, err := a.relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		src := from.(*net.UDPAddr)
+
+		if a.addr == nil && src.IP.Equal(a.client) {
+			a.addr = src
+		}
+
+		if a.addr != nil && src.IP.Equal(a.addr.IP) && src.Port == a.addr.Port {
+			a.forward(buf[:n])
+			continue
+		}
+
+		if a.addr != nil {
+			out = append(appendUDPHeader(out[:0], addrOf(src)), buf[:n]...)
+			a.relay.WriteTo(out, a.addr)
+		}
+	}
+}
+
+func (a *association) forward(b []byte) {
+	target, payload, err := parseUDPHeader(b)
+	if err != nil {
+		return
+	}
+
+	s := a.server
+
+	if s.Allow != nil && !s.Allow(a.ctx, a.user, "udp", target.String()) {
+		return
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", target.String())
+	if err != nil {
+		return
+	}
+
+	a.relay.WriteTo(payload, addr)
+}
+
+func hasMethod(methods []byte, method byte) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// reply sends a reply to the client's request, with addr as bound address.
+func reply(conn net.Conn, r Reply, addr net.Addr) error {
+	a := &Addr{Host: "0.0.0.0"}
+	if addr != nil {
+		a = addrOf(addr)
+	}
+	_, err := conn.Write(appendAddr([]byte{socksVersion, byte(r), 0}, a))
+	return err
+}
+
+// replyOf returns the reply code representing err, which was returned when
+// dialing a destination.
+func replyOf(err error) Reply {
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(interface{ Unwrap() error }); ok {
+		err = e.Unwrap()
+	}
+
+	switch e := err.(type) {
+	case Reply:
+		return e
+	case *net.DNSError:
+		return HostUnreachable
+	case syscall.Errno:
+		switch e {
+		case syscall.ECONNREFUSED:
+			return ConnectionRefused
+		case syscall.ENETUNREACH:
+			return NetworkUnreachable
+		case syscall.EHOSTUNREACH:
+			return HostUnreachable
+		}
+	}
+
+	if netx.IsTimeout(err) {
+		return HostUnreachable
+	}
+
+	return GeneralFailure
+}
@@ -0,0 +1,153 @@
+package socksx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+func listenAndServe(t *testing.T, s *Server) (string, func()) {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go (&netx.Server{Handler: s, Context: ctx}).Serve(lstn)
+	return lstn.Addr().String(), cancel
+}
+
+func TestServerConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go (&netx.Server{Handler: netx.Echo}).Serve(echo)
+
+	users := make(chan string, 1)
+
+	addr, stop := listenAndServe(t, &Server{
+		Authenticate: func(ctx context.Context, username string, password string) error {
+			if password != "secret" {
+				return errors.New("bad password")
+			}
+			return nil
+		},
+		Allow: func(ctx context.Context, user string, network string, address string) bool {
+			users <- user
+			return address == echo.Addr().String()
+		},
+	})
+	defer stop()
+
+	d := &Dialer{Address: addr, Username: "luke", Password: "secret"}
+
+	conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if user := <-users; user != "luke" {
+		t.Error("bad user:", user)
+	}
+
+	conn.Write([]byte("Hello World!"))
+	b := make([]byte, 12)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Error("bad response:", string(b))
+	}
+
+	t.Run("not-allowed", func(t *testing.T) {
+		_, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+		<-users
+
+		if e, ok := err.(*net.OpError); !ok || e.Err != ConnectionNotAllowed {
+			t.Error("bad error:", err)
+		}
+	})
+
+	t.Run("auth-failed", func(t *testing.T) {
+		d := &Dialer{Address: addr, Username: "luke", Password: "wrong"}
+		_, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+
+		if e, ok := err.(*net.OpError); !ok || e.Err != ErrAuthFailed {
+			t.Error("bad error:", err)
+		}
+	})
+}
+
+func TestServerConnectRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := l.Addr().String()
+	l.Close()
+
+	addr, stop := listenAndServe(t, &Server{})
+	defer stop()
+
+	_, err = (&Dialer{Address: addr}).DialContext(context.Background(), "tcp", target)
+
+	if e, ok := err.(*net.OpError); !ok || e.Err != ConnectionRefused {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestServerUDPAssociate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := echo.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(b[:n], addr)
+		}
+	}()
+
+	addr, stop := listenAndServe(t, &Server{})
+	defer stop()
+
+	conn, err := (&Dialer{Address: addr}).DialContext(context.Background(), "udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(1 * time.Second))
+
+	if _, err := conn.Write([]byte("Hello World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 64)
+	n, from, err := conn.(net.PacketConn).ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b[:n]) != "Hello World!" {
+		t.Error("bad datagram:", string(b[:n]))
+	}
+
+	if from.String() != echo.LocalAddr().String() {
+		t.Error("bad source address:", from)
+	}
+}
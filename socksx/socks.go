@@ -0,0 +1,212 @@
+package socksx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	socksVersion = 5
+
+	// Authentication methods.
+	authNone         = 0x00
+	authPassword     = 0x02
+	authNoAcceptable = 0xFF
+
+	// Version of the username/password authentication subnegotiation.
+	authPasswordVersion = 1
+
+	// Commands.
+	cmdConnect      = 0x01
+	cmdUDPAssociate = 0x03
+
+	// Address types.
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// Reply is the type of the reply codes sent by SOCKS5 servers in response to
+// client requests.
+type Reply byte
+
+// Reply codes defined in RFC 1928.
+const (
+	Succeeded               Reply = 0x00
+	GeneralFailure          Reply = 0x01
+	ConnectionNotAllowed    Reply = 0x02
+	NetworkUnreachable      Reply = 0x03
+	HostUnreachable         Reply = 0x04
+	ConnectionRefused       Reply = 0x05
+	TTLExpired              Reply = 0x06
+	CommandNotSupported     Reply = 0x07
+	AddressTypeNotSupported Reply = 0x08
+)
+
+// Error satisfies the error interface, so replies reporting failures can be
+// returned as errors.
+func (r Reply) Error() string {
+	switch r {
+	case Succeeded:
+		return "succeeded"
+	case GeneralFailure:
+		return "general SOCKS server failure"
+	case ConnectionNotAllowed:
+		return "connection not allowed by ruleset"
+	case NetworkUnreachable:
+		return "network unreachable"
+	case HostUnreachable:
+		return "host unreachable"
+	case ConnectionRefused:
+		return "connection refused"
+	case TTLExpired:
+		return "TTL expired"
+	case CommandNotSupported:
+		return "command not supported"
+	case AddressTypeNotSupported:
+		return "address type not supported"
+	default:
+		return "unknown SOCKS reply code " + strconv.Itoa(int(r))
+	}
+}
+
+var (
+	// ErrAuthFailed is returned by dialers when the proxy rejected the
+	// credentials.
+	ErrAuthFailed = errors.New("socks: authentication failed")
+
+	// ErrNoAcceptableAuth is returned by dialers when the proxy does not
+	// support any of the authentication methods offered by the client.
+	ErrNoAcceptableAuth = errors.New("socks: no acceptable authentication method")
+
+	errBadVersion     = errors.New("socks: unsupported protocol version")
+	errBadAddress     = errors.New("socks: malformed address")
+	errMissingAddress = errors.New("socks: missing destination address")
+)
+
+// Addr is the representation of the addresses exchanged by SOCKS5 clients and
+// servers, which may be an IP address or a host name, and a port.
+type Addr struct {
+	Host string
+	Port int
+}
+
+// Network returns "socks".
+func (a *Addr) Network() string { return "socks" }
+
+// String returns the host:port representation of a.
+func (a *Addr) String() string { return net.JoinHostPort(a.Host, strconv.Itoa(a.Port)) }
+
+func parseAddr(address string) (*Addr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, errors.New("socks: invalid port in address " + address)
+	}
+	if len(host) > 255 {
+		return nil, errors.New("socks: host name too long in address " + address)
+	}
+	return &Addr{Host: host, Port: p}, nil
+}
+
+func addrOf(addr net.Addr) *Addr {
+	switch a := addr.(type) {
+	case *Addr:
+		return a
+	case *net.TCPAddr:
+		return &Addr{Host: a.IP.String(), Port: a.Port}
+	case *net.UDPAddr:
+		return &Addr{Host: a.IP.String(), Port: a.Port}
+	}
+	a, err := parseAddr(addr.String())
+	if err != nil {
+		return &Addr{Host: "0.0.0.0"}
+	}
+	return a
+}
+
+// appendAddr appends the wire representation of a to b.
+func appendAddr(b []byte, a *Addr) []byte {
+	if ip := net.ParseIP(a.Host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, atypIPv4), ip4...)
+		} else {
+			b = append(append(b, atypIPv6), ip.To16()...)
+		}
+	} else {
+		b = append(append(b, atypDomain, byte(len(a.Host))), a.Host...)
+	}
+	return append(b, byte(a.Port>>8), byte(a.Port))
+}
+
+// readAddr reads an address in its wire representation from r.
+func readAddr(r io.Reader) (*Addr, error) {
+	var b [256]byte
+
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return nil, err
+	}
+
+	var host string
+
+	switch b[0] {
+	case atypIPv4:
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return nil, err
+		}
+		host = net.IP(b[:4]).String()
+
+	case atypIPv6:
+		if _, err := io.ReadFull(r, b[:16]); err != nil {
+			return nil, err
+		}
+		host = net.IP(b[:16]).String()
+
+	case atypDomain:
+		if _, err := io.ReadFull(r, b[:1]); err != nil {
+			return nil, err
+		}
+		n := int(b[0])
+		if _, err := io.ReadFull(r, b[:n]); err != nil {
+			return nil, err
+		}
+		host = string(b[:n])
+
+	default:
+		return nil, AddressTypeNotSupported
+	}
+
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return nil, err
+	}
+
+	return &Addr{Host: host, Port: int(binary.BigEndian.Uint16(b[:2]))}, nil
+}
+
+// appendUDPHeader appends the header of UDP datagrams relayed through a SOCKS5
+// server to b.
+func appendUDPHeader(b []byte, a *Addr) []byte {
+	return appendAddr(append(b, 0, 0, 0), a)
+}
+
+// parseUDPHeader parses the header of a UDP datagram relayed through a SOCKS5
+// server, returning the address and the payload. Fragmented datagrams are not
+// supported.
+func parseUDPHeader(b []byte) (*Addr, []byte, error) {
+	if len(b) < 4 || b[2] != 0 {
+		return nil, nil, errBadAddress
+	}
+	r := bytes.NewReader(b[3:])
+	a, err := readAddr(r)
+	if err != nil {
+		return nil, nil, errBadAddress
+	}
+	return a, b[len(b)-r.Len():], nil
+}
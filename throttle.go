@@ -0,0 +1,193 @@
+package netx
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Throttle wraps conn to limit the rate at which bytes can be read from and
+// written to the connection, expressed in bytes per second. A zero or negative
+// rate means the direction is not throttled.
+//
+// Each direction has its own token bucket, which allows bursts of up to one
+// second worth of bytes. Reads and writes that have to wait for the budget to
+// be replenished honor the deadlines set on the connection, returning timeout
+// errors if they expire before the operation could complete.
+//
+// The returned connection exposes conn through its BaseConn method.
+func Throttle(conn net.Conn, readRate int, writeRate int) net.Conn {
+	if readRate <= 0 && writeRate <= 0 {
+		return conn
+	}
+	return &throttledConn{
+		Conn: conn,
+		r:    newTokenBucket(readRate),
+		w:    newTokenBucket(writeRate),
+	}
+}
+
+type throttledConn struct {
+	net.Conn
+	r *tokenBucket
+	w *tokenBucket
+}
+
+func (c *throttledConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *throttledConn) Read(b []byte) (n int, err error) {
+	if c.r == nil || len(b) == 0 {
+		return c.Conn.Read(b)
+	}
+
+	var max int
+
+	if max, err = c.r.take(len(b), "read"); err != nil {
+		return
+	}
+
+	n, err = c.Conn.Read(b[:max])
+	c.r.give(max - n)
+	return
+}
+
+func (c *throttledConn) Write(b []byte) (n int, err error) {
+	if c.w == nil {
+		return c.Conn.Write(b)
+	}
+
+	for len(b) != 0 && err == nil {
+		var max, k int
+
+		if max, err = c.w.take(len(b), "write"); err != nil {
+			break
+		}
+
+		k, err = c.Conn.Write(b[:max])
+		c.w.give(max - k)
+		n, b = n+k, b[k:]
+	}
+
+	return
+}
+
+func (c *throttledConn) SetDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	c.w.setDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *throttledConn) SetReadDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *throttledConn) SetWriteDeadline(t time.Time) error {
+	c.w.setDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// tokenBucket implements the rate limiting algorithm used by throttled
+// connections and listeners, the bucket holds up to burst tokens (one per byte
+// or connection) and refills continuously at rate tokens per second.
+//
+// A nil bucket is valid and represents an unlimited budget.
+type tokenBucket struct {
+	mutex    sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	deadline time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return newTokenBucketBurst(rate, rate)
+}
+
+func newTokenBucketBurst(rate int, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) setDeadline(t time.Time) {
+	if b != nil {
+		b.mutex.Lock()
+		b.deadline = t
+		b.mutex.Unlock()
+	}
+}
+
+// take blocks until at least one token is available in the bucket, then
+// removes and returns up to n tokens.
+func (b *tokenBucket) take(n int, op string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for {
+		now := time.Now()
+		b.refill(now)
+
+		if b.tokens >= 1 {
+			if max := int(b.tokens); n > max {
+				n = max
+			}
+			b.tokens -= float64(n)
+			return n, nil
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+
+		if !b.deadline.IsZero() {
+			if !now.Add(wait).Before(b.deadline) {
+				return 0, Timeout(op + " deadline exceeded while waiting for the connection's rate limit")
+			}
+		}
+
+		b.mutex.Unlock()
+		time.Sleep(wait)
+		b.mutex.Lock()
+	}
+}
+
+// level returns the ratio of available tokens in the bucket, between 0 and 1.
+func (b *tokenBucket) level() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	return b.tokens / b.burst
+}
+
+// give puts n unused tokens back into the bucket.
+func (b *tokenBucket) give(n int) {
+	if n > 0 {
+		b.mutex.Lock()
+		b.tokens += float64(n)
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.mutex.Unlock()
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
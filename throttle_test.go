@@ -0,0 +1,98 @@
+package netx
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name      string
+		readRate  int
+		writeRate int
+	}{
+		{
+			name:     "read",
+			readRate: 1000,
+		},
+		{
+			name:      "write",
+			writeRate: 1000,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+
+			conn := Throttle(c1, test.readRate, test.writeRate)
+			peer := net.Conn(c2)
+
+			if test.readRate != 0 {
+				conn, peer = peer, conn
+			}
+
+			if BaseConn(Throttle(c1, test.readRate, test.writeRate)) != c1 {
+				t.Error("the throttled connection doesn't expose its base connection")
+			}
+
+			// The bucket starts full, so sending 1500 bytes should consume
+			// the initial burst and wait for half a second worth of tokens.
+			go func() {
+				conn.Write(make([]byte, 1500))
+				conn.Close()
+			}()
+
+			start := time.Now()
+			n, err := io.Copy(ioutil.Discard, peer)
+			elapsed := time.Since(start)
+
+			if err != nil {
+				t.Error(err)
+			}
+
+			if n != 1500 {
+				t.Error("bad byte count:", n)
+			}
+
+			if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+				t.Error("bad throttling delay:", elapsed)
+			}
+		})
+	}
+}
+
+func TestThrottleDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go io.Copy(ioutil.Discard, c2)
+
+	conn := Throttle(c1, 0, 100)
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+
+	n, err := conn.Write(make([]byte, 200))
+
+	if n < 100 || n >= 200 {
+		t.Error("bad byte count:", n)
+	}
+
+	if !IsTimeout(err) {
+		t.Error("expected a timeout error but got", err)
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	if conn := Throttle(c1, 0, 0); conn != c1 {
+		t.Error("the connection should not be wrapped when no rate is set")
+	}
+}
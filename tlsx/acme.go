@@ -0,0 +1,586 @@
+package tlsx
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acmeClient implements the subset of the ACME protocol (RFC 8555) needed to
+// obtain certificates for DNS names with the HTTP-01 and TLS-ALPN-01
+// challenges.
+type acmeClient struct {
+	directoryURL string
+	client       *http.Client
+	key          *ecdsa.PrivateKey
+	contact      []string
+	acceptTOS    bool
+
+	mutex  sync.Mutex
+	dir    *acmeDirectory
+	kid    string
+	nonces []string
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	Status         string     `json:"status"`
+	Authorizations []string   `json:"authorizations"`
+	Finalize       string     `json:"finalize"`
+	Certificate    string     `json:"certificate"`
+	Error          *acmeError `json:"error"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type   string     `json:"type"`
+	URL    string     `json:"url"`
+	Token  string     `json:"token"`
+	Status string     `json:"status"`
+	Error  *acmeError `json:"error"`
+}
+
+// acmeError is the problem document (RFC 7807) returned by ACME servers to
+// report errors.
+type acmeError struct {
+	Status int    `json:"status"`
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (e *acmeError) Error() string {
+	return fmt.Sprintf("acme: %s (%s)", e.Detail, e.Type)
+}
+
+const (
+	acmeBadNonce = "urn:ietf:params:acme:error:badNonce"
+
+	// acmePollInterval is the interval between two requests polling the state
+	// of an order or authorization, when the server didn't send a Retry-After
+	// header.
+	acmePollInterval = 1 * time.Second
+)
+
+// idPeACMEIdentifier is the OID of the certificate extension carrying the key
+// authorization of TLS-ALPN-01 challenges (see RFC 8737, section 6.1).
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// register creates the ACME account of the client's key, or looks it up if it
+// already exists. The account is only created if the terms of service of the
+// certificate authority were accepted.
+func (c *acmeClient) register(ctx context.Context) error {
+	c.mutex.Lock()
+	kid := c.kid
+	c.mutex.Unlock()
+
+	if len(kid) != 0 {
+		return nil
+	}
+
+	if !c.acceptTOS {
+		return errors.New("acme: the terms of service of the certificate authority must be accepted to create an account")
+	}
+
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return err
+	}
+
+	account := struct {
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		Contact              []string `json:"contact,omitempty"`
+	}{c.acceptTOS, c.contact}
+
+	res, err := c.post(ctx, dir.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+
+	if kid = res.Header.Get("Location"); len(kid) == 0 {
+		return errors.New("acme: the account has no location")
+	}
+
+	c.mutex.Lock()
+	c.kid = kid
+	c.mutex.Unlock()
+	return nil
+}
+
+// authorize creates an order for the DNS name host, and completes the
+// authorizations of the order with the challenge of type typ. The prepare
+// function is called to provision the response to the challenges before they
+// are accepted, and the returned function to clean it up.
+func (c *acmeClient) authorize(ctx context.Context, host string, typ string, prepare func(acmeChallenge) (func(), error)) (orderURL string, order *acmeOrder, err error) {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	order = &acmeOrder{}
+	req := struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}{[]acmeIdentifier{{Type: "dns", Value: host}}}
+
+	res, err := c.post(ctx, dir.NewOrder, req, order)
+	if err != nil {
+		return "", nil, err
+	}
+	orderURL = res.Header.Get("Location")
+
+	for _, authzURL := range order.Authorizations {
+		if err := c.authorization(ctx, authzURL, typ, prepare); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return orderURL, order, nil
+}
+
+func (c *acmeClient) authorization(ctx context.Context, authzURL string, typ string, prepare func(acmeChallenge) (func(), error)) error {
+	authz := &acmeAuthorization{}
+
+	if _, err := c.post(ctx, authzURL, nil, authz); err != nil {
+		return err
+	}
+
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var challenge *acmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == typ {
+			challenge = &authz.Challenges[i]
+			break
+		}
+	}
+
+	if challenge == nil {
+		return fmt.Errorf("acme: no %s challenge offered for %s", typ, authz.Identifier.Value)
+	}
+
+	cleanup, err := prepare(*challenge)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := c.post(ctx, challenge.URL, struct{}{}, nil); err != nil {
+		return err
+	}
+
+	for {
+		res, err := c.post(ctx, authzURL, nil, authz)
+		if err != nil {
+			return err
+		}
+
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+		default:
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return ch.Error
+				}
+			}
+			return fmt.Errorf("acme: authorization of %s is %s", authz.Identifier.Value, authz.Status)
+		}
+
+		if err := sleep(ctx, retryAfter(res)); err != nil {
+			return err
+		}
+	}
+}
+
+// finalize submits the certificate signing request of the order and returns
+// the certificate chain, encoded in DER.
+func (c *acmeClient) finalize(ctx context.Context, orderURL string, order *acmeOrder, csr []byte) ([][]byte, error) {
+	req := struct {
+		CSR string `json:"csr"`
+	}{base64.RawURLEncoding.EncodeToString(csr)}
+
+	res, err := c.post(ctx, order.Finalize, req, order)
+	if err != nil {
+		return nil, err
+	}
+
+	for order.Status != "valid" {
+		switch order.Status {
+		case "pending", "ready", "processing":
+		default:
+			if order.Error != nil {
+				return nil, order.Error
+			}
+			return nil, fmt.Errorf("acme: the order is %s", order.Status)
+		}
+
+		if err := sleep(ctx, retryAfter(res)); err != nil {
+			return nil, err
+		}
+
+		if res, err = c.post(ctx, orderURL, nil, order); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err = c.post(ctx, order.Certificate, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain [][]byte
+	for rest := res.body; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("acme: the certificate chain is empty")
+	}
+	return chain, nil
+}
+
+// keyAuthorization returns the key authorization of token (see RFC 8555,
+// section 8.1).
+func (c *acmeClient) keyAuthorization(token string) string {
+	sum := sha256.Sum256([]byte(jwkThumbprintInput(&c.key.PublicKey)))
+	return token + "." + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (c *acmeClient) directory(ctx context.Context) (*acmeDirectory, error) {
+	c.mutex.Lock()
+	dir := c.dir
+	c.mutex.Unlock()
+
+	if dir != nil {
+		return dir, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.directoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	dir = &acmeDirectory{}
+	if err := json.Unmarshal(res.body, dir); err != nil {
+		return nil, fmt.Errorf("acme: decoding the directory: %w", err)
+	}
+
+	c.mutex.Lock()
+	c.dir = dir
+	c.mutex.Unlock()
+	return dir, nil
+}
+
+// acmeResponse is a response of an ACME server, with its body read.
+type acmeResponse struct {
+	*http.Response
+	body []byte
+}
+
+// post sends a JWS signed request with payload to url, payload is nil for
+// POST-as-GET requests. The response body is decoded into result if it is not
+// nil.
+//
+// Requests rejected because of a bad nonce are retried once, as recommended by
+// RFC 8555, section 6.5.
+func (c *acmeClient) post(ctx context.Context, url string, payload interface{}, result interface{}) (*acmeResponse, error) {
+	var res *acmeResponse
+	var err error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if res, err = c.postOnce(ctx, url, payload); err == nil {
+			break
+		}
+		if e, ok := err.(*acmeError); !ok || e.Type != acmeBadNonce {
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(res.body, result); err != nil {
+			return nil, fmt.Errorf("acme: decoding the response of %s: %w", url, err)
+		}
+	}
+
+	return res, nil
+}
+
+func (c *acmeClient) postOnce(ctx context.Context, url string, payload interface{}) (*acmeResponse, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.sign(url, nonce, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+
+	return c.do(req)
+}
+
+// sign encodes payload in a JWS using the flattened JSON serialization, signed
+// with the account key (see RFC 8555, section 6.2).
+func (c *acmeClient) sign(url string, nonce string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+
+	c.mutex.Lock()
+	kid := c.kid
+	c.mutex.Unlock()
+
+	if len(kid) != 0 {
+		protected["kid"] = kid
+	} else {
+		protected["jwk"] = json.RawMessage(jwkThumbprintInput(&c.key.PublicKey))
+	}
+
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if payload != nil {
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	jws := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{
+		Protected: base64.RawURLEncoding.EncodeToString(header),
+		Payload:   base64.RawURLEncoding.EncodeToString(data),
+	}
+
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	jws.Signature = base64.RawURLEncoding.EncodeToString(sig)
+
+	return json.Marshal(jws)
+}
+
+// nonce returns a nonce saved from a previous response, or gets a new one from
+// the server.
+func (c *acmeClient) nonce(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	if n := len(c.nonces); n != 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mutex.Unlock()
+		return nonce, nil
+	}
+	c.mutex.Unlock()
+
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	nonce := res.Header.Get("Replay-Nonce")
+	if len(nonce) == 0 {
+		return "", errors.New("acme: the server sent no nonce")
+	}
+	return nonce, nil
+}
+
+// do sends req and reads the response, saving the nonce it carries. Error
+// responses are returned as *acmeError values.
+func (c *acmeClient) do(req *http.Request) (*acmeResponse, error) {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if nonce := res.Header.Get("Replay-Nonce"); len(nonce) != 0 {
+		c.mutex.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mutex.Unlock()
+	}
+
+	if res.StatusCode >= 400 {
+		e := &acmeError{Status: res.StatusCode}
+		if json.Unmarshal(body, e) != nil || len(e.Detail) == 0 {
+			e.Detail = res.Status
+		}
+		return nil, e
+	}
+
+	return &acmeResponse{Response: res, body: body}, nil
+}
+
+// jwkThumbprintInput returns the JSON Web Key of pub, with only its required
+// members in lexicographic order, as expected to compute its thumbprint (see
+// RFC 7638).
+func jwkThumbprintInput(pub *ecdsa.PublicKey) string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(x),
+		base64.RawURLEncoding.EncodeToString(y),
+	)
+}
+
+// tlsALPN01Certificate returns the self-signed certificate presented to ACME
+// servers validating a TLS-ALPN-01 challenge for host (see RFC 8737).
+func tlsALPN01Certificate(host string, keyAuth string) (*tls.Certificate, error) {
+	sum := sha256.Sum256([]byte(keyAuth))
+
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeACMEIdentifier, Critical: true, Value: ext},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// newCSR generates a private key and the certificate signing request of host.
+func newCSR(host string) (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: []string{host},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, csr, nil
+}
+
+// retryAfter returns the delay that the Retry-After header of res asks for, or
+// the default polling interval.
+func retryAfter(res *acmeResponse) time.Duration {
+	if s, err := strconv.Atoi(strings.TrimSpace(res.Header.Get("Retry-After"))); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	return acmePollInterval
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,221 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// DefaultHandshakeTimeout is the default handshake timeout of an ALPNListener.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// ALPNListener terminates TLS on the connections accepted by a listener, and
+// dispatches them to multiple sub-listeners based on the application protocol
+// negotiated during the handshake (ALPN). This allows a single port to serve
+// HTTP/2, HTTP/1.1, and custom protocols, each sub-listener being passed to
+// the server of its protocol.
+//
+// The sub-listeners return *tls.Conn values on which the handshake was
+// completed, so they can be used with http.Server, which looks at the
+// negotiated protocol to decide whether to serve HTTP/2.
+//
+// Sub-listeners are created by calling Listen, then the program must call
+// Serve to start accepting connections.
+type ALPNListener struct {
+	// HandshakeTimeout is the maximum amount of time allowed to the clients to
+	// complete the TLS handshake. If zero, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// Events, if not nil, receives an EventTLSHandshake event for each
+	// handshake, with the server name and negotiated protocol as metadata.
+	Events *netx.EventBus
+
+	lstn   net.Listener
+	mutex  sync.Mutex
+	base   *tls.Config
+	config *tls.Config
+	subs   map[string]*alpnSubListener
+	protos []string // in registration order
+	once   sync.Once
+	done   chan struct{}
+}
+
+// NewALPNListener returns a new ALPNListener which accepts connections from
+// lstn, and terminates TLS with config.
+//
+// The config is cloned, the protocols of the sub-listeners are appended to
+// its NextProtos field in the order that the sub-listeners are created. Since
+// servers select the first protocol of their list that the client supports,
+// the protocols already present in NextProtos take precedence.
+func NewALPNListener(lstn net.Listener, config *tls.Config) *ALPNListener {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	return &ALPNListener{
+		lstn:   lstn,
+		base:   config,
+		config: config,
+		subs:   make(map[string]*alpnSubListener),
+		done:   make(chan struct{}),
+	}
+}
+
+// Listen returns a sub-listener which receives the connections that negotiated
+// one of the given protocols, for example "h2" and "http/1.1" for a HTTP
+// server. The empty protocol receives the connections of clients that didn't
+// use ALPN.
+//
+// The routing happens after the handshake, so the certificate presented to
+// the client does not depend on the sub-listener. TLS-ALPN-01 challenges are
+// answered by the GetCertificate function of the config instead, for example
+// Autocert.GetCertificate.
+//
+// Connections that negotiated a protocol which has no sub-listener are closed
+// after the handshake. Registering a protocol twice replaces the previous
+// sub-listener.
+func (m *ALPNListener) Listen(protos ...string) net.Listener {
+	sub := &alpnSubListener{
+		mux:   m,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, proto := range protos {
+		if _, exists := m.subs[proto]; !exists && len(proto) != 0 {
+			m.protos = append(m.protos, proto)
+		}
+		m.subs[proto] = sub
+	}
+
+	config := m.base.Clone()
+
+	for _, proto := range m.protos {
+		if !containsProtocol(config.NextProtos, proto) {
+			config.NextProtos = append(config.NextProtos, proto)
+		}
+	}
+
+	m.config = config
+	return sub
+}
+
+// Serve accepts connections on the base listener and dispatches them to the
+// sub-listeners, it returns nil when the base listener is closed, or the error
+// that prevented it from accepting connections. See netx.AcceptLoop for
+// details.
+//
+// When Serve returns, the sub-listeners return errors from their Accept
+// method.
+func (m *ALPNListener) Serve() error {
+	defer m.Close()
+	return netx.AcceptLoop(m.lstn, func(conn net.Conn) { go m.serve(conn) })
+}
+
+// Close closes the base listener and all the sub-listeners.
+func (m *ALPNListener) Close() (err error) {
+	m.once.Do(func() {
+		close(m.done)
+		err = m.lstn.Close()
+	})
+	return
+}
+
+// Addr returns the address of the base listener.
+func (m *ALPNListener) Addr() net.Addr {
+	return m.lstn.Addr()
+}
+
+func (m *ALPNListener) serve(conn net.Conn) {
+	timeout := m.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	m.mutex.Lock()
+	config := m.config
+	m.mutex.Unlock()
+
+	tlsConn := tls.Server(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+
+	err := tlsConn.Handshake()
+
+	if m.Events != nil {
+		state := tlsConn.ConnectionState()
+		m.Events.Publish(netx.Event{
+			Type:       netx.EventTLSHandshake,
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+			Err:        err,
+			Meta: map[string]string{
+				"server_name": state.ServerName,
+				"protocol":    state.NegotiatedProtocol,
+			},
+		})
+	}
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	tlsConn.SetDeadline(time.Time{})
+
+	m.mutex.Lock()
+	sub := m.subs[tlsConn.ConnectionState().NegotiatedProtocol]
+	m.mutex.Unlock()
+
+	if sub == nil || !sub.push(tlsConn) {
+		tlsConn.Close()
+	}
+}
+
+type alpnSubListener struct {
+	mux   *ALPNListener
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *alpnSubListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+	case <-l.mux.done:
+	}
+	return false
+}
+
+func (l *alpnSubListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+	case <-l.mux.done:
+	}
+	return nil, &net.OpError{
+		Op:   "accept",
+		Net:  l.Addr().Network(),
+		Addr: l.Addr(),
+		Err:  io.ErrClosedPipe,
+	}
+}
+
+func (l *alpnSubListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *alpnSubListener) Addr() net.Addr {
+	return l.mux.Addr()
+}
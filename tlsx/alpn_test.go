@@ -0,0 +1,118 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestALPNListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := NewALPNListener(lstn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer mux.Close()
+
+	httpLstn := mux.Listen("http/1.1")
+	echoLstn := mux.Listen("echo/1", "")
+
+	go mux.Serve()
+
+	go (&http.Server{
+		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			io.WriteString(res, "Hello World!")
+		}),
+	}).Serve(httpLstn)
+
+	go func() {
+		for {
+			conn, err := echoLstn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, conn.(*tls.Conn).ConnectionState().NegotiatedProtocol+":")
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	t.Run("http", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}},
+		}}
+		defer client.CloseIdleConnections()
+
+		res, err := client.Get("https://" + lstn.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if string(b) != "Hello World!" || res.TLS.NegotiatedProtocol != "http/1.1" {
+			t.Error("bad response:", string(b), res.TLS.NegotiatedProtocol)
+		}
+	})
+
+	for _, test := range []struct {
+		name   string
+		protos []string
+		output string
+	}{
+		{"custom", []string{"echo/1"}, "echo/1:Hello"},
+		{"no-alpn", nil, ":Hello"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", lstn.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         test.protos,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			io.WriteString(conn, "Hello")
+			conn.CloseWrite()
+
+			if b, err := ioutil.ReadAll(conn); err != nil || string(b) != test.output {
+				t.Error("bad output:", string(b), err)
+			}
+		})
+	}
+
+	t.Run("unknown-protocol", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", lstn.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"other/1"},
+		})
+		if err == nil {
+			conn.Close()
+			t.Error("expected the handshake to fail")
+		}
+	})
+}
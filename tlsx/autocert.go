@@ -0,0 +1,537 @@
+package tlsx
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACMETLS1Protocol is the ALPN protocol used by ACME servers to validate the
+// TLS-ALPN-01 challenges (see RFC 8737).
+const ACMETLS1Protocol = "acme-tls/1"
+
+const (
+	// LetsEncryptURL is the URL of the ACME directory of Let's Encrypt.
+	LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// DefaultRenewBefore is the default amount of time before their expiration
+	// that an Autocert renews certificates.
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// DefaultAutocertTimeout is the default maximum amount of time that an
+	// Autocert spends obtaining a certificate.
+	DefaultAutocertTimeout = 2 * time.Minute
+
+	// autocertRetryInterval is the minimum amount of time between two attempts
+	// to obtain the certificate of a host, which prevents the handshakes of
+	// clients from flooding the certificate authority after a failure.
+	autocertRetryInterval = 1 * time.Minute
+)
+
+// Challenge types supported by Autocert.
+const (
+	TLSALPN01 = "tls-alpn-01"
+	HTTP01    = "http-01"
+)
+
+// CertManager is an interface implemented by types that obtain certificates on
+// demand, like Autocert, or the autocert.Manager type of the
+// golang.org/x/crypto/acme/autocert package.
+//
+// Managers supporting the TLS-ALPN-01 challenge are expected to return the
+// challenge certificate when the ClientHello only advertises the acme-tls/1
+// protocol.
+type CertManager interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// Autocert obtains certificates from an ACME certificate authority (RFC 8555),
+// like Let's Encrypt, for the server names that clients connect to, and renews
+// them before they expire.
+//
+// Certificates are obtained by GetCertificate the first time a client connects
+// to one of the Hosts, and renewed in the background when a client connects
+// less than RenewBefore before they expire. The domain names are validated
+// with the TLS-ALPN-01 challenge on the TLS listeners using the Autocert (see
+// AutocertListener), or with the HTTP-01 challenge by serving the
+// KeyAuthorization method with httpx.ACMEHandler on port 80.
+type Autocert struct {
+	// DirectoryURL is the URL of the ACME directory of the certificate
+	// authority. If empty, LetsEncryptURL is used.
+	DirectoryURL string
+
+	// Email is the contact address registered with the ACME account.
+	Email string
+
+	// AcceptTOS must be set to true to agree to the terms of service of the
+	// certificate authority, which is required to create the ACME account.
+	// Obtaining certificates fails when it is false.
+	AcceptTOS bool
+
+	// Hosts is the list of server names that certificates are obtained for,
+	// the TLS handshakes of clients requesting other names fail.
+	Hosts []string
+
+	// Challenge is the type of challenge used to validate the domain names,
+	// either TLSALPN01 or HTTP01. If empty, TLSALPN01 is used.
+	Challenge string
+
+	// Dir, if not empty, is the directory where the account key and the
+	// certificates are cached, so they are reused after the program restarts.
+	// The certificates are saved in pairs of <host>.crt and <host>.key files,
+	// which CertDir can load.
+	Dir string
+
+	// RenewBefore is the amount of time before their expiration that the
+	// certificates are renewed. If zero, DefaultRenewBefore is used.
+	RenewBefore time.Duration
+
+	// Timeout is the maximum amount of time spent obtaining a certificate. If
+	// zero, DefaultAutocertTimeout is used.
+	Timeout time.Duration
+
+	// Client is the HTTP client used to send requests to the ACME server.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// ErrorLog is used to report errors that occur when obtaining certificates.
+	// If nil, the default logger is used.
+	ErrorLog *log.Logger
+
+	once     sync.Once
+	acme     *acmeClient
+	err      error
+	mutex    sync.Mutex
+	certs    map[string]*tls.Certificate // host => certificate
+	calls    map[string]*autocertCall    // host => certificate being obtained
+	failures map[string]*autocertCall    // host => last failed attempt
+	tokens   map[string]string           // HTTP-01 token => key authorization
+	alpn     map[string]*tls.Certificate // host => TLS-ALPN-01 certificate
+}
+
+// autocertCall represents a certificate being obtained, which other callers
+// wait for.
+type autocertCall struct {
+	done chan struct{}
+	time time.Time
+	cert *tls.Certificate
+	err  error
+}
+
+// GetCertificate satisfies the signature of the tls.Config.GetCertificate
+// field, returning the certificate of the server name of the client, or the
+// TLS-ALPN-01 challenge certificate to the ACME servers validating it.
+func (a *Autocert) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	if len(host) == 0 {
+		return nil, errors.New("autocert: missing server name")
+	}
+
+	if !a.allowed(host) {
+		return nil, fmt.Errorf("autocert: server name not allowed: %q", host)
+	}
+
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == ACMETLS1Protocol {
+		a.mutex.Lock()
+		cert := a.alpn[host]
+		a.mutex.Unlock()
+
+		if cert == nil {
+			return nil, fmt.Errorf("autocert: no TLS-ALPN-01 challenge for %q", host)
+		}
+		return cert, nil
+	}
+
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	cert := a.certs[host]
+	a.mutex.Unlock()
+
+	if cert == nil {
+		ctx := context.Background()
+		if hello.Context() != nil {
+			ctx = hello.Context()
+		}
+		return a.obtain(ctx, host)
+	}
+
+	if time.Until(cert.Leaf.NotAfter) < a.renewBefore() {
+		// The current certificate is served until the renewal completes.
+		go a.obtain(context.Background(), host)
+	}
+
+	return cert, nil
+}
+
+// KeyAuthorization returns the response to the HTTP-01 challenge of token, it
+// is intended to be used as the KeyAuthorization function of an
+// httpx.ACMEHandler.
+func (a *Autocert) KeyAuthorization(token string) (string, bool) {
+	a.mutex.Lock()
+	keyAuth, ok := a.tokens[token]
+	a.mutex.Unlock()
+	return keyAuth, ok
+}
+
+// obtain gets a new certificate for host, concurrent calls for the same host
+// wait for the same certificate.
+func (a *Autocert) obtain(ctx context.Context, host string) (*tls.Certificate, error) {
+	a.mutex.Lock()
+
+	if c := a.calls[host]; c != nil {
+		a.mutex.Unlock()
+
+		select {
+		case <-c.done:
+			return c.cert, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if f := a.failures[host]; f != nil && time.Since(f.time) < autocertRetryInterval {
+		a.mutex.Unlock()
+		return nil, f.err
+	}
+
+	c := &autocertCall{done: make(chan struct{}), time: time.Now()}
+	a.calls[host] = c
+	a.mutex.Unlock()
+
+	// The certificate is obtained in the background so the clients giving up
+	// do not abort it, and the next clients don't have to start over.
+	go func() {
+		timeout := a.Timeout
+		if timeout == 0 {
+			timeout = DefaultAutocertTimeout
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		c.cert, c.err = a.issue(ctx, host)
+
+		a.mutex.Lock()
+		if c.err == nil {
+			a.certs[host] = c.cert
+			delete(a.failures, host)
+		} else {
+			a.failures[host] = c
+		}
+		delete(a.calls, host)
+		a.mutex.Unlock()
+
+		if c.err != nil {
+			a.logf("autocert: obtaining the certificate of %s: %v", host, c.err)
+		}
+
+		close(c.done)
+	}()
+
+	select {
+	case <-c.done:
+		return c.cert, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// issue runs the ACME protocol to get a certificate for host, and saves it to
+// the cache directory.
+func (a *Autocert) issue(ctx context.Context, host string) (*tls.Certificate, error) {
+	if err := a.acme.register(ctx); err != nil {
+		return nil, err
+	}
+
+	orderURL, order, err := a.acme.authorize(ctx, host, a.challenge(), func(ch acmeChallenge) (func(), error) {
+		return a.prepare(host, ch)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key, csr, err := newCSR(host)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := a.acme.finalize(ctx, orderURL, order, csr)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{Certificate: chain, PrivateKey: key}
+
+	if cert.Leaf, err = x509.ParseCertificate(chain[0]); err != nil {
+		return nil, err
+	}
+
+	if len(a.Dir) != 0 {
+		if err := a.save(host, cert); err != nil {
+			a.logf("autocert: saving the certificate of %s: %v", host, err)
+		}
+	}
+
+	return cert, nil
+}
+
+// prepare provisions the response to the challenge ch for host, it returns a
+// function which removes it.
+func (a *Autocert) prepare(host string, ch acmeChallenge) (func(), error) {
+	keyAuth := a.acme.keyAuthorization(ch.Token)
+
+	switch ch.Type {
+	case HTTP01:
+		a.mutex.Lock()
+		a.tokens[ch.Token] = keyAuth
+		a.mutex.Unlock()
+
+		return func() {
+			a.mutex.Lock()
+			delete(a.tokens, ch.Token)
+			a.mutex.Unlock()
+		}, nil
+
+	default:
+		cert, err := tlsALPN01Certificate(host, keyAuth)
+		if err != nil {
+			return nil, err
+		}
+
+		a.mutex.Lock()
+		a.alpn[host] = cert
+		a.mutex.Unlock()
+
+		return func() {
+			a.mutex.Lock()
+			if a.alpn[host] == cert {
+				delete(a.alpn, host)
+			}
+			a.mutex.Unlock()
+		}, nil
+	}
+}
+
+func (a *Autocert) init() error {
+	a.once.Do(func() {
+		a.certs = make(map[string]*tls.Certificate)
+		a.calls = make(map[string]*autocertCall)
+		a.failures = make(map[string]*autocertCall)
+
+		a.mutex.Lock()
+		if a.tokens == nil {
+			a.tokens = make(map[string]string)
+		}
+		if a.alpn == nil {
+			a.alpn = make(map[string]*tls.Certificate)
+		}
+		a.mutex.Unlock()
+
+		switch a.challenge() {
+		case TLSALPN01, HTTP01:
+		default:
+			a.err = fmt.Errorf("autocert: unsupported challenge: %q", a.Challenge)
+			return
+		}
+
+		var key *ecdsa.PrivateKey
+		if key, a.err = a.accountKey(); a.err != nil {
+			return
+		}
+
+		client := a.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		directoryURL := a.DirectoryURL
+		if len(directoryURL) == 0 {
+			directoryURL = LetsEncryptURL
+		}
+
+		var contact []string
+		if len(a.Email) != 0 {
+			contact = []string{"mailto:" + a.Email}
+		}
+
+		a.acme = &acmeClient{directoryURL: directoryURL, client: client, key: key, contact: contact, acceptTOS: a.AcceptTOS}
+
+		for _, host := range a.Hosts {
+			host = strings.ToLower(host)
+			if cert, err := a.load(host); err == nil {
+				a.certs[host] = cert
+			}
+		}
+	})
+	return a.err
+}
+
+// accountKey loads the key of the ACME account from the cache directory, or
+// generates a new one.
+func (a *Autocert) accountKey() (*ecdsa.PrivateKey, error) {
+	file := filepath.Join(a.Dir, "acme_account.key")
+
+	if len(a.Dir) != 0 {
+		if b, err := ioutil.ReadFile(file); err == nil {
+			block, _ := pem.Decode(b)
+			if block == nil {
+				return nil, fmt.Errorf("autocert: %s: no PEM data found", file)
+			}
+			return x509.ParseECPrivateKey(block.Bytes)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(a.Dir) != 0 {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// load loads the certificate of host from the cache directory.
+func (a *Autocert) load(host string) (*tls.Certificate, error) {
+	if len(a.Dir) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	base := filepath.Join(a.Dir, host)
+	cert, err := tls.LoadX509KeyPair(base+".crt", base+".key")
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// save writes the certificate of host to the cache directory.
+func (a *Autocert) save(host string, cert *tls.Certificate) error {
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey.(crypto.Signer))
+	if err != nil {
+		return err
+	}
+
+	var chain []byte
+	for _, c := range cert.Certificate {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+
+	base := filepath.Join(a.Dir, host)
+
+	// The key is written first, CertDir ignores pairs whose files don't
+	// match until both were written.
+	if err := writeFile(base+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		return err
+	}
+	return writeFile(base+".crt", chain)
+}
+
+func (a *Autocert) allowed(host string) bool {
+	for _, h := range a.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Autocert) challenge() string {
+	if len(a.Challenge) == 0 {
+		return TLSALPN01
+	}
+	return a.Challenge
+}
+
+func (a *Autocert) renewBefore() time.Duration {
+	if a.RenewBefore == 0 {
+		return DefaultRenewBefore
+	}
+	return a.RenewBefore
+}
+
+func (a *Autocert) logf(format string, args ...interface{}) {
+	if a.ErrorLog != nil {
+		a.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// writeFile atomically replaces the content of file with b, so readers never
+// see partially written files.
+func writeFile(file string, b []byte) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// AutocertListener wraps lstn to accept TLS connections, using m to get the
+// certificates presented to the clients, m is usually an *Autocert.
+//
+// The config may be nil, otherwise it is cloned and its GetCertificate field
+// is set to use m. The acme-tls/1 protocol is added to the list of supported
+// protocols so the TLS-ALPN-01 challenges can be served on the listener, the
+// connections of ACME servers negotiate this protocol, which http.Server
+// closes after the handshake. The HTTP-01 challenges are served by
+// httpx.ACMEHandler.
+func AutocertListener(lstn net.Listener, m CertManager, config *tls.Config) net.Listener {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	config.GetCertificate = m.GetCertificate
+
+	if !containsProtocol(config.NextProtos, ACMETLS1Protocol) {
+		config.NextProtos = append(config.NextProtos, ACMETLS1Protocol)
+	}
+
+	return tls.NewListener(lstn, config)
+}
+
+func containsProtocol(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
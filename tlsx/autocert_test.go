@@ -0,0 +1,651 @@
+package tlsx
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutocert(t *testing.T) {
+	for _, challenge := range []string{TLSALPN01, HTTP01} {
+		t.Run(challenge, func(t *testing.T) {
+			ca := newTestACMEServer(t, 90*24*time.Hour)
+			defer ca.Close()
+
+			a := &Autocert{
+				DirectoryURL: ca.URL + "/directory",
+				AcceptTOS:    true,
+				Email:        "admin@example.com",
+				Hosts:        []string{"example.com"},
+				Challenge:    challenge,
+			}
+
+			lstn := ca.serve(t, a)
+			defer lstn.Close()
+
+			cert := dialTestAutocert(t, lstn, "example.com")
+
+			if cert.Issuer.CommonName != "Test ACME CA" || cert.Subject.CommonName != "example.com" {
+				t.Errorf("bad certificate: %s issued by %s", cert.Subject, cert.Issuer)
+			}
+
+			if ca.contact != "mailto:admin@example.com" {
+				t.Errorf("bad account contact: %q", ca.contact)
+			}
+
+			if validated := ca.validated(); len(validated) != 1 || validated[0] != challenge {
+				t.Error("bad validated challenges:", validated)
+			}
+
+			// The certificate is reused for the next connections.
+			dialTestAutocert(t, lstn, "example.com")
+
+			if n := ca.orderCount(); n != 1 {
+				t.Error("bad number of orders:", n)
+			}
+		})
+	}
+}
+
+func TestAutocertHostNotAllowed(t *testing.T) {
+	ca := newTestACMEServer(t, time.Hour)
+	defer ca.Close()
+
+	a := &Autocert{
+		DirectoryURL: ca.URL + "/directory",
+		AcceptTOS:    true,
+		Hosts:        []string{"example.com"},
+	}
+
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org"}); err == nil {
+		t.Error("a certificate was returned for a server name that is not allowed")
+	}
+
+	if n := ca.orderCount(); n != 0 {
+		t.Error("bad number of orders:", n)
+	}
+}
+
+func TestAutocertTermsOfService(t *testing.T) {
+	ca := newTestACMEServer(t, time.Hour)
+	defer ca.Close()
+
+	a := &Autocert{
+		DirectoryURL: ca.URL + "/directory",
+		Hosts:        []string{"example.com"},
+	}
+
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Error("a certificate was obtained without accepting the terms of service")
+	}
+
+	if n := ca.accountCount(); n != 0 {
+		t.Error("bad number of accounts:", n)
+	}
+}
+
+func TestAutocertDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestACMEServer(t, 90*24*time.Hour)
+	defer ca.Close()
+
+	newAutocert := func() *Autocert {
+		return &Autocert{
+			DirectoryURL: ca.URL + "/directory",
+			AcceptTOS:    true,
+			Hosts:        []string{"example.com"},
+			Dir:          dir,
+		}
+	}
+
+	lstn := ca.serve(t, newAutocert())
+	first := dialTestAutocert(t, lstn, "example.com")
+	lstn.Close()
+
+	for _, name := range []string{"acme_account.key", "example.com.crt", "example.com.key"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A new Autocert using the same directory loads the certificate instead
+	// of ordering a new one.
+	lstn = ca.serve(t, newAutocert())
+	defer lstn.Close()
+
+	if cert := dialTestAutocert(t, lstn, "example.com"); cert.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Error("the certificate was not loaded from the directory")
+	}
+
+	if n := ca.orderCount(); n != 1 {
+		t.Error("bad number of orders:", n)
+	}
+}
+
+func TestAutocertRenew(t *testing.T) {
+	ca := newTestACMEServer(t, time.Hour)
+	defer ca.Close()
+
+	a := &Autocert{
+		DirectoryURL: ca.URL + "/directory",
+		AcceptTOS:    true,
+		Hosts:        []string{"example.com"},
+		RenewBefore:  2 * time.Hour,
+	}
+
+	lstn := ca.serve(t, a)
+	defer lstn.Close()
+
+	first := dialTestAutocert(t, lstn, "example.com")
+
+	// The certificate expires before RenewBefore, it keeps being served while
+	// it is renewed in the background.
+	if cert := dialTestAutocert(t, lstn, "example.com"); cert.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Error("the certificate was replaced before being renewed")
+	}
+
+	for i := 0; ca.orderCount() != 2; i++ {
+		if i == 100 {
+			t.Fatal("the certificate was not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatal("the renewed certificate is not served")
+		}
+		if cert := dialTestAutocert(t, lstn, "example.com"); cert.SerialNumber.Cmp(first.SerialNumber) != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAutocertListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "example.com")
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := certManagerFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &cert, nil
+	})
+
+	lstn = AutocertListener(lstn, m, &tls.Config{NextProtos: []string{"h2"}})
+	defer lstn.Close()
+
+	go func() {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", lstn.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{ACMETLS1Protocol},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != ACMETLS1Protocol {
+		t.Errorf("bad negotiated protocol: %q", proto)
+	}
+}
+
+type certManagerFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+func (f certManagerFunc) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f(hello)
+}
+
+// dialTestAutocert connects to lstn with the server name host and returns the
+// certificate presented by the server.
+func dialTestAutocert(t *testing.T, lstn net.Listener, host string) *x509.Certificate {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", lstn.Addr().String(), &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+// testACMEServer is a minimal ACME server which validates the challenges by
+// connecting to the listeners of the test.
+type testACMEServer struct {
+	*httptest.Server
+	t        *testing.T
+	lifetime time.Duration
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+
+	mutex      sync.Mutex
+	nonce      int
+	nonces     map[string]bool
+	account    *ecdsa.PublicKey
+	contact    string
+	accounts   int
+	orders     int
+	authzValid bool
+	challenges []string
+	token      string
+	tlsAddr    string
+	httpAddr   string
+	serial     int64
+	chain      []byte
+}
+
+func newTestACMEServer(t *testing.T, lifetime time.Duration) *testACMEServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME CA"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, _ := x509.ParseCertificate(der)
+
+	s := &testACMEServer{
+		t:        t,
+		lifetime: lifetime,
+		caKey:    key,
+		caCert:   caCert,
+		nonces:   make(map[string]bool),
+		serial:   1,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// serve starts a TLS listener and a HTTP server serving the challenges of a,
+// which the ACME server uses to validate them.
+func (s *testACMEServer) serve(t *testing.T, a *Autocert) net.Listener {
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn = AutocertListener(lstn, a, nil)
+
+	go func() {
+		for {
+			conn, err := lstn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	// The HTTP-01 challenges are served the way httpx.ACMEHandler does.
+	challenges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		keyAuth, ok := a.KeyAuthorization(strings.TrimPrefix(req.URL.Path, "/.well-known/acme-challenge/"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(keyAuth))
+	}))
+	t.Cleanup(challenges.Close)
+
+	s.mutex.Lock()
+	s.tlsAddr = lstn.Addr().String()
+	s.httpAddr = challenges.Listener.Addr().String()
+	s.mutex.Unlock()
+	return lstn
+}
+
+func (s *testACMEServer) accountCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.accounts
+}
+
+func (s *testACMEServer) orderCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.orders
+}
+
+func (s *testACMEServer) validated() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.challenges...)
+}
+
+func (s *testACMEServer) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	s.nonce++
+	nonce := fmt.Sprint("nonce-", s.nonce)
+	s.nonces[nonce] = true
+	s.mutex.Unlock()
+
+	w.Header().Set("Replay-Nonce", nonce)
+
+	switch req.URL.Path {
+	case "/directory":
+		writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+		})
+		return
+	case "/nonce":
+		return
+	}
+
+	payload, err := s.verify(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": err.Error()})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch req.URL.Path {
+	case "/account":
+		var account struct {
+			TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+			Contact              []string `json:"contact"`
+		}
+		json.Unmarshal(payload, &account)
+		if !account.TermsOfServiceAgreed {
+			writeJSON(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:userActionRequired", "detail": "the terms of service must be agreed to"})
+			return
+		}
+		s.accounts++
+		s.contact = strings.Join(account.Contact, ",")
+		w.Header().Set("Location", s.URL+"/account/1")
+		writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+
+	case "/order":
+		s.orders++
+		s.authzValid = false
+		s.token = fmt.Sprint("token-", s.orders)
+		w.Header().Set("Location", s.URL+"/order/1")
+		writeJSON(w, http.StatusCreated, s.order("pending"))
+
+	case "/authz/1":
+		status := "pending"
+		if s.authzValid {
+			status = "valid"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": "example.com"},
+			"challenges": []map[string]string{
+				{"type": HTTP01, "url": s.URL + "/challenge/http", "token": s.token, "status": status},
+				{"type": TLSALPN01, "url": s.URL + "/challenge/alpn", "token": s.token, "status": status},
+			},
+		})
+
+	case "/challenge/http", "/challenge/alpn":
+		keyAuth := s.token + "." + thumbprint(s.account)
+		typ := HTTP01
+		err := s.validateHTTP01(keyAuth)
+		if req.URL.Path == "/challenge/alpn" {
+			typ, err = TLSALPN01, s.validateTLSALPN01(keyAuth)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:unauthorized", "detail": err.Error()})
+			return
+		}
+		s.authzValid = true
+		s.challenges = append(s.challenges, typ)
+		writeJSON(w, http.StatusOK, map[string]string{"type": typ, "status": "valid"})
+
+	case "/finalize/1":
+		var finalize struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &finalize)
+		if err := s.issue(finalize.CSR); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:badCSR", "detail": err.Error()})
+			return
+		}
+		w.Header().Set("Retry-After", "0")
+		writeJSON(w, http.StatusOK, s.order("processing"))
+
+	case "/order/1":
+		writeJSON(w, http.StatusOK, s.order("valid"))
+
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.chain)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *testACMEServer) order(status string) map[string]interface{} {
+	return map[string]interface{}{
+		"status":         status,
+		"authorizations": []string{s.URL + "/authz/1"},
+		"finalize":       s.URL + "/finalize/1",
+		"certificate":    s.URL + "/cert/1",
+	}
+}
+
+// verify checks the JWS signature and nonce of req, and returns its payload.
+func (s *testACMEServer) verify(req *http.Request) ([]byte, error) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+
+	var protected struct {
+		Alg   string `json:"alg"`
+		Nonce string `json:"nonce"`
+		URL   string `json:"url"`
+		KID   string `json:"kid"`
+		JWK   *struct {
+			X string `json:"x"`
+			Y string `json:"y"`
+		} `json:"jwk"`
+	}
+
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.nonces[protected.Nonce] {
+		return nil, fmt.Errorf("bad nonce: %q", protected.Nonce)
+	}
+	delete(s.nonces, protected.Nonce)
+
+	if protected.Alg != "ES256" || protected.URL != s.URL+req.URL.Path {
+		return nil, fmt.Errorf("bad protected header: %s", header)
+	}
+
+	pub := s.account
+	switch {
+	case protected.JWK != nil && req.URL.Path == "/account":
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK.Y)
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		s.account = pub
+	case protected.KID != s.URL+"/account/1" || pub == nil:
+		return nil, fmt.Errorf("bad key identifier: %q", protected.KID)
+	}
+
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("bad signature")
+	}
+
+	return payload, nil
+}
+
+func (s *testACMEServer) validateHTTP01(keyAuth string) error {
+	req, _ := http.NewRequest("GET", "http://"+s.httpAddr+"/.well-known/acme-challenge/"+s.token, nil)
+	req.Host = "example.com"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+	if string(b) != keyAuth {
+		return fmt.Errorf("bad key authorization: %q", b)
+	}
+	return nil
+}
+
+func (s *testACMEServer) validateTLSALPN01(keyAuth string) error {
+	conn, err := tls.Dial("tcp", s.tlsAddr, &tls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ACMETLS1Protocol {
+		return fmt.Errorf("bad negotiated protocol: %q", state.NegotiatedProtocol)
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range state.PeerCertificates[0].Extensions {
+		if ext.Id.Equal(idPeACMEIdentifier) {
+			var value []byte
+			if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || !ext.Critical || !bytes.Equal(value, sum[:]) {
+				return fmt.Errorf("bad acmeIdentifier extension")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("missing acmeIdentifier extension")
+}
+
+func (s *testACMEServer) issue(csr string) error {
+	der, err := base64.RawURLEncoding.DecodeString(csr)
+	if err != nil {
+		return err
+	}
+
+	req, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+
+	if err := req.CheckSignature(); err != nil || len(req.DNSNames) != 1 || req.DNSNames[0] != "example.com" {
+		return fmt.Errorf("bad certificate request: %v %v", req.DNSNames, err)
+	}
+
+	s.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(s.serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     req.DNSNames,
+		NotBefore:    time.Now().Add(-1 * time.Minute),
+		NotAfter:     time.Now().Add(s.lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, s.caCert, req.PublicKey, s.caKey)
+	if err != nil {
+		return err
+	}
+
+	s.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+	return nil
+}
+
+func thumbprint(pub *ecdsa.PublicKey) string {
+	sum := sha256.Sum256([]byte(jwkThumbprintInput(pub)))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
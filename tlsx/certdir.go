@@ -0,0 +1,214 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertDir serves TLS certificates loaded from a directory, selecting the one
+// to use for each connection based on the server name sent by the client
+// (SNI). This lets a program terminate TLS for many domains, and add or renew
+// certificates without restarting.
+//
+// Each certificate is made of a pair of PEM encoded files named <name>.crt and
+// <name>.key. The certificates are indexed by the DNS names they are valid for
+// (or their common name if they have none), wildcard certificates like
+// *.example.com are used for server names that have no exact match.
+//
+// The directory is scanned again by GetCertificate at most once per reload
+// interval, and the certificates are reloaded when files were added, removed,
+// or modified. Pairs that fail to load are reported to the error log and
+// skipped, the previous version of their certificate keeps being used.
+type CertDir struct {
+	// Dir is the path to the directory holding the certificates.
+	Dir string
+
+	// DefaultName is the server name of the certificate used for clients that
+	// didn't send a server name, or sent one that didn't match a certificate.
+	// If empty, the TLS handshakes of those clients fail.
+	DefaultName string
+
+	// Interval is the minimum amount of time between two scans of the
+	// directory. If zero, DefaultReloadInterval is used.
+	Interval time.Duration
+
+	// ErrorLog is used to report errors that occur when loading certificates.
+	// If nil, the default logger is used.
+	ErrorLog *log.Logger
+
+	mutex   sync.Mutex
+	names   map[string]*tls.Certificate // server name => certificate
+	pairs   map[string]*tls.Certificate // pair name => certificate
+	files   map[string]time.Time        // file name => modification time
+	checked time.Time
+}
+
+// NewCertDir creates a new CertDir, loading the certificates from dir.
+func NewCertDir(dir string) (*CertDir, error) {
+	d := &CertDir{Dir: dir}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload scans the directory and loads the certificates that were added or
+// modified since the last scan.
+func (d *CertDir) Reload() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.checked = time.Now()
+	return d.reload()
+}
+
+// GetCertificate satisfies the signature of the tls.Config.GetCertificate
+// field, returning the certificate matching the server name of the client
+// after scanning the directory if the reload interval has expired.
+func (d *CertDir) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	interval := d.Interval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+
+	if now := time.Now(); d.files == nil || now.Sub(d.checked) >= interval {
+		d.checked = now
+
+		if err := d.reload(); err != nil {
+			d.logf("error scanning the certificate directory %s: %v", d.Dir, err)
+		}
+	}
+
+	name := ""
+	if hello != nil {
+		name = hello.ServerName
+	}
+
+	if cert := d.lookup(name); cert != nil {
+		return cert, nil
+	}
+
+	if cert := d.lookup(d.DefaultName); cert != nil {
+		return cert, nil
+	}
+
+	return nil, fmt.Errorf("tls: no certificate found for server name %q", name)
+}
+
+// lookup returns the certificate for name, the mutex must be held when calling
+// the method.
+func (d *CertDir) lookup(name string) *tls.Certificate {
+	if len(name) == 0 {
+		return nil
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if cert := d.names[name]; cert != nil {
+		return cert
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return d.names["*"+name[i:]]
+	}
+
+	return nil
+}
+
+// reload loads the certificate pairs of the directory if the files have
+// changed since they were last loaded, the mutex must be held when calling
+// the method.
+func (d *CertDir) reload() error {
+	infos, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]time.Time, len(infos))
+	changed := d.files == nil
+
+	for _, info := range infos {
+		if ext := filepath.Ext(info.Name()); !info.IsDir() && (ext == ".crt" || ext == ".key") {
+			files[info.Name()] = info.ModTime()
+
+			if t, ok := d.files[info.Name()]; !ok || !t.Equal(info.ModTime()) {
+				changed = true
+			}
+		}
+	}
+
+	if !changed && len(files) == len(d.files) {
+		return nil
+	}
+
+	pairs := make(map[string]*tls.Certificate)
+
+	for file := range files {
+		if filepath.Ext(file) != ".crt" {
+			continue
+		}
+
+		pair := strings.TrimSuffix(file, ".crt")
+		if _, ok := files[pair+".key"]; !ok {
+			continue
+		}
+
+		cert, err := loadCertificate(filepath.Join(d.Dir, pair+".crt"), filepath.Join(d.Dir, pair+".key"))
+		if err != nil {
+			d.logf("error loading the certificate %s from %s: %v", pair, d.Dir, err)
+
+			if cert = d.pairs[pair]; cert == nil {
+				continue
+			}
+		}
+
+		pairs[pair] = cert
+	}
+
+	names := make(map[string]*tls.Certificate)
+
+	for _, cert := range pairs {
+		certNames := cert.Leaf.DNSNames
+		if len(certNames) == 0 {
+			certNames = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range certNames {
+			names[strings.ToLower(name)] = cert
+		}
+	}
+
+	d.names, d.pairs, d.files = names, pairs, files
+	return nil
+}
+
+func (d *CertDir) logf(format string, args ...interface{}) {
+	if d.ErrorLog != nil {
+		d.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func loadCertificate(certFile string, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cert, nil
+}
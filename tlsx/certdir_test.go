@@ -0,0 +1,88 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestCertificate(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "a.example.com")
+	writeTestCertificate(t, filepath.Join(dir, "wildcard.crt"), filepath.Join(dir, "wildcard.key"), "*.example.org")
+
+	d, err := NewCertDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Interval = 1 * time.Millisecond
+	d.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	tests := []struct {
+		serverName string
+		certName   string
+	}{
+		{"a.example.com", "a.example.com"},
+		{"A.Example.Com.", "a.example.com"},
+		{"www.example.org", "*.example.org"},
+		{"example.org", ""},
+		{"b.example.com", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		cert, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: test.serverName})
+
+		switch {
+		case test.certName == "" && err == nil:
+			t.Errorf("%q: expected no certificate but got %s", test.serverName, cert.Leaf.Subject.CommonName)
+		case test.certName != "" && err != nil:
+			t.Errorf("%q: %v", test.serverName, err)
+		case test.certName != "" && cert.Leaf.Subject.CommonName != test.certName:
+			t.Errorf("%q: bad certificate: %s", test.serverName, cert.Leaf.Subject.CommonName)
+		}
+	}
+
+	d.DefaultName = "a.example.com"
+
+	if cert, err := d.GetCertificate(&tls.ClientHelloInfo{}); err != nil || cert.Leaf.Subject.CommonName != "a.example.com" {
+		t.Error("the default certificate was not used:", err)
+	}
+
+	// Certificates added to the directory are picked up without restarting.
+	writeTestCertificate(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "b.example.com")
+	time.Sleep(2 * time.Millisecond)
+
+	if cert, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"}); err != nil || cert.Leaf.Subject.CommonName != "b.example.com" {
+		t.Error("the new certificate was not loaded:", err)
+	}
+
+	// A broken key file must not prevent the current certificate from being
+	// used.
+	future := time.Now().Add(1 * time.Hour)
+	ioutil.WriteFile(filepath.Join(dir, "b.key"), []byte("broken"), 0600)
+	os.Chtimes(filepath.Join(dir, "b.key"), future, future)
+	time.Sleep(2 * time.Millisecond)
+
+	if _, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"}); err != nil {
+		t.Error("the certificate was dropped after a failed reload:", err)
+	}
+
+	// Removing the files removes the certificate.
+	os.Remove(filepath.Join(dir, "b.crt"))
+	os.Remove(filepath.Join(dir, "b.key"))
+	time.Sleep(2 * time.Millisecond)
+
+	if cert, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"}); err != nil || cert.Leaf.Subject.CommonName != "a.example.com" {
+		t.Error("the removed certificate is still used:", err)
+	}
+}
@@ -0,0 +1,468 @@
+package tlsx
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ClientHello holds the fields of a TLS ClientHello message which are used to
+// fingerprint the TLS implementation of clients.
+type ClientHello struct {
+	Version             uint16   // legacy version of the message
+	CipherSuites        []uint16 // in the order sent by the client
+	Extensions          []uint16 // in the order sent by the client
+	Curves              []uint16 // supported groups
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ServerName          string
+	ALPN                []string
+}
+
+var (
+	errNotClientHello = errors.New("tls: the connection did not start with a ClientHello")
+	errBadClientHello = errors.New("tls: malformed ClientHello")
+)
+
+// ParseClientHello parses a TLS record holding a ClientHello message, b must
+// start with the record header.
+//
+// Only messages that fit in a single record are supported, which is always
+// the case in practice since clients send ClientHello messages well below the
+// maximum record size of 16 KB.
+func ParseClientHello(b []byte) (*ClientHello, error) {
+	if len(b) < 5 || b[0] != 22 {
+		return nil, errNotClientHello
+	}
+
+	n := int(binary.BigEndian.Uint16(b[3:]))
+	if n > len(b)-5 {
+		return nil, errBadClientHello
+	}
+
+	r := helloReader(b[5 : 5+n])
+
+	msgType := r.uint8()
+	msg := r.bytes(int(r.uint8())<<16 | int(r.uint16()))
+
+	if msgType != 1 || msg == nil {
+		return nil, errNotClientHello
+	}
+
+	r = helloReader(msg)
+	hello := &ClientHello{Version: r.uint16()}
+
+	r.bytes(32)             // random
+	r.bytes(int(r.uint8())) // session id
+
+	for ciphers := helloReader(r.bytes(int(r.uint16()))); len(ciphers) >= 2; {
+		hello.CipherSuites = append(hello.CipherSuites, ciphers.uint16())
+	}
+
+	if r.bytes(int(r.uint8())) == nil { // compression methods
+		return nil, errBadClientHello
+	}
+
+	for exts := helloReader(r.bytes(int(r.uint16()))); len(exts) != 0; {
+		extType := exts.uint16()
+		data := exts.bytes(int(exts.uint16()))
+		if data == nil {
+			return nil, errBadClientHello
+		}
+		hello.Extensions = append(hello.Extensions, extType)
+		hello.parseExtension(extType, helloReader(data))
+	}
+
+	return hello, nil
+}
+
+func (hello *ClientHello) parseExtension(extType uint16, data helloReader) {
+	switch extType {
+	case 0: // server_name
+		for names := helloReader(data.bytes(int(data.uint16()))); len(names) != 0; {
+			nameType := names.uint8()
+			name := names.bytes(int(names.uint16()))
+			if nameType == 0 {
+				hello.ServerName = string(name)
+			}
+		}
+
+	case 10: // supported_groups
+		for groups := helloReader(data.bytes(int(data.uint16()))); len(groups) >= 2; {
+			hello.Curves = append(hello.Curves, groups.uint16())
+		}
+
+	case 11: // ec_point_formats
+		hello.PointFormats = append(hello.PointFormats, data.bytes(int(data.uint8()))...)
+
+	case 13: // signature_algorithms
+		for algs := helloReader(data.bytes(int(data.uint16()))); len(algs) >= 2; {
+			hello.SignatureAlgorithms = append(hello.SignatureAlgorithms, algs.uint16())
+		}
+
+	case 16: // application_layer_protocol_negotiation
+		for protos := helloReader(data.bytes(int(data.uint16()))); len(protos) != 0; {
+			if proto := protos.bytes(int(protos.uint8())); len(proto) != 0 {
+				hello.ALPN = append(hello.ALPN, string(proto))
+			}
+		}
+
+	case 43: // supported_versions
+		for versions := helloReader(data.bytes(int(data.uint8()))); len(versions) >= 2; {
+			hello.SupportedVersions = append(hello.SupportedVersions, versions.uint16())
+		}
+	}
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello, which is the MD5 hash of
+// the string returned by JA3String.
+func (hello *ClientHello) JA3() string {
+	sum := md5.Sum([]byte(hello.JA3String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA3String returns the string that the JA3 fingerprint is computed from: the
+// version, cipher suites, extensions, curves, and point formats sent by the
+// client, in decimal, with the GREASE values removed.
+func (hello *ClientHello) JA3String() string {
+	b := make([]byte, 0, 128)
+	b = strconv.AppendUint(b, uint64(hello.Version), 10)
+	b = append(b, ',')
+	b = appendJA3List(b, hello.CipherSuites)
+	b = append(b, ',')
+	b = appendJA3List(b, hello.Extensions)
+	b = append(b, ',')
+	b = appendJA3List(b, hello.Curves)
+	b = append(b, ',')
+
+	for i, f := range hello.PointFormats {
+		if i != 0 {
+			b = append(b, '-')
+		}
+		b = strconv.AppendUint(b, uint64(f), 10)
+	}
+
+	return string(b)
+}
+
+func appendJA3List(b []byte, values []uint16) []byte {
+	n := 0
+
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+		if n != 0 {
+			b = append(b, '-')
+		}
+		b = strconv.AppendUint(b, uint64(v), 10)
+		n++
+	}
+
+	return b
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello, for example
+// "t13d1516h2_8daaf6152771_e5627efa2ab1". The client is assumed to be using
+// TCP, the fingerprints of QUIC clients start with a "q" instead of a "t".
+func (hello *ClientHello) JA4() string {
+	ciphers := withoutGREASE(hello.CipherSuites)
+	exts := withoutGREASE(hello.Extensions)
+
+	sni := 'i'
+	if len(hello.ServerName) != 0 {
+		sni = 'd'
+	}
+
+	alpn := "00"
+	if len(hello.ALPN) != 0 {
+		alpn = ja4ALPN(hello.ALPN[0])
+	}
+
+	// The server name and ALPN extensions are counted in the first part of
+	// the fingerprint but excluded from the hash of the extensions, since
+	// they're already represented.
+	hashed := make([]uint16, 0, len(exts))
+	for _, ext := range exts {
+		if ext != 0 && ext != 16 {
+			hashed = append(hashed, ext)
+		}
+	}
+
+	sortUint16(ciphers)
+	sortUint16(hashed)
+
+	extList := ja4List(hashed)
+	if algs := withoutGREASE(hello.SignatureAlgorithms); len(algs) != 0 {
+		extList += "_" + ja4List(algs)
+	}
+
+	return fmt.Sprintf("t%s%c%02d%02d%s_%s_%s",
+		ja4Version(hello.version()), sni, ja4Count(ciphers), ja4Count(exts), alpn,
+		ja4Hash(len(ciphers), ja4List(ciphers)),
+		ja4Hash(len(hashed), extList),
+	)
+}
+
+// version returns the highest TLS version supported by the client.
+func (hello *ClientHello) version() uint16 {
+	version := uint16(0)
+
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+
+	if version == 0 {
+		version = hello.Version
+	}
+
+	return version
+}
+
+func ja4Version(version uint16) string {
+	switch version {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case tls.VersionSSL30:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+func ja4ALPN(proto string) string {
+	first, last := proto[0], proto[len(proto)-1]
+
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		h := hex.EncodeToString([]byte(proto))
+		return h[:1] + h[len(h)-1:]
+	}
+
+	return string([]byte{first, last})
+}
+
+func ja4Count(values []uint16) int {
+	if len(values) > 99 {
+		return 99
+	}
+	return len(values)
+}
+
+func ja4List(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func ja4Hash(n int, s string) string {
+	if n == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isGREASE returns true if v is one of the values reserved by RFC 8701, which
+// clients send at random to keep servers tolerant to unknown values.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	list := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func sortUint16(values []uint16) {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+}
+
+// helloReader is used to decode the fields of ClientHello messages, reads past
+// the end of the buffer return zero values and empty the reader.
+type helloReader []byte
+
+func (r *helloReader) bytes(n int) []byte {
+	if n > len(*r) {
+		*r = nil
+		return nil
+	}
+	b := (*r)[:n:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *helloReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *helloReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// NewFingerprintListener returns a listener wrapping lstn which accepts
+// FingerprintConn connections.
+//
+// The listener is meant to be placed below the TLS listener, or below a
+// listener routing connections based on their ClientHello, so the message is
+// captured before it is consumed.
+func NewFingerprintListener(lstn net.Listener) net.Listener {
+	return fingerprintListener{lstn}
+}
+
+type fingerprintListener struct {
+	net.Listener
+}
+
+func (l fingerprintListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewFingerprintConn(conn), nil
+}
+
+// FingerprintConn is a connection wrapper which captures the TLS ClientHello
+// received on the connection, to compute the fingerprint of the client.
+//
+// The ClientHello is read by the first call to Read or ClientHello, then
+// returned by the following reads, so the connection can still be handed to a
+// TLS server or proxied to a backend. Connections that don't start with a TLS
+// handshake are left unchanged.
+type FingerprintConn struct {
+	net.Conn
+	once  sync.Once
+	hello *ClientHello
+	err   error
+	buf   []byte // bytes read by the parser, not yet returned by Read
+}
+
+// NewFingerprintConn returns a new FingerprintConn wrapping conn.
+func NewFingerprintConn(conn net.Conn) *FingerprintConn {
+	return &FingerprintConn{Conn: conn}
+}
+
+// BaseConn returns the connection wrapped by c.
+func (c *FingerprintConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+// ClientHello returns the ClientHello sent by the client, reading it from the
+// connection if it wasn't already.
+func (c *FingerprintConn) ClientHello() (*ClientHello, error) {
+	c.once.Do(c.readClientHello)
+	return c.hello, c.err
+}
+
+// Read satisfies the io.Reader interface.
+func (c *FingerprintConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readClientHello)
+
+	if len(c.buf) != 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *FingerprintConn) readClientHello() {
+	header := make([]byte, 5)
+	n, err := io.ReadFull(c.Conn, header)
+	c.buf = header[:n]
+
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	if header[0] != 22 {
+		c.err = errNotClientHello
+		return
+	}
+
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:])))
+	copy(record, header)
+	n, err = io.ReadFull(c.Conn, record[5:])
+	c.buf = record[:5+n]
+
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	c.hello, c.err = ParseClientHello(record)
+}
+
+// ConnContext returns a context carrying the ClientHello of conn, which must
+// be a FingerprintConn or a wrapper of one (like a *tls.Conn created over a
+// listener returned by NewFingerprintListener). If not, ctx is returned.
+//
+// The signature matches the one of the ConnContext field of http.Server. The
+// ClientHello isn't read by the function, so it doesn't block, it's read when
+// ClientHelloFromContext is first called on the returned context.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	for conn != nil {
+		switch c := conn.(type) {
+		case *FingerprintConn:
+			return context.WithValue(ctx, clientHelloContextKey{}, c)
+		case *tls.Conn:
+			conn = c.NetConn()
+		case interface{ BaseConn() net.Conn }:
+			conn = c.BaseConn()
+		default:
+			return ctx
+		}
+	}
+	return ctx
+}
+
+// ClientHelloFromContext returns the ClientHello carried by ctx, or nil if it
+// has none or the connection didn't start with a valid ClientHello.
+func ClientHelloFromContext(ctx context.Context) *ClientHello {
+	c, _ := ctx.Value(clientHelloContextKey{}).(*FingerprintConn)
+	if c == nil {
+		return nil
+	}
+	hello, _ := c.ClientHello()
+	return hello
+}
+
+type clientHelloContextKey struct{}
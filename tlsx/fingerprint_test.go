@@ -0,0 +1,178 @@
+package tlsx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testClientHello builds a TLS record holding a ClientHello with a GREASE
+// cipher suite, and the most common extensions.
+func testClientHello() []byte {
+	u16 := func(v ...uint16) []byte {
+		b := make([]byte, 2*len(v))
+		for i, x := range v {
+			binary.BigEndian.PutUint16(b[2*i:], x)
+		}
+		return b
+	}
+	vec16 := func(b []byte) []byte { return append(u16(uint16(len(b))), b...) }
+	vec8 := func(b []byte) []byte { return append([]byte{byte(len(b))}, b...) }
+	ext := func(t uint16, data []byte) []byte { return append(u16(t), vec16(data)...) }
+
+	var exts []byte
+	exts = append(exts, ext(0, vec16(append([]byte{0}, vec16([]byte("example.com"))...)))...)
+	exts = append(exts, ext(10, vec16(u16(0x001d, 0x0017)))...)
+	exts = append(exts, ext(11, vec8([]byte{0}))...)
+	exts = append(exts, ext(13, vec16(u16(0x0403, 0x0804)))...)
+	exts = append(exts, ext(16, vec16(append(vec8([]byte("h2")), vec8([]byte("http/1.1"))...)))...)
+	exts = append(exts, ext(43, vec8(u16(0x0304, 0x0303)))...)
+
+	var msg []byte
+	msg = append(msg, u16(0x0303)...)
+	msg = append(msg, make([]byte, 32)...)
+	msg = append(msg, vec8(nil)...)
+	msg = append(msg, vec16(u16(0x0a0a, 0x1301, 0xc02f))...)
+	msg = append(msg, vec8([]byte{0})...)
+	msg = append(msg, vec16(exts)...)
+
+	handshake := append([]byte{1, 0}, vec16(msg)...)
+	return append([]byte{22, 3, 1}, vec16(handshake)...)
+}
+
+func TestParseClientHello(t *testing.T) {
+	hello, err := ParseClientHello(testClientHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hello.ServerName != "example.com" {
+		t.Error("bad server name:", hello.ServerName)
+	}
+
+	if len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" || hello.ALPN[1] != "http/1.1" {
+		t.Error("bad ALPN:", hello.ALPN)
+	}
+
+	if s := hello.JA3String(); s != "771,4865-49199,0-10-11-13-16-43,29-23,0" {
+		t.Error("bad JA3 string:", s)
+	}
+
+	if s := hello.JA3(); s != "97737df38853b88c4324af06e211c4a1" {
+		t.Error("bad JA3:", s)
+	}
+
+	if s := hello.JA4(); s != "t13d0206h2_c1929292aa6b_fb71836bce29" {
+		t.Error("bad JA4:", s)
+	}
+}
+
+func TestParseClientHelloInvalid(t *testing.T) {
+	hello := testClientHello()
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"not-handshake", []byte("GET / HTTP/1.1\r\n\r\n")},
+		{"truncated", hello[:len(hello)-10]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseClientHello(test.b); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestFingerprintListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lstn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lstn = tls.NewListener(NewFingerprintListener(lstn), &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer lstn.Close()
+
+	hellos := make(chan *ClientHello, 1)
+
+	go func() {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("Hello World!"))
+		hellos <- ClientHelloFromContext(ConnContext(context.Background(), conn))
+	}()
+
+	conn, err := tls.Dial("tcp", lstn.Addr().String(), &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if b, err := ioutil.ReadAll(conn); err != nil || string(b) != "Hello World!" {
+		t.Fatal("bad response:", string(b), err)
+	}
+
+	hello := <-hellos
+	if hello == nil {
+		t.Fatal("no ClientHello in the connection context")
+	}
+
+	if hello.ServerName != "localhost" {
+		t.Error("bad server name:", hello.ServerName)
+	}
+
+	if ja4 := hello.JA4(); !strings.HasPrefix(ja4, "t13d") || !strings.Contains(ja4, "h2_") {
+		t.Error("bad JA4:", ja4)
+	}
+}
+
+func TestFingerprintConnPassthrough(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	go c1.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	conn := NewFingerprintConn(c2)
+
+	if _, err := conn.ClientHello(); err == nil {
+		t.Error("expected an error for a connection not starting with a ClientHello")
+	}
+
+	b := make([]byte, 18)
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Error("bad data read from the connection:", string(b), err)
+	}
+}
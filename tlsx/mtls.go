@@ -0,0 +1,364 @@
+package tlsx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// LoadCertPool loads a bundle of PEM encoded CA certificates from file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// CAReloader loads a bundle of CA certificates from a PEM encoded file and
+// reloads it when the file changes, which lets programs rotate the authorities
+// they trust without restarting.
+//
+// Changes are detected the same way as CertReloader does, if loading the
+// new bundle fails the previous one keeps being used.
+type CAReloader struct {
+	// File is the path to the CA bundle.
+	File string
+
+	// Interval is the minimum amount of time between two checks for changes
+	// of the file. If zero, DefaultReloadInterval is used.
+	Interval time.Duration
+
+	// ErrorLog is used to report errors that occur when reloading the bundle.
+	// If nil, the default logger is used.
+	ErrorLog *log.Logger
+
+	mutex   sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCAReloader creates a new CAReloader, loading the CA bundle from file.
+func NewCAReloader(file string) (*CAReloader, error) {
+	r := &CAReloader{File: file}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload unconditionally loads the CA bundle from the file.
+func (r *CAReloader) Reload() error {
+	s, err := os.Stat(r.File)
+	if err != nil {
+		return err
+	}
+
+	pool, err := LoadCertPool(r.File)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.pool, r.modTime, r.checked = pool, s.ModTime(), time.Now()
+	r.mutex.Unlock()
+	return nil
+}
+
+// CertPool returns the current pool of CA certificates, after reloading it if
+// the file has changed.
+func (r *CAReloader) CertPool() (*x509.CertPool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+
+	if now := time.Now(); r.pool == nil || now.Sub(r.checked) >= interval {
+		r.checked = now
+
+		if err := r.reload(); err != nil {
+			if r.pool == nil {
+				return nil, err
+			}
+			r.logf("error reloading the CA bundle from %s: %v", r.File, err)
+		}
+	}
+
+	return r.pool, nil
+}
+
+// reload loads the CA bundle if the file was modified since it was last
+// loaded, the mutex must be held when calling the method.
+func (r *CAReloader) reload() error {
+	s, err := os.Stat(r.File)
+	if err != nil {
+		return err
+	}
+
+	if r.pool != nil && s.ModTime().Equal(r.modTime) {
+		return nil
+	}
+
+	pool, err := LoadCertPool(r.File)
+	if err != nil {
+		return err
+	}
+
+	r.pool, r.modTime = pool, s.ModTime()
+	return nil
+}
+
+func (r *CAReloader) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// PeerVerifier verifies the certificates presented by the peers of mutually
+// authenticated TLS connections: the certificate chain must be signed by one
+// of the trusted authorities, and the leaf certificate must carry one of the
+// allowed identities.
+//
+// When no identities are configured, any certificate signed by the trusted
+// authorities is accepted on servers, and clients verify that the certificate
+// is valid for the server name they connected to.
+type PeerVerifier struct {
+	// CA is the bundle of trusted authorities. If nil, the system roots are
+	// used.
+	CA *CAReloader
+
+	// DNSNames is the list of allowed DNS names, matched against the DNS
+	// subject alternative names of certificates (wildcards are supported).
+	DNSNames []string
+
+	// URIs is the list of allowed URIs, matched exactly against the URI
+	// subject alternative names of certificates, for example the SPIFFE ID
+	// "spiffe://example.org/ns/prod/sa/api".
+	URIs []string
+
+	// TrustDomains is the list of SPIFFE trust domains whose identities are
+	// all allowed, for example "example.org".
+	TrustDomains []string
+}
+
+var errNoPeerCertificate = errors.New("tls: the peer did not present a certificate")
+
+// Verify checks the certificate chain presented by a peer, certs[0] being the
+// leaf certificate. The usage is x509.ExtKeyUsageClientAuth to verify clients,
+// and x509.ExtKeyUsageServerAuth to verify servers.
+func (v *PeerVerifier) Verify(certs []*x509.Certificate, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return errNoPeerCertificate
+	}
+
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+
+	if v.CA != nil {
+		pool, err := v.CA.CertPool()
+		if err != nil {
+			return err
+		}
+		opts.Roots = pool
+	}
+
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	leaf := certs[0]
+
+	if _, err := leaf.Verify(opts); err != nil {
+		return err
+	}
+
+	if !v.hasIdentities() {
+		return nil
+	}
+
+	for _, name := range v.DNSNames {
+		if leaf.VerifyHostname(name) == nil {
+			return nil
+		}
+	}
+
+	for _, uri := range leaf.URIs {
+		s := uri.String()
+
+		for _, allowed := range v.URIs {
+			if s == allowed {
+				return nil
+			}
+		}
+
+		if uri.Scheme == "spiffe" {
+			for _, domain := range v.TrustDomains {
+				if uri.Host == domain {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("tls: the certificate of %s does not carry an allowed identity", leaf.Subject)
+}
+
+func (v *PeerVerifier) hasIdentities() bool {
+	return len(v.DNSNames) != 0 || len(v.URIs) != 0 || len(v.TrustDomains) != 0
+}
+
+// SPIFFEID returns the SPIFFE ID carried by cert, which is the URI subject
+// alternative name with the spiffe scheme.
+func SPIFFEID(cert *x509.Certificate) (*url.URL, error) {
+	var id *url.URL
+
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			if id != nil {
+				return nil, errors.New("tls: the certificate carries multiple SPIFFE IDs")
+			}
+			id = uri
+		}
+	}
+
+	if id == nil {
+		return nil, errors.New("tls: the certificate does not carry a SPIFFE ID")
+	}
+
+	return id, nil
+}
+
+// ServerConfig returns a TLS configuration for servers requiring clients to
+// authenticate with a certificate, which is verified by v. The certificate of
+// the server is provided by cert.
+func ServerConfig(cert *CertReloader, v *PeerVerifier) *tls.Config {
+	return &tls.Config{
+		GetCertificate: cert.GetCertificate,
+		// The chain is verified by VerifyConnection, which is also called on
+		// resumed sessions, so the CA bundle can be reloaded.
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return v.Verify(cs.PeerCertificates, x509.ExtKeyUsageClientAuth)
+		},
+	}
+}
+
+// ClientConfig returns a TLS configuration for clients authenticating with the
+// certificate provided by cert, the certificates of servers are verified by v.
+//
+// The cert argument may be nil, in which case clients don't present any
+// certificate.
+func ClientConfig(cert *CertReloader, v *PeerVerifier) *tls.Config {
+	config := &tls.Config{
+		// The verification done by the standard library is replaced by the
+		// one of VerifyConnection, see ServerConfig.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if err := v.Verify(cs.PeerCertificates, x509.ExtKeyUsageServerAuth); err != nil {
+				return err
+			}
+			if !v.hasIdentities() {
+				return cs.PeerCertificates[0].VerifyHostname(cs.ServerName)
+			}
+			return nil
+		},
+	}
+
+	if cert != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.GetCertificate(nil)
+		}
+	}
+
+	return config
+}
+
+// BackendConfigs maps the addresses of backends to the TLS configurations used
+// to connect to them, which lets a proxy present a different client
+// certificate to each of its backends.
+type BackendConfigs struct {
+	// Default is the configuration used for backends that have no entry in
+	// Backends. If nil, the default configuration is used.
+	Default *tls.Config
+
+	// Backends maps backend addresses, either in the host or host:port form,
+	// to their TLS configuration. Entries with a port take precedence.
+	Backends map[string]*tls.Config
+
+	// Dial is used to establish the connections. If nil, a net.Dialer with a
+	// 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+}
+
+// Config returns the TLS configuration to connect to the backend at address,
+// its ServerName field is set to the host of the address if it was empty.
+func (b *BackendConfigs) Config(address string) *tls.Config {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	config, ok := b.Backends[address]
+	if !ok {
+		if config, ok = b.Backends[host]; !ok {
+			config = b.Default
+		}
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	if len(config.ServerName) == 0 {
+		config.ServerName = host
+	}
+
+	return config
+}
+
+// DialTLSContext establishes a TLS connection to the backend at address, using
+// the configuration returned by Config. Its signature matches the one of the
+// DialTLSContext field of http.Transport.
+func (b *BackendConfigs) DialTLSContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := b.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, b.Config(address))
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
@@ -0,0 +1,253 @@
+package tlsx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes a certificate signed by the CA to dir, and returns a reloader
+// for it.
+func (ca *testCA) issue(t *testing.T, dir string, name string, uri string, usage x509.ExtKeyUsage) *CertReloader {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	if uri != "" {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = []*url.URL{u}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	serverCert := ca.issue(t, dir, "localhost", "spiffe://example.org/server", x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, dir, "client", "spiffe://example.org/client", x509.ExtKeyUsageClientAuth)
+
+	caReloader, err := NewCAReloader(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		server *PeerVerifier
+		client *PeerVerifier
+		cert   *CertReloader
+		ok     bool
+	}{
+		{
+			name:   "trust-domain",
+			server: &PeerVerifier{CA: caReloader, TrustDomains: []string{"example.org"}},
+			client: &PeerVerifier{CA: caReloader},
+			cert:   clientCert,
+			ok:     true,
+		},
+		{
+			name:   "uri",
+			server: &PeerVerifier{CA: caReloader, URIs: []string{"spiffe://example.org/client"}},
+			client: &PeerVerifier{CA: caReloader, URIs: []string{"spiffe://example.org/server"}},
+			cert:   clientCert,
+			ok:     true,
+		},
+		{
+			name:   "wrong-identity",
+			server: &PeerVerifier{CA: caReloader, URIs: []string{"spiffe://example.org/other"}},
+			client: &PeerVerifier{CA: caReloader},
+			cert:   clientCert,
+			ok:     false,
+		},
+		{
+			name:   "no-client-certificate",
+			server: &PeerVerifier{CA: caReloader},
+			client: &PeerVerifier{CA: caReloader},
+			ok:     false,
+		},
+		{
+			name:   "untrusted-server",
+			server: &PeerVerifier{CA: caReloader},
+			client: &PeerVerifier{},
+			cert:   clientCert,
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lstn, err := tls.Listen("tcp", "127.0.0.1:0", ServerConfig(serverCert, test.server))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lstn.Close()
+
+			go func() {
+				conn, err := lstn.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("Hello World!"))
+				conn.Close()
+			}()
+
+			_, port, _ := net.SplitHostPort(lstn.Addr().String())
+			conn, err := tls.Dial("tcp", net.JoinHostPort("localhost", port), ClientConfig(test.cert, test.client))
+			if err == nil {
+				// With TLS 1.3 the server verifies the client certificate after
+				// the client completed the handshake, errors are reported when
+				// reading.
+				_, err = ioutil.ReadAll(conn)
+				conn.Close()
+			}
+
+			if test.ok && err != nil {
+				t.Error(err)
+			}
+			if !test.ok && err == nil {
+				t.Error("expected the connection to fail")
+			}
+		})
+	}
+}
+
+func TestSPIFFEID(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.org/ns/prod/sa/api")
+
+	if id, err := SPIFFEID(&x509.Certificate{URIs: []*url.URL{u}}); err != nil || id.String() != u.String() {
+		t.Error("bad SPIFFE ID:", id, err)
+	}
+
+	if _, err := SPIFFEID(&x509.Certificate{}); err == nil {
+		t.Error("expected an error for a certificate without SPIFFE ID")
+	}
+}
+
+func TestBackendConfigs(t *testing.T) {
+	a := &tls.Config{ServerName: "a"}
+	b := &tls.Config{}
+	d := &tls.Config{ServerName: "default"}
+
+	configs := &BackendConfigs{
+		Default: d,
+		Backends: map[string]*tls.Config{
+			"backend-a":      a,
+			"backend-b:8443": b,
+		},
+	}
+
+	tests := []struct {
+		address    string
+		serverName string
+	}{
+		{"backend-a:443", "a"},
+		{"backend-b:8443", "backend-b"},
+		{"backend-b:443", "default"},
+		{"backend-c", "default"},
+	}
+
+	for _, test := range tests {
+		if name := configs.Config(test.address).ServerName; name != test.serverName {
+			t.Errorf("%s: bad server name: %q", test.address, name)
+		}
+	}
+
+	if b.ServerName != "" {
+		t.Error("the configuration of the backend was modified")
+	}
+}
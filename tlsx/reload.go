@@ -0,0 +1,184 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// DefaultReloadInterval is the default interval at which a CertReloader checks
+// whether its certificate files have changed.
+const DefaultReloadInterval = 10 * time.Second
+
+// CertReloader loads a TLS certificate from a pair of PEM encoded files and
+// reloads it when the files change, which lets programs rotate certificates
+// without restarting.
+//
+// Changes are detected by comparing the modification times of the files, the
+// check is done by GetCertificate at most once per reload interval. If loading
+// the new certificate fails the previous one keeps being used, so a partially
+// written pair of files doesn't break the handshakes.
+type CertReloader struct {
+	// CertFile and KeyFile are the paths to the certificate and private key
+	// files.
+	CertFile string
+	KeyFile  string
+
+	// Interval is the minimum amount of time between two checks for changes
+	// of the certificate files. If zero, DefaultReloadInterval is used.
+	Interval time.Duration
+
+	// ErrorLog is used to report errors that occur when reloading the
+	// certificate. If nil, the default logger is used.
+	ErrorLog *log.Logger
+
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+	checked  time.Time
+}
+
+// NewCertReloader creates a new CertReloader, loading the certificate from
+// certFile and keyFile.
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload unconditionally loads the certificate from the files.
+func (r *CertReloader) Reload() error {
+	certTime, keyTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.cert, r.certTime, r.keyTime, r.checked = &cert, certTime, keyTime, time.Now()
+	r.mutex.Unlock()
+	return nil
+}
+
+// GetCertificate satisfies the signature of the tls.Config.GetCertificate
+// field, returning the current certificate after reloading it if the files
+// have changed.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+
+	if now := time.Now(); r.cert == nil || now.Sub(r.checked) >= interval {
+		r.checked = now
+
+		if err := r.reload(); err != nil {
+			if r.cert == nil {
+				return nil, err
+			}
+			r.logf("error reloading the certificate from %s and %s: %v", r.CertFile, r.KeyFile, err)
+		}
+	}
+
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files were modified since it was last
+// loaded, the mutex must be held when calling the method.
+func (r *CertReloader) reload() error {
+	certTime, keyTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil && certTime.Equal(r.certTime) && keyTime.Equal(r.keyTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert, r.certTime, r.keyTime = &cert, certTime, keyTime
+	return nil
+}
+
+func (r *CertReloader) modTimes() (certTime time.Time, keyTime time.Time, err error) {
+	var s1, s2 os.FileInfo
+
+	if s1, err = os.Stat(r.CertFile); err != nil {
+		return
+	}
+
+	if s2, err = os.Stat(r.KeyFile); err != nil {
+		return
+	}
+
+	certTime, keyTime = s1.ModTime(), s2.ModTime()
+	return
+}
+
+func (r *CertReloader) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// NewListener wraps lstn to accept TLS connections, using the certificate
+// loaded from certFile and keyFile which is reloaded when the files change.
+//
+// The config may be nil, otherwise it is cloned and its GetCertificate field
+// is set to use the reloaded certificate.
+func NewListener(lstn net.Listener, certFile string, keyFile string, config *tls.Config) (net.Listener, error) {
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	config.GetCertificate = r.GetCertificate
+	return tls.NewListener(lstn, config), nil
+}
+
+// Listen is like netx.Listen but returns a listener accepting TLS connections,
+// see NewListener for details.
+func Listen(address string, certFile string, keyFile string, config *tls.Config) (net.Listener, error) {
+	lstn, err := netx.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsLstn, err := NewListener(lstn, certFile, keyFile, config)
+	if err != nil {
+		lstn.Close()
+		return nil, err
+	}
+
+	return tlsLstn, nil
+}
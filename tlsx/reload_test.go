@@ -0,0 +1,155 @@
+package tlsx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Interval = 1 * time.Millisecond
+
+	if name := certificateName(t, r); name != "first" {
+		t.Error("bad certificate:", name)
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	// Make sure the modification time changed even on file systems with a
+	// coarse time resolution.
+	future := time.Now().Add(1 * time.Hour)
+	os.Chtimes(certFile, future, future)
+	time.Sleep(2 * time.Millisecond)
+
+	if name := certificateName(t, r); name != "second" {
+		t.Error("the certificate was not reloaded:", name)
+	}
+
+	// A broken key file must not prevent the current certificate from being
+	// used.
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	os.Chtimes(keyFile, future, future)
+	time.Sleep(2 * time.Millisecond)
+	r.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	if name := certificateName(t, r); name != "second" {
+		t.Error("bad certificate after a failed reload:", name)
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+
+	lstn, err := Listen("127.0.0.1:0", certFile, keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+
+	go func() {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("Hello World!"))
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", lstn.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s := string(b); s != "Hello World!" {
+		t.Errorf("bad response: %q", s)
+	}
+
+	if name := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "localhost" {
+		t.Error("bad certificate:", name)
+	}
+}
+
+func certificateName(t *testing.T, r *CertReloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
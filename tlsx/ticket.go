@@ -0,0 +1,267 @@
+package tlsx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTicketKeyInterval is the default interval at which a
+	// TicketKeyRotator generates new session ticket keys.
+	DefaultTicketKeyInterval = 1 * time.Hour
+
+	// DefaultTicketKeys is the default number of session ticket keys that a
+	// TicketKeyRotator keeps.
+	DefaultTicketKeys = 3
+)
+
+// TicketKey is a session ticket key, with the time at which it was generated.
+type TicketKey struct {
+	Key     [32]byte
+	Created time.Time
+}
+
+// TicketKeyStore is the interface implemented by types that share session
+// ticket keys between multiple instances of a program, so clients can resume
+// their sessions on any of them.
+type TicketKeyStore interface {
+	// LoadTicketKeys returns the shared keys, the most recent first. No keys
+	// and a nil error are returned if none were stored yet.
+	LoadTicketKeys(ctx context.Context) ([]TicketKey, error)
+
+	// StoreTicketKeys replaces the shared keys.
+	StoreTicketKeys(ctx context.Context, keys []TicketKey) error
+}
+
+// TicketKeyRotator generates new TLS session ticket keys on a schedule and
+// installs them in the TLS configurations registered to it. Encrypting the
+// tickets with short lived keys limits the amount of traffic that can be
+// decrypted if a key is compromised, improving the forward secrecy of
+// resumed sessions on long-running listeners.
+//
+// The most recent key is used to encrypt new tickets, the previous ones are
+// kept to decrypt the tickets that were issued before the rotation.
+//
+// When a store is configured the keys are shared between all the instances
+// using it, each instance periodically loads the keys from the store and the
+// first one seeing that the current key expired generates the next one.
+type TicketKeyRotator struct {
+	// Interval is the amount of time after which a new key is generated. If
+	// zero, DefaultTicketKeyInterval is used.
+	Interval time.Duration
+
+	// Keys is the number of keys kept to decrypt tickets, including the
+	// current one. If zero, DefaultTicketKeys is used.
+	Keys int
+
+	// Store, if not nil, is used to share the keys with other instances.
+	Store TicketKeyStore
+
+	// ErrorLog is used to report errors that occur when rotating the keys in
+	// the background. If nil, the default logger is used.
+	ErrorLog *log.Logger
+
+	mutex   sync.Mutex
+	keys    []TicketKey
+	configs []*tls.Config
+}
+
+// Register installs the session ticket keys of the rotator in config, and
+// updates them on each rotation. Keys are generated if none existed yet.
+func (r *TicketKeyRotator) Register(config *tls.Config) error {
+	r.mutex.Lock()
+	r.configs = append(r.configs, config)
+	keys := r.keys
+	r.mutex.Unlock()
+
+	if len(keys) == 0 {
+		return r.Rotate(context.Background())
+	}
+
+	config.SetSessionTicketKeys(rawTicketKeys(keys))
+	return nil
+}
+
+// Rotate generates a new key if the current one has expired, after loading
+// the keys from the store if the rotator has one, then installs the keys in
+// the registered configurations.
+func (r *TicketKeyRotator) Rotate(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := r.keys
+
+	if r.Store != nil {
+		var err error
+		if keys, err = r.Store.LoadTicketKeys(ctx); err != nil {
+			return err
+		}
+	}
+
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultTicketKeyInterval
+	}
+
+	if now := time.Now(); len(keys) == 0 || now.Sub(keys[0].Created) >= interval {
+		key := TicketKey{Created: now}
+
+		if _, err := rand.Read(key.Key[:]); err != nil {
+			return err
+		}
+
+		max := r.Keys
+		if max <= 0 {
+			max = DefaultTicketKeys
+		}
+
+		keys = append([]TicketKey{key}, keys...)
+		if len(keys) > max {
+			keys = keys[:max]
+		}
+
+		if r.Store != nil {
+			if err := r.Store.StoreTicketKeys(ctx, keys); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.keys = keys
+	raw := rawTicketKeys(keys)
+
+	for _, config := range r.configs {
+		config.SetSessionTicketKeys(raw)
+	}
+
+	return nil
+}
+
+// Run calls Rotate periodically until ctx is canceled, errors are reported to
+// the error log. The keys are checked four times per interval, which bounds
+// the time that instances sharing a store take to pick up new keys.
+func (r *TicketKeyRotator) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultTicketKeyInterval
+	}
+
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Rotate(ctx); err != nil {
+				r.logf("error rotating the session ticket keys: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// TicketKeys returns the current keys of the rotator, the most recent first.
+func (r *TicketKeyRotator) TicketKeys() []TicketKey {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]TicketKey(nil), r.keys...)
+}
+
+func (r *TicketKeyRotator) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func rawTicketKeys(keys []TicketKey) [][32]byte {
+	raw := make([][32]byte, len(keys))
+	for i, key := range keys {
+		raw[i] = key.Key
+	}
+	return raw
+}
+
+// FileTicketKeyStore is a TicketKeyStore which saves the keys to a file, for
+// example on a volume shared by the instances of a program.
+//
+// The file is replaced atomically when keys are stored, so readers never see
+// a partially written file.
+type FileTicketKeyStore struct {
+	Path string
+}
+
+// ticketKeySize is the size of a key in the files of a FileTicketKeyStore, the
+// creation time is encoded as a 64 bits unix time in nanoseconds followed by
+// the key.
+const ticketKeySize = 8 + 32
+
+var errBadTicketKeyFile = errors.New("the session ticket key file is malformed")
+
+// LoadTicketKeys satisfies the TicketKeyStore interface.
+func (s *FileTicketKeyStore) LoadTicketKeys(ctx context.Context) ([]TicketKey, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	if len(b)%ticketKeySize != 0 {
+		return nil, errBadTicketKeyFile
+	}
+
+	keys := make([]TicketKey, 0, len(b)/ticketKeySize)
+
+	for ; len(b) != 0; b = b[ticketKeySize:] {
+		key := TicketKey{Created: time.Unix(0, int64(binary.BigEndian.Uint64(b)))}
+		copy(key.Key[:], b[8:ticketKeySize])
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// StoreTicketKeys satisfies the TicketKeyStore interface.
+func (s *FileTicketKeyStore) StoreTicketKeys(ctx context.Context, keys []TicketKey) error {
+	b := make([]byte, 0, len(keys)*ticketKeySize)
+
+	for _, key := range keys {
+		var t [8]byte
+		binary.BigEndian.PutUint64(t[:], uint64(key.Created.UnixNano()))
+		b = append(b, t[:]...)
+		b = append(b, key.Key[:]...)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+
+	if e := f.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.Path)
+}
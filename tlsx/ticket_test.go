@@ -0,0 +1,159 @@
+package tlsx
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTicketKeyRotator(t *testing.T) {
+	r := &TicketKeyRotator{Interval: 10 * time.Millisecond, Keys: 2}
+
+	if err := r.Register(&tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := r.TicketKeys()
+	if len(first) != 1 {
+		t.Fatal("bad number of keys:", len(first))
+	}
+
+	// The current key has not expired, nothing changes.
+	if err := r.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := r.TicketKeys(); len(keys) != 1 || keys[0] != first[0] {
+		t.Error("the key was rotated before the interval expired")
+	}
+
+	for i := 0; i != 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+
+		if err := r.Rotate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := r.TicketKeys()
+	if len(keys) != 2 {
+		t.Fatal("bad number of keys:", len(keys))
+	}
+	if keys[0] == keys[1] || keys[0] == first[0] || keys[1] == first[0] {
+		t.Error("the keys were not rotated")
+	}
+}
+
+func TestTicketKeyRotatorStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &FileTicketKeyStore{Path: filepath.Join(dir, "tickets")}
+	addrs := make([]string, 2)
+
+	// Two instances sharing the store, a session established with one of them
+	// must be resumed by the other.
+	for i := range addrs {
+		config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		if err := (&TicketKeyRotator{Store: store}).Register(config); err != nil {
+			t.Fatal(err)
+		}
+
+		lstn, err := tls.Listen("tcp", "127.0.0.1:0", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lstn.Close()
+
+		go func() {
+			for {
+				conn, err := lstn.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("Hello World!"))
+				conn.Close()
+			}
+		}()
+
+		addrs[i] = lstn.Addr().String()
+	}
+
+	config := &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+
+	for i, addr := range addrs {
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// With TLS 1.3 the session tickets are sent after the handshake,
+		// reading the response ensures the client received them.
+		if _, err := ioutil.ReadAll(conn); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+
+		if resumed := conn.ConnectionState().DidResume; resumed != (i != 0) {
+			t.Errorf("connection #%d: bad session resumption: %t", i, resumed)
+		}
+	}
+}
+
+func TestFileTicketKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &FileTicketKeyStore{Path: filepath.Join(dir, "tickets")}
+
+	if keys, err := store.LoadTicketKeys(context.Background()); err != nil || len(keys) != 0 {
+		t.Fatal("bad keys loaded from a missing file:", keys, err)
+	}
+
+	keys := []TicketKey{
+		{Key: [32]byte{1}, Created: time.Unix(2, 0)},
+		{Key: [32]byte{3}, Created: time.Unix(1, 0)},
+	}
+
+	if err := store.StoreTicketKeys(context.Background(), keys); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.LoadTicketKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != len(keys) {
+		t.Fatal("bad number of keys:", len(loaded))
+	}
+
+	for i := range keys {
+		if loaded[i].Key != keys[i].Key || !loaded[i].Created.Equal(keys[i].Created) {
+			t.Errorf("key #%d: bad key loaded: %+v", i, loaded[i])
+		}
+	}
+}
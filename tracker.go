@@ -0,0 +1,123 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnTracker is a registry of connections, it assigns them unique IDs and
+// lets programs list and force-close them, for example to expose an admin
+// endpoint which terminates a misbehaving tunnel.
+//
+// Trackers are safe to use concurrently from multiple goroutines, the zero
+// value is an empty tracker ready to use.
+type ConnTracker struct {
+	mutex sync.Mutex
+	conns map[uint64]*trackedConn
+	next  uint64
+}
+
+// ConnInfo describes a connection registered in a ConnTracker.
+type ConnInfo struct {
+	ID         uint64
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Start      time.Time         // time at which the connection was tracked
+	Meta       map[string]string // shared, must not be modified
+}
+
+type trackedConn struct {
+	conn net.Conn
+	info ConnInfo
+}
+
+// Track registers conn in the tracker with the given metadata and returns the
+// ID assigned to it. The connection must be removed from the tracker by
+// calling Untrack when it's closed.
+func (t *ConnTracker) Track(conn net.Conn, meta map[string]string) uint64 {
+	c := &trackedConn{
+		conn: conn,
+		info: ConnInfo{
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+			Start:      time.Now(),
+			Meta:       make(map[string]string, len(meta)),
+		},
+	}
+
+	for k, v := range meta {
+		c.info.Meta[k] = v
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[uint64]*trackedConn)
+	}
+
+	t.next++
+	c.info.ID = t.next
+	t.conns[c.info.ID] = c
+	return c.info.ID
+}
+
+// Untrack removes the connection with the given ID from the tracker.
+func (t *ConnTracker) Untrack(id uint64) {
+	t.mutex.Lock()
+	delete(t.conns, id)
+	t.mutex.Unlock()
+}
+
+// Conns returns the list of connections currently registered in the tracker,
+// sorted by ID.
+func (t *ConnTracker) Conns() []ConnInfo {
+	t.mutex.Lock()
+	conns := make([]ConnInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c.info)
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// Lookup returns information about the connection with the given ID.
+func (t *ConnTracker) Lookup(id uint64) (info ConnInfo, ok bool) {
+	t.mutex.Lock()
+	c, ok := t.conns[id]
+	t.mutex.Unlock()
+
+	if ok {
+		info = c.info
+	}
+	return
+}
+
+// CloseConn closes the connection with the given ID and removes it from the
+// tracker, returning false if no connection had this ID.
+func (t *ConnTracker) CloseConn(id uint64) bool {
+	t.mutex.Lock()
+	c, ok := t.conns[id]
+	delete(t.conns, id)
+	t.mutex.Unlock()
+
+	if ok {
+		c.conn.Close()
+	}
+	return ok
+}
+
+// Handler returns a handler which registers the connections in the tracker
+// while they are served by h.
+func (t *ConnTracker) Handler(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		id := t.Track(conn, nil)
+		defer t.Untrack(id)
+		h.ServeConn(ctx, conn)
+	})
+}
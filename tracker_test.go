@@ -0,0 +1,86 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTracker(t *testing.T) {
+	tracker := &ConnTracker{}
+
+	c1, c2, err := ConnPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	meta := map[string]string{"user": "luke"}
+	id1 := tracker.Track(c1, meta)
+	id2 := tracker.Track(c2, nil)
+	meta["user"] = "han"
+
+	if id1 == id2 {
+		t.Fatal("the tracker assigned the same ID to two connections:", id1)
+	}
+
+	conns := tracker.Conns()
+	if len(conns) != 2 || conns[0].ID != id1 || conns[1].ID != id2 {
+		t.Fatal("bad list of connections:", conns)
+	}
+
+	if info, ok := tracker.Lookup(id1); !ok || info.Meta["user"] != "luke" || info.RemoteAddr.String() != c1.RemoteAddr().String() {
+		t.Error("bad connection info:", info, ok)
+	}
+
+	if !tracker.CloseConn(id1) {
+		t.Error("the connection was not found")
+	}
+	if tracker.CloseConn(id1) {
+		t.Error("the connection was closed twice")
+	}
+
+	// The peer must see the connection closed.
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c2.Read(make([]byte, 1)); err == nil || IsTimeout(err) {
+		t.Error("the connection was not closed:", err)
+	}
+
+	tracker.Untrack(id2)
+
+	if conns := tracker.Conns(); len(conns) != 0 {
+		t.Error("connections left in the tracker:", conns)
+	}
+}
+
+func TestConnTrackerHandler(t *testing.T) {
+	tracker := &ConnTracker{}
+	served := make(chan struct{})
+
+	a, stop := listenAndServe(tracker.Handler(HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		served <- struct{}{}
+		conn.Read(make([]byte, 1))
+	})))
+	defer stop()
+
+	conn, err := net.Dial(a.Network(), a.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-served
+
+	conns := tracker.Conns()
+	if len(conns) != 1 {
+		t.Fatal("bad number of connections:", len(conns))
+	}
+
+	tracker.CloseConn(conns[0].ID)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || IsTimeout(err) {
+		t.Error("the connection was not closed:", err)
+	}
+}
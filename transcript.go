@@ -0,0 +1,264 @@
+package netx
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TranscriptOp is the type of operations recorded in connection transcripts.
+type TranscriptOp int
+
+const (
+	// TranscriptOpen is recorded when the recorder starts watching a
+	// connection.
+	TranscriptOpen TranscriptOp = iota
+
+	// TranscriptRead is recorded for bytes read from a connection.
+	TranscriptRead
+
+	// TranscriptWrite is recorded for bytes written to a connection.
+	TranscriptWrite
+
+	// TranscriptClose is recorded when a connection is closed.
+	TranscriptClose
+)
+
+// String returns a human-readable representation of op.
+func (op TranscriptOp) String() string {
+	switch op {
+	case TranscriptOpen:
+		return "open"
+	case TranscriptRead:
+		return "read"
+	case TranscriptWrite:
+		return "write"
+	case TranscriptClose:
+		return "close"
+	default:
+		return fmt.Sprintf("TranscriptOp(%d)", int(op))
+	}
+}
+
+// A TranscriptEvent is an operation recorded on a connection.
+type TranscriptEvent struct {
+	ConnID     uint64 // unique for each recorded connection
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Time       time.Time
+	Op         TranscriptOp
+	Data       []byte // copy of the bytes read or written, owned by the sink
+	Truncated  bool   // set when the recorder's size cap cut the data
+}
+
+// A TranscriptSink receives the events recorded on connections.
+//
+// Sinks are called concurrently when multiple connections are recorded, the
+// events of a single connection are passed in order from the goroutines that
+// read and write it.
+type TranscriptSink interface {
+	RecordEvent(TranscriptEvent)
+}
+
+// The TranscriptSinkFunc type allows simple functions to be used as transcript
+// sinks.
+type TranscriptSinkFunc func(TranscriptEvent)
+
+// RecordEvent calls f.
+func (f TranscriptSinkFunc) RecordEvent(e TranscriptEvent) {
+	f(e)
+}
+
+// Recorder wraps connections to record the bytes read from and written to them
+// to a sink, which lets programs capture the traffic of production connections
+// for offline analysis.
+type Recorder struct {
+	// Sink receives the recorded events.
+	//
+	// Record will panic if Sink is nil.
+	Sink TranscriptSink
+
+	// MaxBytes is the maximum number of bytes recorded for each connection,
+	// the connections keep working past the limit but their traffic is not
+	// recorded anymore. Zero means no limit.
+	MaxBytes int64
+
+	// Redact, if not nil, is called with a copy of the bytes of each read and
+	// write event before they are passed to the sink, it returns the data to
+	// record, which lets programs hide secrets like credentials or tokens.
+	// The function may modify b in place.
+	Redact func(op TranscriptOp, b []byte) []byte
+}
+
+// transcriptConnID is the counter used to assign IDs to recorded connections.
+var transcriptConnID uint64
+
+// Record returns a connection wrapping conn, which records the bytes read and
+// written to the recorder's sink.
+//
+// The returned connection exposes conn through its BaseConn method. It does
+// not forward ReadFrom and WriteTo to conn, since bytes copied with zero-copy
+// optimizations couldn't be recorded.
+func (r *Recorder) Record(conn net.Conn) net.Conn {
+	c := &recordedConn{
+		Conn:     conn,
+		recorder: r,
+		id:       atomic.AddUint64(&transcriptConnID, 1),
+	}
+	c.record(TranscriptOpen, nil)
+	return c
+}
+
+// Handler returns a handler which records the connections served by h.
+func (r *Recorder) Handler(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		h.ServeConn(ctx, r.Record(conn))
+	})
+}
+
+type recordedConn struct {
+	net.Conn
+	size     int64 // number of bytes recorded
+	id       uint64
+	recorder *Recorder
+	once     sync.Once
+}
+
+func (c *recordedConn) BaseConn() net.Conn {
+	return c.Conn
+}
+
+func (c *recordedConn) Read(b []byte) (n int, err error) {
+	if n, err = c.Conn.Read(b); n > 0 {
+		c.record(TranscriptRead, b[:n])
+	}
+	return
+}
+
+func (c *recordedConn) Write(b []byte) (n int, err error) {
+	if n, err = c.Conn.Write(b); n > 0 {
+		c.record(TranscriptWrite, b[:n])
+	}
+	return
+}
+
+func (c *recordedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.record(TranscriptClose, nil) })
+	return err
+}
+
+func (c *recordedConn) record(op TranscriptOp, b []byte) {
+	r := c.recorder
+	e := TranscriptEvent{
+		ConnID:     c.id,
+		LocalAddr:  c.LocalAddr(),
+		RemoteAddr: c.RemoteAddr(),
+		Time:       time.Now(),
+		Op:         op,
+	}
+
+	if len(b) != 0 {
+		if max := r.MaxBytes; max > 0 {
+			size := atomic.AddInt64(&c.size, int64(len(b)))
+			if over := size - max; over >= int64(len(b)) {
+				return
+			} else if over > 0 {
+				b, e.Truncated = b[:int64(len(b))-over], true
+			}
+		}
+
+		e.Data = append(make([]byte, 0, len(b)), b...)
+
+		if r.Redact != nil {
+			e.Data = r.Redact(op, e.Data)
+		}
+	}
+
+	r.Sink.RecordEvent(e)
+}
+
+// TranscriptRing is a transcript sink which keeps the most recent events in
+// memory, up to a total size of data.
+//
+// Rings are safe to use concurrently from multiple goroutines.
+type TranscriptRing struct {
+	mutex  sync.Mutex
+	events []TranscriptEvent
+	size   int
+	max    int
+}
+
+// NewTranscriptRing returns a new ring which retains up to size bytes of data.
+func NewTranscriptRing(size int) *TranscriptRing {
+	return &TranscriptRing{max: size}
+}
+
+// RecordEvent satisfies the TranscriptSink interface.
+func (r *TranscriptRing) RecordEvent(e TranscriptEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = append(r.events, e)
+	r.size += len(e.Data)
+
+	i := 0
+	for r.size > r.max && i < len(r.events) {
+		r.size -= len(r.events[i].Data)
+		i++
+	}
+
+	if i != 0 {
+		r.events = append(r.events[:0], r.events[i:]...)
+	}
+}
+
+// Events returns the events currently held in the ring, from the oldest to the
+// most recent.
+func (r *TranscriptRing) Events() []TranscriptEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]TranscriptEvent(nil), r.events...)
+}
+
+// NewTranscriptWriter returns a transcript sink which writes the events to w in
+// a human-readable format, with a header line for each event followed by a hex
+// dump of its data. It is typically used with a file to keep transcripts.
+//
+// Errors returned by w are ignored.
+func NewTranscriptWriter(w io.Writer) TranscriptSink {
+	return &transcriptWriter{w: w}
+}
+
+type transcriptWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	buf   []byte
+}
+
+func (t *transcriptWriter) RecordEvent(e TranscriptEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	b := t.buf[:0]
+	b = e.Time.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, fmt.Sprintf(" #%d %s->%s %s", e.ConnID, e.LocalAddr, e.RemoteAddr, e.Op)...)
+
+	if e.Op == TranscriptRead || e.Op == TranscriptWrite {
+		b = append(b, fmt.Sprintf(" %d bytes", len(e.Data))...)
+		if e.Truncated {
+			b = append(b, " (truncated)"...)
+		}
+	}
+
+	b = append(b, '\n')
+	b = append(b, hex.Dump(e.Data)...)
+
+	t.w.Write(b)
+	t.buf = b
+}
@@ -0,0 +1,122 @@
+package netx
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	ring := NewTranscriptRing(1024)
+	recorder := &Recorder{Sink: ring}
+	conn := recorder.Record(c2)
+
+	go func() {
+		c1.Write([]byte("Hello"))
+		io.ReadFull(c1, make([]byte, 6))
+	}()
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("World!")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	conn.Close()
+
+	events := ring.Events()
+	ops := make([]TranscriptOp, len(events))
+	for i, e := range events {
+		ops[i] = e.Op
+		if e.ConnID != events[0].ConnID {
+			t.Error("bad connection ID:", e.ConnID)
+		}
+	}
+
+	expect := []TranscriptOp{TranscriptOpen, TranscriptRead, TranscriptWrite, TranscriptClose}
+	if len(ops) != len(expect) {
+		t.Fatal("bad events:", ops)
+	}
+	for i := range ops {
+		if ops[i] != expect[i] {
+			t.Fatal("bad events:", ops)
+		}
+	}
+
+	if s := string(events[1].Data); s != "Hello" {
+		t.Errorf("bad read data: %q", s)
+	}
+	if s := string(events[2].Data); s != "World!" {
+		t.Errorf("bad write data: %q", s)
+	}
+}
+
+func TestRecorderMaxBytesAndRedact(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	var events []TranscriptEvent
+	recorder := &Recorder{
+		Sink:     TranscriptSinkFunc(func(e TranscriptEvent) { events = append(events, e) }),
+		MaxBytes: 8,
+		Redact: func(op TranscriptOp, b []byte) []byte {
+			return bytes.Replace(b, []byte("secret"), []byte("******"), -1)
+		},
+	}
+	conn := recorder.Record(c2)
+
+	go io.Copy(ioutil.Discard, c1)
+
+	for _, s := range []string{"secret", "1234", "5678"} {
+		if _, err := conn.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(events) != 3 {
+		t.Fatal("bad number of events:", len(events))
+	}
+	if e := events[1]; string(e.Data) != "******" || e.Truncated {
+		t.Errorf("bad redacted event: %q (truncated=%t)", e.Data, e.Truncated)
+	}
+	if e := events[2]; string(e.Data) != "12" || !e.Truncated {
+		t.Errorf("bad truncated event: %q (truncated=%t)", e.Data, e.Truncated)
+	}
+}
+
+func TestTranscriptRing(t *testing.T) {
+	ring := NewTranscriptRing(8)
+
+	for _, s := range []string{"abc", "def", "ghi", "jk"} {
+		ring.RecordEvent(TranscriptEvent{Op: TranscriptWrite, Data: []byte(s)})
+	}
+
+	var data []string
+	for _, e := range ring.Events() {
+		data = append(data, string(e.Data))
+	}
+
+	if s := strings.Join(data, ","); s != "def,ghi,jk" {
+		t.Error("bad ring content:", s)
+	}
+}
+
+func TestTranscriptWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewTranscriptWriter(buf)
+	sink.RecordEvent(TranscriptEvent{ConnID: 42, Op: TranscriptRead, Data: []byte("Hello")})
+
+	s := buf.String()
+	if !strings.Contains(s, "#42") || !strings.Contains(s, "read 5 bytes") || !strings.Contains(s, "48 65 6c 6c 6f") {
+		t.Error("bad transcript output:", s)
+	}
+}
@@ -38,6 +38,21 @@ type Tunnel struct {
 	// DialContext can be set to a dialing function to configure how the tunnel
 	// establishes new connections.
 	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// ReadRate and WriteRate limit the number of bytes per second that the
+	// tunnel reads from and writes to the connections it receives, which is
+	// useful to enforce fairness between clients sharing the tunnel or to
+	// simulate slow clients. Zero means no limit. See Throttle for details.
+	ReadRate  int
+	WriteRate int
+
+	// Logger is used to report the connections that the tunnel failed to
+	// establish. If nil, the tunnel panics to report errors.
+	Logger Logger
+
+	// Events, if not nil, receives the EventUpstreamSelected and
+	// EventTunnelOpen events of the connections served by the tunnel.
+	Events *EventBus
 }
 
 // ServeProxy satisfies the ProxyHandler interface.
@@ -45,7 +60,8 @@ type Tunnel struct {
 // When called the tunnel establishes a connection to target, then delegate to
 // its handler.
 //
-// The method panics to report errors.
+// Errors are reported to the tunnel's logger, the method panics to report them
+// if no logger was set.
 func (t *Tunnel) ServeProxy(ctx context.Context, from net.Conn, target net.Addr) {
 	dial := t.DialContext
 
@@ -53,13 +69,34 @@ func (t *Tunnel) ServeProxy(ctx context.Context, from net.Conn, target net.Addr)
 		dial = (&net.Dialer{Timeout: 10 * time.Second /* safeguard */}).DialContext
 	}
 
+	t.publish(EventUpstreamSelected, from, target, nil)
+
 	to, err := dial(ctx, target.Network(), target.String())
+	t.publish(EventTunnelOpen, from, target, err)
+
 	if err != nil {
-		panic(err)
+		if t.Logger == nil {
+			panic(err)
+		}
+		t.Logger.Warn("tunnel dial error", "remote", from.RemoteAddr(), "target", target, "err", err)
+		from.Close()
+		return
 	}
 
 	defer to.Close()
-	t.Handler.ServeTunnel(ctx, from, to)
+	t.Handler.ServeTunnel(ctx, Throttle(from, t.ReadRate, t.WriteRate), to)
+}
+
+func (t *Tunnel) publish(typ EventType, from net.Conn, target net.Addr, err error) {
+	if t.Events != nil {
+		t.Events.Publish(Event{
+			Type:       typ,
+			LocalAddr:  from.LocalAddr(),
+			RemoteAddr: from.RemoteAddr(),
+			Target:     target.String(),
+			Err:        err,
+		})
+	}
 }
 
 var (
@@ -70,7 +107,7 @@ var (
 	// before it returns (because it doesn't know anything about the underlying
 	// protocol being spoken and could leave the connections in an unreusable
 	// state).
-	TunnelRaw TunnelHandler = TunnelHandlerFunc(tunnelRaw)
+	TunnelRaw TunnelHandler = &RawTunnel{}
 
 	// TunnelLine is the implementation of a tunnel handler which speaks a line
 	// based protocol like TELNET, expecting the client not to send more than
@@ -83,12 +120,22 @@ var (
 	TunnelLine TunnelHandler = TunnelHandlerFunc(tunnelLine)
 )
 
-func tunnelRaw(ctx context.Context, from net.Conn, to net.Conn) {
+// RawTunnel is the implementation of the TunnelRaw handler, it can be used
+// directly when the buffers used to pass bytes between the two ends of the
+// tunnel need to come from a specific pool.
+type RawTunnel struct {
+	// BufferPool is used to get the buffers for copying bytes between the
+	// connections. If nil, the internal buffer pool of Copy is used.
+	BufferPool BufferPool
+}
+
+// ServeTunnel satisfies the TunnelHandler interface.
+func (t *RawTunnel) ServeTunnel(ctx context.Context, from net.Conn, to net.Conn) {
 	ctx, cancel := context.WithCancel(ctx)
 
 	copy := func(w io.Writer, r io.Reader) {
 		defer cancel()
-		Copy(w, r)
+		CopyBufferContext(ctx, w, r, t.BufferPool)
 	}
 
 	go copy(to, from)
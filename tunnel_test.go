@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestTunnel(t *testing.T) {
@@ -15,6 +16,10 @@ func TestTunnel(t *testing.T) {
 			name:   "TunnelRaw",
 			tunnel: TunnelRaw,
 		},
+		{
+			name:   "RawTunnel",
+			tunnel: &RawTunnel{BufferPool: NewBufferPool(1024)},
+		},
 		{
 			name:   "TunnelLine",
 			tunnel: TunnelLine,
@@ -60,3 +65,34 @@ func TestTunnel(t *testing.T) {
 		})
 	}
 }
+
+func TestTunnelThrottle(t *testing.T) {
+	addr1, close1 := listenAndServe(Echo)
+	defer close1()
+
+	addr2, close2 := listenAndServe(&Proxy{
+		Addr: addr1,
+		Handler: &Tunnel{
+			Handler:   TunnelRaw,
+			WriteRate: 1000,
+		},
+	})
+	defer close2()
+
+	conn, err := net.Dial(addr2.Network(), addr2.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	go conn.Write(make([]byte, 1500))
+
+	if _, err := io.ReadFull(conn, make([]byte, 1500)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Error("the tunnel did not throttle the connection:", elapsed)
+	}
+}
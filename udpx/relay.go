@@ -0,0 +1,356 @@
+package udpx
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+// A Balancer selects the backend that the datagrams of a new client session
+// are forwarded to.
+type Balancer interface {
+	Backend(client net.Addr) (string, error)
+}
+
+// The BalancerFunc type allows simple functions to be used as balancers.
+type BalancerFunc func(net.Addr) (string, error)
+
+// Backend calls f.
+func (f BalancerFunc) Backend(client net.Addr) (string, error) {
+	return f(client)
+}
+
+// RoundRobin is a balancer which cycles through a list of backend addresses.
+type RoundRobin struct {
+	Addrs []string
+	next  uint64
+}
+
+// Backend satisfies the Balancer interface.
+func (r *RoundRobin) Backend(client net.Addr) (string, error) {
+	if len(r.Addrs) == 0 {
+		return "", errNoBackend
+	}
+	i := atomic.AddUint64(&r.next, 1) - 1
+	return r.Addrs[i%uint64(len(r.Addrs))], nil
+}
+
+// Relay forwards UDP datagrams between clients and backends.
+//
+// Each client address gets a session, similar to a NAT mapping: the relay dials
+// a backend for the client and sends the datagrams received from the backend
+// back to the client. Sessions are closed when they stay idle for longer than
+// IdleTimeout.
+type Relay struct {
+	// Addr is the address of the backend that datagrams are forwarded to.
+	Addr string
+
+	// Balancer, if not nil, is used to choose the backend of each new client
+	// session instead of Addr.
+	Balancer Balancer
+
+	// DialContext is used to create the sockets that sessions use to talk to
+	// their backend. If nil, a net.Dialer with a 10 seconds timeout is used.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// IdleTimeout is the amount of time after which sessions that didn't see
+	// any datagrams are closed. If zero, DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+
+	// ErrorLog is used to report errors that occur when creating sessions. If
+	// nil, the standard logger is used.
+	ErrorLog *log.Logger
+
+	// Context is the base context used by the relay.
+	Context context.Context
+
+	mutex    sync.Mutex
+	conns    map[net.PacketConn]struct{}
+	sessions map[string]*session
+	closed   bool
+}
+
+// DefaultIdleTimeout is the default idle timeout of relay sessions.
+const DefaultIdleTimeout = 1 * time.Minute
+
+var errNoBackend = errors.New("no backend available")
+
+// ListenAndServe listens on the UDP address addr and then calls Serve to relay
+// the datagrams it receives.
+func (r *Relay) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(conn)
+}
+
+// Serve relays the datagrams received on conn until an error occurs or the
+// relay is closed.
+//
+// The relay becomes the owner of conn which will be closed by the time the
+// Serve method returns, along with the sessions created for its clients.
+//
+// After Close was called, Serve returns netx.ErrServerClosed.
+func (r *Relay) Serve(conn net.PacketConn) error {
+	defer conn.Close()
+
+	if !r.trackConn(conn) {
+		return netx.ErrServerClosed
+	}
+	defer r.untrackConn(conn)
+
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go r.reap(ctx, conn)
+	defer r.closeSessions(conn, time.Time{})
+
+	buf := make([]byte, 65535)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if netx.IsTemporary(err) {
+				continue
+			}
+			if r.isClosed() {
+				err = netx.ErrServerClosed
+			} else if ctx.Err() != nil {
+				err = nil
+			}
+			return err
+		}
+
+		s, err := r.session(ctx, conn, addr)
+		if err != nil {
+			r.logf("relay error for %s: %v", addr, err)
+			continue
+		}
+
+		s.forward(buf[:n])
+	}
+}
+
+// Close closes the sockets that the relay serves and all the sessions.
+func (r *Relay) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+
+	for conn := range r.conns {
+		conn.Close()
+	}
+
+	return nil
+}
+
+// Sessions returns the number of open client sessions.
+func (r *Relay) Sessions() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.sessions)
+}
+
+// session returns the session of the client at addr, creating it if needed.
+func (r *Relay) session(ctx context.Context, conn net.PacketConn, addr net.Addr) (*session, error) {
+	key := sessionKey(conn, addr)
+
+	r.mutex.Lock()
+	s := r.sessions[key]
+	r.mutex.Unlock()
+
+	if s != nil {
+		return s, nil
+	}
+
+	backend := r.Addr
+	if r.Balancer != nil {
+		var err error
+		if backend, err = r.Balancer.Backend(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	dial := r.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+
+	upstream, err := dial(ctx, "udp", backend)
+	if err != nil {
+		return nil, err
+	}
+
+	s = &session{
+		key:      key,
+		conn:     conn,
+		client:   addr,
+		upstream: upstream,
+		last:     time.Now().UnixNano(),
+	}
+
+	r.mutex.Lock()
+	if r.sessions == nil {
+		r.sessions = make(map[string]*session)
+	}
+	r.sessions[key] = s
+	r.mutex.Unlock()
+
+	go func() {
+		s.run()
+		r.removeSession(s)
+	}()
+
+	return s, nil
+}
+
+func (r *Relay) removeSession(s *session) {
+	r.mutex.Lock()
+	if r.sessions[s.key] == s {
+		delete(r.sessions, s.key)
+	}
+	r.mutex.Unlock()
+	s.upstream.Close()
+}
+
+// reap periodically closes the sessions of conn that have been idle for
+// longer than the relay's idle timeout, until ctx is canceled.
+func (r *Relay) reap(ctx context.Context, conn net.PacketConn) {
+	timeout := r.idleTimeout()
+
+	interval := timeout / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			r.closeSessions(conn, now.Add(-timeout))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// closeSessions closes the sessions of conn that were last active before the
+// given time, or all of them if it is zero.
+func (r *Relay) closeSessions(conn net.PacketConn, before time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, s := range r.sessions {
+		if s.conn == conn && (before.IsZero() || s.lastActive().Before(before)) {
+			s.upstream.Close()
+			delete(r.sessions, key)
+		}
+	}
+}
+
+func (r *Relay) idleTimeout() time.Duration {
+	if r.IdleTimeout != 0 {
+		return r.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+func (r *Relay) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+func (r *Relay) trackConn(conn net.PacketConn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return false
+	}
+
+	if r.conns == nil {
+		r.conns = make(map[net.PacketConn]struct{})
+	}
+
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *Relay) untrackConn(conn net.PacketConn) {
+	r.mutex.Lock()
+	delete(r.conns, conn)
+	r.mutex.Unlock()
+}
+
+func (r *Relay) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// session is the state of a client of the relay, datagrams received from the
+// client are forwarded to the upstream socket, and datagrams received on the
+// upstream socket are sent back to the client.
+type session struct {
+	key      string
+	conn     net.PacketConn
+	client   net.Addr
+	upstream net.Conn
+	last     int64 // time of the last datagram, in unix nanoseconds
+}
+
+func (s *session) forward(b []byte) {
+	s.touch()
+	s.upstream.Write(b)
+}
+
+func (s *session) run() {
+	buf := make([]byte, 65535)
+
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			if netx.IsTemporary(err) && !netx.IsTimeout(err) {
+				continue
+			}
+			return
+		}
+		s.touch()
+		s.conn.WriteTo(buf[:n], s.client)
+	}
+}
+
+func (s *session) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+func (s *session) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.last))
+}
+
+func sessionKey(conn net.PacketConn, addr net.Addr) string {
+	return conn.LocalAddr().String() + "|" + addr.String()
+}
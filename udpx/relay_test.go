@@ -0,0 +1,154 @@
+package udpx
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/netx"
+)
+
+func listenUDPEcho(t *testing.T, prefix string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append([]byte(prefix), buf[:n]...), addr)
+		}
+	}()
+
+	return conn
+}
+
+func startRelay(t *testing.T, relay *Relay) (net.Addr, <-chan error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- relay.Serve(conn) }()
+	return conn.LocalAddr(), errs
+}
+
+func roundTrip(t *testing.T, conn net.Conn, msg string) string {
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf [1024]byte
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestRelay(t *testing.T) {
+	backend := listenUDPEcho(t, "")
+	defer backend.Close()
+
+	relay := &Relay{Addr: backend.LocalAddr().String()}
+	addr, errs := startRelay(t, relay)
+
+	client, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, msg := range []string{"Hello", "World"} {
+		if s := roundTrip(t, client, msg); s != msg {
+			t.Errorf("bad response: %q", s)
+		}
+	}
+
+	if n := relay.Sessions(); n != 1 {
+		t.Error("bad session count:", n)
+	}
+
+	relay.Close()
+
+	if err := <-errs; err != netx.ErrServerClosed {
+		t.Error("bad error returned by Serve:", err)
+	}
+
+	if n := relay.Sessions(); n != 0 {
+		t.Error("sessions were not closed:", n)
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	backend := listenUDPEcho(t, "")
+	defer backend.Close()
+
+	relay := &Relay{
+		Addr:        backend.LocalAddr().String(),
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	addr, _ := startRelay(t, relay)
+	defer relay.Close()
+
+	client, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	roundTrip(t, client, "Hello")
+
+	for deadline := time.Now().Add(2 * time.Second); relay.Sessions() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the idle session was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A new session is created when the client sends more datagrams.
+	if s := roundTrip(t, client, "World"); s != "World" {
+		t.Errorf("bad response: %q", s)
+	}
+}
+
+func TestRelayBalancer(t *testing.T) {
+	backend1 := listenUDPEcho(t, "1:")
+	defer backend1.Close()
+
+	backend2 := listenUDPEcho(t, "2:")
+	defer backend2.Close()
+
+	relay := &Relay{
+		Balancer: &RoundRobin{Addrs: []string{
+			backend1.LocalAddr().String(),
+			backend2.LocalAddr().String(),
+		}},
+	}
+	addr, _ := startRelay(t, relay)
+	defer relay.Close()
+
+	for _, prefix := range []string{"1:", "2:", "1:"} {
+		client, err := net.Dial("udp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Datagrams of a client stick to the backend of its session.
+		for i := 0; i != 2; i++ {
+			if s := roundTrip(t, client, "Hello"); s != prefix+"Hello" {
+				t.Errorf("bad response: %q", s)
+			}
+		}
+
+		client.Close()
+	}
+}
@@ -0,0 +1,307 @@
+package udpx
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultSTUNServer is the default STUN server used by STUNClient.
+	DefaultSTUNServer = "stun.l.google.com:19302"
+
+	// DefaultSTUNTimeout is the default amount of time that a STUNClient waits
+	// for the response to a binding request, including retransmissions.
+	DefaultSTUNTimeout = 5 * time.Second
+
+	// stunRTO is the initial retransmission timeout of binding requests, it
+	// is doubled after each retransmission (RFC 5389 section 7.2.1).
+	stunRTO = 500 * time.Millisecond
+)
+
+const (
+	stunHeaderLen   = 20
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunBindingError     = 0x0111
+	stunMappedAddress    = 0x0001
+	stunErrorCode        = 0x0009
+	stunXorMappedAddress = 0x0020
+)
+
+var errSTUNNoAddress = errors.New("STUN response has no mapped address")
+
+// STUNError is returned by STUNClient when the server responds to a binding
+// request with an error.
+type STUNError struct {
+	Code   int
+	Reason string
+}
+
+// Error satisfies the error interface.
+func (e *STUNError) Error() string {
+	return "STUN error " + strconv.Itoa(e.Code) + ": " + e.Reason
+}
+
+// STUNClient discovers the public address that the NAT devices between the
+// host and a STUN server (RFC 5389) map a UDP socket to.
+//
+// Services running behind a NAT can use the client to learn the externally
+// reachable address to register in discovery systems. Note that the mapping
+// is only valid for the socket that the binding request was sent from, and
+// may change if the socket stays idle for too long.
+type STUNClient struct {
+	// Server is the address of the STUN server. If empty, DefaultSTUNServer
+	// is used.
+	Server string
+
+	// Timeout is the amount of time to wait for the response of the server.
+	// If zero, DefaultSTUNTimeout is used.
+	Timeout time.Duration
+}
+
+// Discover returns the public address of a new UDP socket.
+//
+// Programs that need the mapping of a socket they use should call
+// DiscoverConn instead.
+func (c *STUNClient) Discover(ctx context.Context) (*net.UDPAddr, error) {
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return c.DiscoverConn(ctx, conn)
+}
+
+// DiscoverConn sends a binding request from conn to the STUN server and
+// returns the public address it was received from.
+//
+// The method reads from conn, datagrams received from other peers while it
+// runs are discarded, and the program must not read from conn concurrently.
+// The read deadline of conn is cleared when the method returns.
+func (c *STUNClient) DiscoverConn(ctx context.Context, conn net.PacketConn) (*net.UDPAddr, error) {
+	server, err := c.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Unix(1, 0)) })
+	defer stop()
+	defer conn.SetReadDeadline(time.Time{})
+
+	var txid [12]byte
+	if _, err := rand.Read(txid[:]); err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], txid[:])
+
+	buf := make([]byte, 1500)
+	rto := stunRTO
+	deadline := time.Now().Add(c.timeout())
+
+	for {
+		if _, err := conn.WriteTo(req, server); err != nil {
+			return nil, c.error(ctx, err)
+		}
+
+		retransmit := time.Now().Add(rto)
+		if retransmit.After(deadline) {
+			retransmit = deadline
+		}
+		if err := conn.SetReadDeadline(retransmit); err != nil {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() && ctx.Err() == nil {
+					break
+				}
+				return nil, c.error(ctx, err)
+			}
+
+			if !isSTUNServer(addr, server) {
+				continue
+			}
+
+			if res, ok, err := parseSTUNResponse(buf[:n], txid); ok {
+				return res, err
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, c.error(ctx, fmt.Errorf("STUN request to %s timed out", server))
+		}
+		rto *= 2
+	}
+}
+
+func (c *STUNClient) resolve(ctx context.Context) (*net.UDPAddr, error) {
+	server := c.Server
+	if len(server) == 0 {
+		server = DefaultSTUNServer
+	}
+
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+
+	portnum, err := net.DefaultResolver.LookupPort(ctx, "udp", port)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer IPv4 since the socket may be bound to an IPv4 address, and NATs
+	// are mostly found on IPv4 networks.
+	addr := addrs[0]
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			addr = a
+			break
+		}
+	}
+
+	return &net.UDPAddr{IP: addr.IP, Port: portnum, Zone: addr.Zone}, nil
+}
+
+func (c *STUNClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultSTUNTimeout
+}
+
+func (c *STUNClient) error(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func isSTUNServer(addr net.Addr, server *net.UDPAddr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	return ok && a.Port == server.Port && a.IP.Equal(server.IP)
+}
+
+// parseSTUNResponse parses b as the response to the binding request with the
+// transaction id txid, ok is false if b is not such a response.
+func parseSTUNResponse(b []byte, txid [12]byte) (addr *net.UDPAddr, ok bool, err error) {
+	if len(b) < stunHeaderLen || binary.BigEndian.Uint32(b[4:]) != stunMagicCookie || !bytes.Equal(b[8:20], txid[:]) {
+		return nil, false, nil
+	}
+
+	typ := binary.BigEndian.Uint16(b[0:])
+	if typ != stunBindingSuccess && typ != stunBindingError {
+		return nil, false, nil
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length > len(b)-stunHeaderLen {
+		return nil, false, nil
+	}
+
+	var mapped, xorMapped *net.UDPAddr
+	var stunErr *STUNError
+
+	for attrs := b[stunHeaderLen : stunHeaderLen+length]; len(attrs) >= 4; {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		attrs = attrs[4:]
+
+		if attrLen > len(attrs) {
+			break
+		}
+		value := attrs[:attrLen]
+
+		switch attrType {
+		case stunMappedAddress:
+			mapped = parseSTUNAddress(value, nil)
+		case stunXorMappedAddress:
+			xorMapped = parseSTUNAddress(value, b[4:20])
+		case stunErrorCode:
+			if len(value) >= 4 {
+				stunErr = &STUNError{
+					Code:   int(value[2]&0x7)*100 + int(value[3]),
+					Reason: string(value[4:]),
+				}
+			}
+		}
+
+		// Attributes are padded to a multiple of 4 bytes.
+		if padded := (attrLen + 3) &^ 3; padded <= len(attrs) {
+			attrs = attrs[padded:]
+		} else {
+			attrs = nil
+		}
+	}
+
+	switch {
+	case typ == stunBindingError:
+		if stunErr == nil {
+			stunErr = &STUNError{Reason: "unknown error"}
+		}
+		return nil, true, stunErr
+	case xorMapped != nil:
+		return xorMapped, true, nil
+	case mapped != nil:
+		return mapped, true, nil
+	default:
+		return nil, true, errSTUNNoAddress
+	}
+}
+
+// parseSTUNAddress parses the value of a MAPPED-ADDRESS attribute, or of a
+// XOR-MAPPED-ADDRESS attribute if key is not nil, in which case key is the
+// magic cookie and transaction id of the response.
+func parseSTUNAddress(b []byte, key []byte) *net.UDPAddr {
+	if len(b) < 4 {
+		return nil
+	}
+
+	var ip net.IP
+	switch b[1] {
+	case 0x01:
+		ip = make(net.IP, net.IPv4len)
+	case 0x02:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil
+	}
+
+	if len(b) < 4+len(ip) {
+		return nil
+	}
+	copy(ip, b[4:])
+	port := binary.BigEndian.Uint16(b[2:])
+
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
@@ -0,0 +1,130 @@
+package udpx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenSTUN starts a STUN server responding to binding requests with the
+// attributes returned by attrs.
+func listenSTUN(t *testing.T, attrs func(req []byte, addr *net.UDPAddr) (uint16, []byte)) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			typ, body := attrs(buf[:n], addr.(*net.UDPAddr))
+			if typ == 0 {
+				continue
+			}
+			res := make([]byte, stunHeaderLen, stunHeaderLen+len(body))
+			binary.BigEndian.PutUint16(res[0:], typ)
+			binary.BigEndian.PutUint16(res[2:], uint16(len(body)))
+			copy(res[4:], buf[4:20])
+			conn.WriteTo(append(res, body...), addr)
+		}
+	}()
+
+	return conn
+}
+
+func stunAttr(typ uint16, value []byte) []byte {
+	b := make([]byte, 4, 4+len(value)+3)
+	binary.BigEndian.PutUint16(b[0:], typ)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(value)))
+	b = append(b, value...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func xorMappedAddress(req []byte, addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	b := []byte{0, 1, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	for i := range ip {
+		b = append(b, ip[i]^req[4+i])
+	}
+	return stunAttr(stunXorMappedAddress, b)
+}
+
+func TestSTUNClient(t *testing.T) {
+	var requests int32
+
+	server := listenSTUN(t, func(req []byte, addr *net.UDPAddr) (uint16, []byte) {
+		// Drop the first request to exercise retransmissions.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			return 0, nil
+		}
+		software := stunAttr(0x8022, []byte("test"))
+		return stunBindingSuccess, append(software, xorMappedAddress(req, addr)...)
+	})
+	defer server.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := &STUNClient{Server: server.LocalAddr().String()}
+
+	addr, err := client.DiscoverConn(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr.String() != conn.LocalAddr().String() {
+		t.Error("bad address:", addr, "!=", conn.LocalAddr())
+	}
+
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Error("bad number of requests:", requests)
+	}
+}
+
+func TestSTUNClientError(t *testing.T) {
+	server := listenSTUN(t, func(req []byte, addr *net.UDPAddr) (uint16, []byte) {
+		return stunBindingError, stunAttr(stunErrorCode, append([]byte{0, 0, 4, 20}, "Unknown Attribute"...))
+	})
+	defer server.Close()
+
+	client := &STUNClient{Server: server.LocalAddr().String()}
+
+	_, err := client.Discover(context.Background())
+	if e, ok := err.(*STUNError); !ok || e.Code != 420 || e.Reason != "Unknown Attribute" {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestSTUNClientTimeout(t *testing.T) {
+	server := listenSTUN(t, func(req []byte, addr *net.UDPAddr) (uint16, []byte) {
+		return 0, nil
+	})
+	defer server.Close()
+
+	client := &STUNClient{Server: server.LocalAddr().String(), Timeout: 100 * time.Millisecond}
+
+	if _, err := client.Discover(context.Background()); err == nil {
+		t.Error("expected a timeout error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := (&STUNClient{Server: server.LocalAddr().String()}).Discover(ctx); err != context.DeadlineExceeded {
+		t.Error("bad error:", err)
+	}
+}
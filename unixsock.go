@@ -0,0 +1,232 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UnixListenConfig contains options for creating listeners on unix domain
+// sockets.
+//
+// Names starting with '@' are in the abstract namespace on Linux, they don't
+// exist on the file system so the file options are ignored for them.
+type UnixListenConfig struct {
+	// Mode, if not zero, is set as the permissions of the socket file after
+	// it was created. Note that the socket is reachable with the permissions
+	// derived from the umask of the process until then.
+	Mode os.FileMode
+
+	// User and Group, if not empty, are the names or numeric ids of the owner
+	// and group set on the socket file after it was created.
+	User  string
+	Group string
+
+	// RemoveStale enables removing socket files left behind by processes that
+	// didn't get to clean up, which would otherwise make listening fail. A
+	// socket is considered stale when connecting to it is refused, sockets
+	// still in use are never removed.
+	RemoveStale bool
+}
+
+// Listen announces on the unix socket at path, network must be one of unix or
+// unixpacket.
+func (c *UnixListenConfig) Listen(network string, path string) (lstn net.Listener, err error) {
+	switch network {
+	case "unix", "unixpacket":
+	default:
+		err = &net.OpError{Op: "listen", Net: network, Err: net.UnknownNetworkError(network)}
+		return
+	}
+
+	abstract := isAbstractUnix(path)
+
+	if c.RemoveStale && !abstract {
+		if err = removeStaleUnix(network, path); err != nil {
+			return
+		}
+	}
+
+	if lstn, err = net.Listen(network, path); err != nil || abstract {
+		return
+	}
+
+	if err = c.setFileOptions(path); err != nil {
+		lstn.Close()
+		lstn = nil
+	}
+
+	return
+}
+
+func (c *UnixListenConfig) setFileOptions(path string) (err error) {
+	uid, gid := -1, -1
+
+	if len(c.User) != 0 {
+		if uid, err = lookupUser(c.User); err != nil {
+			return
+		}
+	}
+
+	if len(c.Group) != 0 {
+		if gid, err = lookupGroup(c.Group); err != nil {
+			return
+		}
+	}
+
+	if uid != -1 || gid != -1 {
+		if err = os.Chown(path, uid, gid); err != nil {
+			return
+		}
+	}
+
+	if c.Mode != 0 {
+		err = os.Chmod(path, c.Mode)
+	}
+
+	return
+}
+
+// UnixDialer connects to unix domain sockets in place of the network addresses
+// that they are mapped to, which lets proxies and HTTP transports, which only
+// deal with host and port pairs, reach backends listening on unix sockets:
+//
+//	d := &netx.UnixDialer{
+//		Sockets: map[string]string{"app": "unix:///var/run/app.sock"},
+//	}
+//	proxy := &httpx.ReverseProxy{
+//		Transport:   &http.Transport{DialContext: d.DialContext},
+//		DialContext: d.DialContext,
+//	}
+type UnixDialer struct {
+	// Sockets maps addresses, either host:port pairs or host names matching
+	// any port, to the unix:// or unixpacket:// addresses of the sockets that
+	// connections are established to. Paths without a protocol are unix
+	// sockets.
+	Sockets map[string]string
+
+	// Dial is used to connect to the addresses which are not in Sockets. If
+	// nil, a net.Dialer with a 10 seconds timeout is used.
+	Dial func(context.Context, string, string) (net.Conn, error)
+}
+
+// DialContext connects to address on the named network, or to the unix socket
+// that address is mapped to.
+//
+// The method has the signature expected by the DialContext fields of
+// transports and proxies.
+func (d *UnixDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	if socket, ok := d.lookup(network, address); ok {
+		sockNet, path := SplitNetAddr(socket)
+		if len(sockNet) == 0 {
+			sockNet = "unix"
+		}
+		return dialer.DialContext(ctx, sockNet, path)
+	}
+
+	if d.Dial != nil {
+		return d.Dial(ctx, network, address)
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (d *UnixDialer) lookup(network string, address string) (socket string, ok bool) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return
+	}
+
+	if socket, ok = d.Sockets[address]; !ok {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			socket, ok = d.Sockets[host]
+		}
+	}
+
+	return
+}
+
+func isAbstractUnix(path string) bool {
+	return runtime.GOOS == "linux" && strings.HasPrefix(path, "@")
+}
+
+// removeStaleUnix removes the socket file at path if no process accepts
+// connections on it anymore.
+func removeStaleUnix(network string, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil // not a socket, let Listen report the error
+	}
+
+	conn, err := net.DialTimeout(network, path, 1*time.Second)
+	if err == nil {
+		conn.Close()
+		return nil // in use
+	}
+
+	if !isConnRefused(err) {
+		return nil
+	}
+
+	if err = os.Remove(path); os.IsNotExist(err) {
+		err = nil // removed concurrently
+	}
+	return err
+}
+
+func isConnRefused(err error) bool {
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+func lookupUser(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return parseID(u.Uid)
+}
+
+func lookupGroup(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+	return parseID(g.Gid)
+}
+
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return -1, errors.New("unsupported non-numeric user or group id: " + s)
+	}
+	return id, nil
+}
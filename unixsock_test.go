@@ -0,0 +1,142 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestUnixListenConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.sock")
+
+	t.Run("mode", func(t *testing.T) {
+		config := &UnixListenConfig{
+			Mode:  0600,
+			User:  strconv.Itoa(os.Getuid()),
+			Group: strconv.Itoa(os.Getgid()),
+		}
+
+		lstn, err := config.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lstn.Close()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("bad mode: %o", mode)
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		lstn, err := net.ListenUnix("unix", &net.UnixAddr{Net: "unix", Name: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lstn.SetUnlinkOnClose(false)
+		lstn.Close()
+
+		if l, err := (&UnixListenConfig{}).Listen("unix", path); err == nil {
+			l.Close()
+			t.Fatal("listening on a stale socket succeeded without RemoveStale")
+		}
+
+		l, err := (&UnixListenConfig{RemoveStale: true}).Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		// The socket is in use now, it must not be removed.
+		if l, err := (&UnixListenConfig{RemoveStale: true}).Listen("unix", path); err == nil {
+			l.Close()
+			t.Fatal("a socket in use was removed")
+		}
+	})
+
+	t.Run("abstract", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("abstract unix sockets are only supported on linux")
+		}
+
+		name := "@netx-test-" + strconv.Itoa(os.Getpid())
+
+		lstn, err := (&UnixListenConfig{Mode: 0600, RemoveStale: true}).Listen("unix", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lstn.Close()
+
+		conn, err := net.Dial("unix", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+}
+
+func TestUnixDialer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "echo.sock")
+
+	lstn, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstn.Close()
+	go (&Server{Handler: Echo}).Serve(lstn)
+
+	d := &UnixDialer{
+		Sockets: map[string]string{
+			"echo":           "unix://" + path,
+			"localhost:4242": path,
+		},
+	}
+
+	for _, address := range []string{"echo:80", "echo:443", "localhost:4242"} {
+		t.Run(address, func(t *testing.T) {
+			conn, err := d.DialContext(context.Background(), "tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("Hello World!"))
+			b := make([]byte, 12)
+
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "Hello World!" {
+				t.Error("bad response:", string(b))
+			}
+		})
+	}
+
+	t.Run("not-mapped", func(t *testing.T) {
+		if conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1"); err == nil {
+			conn.Close()
+			t.Error("no error returned when dialing an unmapped address")
+		}
+	})
+}
@@ -0,0 +1,540 @@
+package wsx
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxMessageSize is the default maximum size of messages returned by
+// ReadMessage.
+const DefaultMaxMessageSize = 16 * 1024 * 1024
+
+// MessageType is the type of data messages.
+type MessageType int
+
+const (
+	// TextMessage is the type of messages carrying UTF-8 text.
+	TextMessage MessageType = 1
+
+	// BinaryMessage is the type of messages carrying binary data.
+	BinaryMessage MessageType = 2
+)
+
+// Close codes defined by RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+const (
+	opContinuation = 0
+	opText         = 1
+	opBinary       = 2
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+
+	finalBit = 0x80
+	rsvBits  = 0x70
+	maskBit  = 0x80
+
+	maxControlPayload = 125
+)
+
+// CloseError is returned by reads when the remote peer closed the connection
+// with a close frame, except for normal closures which are reported as io.EOF
+// by Read.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error satisfies the error interface.
+func (e *CloseError) Error() string {
+	s := "websocket: close " + strconv.Itoa(e.Code)
+	if len(e.Reason) != 0 {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+var (
+	errProtocol      = &CloseError{Code: CloseProtocolError, Reason: "protocol error"}
+	errInvalidUTF8   = &CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8 in text message"}
+	errMessageTooBig = &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	errCloseSent     = errors.New("websocket: close frame already sent")
+)
+
+// Conn is a WebSocket connection, it is returned by Upgrader.Upgrade on the
+// server side and by Dialer.Dial on the client side.
+//
+// Messages can be exchanged with ReadMessage and WriteMessage, or with
+// NextReader to stream large messages. Conn also implements net.Conn: Read
+// returns the payload of consecutive messages as a byte stream, and Write
+// sends each buffer as a binary message, which lets programs run stream
+// protocols over WebSocket.
+//
+// Pings received from the remote peer are answered automatically while the
+// connection is read. Only one goroutine may read from the connection at a
+// time, writes may be done concurrently.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	server      bool
+	subprotocol string
+
+	// MaxMessageSize is the maximum size of messages returned by ReadMessage.
+	// If zero, DefaultMaxMessageSize is used.
+	MaxMessageSize int64
+
+	// OnPong, if not nil, is called with the payload of pongs received from
+	// the remote peer, the payload is only valid during the call.
+	OnPong func([]byte)
+
+	// read state
+	remaining int64 // bytes left in the current frame
+	maskKey   [4]byte
+	maskPos   int
+	masked    bool
+	final     bool  // the current frame is the last of its message
+	reading   bool  // a message is being read
+	readErr   error // sticky read error
+	message   *messageReader
+	control   [maxControlPayload]byte
+
+	writeMutex sync.Mutex
+	closeSent  bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, server bool, subprotocol string) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, server: server, subprotocol: subprotocol}
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// BaseConn returns the network connection that the WebSocket connection runs
+// on.
+func (c *Conn) BaseConn() net.Conn {
+	return c.conn
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// Read satisfies the io.Reader interface, reading the payloads of consecutive
+// data messages. It returns io.EOF when the remote peer closed the connection
+// normally.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		if c.message != nil {
+			n, err := c.message.Read(b)
+			if err == io.EOF {
+				c.message = nil
+				if n == 0 {
+					continue
+				}
+				err = nil
+			}
+			return n, err
+		}
+
+		_, r, err := c.NextReader()
+		if err != nil {
+			if e, ok := err.(*CloseError); ok && (e.Code == CloseNormalClosure || e.Code == CloseGoingAway) {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		c.message = r.(*messageReader)
+	}
+}
+
+// Write satisfies the io.Writer interface, b is sent as a binary message.
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadMessage reads the next data message, it returns a CloseError if the
+// connection was closed by the remote peer.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	typ, r, err := c.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	limit := c.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if int64(len(b)) > limit {
+		return 0, nil, c.fail(errMessageTooBig)
+	}
+
+	if typ == TextMessage && !utf8.Valid(b) {
+		return 0, nil, c.fail(errInvalidUTF8)
+	}
+
+	return typ, b, nil
+}
+
+// NextReader returns the type of the next data message and a reader for its
+// payload, the rest of the previous message is discarded. The reader returns
+// io.EOF at the end of the message.
+func (c *Conn) NextReader() (MessageType, io.Reader, error) {
+	// Skip what is left of the previous message.
+	if c.reading || c.remaining != 0 {
+		if _, err := io.Copy(io.Discard, &messageReader{c}); err != nil {
+			return 0, nil, err
+		}
+		c.message = nil
+	}
+
+	for {
+		opcode, err := c.nextFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if opcode == opText || opcode == opBinary {
+			return MessageType(opcode), &messageReader{c}, nil
+		}
+	}
+}
+
+// nextFrame reads frame headers until a data frame is found, handling control
+// frames in between.
+func (c *Conn) nextFrame() (int, error) {
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			return 0, c.setReadErr(err)
+		}
+
+		final := h[0]&finalBit != 0
+		opcode := int(h[0] & 0x0f)
+		masked := h[1]&maskBit != 0
+		length := int64(h[1] & 0x7f)
+
+		switch length {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return 0, c.setReadErr(err)
+			}
+			length = int64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return 0, c.setReadErr(err)
+			}
+			length = int64(binary.BigEndian.Uint64(b[:]))
+		}
+
+		// Frames sent by clients must be masked and frames sent by servers
+		// must not, no extensions are negotiated so the reserved bits must be
+		// zero.
+		if h[0]&rsvBits != 0 || masked != c.server || length < 0 {
+			return 0, c.fail(errProtocol)
+		}
+
+		if masked {
+			if _, err := io.ReadFull(c.br, c.maskKey[:]); err != nil {
+				return 0, c.setReadErr(err)
+			}
+		}
+		c.masked, c.maskPos = masked, 0
+
+		switch opcode {
+		case opClose, opPing, opPong:
+			if !final || length > maxControlPayload {
+				return 0, c.fail(errProtocol)
+			}
+			payload := c.control[:length]
+			if _, err := io.ReadFull(c.br, payload); err != nil {
+				return 0, c.setReadErr(err)
+			}
+			c.unmask(payload)
+
+			if err := c.handleControl(opcode, payload); err != nil {
+				return 0, err
+			}
+
+		case opText, opBinary, opContinuation:
+			// Continuation frames must follow a non-final data frame, and new
+			// messages can't start before the previous one ended.
+			if (opcode == opContinuation) != c.reading {
+				return 0, c.fail(errProtocol)
+			}
+			c.reading = !final
+			c.final = final
+			c.remaining = length
+			return opcode, nil
+
+		default:
+			return 0, c.fail(errProtocol)
+		}
+	}
+}
+
+func (c *Conn) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case opPing:
+		err := c.writeFrame(opPong, payload)
+		if err != nil && err != errCloseSent {
+			return c.setReadErr(err)
+		}
+
+	case opPong:
+		if c.OnPong != nil {
+			c.OnPong(payload)
+		}
+
+	case opClose:
+		closeErr := &CloseError{Code: CloseNoStatusReceived}
+
+		switch {
+		case len(payload) == 1:
+			return c.fail(errProtocol)
+		case len(payload) >= 2:
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Reason = string(payload[2:])
+			if !validCloseCode(closeErr.Code) || !utf8.ValidString(closeErr.Reason) {
+				return c.fail(errProtocol)
+			}
+		}
+
+		// Echo the close frame if it wasn't already sent, the closing
+		// handshake is then complete.
+		code := closeErr.Code
+		if code == CloseNoStatusReceived {
+			code = CloseNormalClosure
+		}
+		c.WriteClose(code, "")
+		return c.setReadErr(closeErr)
+	}
+
+	return nil
+}
+
+// fail sends a close frame for err and makes it the sticky read error.
+func (c *Conn) fail(err *CloseError) error {
+	c.WriteClose(err.Code, err.Reason)
+	return c.setReadErr(err)
+}
+
+func (c *Conn) setReadErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The connection was closed without a close frame.
+		err = &CloseError{Code: CloseAbnormalClosure, Reason: "unexpected EOF"}
+	}
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	return c.readErr
+}
+
+func (c *Conn) unmask(b []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range b {
+		b[i] ^= c.maskKey[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+// messageReader reads the payload of the current message, across
+// continuation frames.
+type messageReader struct{ c *Conn }
+
+func (r *messageReader) Read(b []byte) (int, error) {
+	c := r.c
+
+	for c.remaining == 0 {
+		if c.final {
+			return 0, io.EOF
+		}
+		if _, err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+
+	n, err := c.br.Read(b)
+	c.unmask(b[:n])
+	c.remaining -= int64(n)
+
+	if err == io.EOF {
+		err = c.setReadErr(err)
+	}
+	return n, err
+}
+
+// WriteMessage sends a data message with payload b.
+func (c *Conn) WriteMessage(typ MessageType, b []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return errors.New("websocket: invalid message type " + strconv.Itoa(int(typ)))
+	}
+	return c.writeFrame(int(typ), b)
+}
+
+// Ping sends a ping to the remote peer, the pong is received while reading the
+// connection and passed to OnPong.
+func (c *Conn) Ping(payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeFrame(opPing, payload)
+}
+
+// WriteClose starts the closing handshake by sending a close frame with the
+// given code and reason, the connection may still be read until the remote
+// peer answers with its own close frame.
+func (c *Conn) WriteClose(code int, reason string) error {
+	var payload []byte
+
+	if code != CloseNoStatusReceived {
+		if len(reason) > maxControlPayload-2 {
+			reason = reason[:maxControlPayload-2]
+		}
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+	}
+
+	return c.writeFrame(opClose, payload)
+}
+
+// Close sends a normal closure frame if none was sent yet, then closes the
+// underlying connection.
+func (c *Conn) Close() error {
+	c.WriteClose(CloseNormalClosure, "")
+	return c.conn.Close()
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closeSent {
+		return errCloseSent
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	// Servers send the payload unmodified, it is written after the header in
+	// a single vectored write instead of being copied to the frame.
+	size := 14
+	if !c.server {
+		size += len(payload)
+	}
+
+	frame := make([]byte, 0, size)
+	frame = append(frame, finalBit|byte(opcode))
+
+	var maskBits byte
+	if !c.server {
+		maskBits = maskBit
+	}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBits|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBits|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBits|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.server {
+		bufs := net.Buffers{frame, payload}
+		_, err := bufs.WriteTo(c.conn)
+		return err
+	}
+
+	// Clients mask their frames with a random key so intermediaries can't be
+	// tricked into interpreting the payload (RFC 6455 section 10.3).
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	frame = append(frame, key[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i&3])
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
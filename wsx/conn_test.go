@@ -0,0 +1,212 @@
+package wsx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startEchoServer starts a server which echoes the messages it receives, and
+// closes the connection with the same code when receiving a close frame.
+func startEchoServer(t *testing.T) (string, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := (&Upgrader{}).Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			typ, b, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(typ, b); err != nil {
+				return
+			}
+		}
+	}))
+	return "ws" + strings.TrimPrefix(server.URL, "http"), server.Close
+}
+
+func dial(t *testing.T, url string) *Conn {
+	conn, _, err := (&Dialer{}).Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestConnMessages(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn := dial(t, url)
+	defer conn.Close()
+
+	for _, test := range []struct {
+		name string
+		typ  MessageType
+		data []byte
+	}{
+		{"empty", TextMessage, nil},
+		{"text", TextMessage, []byte("Hello World!")},
+		{"binary-16", BinaryMessage, bytes.Repeat([]byte{0xff}, 1000)},
+		{"binary-64", BinaryMessage, bytes.Repeat([]byte{0x42}, 100000)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := conn.WriteMessage(test.typ, test.data); err != nil {
+				t.Fatal(err)
+			}
+
+			typ, b, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if typ != test.typ || !bytes.Equal(b, test.data) {
+				t.Errorf("bad message: %d %d bytes", typ, len(b))
+			}
+		})
+	}
+}
+
+func TestConnStream(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn := dial(t, url)
+
+	// Messages are read as a continuous byte stream through net.Conn.
+	var c net.Conn = conn
+	io.WriteString(c, "Hello ")
+	io.WriteString(c, "World!")
+
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello World!" {
+		t.Errorf("bad stream: %q", b)
+	}
+
+	// Starting the closing handshake makes the server echo the close frame,
+	// which is seen as the end of the stream.
+	if err := conn.WriteClose(CloseNormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Read(b); n != 0 || err != io.EOF {
+		t.Error("bad end of stream:", n, err)
+	}
+	conn.Close()
+}
+
+func TestConnPing(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn := dial(t, url)
+	defer conn.Close()
+
+	var pong string
+	conn.OnPong = func(b []byte) { pong = string(b) }
+
+	if err := conn.Ping([]byte("ping!")); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteMessage(TextMessage, []byte("sync"))
+
+	if _, b, err := conn.ReadMessage(); err != nil || string(b) != "sync" {
+		t.Fatal("bad message:", string(b), err)
+	}
+
+	if pong != "ping!" {
+		t.Errorf("bad pong: %q", pong)
+	}
+}
+
+func TestConnProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked", []byte{0x81, 0x01, 'x'}, CloseProtocolError},
+		{"reserved-bits", []byte{0xc1, 0x81, 0, 0, 0, 0, 'x'}, CloseProtocolError},
+		{"continuation", []byte{0x80, 0x81, 0, 0, 0, 0, 'x'}, CloseProtocolError},
+		{"fragmented-ping", []byte{0x09, 0x80, 0, 0, 0, 0}, CloseProtocolError},
+		{"invalid-utf8", []byte{0x81, 0x81, 0, 0, 0, 0, 0xff}, CloseInvalidPayload},
+		{"bad-opcode", []byte{0x83, 0x80, 0, 0, 0, 0}, CloseProtocolError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, stop := startEchoServer(t)
+			defer stop()
+
+			conn := dial(t, url)
+			defer conn.Close()
+
+			// Write the frame directly on the underlying connection to bypass
+			// the validation of the client.
+			if _, err := conn.BaseConn().Write(test.frame); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err := conn.ReadMessage()
+			if e, ok := err.(*CloseError); !ok || e.Code != test.code {
+				t.Error("bad error:", err)
+			}
+		})
+	}
+}
+
+func TestConnFragmented(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn := dial(t, url)
+	defer conn.Close()
+
+	// A text message split in two frames with a ping in between, all masked
+	// with a zero key.
+	frames := []byte{
+		0x01, 0x83, 0, 0, 0, 0, 'a', 'b', 'c',
+		0x89, 0x80, 0, 0, 0, 0,
+		0x80, 0x83, 0, 0, 0, 0, 'd', 'e', 'f',
+	}
+	if _, err := conn.BaseConn().Write(frames); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, b, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || string(b) != "abcdef" {
+		t.Errorf("bad message: %d %q", typ, b)
+	}
+}
+
+func TestConnMessageTooBig(t *testing.T) {
+	url, stop := startEchoServer(t)
+	defer stop()
+
+	conn := dial(t, url)
+	defer conn.Close()
+	conn.MaxMessageSize = 10
+
+	conn.WriteMessage(BinaryMessage, make([]byte, 11))
+
+	_, _, err := conn.ReadMessage()
+	if e, ok := err.(*CloseError); !ok || e.Code != CloseMessageTooBig {
+		t.Error("bad error:", err)
+	}
+}
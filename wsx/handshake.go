@@ -0,0 +1,348 @@
+package wsx
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultHandshakeTimeout is the default amount of time that a Dialer waits
+// for the opening handshake to complete.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// acceptGUID is concatenated to the client key to compute the accept key of
+// the server (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrBadHandshake is returned by Dialer.Dial when the server response is not
+// a valid WebSocket handshake.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader upgrades HTTP requests to WebSocket connections on the server side.
+type Upgrader struct {
+	// Protocols is the list of subprotocols supported by the server, in order
+	// of preference. The first one requested by the client is selected.
+	Protocols []string
+
+	// CheckOrigin returns true if the request may be upgraded, it protects
+	// against cross-site WebSocket hijacking. If nil, requests with an Origin
+	// header are only accepted if the origin host matches the Host header.
+	CheckOrigin func(*http.Request) bool
+
+	// Header is added to the handshake response.
+	Header http.Header
+}
+
+// IsWebSocketUpgrade returns true if req asks for the connection to be
+// upgraded to the WebSocket protocol.
+func IsWebSocketUpgrade(req *http.Request) bool {
+	return headerContains(req.Header, "Connection", "upgrade") && headerContains(req.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake of the WebSocket connection that req
+// asked for, and returns the connection. If the handshake fails, Upgrade
+// responds to the request with an HTTP error and returns a non-nil error.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	if req.Method != "GET" {
+		return nil, handshakeError(w, http.StatusMethodNotAllowed, "websocket: the request method must be GET")
+	}
+
+	if !IsWebSocketUpgrade(req) {
+		return nil, handshakeError(w, http.StatusBadRequest, "websocket: the request is not a websocket upgrade")
+	}
+
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		return nil, handshakeError(w, http.StatusUpgradeRequired, "websocket: unsupported protocol version")
+	}
+
+	key := req.Header.Get("Sec-Websocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, handshakeError(w, http.StatusBadRequest, "websocket: missing or invalid key")
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, handshakeError(w, http.StatusForbidden, "websocket: origin not allowed")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, handshakeError(w, http.StatusInternalServerError, "websocket: the response writer doesn't support hijacking")
+	}
+
+	subprotocol := u.selectProtocol(req)
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+	}
+	for name, values := range u.Header {
+		res.Header[name] = values
+	}
+	res.Header.Set("Upgrade", "websocket")
+	res.Header.Set("Connection", "Upgrade")
+	res.Header.Set("Sec-Websocket-Accept", acceptKey(key))
+	if len(subprotocol) != 0 {
+		res.Header.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+
+	if err := res.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var br *bufio.Reader
+	if rw.Reader.Buffered() != 0 {
+		br = rw.Reader
+	}
+
+	return newConn(conn, br, true, subprotocol), nil
+}
+
+func (u *Upgrader) selectProtocol(req *http.Request) string {
+	requested := headerTokens(req.Header, "Sec-Websocket-Protocol")
+
+	for _, p := range u.Protocols {
+		for _, r := range requested {
+			if p == r {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
+
+// Dialer establishes WebSocket connections on the client side.
+type Dialer struct {
+	// DialContext is used to establish the network connections. If nil, a
+	// net.Dialer is used.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// TLSConfig is used to establish the TLS connections to wss:// URLs.
+	TLSConfig *tls.Config
+
+	// Protocols is the list of subprotocols requested to the server.
+	Protocols []string
+
+	// HandshakeTimeout is the amount of time to wait for the opening
+	// handshake to complete. If zero, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize is the size of the buffer used to read frames from the
+	// connection. If zero, a default size of 4 KB is used.
+	ReadBufferSize int
+}
+
+// Dial opens a WebSocket connection to the ws:// or wss:// URL rawurl, header
+// is added to the handshake request. The handshake response is returned even
+// if the handshake failed, its body is not readable.
+func (d *Dialer) Dial(ctx context.Context, rawurl string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var secure bool
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		secure = true
+	default:
+		return nil, nil, errors.New("websocket: unsupported URL scheme: " + u.Scheme)
+	}
+
+	address := u.Host
+	if len(u.Port()) == 0 {
+		if secure {
+			address = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			address = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	timeout := d.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := d.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	c, res, err := d.handshake(ctx, conn, u, secure, header)
+
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, res, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return c, res, nil
+}
+
+func (d *Dialer) handshake(ctx context.Context, conn net.Conn, u *url.URL, secure bool, header http.Header) (*Conn, *http.Response, error) {
+	if secure {
+		config := d.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		} else {
+			config = config.Clone()
+		}
+		if len(config.ServerName) == 0 {
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	if len(req.URL.Path) == 0 {
+		req.URL.Path = "/"
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-Websocket-Key", key)
+	req.Header.Set("Sec-Websocket-Version", "13")
+	if len(d.Protocols) != 0 {
+		req.Header.Set("Sec-Websocket-Protocol", strings.Join(d.Protocols, ", "))
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+
+	size := d.ReadBufferSize
+	if size <= 0 {
+		size = 4096
+	}
+
+	br := bufio.NewReaderSize(conn, size)
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	res.Body = http.NoBody
+
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(res.Header, "Upgrade", "websocket") ||
+		!headerContains(res.Header, "Connection", "upgrade") ||
+		res.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		return nil, res, ErrBadHandshake
+	}
+
+	subprotocol := res.Header.Get("Sec-Websocket-Protocol")
+	if len(subprotocol) != 0 && !contains(d.Protocols, subprotocol) {
+		return nil, res, ErrBadHandshake
+	}
+
+	return newConn(conn, br, false, subprotocol), res, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func handshakeError(w http.ResponseWriter, status int, msg string) error {
+	http.Error(w, http.StatusText(status), status)
+	return errors.New(msg)
+}
+
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// headerTokens returns the comma separated tokens of the header values.
+func headerTokens(h http.Header, name string) []string {
+	var tokens []string
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); len(token) != 0 {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerContains(h http.Header, name string, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
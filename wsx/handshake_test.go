@@ -0,0 +1,69 @@
+package wsx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3.
+	if key := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Error("bad accept key:", key)
+	}
+}
+
+func TestUpgrader(t *testing.T) {
+	upgrader := &Upgrader{Protocols: []string{"chat.v2", "chat.v1"}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(TextMessage, []byte(conn.Subprotocol()))
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	t.Run("subprotocol", func(t *testing.T) {
+		conn, res, err := (&Dialer{Protocols: []string{"chat.v1", "chat.v2"}, ReadBufferSize: 64}).Dial(context.Background(), wsURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if res.StatusCode != http.StatusSwitchingProtocols {
+			t.Error("bad status:", res.StatusCode)
+		}
+
+		if _, b, err := conn.ReadMessage(); err != nil || string(b) != "chat.v2" {
+			t.Error("bad subprotocol:", string(b), err)
+		}
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		header := http.Header{"Origin": {"http://evil.example.com"}}
+
+		_, res, err := (&Dialer{}).Dial(context.Background(), wsURL, header)
+		if err != ErrBadHandshake || res.StatusCode != http.StatusForbidden {
+			t.Error("cross origin request was not rejected:", err)
+		}
+	})
+
+	t.Run("not-websocket", func(t *testing.T) {
+		res, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Error("bad status:", res.StatusCode)
+		}
+	})
+}